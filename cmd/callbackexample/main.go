@@ -0,0 +1,96 @@
+// callbackexample Example of the segmenter embedded in other service: the chunks and chunklists are received in memory (callback outputs) instead of saved to files or uploaded
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go-ts-segmenter/manifestgenerator"
+	"go-ts-segmenter/manifestgenerator/hls"
+	"go-ts-segmenter/manifestgenerator/mediachunk"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	inputFile  = flag.String("i", "", "Input TS file (empty stdin)")
+	targetDurS = flag.Float64("targetDur", 4.0, "Target chunk duration in seconds")
+	windowSize = flag.Int("liveWindowSize", 3, "Live window size in chunks")
+	verbose    = flag.Bool("verbose", false, "enable to get verbose logging")
+)
+
+// store In memory store of the chunks (the ones being written and the completed ones) and the last chunklist
+type store struct {
+	chunks    map[string]*bytes.Buffer
+	completed []string
+	chunklist []byte
+}
+
+func main() {
+	flag.Parse()
+
+	log := logrus.New()
+	log.SetLevel(logrus.InfoLevel)
+	if *verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	input := io.Reader(os.Stdin)
+	if *inputFile != "" {
+		f, err := os.Open(*inputFile)
+		if err != nil {
+			log.Fatal("Error opening the input file, ", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	s := store{map[string]*bytes.Buffer{}, []string{}, nil}
+
+	// No uploaders, the paths are only the names passed to the callbacks
+	mg := manifestgenerator.New(log, mediachunk.ChunkOutputModeCallback, hls.HlsOutputModeCallback, "live", "chunk_", "chunklist.m3u8", 5, *targetDurS, manifestgenerator.ChunkInitStart, true, -1, -1, hls.LiveWindow, *windowSize, 0, nil, nil)
+	mg.SetCallbacks(manifestgenerator.Callbacks{
+		OnChunkData: func(info mediachunk.ChunkInfo, r io.Reader) {
+			if s.chunks[info.FileName] == nil {
+				s.chunks[info.FileName] = &bytes.Buffer{}
+			}
+			s.chunks[info.FileName].ReadFrom(r)
+		},
+		OnChunkClosed: func(info mediachunk.ChunkInfo) {
+			log.Info("Chunk ", info.FileName, " completed, index: ", info.Index, ", duration: ", fmt.Sprintf("%.3f", info.DurationS), "s, bytes: ", info.Bytes)
+
+			// Only the chunks of the window are kept
+			s.completed = append(s.completed, info.FileName)
+			for len(s.completed) > *windowSize {
+				delete(s.chunks, s.completed[0])
+				s.completed = s.completed[1:]
+			}
+		},
+		OnManifestUpdated: func(name string, body []byte) {
+			s.chunklist = body
+			log.Debug("Manifest ", name, " updated:\n", string(body))
+		},
+	})
+
+	reader := bufio.NewReader(input)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			mg.AddData(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading the input, ", err)
+		}
+	}
+	mg.Close()
+
+	fmt.Print(string(s.chunklist))
+}
@@ -1,8 +1,16 @@
 module go-ts-segmenter
 
-go 1.16
+go 1.24
 
 require (
 	github.com/aws/aws-sdk-go v1.38.55
+	github.com/datarhei/gosrt v0.9.0
 	github.com/sirupsen/logrus v1.8.1
 )
+
+require (
+	github.com/benburkert/openpgp v0.0.0-20160410205803-c2471f86866c // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,169 @@
+package fileinput
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tsStartByte Start byte for TS pakcets
+	tsStartByte uint8 = 0x47
+
+	// MaxPCRJumpS If the PCR jumps more than that (in seconds) we re-anchor the pacing clock
+	MaxPCRJumpS = 10.0
+)
+
+// FileInput File input class, it reads the file pacing the data based on the PCR
+type FileInput struct {
+	File *os.File
+
+	Log        *logrus.Logger
+	FileName   string
+	PaceFactor float64
+
+	reader   *bufio.Reader
+	tsPacket tspacket.TsPacket
+	pending  []byte
+
+	// Pacing data
+	pcrPID      int
+	lastPCRS    float64
+	streamTimeS float64
+	startTime   time.Time
+}
+
+// New Creates a file input instance, paceFactor = 1 reads at real time, 2 at double speed, 0 as fast as possible
+func New(log *logrus.Logger, fileName string, paceFactor float64) (FileInput, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	fi := FileInput{nil, log, fileName, paceFactor, nil, tspacket.New(tspacket.TsDefaultPacketSize), nil, -1, -1.0, 0, time.Time{}}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return fi, err
+	}
+	fi.File = f
+	fi.reader = bufio.NewReader(f)
+
+	return fi, nil
+}
+
+// Read Reads the file data, blocking until it is time to deliver it (implements io.Reader)
+func (fi *FileInput) Read(p []byte) (int, error) {
+	if len(fi.pending) <= 0 {
+		buf, err := fi.readPacket()
+		if len(buf) <= 0 {
+			return 0, err
+		}
+
+		if fi.PaceFactor > 0 && len(buf) == tspacket.TsDefaultPacketSize {
+			fi.pace(buf)
+		}
+		fi.pending = buf
+	}
+
+	n := copy(p, fi.pending)
+	fi.pending = fi.pending[n:]
+
+	return n, nil
+}
+
+// readPacket Reads the next TS packet, or the bytes until the next sync byte if we are not in sync
+func (fi *FileInput) readPacket() ([]byte, error) {
+	firstByte, err := fi.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if firstByte[0] != tsStartByte {
+		buf := make([]byte, 0, tspacket.TsDefaultPacketSize)
+		for len(buf) < tspacket.TsDefaultPacketSize {
+			b, err := fi.reader.ReadByte()
+			if err != nil {
+				return buf, err
+			}
+			buf = append(buf, b)
+
+			next, err := fi.reader.Peek(1)
+			if err != nil || next[0] == tsStartByte {
+				break
+			}
+		}
+		return buf, nil
+	}
+
+	buf := make([]byte, tspacket.TsDefaultPacketSize)
+	n, err := io.ReadFull(fi.reader, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return buf[:n], err
+}
+
+// pace Sleeps until the packet PCR time is reached
+func (fi *FileInput) pace(buf []byte) {
+	fi.tsPacket.Reset()
+	fi.tsPacket.AddData(buf)
+	if !fi.tsPacket.Parse(-1) {
+		return
+	}
+
+	pcrS := fi.tsPacket.GetPCRS()
+	if pcrS < 0 {
+		return
+	}
+
+	// Use only one PCR PID (in case of MPTS)
+	pID := fi.tsPacket.GetPID()
+	if fi.pcrPID < 0 {
+		fi.pcrPID = pID
+	} else if fi.pcrPID != pID {
+		return
+	}
+
+	now := time.Now()
+	if fi.lastPCRS < 0 {
+		fi.startTime = now
+		fi.streamTimeS = 0
+		fi.lastPCRS = pcrS
+		return
+	}
+
+	elapsedS := pcrS - fi.lastPCRS
+	if elapsedS < 0 {
+		// PCR rollover
+		elapsedS = elapsedS + tspacket.MaxPCRSValue
+	}
+	fi.lastPCRS = pcrS
+
+	if elapsedS > MaxPCRJumpS {
+		fi.Log.Info("PCR jump detected (", elapsedS, "s), re-anchoring file input pacing")
+		fi.startTime = now
+		fi.streamTimeS = 0
+		return
+	}
+
+	fi.streamTimeS = fi.streamTimeS + elapsedS
+	deliverAt := fi.startTime.Add(time.Duration(fi.streamTimeS / fi.PaceFactor * float64(time.Second)))
+	if deliverAt.After(now) {
+		time.Sleep(deliverAt.Sub(now))
+	}
+}
+
+// Close Closes the file
+func (fi *FileInput) Close() error {
+	if fi.File == nil {
+		return nil
+	}
+	return fi.File.Close()
+}
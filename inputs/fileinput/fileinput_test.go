@@ -0,0 +1,58 @@
+package fileinput
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestReadPaced(t *testing.T) {
+	testFilePath := "../../fixture/testSmall.ts"
+
+	bufExpected, err := ioutil.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatal("Error reading the local expected result file. Err: ", err)
+	}
+
+	// testSmall.ts is ~10s, at 20x it should take ~0.5s
+	fi, err := New(nil, testFilePath, 20)
+	if err != nil {
+		t.Fatal("Error opening file input. Err: ", err)
+	}
+	defer fi.Close()
+
+	start := time.Now()
+	buf, err := ioutil.ReadAll(&fi)
+	if err != nil {
+		t.Error("Error reading file input. Err: ", err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(buf, bufExpected) {
+		t.Errorf("Different data from original file, got: %d (bytes), want: %d (bytes).", len(buf), len(bufExpected))
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Pacing is not correct, got: %v, want: ~500ms.", elapsed)
+	}
+}
+
+func TestReadNoPacing(t *testing.T) {
+	testFilePath := "../../fixture/testSmall.ts"
+
+	fi, err := New(nil, testFilePath, 0)
+	if err != nil {
+		t.Fatal("Error opening file input. Err: ", err)
+	}
+	defer fi.Close()
+
+	start := time.Now()
+	_, err = ioutil.ReadAll(&fi)
+	if err != nil {
+		t.Error("Error reading file input. Err: ", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Read without pacing too slow, got: %v.", elapsed)
+	}
+}
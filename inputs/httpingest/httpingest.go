@@ -0,0 +1,151 @@
+package httpingest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// IngestTokenHeader Header that contains the shared secret
+	IngestTokenHeader = "X-Ingest-Token"
+)
+
+// HTTPIngest HTTP(S) server input class, it accepts one POST / PUT at a time and its body is the TS stream
+type HTTPIngest struct {
+	Listener net.Listener
+	Server   *http.Server
+
+	Log       *logrus.Logger
+	LocalAddr string
+	Token     string
+	CertFile  string
+	KeyFile   string
+
+	bodies chan *ingestBody
+	busy   chan bool
+	closed chan bool
+}
+
+// ingestBody Request body that notifies the handler when the reader is done with it
+type ingestBody struct {
+	io.ReadCloser
+	remoteAddr string
+	done       chan bool
+	closeOnce  sync.Once
+}
+
+// Close Notifies the request handler that the body was consumed
+func (b *ingestBody) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+// New Creates an HTTP ingest instance and starts listening, if certFile and keyFile are set it uses TLS
+func New(log *logrus.Logger, bindHost string, localPort int, token string, certFile string, keyFile string) (HTTPIngest, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	h := HTTPIngest{nil, nil, log, net.JoinHostPort(bindHost, strconv.Itoa(localPort)), token, certFile, keyFile, make(chan *ingestBody), make(chan bool, 1), make(chan bool)}
+
+	if (certFile == "") != (keyFile == "") {
+		return h, errors.New("both TLS cert and key are needed for HTTPS ingest")
+	}
+
+	ln, err := net.Listen("tcp", h.LocalAddr)
+	if err != nil {
+		return h, fmt.Errorf("listening HTTP ingest on %s: %w", h.LocalAddr, err)
+	}
+	h.Listener = ln
+	h.Server = &http.Server{Handler: h}
+
+	go func() {
+		var err error
+		if h.IsTLS() {
+			err = h.Server.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = h.Server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			h.Log.Error("Error serving HTTP ingest on ", h.LocalAddr, ". Err: ", err)
+		}
+	}()
+
+	return h, nil
+}
+
+// IsTLS Returns true if the ingest is served over HTTPS
+func (h HTTPIngest) IsTLS() bool {
+	return h.CertFile != "" && h.KeyFile != ""
+}
+
+// ServeHTTP Handles the ingest requests (implements http.Handler)
+func (h HTTPIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Only POST or PUT allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(IngestTokenHeader)), []byte(h.Token)) != 1 {
+		h.Log.Warn("Rejected HTTP ingest from ", r.RemoteAddr, ", invalid token")
+		http.Error(w, "Invalid ingest token", http.StatusUnauthorized)
+		return
+	}
+
+	select {
+	case h.busy <- true:
+	default:
+		h.Log.Warn("Rejected HTTP ingest from ", r.RemoteAddr, ", there is already an active ingest")
+		http.Error(w, "There is already an active ingest", http.StatusConflict)
+		return
+	}
+	defer func() { <-h.busy }()
+
+	body := &ingestBody{r.Body, r.RemoteAddr, make(chan bool), sync.Once{}}
+	select {
+	case h.bodies <- body:
+	case <-h.closed:
+		http.Error(w, "Ingest closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case <-body.done:
+	case <-r.Context().Done():
+		// Client gone, wait for the reader to release the body
+		<-body.done
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Next Blocks until a new ingest request is received, used as reconnectreader.OpenFunc
+func (h *HTTPIngest) Next() (io.ReadCloser, error) {
+	h.Log.Info("Waiting HTTP ingest request on ", h.Listener.Addr())
+
+	select {
+	case body := <-h.bodies:
+		h.Log.Info("HTTP ingest started from ", body.remoteAddr)
+		return body, nil
+	case <-h.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close Closes the HTTP server
+func (h *HTTPIngest) Close() error {
+	if h.Server == nil {
+		return nil
+	}
+	close(h.closed)
+	return h.Server.Close()
+}
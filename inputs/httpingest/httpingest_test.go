@@ -0,0 +1,101 @@
+package httpingest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIngestBody(t *testing.T) {
+	h, err := New(nil, "127.0.0.1", 0, "secret", "", "")
+	if err != nil {
+		t.Fatal("Error creating HTTP ingest. Err: ", err)
+	}
+	defer h.Close()
+
+	data := []byte("ABCDEFGHIJ")
+	statusCode := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+h.Listener.Addr().String()+"/ingest", bytes.NewReader(data))
+		req.Header.Set(IngestTokenHeader, "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error("Error sending ingest request. Err: ", err)
+			statusCode <- 0
+			return
+		}
+		resp.Body.Close()
+		statusCode <- resp.StatusCode
+	}()
+
+	rc, err := h.Next()
+	if err != nil {
+		t.Fatal("Error waiting ingest request. Err: ", err)
+	}
+	buf, _ := ioutil.ReadAll(rc)
+	rc.Close()
+
+	if !bytes.Equal(buf, data) {
+		t.Errorf("Different data received, got: %s, want: %s.", string(buf), string(data))
+	}
+	if code := <-statusCode; code != http.StatusOK {
+		t.Errorf("Ingest status code is not correct, got: %d, want: %d.", code, http.StatusOK)
+	}
+}
+
+func TestIngestRejected(t *testing.T) {
+	h, err := New(nil, "127.0.0.1", 0, "secret", "", "")
+	if err != nil {
+		t.Fatal("Error creating HTTP ingest. Err: ", err)
+	}
+	defer h.Close()
+
+	url := "http://" + h.Listener.Addr().String() + "/ingest"
+
+	// Invalid token
+	req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader([]byte("ABC")))
+	req.Header.Set(IngestTokenHeader, "wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error sending ingest request. Err: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Invalid token status code is not correct, got: %d, want: %d.", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Long lived ingest
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, url, pr)
+		req.Header.Set(IngestTokenHeader, "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	pw.Write([]byte("ABC"))
+
+	rc, err := h.Next()
+	if err != nil {
+		t.Fatal("Error waiting ingest request. Err: ", err)
+	}
+	defer rc.Close()
+
+	// Concurrent ingest
+	req, _ = http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte("DEF")))
+	req.Header.Set(IngestTokenHeader, "secret")
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal("Error sending concurrent ingest request. Err: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Concurrent ingest status code is not correct, got: %d, want: %d.", resp.StatusCode, http.StatusConflict)
+	}
+}
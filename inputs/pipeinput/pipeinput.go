@@ -0,0 +1,48 @@
+package pipeinput
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PipeInput Named pipe (FIFO) input class, it reopens the pipe every time the writer closes it
+type PipeInput struct {
+	Log      *logrus.Logger
+	PipePath string
+}
+
+// New Creates a named pipe input instance
+func New(log *logrus.Logger, pipePath string) (PipeInput, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	p := PipeInput{log, pipePath}
+
+	fi, err := os.Stat(pipePath)
+	if err != nil {
+		return p, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return p, errors.New(pipePath + " is not a named pipe (create it with mkfifo)")
+	}
+
+	return p, nil
+}
+
+// Next Opens the pipe, it blocks until a writer opens it, used as reconnectreader.OpenFunc
+func (p *PipeInput) Next() (io.ReadCloser, error) {
+	p.Log.Info("Waiting for a writer on pipe ", p.PipePath)
+
+	f, err := os.Open(p.PipePath)
+	if err != nil {
+		return nil, err
+	}
+	p.Log.Info("Pipe ", p.PipePath, " opened by a writer")
+
+	return f, nil
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+
+package pipeinput
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"go-ts-segmenter/inputs/reconnectreader"
+)
+
+func TestReopenPipe(t *testing.T) {
+	pipePath := path.Join(t.TempDir(), "feed.ts")
+	err := syscall.Mkfifo(pipePath, 0644)
+	if err != nil {
+		t.Fatal("Error creating named pipe. Err: ", err)
+	}
+
+	p, err := New(nil, pipePath)
+	if err != nil {
+		t.Fatal("Error creating pipe input. Err: ", err)
+	}
+
+	// Signals when the previous pipe is closed and the reader is waiting for the next writer
+	opening := make(chan bool, 1)
+	openNext := func() (io.ReadCloser, error) {
+		opening <- true
+		return p.Next()
+	}
+
+	// 2 writer restarts
+	go func() {
+		for _, data := range []string{"ABC", "DEF", "GHI"} {
+			<-opening
+			f, err := os.OpenFile(pipePath, os.O_WRONLY, 0)
+			if err != nil {
+				t.Error("Error opening pipe to write. Err: ", err)
+				return
+			}
+			f.Write([]byte(data))
+			f.Close()
+		}
+	}()
+
+	reconnections := 0
+	r := reconnectreader.New(nil, "pipe", openNext, 2, func() { reconnections++ })
+
+	buf, err := ioutil.ReadAll(&r)
+	if err != nil {
+		t.Error("Error reading pipe. Err: ", err)
+	}
+
+	if !bytes.Equal(buf, []byte("ABCDEFGHI")) {
+		t.Errorf("Different data read, got: %s, want: %s.", string(buf), "ABCDEFGHI")
+	}
+	if reconnections != 2 {
+		t.Errorf("Reopens is not correct, got: %d, want: %d.", reconnections, 2)
+	}
+}
+
+func TestRejectRegularFile(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "file.ts")
+	ioutil.WriteFile(filePath, nil, 0644)
+
+	_, err := New(nil, filePath)
+	if err == nil {
+		t.Error("Regular file accepted as named pipe")
+	}
+}
@@ -0,0 +1,89 @@
+package reconnectreader
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OpenFunc Opens the next input stream (Ex: accepts a new connection), it blocks until it is available
+type OpenFunc func() (io.ReadCloser, error)
+
+// ReconnectReader Reader that opens a new input stream every time the current one ends (implements io.Reader)
+type ReconnectReader struct {
+	Log         *logrus.Logger
+	Name        string
+	OpenNext    OpenFunc
+	OnReconnect func()
+
+	// Max number of reopens after the 1st open, < 0 means no limit
+	MaxReopens int
+
+	current     io.ReadCloser
+	openedTimes int
+}
+
+// New Creates a reconnect reader instance
+func New(log *logrus.Logger, name string, openNext OpenFunc, maxReopens int, onReconnect func()) ReconnectReader {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	return ReconnectReader{log, name, openNext, onReconnect, maxReopens, nil, 0}
+}
+
+// Read Reads from the current input stream, opening the next one when the current ends
+func (r *ReconnectReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.MaxReopens >= 0 && r.openedTimes > r.MaxReopens {
+				r.Log.Info("Input ", r.Name, " max reopens (", r.MaxReopens, ") reached")
+				return 0, io.EOF
+			}
+
+			rc, err := r.OpenNext()
+			if err != nil {
+				return 0, err
+			}
+			r.current = rc
+
+			if r.openedTimes > 0 {
+				r.Log.Info("Input ", r.Name, " reconnected (", r.openedTimes, ")")
+				if r.OnReconnect != nil {
+					r.OnReconnect()
+				}
+			}
+			r.openedTimes++
+		}
+
+		n, err := r.current.Read(p)
+		if err != nil {
+			r.Log.Info("Input ", r.Name, " closed. Err: ", err)
+			r.current.Close()
+			r.current = nil
+
+			if n <= 0 {
+				continue
+			}
+		}
+
+		return n, nil
+	}
+}
+
+// GetOpenedTimes Returns the number of input streams opened
+func (r *ReconnectReader) GetOpenedTimes() int {
+	return r.openedTimes
+}
+
+// Close Closes the current input stream
+func (r *ReconnectReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+
+	return err
+}
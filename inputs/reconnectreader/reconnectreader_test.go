@@ -0,0 +1,46 @@
+package reconnectreader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadSeveralInputs(t *testing.T) {
+	inputs := [][]byte{[]byte("ABCDE"), []byte("123456"), []byte("XYZ")}
+
+	opened := 0
+	openNext := func() (io.ReadCloser, error) {
+		if opened >= len(inputs) {
+			t.Error("Opened more inputs than expected")
+			return nil, io.EOF
+		}
+		rc := ioutil.NopCloser(bytes.NewReader(inputs[opened]))
+		opened++
+		return rc, nil
+	}
+
+	reconnections := 0
+	onReconnect := func() {
+		reconnections++
+	}
+
+	r := New(nil, "test", openNext, 2, onReconnect)
+
+	buf, err := ioutil.ReadAll(&r)
+	if err != nil {
+		t.Error("Error reading data. Err: ", err)
+	}
+
+	xpectedData := []byte("ABCDE123456XYZ")
+	if !bytes.Equal(buf, xpectedData) {
+		t.Errorf("Different data read, got: %s, want: %s.", string(buf), string(xpectedData))
+	}
+	if reconnections != 2 {
+		t.Errorf("Reconnections is not correct, got: %d, want: %d.", reconnections, 2)
+	}
+	if r.GetOpenedTimes() != 3 {
+		t.Errorf("Opened times is not correct, got: %d, want: %d.", r.GetOpenedTimes(), 3)
+	}
+}
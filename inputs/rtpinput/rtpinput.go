@@ -0,0 +1,269 @@
+package rtpinput
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// rtpHeaderSize Fixed RTP header size (RFC 3550)
+	rtpHeaderSize = 12
+
+	// rtpVersion Expected RTP version
+	rtpVersion = 2
+
+	// MP2TPayloadType RTP payload type for MPEG-TS (RFC 3551)
+	MP2TPayloadType = 33
+
+	// maxBufferedPackets Max packets in the jitter buffer, after that we consider the gap lost
+	maxBufferedPackets = 4096
+
+	// maxDatagramSize Max UDP payload we can receive
+	maxDatagramSize = 65536
+
+	// maxConsecutiveLatePackets Late packets in a row after which the sequence is considered restarted (Ex: encoder restart)
+	maxConsecutiveLatePackets = 8
+)
+
+type rtpPacket struct {
+	seq       uint16
+	payload   []byte
+	arrivedAt time.Time
+}
+
+// RTPInput RTP (over UDP) input class, it reorders the packets using the sequence number
+type RTPInput struct {
+	Conn *net.UDPConn
+
+	Log          *logrus.Logger
+	JitterBuffer time.Duration
+
+	// Jitter buffer
+	buffer      map[uint16]rtpPacket
+	nextSeq     uint16
+	highestSeq  uint16
+	initialized bool
+
+	// Late packets received in a row
+	consecutiveLate int
+
+	// Payloads ready to be read (in order)
+	ready   [][]byte
+	pending []byte
+
+	buf []byte
+
+	// Stats
+	ReceivedPackets  uint64
+	LostPackets      uint64
+	ReorderedPackets uint64
+	LatePackets      uint64
+	InvalidPackets   uint64
+	Resyncs          uint64
+}
+
+// New Creates a RTP input instance reading from the UDP socket
+func New(log *logrus.Logger, conn *net.UDPConn, jitterBufferMs int) RTPInput {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	return RTPInput{
+		conn,
+		log,
+		time.Duration(jitterBufferMs) * time.Millisecond,
+		make(map[uint16]rtpPacket),
+		0,
+		0,
+		false,
+		0,
+		nil,
+		nil,
+		make([]byte, maxDatagramSize),
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+	}
+}
+
+// Read Reads the TS data inside the RTP packets in sequence order (implements io.Reader)
+func (r *RTPInput) Read(p []byte) (int, error) {
+	for len(r.pending) <= 0 {
+		if len(r.ready) > 0 {
+			r.pending = r.ready[0]
+			r.ready = r.ready[1:]
+			continue
+		}
+
+		// If we are waiting for a missing packet do not wait more than the jitter buffer
+		if len(r.buffer) > 0 {
+			r.Conn.SetReadDeadline(time.Now().Add(r.JitterBuffer))
+		} else {
+			r.Conn.SetReadDeadline(time.Time{})
+		}
+
+		n, _, err := r.Conn.ReadFromUDP(r.buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				r.releasePackets(time.Now())
+				continue
+			}
+			return 0, err
+		}
+
+		r.addPacket(r.buf[:n], time.Now())
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+// parseRTP Returns the sequence number and the payload of a RTP packet
+func (r *RTPInput) parseRTP(datagram []byte) (seq uint16, payload []byte, err error) {
+	if len(datagram) < rtpHeaderSize {
+		err = errors.New("RTP packet too short")
+		return
+	}
+
+	version := datagram[0] >> 6
+	if version != rtpVersion {
+		err = errors.New("Wrong RTP version")
+		return
+	}
+	hasPadding := datagram[0]&0x20 > 0
+	hasExtension := datagram[0]&0x10 > 0
+	csrcCount := int(datagram[0] & 0x0F)
+	payloadType := datagram[1] & 0x7F
+
+	if payloadType != MP2TPayloadType {
+		r.Log.Debug("Unexpected RTP payload type ", payloadType)
+	}
+
+	seq = binary.BigEndian.Uint16(datagram[2:4])
+
+	headerSize := rtpHeaderSize + 4*csrcCount
+	if hasExtension {
+		if len(datagram) < headerSize+4 {
+			err = errors.New("RTP packet too short for the extension")
+			return
+		}
+		extensionLength := int(binary.BigEndian.Uint16(datagram[headerSize+2 : headerSize+4]))
+		headerSize = headerSize + 4 + 4*extensionLength
+	}
+
+	payloadEnd := len(datagram)
+	if hasPadding && payloadEnd > 0 {
+		payloadEnd = payloadEnd - int(datagram[payloadEnd-1])
+	}
+
+	if headerSize > payloadEnd {
+		err = errors.New("RTP header bigger than packet")
+		return
+	}
+
+	payload = datagram[headerSize:payloadEnd]
+
+	return
+}
+
+// addPacket Adds the packet to the jitter buffer, the late ones are dropped until maxConsecutiveLatePackets in a row restart the sequence from them
+func (r *RTPInput) addPacket(datagram []byte, now time.Time) {
+	seq, payload, err := r.parseRTP(datagram)
+	if err != nil {
+		r.InvalidPackets++
+		r.Log.Warn("Discarded invalid RTP packet. Err: ", err)
+		return
+	}
+	r.ReceivedPackets++
+
+	if !r.initialized {
+		r.nextSeq = seq
+		r.highestSeq = seq
+		r.initialized = true
+	}
+
+	// Modular distance (sequence number wraps at 16 bits)
+	if int16(seq-r.nextSeq) < 0 {
+		r.consecutiveLate++
+		if r.consecutiveLate < maxConsecutiveLatePackets {
+			r.LatePackets++
+			if r.consecutiveLate == 1 {
+				r.Log.Warn("Dropped RTP packet out of the jitter buffer, seq: ", seq, ", expected: ", r.nextSeq, ". Total late: ", r.LatePackets)
+			}
+			return
+		}
+		r.resync(seq, now)
+	}
+	r.consecutiveLate = 0
+	if _, exists := r.buffer[seq]; exists {
+		r.Log.Debug("Dropped duplicated RTP packet, seq: ", seq)
+		return
+	}
+
+	if int16(seq-r.highestSeq) < 0 {
+		r.ReorderedPackets++
+	} else {
+		r.highestSeq = seq
+	}
+
+	payloadCopy := make([]byte, len(payload))
+	copy(payloadCopy, payload)
+	r.buffer[seq] = rtpPacket{seq, payloadCopy, now}
+
+	r.releasePackets(now)
+}
+
+// resync Restarts the sequence from seq, the buffered packets of the previous sequence are released first
+func (r *RTPInput) resync(seq uint16, now time.Time) {
+	r.Resyncs++
+	r.Log.Warn("RTP sequence restarted, seq: ", seq, ", expected: ", r.nextSeq, ". Total resyncs: ", r.Resyncs)
+
+	// Every gap is considered waited for more than the jitter buffer
+	r.releasePackets(now.Add(r.JitterBuffer))
+	r.nextSeq = seq
+	r.highestSeq = seq
+}
+
+// releasePackets Moves the packets in order to the ready list, skipping the gaps that waited more than the jitter buffer
+func (r *RTPInput) releasePackets(now time.Time) {
+	for len(r.buffer) > 0 {
+		pckt, ok := r.buffer[r.nextSeq]
+		if ok {
+			r.ready = append(r.ready, pckt.payload)
+			delete(r.buffer, r.nextSeq)
+			r.nextSeq++
+			continue
+		}
+
+		// Gap, find the next packet we have
+		first := true
+		var lowest rtpPacket
+		for _, p := range r.buffer {
+			if first || int16(p.seq-lowest.seq) < 0 {
+				lowest = p
+				first = false
+			}
+		}
+
+		if now.Sub(lowest.arrivedAt) < r.JitterBuffer && len(r.buffer) < maxBufferedPackets {
+			// Still waiting for the missing packet
+			break
+		}
+
+		lost := uint64(lowest.seq - r.nextSeq)
+		r.LostPackets = r.LostPackets + lost
+		r.Log.Warn("Lost ", lost, " RTP packets, from seq: ", r.nextSeq, " to seq: ", lowest.seq-1, ". Total lost: ", r.LostPackets, ", total received: ", r.ReceivedPackets)
+		r.nextSeq = lowest.seq
+	}
+}
@@ -0,0 +1,145 @@
+package rtpinput
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func createRTPPacket(seq uint16, payload []byte) []byte {
+	h := make([]byte, rtpHeaderSize)
+	h[0] = rtpVersion << 6
+	h[1] = MP2TPayloadType
+	binary.BigEndian.PutUint16(h[2:4], seq)
+
+	return append(h, payload...)
+}
+
+func createInput(t *testing.T, jitterBufferMs int) (RTPInput, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		t.Fatal("Error opening UDP socket. Err: ", err)
+	}
+
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal("Error creating UDP sender. Err: ", err)
+	}
+
+	return New(nil, conn, jitterBufferMs), sender
+}
+
+func readBytes(t *testing.T, r *RTPInput, size int) []byte {
+	buf := make([]byte, 0, size)
+	readBuf := make([]byte, 2)
+	for len(buf) < size {
+		n, err := r.Read(readBuf)
+		if err != nil {
+			t.Fatal("Error reading RTP input. Err: ", err)
+		}
+		buf = append(buf, readBuf[:n]...)
+	}
+	return buf
+}
+
+func TestReorderAndLoss(t *testing.T) {
+	r, sender := createInput(t, 50)
+	defer r.Conn.Close()
+	defer sender.Close()
+
+	// Seq 65535 -> 3 (wraps), 2 is lost, 0 arrives out of order
+	sends := []struct {
+		seq     uint16
+		payload string
+	}{
+		{65535, "A"},
+		{1, "C"},
+		{0, "B"},
+		{3, "E"},
+	}
+	for _, s := range sends {
+		sender.Write(createRTPPacket(s.seq, []byte(s.payload)))
+	}
+
+	buf := readBytes(t, &r, 4)
+	if !bytes.Equal(buf, []byte("ABCE")) {
+		t.Errorf("Data is not in order, got: %s, want: %s.", string(buf), "ABCE")
+	}
+
+	// Late packet should be dropped
+	sender.Write(createRTPPacket(2, []byte("D")))
+	sender.Write(createRTPPacket(4, []byte("F")))
+
+	buf = readBytes(t, &r, 1)
+	if !bytes.Equal(buf, []byte("F")) {
+		t.Errorf("Late packet not dropped, got: %s, want: %s.", string(buf), "F")
+	}
+
+	if r.LostPackets != 1 {
+		t.Errorf("Lost packets is not correct, got: %d, want: %d.", r.LostPackets, 1)
+	}
+	if r.ReorderedPackets != 1 {
+		t.Errorf("Reordered packets is not correct, got: %d, want: %d.", r.ReorderedPackets, 1)
+	}
+	if r.LatePackets != 1 {
+		t.Errorf("Late packets is not correct, got: %d, want: %d.", r.LatePackets, 1)
+	}
+}
+
+func TestSequenceRestart(t *testing.T) {
+	r, sender := createInput(t, 50)
+	defer r.Conn.Close()
+	defer sender.Close()
+
+	sender.Write(createRTPPacket(30000, []byte("A")))
+	sender.Write(createRTPPacket(30001, []byte("B")))
+	buf := readBytes(t, &r, 2)
+	if !bytes.Equal(buf, []byte("AB")) {
+		t.Errorf("Data is not in order, got: %s, want: %s.", string(buf), "AB")
+	}
+
+	// Encoder restarted with a lower sequence number, the stream continues after the late packets in a row
+	payloads := "cdefghijklmn"
+	for i := 0; i < len(payloads); i++ {
+		sender.Write(createRTPPacket(uint16(100+i), []byte{payloads[i]}))
+	}
+
+	xpected := payloads[maxConsecutiveLatePackets-1:]
+	buf = readBytes(t, &r, len(xpected))
+	if !bytes.Equal(buf, []byte(xpected)) {
+		t.Errorf("Data after the restart is not correct, got: %s, want: %s.", string(buf), xpected)
+	}
+	if r.Resyncs != 1 {
+		t.Errorf("Resyncs is not correct, got: %d, want: %d.", r.Resyncs, 1)
+	}
+	if r.LatePackets != maxConsecutiveLatePackets-1 {
+		t.Errorf("Late packets is not correct, got: %d, want: %d.", r.LatePackets, maxConsecutiveLatePackets-1)
+	}
+	if r.LostPackets != 0 {
+		t.Errorf("Lost packets is not correct, got: %d, want: %d.", r.LostPackets, 0)
+	}
+}
+
+func TestHeaderExtensionAndPadding(t *testing.T) {
+	r, sender := createInput(t, 0)
+	defer r.Conn.Close()
+	defer sender.Close()
+
+	pckt := createRTPPacket(10, nil)
+	pckt[0] = pckt[0] | 0x20 | 0x10 | 0x01
+	// CSRC
+	pckt = append(pckt, 0, 0, 0, 1)
+	// Extension 1 word
+	pckt = append(pckt, 0xBE, 0xDE, 0, 1, 1, 2, 3, 4)
+	// Payload + 2 bytes padding
+	pckt = append(pckt, []byte("TS")...)
+	pckt = append(pckt, 0, 2)
+
+	sender.Write(pckt)
+
+	buf := readBytes(t, &r, 2)
+	if !bytes.Equal(buf, []byte("TS")) {
+		t.Errorf("Payload is not correct, got: %s, want: %s.", string(buf), "TS")
+	}
+}
@@ -0,0 +1,149 @@
+package srtinput
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/sirupsen/logrus"
+)
+
+// Modes SRT connection modes
+type Modes int
+
+const (
+	// SrtModeListener Waits for callers
+	SrtModeListener Modes = iota
+
+	// SrtModeCaller Connects to a remote listener
+	SrtModeCaller
+)
+
+const (
+	// callerRetryDelay Pause between caller connection intents
+	callerRetryDelay = 1 * time.Second
+)
+
+// SRTInput SRT input class
+type SRTInput struct {
+	Listener srt.Listener
+
+	Log        *logrus.Logger
+	Mode       Modes
+	Address    string
+	Passphrase string
+	LatencyMs  int
+	StreamID   string
+}
+
+// New Creates a SRT input instance, in listener mode it starts listening
+func New(log *logrus.Logger, mode Modes, address string, passphrase string, latencyMs int, streamID string) (SRTInput, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	s := SRTInput{nil, log, mode, address, passphrase, latencyMs, streamID}
+
+	if mode == SrtModeListener {
+		ln, err := srt.Listen("srt", address, s.getConfig())
+		if err != nil {
+			return s, err
+		}
+		s.Listener = ln
+	}
+
+	return s, nil
+}
+
+func (s *SRTInput) getConfig() srt.Config {
+	config := srt.DefaultConfig()
+	if s.LatencyMs >= 0 {
+		config.Latency = time.Duration(s.LatencyMs) * time.Millisecond
+	}
+	if s.Mode == SrtModeCaller {
+		config.Passphrase = s.Passphrase
+		config.StreamId = s.StreamID
+	}
+
+	return config
+}
+
+// Next Blocks until a new SRT connection is available (accepted or connected), used as reconnectreader.OpenFunc
+func (s *SRTInput) Next() (io.ReadCloser, error) {
+	if s.Mode == SrtModeCaller {
+		return s.connect()
+	}
+	return s.accept()
+}
+
+func (s *SRTInput) connect() (io.ReadCloser, error) {
+	for {
+		s.Log.Info("Connecting SRT to ", s.Address)
+		conn, err := srt.Dial("srt", s.Address, s.getConfig())
+		if err == nil {
+			s.Log.Info("SRT connected to ", s.Address)
+			return conn, nil
+		}
+
+		s.Log.Error("Error connecting SRT to ", s.Address, ". Err: ", err)
+		time.Sleep(callerRetryDelay)
+	}
+}
+
+func (s *SRTInput) accept() (io.ReadCloser, error) {
+	if s.Listener == nil {
+		return nil, errors.New("SRT listener not initialized")
+	}
+
+	for {
+		req, err := s.Listener.Accept2()
+		if err != nil {
+			if err == srt.ErrListenerClosed {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		if s.StreamID != "" && req.StreamId() != s.StreamID {
+			s.Log.Warn("Rejected SRT connection from ", req.RemoteAddr(), ", invalid streamid: ", req.StreamId())
+			req.Reject(srt.REJX_FORBIDDEN)
+			continue
+		}
+
+		if s.Passphrase != "" {
+			if !req.IsEncrypted() {
+				s.Log.Warn("Rejected SRT connection from ", req.RemoteAddr(), ", it is NOT encrypted")
+				req.Reject(srt.REJ_UNSECURE)
+				continue
+			}
+			errPass := req.SetPassphrase(s.Passphrase)
+			if errPass != nil {
+				s.Log.Warn("Rejected SRT connection from ", req.RemoteAddr(), ", wrong passphrase")
+				req.Reject(srt.REJ_BADSECRET)
+				continue
+			}
+		} else if req.IsEncrypted() {
+			s.Log.Warn("Rejected SRT connection from ", req.RemoteAddr(), ", it is encrypted but no passphrase configured")
+			req.Reject(srt.REJ_UNSECURE)
+			continue
+		}
+
+		conn, err := req.Accept()
+		if err != nil {
+			s.Log.Error("Error accepting SRT connection from ", req.RemoteAddr(), ". Err: ", err)
+			continue
+		}
+
+		s.Log.Info("SRT connection accepted from ", conn.RemoteAddr(), " (streamid: ", conn.StreamId(), ")")
+		return conn, nil
+	}
+}
+
+// Close Closes the listener
+func (s *SRTInput) Close() {
+	if s.Listener != nil {
+		s.Listener.Close()
+	}
+}
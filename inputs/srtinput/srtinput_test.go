@@ -0,0 +1,76 @@
+package srtinput
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+func callerConfig(passphrase string, streamID string) srt.Config {
+	config := srt.DefaultConfig()
+	config.Passphrase = passphrase
+	config.StreamId = streamID
+
+	return config
+}
+
+func TestAcceptValidCaller(t *testing.T) {
+	passphrase := "testpassphrase"
+	streamID := "live/test"
+	data := bytes.Repeat([]byte{0x47}, 1316)
+
+	s, err := New(nil, SrtModeListener, "127.0.0.1:0", passphrase, 120, streamID)
+	if err != nil {
+		t.Fatal("Error creating SRT listener. Err: ", err)
+	}
+	defer s.Close()
+
+	// Keep the caller open until the data is read
+	done := make(chan bool)
+	defer close(done)
+
+	go func() {
+		conn, err := srt.Dial("srt", s.Listener.Addr().String(), callerConfig(passphrase, streamID))
+		if err != nil {
+			t.Error("Error connecting to SRT listener. Err: ", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.Write(data)
+		<-done
+	}()
+
+	conn, err := s.Next()
+	if err != nil {
+		t.Fatal("Error accepting SRT connection. Err: ", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal("Error reading SRT data. Err: ", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("Different data received, got: %d (bytes), want: %d (bytes).", len(buf), len(data))
+	}
+}
+
+func TestRejectInvalidStreamID(t *testing.T) {
+	s, err := New(nil, SrtModeListener, "127.0.0.1:0", "", 120, "live/test")
+	if err != nil {
+		t.Fatal("Error creating SRT listener. Err: ", err)
+	}
+
+	go s.Next()
+
+	_, err = srt.Dial("srt", s.Listener.Addr().String(), callerConfig("", "live/other"))
+	if err == nil {
+		t.Error("SRT connection with invalid streamid accepted")
+	}
+
+	s.Close()
+}
@@ -0,0 +1,106 @@
+package stallwatchdog
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxCheckInterval Max interval between stall checks
+	maxCheckInterval = time.Second
+)
+
+// StallWatchdog Input reader wrapper that detects when no data is received for more than the stall timeout (implements io.Reader)
+type StallWatchdog struct {
+	// Last data received (unix nano), 0 means no data received yet. First to keep 64b alignment for atomic
+	lastDataUnixNano int64
+
+	Log          *logrus.Logger
+	Reader       io.Reader
+	StallTimeout time.Duration
+	OnStall      func(lastDataTime time.Time)
+
+	stalled  bool
+	stopChan chan bool
+}
+
+// New Creates a stall watchdog instance, onStall is called once per stall (after it the input has to receive data to be armed again)
+func New(log *logrus.Logger, r io.Reader, stallTimeoutS int, onStall func(lastDataTime time.Time)) StallWatchdog {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	return StallWatchdog{0, log, r, time.Duration(stallTimeoutS) * time.Second, onStall, false, make(chan bool)}
+}
+
+// Start Starts checking for stalls, it is not armed until the 1st data is received (Ex: initial TCP connection wait)
+func (w *StallWatchdog) Start() {
+	checkInterval := w.StallTimeout / 4
+	if checkInterval > maxCheckInterval {
+		checkInterval = maxCheckInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stopChan:
+				return
+			case now := <-ticker.C:
+				w.check(now)
+			}
+		}
+	}()
+}
+
+// check Calls OnStall if there was no data for more than StallTimeout
+func (w *StallWatchdog) check(now time.Time) {
+	lastData := w.GetLastDataTime()
+	if lastData.IsZero() {
+		return
+	}
+
+	if now.Sub(lastData) < w.StallTimeout {
+		w.stalled = false
+		return
+	}
+
+	if !w.stalled {
+		w.stalled = true
+		w.Log.Warn("Input stalled, no data received since ", lastData.Format(time.RFC3339Nano))
+		if w.OnStall != nil {
+			w.OnStall(lastData)
+		}
+	}
+}
+
+// Read Reads from the input reader, resetting the stall timer if there is data
+func (w *StallWatchdog) Read(p []byte) (int, error) {
+	n, err := w.Reader.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&w.lastDataUnixNano, time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+// GetLastDataTime Returns the time of the last data received (zero time if no data received yet)
+func (w *StallWatchdog) GetLastDataTime() time.Time {
+	lastDataUnixNano := atomic.LoadInt64(&w.lastDataUnixNano)
+	if lastDataUnixNano == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastDataUnixNano)
+}
+
+// Stop Stops checking for stalls
+func (w *StallWatchdog) Stop() {
+	close(w.stopChan)
+}
@@ -0,0 +1,44 @@
+package stallwatchdog
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStallAfterData(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	stalls := make(chan time.Time, 10)
+	w := New(nil, pr, 1, func(lastDataTime time.Time) { stalls <- lastDataTime })
+	w.Start()
+	defer w.Stop()
+
+	// Not armed before the 1st data
+	select {
+	case <-stalls:
+		t.Fatal("Stall detected before receiving data")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	go pw.Write([]byte("ABC"))
+	w.Read(make([]byte, 10))
+	dataTime := w.GetLastDataTime()
+
+	select {
+	case lastDataTime := <-stalls:
+		if !lastDataTime.Equal(dataTime) {
+			t.Errorf("Last data time is not correct, got: %v, want: %v.", lastDataTime, dataTime)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stall not detected")
+	}
+
+	// Only once per stall
+	select {
+	case <-stalls:
+		t.Error("Stall detected twice")
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
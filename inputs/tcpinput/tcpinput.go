@@ -0,0 +1,157 @@
+package tcpinput
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TLSHandshakeTimeoutS Max time for a client to complete the TLS handshake
+	TLSHandshakeTimeoutS = 10
+)
+
+// TCPInput TCP server input class, it accepts a new connection every time the previous one ends
+type TCPInput struct {
+	Listener  net.Listener
+	TLSConfig *tls.Config
+
+	Log              *logrus.Logger
+	LocalAddr        string
+	MaxIdleInputS    int
+	BindRetries      int
+	BindRetryDelayMs int
+}
+
+// idleTimeoutConn Connection that fails if no data is received for the idle timeout
+type idleTimeoutConn struct {
+	net.Conn
+	idleTimeout time.Duration
+}
+
+// Read Reads from the connection with idle timeout
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	if c.idleTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	}
+	return c.Conn.Read(p)
+}
+
+// New Creates a TCP input instance and starts listening, if bind fails it retries bindRetries times (delay doubles each intent). If tlsConfig is not nil connections are TLS
+func New(log *logrus.Logger, bindHost string, localPort int, maxIdleInputS int, bindRetries int, bindRetryDelayMs int, tlsConfig *tls.Config) (TCPInput, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	t := TCPInput{nil, tlsConfig, log, net.JoinHostPort(bindHost, strconv.Itoa(localPort)), maxIdleInputS, bindRetries, bindRetryDelayMs}
+
+	ln, err := t.listenRetries()
+	if err != nil {
+		return t, fmt.Errorf("listening TCP on %s (is the port already in use?): %w", t.LocalAddr, err)
+	}
+	t.Listener = ln
+
+	return t, nil
+}
+
+func (t *TCPInput) listenRetries() (net.Listener, error) {
+	retryDelay := time.Duration(t.BindRetryDelayMs) * time.Millisecond
+	retryIntent := 0
+
+	for {
+		ln, err := net.Listen("tcp", t.LocalAddr)
+		if err == nil || retryIntent >= t.BindRetries {
+			return ln, err
+		}
+
+		t.Log.Warn("Error listening TCP on ", t.LocalAddr, ", retrying in ", retryDelay, " (", retryIntent+1, "/", t.BindRetries, "). Err: ", err)
+		time.Sleep(retryDelay)
+
+		retryDelay = retryDelay * 2
+		retryIntent++
+	}
+}
+
+// NewTLSConfig Creates the TLS server config from PEM files, if clientCAFile is set clients must present a certificate signed by it (mTLS)
+func NewTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS cert %s and key %s: %w", certFile, keyFile, err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS client CA %s: %w", clientCAFile, err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no valid certificates found in TLS client CA " + clientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Next Blocks until a new TCP connection is accepted (and TLS handshake is done), used as reconnectreader.OpenFunc
+func (t *TCPInput) Next() (io.ReadCloser, error) {
+	for {
+		t.Log.Info("Waiting TCP connection on ", t.Listener.Addr())
+
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("accepting TCP connection on %s: %w", t.LocalAddr, err)
+		}
+		t.Log.Info("Connection TCP accepted from ", conn.RemoteAddr())
+
+		if t.TLSConfig != nil {
+			tlsConn, err := t.handshake(conn)
+			if err != nil {
+				// Keep accepting, a bad client should not stop the input
+				t.Log.Warn("Error in TLS handshake from ", conn.RemoteAddr(), ". Err: ", err)
+				conn.Close()
+				continue
+			}
+			conn = tlsConn
+		}
+
+		return &idleTimeoutConn{conn, time.Duration(t.MaxIdleInputS) * time.Second}, nil
+	}
+}
+
+// handshake Does the TLS server handshake with timeout
+func (t *TCPInput) handshake(conn net.Conn) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, t.TLSConfig)
+
+	conn.SetDeadline(time.Now().Add(TLSHandshakeTimeoutS * time.Second))
+	err := tlsConn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	t.Log.Info("TLS handshake done with ", conn.RemoteAddr())
+
+	return tlsConn, nil
+}
+
+// Close Closes the listener
+func (t *TCPInput) Close() error {
+	if t.Listener == nil {
+		return nil
+	}
+	return t.Listener.Close()
+}
@@ -0,0 +1,209 @@
+package tcpinput
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAcceptConsecutiveConnections(t *testing.T) {
+	ti, err := New(nil, "127.0.0.1", 0, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal("Error listening TCP. Err: ", err)
+	}
+	defer ti.Close()
+
+	for _, data := range [][]byte{[]byte("ABCDE"), []byte("123456")} {
+		go func(data []byte) {
+			conn, err := net.Dial("tcp", ti.Listener.Addr().String())
+			if err != nil {
+				t.Error("Error connecting TCP. Err: ", err)
+				return
+			}
+			conn.Write(data)
+			conn.Close()
+		}(data)
+
+		rc, err := ti.Next()
+		if err != nil {
+			t.Fatal("Error accepting TCP connection. Err: ", err)
+		}
+
+		buf := make([]byte, 0)
+		readBuf := make([]byte, 3)
+		for {
+			n, err := rc.Read(readBuf)
+			buf = append(buf, readBuf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		rc.Close()
+
+		if !bytes.Equal(buf, data) {
+			t.Errorf("Different data received, got: %s, want: %s.", string(buf), string(data))
+		}
+	}
+}
+
+func TestIdleConnectionDropped(t *testing.T) {
+	ti, err := New(nil, "127.0.0.1", 0, 1, 0, 0, nil)
+	if err != nil {
+		t.Fatal("Error listening TCP. Err: ", err)
+	}
+	defer ti.Close()
+
+	conn, err := net.Dial("tcp", ti.Listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error connecting TCP. Err: ", err)
+	}
+	defer conn.Close()
+
+	rc, err := ti.Next()
+	if err != nil {
+		t.Fatal("Error accepting TCP connection. Err: ", err)
+	}
+	defer rc.Close()
+
+	start := time.Now()
+	_, err = rc.Read(make([]byte, 10))
+	if err == nil {
+		t.Error("Idle connection did not time out")
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Idle connection timed out too early, got: %v, want: 1s.", elapsed)
+	}
+}
+
+func TestBindRetries(t *testing.T) {
+	// Keep the port busy for a while
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening TCP. Err: ", err)
+	}
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	_, err = New(nil, "127.0.0.1", port, 0, 0, 0, nil)
+	if err == nil {
+		t.Fatal("Listening on a busy port did not fail")
+	}
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		busy.Close()
+	}()
+
+	ti, err := New(nil, "127.0.0.1", port, 0, 5, 50, nil)
+	if err != nil {
+		t.Fatal("Error listening TCP with retries. Err: ", err)
+	}
+	ti.Close()
+}
+
+// writeTestCert Creates a self signed cert valid for server and client auth, returns the PEM file paths
+func writeTestCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating key. Err: ", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-ts-segmenter test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error creating cert. Err: ", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Error marshaling key. Err: ", err)
+	}
+
+	dir := t.TempDir()
+	certFile := path.Join(dir, "cert.pem")
+	keyFile := path.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestTLSKeepsAcceptingAfterHandshakeError(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	// mTLS, the client CA is the same self signed cert
+	tlsConfig, err := NewTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal("Error creating TLS config. Err: ", err)
+	}
+
+	ti, err := New(nil, "127.0.0.1", 0, 0, 0, 0, tlsConfig)
+	if err != nil {
+		t.Fatal("Error listening TCP. Err: ", err)
+	}
+	defer ti.Close()
+
+	certPEM, _ := os.ReadFile(certFile)
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(certPEM)
+	clientCert, _ := tls.LoadX509KeyPair(certFile, keyFile)
+
+	data := []byte("ABCDE")
+	go func() {
+		// Plain TCP client, handshake fails
+		conn, err := net.Dial("tcp", ti.Listener.Addr().String())
+		if err != nil {
+			t.Error("Error connecting TCP. Err: ", err)
+			return
+		}
+		conn.Write([]byte("not TLS"))
+		conn.Close()
+
+		// TLS client without cert, handshake fails
+		tlsConn, err := tls.Dial("tcp", ti.Listener.Addr().String(), &tls.Config{RootCAs: rootCAs})
+		if err == nil {
+			tlsConn.Write(data)
+			tlsConn.Close()
+		}
+
+		// Valid mTLS client
+		tlsConn, err = tls.Dial("tcp", ti.Listener.Addr().String(), &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{clientCert}})
+		if err != nil {
+			t.Error("Error connecting TLS. Err: ", err)
+			return
+		}
+		tlsConn.Write(data)
+		tlsConn.Close()
+	}()
+
+	rc, err := ti.Next()
+	if err != nil {
+		t.Fatal("Error accepting TLS connection. Err: ", err)
+	}
+	defer rc.Close()
+
+	buf, _ := ioutil.ReadAll(rc)
+	if !bytes.Equal(buf, data) {
+		t.Errorf("Different data received, got: %s, want: %s.", string(buf), string(data))
+	}
+}
@@ -0,0 +1,140 @@
+package udpinput
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxDatagramSize Max UDP payload we can receive
+	MaxDatagramSize = 65536
+
+	// tsPacketSize TS packet size, used to validate the datagrams
+	tsPacketSize = 188
+
+	// tsStartByte Start byte for TS pakcets
+	tsStartByte uint8 = 0x47
+)
+
+// UDPInput UDP (unicast or multicast) input class
+type UDPInput struct {
+	Conn *net.UDPConn
+
+	Log            *logrus.Logger
+	LocalAddr      string
+	MulticastGroup string
+	MulticastIface string
+	RcvBufferSize  int
+
+	// Datagram buffer, and pending data not read yet
+	buf     []byte
+	pending []byte
+
+	// Stats
+	ReceivedDatagrams  uint64
+	ReceivedBytes      uint64
+	MalformedDatagrams uint64
+}
+
+// New Creates a UDP input instance and binds the socket
+func New(log *logrus.Logger, bindHost string, localPort int, multicastGroup string, multicastIface string, rcvBufferSize int) (UDPInput, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	u := UDPInput{nil, log, net.JoinHostPort(bindHost, strconv.Itoa(localPort)), multicastGroup, multicastIface, rcvBufferSize, make([]byte, MaxDatagramSize), nil, 0, 0, 0}
+
+	var err error
+	if multicastGroup != "" {
+		u.Conn, err = u.listenMulticast(localPort)
+	} else {
+		var addr *net.UDPAddr
+		addr, err = net.ResolveUDPAddr("udp", u.LocalAddr)
+		if err != nil {
+			return u, err
+		}
+		u.Conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return u, err
+	}
+
+	if rcvBufferSize > 0 {
+		errBuf := u.Conn.SetReadBuffer(rcvBufferSize)
+		if errBuf != nil {
+			log.Warn("Error setting UDP receive buffer size to ", rcvBufferSize, ". Err: ", errBuf)
+		}
+	}
+
+	return u, nil
+}
+
+func (u *UDPInput) listenMulticast(localPort int) (*net.UDPConn, error) {
+	groupIP := net.ParseIP(u.MulticastGroup)
+	if groupIP == nil || !groupIP.IsMulticast() {
+		return nil, errors.New("Invalid multicast group " + u.MulticastGroup)
+	}
+
+	var iface *net.Interface = nil
+	if u.MulticastIface != "" {
+		var err error
+		iface, err = net.InterfaceByName(u.MulticastIface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u.Log.Info("Joining multicast group ", u.MulticastGroup, " on port ", localPort, " (iface: ", u.MulticastIface, ")")
+
+	return net.ListenMulticastUDP("udp", iface, &net.UDPAddr{IP: groupIP, Port: localPort})
+}
+
+// Read Reads TS data from the received datagrams (implements io.Reader)
+func (u *UDPInput) Read(p []byte) (int, error) {
+	for len(u.pending) <= 0 {
+		n, _, err := u.Conn.ReadFromUDP(u.buf)
+		if err != nil {
+			return 0, err
+		}
+
+		u.ReceivedDatagrams++
+		u.ReceivedBytes = u.ReceivedBytes + uint64(n)
+
+		u.pending = u.getTSPayload(u.buf[:n])
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+
+	return n, nil
+}
+
+// getTSPayload Returns the TS packets inside the datagram, skipping any header (Ex: 1328 = 12 + 7 * 188)
+func (u *UDPInput) getTSPayload(datagram []byte) []byte {
+	headerSize := len(datagram) % tsPacketSize
+	if headerSize == 0 {
+		return datagram
+	}
+
+	if len(datagram) > tsPacketSize && datagram[headerSize] == tsStartByte {
+		return datagram[headerSize:]
+	}
+
+	// Let the TS parser resync
+	u.MalformedDatagrams++
+	u.Log.Debug("Received UDP datagram not aligned to TS packets, size: ", len(datagram))
+
+	return datagram
+}
+
+// Close Closes the socket
+func (u *UDPInput) Close() error {
+	if u.Conn == nil {
+		return nil
+	}
+	return u.Conn.Close()
+}
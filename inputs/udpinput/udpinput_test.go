@@ -0,0 +1,69 @@
+package udpinput
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func createTSPackets(num int) []byte {
+	buf := make([]byte, 0, num*tsPacketSize)
+	for n := 0; n < num; n++ {
+		pckt := bytes.Repeat([]byte{byte(n)}, tsPacketSize)
+		pckt[0] = tsStartByte
+		buf = append(buf, pckt...)
+	}
+	return buf
+}
+
+func sendDatagrams(t *testing.T, u *UDPInput, datagrams [][]byte) {
+	conn, err := net.DialUDP("udp", nil, u.Conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal("Error creating UDP sender. Err: ", err)
+	}
+	defer conn.Close()
+
+	for _, d := range datagrams {
+		_, err := conn.Write(d)
+		if err != nil {
+			t.Fatal("Error sending datagram. Err: ", err)
+		}
+	}
+}
+
+func TestReadDatagrams(t *testing.T) {
+	u, err := New(nil, "127.0.0.1", 0, "", "", 0)
+	if err != nil {
+		t.Fatal("Error opening UDP input. Err: ", err)
+	}
+	defer u.Close()
+
+	tsData := createTSPackets(7)
+	rtpHeader := bytes.Repeat([]byte{0x80}, 12)
+
+	// 1316 bytes (7 TS packets) and 1328 bytes (12 bytes header + 7 TS packets)
+	sendDatagrams(t, &u, [][]byte{tsData, append(rtpHeader, tsData...)})
+
+	for i := 0; i < 2; i++ {
+		buf := make([]byte, 0, len(tsData))
+		readBuf := make([]byte, 128)
+		for len(buf) < len(tsData) {
+			n, err := u.Read(readBuf)
+			if err != nil {
+				t.Fatal("Error reading UDP input. Err: ", err)
+			}
+			buf = append(buf, readBuf[:n]...)
+		}
+
+		if !bytes.Equal(buf, tsData) {
+			t.Errorf("Different data from sent datagram %d, got: %d (bytes), want: %d (bytes).", i, len(buf), len(tsData))
+		}
+	}
+
+	if u.ReceivedDatagrams != 2 {
+		t.Errorf("Received datagrams is not correct, got: %d, want: %d.", u.ReceivedDatagrams, 2)
+	}
+	if u.MalformedDatagrams != 0 {
+		t.Errorf("Malformed datagrams is not correct, got: %d, want: %d.", u.MalformedDatagrams, 0)
+	}
+}
@@ -1,13 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"go-ts-segmenter/inputs/fileinput"
+	"go-ts-segmenter/inputs/httpingest"
+	"go-ts-segmenter/inputs/pipeinput"
+	"go-ts-segmenter/inputs/reconnectreader"
+	"go-ts-segmenter/inputs/rtpinput"
+	"go-ts-segmenter/inputs/srtinput"
+	"go-ts-segmenter/inputs/stallwatchdog"
+	"go-ts-segmenter/inputs/tcpinput"
+	"go-ts-segmenter/inputs/udpinput"
 	"go-ts-segmenter/manifestgenerator"
+	"go-ts-segmenter/manifestgenerator/chunkmeta"
+	"go-ts-segmenter/manifestgenerator/encryption"
 	"go-ts-segmenter/manifestgenerator/hls"
 	"go-ts-segmenter/manifestgenerator/mediachunk"
+	"go-ts-segmenter/manifestgenerator/tspacket"
+	"go-ts-segmenter/uploaders/httpserver"
 	"go-ts-segmenter/uploaders/httpuploader"
 	"go-ts-segmenter/uploaders/s3uploader"
 
@@ -17,36 +40,215 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
+// stallActions What to do when the input stalls
+type stallActions int
+
 const (
-	readBufferSize = 128
+	// stallActionLog Logs the event (and calls the webhook)
+	stallActionLog stallActions = iota
+
+	// stallActionExit Exits with exitCodeInputStall
+	stallActionExit
+
+	// stallActionBoth Logs the event (and calls the webhook) and exits
+	stallActionBoth
+)
+
+const (
+	// exitCodeInputStall Exit code when the input stalls
+	exitCodeInputStall = 3
 )
 
 var (
 	verbose                 = flag.Bool("verbose", false, "enable to get verbose logging")
 	baseOutPath             = flag.String("dstPath", "./results", "Output path")
 	chunkBaseFilename       = flag.String("chunksBaseFilename", "chunk_", "Chunks base filename")
+	chunkShardLayout        = flag.String("chunkShardLayout", "", "Saves the media chunks (with their parts and captions) and the I-frames in subdirectories of the wall clock (UTC) when they are created, in this Go time layout (Ex: 2006/01/02/15 hourly, empty disabled). It applies to the files, the HTTP upload paths and the S3 keys, and the chunklist URIs have the subdirectories (after segmentUrlPrefix if set). A chunk is never split between two directories. Not compatible with dashFilename or mediaDestinationType 5")
+	deleteOldChunks         = flag.Bool("deleteOldChunks", false, "Deletes the local media files (chunks with their flag files, parts, captions and I-frames) when they are out of the live window and deleteOldChunksMargin more. Only manifestType 2 and mediaDestinationType 1, the deletion errors are logged")
+	deleteOldChunksMargin   = flag.Int("deleteOldChunksMargin", 2, "Chunks out of the live window kept before deleting them (for the players still downloading them), only if deleteOldChunks = true")
+	stateFile               = flag.String("stateFile", "", "Local file where the state (chunk indexes, media and discontinuity sequences and chunks of the chunklist) is saved as JSON after every chunk, to resume after a restart (empty disabled). Every rendition has its own file in its subdirectory. Not compatible with mediaDestinationType 5")
+	resume                  = flag.Bool("resume", false, "Loads the stateFile saved by a previous run: the chunk numbering and the media sequence continue from it, the chunklist keeps its chunks and the 1st new chunk has an EXT-X-DISCONTINUITY and the key files continue their numbering. A missing or corrupt state file starts from scratch.")
+	epochNumbering          = flag.Bool("epochNumbering", false, "Starts the chunk index (filenames) and the media sequence from the wall clock (ms since the epoch / targetDur, the I-frames ones / 1s), so the names are unique and increasing across restarts without persisted state (if the chunks are not shorter than targetDur on average)")
+	chunkFilenameTemplate   = flag.String("chunkFilenameTemplate", "", "Filename template of the media chunks without the extension, with the tokens {seq}, {unixms}, {pdt:LAYOUT}, {ptsms} and {dur} (Ex: chan1_{pdt:20060102T150405}_{seq}). Empty chunksBaseFilename and the index")
+	chunkChecksum           = flag.Bool("chunkChecksum", false, "Computes the SHA-256 of every media chunk (the bytes published, after the init data and the encryption) and sends it in the Joc-Hls-Chunk-Sha256 header of the HTTP uploads and as metadata of the S3 ones (S3 also verifies the Content-MD5). Not compatible with mediaDestinationType 2 and 6")
+	chunkChecksumSidecar    = flag.Bool("chunkChecksumSidecar", false, "Saves the SHA-256 of every media chunk next to it (chunk filename + .sha256, sha256sum format), only if chunkChecksum = true and mediaDestinationType 1")
+	chunkMetadata           = flag.Bool("chunkMetadata", false, "Saves a JSON metadata file of every media chunk (same name, .json) to the same destination: sequence number, URI, start PTS, wall clock start, duration, bytes, keyframes, discontinuity, program date time and SCTE-35 splices (if set). Not compatible with mediaDestinationType 5")
+	chunkMetadataIndex      = flag.String("chunkMetadataIndexFilename", "index.json", "Filename of the JSON index with the metadata of the chunks in the live window (all of them in VOD / event), saved after every chunk (only if chunkMetadata = true, empty none)")
 	chunkListFilename       = flag.String("chunklistFilename", "chunklist.m3u8", "Chunklist filename")
 	fileNumberLength        = flag.Int("maxChunks", 5, "Number of chunks inside of .m3u8")
 	targetSegmentDurS       = flag.Float64("targetDur", 4.0, "Target chunk duration in seconds")
-	liveWindowSize          = flag.Int("liveWindowSize", 3, "Live window size in chunks")
+	liveWindowSize          = flag.Int("liveWindowSize", 3, "Live window size in chunks (completed ones, the LHLS advanced chunks are always after them)")
+	windowChunklists        = flag.String("windowChunklists", "", "Additional chunklists of the same chunks with their own window, comma separated filename:windowSize[:manifestDestinationType] (Ex: chunklist_dvr.m3u8:900), windowSize 0 all the chunks since the start (EVENT). They are saved every time a chunk is completed, the chunks out of liveWindowSize are retained in memory for the largest one (the segmenter never deletes chunks)")
+	partDurationS           = flag.Float64("partDur", 0, "If > 0 activates LL-HLS parts, every chunk is also written as parts (EXT-X-PART) of this duration in seconds (Ex: 0.5). Not compatible with lhls or mediaDestinationType 5")
+	serverControl           = flag.Bool("serverControl", false, "Writes EXT-X-SERVER-CONTROL with CAN-BLOCK-RELOAD=YES (the origin supports blocking playlist reloads), live manifests only")
+	holdBackS               = flag.Float64("holdBackS", 0, "HOLD-BACK of EXT-X-SERVER-CONTROL in seconds, min 3 target durations (0 computed, 3 target durations)")
+	partHoldBackS           = flag.Float64("partHoldBackS", 0, "PART-HOLD-BACK of EXT-X-SERVER-CONTROL in seconds, min 2 part durations (0 computed, 3 part durations)")
+	dashFilename            = flag.String("dashFilename", "", "MPEG-DASH manifest filename (Ex: manifest.mpd), if not empty a MPD (single period, SegmentTemplate with $Number$) of the same chunks is written with the chunklist. Not compatible with mediaDestinationType 5")
+	deltaChunklistFile      = flag.String("deltaChunklistFilename", "", "Delta chunklist filename (Ex: chunklist_delta.m3u8), if not empty it is saved with the chunklist and the chunks older than CAN-SKIP-UNTIL are replaced by EXT-X-SKIP (_HLS_skip=YES). Needs serverControl")
+	canSkipUntilS           = flag.Float64("canSkipUntilS", 0, "CAN-SKIP-UNTIL of EXT-X-SERVER-CONTROL in seconds when deltaChunklistFilename is set, min 6 target durations (0 computed, 6 target durations)")
 	lhlsAdvancedChunks      = flag.Int("lhls", 0, "If > 0 activates LHLS, and it indicates the number of advanced chunks to create")
+	finalizeAsVod           = flag.Bool("finalizeAsVod", false, "Converts the live event chunklist to VOD when the input ends (EXT-X-PLAYLIST-TYPE:VOD, EXT-X-ENDLIST and no live only tags), saved again to the same destination. It waits (shutdownTimeoutS) for the uploads in progress and it is not done if any chunk upload failed. Only manifestType 1")
+	liveEndlist             = flag.Int("liveEndlist", int(manifestgenerator.EndlistNone), "EXT-X-ENDLIST of the live chunklists when the input ends (0- Left open, 1- Added, 2- Added after liveEndlistGraceS without new data, a shutdown signal leaves it open)")
+	liveEndlistGraceS       = flag.Int("liveEndlistGraceS", 10, "Grace period in seconds before adding the EXT-X-ENDLIST (only liveEndlist 2)")
 	manifestTypeInt         = flag.Int("manifestType", int(hls.LiveWindow), "Manifest to generate (0- Vod, 1- Live event, 2- Live sliding window")
 	autoPID                 = flag.Bool("apids", true, "Enable auto PID detection, if true no need to pass vpid and apid")
-	videoPID                = flag.Int("vpid", -1, "Video PID to parse")
-	audioPID                = flag.Int("apid", -1, "Audio PID to parse")
+	videoPID                = flag.Int("vpid", -1, "Video PID to parse when apids = false (-1 if there is no video)")
+	audioPID                = flag.Int("apid", -1, "Audio PID to parse when apids = false (-1 if there is no audio)")
+	audioOnly               = flag.Bool("audioOnly", false, "Segment on audio PTS only (no video timing), auto enabled when the detected PMT has no video")
+	requireAudio            = flag.Bool("requireAudio", false, "Exits with error if the auto detected PMT has no audio (by default video only streams are segmented)")
+	preferredAudioCodec     = flag.Int("preferredAudioCodec", int(manifestgenerator.AudioCodecAAC), "Audio codec to select when apids = true and the PMT has several (0- AAC, 1- AC-3, 2- E-AC-3), if there is none of that codec the 1st found is used")
+	programNumber           = flag.Int("programNumber", -1, "Program number to segment in multi program TS (MPTS) when apids = true, the packets of other programs are ignored (-1 the 1st program of the PAT)")
+	pmtPID                  = flag.Int("pmtPid", -1, "PMT PID of the program to segment in multi program TS (MPTS) when apids = true (-1 any)")
+	scte35PID               = flag.Int("scte35Pid", -1, "SCTE-35 PID, its splices are signaled in the chunklist as EXT-X-DATERANGE (-1 auto detected from the PMT stream type 0x86, 0 disabled)")
+	scte35Cut               = flag.Bool("scte35Cut", false, "Starts a new chunk at the 1st keyframe at or after each SCTE-35 splice point, even if the target duration is not reached (splices received too late are attached to the current chunk)")
+	maxTimestampJumpS       = flag.Float64("maxTimestampJumpS", manifestgenerator.MaxTimestampJumpSDefault, "PTS jump (in seconds) that is considered a timestamp discontinuity even if the discontinuity_indicator is not set, it closes the chunk and signals EXT-X-DISCONTINUITY (0 only honors the indicator)")
+	durationSource          = flag.Int("durationSource", int(manifestgenerator.DurationSourceAuto), "Clock used to measure the chunk durations (0- Auto: PCR of the keyframe packet or PTS if there is only audio / video, the PCR PID is used when it is missing or not consistent, 1- PCR of the PCR PID declared in the PMT)")
+	maxDurationDriftS       = flag.Float64("maxDurationDriftS", manifestgenerator.MaxDurationDriftSDefault, "Difference in seconds between the chunk duration and the PCR PID elapsed time that makes durationSource = 0 use the PCR")
+	dataPIDs                = flag.String("dataPids", "", "Comma separated list of data PIDs (Ex: ID3 timed metadata) to write in the chunks (decimal or 0x hex), \"auto\" adds the timed metadata PIDs of the PMT (stream type 0x15). Their PES are never split between chunks (Ex: auto,0x104)")
+	filterPIDs              = flag.Bool("filterPids", false, "Rewrites the PAT and PMT written in the chunks so they only reference the selected program, video, audio, PCR and keepPids PIDs (only if apids = true), the byte reduction per chunk is logged")
+	keepPIDs                = flag.String("keepPids", "", "Comma separated list of extra PIDs to write in the chunks (decimal or 0x hex), by default only the video and audio PIDs (and the null packets, see stripNullPackets) are written (Ex: 0x102,0x103)")
+	stripNullPackets        = flag.Bool("stripNullPackets", false, "Drops the null packets (PID 0x1FFF, CBR padding) instead of passing them through to the chunks, the stripped ones are counted in the stats")
+	remapVideoPID           = flag.Int("remapVideoPid", -1, "PID to use for the video in the chunks, the PMT is rewritten to reference it (-1 keeps the source PID)")
+	remapAudioPID           = flag.Int("remapAudioPid", -1, "PID to use for the audio in the chunks, the PMT is rewritten to reference it (-1 keeps the source PID)")
+	generatePSI             = flag.Bool("generatePsi", false, "Generates the init data PAT (single program) and PMT (only the selected PIDs) instead of copying the source tables, it is also used in manual PID mode (vpid / apid stream types are h264 / AAC)")
+	psiIntervalMs           = flag.Int("psiIntervalMs", 0, "Repeats the PAT and PMT (the ones used as init data) inside the chunks, at the start of every chunk and every psiIntervalMs of PCR, for players that need PSI in the media chunks (0 disabled, Ex: 100). In manual PID mode it needs generatePsi = true")
+	remapPMTPID             = flag.Int("remapPmtPid", -1, "PID to use for the PMT in the chunks, the PAT is rewritten to reference it (-1 keeps the source PID)")
+	pdtEvery                = flag.Int("pdtEvery", 0, "Writes EXT-X-PROGRAM-DATE-TIME every N chunks (0 disabled, 1 every chunk, forced to 1 with SCTE-35 splices as their EXT-X-DATERANGE need it), and always in the 1st chunk and after a discontinuity. It is the wall clock time when the chunk received its 1st byte, carried forward by the chunk durations")
+	pdtSource               = flag.String("pdtSource", "clock", "Source of the EXT-X-PROGRAM-DATE-TIME (only if pdtEvery > 0), \"clock\": wall clock of the segmenter host, \"dvb\": UTC time of the DVB TDT / TOT tables (PID 0x14) mapped to the chunk start PCR, extrapolated if they disappear")
+	masterFilename          = flag.String("masterFilename", "", "Master (multivariant) playlist filename, written in the manifest destination (empty disabled). By default it references the chunklist (or one chunklist per localPorts rendition) with the master* attributes")
+	masterDescriptor        = flag.String("masterDescriptor", "", "JSON file with the variants (they replace the master* attributes) and alternate renditions (AUDIO, SUBTITLES) of the master playlist (Ex: {\"variants\":[{\"uri\":\"720p/chunklist.m3u8\",\"bandwidth\":3000000,\"averageBandwidth\":2500000,\"resolution\":\"1280x720\",\"frameRate\":29.97,\"codecs\":\"avc1.64001f,mp4a.40.2\",\"audio\":\"aac\"}],\"renditions\":[{\"type\":\"AUDIO\",\"groupId\":\"aac\",\"name\":\"English\",\"language\":\"en\",\"default\":true,\"uri\":\"en/chunklist.m3u8\"}]}), an array of variants is also accepted")
+	masterBandwidth         = flag.Int("masterBandwidth", 0, "Master playlist BANDWIDTH (bits per second) of the chunklist")
+	masterAvgBandwidth      = flag.Int("masterAverageBandwidth", 0, "Master playlist AVERAGE-BANDWIDTH (bits per second) of the chunklist (0 not written)")
+	masterResolution        = flag.String("masterResolution", "", "Master playlist RESOLUTION of the chunklist (Ex: 1280x720, empty not written)")
+	masterFrameRate         = flag.Float64("masterFrameRate", 0, "Master playlist FRAME-RATE of the chunklist (0 not written)")
+	masterCodecs            = flag.String("masterCodecs", "", "Master playlist CODECS of the chunklist (Ex: avc1.64001f,mp4a.40.2, empty not written)")
+	masterAudioGroup        = flag.String("masterAudioGroup", "", "Master playlist AUDIO group of the chunklist, its renditions are in masterDescriptor (empty not written)")
+	masterSubtitlesGroup    = flag.String("masterSubtitlesGroup", "", "Master playlist SUBTITLES group of the chunklist, its renditions are in masterDescriptor, with captions = true the captions chunklist is added to it (empty not written)")
+	masterBandwidthWindow   = flag.Int("masterBandwidthWindow", 0, "If > 0 the BANDWIDTH (peak chunk bitrate) and AVERAGE-BANDWIDTH of the master playlist are measured from the sizes of the last N chunks of each chunklist, instead of taken from the attributes")
+	captions                = flag.Bool("captions", false, "Extracts the CEA-608 captions (CC1) of the video SEI / user data (A/53 cc_data) and writes a WebVTT file per chunk (empty if there are no captions), listed in captionsChunklistFilename")
+	captionsChunklistFile   = flag.String("captionsChunklistFilename", "chunklist_captions.m3u8", "Captions (WebVTT) chunklist filename, it has the same target duration and media sequence than the chunklist (only if captions = true)")
+	hlsVersion              = flag.Int("hlsVersion", 0, "Pins the EXT-X-VERSION of the chunklists, startup fails if it does not support the features in use (0 the min version needed by them)")
+	extinfPrecision         = flag.Int("extinfPrecision", hls.DefaultExtinfPrecision, "Decimal places of the EXTINF durations (0 rounded integers, version 1+)")
+	startTimeOffsetS        = flag.String("startTimeOffsetS", "", "Writes EXT-X-START with this TIME-OFFSET in seconds (Ex: -12 joins 12s from the live edge), clamped to the chunklist duration (empty not written)")
+	startPrecise            = flag.Bool("startPrecise", false, "Adds PRECISE=YES to EXT-X-START (only if startTimeOffsetS is set)")
+	independentSegments     = flag.Bool("independentSegments", true, "Writes EXT-X-INDEPENDENT-SEGMENTS in the chunklist, it is removed (error logged) if a chunk does not start with a keyframe (Ex: maxSegmentDurAction 0 cut)")
+	iFrames                 = flag.Bool("iFrames", false, "Writes an I-frames only chunklist (trick play) in iFramesChunklistFilename, every keyframe gets a file with the PAT, PMT and its PES. With masterFilename it is added as EXT-X-I-FRAME-STREAM-INF (measured peak bandwidth)")
+	iFramesChunklistFile    = flag.String("iFramesChunklistFilename", "chunklist_iframes.m3u8", "I-frames only chunklist filename (only if iFrames = true)")
+	minSegmentDurS          = flag.Float64("minSegmentDurS", 0, "Min chunk duration in seconds, a keyframe before it does not cut the chunk, it continues until the next keyframe after the min (0 disabled)")
+	maxSegmentDurS          = flag.Float64("maxSegmentDurS", 0, "Max chunk duration in seconds, if it is reached without a keyframe the chunk is cut anyway at the next packet (0 disabled)")
+	maxSegmentDurAction     = flag.Int("maxSegmentDurAction", int(manifestgenerator.MaxSegmentDurCut), "What to do when maxSegmentDurS is reached (0- Cut without keyframe, 1- Cut and drop the data until the next keyframe)")
 	chunkInitType           = flag.Int("initType", int(manifestgenerator.ChunkInitStart), "Indicates where to put the init data PAT and PMT packets (0- No ini data, 1- Init segment, 2- At the beginning of each chunk")
-	mediaDestinationType    = flag.Int("mediaDestinationType", 1, "Indicates where the destination (0- No output, 1- File + flag indicator, 2- HTTP chunked transfer, 3- HTTP regular, 4- S3 regular)")
-	manifestDestinationType = flag.Int("manifestDestinationType", 1, "Indicates where the destination (0- No output, 1- File + flag indicator, 2- HTTP, 3- S3)")
+	chunkFormat             = flag.Int("chunkFormat", int(manifestgenerator.ChunkFormatTS), "Container of the chunks (0- TS, 1- CMAF fMP4 with an init segment in EXT-X-MAP, only H.264 video and AAC audio, the other PIDs are dropped). CMAF is not compatible with initType 1, lhls, partDur or iFrames")
+	encryptionMethod        = flag.Int("encryptionMethod", int(encryption.MethodNone), "Encryption of the chunks (0- None, 1- AES-128, whole chunks AES-128 CBC in EXT-X-KEY, 2- SAMPLE-AES, only the H.264 and AAC samples encrypted). AES-128 is not compatible with mediaDestinationType 5 or initType 1, none of them is compatible with CMAF, partDur or iFrames")
+	encryptionKeyFile       = flag.String("encryptionKeyFile", "", "File with the 16 bytes (binary) AES-128 key, if empty a random key is generated. The key is saved as key00000.key in the chunks destination (or encryptionKeyPath)")
+	encryptionKeyURI        = flag.String("encryptionKeyURI", "", "URI of the key in the EXT-X-KEY, "+manifestgenerator.KeyURINamePlaceholder+" is replaced by the key filename (Ex: https://keys.example.com/"+manifestgenerator.KeyURINamePlaceholder+"), if empty the key file relative to the chunklist")
+	encryptionKeyPath       = flag.String("encryptionKeyPath", "", "Local directory where the key file is saved, if empty it is saved in the chunks destination")
+	encryptionKeyFormat     = flag.String("encryptionKeyFormat", "", "KEYFORMAT of the EXT-X-KEY (Ex: identity), if empty it is not written")
+	encryptionKeyFormatVers = flag.String("encryptionKeyFormatVersions", "", "KEYFORMATVERSIONS of the EXT-X-KEY (Ex: 1), if empty it is not written")
+	encryptionKeyProvider   = flag.String("encryptionKeyProviderURL", "", "Key server URL (Ex: https://keys.example.com/keys), the keys and their EXT-X-KEY URIs are requested to it at startup and ahead of every rotation (in the background, the current key is kept until the next one is received) instead of generating them (they are not saved), the response is JSON {\"key\": \"<16 bytes in base64>\", \"uri\": \"...\"}. It is not compatible with encryptionKeyFile")
+	encryptionKeyProvMethod = flag.String("encryptionKeyProviderMethod", "GET", "HTTP method of the key server requests (GET, key index in the index query param, or POST, JSON body {\"index\": N})")
+	encryptionKeyProvToken  = flag.String("encryptionKeyProviderToken", "", "Auth token of the key server requests (Authorization: Bearer), if empty it is not sent")
+	encryptionKeyProvRetry  = flag.Int("encryptionKeyProviderRetries", 5, "Retries of the key server requests, if they fail at a rotation the current key is kept")
+	encryptionKeyProvDelay  = flag.Int("encryptionKeyProviderRetryDelay", 200, "Initial delay in MS between key server retries, doubles on each retry")
+	encryptionKeyRotation   = flag.Int("encryptionKeyRotation", 0, "Rotates the encryption key every these chunks, the new keys are random and saved as new key files (key00001.key, ...) with a new EXT-X-KEY at their 1st chunk (0 disabled)")
+	encryptionKeyRotationS  = flag.Float64("encryptionKeyRotationS", 0, "Rotates the encryption key after these seconds of media encrypted with it, at the start of the next chunk (0 disabled)")
+	encryptionIVMode        = flag.Int("encryptionIVMode", int(encryption.IVMediaSequence), "IV of every chunk (0- Media sequence number, not written in the EXT-X-KEY, 1- Random, written in the EXT-X-KEY)")
+	mediaDestinationType    = flag.String("mediaDestinationType", "1", "Indicates where the destination (0- No output, 1- File + flag indicator, 2- HTTP chunked transfer, 3- HTTP regular, 4- S3 regular, 5- Single file with byte ranges, 6- Built-in HTTP server, 8- Stdout). Comma separated for several destinations, the 1st one is the primary (Ex: 1,4)")
+	noFlagFiles             = flag.Bool("noFlagFiles", false, "The media files are written to hidden temporary files (.<name>.tmp) renamed when they are completed, instead of having the flag files (.growing_<name>) while they are written. Only mediaDestinationType 1, the LHLS advanced chunks and LL-HLS parts keep the flag files (they are read while they grow)")
+	inProgressSignal        = flag.String("inProgressSignal", "flag", "Signals of the media chunks being written to files, comma separated: flag (.growing_<name> flag file), part (<name>.part renamed when completed) and state (live_state.json) (Ex: part,state)")
+	verifyChunks            = flag.Bool("verifyChunks", false, "Re-reads every media chunk when it is closed and checks it (mediaDestinationType 1 or 5, TS chunks): whole 188 bytes packets with the sync byte, packets written, PAT / PMT and keyframe start, and PTS span against the EXTINF. The failures are logged as errors and counted in the stats, the AES-128 chunks are not checked")
+	verifyChunksToleranceS  = flag.Float64("verifyChunksToleranceS", 0.5, "Max difference in seconds between the PTS span and the EXTINF of a verified chunk, only if verifyChunks = true")
+	verifyChunksGap         = flag.Bool("verifyChunksGap", false, "The chunks that fail the verification are flagged as EXT-X-GAP, only if verifyChunks = true")
+	writeQueueBytes         = flag.Int("writeQueueBytes", 0, "If > 0 the media chunks (mediaDestinationType 1 or 5) are written to disk by other goroutine from a queue of this size in bytes, so the input processing only waits for the disk when the queue is full. The queue high-water mark and blocked time are in the stats (statsIntervalS)")
+	chunkedFlushBytes       = flag.Int("chunkedFlushBytes", 0, "If > 0 the data of the chunk being written is accumulated and sent to the destination (HTTP chunked transfer, built-in HTTP server, or file write + sync) when it reaches this size in bytes, or chunkedFlushMs. Both 0 every TS packet is sent when processed")
+	chunkedFlushMs          = flag.Int("chunkedFlushMs", 0, "If > 0 the data of the chunk being written is sent to the destination when this time in ms passed since the last flush, or chunkedFlushBytes")
+	byteRangeMaxFileBytes   = flag.Int64("byteRangeMaxFileBytes", 0, "If > 0 and mediaDestinationType = 5, a new file is started (with a discontinuity) when the current one reaches this size in bytes")
+	manifestPublishPolicy   = flag.Int("manifestPublishPolicy", int(hls.PublishOnChange), "When the chunklist is saved to the destination (0- On every change, 1- Coalesced, the changes that are not a completed chunk at most every manifestPublishIntervalMs, 2- Only when a chunk is completed)")
+	manifestPublishInterval = flag.Int("manifestPublishIntervalMs", 500, "Min time in ms between chunklist saves with manifestPublishPolicy 1, a completed chunk is always saved immediately")
+	segmentURLPrefix        = flag.String("segmentUrlPrefix", "", "Prefix of the chunks, init chunks, parts and keys URIs written in the chunklists (Ex: https://media.cdn.example/chan1/), the file names are URL escaped. The destination paths do not change (empty relative to the chunklist)")
+	masterURLPrefix         = flag.String("masterUrlPrefix", "", "Prefix of the variants, I-frames and renditions URIs written in the master playlist (Ex: https://cdn.example/live/chan1/), empty relative to the master playlist")
+	bitrateTag              = flag.Bool("bitrateTag", false, "Writes the EXT-X-BITRATE (kbps of the chunk size and duration) before every chunk of the chunklist, not in the byte range chunks. The LHLS advanced chunks get it when they are finalized")
+	customTagsFile          = flag.String("customTagsFile", "", "File with custom tags written after the header tags of the chunklist (Ex: #EXT-X-CUSTOM-ORIGIN-ID:chan1), a JSON array of strings or one tag per line. Every tag has to start with #")
+	manifestFsync           = flag.Bool("manifestFsync", false, "Fsyncs the manifest files (chunklists, master and MPD) before they replace the previous ones, they are always written to a temp file and renamed over the previous one (manifestDestinationType 1)")
+	fsync                   = flag.Bool("fsync", false, "Durability of the file outputs: fsyncs the manifests (as manifestFsync), and the media files every fsyncMediaEvery chunks (the init and key files always) before the chunklist references them, also their directories after the renames. The fsync latency is logged (verbose)")
+	fsyncMediaEvery         = flag.Int("fsyncMediaEvery", 1, "Media chunks fsynced every this number of chunks (1 all of them), only if fsync = true and mediaDestinationType 1 or 5")
+	manifestDestinationType = flag.String("manifestDestinationType", "1", "Indicates where the destination (0- No output, 1- File + flag indicator, 2- HTTP, 3- S3, 4- Built-in HTTP server). Comma separated for several destinations, the 1st one is the primary (Ex: 1,3)")
+	serverBindAddress       = flag.String("serverBindAddress", "", "Bind address of the built-in HTTP server (mediaDestinationType 6 / manifestDestinationType 4), empty all the interfaces. The URL paths are the dstPath paths (Ex: /results/chunklist.m3u8)")
+	serverPort              = flag.Int("serverPort", 8080, "Port of the built-in HTTP server")
+	serverTLSCert           = flag.String("serverTLSCert", "", "TLS certificate file (PEM) of the built-in HTTP server, if set with serverTLSKey it is served over HTTPS")
+	serverTLSKey            = flag.String("serverTLSKey", "", "TLS private key file (PEM) of the built-in HTTP server")
+	serverMaxSegments       = flag.Int("serverMaxSegments", httpserver.DefaultMaxSegments, "Number of chunks (LL-HLS parts included) retained in memory by the built-in HTTP server, the oldest ones are removed (0 all)")
+	serverBlockTimeoutS     = flag.Float64("serverBlockTimeoutS", 0, "Max time the built-in HTTP server holds a blocking playlist reload (_HLS_msn / _HLS_part) before responding 503, <= 0 3 target durations")
+	serverCORSOrigin        = flag.String("serverCORSOrigin", "", "Access-Control-Allow-Origin of the built-in HTTP server responses (Ex: *), empty no CORS headers")
+	serverCORSExposeHeaders = flag.String("serverCORSExposeHeaders", "Content-Length, Content-Range, Date", "Access-Control-Expose-Headers of the built-in HTTP server responses (only with serverCORSOrigin)")
 	httpScheme              = flag.String("protocol", "http", "HTTP Scheme (http, https)")
 	httpHost                = flag.String("host", "localhost:9094", "HTTP Host")
 	logPath                 = flag.String("logsPath", "", "Logs file path")
-	httpMaxRetries          = flag.Int("httpMaxRetries", 40, "Max retries for HTTP service unavailable")
-	initialHTTPRetryDelay   = flag.Int("initialHTTPRetryDelay", 5, "Initial retry delay in MS for chunk HTTP (no chunk transfer) uploads. Value = intent * initialHttpRetryDelay")
+	gapOnUploadFailure      = flag.Bool("gapOnUploadFailure", false, "Flags the chunks whose S3 / HTTP upload failed after its retries as EXT-X-GAP (they keep their EXTINF), also in the already saved chunklists. The gaps are counted in the stats")
+	httpMaxRetries          = flag.Int("httpMaxRetries", 40, "Max retries for HTTP service unavailable (httpRetryableStatuses and network errors), <= 0 only limited by httpMaxRetryTime (if it is set)")
+	httpRetryableStatuses   = flag.String("httpRetryableStatuses", "408,429,500,502,503,504", "Comma separated HTTP statuses of the uploads that are retried (the Retry-After header is honored), the other errors are permanent failures (also flagged by gapOnUploadFailure). The network errors are always retried")
+	initialHTTPRetryDelay   = flag.Int("initialHTTPRetryDelay", 5, "Initial retry delay in MS for chunk HTTP (no chunk transfer) uploads. Exponential backoff with full jitter, the delay is random between 0 and initialHttpRetryDelay * 2^intent")
+	maxHTTPRetryDelay       = flag.Int("maxHTTPRetryDelay", 2000, "Max retry delay in MS for HTTP uploads (cap of the exponential backoff), <= 0 no cap")
+	httpMaxRetryTime        = flag.Int("httpMaxRetryTime", 0, "Max time in MS retrying an HTTP upload (Ex: the live window duration, an older chunk is not worth retrying), <= 0 only limited by httpMaxRetries")
+	httpChunkTimeout        = flag.Int("httpChunkTimeout", 0, "Timeout in MS of each HTTP upload request of the chunks (also init, keys and metadata, not chunked transfer), a timeout is retried. <= 0 none")
+	httpManifestTimeout     = flag.Int("httpManifestTimeout", 0, "Timeout in MS of each HTTP upload request of the manifests, a timeout is retried. <= 0 none")
+	httpChunkedIdleTimeout  = flag.Int("httpChunkedIdleTimeout", 0, "Max time in MS of the chunked transfer uploads without writing data (also waiting the response after the last one), then the upload is cancelled and fails. <= 0 none")
+	httpMaxIdleConnsPerHost = flag.Int("httpMaxIdleConnsPerHost", 0, "Idle (keep-alive) connections kept to the HTTP upload host, it has to be the concurrent uploads (chunked transfer ones, manifests, secondaries) to reuse them, <= 0 httpConcurrency + 4. The verbose logs show if each upload reused a connection")
+	httpIdleConnTimeout     = flag.Int("httpIdleConnTimeout", int(httpuploader.DefaultTransportOptions.IdleConnTimeout/time.Millisecond), "Time in MS an idle HTTP upload connection is kept, <= 0 no limit")
+	httpDisableKeepAlives   = flag.Bool("httpDisableKeepAlives", false, "Opens a new connection for every HTTP upload request")
+	httpProtocol            = flag.String("httpProtocol", string(httpuploader.ProtocolAuto), "HTTP protocol of the uploads: auto (HTTP/2 if the https server offers it, HTTP/1.1 otherwise), http1, http2 (only https) or h2c (HTTP/2 without TLS with prior knowledge, only http). With HTTP/2 the uploads (also the chunked transfer ones) are multiplexed in one connection. The verbose logs show the protocol of each connection")
+	httpConcurrency         = flag.Int("httpConcurrency", 3, "HTTP uploads (not chunked transfer) done at the same time, the next chunk and the manifest do not wait for a slow chunk upload. A manifest is uploaded after the chunks it references. 1 one after the other (the segmenter waits for each upload)")
+	httpMethod              = flag.String("httpMethod", "POST", "HTTP method of the uploads (Ex: PUT)")
+	httpURLTemplate         = flag.String("httpURLTemplate", httpuploader.DefaultURLTemplate, "Path (and query) of the HTTP uploads, tokens: {path} destination path (Ex: 720p/chunk_00001.ts), {dir} its directory, {filename} its filename, {type} chunk, manifest, init, key or metadata and {seq} sequence number of the chunk (empty if none). The values are URL escaped (Ex: /ingest/channel1/{filename}, /upload?name={path}&type={type})")
+	httpHeadersFile         = flag.String("httpHeadersFile", "", "File with headers added to every HTTP upload request, one \"Name: value\" per line (# comments), before the httpHeader ones. ${NAME} in the values is replaced by the environment variable")
+	httpAuthBearer          = flag.String("httpAuthBearer", "", "Bearer token of the HTTP upload requests (${NAME} is replaced by the environment variable, Ex: ${INGEST_TOKEN}). With httpAuthTokenURL it is the initial one")
+	httpAuthBasic           = flag.String("httpAuthBasic", "", "Basic auth (user:password) of the HTTP upload requests if there is no bearer token (${NAME} is replaced by the environment variable)")
+	httpAuthTokenURL        = flag.String("httpAuthTokenURL", "", "Token URL (Ex: https://auth.example.com/oauth/token), when an HTTP upload gets 401 a new bearer token is requested (POST grant_type=client_credentials, one request at a time) and the upload is retried once with it. The chunked transfer uploads are not retried")
+	httpAuthTokenCreds      = flag.String("httpAuthTokenCredentials", "", "Client credentials (id:secret) sent as basic auth to httpAuthTokenURL (${NAME} is replaced by the environment variable)")
 	httpsInsecure           = flag.Bool("insecure", false, "Skips CA verification for HTTPS out")
-	inputType               = flag.Int("inputType", 1, "Where gets the input data (1-stdin, 2-TCP socket)")
-	localPort               = flag.Int("localPort", 2002, "Local port to listen in case inputType = 2")
+	httpsClientCert         = flag.String("httpsClientCert", "", "TLS client certificate file (PEM) of the HTTPS uploads (mTLS), with httpsClientKey")
+	httpsClientKey          = flag.String("httpsClientKey", "", "TLS client private key file (PEM) of the HTTPS uploads")
+	httpsCACert             = flag.String("httpsCACert", "", "CA certificate file (PEM) to verify the HTTPS upload server instead of the system CAs (not compatible with insecure)")
+	inputType               = flag.Int("inputType", 1, "Where gets the input data (1-stdin, 2-TCP socket, 3-UDP socket, 4-SRT, 5-RTP over UDP, 6-File, 7-Named pipe, 8-HTTP ingest)")
+	readBufferSize          = flag.Int("readBufferSize", 64*1024, "Input read buffer size in bytes, the data is sent to the segmenter aligned to 188 bytes TS packets")
+	resyncPackets           = flag.Int("resyncPackets", 3, "Number of consecutive TS sync bytes (at 188 bytes intervals) needed to consider the input in sync after losing it")
+	inputPacketSize         = flag.Int("inputPacketSize", 0, "Input TS packet size (188, or 204 for packets with Reed-Solomon bytes), 0 autodetects it")
+	maxResyncs              = flag.Int("maxResyncs", 0, "If > 0 exits with error when the input loses the TS sync more than this number of times")
+	localPorts              = flag.String("localPorts", "", "Comma separated local TCP ports to listen (Ex: 2002,2003,2004), each port generates its own chunklist in its own subdirectory of dstPath. Only for inputType = 2")
+	localPortsNames         = flag.String("localPortsNames", "", "Comma separated names (one per port in localPorts) used as subdirectory and log rendition label (Ex: 720p,480p,360p), default the port number")
+	localPort               = flag.Int("localPort", 2002, "Local port to listen in case inputType = 2, 3, 4, 5 or 8")
+	bindAddress             = flag.String("bindAddress", "", "Local address (IP or host) to listen for inputType = 2, 3, 4, 5 or 8 (default: all interfaces)")
+	bindRetries             = flag.Int("bindRetries", 0, "Number of retries if TCP listen fails (Ex: port still in use by a previous instance)")
+	bindRetryDelayMs        = flag.Int("bindRetryDelay", 500, "Initial delay in MS between TCP listen retries, doubles on each retry")
+	multicastGroup          = flag.String("multicastGroup", "", "Multicast group to join in case inputType = 3 or 5 (Ex: 239.1.1.1)")
+	multicastIface          = flag.String("multicastIface", "", "Network interface name used to join the multicast group (default: system choice)")
+	udpRcvBufferSize        = flag.Int("udpRcvBufferSize", 4*1024*1024, "UDP socket receive buffer size in bytes (0 uses the system default)")
+	inputFile               = flag.String("inputFile", "", "TS file to read in case inputType = 6")
+	paceFactor              = flag.Float64("paceFactor", 1.0, "Speed factor to read the inputFile based on the PCR (1- Real time, 2- Double speed, 0.5- Half speed, 0- As fast as possible)")
+	inputPipe               = flag.String("inputPipe", "", "Named pipe (FIFO) to read in case inputType = 7, it is reopened when the writer closes it")
+	ingestToken             = flag.String("ingestToken", "", "Shared secret that HTTP ingest requests must send in the X-Ingest-Token header (inputType = 8)")
+	ingestWaitReconnect     = flag.Bool("ingestWaitReconnect", false, "When the HTTP ingest body ends wait for a new ingest request instead of finalizing as EOF")
+	inputTLSCert            = flag.String("inputTLSCert", "", "TLS certificate file, if set (with inputTLSKey) the TCP input and HTTP ingest use TLS")
+	inputTLSKey             = flag.String("inputTLSKey", "", "TLS private key file for inputTLSCert")
+	inputTLSClientCA        = flag.String("inputTLSClientCA", "", "If set TLS TCP input clients must present a certificate signed by this CA file (mTLS)")
+	rtpJitterMs             = flag.Int("rtpJitterMs", 100, "RTP jitter buffer in MS used to reorder packets, after that missing packets are considered lost")
+	srtCallerAddress        = flag.String("srtCallerAddress", "", "If set SRT input works in caller mode connecting to this address (Ex: encoder.example.com:9000), if not it listens on localPort")
+	srtPassphrase           = flag.String("srtPassphrase", "", "SRT passphrase, if set only encrypted SRT connections are accepted")
+	srtLatencyMs            = flag.Int("srtLatency", 120, "SRT latency in MS")
+	srtStreamID             = flag.String("srtStreamId", "", "SRT streamid, in listener mode connections with a different streamid are rejected")
+	maxInputReopens         = flag.Int("maxInputReopens", -1, "Max number of input reopens (new connections or pipe writers) after the input closes, after that it is considered EOF (-1 no limit). Only for inputType = 2, 4, 7 or 8")
+	maxIdleInputS           = flag.Int("maxIdleInputS", 0, "If > 0 a TCP connection that does not send data for this time in seconds is dropped, and a new one is accepted")
+	reconnectDiscontinuity  = flag.Bool("reconnectDiscontinuity", false, "Insert EXT-X-DISCONTINUITY in the chunklist when the input reconnects (only inputs that reconnect)")
+	inputStallTimeoutS      = flag.Int("inputStallTimeoutS", 0, "If > 0 and no input data is received for this time in seconds the input is considered stalled (it is armed after the 1st data, the end of the input is not a stall)")
+	inputStallAction        = flag.Int("inputStallAction", int(stallActionLog), "What to do when the input stalls (0- Log event and call inputStallWebhook if set, 1- Exit with code 3, 2- Both)")
+	inputStallWebhook       = flag.String("inputStallWebhook", "", "URL to POST a JSON event when the input stalls (Ex: https://alerts.example.com/stall)")
+	shutdownTimeoutS        = flag.Int("shutdownTimeoutS", 10, "Max time in seconds to wait for the pending uploads when exiting")
+	statsIntervalS          = flag.Int("statsIntervalS", 10, "Interval in seconds to log the input stats (bitrate, packet rate, per PID bitrate, CC errors), 0 disables them")
+	maxCCErrorsPerMin       = flag.Int("maxCCErrorsPerMin", 0, "If > 0 a warning event is logged every minute with more continuity counter errors than this (Ex: packet loss in the contribution path)")
 	awsID                   = flag.String("awsId", "", "AWSId in case you do not want to use default machine credentials")
 	awsSecret               = flag.String("awsSecret", "", "AWSSecret in case you do not want to use default machine credentials")
 	awsRegion               = flag.String("s3Region", "", "Specific aws region to use for AWS S3 destination")
@@ -55,24 +257,185 @@ var (
 	s3IsPublicRead          = flag.Bool("s3IsPublicRead", false, "Set ACL = \"public-read\" for all S3 uploads")
 )
 
+// pdtSources Values of pdtSource
+var pdtSources = map[string]manifestgenerator.PDTSources{"clock": manifestgenerator.PDTSourceClock, "dvb": manifestgenerator.PDTSourceDVB}
+
+// httpMethodRegexp Valid values of httpMethod
+var httpMethodRegexp = regexp.MustCompile(`^[A-Z]+$`)
+
+// headerFlags Values of a repeatable flag, one per use
+type headerFlags []string
+
+// String Values of the flag (implements flag.Value)
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set Adds a value (implements flag.Value)
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// httpHeaders Values of httpHeader
+var httpHeaders headerFlags
+
+func init() {
+	flag.Var(&httpHeaders, "httpHeader", "Header added to every HTTP upload request (chunks and manifests, also chunked transfer), repeatable (Ex: -httpHeader \"X-Api-Key: ${API_KEY}\"). ${NAME} in the value is replaced by the environment variable, so the secrets are not in the process list. The Content-Type of each request is kept")
+}
+
+// mediaDestinationTypes and manifestDestinationTypes Destinations of mediaDestinationType and manifestDestinationType, the 1st one is the primary
+var mediaDestinationTypes, manifestDestinationTypes []int
+
 func main() {
 	flag.Parse()
 
-	var log = configureLogger(*verbose, *logPath)
+	var log = configureLogger(*verbose, *logPath, isStdoutOut())
 
 	log.Info(manifestgenerator.Version, logPath)
 	log.Info("Started tssegmenter", logPath)
 
-	if *autoPID == false && manifestgenerator.ChunkInitTypes(*chunkInitType) != manifestgenerator.ChunkNoIni {
-		log.Error("Manual PID mode and Chunk No ini data are not compatible")
+	mediaDestinationTypes = parseDestinationTypes(log, "mediaDestinationType", *mediaDestinationType, int(mediachunk.ChunkOutputModeStdout))
+	manifestDestinationTypes = parseDestinationTypes(log, "manifestDestinationType", *manifestDestinationType, int(hls.HlsOutputModeHTTPServer))
+	if hasDestination(mediaDestinationTypes, int(mediachunk.ChunkOutputModeCallback)) {
+		log.Error("mediaDestinationType 7 (callbacks) is only for the segmenter embedded in other program")
+		os.Exit(1)
+	}
+	if isStdoutOut() {
+		if *inputType == 1 {
+			log.Error("Stdout output (mediaDestinationType 8) is not compatible with stdin input (inputType 1)")
+			os.Exit(1)
+		}
+		if *inputType == 2 && *localPorts != "" {
+			log.Error("Stdout output (mediaDestinationType 8) is not compatible with several renditions (localPorts), their streams would be mixed")
+			os.Exit(1)
+		}
+
+		// A closed reader (Ex: the recording process ended) is a write error instead of killing the segmenter
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
+	if *autoPID == false {
+		if manifestgenerator.ChunkInitTypes(*chunkInitType) != manifestgenerator.ChunkNoIni && !*generatePSI {
+			log.Error("Manual PID mode is only compatible with Chunk No ini data (initType 0), or with generated PSI (generatePsi = true)")
+			os.Exit(1)
+		}
+
+		err := manifestgenerator.ValidateManualPIDs(*videoPID, *audioPID)
+		if err != nil {
+			log.Error("Invalid manual PIDs (vpid ", *videoPID, ", apid ", *audioPID, "). Err: ", err)
+			os.Exit(1)
+		}
+
+		if *programNumber >= 0 || *pmtPID >= 0 {
+			log.Error("Program selection (programNumber, pmtPid) is only compatible with auto PID detection (apids = true)")
+			os.Exit(1)
+		}
+	}
+
+	if *inputPacketSize != 0 && *inputPacketSize != tspacket.TsDefaultPacketSize && *inputPacketSize != tspacket.TsRSPacketSize {
+		log.Error("Invalid inputPacketSize ", *inputPacketSize, ", valid values: 0 (autodetect), 188 or 204")
+		os.Exit(1)
+	}
+
+	if *partDurationS > 0 {
+		if *lhlsAdvancedChunks > 0 || mediachunk.OutputTypes(mediaDestinationTypes[0]) == mediachunk.ChunkOutputModeFileByteRange {
+			log.Error("LL-HLS parts (partDur) are not compatible with LHLS (lhls) or byte range output (mediaDestinationType 5)")
+			os.Exit(1)
+		}
+		if *partDurationS > *targetSegmentDurS {
+			log.Error("Invalid partDur ", *partDurationS, ", it can not be longer than the target duration (targetDur ", *targetSegmentDurS, ")")
+			os.Exit(1)
+		}
+	}
+
+	if mediachunk.OutputTypes(mediaDestinationTypes[0]) == mediachunk.ChunkOutputModeFileByteRange {
+		if hls.ManifestTypes(*manifestTypeInt) == hls.LiveWindow || *lhlsAdvancedChunks > 0 {
+			log.Error("Byte range output (mediaDestinationType 5) is only compatible with Vod or Live event manifests (manifestType 0 or 1), and without LHLS")
+			os.Exit(1)
+		}
+		if *dashFilename != "" {
+			log.Error("The MPEG-DASH manifest (dashFilename) is not compatible with byte range output (mediaDestinationType 5)")
+			os.Exit(1)
+		}
+	}
+
+	if *liveEndlist < int(manifestgenerator.EndlistNone) || *liveEndlist > int(manifestgenerator.EndlistAfterGrace) {
+		log.Error("Invalid liveEndlist ", *liveEndlist)
+		os.Exit(1)
+	}
+
+	if *finalizeAsVod && hls.ManifestTypes(*manifestTypeInt) != hls.LiveEvent {
+		log.Error("finalizeAsVod is only compatible with Live event manifests (manifestType 1)")
+		os.Exit(1)
+	}
+
+	if *serverControl {
+		if hls.ManifestTypes(*manifestTypeInt) == hls.Vod {
+			log.Error("EXT-X-SERVER-CONTROL (serverControl) is only used in live manifests (manifestType 1 or 2)")
+			os.Exit(1)
+		}
+		if err := manifestgenerator.ValidateServerControl(*holdBackS, *partHoldBackS, *canSkipUntilS, *targetSegmentDurS, *partDurationS); err != nil {
+			log.Error("Invalid holdBackS ", *holdBackS, " / partHoldBackS ", *partHoldBackS, " / canSkipUntilS ", *canSkipUntilS, ". Err: ", err)
+			os.Exit(1)
+		}
+	} else if *deltaChunklistFile != "" {
+		log.Error("The delta chunklist (deltaChunklistFilename) needs EXT-X-SERVER-CONTROL (serverControl)")
+		os.Exit(1)
+	}
+
+	if *manifestPublishPolicy < int(hls.PublishOnChange) || *manifestPublishPolicy > int(hls.PublishOnChunk) {
+		log.Error("Invalid manifestPublishPolicy ", *manifestPublishPolicy)
 		os.Exit(1)
 	}
 
-	chunkOutputType := mediachunk.OutputTypes(*mediaDestinationType)
-	hlsOutputType := hls.OutputTypes(*manifestDestinationType)
+	if err := manifestgenerator.ValidateChunkFormat(manifestgenerator.ChunkFormats(*chunkFormat), manifestgenerator.ChunkInitTypes(*chunkInitType), *lhlsAdvancedChunks, *partDurationS, *iFrames); err != nil {
+		log.Error("Invalid chunkFormat ", *chunkFormat, ". Err: ", err)
+		os.Exit(1)
+	}
+
+	if err := manifestgenerator.ValidateEncryption(encryption.Methods(*encryptionMethod), mediachunk.OutputTypes(mediaDestinationTypes[0]), manifestgenerator.ChunkInitTypes(*chunkInitType), manifestgenerator.ChunkFormats(*chunkFormat), *partDurationS, *iFrames); err != nil {
+		log.Error("Invalid encryptionMethod ", *encryptionMethod, ". Err: ", err)
+		os.Exit(1)
+	}
+	if *encryptionIVMode != int(encryption.IVMediaSequence) && *encryptionIVMode != int(encryption.IVRandom) {
+		log.Error("Invalid encryptionIVMode ", *encryptionIVMode)
+		os.Exit(1)
+	}
+	encryptionKey := readEncryptionKey(log)
+	if *encryptionKeyProvider != "" && *encryptionKeyFile != "" {
+		log.Error("encryptionKeyProviderURL is not compatible with encryptionKeyFile")
+		os.Exit(1)
+	}
+
+	if err := manifestgenerator.ValidateHlsVersion(*hlsVersion, *extinfPrecision, mediachunk.OutputTypes(mediaDestinationTypes[0]), manifestgenerator.ChunkInitTypes(*chunkInitType)); err != nil {
+		log.Error("Invalid hlsVersion ", *hlsVersion, " / extinfPrecision ", *extinfPrecision, ". Err: ", err)
+		os.Exit(1)
+	}
+
+	if *startTimeOffsetS != "" {
+		if _, err := strconv.ParseFloat(*startTimeOffsetS, 64); err != nil {
+			log.Error("Invalid startTimeOffsetS ", *startTimeOffsetS, ". Err: ", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, found := pdtSources[*pdtSource]; !found {
+		log.Error("Invalid pdtSource ", *pdtSource, ", valid values: clock or dvb")
+		os.Exit(1)
+	}
+
+	keepPIDsList, _ := parsePIDs(log, "keepPids", *keepPIDs, false, true)
+	dataPIDsList, _ := parsePIDs(log, "dataPids", *dataPIDs, true, false)
+	if err := manifestgenerator.ValidateRemapPIDs(*remapVideoPID, *remapAudioPID, *remapPMTPID, append(keepPIDsList, dataPIDsList...)); err != nil {
+		log.Error("Invalid PID remapping (remapVideoPid ", *remapVideoPID, ", remapAudioPid ", *remapAudioPID, ", remapPmtPid ", *remapPMTPID, "). Err: ", err)
+		os.Exit(1)
+	}
+
+	hlsOutputType := hls.OutputTypes(manifestDestinationTypes[0])
 
 	// Creating output dir if does not exists
-	if chunkOutputType == mediachunk.ChunkOutputModeFile || hlsOutputType == hls.HlsOutputModeFile {
+	if isFileOut() {
 		os.MkdirAll(*baseOutPath, 0744)
 	}
 
@@ -80,8 +443,44 @@ func main() {
 	var s3Uploader *s3uploader.S3Uploader = nil
 	if isHTTPOut() {
 		httpUploaderTmp := httpuploader.New(log, *httpsInsecure, *httpScheme, *httpHost, *httpMaxRetries, *initialHTTPRetryDelay)
+		httpUploaderTmp.Headers = readHTTPHeaders(log)
+		httpUploaderTmp.Auth = newHTTPAuth(log)
+		httpUploaderTmp.MaxHTTPRetryDelayMs = *maxHTTPRetryDelay
+		httpUploaderTmp.MaxHTTPRetryTimeMs = *httpMaxRetryTime
+		httpUploaderTmp.ChunkTimeout = time.Duration(*httpChunkTimeout) * time.Millisecond
+		httpUploaderTmp.ManifestTimeout = time.Duration(*httpManifestTimeout) * time.Millisecond
+		httpUploaderTmp.ChunkedIdleTimeout = time.Duration(*httpChunkedIdleTimeout) * time.Millisecond
+		httpUploaderTmp.RetryableStatuses = parseHTTPStatuses(log, "httpRetryableStatuses", *httpRetryableStatuses)
+		if !httpMethodRegexp.MatchString(*httpMethod) {
+			log.Fatal("Error parsing httpMethod, invalid HTTP method ", *httpMethod)
+		}
+		httpUploaderTmp.Method = *httpMethod
+		if *httpURLTemplate != httpuploader.DefaultURLTemplate {
+			urlTemplate, err := httpuploader.ParseURLTemplate(*httpURLTemplate)
+			if err != nil {
+				log.Fatal("Error parsing httpURLTemplate, ", err)
+			}
+			httpUploaderTmp.URLTemplate = urlTemplate
+		}
+		if *httpsClientCert != "" || *httpsClientKey != "" || *httpsCACert != "" {
+			tlsConfig, err := httpuploader.NewTLSConfig(*httpsClientCert, *httpsClientKey, *httpsCACert, *httpsInsecure)
+			if err != nil {
+				log.Fatal("Error creating HTTPS upload TLS config, ", err)
+			}
+			httpUploaderTmp.SetTLSConfig(tlsConfig)
+		}
+		if err := httpuploader.ValidateProtocol(httpuploader.Protocols(*httpProtocol), *httpScheme); err != nil {
+			log.Fatal("Error parsing httpProtocol, ", err)
+		}
+		maxIdleConnsPerHost := *httpMaxIdleConnsPerHost
+		if maxIdleConnsPerHost <= 0 {
+			maxIdleConnsPerHost = httpuploader.IdleConnsPerHost(*httpConcurrency)
+		}
+		httpUploaderTmp.SetTransportOptions(httpuploader.TransportOptions{DisableKeepAlives: *httpDisableKeepAlives, MaxIdleConnsPerHost: maxIdleConnsPerHost, IdleConnTimeout: time.Duration(*httpIdleConnTimeout) * time.Millisecond, Protocol: httpuploader.Protocols(*httpProtocol)})
+		httpUploaderTmp.SetConcurrency(*httpConcurrency)
 		httpUploader = &httpUploaderTmp
-	} else if isS3Out() {
+	}
+	if isS3Out() {
 		awsCreds := s3uploader.AWSLocalCreds{}
 		if (*awsID != "") && (*awsSecret != "") {
 			awsCreds.Valid = true
@@ -92,87 +491,1056 @@ func main() {
 		s3Uploader = &s3UploaderTmp
 	}
 
+	var httpServer *httpserver.HTTPServer = nil
+	if isServerOut() {
+		httpServerTmp, err := httpserver.New(log, *serverBindAddress, *serverPort, *serverTLSCert, *serverTLSKey, *serverMaxSegments)
+		if err != nil {
+			log.Error("Error creating the HTTP server. Err: ", err)
+			os.Exit(1)
+		}
+		httpServer = &httpServerTmp
+
+		blockTimeoutS := *serverBlockTimeoutS
+		if blockTimeoutS <= 0 {
+			blockTimeoutS = 3 * *targetSegmentDurS
+		}
+		httpServer.SetBlockingReload(time.Duration(blockTimeoutS * float64(time.Second)))
+		httpServer.SetCORS(*serverCORSOrigin, *serverCORSExposeHeaders)
+		log.Info("HTTP server listening on " + httpServer.LocalAddr + " (TLS: " + strconv.FormatBool(httpServer.IsTLS()) + ")")
+	}
+
+	master := newMasterPlaylist(log, hlsOutputType, httpUploader, s3Uploader, httpServer)
+
+	stop := handleShutdownSignals(log)
+
+	if *inputType == 2 && *localPorts != "" {
+		// One TCP input and manifest generator per port (Ex: ABR ladder)
+		mgs := runMultiPortTCP(log, httpUploader, s3Uploader, httpServer, master, encryptionKey, stop)
+		waitPendingUploads(log, httpUploader)
+		for _, mg := range mgs {
+			// The chunked transfer uploads that failed after the last chunk
+			mg.MarkUploadGaps()
+		}
+		waitEndlists(mgs, stop)
+		waitServerShutdown(log, httpServer, stop)
+
+		log.Info("Exit because detected EOF in all the input readers (or shutdown signal)")
+		os.Exit(0)
+	}
+
+	mg := newManifestGenerator(log, *baseOutPath, httpUploader, s3Uploader, httpServer, master, encryptionKey)
+	startStatsReport(log, &mg, httpUploader)
+	startCCErrorsWatch(log, &mg)
+
+	// Called from the input reader when a new connection replaces the previous one
+	onInputReconnect := func() {
+		if *reconnectDiscontinuity {
+			mg.SetDiscontinuity()
+		}
+	}
+
+	// Create the requested input reader
+	var r *bufio.Reader = nil
+	if *inputType == 2 {
+		// Reader from TCP server socket, it accepts a new connection when the current one closes
+		tlsConfig := newTCPInputTLSConfig(log)
+		tcpIn, err := tcpinput.New(log, *bindAddress, *localPort, *maxIdleInputS, *bindRetries, *bindRetryDelayMs, tlsConfig)
+		if err != nil {
+			log.Fatal("Error creating TCP input, ", err)
+		}
+		log.Info("Listening on " + tcpIn.LocalAddr + " (TLS: " + strconv.FormatBool(tlsConfig != nil) + ")")
+
+		tcpReader := reconnectreader.New(log, "TCP", tcpIn.Next, *maxInputReopens, onInputReconnect)
+		r = bufio.NewReader(&tcpReader)
+	} else if *inputType == 3 {
+		// Reader from UDP socket (unicast or multicast)
+		udpIn, err := udpinput.New(log, *bindAddress, *localPort, *multicastGroup, *multicastIface, *udpRcvBufferSize)
+		if err != nil {
+			log.Error("Error opening UDP input on port ", *localPort, ". Err: ", err)
+			os.Exit(1)
+		}
+		log.Info("Listening UDP on port " + strconv.Itoa(*localPort))
+
+		r = bufio.NewReader(&udpIn)
+	} else if *inputType == 5 {
+		// Reader from RTP over UDP socket (unicast or multicast)
+		udpIn, err := udpinput.New(log, *bindAddress, *localPort, *multicastGroup, *multicastIface, *udpRcvBufferSize)
+		if err != nil {
+			log.Error("Error opening RTP input on port ", *localPort, ". Err: ", err)
+			os.Exit(1)
+		}
+		log.Info("Listening RTP on port " + strconv.Itoa(*localPort))
+
+		rtpIn := rtpinput.New(log, udpIn.Conn, *rtpJitterMs)
+		r = bufio.NewReader(&rtpIn)
+	} else if *inputType == 6 {
+		// Reader from file, paced at real time (or paceFactor)
+		fileIn, err := fileinput.New(log, *inputFile, *paceFactor)
+		if err != nil {
+			log.Error("Error opening input file ", *inputFile, ". Err: ", err)
+			os.Exit(1)
+		}
+		log.Info("Reading file " + *inputFile + " at pace factor " + strconv.FormatFloat(*paceFactor, 'f', -1, 64))
+
+		r = bufio.NewReader(&fileIn)
+	} else if *inputType == 7 {
+		// Reader from named pipe, it reopens the pipe when the writer closes it
+		pipeIn, err := pipeinput.New(log, *inputPipe)
+		if err != nil {
+			log.Error("Error opening input pipe ", *inputPipe, ". Err: ", err)
+			os.Exit(1)
+		}
+
+		pipeReader := reconnectreader.New(log, "pipe", pipeIn.Next, *maxInputReopens, onInputReconnect)
+		r = bufio.NewReader(&pipeReader)
+	} else if *inputType == 8 {
+		// Reader from HTTP ingest server, the body of a POST or PUT is the TS stream
+		ingestIn, err := httpingest.New(log, *bindAddress, *localPort, *ingestToken, *inputTLSCert, *inputTLSKey)
+		if err != nil {
+			log.Error("Error creating HTTP ingest input. Err: ", err)
+			os.Exit(1)
+		}
+		log.Info("HTTP ingest listening on " + ingestIn.LocalAddr + " (TLS: " + strconv.FormatBool(ingestIn.IsTLS()) + ")")
+
+		ingestReopens := 0
+		if *ingestWaitReconnect {
+			ingestReopens = *maxInputReopens
+		}
+		ingestReader := reconnectreader.New(log, "HTTP ingest", ingestIn.Next, ingestReopens, onInputReconnect)
+		r = bufio.NewReader(&ingestReader)
+	} else if *inputType == 4 {
+		// Reader from SRT (listener or caller), it reconnects if the current connection drops
+		srtMode := srtinput.SrtModeListener
+		srtAddress := net.JoinHostPort(*bindAddress, strconv.Itoa(*localPort))
+		if *srtCallerAddress != "" {
+			srtMode = srtinput.SrtModeCaller
+			srtAddress = *srtCallerAddress
+		}
+
+		srtIn, err := srtinput.New(log, srtMode, srtAddress, *srtPassphrase, *srtLatencyMs, *srtStreamID)
+		if err != nil {
+			log.Error("Error opening SRT input on ", srtAddress, ". Err: ", err)
+			os.Exit(1)
+		}
+		log.Info("SRT input ready on " + srtAddress)
+
+		srtReader := reconnectreader.New(log, "SRT", srtIn.Next, *maxInputReopens, onInputReconnect)
+		r = bufio.NewReader(&srtReader)
+	} else {
+		// Reader from std in
+		r = bufio.NewReader(os.Stdin)
+	}
+
+	err := processWatchedInput(log, r, &mg, *readBufferSize, stop)
+	if err != nil {
+		// Error reading pipe
+		log.Fatal("Error reading input data. Err: ", err)
+	}
+	waitPendingUploads(log, httpUploader)
+	// The chunked transfer uploads that failed after the last chunk
+	mg.MarkUploadGaps()
+	waitEndlist(&mg, stop)
+	waitServerShutdown(log, httpServer, stop)
+
+	if isStopped(stop) {
+		log.Info("Exit because of shutdown signal")
+	} else {
+		log.Info("Exit because detected EOF in the input reader")
+	}
+
+	os.Exit(0)
+}
+
+// newTCPInputTLSConfig Creates the TCP input TLS config from the flags, nil if TLS is not enabled
+func newTCPInputTLSConfig(log *logrus.Logger) *tls.Config {
+	if *inputTLSCert == "" && *inputTLSKey == "" {
+		return nil
+	}
+
+	tlsConfig, err := tcpinput.NewTLSConfig(*inputTLSCert, *inputTLSKey, *inputTLSClientCA)
+	if err != nil {
+		log.Fatal("Error creating TCP input TLS config, ", err)
+	}
+
+	return tlsConfig
+}
+
+// readEncryptionKey Returns the key of encryptionKeyFile, nil if it is empty (random key). It exits if the file can not be read or the key is not valid
+func readEncryptionKey(log *logrus.Logger) []byte {
+	if *encryptionKeyFile == "" {
+		return nil
+	}
+
+	key, err := os.ReadFile(*encryptionKeyFile)
+	if err != nil {
+		log.Error("Error reading encryptionKeyFile ", *encryptionKeyFile, ". Err: ", err)
+		os.Exit(1)
+	}
+	if len(key) != encryption.KeyLength {
+		log.Error("Invalid encryptionKeyFile ", *encryptionKeyFile, ", the key has ", len(key), " bytes and it has to be ", encryption.KeyLength)
+		os.Exit(1)
+	}
+
+	return key
+}
+
+// newManifestGenerator Creates a manifest generator with the configuration from the flags, encryptionKey is the one read (and validated) from encryptionKeyFile, nil if it is random
+func newManifestGenerator(log *logrus.Logger, outPath string, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader, httpServer *httpserver.HTTPServer, master *hls.Master, encryptionKey []byte) manifestgenerator.ManifestGenerator {
 	mg := manifestgenerator.New(log,
-		chunkOutputType,
-		hlsOutputType,
-		*baseOutPath,
+		mediachunk.OutputTypes(mediaDestinationTypes[0]),
+		hls.OutputTypes(manifestDestinationTypes[0]),
+		outPath,
 		*chunkBaseFilename,
 		*chunkListFilename,
 		*fileNumberLength,
 		*targetSegmentDurS,
 		manifestgenerator.ChunkInitTypes(*chunkInitType),
 		*autoPID,
-		-1,
-		-1,
+		*videoPID,
+		*audioPID,
 		hls.ManifestTypes(*manifestTypeInt),
 		*liveWindowSize,
 		*lhlsAdvancedChunks,
 		httpUploader,
 		s3Uploader)
 
-	// Create the requested input reader
-	var r *bufio.Reader = nil
-	if *inputType == 2 {
-		// Reader from TCP server socket
+	mg.SetHTTPServer(httpServer)
+	mg.SetManifestFsync(*manifestFsync || *fsync)
+	mg.SetEpochNumbering(*epochNumbering)
+	mg.SetSegmentURIPrefix(segmentURIPrefix(outPath))
+	mg.SetBitrateTag(*bitrateTag)
+	if *customTagsFile != "" {
+		headerTags, err := hls.LoadCustomTags(*customTagsFile)
+		if err != nil {
+			log.Fatal("Error loading the custom tags, ", err)
+		}
+		if err := mg.SetCustomTags(headerTags, nil); err != nil {
+			log.Fatal("Error setting the custom tags, ", err)
+		}
+	}
+	mg.SetChunkFormat(manifestgenerator.ChunkFormats(*chunkFormat))
+	mg.SetDash(*dashFilename)
+	if err := mg.SetEncryption(encryption.Methods(*encryptionMethod), encryptionKey, *encryptionKeyURI, *encryptionKeyPath, encryption.IVModes(*encryptionIVMode), *encryptionKeyFormat, *encryptionKeyFormatVers); err != nil {
+		log.Fatal("Error setting the chunks encryption, ", err)
+	}
+	if *encryptionKeyProvider != "" && encryption.Methods(*encryptionMethod) != encryption.MethodNone {
+		provider, err := encryption.NewHTTPKeyProvider(log, *encryptionKeyProvider, *encryptionKeyProvMethod, *encryptionKeyProvToken, *encryptionKeyProvRetry, *encryptionKeyProvDelay)
+		if err == nil {
+			err = mg.SetKeyProvider(provider)
+		}
+		if err != nil {
+			log.Fatal("Error getting the encryption key from the key provider, ", err)
+		}
+	}
+	if err := mg.SetKeyRotation(*encryptionKeyRotation, *encryptionKeyRotationS); err != nil {
+		log.Fatal("Error setting the encryption key rotation, ", err)
+	}
+	mg.SetGapOnUploadFailure(*gapOnUploadFailure)
+	mg.SetFinalizeAsVod(*finalizeAsVod, time.Duration(*shutdownTimeoutS)*time.Second)
+	mg.SetEndlist(manifestgenerator.EndlistModes(*liveEndlist), time.Duration(*liveEndlistGraceS)*time.Second)
+	mg.SetChunkFlush(*chunkedFlushBytes, time.Duration(*chunkedFlushMs)*time.Millisecond)
+	mg.SetPublishPolicy(hls.PublishPolicies(*manifestPublishPolicy), time.Duration(*manifestPublishInterval)*time.Millisecond)
+	mg.SetResyncPackets(*resyncPackets)
+	mg.SetInputPacketSize(*inputPacketSize)
+	mg.SetAudioOnly(*audioOnly)
+	mg.SetPreferredAudioCodec(manifestgenerator.AudioCodecs(*preferredAudioCodec))
+	mg.SetProgramSelection(*programNumber, *pmtPID)
+	mg.SetSCTE35PID(*scte35PID)
+	mg.SetSCTE35Cut(*scte35Cut)
+	mg.SetMaxTimestampJumpS(*maxTimestampJumpS)
+	mg.SetDurationSource(manifestgenerator.DurationSources(*durationSource), *maxDurationDriftS)
+	mg.SetMinSegmentDurS(*minSegmentDurS)
+	keepPIDsList, _ := parsePIDs(log, "keepPids", *keepPIDs, false, true)
+	mg.SetPIDFilter(*filterPIDs, keepPIDsList)
+	mg.SetStripNullPackets(*stripNullPackets)
+	dataPIDsList, isAutoDataPIDs := parsePIDs(log, "dataPids", *dataPIDs, true, false)
+	mg.SetDataPIDs(dataPIDsList, isAutoDataPIDs)
+	mg.SetPIDRemap(*remapVideoPID, *remapAudioPID, *remapPMTPID)
+	mg.SetPSIGeneration(*generatePSI)
+	mg.SetPSIInterval(*psiIntervalMs)
+	mg.SetMaxSegmentDurS(*maxSegmentDurS, manifestgenerator.MaxSegmentDurActions(*maxSegmentDurAction))
+	mg.SetIndependentSegments(*independentSegments)
+	mg.SetPartDuration(*partDurationS)
+	mg.SetServerControl(*serverControl, *holdBackS, *partHoldBackS)
+	mg.SetDeltaChunklist(*deltaChunklistFile, *canSkipUntilS)
+	for _, window := range parseWindowChunklists(log, *windowChunklists) {
+		mg.AddWindowChunklist(window.FileName, window.WindowSize, window.OutputType)
+	}
+	if *startTimeOffsetS != "" {
+		timeOffsetS, _ := strconv.ParseFloat(*startTimeOffsetS, 64)
+		mg.SetStart(true, timeOffsetS, *startPrecise)
+	}
+	mg.SetByteRangeMaxFileBytes(*byteRangeMaxFileBytes)
+	mg.SetCaptions(*captions, *captionsChunklistFile)
+	mg.SetProgramDateTime(*pdtEvery)
+	mg.SetProgramDateTimeSource(pdtSources[*pdtSource])
+	mg.SetIFramesPlaylist(*iFrames, *iFramesChunklistFile)
+	mg.SetHlsVersion(*hlsVersion)
+	mg.SetExtinfPrecision(*extinfPrecision)
+	if err := mg.SetChunkSharding(*chunkShardLayout); err != nil {
+		log.Fatal("Error setting the chunks sharding, ", err)
+	}
+	if err := mg.SetChunkFilenameTemplate(*chunkFilenameTemplate); err != nil {
+		log.Fatal("Error setting the chunks filename template, ", err)
+	}
+	if *fsync {
+		if err := mg.SetMediaFsync(*fsyncMediaEvery); err != nil {
+			log.Fatal("Error setting the media fsync, ", err)
+		}
+	}
+	if err := mg.SetChunkVerification(*verifyChunks, *verifyChunksToleranceS, *verifyChunksGap); err != nil {
+		log.Fatal("Error setting the chunks verification, ", err)
+	}
+	if err := mg.SetWriteQueue(*writeQueueBytes); err != nil {
+		log.Fatal("Error setting the write queue, ", err)
+	}
+	if err := mg.SetSecondaryDestinations(secondaryMediaDestinations(), secondaryManifestDestinations()); err != nil {
+		log.Fatal("Error setting the secondary destinations, ", err)
+	}
+	if err := mg.SetNoFlagFiles(*noFlagFiles); err != nil {
+		log.Fatal("Error setting the no flag files, ", err)
+	}
+	isFlagFile, inProgressExtension, liveStateFileName := false, "", ""
+	for _, signal := range strings.Split(*inProgressSignal, ",") {
+		switch strings.TrimSpace(signal) {
+		case "flag":
+			isFlagFile = true
+		case "part":
+			inProgressExtension = manifestgenerator.InProgressExtensionDefault
+		case "state":
+			liveStateFileName = path.Join(outPath, chunkmeta.LiveStateFileName)
+		default:
+			log.Fatal("Error parsing inProgressSignal, invalid signal ", signal)
+		}
+	}
+	if err := mg.SetInProgressSignaling(isFlagFile, inProgressExtension, liveStateFileName); err != nil {
+		log.Fatal("Error setting the in progress signaling, ", err)
+	}
+	if err := mg.SetChunkChecksum(*chunkChecksum, *chunkChecksumSidecar); err != nil {
+		log.Fatal("Error setting the chunks checksum, ", err)
+	}
+	if err := mg.SetChunkMetadata(*chunkMetadata, *chunkMetadataIndex); err != nil {
+		log.Fatal("Error setting the chunks metadata, ", err)
+	}
+	if err := mg.SetChunkRetention(*deleteOldChunks, *deleteOldChunksMargin); err != nil {
+		log.Fatal("Error setting the old chunks deletion, ", err)
+	}
+	if *stateFile != "" {
+		// Every rendition has its own state file in its subdirectory
+		renditionPath, _ := filepath.Rel(*baseOutPath, outPath)
+		stateFileName := path.Join(path.Dir(*stateFile), renditionPath, path.Base(*stateFile))
+		if err := os.MkdirAll(path.Dir(stateFileName), 0744); err != nil {
+			log.Fatal("Error creating the state file directory, ", err)
+		}
+		if err := mg.SetStateFile(stateFileName, *resume); err != nil {
+			log.Fatal("Error setting the state file, ", err)
+		}
+	}
+	if master != nil {
+		// Variant URI relative to the master playlist
+		variantURI, _ := filepath.Rel(*baseOutPath, path.Join(outPath, *chunkListFilename))
+		mg.SetMasterPlaylist(master, variantURI, *masterBandwidthWindow)
+	}
 
-		log.Info("Listening on port " + strconv.Itoa(*localPort))
-		// listen on all interfaces
-		ln, _ := net.Listen("tcp", ":"+strconv.Itoa(*localPort))
-		// accept connection on port
-		conn, _ := ln.Accept()
-		log.Info("Connection TCP accepted")
+	return mg
+}
 
-		r = bufio.NewReader(conn)
-	} else {
-		// Reader from std in
-		r = bufio.NewReader(os.Stdin)
+// readHTTPHeaders Returns the headers of httpHeadersFile and httpHeader (they replace the ones of the file)
+func readHTTPHeaders(log *logrus.Logger) http.Header {
+	lines := []string{}
+	if *httpHeadersFile != "" {
+		data, err := os.ReadFile(*httpHeadersFile)
+		if err != nil {
+			log.Fatal("Error reading the HTTP headers file, ", err)
+		}
+		lines = strings.Split(string(data), "\n")
 	}
+	lines = append(lines, httpHeaders...)
 
-	// Buffer
-	buf := make([]byte, 0, readBufferSize)
+	headers, err := httpuploader.ParseHeaders(lines)
+	if err != nil {
+		log.Fatal("Error parsing the HTTP headers, ", err)
+	}
+
+	return headers
+}
+
+// newHTTPAuth Returns the authorization of httpAuthBearer, httpAuthBasic and httpAuthTokenURL, nil none
+func newHTTPAuth(log *logrus.Logger) *httpuploader.Auth {
+	if *httpAuthBearer == "" && *httpAuthBasic == "" && *httpAuthTokenURL == "" {
+		return nil
+	}
+
+	bearerToken := expandFlagEnv(log, "httpAuthBearer", *httpAuthBearer)
+	basicUser, basicPassword := splitCredentials(log, "httpAuthBasic", expandFlagEnv(log, "httpAuthBasic", *httpAuthBasic))
+	clientID, clientSecret := splitCredentials(log, "httpAuthTokenCredentials", expandFlagEnv(log, "httpAuthTokenCredentials", *httpAuthTokenCreds))
+
+	return httpuploader.NewAuth(bearerToken, basicUser, basicPassword, *httpAuthTokenURL, clientID, clientSecret)
+}
+
+// expandFlagEnv Returns the value of the flag with the environment variables replaced
+func expandFlagEnv(log *logrus.Logger, flagName string, value string) string {
+	expanded, err := httpuploader.ExpandEnv(value)
+	if err != nil {
+		log.Fatal("Error parsing ", flagName, ", ", err)
+	}
+
+	return expanded
+}
+
+// splitCredentials Splits the user:password credentials of the flag ("" none)
+func splitCredentials(log *logrus.Logger, flagName string, credentials string) (user string, password string) {
+	if credentials == "" {
+		return "", ""
+	}
+	sep := strings.Index(credentials, ":")
+	if sep <= 0 {
+		log.Fatal("Error parsing ", flagName, ", the format is user:password")
+	}
+
+	return credentials[:sep], credentials[sep+1:]
+}
+
+// parseHTTPStatuses Returns the comma separated HTTP statuses of the flag
+func parseHTTPStatuses(log *logrus.Logger, flagName string, statusesStr string) []int {
+	statuses := []int{}
+	for _, statusStr := range strings.Split(statusesStr, ",") {
+		if strings.TrimSpace(statusStr) == "" {
+			continue
+		}
+		status, err := strconv.Atoi(strings.TrimSpace(statusStr))
+		if err != nil || status < 100 || status > 599 {
+			log.Fatal("Error parsing ", flagName, ", invalid HTTP status ", statusStr)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// parsePIDs Parses a comma separated PIDs flag (decimal or 0x hex), "auto" is returned as isAuto if allowed, the null PID (0x1FFF) is valid if allowNull. It exits if a PID is not valid
+func parsePIDs(log *logrus.Logger, flagName string, pIDsStr string, allowAuto bool, allowNull bool) (pIDs []int, isAuto bool) {
+	pIDs = make([]int, 0)
+	if pIDsStr == "" {
+		return
+	}
+
+	for _, pIDStr := range strings.Split(pIDsStr, ",") {
+		pIDStr = strings.TrimSpace(pIDStr)
+		if allowAuto && pIDStr == "auto" {
+			isAuto = true
+			continue
+		}
+		pID, err := strconv.ParseInt(pIDStr, 0, 0)
+		if err != nil || int(pID) < tspacket.MinESPID || int(pID) > tspacket.NullPID || (int(pID) == tspacket.NullPID && !allowNull) {
+			log.Fatal("Error parsing ", flagName, ", invalid PID ", pIDStr)
+		}
+		pIDs = append(pIDs, int(pID))
+	}
+
+	return
+}
+
+// parseDestinationTypes Parses a destination type flag (comma separated, the 1st one is the primary), the types are 0 to maxType and not repeated. It exits if one is not valid
+func parseDestinationTypes(log *logrus.Logger, flagName string, destinationTypesStr string, maxType int) []int {
+	destinationTypes := []int{}
+	for _, typeStr := range strings.Split(destinationTypesStr, ",") {
+		destinationType, err := strconv.Atoi(strings.TrimSpace(typeStr))
+		if err != nil || destinationType < 0 || destinationType > maxType {
+			log.Fatal("Error parsing ", flagName, ", invalid destination ", typeStr)
+		}
+		if hasDestination(destinationTypes, destinationType) {
+			log.Fatal("Error parsing ", flagName, ", destination ", destinationType, " is repeated")
+		}
+		destinationTypes = append(destinationTypes, destinationType)
+	}
+	if len(destinationTypes) > 1 && hasDestination(destinationTypes, 0) {
+		log.Fatal("Error parsing ", flagName, ", no output (0) can not have other destinations")
+	}
+
+	return destinationTypes
+}
+
+// parseWindowChunklists Parses the windowChunklists flag, the destination is manifestDestinationType if it is not set. It exits if a window is not valid
+func parseWindowChunklists(log *logrus.Logger, windowsStr string) []hls.Window {
+	windows := []hls.Window{}
+	if windowsStr == "" {
+		return windows
+	}
+
+	for _, windowStr := range strings.Split(windowsStr, ",") {
+		fields := strings.Split(strings.TrimSpace(windowStr), ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			log.Fatal("Error parsing windowChunklists, invalid window ", windowStr)
+		}
+		windowSize, err := strconv.Atoi(fields[1])
+		if err != nil || windowSize < 0 {
+			log.Fatal("Error parsing windowChunklists, invalid window size ", windowStr)
+		}
+		outputType := manifestDestinationTypes[0]
+		if len(fields) == 3 {
+			if outputType, err = strconv.Atoi(fields[2]); err != nil || outputType < int(hls.HlsOutputModeNone) || outputType > int(hls.HlsOutputModeHTTPServer) {
+				log.Fatal("Error parsing windowChunklists, invalid destination type ", windowStr)
+			}
+		}
+		if *serverMaxSegments > 0 && (windowSize <= 0 || windowSize > *serverMaxSegments) && (outputType == int(hls.HlsOutputModeHTTPServer) || hasDestination(mediaDestinationTypes, 6)) {
+			log.Warn("The built-in HTTP server retains ", *serverMaxSegments, " chunks (serverMaxSegments), the oldest ones of ", fields[0], " will not be available")
+		}
+		windows = append(windows, hls.Window{FileName: fields[0], WindowSize: windowSize, OutputType: hls.OutputTypes(outputType)})
+	}
+
+	return windows
+}
+
+// readResult Result of a read from the input reader
+type readResult struct {
+	n   int
+	err error
+}
+
+// processInput Sends all the input data to the manifest generator aligned to TS packets, and closes it on EOF or when stop is closed
+func processInput(log *logrus.Logger, r io.Reader, mg *manifestgenerator.ManifestGenerator, bufferSize int, stop <-chan bool) error {
+	if bufferSize < tspacket.TsDefaultPacketSize {
+		bufferSize = tspacket.TsDefaultPacketSize
+	}
+
+	// Buffer, pending contains the bytes of an incomplete packet from the previous read
+	buf := make([]byte, bufferSize)
+	pending := 0
+
+	// Reads in a different goroutine to be able to stop while the reader is blocked (Ex: waiting a connection)
+	readOffsets := make(chan int)
+	readResults := make(chan readResult, 1)
+	defer close(readOffsets)
+	go func() {
+		for offset := range readOffsets {
+			n, err := r.Read(buf[offset:])
+			readResults <- readResult{n, err}
+		}
+	}()
 
 	for {
-		n, err := r.Read(buf[:cap(buf)])
+		readOffsets <- pending
+
+		var res readResult
+		select {
+		case res = <-readResults:
+		case <-stop:
+			// The reader only writes after pending, so buf[:pending] is safe to use
+			log.Info("Closing process, shutdown requested")
+			if pending > 0 {
+				mg.AddData(buf[:pending])
+			}
+			mg.Close()
+
+			return nil
+		}
+
+		n, err := res.n, res.err
 		if n == 0 && err == io.EOF {
 			// Detected EOF
 			// Closing
 			log.Info("Closing process detected EOF")
+			if pending > 0 {
+				mg.AddData(buf[:pending])
+			}
 			mg.Close()
 
-			break
+			return nil
 		}
 
 		if err != nil && err != io.EOF {
-			// Error reading pipe
-			log.Fatal(err, logPath)
-			os.Exit(1)
+			return err
 		}
 
-		// process buf
-		log.Debug("Sent to process: ", n, " bytes")
-		mg.AddData(buf[:n])
+		// process buf (only complete packets)
+		available := pending + n
+		aligned := available - (available % tspacket.TsDefaultPacketSize)
+		if aligned > 0 {
+			log.Debug("Sent to process: ", aligned, " bytes")
+			mg.AddData(buf[:aligned])
+
+			if *maxResyncs > 0 && mg.GetResyncs() > uint64(*maxResyncs) {
+				return fmt.Errorf("input lost the TS sync %d times (max %d)", mg.GetResyncs(), *maxResyncs)
+			}
+			if err := mg.GetError(); err != nil {
+				return err
+			}
+			if *requireAudio && mg.IsVideoOnly() {
+				return fmt.Errorf("input PMT has no audio (requireAudio is set)")
+			}
+		}
+		pending = copy(buf, buf[aligned:available])
 	}
+}
 
-	log.Info("Exit because detected EOF in the input reader")
+// handleShutdownSignals Closes the returned channel on the 1st SIGINT / SIGTERM, the 2nd one forces the exit
+func handleShutdownSignals(log *logrus.Logger) chan bool {
+	stop := make(chan bool)
 
-	os.Exit(0)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Info("Received ", sig, ", finalizing the current chunk and manifest (send it again to force the exit)")
+		close(stop)
+
+		sig = <-signals
+		log.Warn("Received ", sig, " again, forcing exit")
+		os.Exit(1)
+	}()
+
+	return stop
+}
+
+// isStopped Returns true if stop is closed
+func isStopped(stop <-chan bool) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitPendingUploads Waits (up to shutdownTimeoutS) for the uploads in progress
+func waitPendingUploads(log *logrus.Logger, httpUploader *httpuploader.HTTPUploader) {
+	if httpUploader == nil {
+		return
+	}
+
+	if !httpUploader.WaitPendingUploads(time.Duration(*shutdownTimeoutS) * time.Second) {
+		log.Warn("Timeout waiting for the pending uploads, exiting anyway")
+	}
+}
+
+// waitEndlist Waits for the EXT-X-ENDLIST grace period, a shutdown signal leaves the chunklist open
+func waitEndlist(mg *manifestgenerator.ManifestGenerator, stop <-chan bool) {
+	done := make(chan bool)
+	go func() {
+		mg.WaitEndlist()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-stop:
+		mg.CancelEndlist()
+	}
+}
+
+// waitEndlists Waits for the EXT-X-ENDLIST grace period of all the manifest generators at the same time
+func waitEndlists(mgs []*manifestgenerator.ManifestGenerator, stop <-chan bool) {
+	var wg sync.WaitGroup
+	for _, mg := range mgs {
+		wg.Add(1)
+		go func(mg *manifestgenerator.ManifestGenerator) {
+			defer wg.Done()
+			waitEndlist(mg, stop)
+		}(mg)
+	}
+	wg.Wait()
+}
+
+// waitServerShutdown Keeps serving from the built-in HTTP server after the input ends, until the shutdown signal
+func waitServerShutdown(log *logrus.Logger, httpServer *httpserver.HTTPServer, stop <-chan bool) {
+	if httpServer == nil {
+		return
+	}
+
+	if !isStopped(stop) {
+		log.Info("Input ended, serving from ", httpServer.LocalAddr, " until the shutdown signal")
+		<-stop
+	}
+	httpServer.Close()
+}
+
+// startStatsReport Logs the input stats every statsIntervalS
+func startStatsReport(log *logrus.Logger, mg *manifestgenerator.ManifestGenerator, httpUploader *httpuploader.HTTPUploader) {
+	if *statsIntervalS <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(*statsIntervalS) * time.Second)
+		defer ticker.Stop()
+
+		last := mg.GetStats()
+		lastTime := time.Now()
+		for now := range ticker.C {
+			stats := mg.GetStats()
+			elapsedS := now.Sub(lastTime).Seconds()
+
+			fields := logrus.Fields{
+				"event":         "input_stats",
+				"inputBps":      int64(float64(stats.InputBytes-last.InputBytes) * 8 / elapsedS),
+				"packetsPerS":   int64(float64(stats.Packets-last.Packets) / elapsedS),
+				"videoPID":      stats.VideoPID,
+				"videoBps":      int64(float64(stats.VideoBytes-last.VideoBytes) * 8 / elapsedS),
+				"audioPID":      stats.AudioPID,
+				"audioBps":      int64(float64(stats.AudioBytes-last.AudioBytes) * 8 / elapsedS),
+				"inputBytes":    stats.InputBytes,
+				"ccErrors":      stats.CCErrors,
+				"videoCCErrors": stats.VideoCCErrors,
+				"audioCCErrors": stats.AudioCCErrors,
+				"nullPackets":   stats.NullPackets,
+				"gaps":          stats.Gaps,
+			}
+			if *writeQueueBytes > 0 {
+				fields["writeQueueHighWaterBytes"] = stats.WriteQueueHighWaterBytes
+				fields["writeQueueBlocks"] = stats.WriteQueueBlocks
+				fields["writeQueueBlockedMs"] = stats.WriteQueueBlockedNs / uint64(time.Millisecond)
+			}
+			if *verifyChunks {
+				fields["verifyFailures"] = stats.VerifyFailures
+			}
+			if len(mediaDestinationTypes) > 1 || len(manifestDestinationTypes) > 1 {
+				fields["secondaryFailures"] = stats.SecondaryFailures
+			}
+			if httpUploader != nil {
+				fields["httpRetries"] = httpUploader.GetRetries()
+				if httpUploader.GetConcurrency() > 1 {
+					fields["httpQueuedUploads"] = httpUploader.GetQueuedUploads()
+					fields["httpInFlightUploads"] = httpUploader.GetInFlightUploads()
+				}
+			}
+			if stats.LastDataUnixNano > 0 {
+				fields["lastDataTime"] = time.Unix(0, stats.LastDataUnixNano).Format(time.RFC3339Nano)
+			}
+			log.WithFields(fields).Info("Input stats")
+
+			last = stats
+			lastTime = now
+		}
+	}()
+}
+
+// startCCErrorsWatch Logs a warning event every minute with more than maxCCErrorsPerMin CC errors
+func startCCErrorsWatch(log *logrus.Logger, mg *manifestgenerator.ManifestGenerator) {
+	if *maxCCErrorsPerMin <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		last := mg.GetStats()
+		for range ticker.C {
+			stats := mg.GetStats()
+
+			ccErrors := stats.CCErrors - last.CCErrors
+			if ccErrors > uint64(*maxCCErrorsPerMin) {
+				log.WithFields(logrus.Fields{
+					"event":         "cc_errors",
+					"ccErrors":      ccErrors,
+					"videoCCErrors": stats.VideoCCErrors - last.VideoCCErrors,
+					"audioCCErrors": stats.AudioCCErrors - last.AudioCCErrors,
+				}).Warn("CC errors in the last minute (", ccErrors, ") exceeded maxCCErrorsPerMin (", *maxCCErrorsPerMin, ")")
+			}
+
+			last = stats
+		}
+	}()
+}
+
+// newInputStallWatchdog Wraps the input reader with the stall watchdog if inputStallTimeoutS is set (nil watchdog otherwise), it has to be stopped when the input ends
+func newInputStallWatchdog(log *logrus.Logger, r io.Reader) (io.Reader, *stallwatchdog.StallWatchdog) {
+	if *inputStallTimeoutS <= 0 {
+		return r, nil
+	}
+
+	onStall := func(lastDataTime time.Time) {
+		action := stallActions(*inputStallAction)
+		if action == stallActionLog || action == stallActionBoth {
+			log.WithFields(logrus.Fields{"event": "input_stall", "lastDataTime": lastDataTime.Format(time.RFC3339Nano)}).Error("Input stalled for more than ", *inputStallTimeoutS, "s")
+			if *inputStallWebhook != "" {
+				postStallWebhook(log, *inputStallWebhook, lastDataTime)
+			}
+		}
+		if action == stallActionExit || action == stallActionBoth {
+			log.Error("Exit because the input stalled for more than ", *inputStallTimeoutS, "s")
+			os.Exit(exitCodeInputStall)
+		}
+	}
+
+	wd := stallwatchdog.New(log, r, *inputStallTimeoutS, onStall)
+	wd.Start()
+
+	return &wd, &wd
+}
+
+// processWatchedInput Same as processInput with the input stall watchdog, stopped when the input ends (it is not a stall)
+func processWatchedInput(log *logrus.Logger, r io.Reader, mg *manifestgenerator.ManifestGenerator, bufferSize int, stop <-chan bool) error {
+	r, wd := newInputStallWatchdog(log, r)
+	if wd != nil {
+		defer wd.Stop()
+	}
+
+	return processInput(log, r, mg, bufferSize, stop)
+}
+
+// postStallWebhook Sends the input stall event as JSON
+func postStallWebhook(log *logrus.Logger, url string, lastDataTime time.Time) {
+	event := map[string]interface{}{
+		"event":        "input_stall",
+		"lastDataTime": lastDataTime.Format(time.RFC3339Nano),
+		"stalledS":     time.Since(lastDataTime).Seconds(),
+	}
+	body, _ := json.Marshal(event)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error("Error calling input stall webhook ", url, ". Err: ", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Error("Error calling input stall webhook ", url, ". Status: ", resp.StatusCode)
+	}
+}
+
+// runMultiPortTCP Listens on every port of localPorts, each one with its own manifest generator in its own subdirectory. It returns the manifest generators when all the inputs end
+func runMultiPortTCP(log *logrus.Logger, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader, httpServer *httpserver.HTTPServer, master *hls.Master, encryptionKey []byte, stop <-chan bool) []*manifestgenerator.ManifestGenerator {
+	ports, names := multiPortRenditions(log)
+	mgs := make([]*manifestgenerator.ManifestGenerator, len(ports))
+
+	tlsConfig := newTCPInputTLSConfig(log)
+
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		name := names[i]
+		renditionLog := newRenditionLogger(log, name)
+
+		outPath := path.Join(*baseOutPath, name)
+		if isFileOut() {
+			os.MkdirAll(outPath, 0744)
+		}
+
+		tcpIn, err := tcpinput.New(renditionLog, *bindAddress, port, *maxIdleInputS, *bindRetries, *bindRetryDelayMs, tlsConfig)
+		if err != nil {
+			log.Fatal("Error creating TCP input for ", name, ", ", err)
+		}
+		renditionLog.Info("Listening on " + tcpIn.LocalAddr + " (TLS: " + strconv.FormatBool(tlsConfig != nil) + ")")
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			mg := newManifestGenerator(renditionLog, outPath, httpUploader, s3Uploader, httpServer, master, encryptionKey)
+			mgs[i] = &mg
+			startStatsReport(renditionLog, &mg, httpUploader)
+			startCCErrorsWatch(renditionLog, &mg)
+			onInputReconnect := func() {
+				if *reconnectDiscontinuity {
+					mg.SetDiscontinuity()
+				}
+			}
+
+			tcpReader := reconnectreader.New(renditionLog, "TCP", tcpIn.Next, *maxInputReopens, onInputReconnect)
+			err := processWatchedInput(renditionLog, bufio.NewReader(&tcpReader), &mg, *readBufferSize, stop)
+			if err != nil {
+				// Only this rendition stops
+				renditionLog.Error("Error reading input data. Err: ", err)
+				mg.Close()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return mgs
+}
+
+// multiPortRenditions Returns the ports of localPorts and their names (localPortsNames, by default the port number)
+func multiPortRenditions(log *logrus.Logger) (ports []int, names []string) {
+	portsStr := strings.Split(*localPorts, ",")
+
+	if *localPortsNames != "" {
+		names = strings.Split(*localPortsNames, ",")
+		if len(names) != len(portsStr) {
+			log.Fatal("localPortsNames needs one name per port in localPorts")
+		}
+	}
+
+	for i, portStr := range portsStr {
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if err != nil {
+			log.Fatal("Error parsing localPorts, invalid port ", portStr)
+		}
+		ports = append(ports, port)
+
+		if *localPortsNames != "" {
+			names[i] = strings.TrimSpace(names[i])
+		} else {
+			names = append(names, strconv.Itoa(port))
+		}
+	}
+
+	return
+}
+
+// segmentURIPrefix Returns the segmentUrlPrefix of the chunklist in outPath, the renditions (localPorts) add their directory to it
+func segmentURIPrefix(outPath string) string {
+	if *segmentURLPrefix == "" {
+		return ""
+	}
+	dir, err := filepath.Rel(*baseOutPath, outPath)
+	if err != nil || dir == "." {
+		return *segmentURLPrefix
+	}
+
+	return hls.PrefixURI(*segmentURLPrefix, dir) + "/"
+}
+
+// newMasterPlaylist Creates the master playlist (nil if masterFilename is empty) with the variants of masterDescriptor, or one variant per chunklist with the master* attributes
+func newMasterPlaylist(log *logrus.Logger, hlsOutputType hls.OutputTypes, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader, httpServer *httpserver.HTTPServer) *hls.Master {
+	if *masterFilename == "" {
+		return nil
+	}
+
+	descriptor := hls.MasterDescriptor{}
+	if *masterDescriptor != "" {
+		var err error
+		descriptor, err = hls.LoadDescriptor(*masterDescriptor)
+		if err != nil {
+			log.Fatal("Error reading masterDescriptor ", *masterDescriptor, ". Err: ", err)
+		}
+	}
+	variants := descriptor.Variants
+
+	renditionDirs := []string{""}
+	if *inputType == 2 && *localPorts != "" {
+		_, renditionDirs = multiPortRenditions(log)
+	}
+	if len(variants) <= 0 {
+		for _, dir := range renditionDirs {
+			variants = append(variants, hls.Variant{URI: path.Join(dir, *chunkListFilename), Bandwidth: *masterBandwidth, AverageBandwidth: *masterAvgBandwidth, Resolution: *masterResolution, FrameRate: *masterFrameRate, Codecs: *masterCodecs, Audio: *masterAudioGroup, Subtitles: *masterSubtitlesGroup})
+		}
+	}
+	if *masterBandwidthWindow <= 0 {
+		for _, v := range variants {
+			if v.Bandwidth <= 0 {
+				log.Warn("Master playlist variant ", v.URI, " without BANDWIDTH (masterBandwidth or masterBandwidthWindow)")
+			}
+		}
+	}
+
+	// The captions chunklists are subtitles renditions
+	renditions := descriptor.Renditions
+	if *captions && *masterSubtitlesGroup != "" {
+		for _, dir := range renditionDirs {
+			renditions = append(renditions, hls.Rendition{Type: hls.RenditionSubtitles, GroupID: *masterSubtitlesGroup, Name: strings.TrimSpace("Captions " + dir), Autoselect: true, URI: path.Join(dir, *captionsChunklistFile)})
+		}
+	}
+
+	master := hls.NewMaster(log, path.Join(*baseOutPath, *masterFilename), variants, renditions, hlsOutputType, httpUploader, s3Uploader)
+	master.SetHTTPServer(httpServer)
+	master.SetSecondaryOutputs(hls.SecondaryOutputs{OutputTypes: secondaryManifestDestinations()})
+	master.SetFsync(*manifestFsync || *fsync)
+	master.SetURIPrefix(*masterURLPrefix)
+	if err := master.Validate(); err != nil {
+		log.Fatal("Invalid master playlist renditions. Err: ", err)
+	}
+
+	return master
+}
+
+// renditionHook Adds the rendition label to all the log entries
+type renditionHook struct {
+	rendition string
+}
+
+// Levels Applies to all levels (implements logrus.Hook)
+func (h renditionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire Adds the rendition field (implements logrus.Hook)
+func (h renditionHook) Fire(entry *logrus.Entry) error {
+	entry.Data["rendition"] = h.rendition
+	return nil
+}
+
+// newRenditionLogger Creates a logger with the same config as log that labels the entries with the rendition
+func newRenditionLogger(log *logrus.Logger, rendition string) *logrus.Logger {
+	renditionLog := logrus.New()
+	renditionLog.SetOutput(log.Out)
+	renditionLog.SetFormatter(log.Formatter)
+	renditionLog.SetLevel(log.GetLevel())
+	renditionLog.AddHook(renditionHook{rendition})
+
+	return renditionLog
 }
 
 func isHTTPOut() bool {
-	if (*mediaDestinationType == 2) || (*mediaDestinationType == 3) || (*manifestDestinationType == 2) {
+	if hasDestination(mediaDestinationTypes, 2) || hasDestination(mediaDestinationTypes, 3) || hasDestination(manifestDestinationTypes, 2) {
+		return true
+	}
+	return false
+}
+
+func isServerOut() bool {
+	if hasDestination(mediaDestinationTypes, 6) || hasDestination(manifestDestinationTypes, 4) {
 		return true
 	}
 	return false
 }
 
 func isS3Out() bool {
-	if (*mediaDestinationType == 4) || (*manifestDestinationType == 3) {
+	if hasDestination(mediaDestinationTypes, 4) || hasDestination(manifestDestinationTypes, 3) {
 		return true
 	}
 	return false
 }
 
-func configureLogger(verbose bool, logPath string) *logrus.Logger {
+// isStdoutOut Returns true if the media is written to stdout, from the flag because the logger (stderr then) is configured before it is parsed
+func isStdoutOut() bool {
+	for _, typeStr := range strings.Split(*mediaDestinationType, ",") {
+		if strings.TrimSpace(typeStr) == strconv.Itoa(int(mediachunk.ChunkOutputModeStdout)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFileOut Returns true if any destination writes local files
+func isFileOut() bool {
+	return hasDestination(mediaDestinationTypes, 1) || hasDestination(mediaDestinationTypes, 5) || hasDestination(manifestDestinationTypes, 1)
+}
+
+// hasDestination Returns true if the destination type is in the destinations
+func hasDestination(destinationTypes []int, destinationType int) bool {
+	for _, t := range destinationTypes {
+		if t == destinationType {
+			return true
+		}
+	}
+	return false
+}
+
+// secondaryMediaDestinations Returns the media destinations after the primary one
+func secondaryMediaDestinations() []mediachunk.OutputTypes {
+	outputTypes := []mediachunk.OutputTypes{}
+	for _, t := range mediaDestinationTypes[1:] {
+		outputTypes = append(outputTypes, mediachunk.OutputTypes(t))
+	}
+	return outputTypes
+}
+
+// secondaryManifestDestinations Returns the manifest destinations after the primary one
+func secondaryManifestDestinations() []hls.OutputTypes {
+	outputTypes := []hls.OutputTypes{}
+	for _, t := range manifestDestinationTypes[1:] {
+		outputTypes = append(outputTypes, hls.OutputTypes(t))
+	}
+	return outputTypes
+}
+
+// configureLogger Creates the logger, it writes to stdout (stderr if isStderr) and to logPath if it is set
+func configureLogger(verbose bool, logPath string, isStderr bool) *logrus.Logger {
 	var log = logrus.New()
 	if verbose {
 		log.SetLevel(logrus.ErrorLevel)
@@ -184,17 +1552,22 @@ func configureLogger(verbose bool, logPath string) *logrus.Logger {
 	log.SetFormatter(formatter)
 	log.SetFormatter(&logrus.JSONFormatter{})
 
+	console := io.Writer(os.Stdout)
+	if isStderr {
+		console = os.Stderr
+	}
+
 	var mw io.Writer
 	if logPath != "" {
 		f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
-			fmt.Printf(fmt.Sprintf("Unable to open log file at: %s, error: %v", logPath, err))
+			fmt.Fprintf(console, "Unable to open log file at: %s, error: %v", logPath, err)
 			os.Exit(-1)
 		}
 
-		mw = io.MultiWriter(os.Stdout, f)
+		mw = io.MultiWriter(console, f)
 	} else {
-		mw = io.MultiWriter(os.Stdout)
+		mw = io.MultiWriter(console)
 	}
 
 	log.SetOutput(mw)
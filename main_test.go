@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"go-ts-segmenter/manifestgenerator"
+	"go-ts-segmenter/manifestgenerator/hls"
+	"go-ts-segmenter/manifestgenerator/mediachunk"
+	"go-ts-segmenter/manifestgenerator/tspacket"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// benchmarkBitrateBps Simulated source bitrate
+	benchmarkBitrateBps = 20 * 1000 * 1000
+)
+
+// benchmarkProcessInput Processes 1s of a 20Mbps source (testSmall.ts repeated) read from a file (1 syscall per read) with the indicated read buffer size
+func benchmarkProcessInput(b *testing.B, bufferSize int) {
+	fixture, err := ioutil.ReadFile("./fixture/testSmall.ts")
+	if err != nil {
+		b.Fatal("Error reading fixture. Err: ", err)
+	}
+
+	data := make([]byte, 0, benchmarkBitrateBps/8+len(fixture))
+	for len(data) < benchmarkBitrateBps/8 {
+		data = append(data, fixture...)
+	}
+
+	dataFile := path.Join(b.TempDir(), "source.ts")
+	err = ioutil.WriteFile(dataFile, data, 0644)
+	if err != nil {
+		b.Fatal("Error writing source file. Err: ", err)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mg := manifestgenerator.New(log, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, "", "chunk_", "chunklist.m3u8", 5, 4, manifestgenerator.ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+
+		f, err := os.Open(dataFile)
+		if err != nil {
+			b.Fatal("Error opening source file. Err: ", err)
+		}
+		err = processInput(log, f, &mg, bufferSize, nil)
+		f.Close()
+		if err != nil {
+			b.Fatal("Error processing input. Err: ", err)
+		}
+	}
+
+	packetsPerS := float64(b.N) * float64(len(data)/tspacket.TsDefaultPacketSize) / b.Elapsed().Seconds()
+	b.ReportMetric(packetsPerS, "packets/s")
+	b.ReportMetric(packetsPerS*float64(tspacket.TsDefaultPacketSize*8)/benchmarkBitrateBps, "x_realtime_20Mbps")
+}
+
+func BenchmarkProcessInput188B(b *testing.B) {
+	benchmarkProcessInput(b, tspacket.TsDefaultPacketSize)
+}
+
+func BenchmarkProcessInput64KiB(b *testing.B) {
+	benchmarkProcessInput(b, 64*1024)
+}
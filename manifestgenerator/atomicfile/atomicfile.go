@@ -0,0 +1,86 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	// windowsRenameRetries Renames retried on Windows, it fails while the target is open (Ex: a request reading it)
+	windowsRenameRetries = 5
+
+	// windowsRenameRetryDelay Delay between the renames retried on Windows
+	windowsRenameRetryDelay = 10 * time.Millisecond
+)
+
+// WriteFile Writes the data to a temp file in the same directory and renames it over fileName, so the readers get the previous file or the new one but never a partial one (rename is atomic on POSIX). If isSync the temp file is fsynced before the rename and the directory after it (crash safety)
+func WriteFile(fileName string, data []byte, perm os.FileMode, isSync bool) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFileName := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil && isSync {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFileName, perm)
+	}
+	if err == nil {
+		err = rename(tmpFileName, fileName, data, perm)
+	}
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	if isSync {
+		return SyncDir(filepath.Dir(fileName))
+	}
+
+	return nil
+}
+
+// SyncDir Fsyncs the directory, so the files created / renamed in it survive a crash. Nothing is done on Windows (the directories can not be synced)
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rename Renames the temp file over the target. On Windows the rename is retried, and if the target is still open it is written in place (not atomic) and the temp file removed
+func rename(tmpFileName string, fileName string, data []byte, perm os.FileMode) error {
+	err := os.Rename(tmpFileName, fileName)
+	if err == nil || runtime.GOOS != "windows" {
+		return err
+	}
+
+	for i := 0; i < windowsRenameRetries; i++ {
+		time.Sleep(windowsRenameRetryDelay)
+		if err = os.Rename(tmpFileName, fileName); err == nil {
+			return nil
+		}
+	}
+
+	if err = ioutil.WriteFile(fileName, data, perm); err != nil {
+		return err
+	}
+	return os.Remove(tmpFileName)
+}
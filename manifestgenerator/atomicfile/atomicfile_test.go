@@ -0,0 +1,64 @@
+package atomicfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "chunklist.m3u8")
+
+	for _, data := range []string{"#EXTM3U\n#EXT-X-VERSION:3\n", "#EXTM3U\n"} {
+		if err := WriteFile(fileName, []byte(data), 0644, true); err != nil {
+			t.Fatal("Error writing file. Err: ", err)
+		}
+		got, err := ioutil.ReadFile(fileName)
+		if err != nil || string(got) != data {
+			t.Errorf("File data is not correct, got: %s, want: %s.", got, data)
+		}
+	}
+
+	info, _ := os.Stat(fileName)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("File mode is not correct, got: %v, want: %v.", info.Mode().Perm(), os.FileMode(0644))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Temp files left in the directory, got: %d files, want: 1.", len(files))
+	}
+}
+
+func TestWriteFileNoPartialReads(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "chunklist.m3u8")
+	long := bytes.Repeat([]byte("#EXTINF:4.00000000,\nchunk_00000.ts\n"), 2000)
+	short := []byte("#EXTM3U\n")
+	WriteFile(fileName, long, 0644, false)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				WriteFile(fileName, short, 0644, false)
+			} else {
+				WriteFile(fileName, long, 0644, false)
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		got, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal("Error reading file. Err: ", err)
+		}
+		if !bytes.Equal(got, long) && !bytes.Equal(got, short) {
+			t.Fatalf("Partial file read, got: %d bytes.", len(got))
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,310 @@
+package captions
+
+import (
+	"sort"
+)
+
+const (
+	// MaxPESHeaderBytes Bytes of each video PES scanned for caption data (the SEI / user data are before the picture data)
+	MaxPESHeaderBytes = 16 * 1024
+
+	// ReorderFrames Frames buffered to decode the caption data in presentation order (B frames)
+	ReorderFrames = 8
+
+	// h264SEINALType H.264 SEI NAL unit type
+	h264SEINALType = 6
+
+	// hevcPrefixSEINALType HEVC prefix SEI NAL unit type
+	hevcPrefixSEINALType = 39
+
+	// mpeg2UserDataStartCode MPEG-2 video user_data start code
+	mpeg2UserDataStartCode = 0xB2
+
+	// seiUserDataRegisteredType SEI user_data_registered_itu_t_t35 payload type
+	seiUserDataRegisteredType = 4
+
+	// atscCCDataType ATSC A/53 user_data_type_code of cc_data
+	atscCCDataType = 0x03
+)
+
+// atscIdentifier ATSC A/53 user_identifier ("GA94")
+var atscIdentifier = []byte("GA94")
+
+// Codecs Video codec of the stream, it indicates where the caption data is
+type Codecs int
+
+const (
+	// CodecH264 h264, SEI NAL units
+	CodecH264 Codecs = iota
+
+	// CodecHEVC h265, prefix SEI NAL units
+	CodecHEVC
+
+	// CodecMPEG2 MPEG-2 video, picture user data
+	CodecMPEG2
+)
+
+// Cue Caption displayed from StartS to EndS (PTS in seconds)
+type Cue struct {
+	StartS float64
+	EndS   float64
+	Text   string
+}
+
+// frame Caption data (CEA-608 field 1 byte pairs) of a video PES
+type frame struct {
+	ptsS  float64
+	pairs [][2]byte
+}
+
+// Captions Extracts the CEA-608 captions (CC1) carried in the video stream (ATSC A/53 cc_data, CEA-708 services are not decoded, only their 608 compatibility bytes)
+type Captions struct {
+	codec Codecs
+
+	// PES being received (only the 1st MaxPESHeaderBytes) and its PTS
+	pes     []byte
+	pesPTSS float64
+
+	// Frames pending to decode, in PTS order
+	frames []frame
+
+	decoder decoder
+}
+
+// New Creates a captions extractor instance
+func New() Captions {
+	return Captions{CodecH264, []byte{}, -1.0, []frame{}, newDecoder()}
+}
+
+// SetCodec Sets the video codec of the stream
+func (c *Captions) SetCodec(codec Codecs) {
+	c.codec = codec
+}
+
+// Reset Discards the data received and the cues (Ex: timestamps discontinuity)
+func (c *Captions) Reset() {
+	c.pes = c.pes[:0]
+	c.pesPTSS = -1.0
+	c.frames = c.frames[:0]
+	c.decoder.reset()
+}
+
+// AddPayload Adds the TS payload of a video packet, isPESStart is the payload_unit_start_indicator and ptsS the PES PTS (< 0 if not present)
+func (c *Captions) AddPayload(payload []byte, isPESStart bool, ptsS float64) {
+	if isPESStart {
+		c.endPES()
+
+		// PES header
+		if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+			return
+		}
+		headerLength := 9 + int(payload[8])
+		if headerLength > len(payload) {
+			return
+		}
+		payload = payload[headerLength:]
+		c.pesPTSS = ptsS
+	} else if c.pesPTSS < 0 {
+		return
+	}
+
+	if pending := MaxPESHeaderBytes - len(c.pes); pending > 0 {
+		if len(payload) > pending {
+			payload = payload[:pending]
+		}
+		c.pes = append(c.pes, payload...)
+	}
+}
+
+// endPES Extracts the caption data of the PES received
+func (c *Captions) endPES() {
+	if c.pesPTSS >= 0 {
+		pairs := ExtractCCData(c.pes, c.codec)
+		if len(pairs) > 0 {
+			c.addFrame(frame{c.pesPTSS, pairs})
+		}
+	}
+	c.pes = c.pes[:0]
+	c.pesPTSS = -1.0
+}
+
+func (c *Captions) addFrame(f frame) {
+	i := sort.Search(len(c.frames), func(i int) bool { return c.frames[i].ptsS > f.ptsS })
+	c.frames = append(c.frames, frame{})
+	copy(c.frames[i+1:], c.frames[i:])
+	c.frames[i] = f
+
+	if len(c.frames) > ReorderFrames {
+		c.decodeFrame()
+	}
+}
+
+func (c *Captions) decodeFrame() {
+	f := c.frames[0]
+	c.frames = c.frames[1:]
+	for _, pair := range f.pairs {
+		c.decoder.decode(f.ptsS, pair[0], pair[1])
+	}
+}
+
+// Cues Returns the cues displayed in [fromS, toS) (PTS in seconds) clipped to it. The cues are forgotten once they are returned for their end
+func (c *Captions) Cues(fromS float64, toS float64) []Cue {
+	// Data before toS can not wait more
+	for len(c.frames) > 0 && c.frames[0].ptsS < toS {
+		c.decodeFrame()
+	}
+
+	return c.decoder.takeCues(fromS, toS)
+}
+
+// ExtractCCData Returns the CEA-608 field 1 byte pairs of the ATSC A/53 cc_data found in the video ES data (H.264 / HEVC SEI or MPEG-2 user data)
+func ExtractCCData(es []byte, codec Codecs) [][2]byte {
+	pairs := [][2]byte{}
+
+	start := nextStartCode(es, 0)
+	for start >= 0 {
+		end := nextStartCode(es, start)
+		unitEnd := len(es)
+		if end >= 0 {
+			unitEnd = end - 3
+		}
+		unit := es[start:unitEnd]
+
+		if len(unit) > 0 {
+			switch codec {
+			case CodecHEVC:
+				nalType := (unit[0] >> 1) & 0x3F
+				if nalType < 32 {
+					// Picture data
+					return pairs
+				}
+				if nalType == hevcPrefixSEINALType && len(unit) > 2 {
+					pairs = append(pairs, seiCCData(unit[2:])...)
+				}
+			case CodecMPEG2:
+				if unit[0] >= 0x01 && unit[0] <= 0xAF {
+					// Slice
+					return pairs
+				}
+				if unit[0] == mpeg2UserDataStartCode && len(unit) > 5 && string(unit[1:5]) == string(atscIdentifier) {
+					pairs = append(pairs, atscCCData(unit[5:])...)
+				}
+			default:
+				nalType := unit[0] & 0x1F
+				if nalType >= 1 && nalType <= 5 {
+					// Picture data
+					return pairs
+				}
+				if nalType == h264SEINALType {
+					pairs = append(pairs, seiCCData(unit[1:])...)
+				}
+			}
+		}
+
+		start = end
+	}
+
+	return pairs
+}
+
+// nextStartCode Returns the position after the next start code (00 00 01) from pos, -1 if there is none
+func nextStartCode(data []byte, pos int) int {
+	for i := pos; i+2 < len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			return i + 3
+		}
+	}
+
+	return -1
+}
+
+// seiCCData Returns the caption data of the SEI messages (NAL unit payload without header)
+func seiCCData(nal []byte) [][2]byte {
+	pairs := [][2]byte{}
+	rbsp := removeEmulationPrevention(nal)
+
+	pos := 0
+	for pos < len(rbsp) && rbsp[pos] != 0x80 {
+		payloadType := 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadType = payloadType + 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadType = payloadType + int(rbsp[pos])
+		pos++
+
+		payloadSize := 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadSize = payloadSize + 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadSize = payloadSize + int(rbsp[pos])
+		pos++
+
+		if pos+payloadSize > len(rbsp) {
+			break
+		}
+		payload := rbsp[pos : pos+payloadSize]
+		pos = pos + payloadSize
+
+		// itu_t_t35_country_code (USA), itu_t_t35_provider_code (ATSC), user_identifier
+		if payloadType == seiUserDataRegisteredType && len(payload) > 7 && payload[0] == 0xB5 && payload[1] == 0x00 && payload[2] == 0x31 && string(payload[3:7]) == string(atscIdentifier) {
+			pairs = append(pairs, atscCCData(payload[7:])...)
+		}
+	}
+
+	return pairs
+}
+
+// removeEmulationPrevention Removes the emulation_prevention_three_byte (00 00 03)
+func removeEmulationPrevention(data []byte) []byte {
+	ret := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		ret = append(ret, b)
+	}
+
+	return ret
+}
+
+// atscCCData Returns the CEA-608 field 1 byte pairs of the ATSC user data (from user_data_type_code)
+func atscCCData(data []byte) [][2]byte {
+	pairs := [][2]byte{}
+	if len(data) < 3 || data[0] != atscCCDataType {
+		return pairs
+	}
+
+	// process_cc_data_flag
+	if data[1]&0x40 == 0 {
+		return pairs
+	}
+	ccCount := int(data[1] & 0x1F)
+
+	// em_data
+	pos := 3
+	for n := 0; n < ccCount && pos+3 <= len(data); n++ {
+		ccValid := data[pos]&0x04 != 0
+		ccType := data[pos] & 0x03
+		if ccValid && ccType == 0 {
+			pairs = append(pairs, [2]byte{data[pos+1], data[pos+2]})
+		}
+		pos = pos + 3
+	}
+
+	return pairs
+}
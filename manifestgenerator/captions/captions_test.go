@@ -0,0 +1,146 @@
+package captions
+
+import (
+	"reflect"
+	"testing"
+)
+
+// CEA-608 CC1 control codes
+var (
+	rcl = [2]byte{0x14, 0x20}
+	eoc = [2]byte{0x14, 0x2F}
+	edm = [2]byte{0x14, 0x2C}
+	ru2 = [2]byte{0x14, 0x25}
+	cr  = [2]byte{0x14, 0x2D}
+	pac = [2]byte{0x14, 0x70}
+)
+
+// textPairs Returns the byte pairs of the text
+func textPairs(text string) [][2]byte {
+	pairs := [][2]byte{}
+	for i := 0; i < len(text); i = i + 2 {
+		pair := [2]byte{text[i], 0}
+		if i+1 < len(text) {
+			pair[1] = text[i+1]
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// h264PES Returns a video PES (start) with the pairs in a SEI cc_data (control codes are doubled), and an IDR slice
+func h264PES(pairs ...[2]byte) []byte {
+	ccData := []byte{}
+	for _, pair := range pairs {
+		ccData = append(ccData, 0xFC, pair[0], pair[1])
+		if pair[0] >= 0x10 && pair[0] <= 0x1F {
+			ccData = append(ccData, 0xFC, pair[0], pair[1])
+		}
+	}
+	ccCount := len(ccData) / 3
+
+	sei := []byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x40 | byte(ccCount), 0xFF}
+	sei = append(sei, ccData...)
+	sei = append(sei, 0xFF)
+
+	pes := []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01}
+	pes = append(pes, 0x00, 0x00, 0x00, 0x01, 0x09, 0xF0)
+	pes = append(pes, 0x00, 0x00, 0x01, 0x06, seiUserDataRegisteredType, byte(len(sei)))
+	pes = append(pes, sei...)
+	pes = append(pes, 0x80)
+	pes = append(pes, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x00)
+
+	return pes
+}
+
+func TestExtractCCData(t *testing.T) {
+	pairs := append([][2]byte{rcl}, textPairs("HELLO")...)
+	pes := h264PES(pairs...)
+
+	got := ExtractCCData(pes[14:], CodecH264)
+
+	xpected := append([][2]byte{rcl, rcl}, textPairs("HELLO")...)
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("Caption data is not correct, got: %v, want: %v.", got, xpected)
+	}
+
+	// Same data in MPEG-2 user data
+	userData := append([]byte{0x00, 0x00, 0x01, 0xB2, 'G', 'A', '9', '4', 0x03, 0x40 | 2, 0xFF, 0xFC, 0x14, 0x20, 0xF9, 0x80, 0x80, 0xFF}, 0x00, 0x00, 0x01, 0x01, 0x12)
+	got = ExtractCCData(userData, CodecMPEG2)
+	xpected = [][2]byte{rcl}
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("MPEG-2 caption data is not correct (field 2 ignored), got: %v, want: %v.", got, xpected)
+	}
+}
+
+func TestCaptionsPopOn(t *testing.T) {
+	c := New()
+
+	// Loaded at 1s, displayed at 2s (EOC), cleared at 5s (EDM)
+	c.AddPayload(h264PES(append([][2]byte{rcl, pac}, textPairs("HELLO")...)...), true, 1.0)
+	c.AddPayload(h264PES(append([][2]byte{pac}, append(textPairs("WORLD"), eoc)...)...), true, 2.0)
+	c.AddPayload(h264PES(edm), true, 5.0)
+	c.AddPayload(h264PES(), true, 6.0)
+
+	got := c.Cues(0, 4.0)
+	xpected := []Cue{{2.0, 4.0, "HELLO\nWORLD"}}
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("Cues of the 1st segment are not correct, got: %v, want: %v.", got, xpected)
+	}
+
+	got = c.Cues(4.0, 8.0)
+	xpected = []Cue{{4.0, 5.0, "HELLO\nWORLD"}}
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("Cues of the 2nd segment are not correct, got: %v, want: %v.", got, xpected)
+	}
+
+	got = c.Cues(8.0, 12.0)
+	if len(got) != 0 {
+		t.Errorf("Cues of the 3rd segment are not correct, got: %v, want: [].", got)
+	}
+}
+
+func TestCaptionsRollUp(t *testing.T) {
+	c := New()
+
+	c.AddPayload(h264PES(append([][2]byte{ru2, cr}, textPairs("ONE")...)...), true, 1.0)
+	c.AddPayload(h264PES(append([][2]byte{cr}, textPairs("TWO")...)...), true, 2.0)
+	c.AddPayload(h264PES(append([][2]byte{cr}, textPairs("THREE")...)...), true, 3.0)
+	c.AddPayload(h264PES(), true, 4.0)
+
+	got := c.Cues(0, 4.0)
+	xpected := []Cue{{1.0, 2.0, "ONE"}, {2.0, 3.0, "ONE\nTWO"}, {3.0, 4.0, "TWO\nTHREE"}}
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("Roll-up cues are not correct, got: %v, want: %v.", got, xpected)
+	}
+}
+
+func TestCaptionsReorder(t *testing.T) {
+	c := New()
+
+	// Decode order is not presentation order (B frames)
+	c.AddPayload(h264PES(append([][2]byte{rcl}, textPairs("AB")...)...), true, 1.0)
+	c.AddPayload(h264PES(eoc), true, 1.2)
+	c.AddPayload(h264PES(textPairs("CD")...), true, 1.1)
+	c.AddPayload(h264PES(), true, 3.0)
+
+	got := c.Cues(0, 2.0)
+	xpected := []Cue{{1.2, 2.0, "ABCD"}}
+	if !reflect.DeepEqual(got, xpected) {
+		t.Errorf("Cues are not correct, got: %v, want: %v.", got, xpected)
+	}
+}
+
+func TestWebVTT(t *testing.T) {
+	got := string(WebVTT([]Cue{{10.5, 12.25, "A < B\nC"}}, 10.0))
+	xpected := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:00.500 --> 00:00:02.250\nA &lt; B\nC\n"
+	if got != xpected {
+		t.Errorf("WebVTT is not correct, got: %s, want: %s.", got, xpected)
+	}
+
+	got = string(WebVTT([]Cue{}, -1))
+	xpected = "WEBVTT\n"
+	if got != xpected {
+		t.Errorf("Empty WebVTT is not correct, got: %s, want: %s.", got, xpected)
+	}
+}
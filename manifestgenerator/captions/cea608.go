@@ -0,0 +1,291 @@
+package captions
+
+import (
+	"strings"
+)
+
+// captionModes CEA-608 caption mode, it indicates which memory is written
+type captionModes int
+
+const (
+	// modePopOn Text is loaded in the non displayed memory and shown at EOC
+	modePopOn captionModes = iota
+
+	// modeRollUp Text is written in the displayed memory, CR scrolls the rows
+	modeRollUp
+
+	// modePaintOn Text is written in the displayed memory
+	modePaintOn
+)
+
+// basicChars CEA-608 characters that are different from ASCII
+var basicChars = map[byte]string{0x2A: "á", 0x5C: "é", 0x5E: "í", 0x5F: "ó", 0x60: "ú", 0x7B: "ç", 0x7C: "÷", 0x7D: "Ñ", 0x7E: "ñ", 0x7F: "█"}
+
+// specialChars CEA-608 special characters (0x11 0x30 - 0x3F)
+var specialChars = []string{"®", "°", "½", "¿", "™", "¢", "£", "♪", "à", " ", "è", "â", "ê", "î", "ô", "û"}
+
+// extendedChars CEA-608 extended characters (0x12 / 0x13 0x20 - 0x3F), they replace the previous character
+var extendedChars = [][]string{
+	{"Á", "É", "Ó", "Ú", "Ü", "ü", "‘", "¡", "*", "'", "—", "©", "℠", "•", "“", "”", "À", "Â", "Ç", "È", "Ê", "Ë", "ë", "Î", "Ï", "ï", "Ô", "Ù", "ù", "Û", "«", "»"},
+	{"Ã", "ã", "Í", "Ì", "ì", "Ò", "ò", "Õ", "õ", "{", "}", "\\", "^", "_", "|", "~", "Ä", "ä", "Ö", "ö", "ß", "¥", "¤", "¦", "Å", "å", "Ø", "ø", "┌", "┐", "└", "┘"},
+}
+
+// decoder CEA-608 decoder of the data channel 1 (CC1), the positioning and styles are ignored (each PAC starts a new row)
+type decoder struct {
+	mode         captionModes
+	rollUpRows   int
+	displayed    []string
+	nonDisplayed []string
+
+	// Data channel of the last control code, and the last control code (they are usually sent twice)
+	isChannel1 bool
+	lastCtrl   [2]byte
+
+	// Start of the displayed cue (< 0 nothing displayed), and the cues already finished
+	cueStartS float64
+	cues      []Cue
+}
+
+func newDecoder() decoder {
+	return decoder{modePopOn, 2, nil, nil, true, [2]byte{}, -1.0, nil}
+}
+
+// reset Clears the memories and the cues (Ex: timestamps discontinuity)
+func (d *decoder) reset() {
+	*d = newDecoder()
+}
+
+// decode Processes a CEA-608 byte pair (field 1) presented at ptsS
+func (d *decoder) decode(ptsS float64, b1 byte, b2 byte) {
+	// Remove parity
+	b1 = b1 & 0x7F
+	b2 = b2 & 0x7F
+
+	if b1 == 0 && b2 == 0 {
+		// Padding
+		return
+	}
+
+	if b1 >= 0x10 && b1 <= 0x1F {
+		if d.lastCtrl == [2]byte{b1, b2} {
+			// Repeated control code
+			d.lastCtrl = [2]byte{}
+			return
+		}
+		d.lastCtrl = [2]byte{b1, b2}
+
+		d.isChannel1 = b1&0x08 == 0
+		if d.isChannel1 {
+			d.control(ptsS, b1, b2)
+		}
+		return
+	}
+	d.lastCtrl = [2]byte{}
+
+	if !d.isChannel1 {
+		return
+	}
+	for _, b := range []byte{b1, b2} {
+		if b >= 0x20 {
+			d.writeText(ptsS, basicChar(b))
+		}
+	}
+}
+
+func basicChar(b byte) string {
+	if s, found := basicChars[b]; found {
+		return s
+	}
+
+	return string(rune(b))
+}
+
+// control Processes a control code of the channel 1
+func (d *decoder) control(ptsS float64, b1 byte, b2 byte) {
+	switch {
+	case b1 == 0x14 && b2 >= 0x20 && b2 <= 0x2F:
+		d.command(ptsS, b2)
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+		// Tab offset
+		d.writeText(ptsS, strings.Repeat(" ", int(b2-0x20)))
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2F:
+		// Mid-row code (style), it is displayed as a space
+		d.writeText(ptsS, " ")
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3F:
+		d.writeText(ptsS, specialChars[b2-0x30])
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3F:
+		d.backspace()
+		d.writeText(ptsS, extendedChars[b1-0x12][b2-0x20])
+	case b1 >= 0x10 && b1 <= 0x17 && b2 >= 0x40 && b2 <= 0x7F:
+		// Preamble address code
+		d.newRow()
+	}
+}
+
+// command Processes a miscellaneous control code
+func (d *decoder) command(ptsS float64, b2 byte) {
+	switch b2 {
+	case 0x20:
+		// RCL Resume caption loading
+		d.setMode(ptsS, modePopOn)
+	case 0x21:
+		// BS Backspace
+		d.backspace()
+	case 0x25, 0x26, 0x27:
+		// RU2, RU3, RU4 Roll-up captions
+		d.setMode(ptsS, modeRollUp)
+		d.rollUpRows = int(b2-0x25) + 2
+	case 0x29:
+		// RDC Resume direct captioning
+		d.setMode(ptsS, modePaintOn)
+	case 0x2C:
+		// EDM Erase displayed memory
+		d.closeCue(ptsS)
+		d.displayed = nil
+	case 0x2D:
+		// CR Carriage return
+		d.carriageReturn(ptsS)
+	case 0x2E:
+		// ENM Erase non-displayed memory
+		d.nonDisplayed = nil
+	case 0x2F:
+		// EOC End of caption, the memories are swapped
+		d.closeCue(ptsS)
+		d.displayed, d.nonDisplayed = d.nonDisplayed, d.displayed
+		d.openCue(ptsS)
+	}
+}
+
+// setMode Changes the caption mode, entering or leaving roll-up clears the screen
+func (d *decoder) setMode(ptsS float64, mode captionModes) {
+	if mode != d.mode && (mode == modeRollUp || d.mode == modeRollUp) {
+		d.closeCue(ptsS)
+		d.displayed = nil
+		d.nonDisplayed = nil
+	}
+	d.mode = mode
+}
+
+// memory Returns the memory written in the current mode
+func (d *decoder) memory() *[]string {
+	if d.mode == modePopOn {
+		return &d.nonDisplayed
+	}
+
+	return &d.displayed
+}
+
+func (d *decoder) writeText(ptsS float64, s string) {
+	mem := d.memory()
+	if len(*mem) <= 0 {
+		*mem = append(*mem, "")
+	}
+	(*mem)[len(*mem)-1] = (*mem)[len(*mem)-1] + s
+
+	if d.mode != modePopOn && d.cueStartS < 0 {
+		d.cueStartS = ptsS
+	}
+}
+
+func (d *decoder) backspace() {
+	mem := d.memory()
+	if len(*mem) <= 0 {
+		return
+	}
+	row := []rune((*mem)[len(*mem)-1])
+	if len(row) > 0 {
+		(*mem)[len(*mem)-1] = string(row[:len(row)-1])
+	}
+}
+
+func (d *decoder) newRow() {
+	mem := d.memory()
+	if len(*mem) > 0 && (*mem)[len(*mem)-1] != "" {
+		*mem = append(*mem, "")
+	}
+}
+
+// carriageReturn In roll-up mode the displayed cue ends and the rows scroll up (the new cue starts with the remaining rows)
+func (d *decoder) carriageReturn(ptsS float64) {
+	if d.mode != modeRollUp {
+		d.newRow()
+		return
+	}
+
+	d.closeCue(ptsS)
+	d.displayed = append(d.displayed, "")
+	if len(d.displayed) > d.rollUpRows {
+		d.displayed = d.displayed[len(d.displayed)-d.rollUpRows:]
+	}
+	d.openCue(ptsS)
+}
+
+// openCue Starts a cue if there is text displayed
+func (d *decoder) openCue(ptsS float64) {
+	if textOf(d.displayed) != "" {
+		d.cueStartS = ptsS
+	}
+}
+
+// closeCue Finishes the displayed cue at ptsS
+func (d *decoder) closeCue(ptsS float64) {
+	if d.cueStartS < 0 {
+		return
+	}
+
+	text := textOf(d.displayed)
+	if text != "" && ptsS > d.cueStartS {
+		d.cues = append(d.cues, Cue{d.cueStartS, ptsS, text})
+	}
+	d.cueStartS = -1.0
+}
+
+// textOf Returns the text of the memory rows (empty rows removed)
+func textOf(rows []string) string {
+	lines := []string{}
+	for _, row := range rows {
+		if line := strings.TrimSpace(row); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// takeCues Returns the cues displayed in [fromS, toS) clipped to it (the cue still displayed ends at toS), the cues that do not continue after toS are forgotten
+func (d *decoder) takeCues(fromS float64, toS float64) []Cue {
+	ret := []Cue{}
+
+	pending := d.cues[:0]
+	for _, c := range d.cues {
+		if c.EndS > fromS && c.StartS < toS {
+			ret = append(ret, Cue{maxFloat(c.StartS, fromS), minFloat(c.EndS, toS), c.Text})
+		}
+		if c.EndS > toS {
+			pending = append(pending, c)
+		}
+	}
+	d.cues = pending
+
+	if d.cueStartS >= 0 && d.cueStartS < toS {
+		if text := textOf(d.displayed); text != "" {
+			ret = append(ret, Cue{maxFloat(d.cueStartS, fromS), toS, text})
+		}
+	}
+
+	return ret
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,40 @@
+package captions
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxPTSValue 33 bits of PTS (90KHz)
+const maxPTSValue int64 = 1 << 33
+
+// WebVTT Returns the WebVTT file of a segment that starts at startS (PTS in seconds, < 0 unknown), the cue times are relative to it (X-TIMESTAMP-MAP)
+func WebVTT(cues []Cue, startS float64) []byte {
+	var b strings.Builder
+
+	b.WriteString("WEBVTT\n")
+	if startS >= 0 {
+		b.WriteString(fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", int64(math.Round(startS*90000))%maxPTSValue))
+	} else {
+		startS = 0
+	}
+
+	for _, c := range cues {
+		b.WriteString("\n" + vttTime(c.StartS-startS) + " --> " + vttTime(c.EndS-startS) + "\n")
+		b.WriteString(vttEscape(c.Text) + "\n")
+	}
+
+	return []byte(b.String())
+}
+
+// vttTime Returns the WebVTT timestamp (hh:mm:ss.ttt)
+func vttTime(timeS float64) string {
+	ms := int64(math.Round(math.Max(timeS, 0) * 1000))
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
+
+func vttEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
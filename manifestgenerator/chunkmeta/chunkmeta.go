@@ -0,0 +1,114 @@
+package chunkmeta
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Version Version of the schema, it only changes if a field is removed or its meaning changes (new fields can be added)
+const Version = 1
+
+// FileExtension Extension of the metadata files
+const FileExtension = ".json"
+
+// SCTE35 SCTE-35 splice of the chunk (its EXT-X-DATERANGE)
+type SCTE35 struct {
+	// ID ID of the EXT-X-DATERANGE (splice event ID)
+	ID string `json:"id"`
+
+	// StartDate Wall clock time of the splice
+	StartDate time.Time `json:"startDate"`
+
+	// DurationS and PlannedDurationS Duration of the break in seconds, omitted if unknown
+	DurationS        *float64 `json:"durationS,omitempty"`
+	PlannedDurationS *float64 `json:"plannedDurationS,omitempty"`
+
+	// Cmd, Out and In Splice info section (hex, 0x prefixed) of the SCTE35-CMD, SCTE35-OUT and SCTE35-IN attributes, omitted if not present
+	Cmd string `json:"cmd,omitempty"`
+	Out string `json:"out,omitempty"`
+	In  string `json:"in,omitempty"`
+}
+
+// Chunk Metadata of a media chunk, saved in its sidecar file and in the index
+type Chunk struct {
+	// Version Schema version
+	Version int `json:"version"`
+
+	// SequenceNumber Index of the chunk (its media sequence number)
+	SequenceNumber uint64 `json:"sequenceNumber"`
+
+	// URI URI of the chunk in the chunklist
+	URI string `json:"uri"`
+
+	// StartPTSS PTS of the 1st sample of the chunk in seconds, -1 unknown
+	StartPTSS float64 `json:"startPtsS"`
+
+	// WallClockStart Wall clock time when the 1st data of the chunk was written
+	WallClockStart time.Time `json:"wallClockStart"`
+
+	// ProgramDateTime EXT-X-PROGRAM-DATE-TIME of the chunk, omitted if it is not set
+	ProgramDateTime *time.Time `json:"programDateTime,omitempty"`
+
+	// DurationS Duration of the chunk in seconds
+	DurationS float64 `json:"durationS"`
+
+	// Bytes Size of the chunk
+	Bytes uint64 `json:"bytes"`
+
+	// Keyframes Video random access points in the chunk (0 without video)
+	Keyframes int `json:"keyframes"`
+
+	// Discontinuity The chunk has an EXT-X-DISCONTINUITY
+	Discontinuity bool `json:"discontinuity"`
+
+	// SCTE35 SCTE-35 splices of the chunk, omitted if there are none
+	SCTE35 []SCTE35 `json:"scte35,omitempty"`
+}
+
+// Index Metadata of the chunks in the live window (all of them in VOD / event), oldest first
+type Index struct {
+	// Version Schema version
+	Version int `json:"version"`
+
+	// UpdatedAt Wall clock time when the index was saved
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Chunks Metadata of the chunks
+	Chunks []Chunk `json:"chunks"`
+}
+
+// FileName Returns the metadata filename of the chunk, the same one with the metadata extension
+func FileName(chunkFileName string) string {
+	return strings.TrimSuffix(chunkFileName, path.Ext(chunkFileName)) + FileExtension
+}
+
+// LiveStateFileName Default filename of the live state (in the chunklist directory)
+const LiveStateFileName = "live_state.json"
+
+// LiveStateChunk Media chunk being written
+type LiveStateChunk struct {
+	// SequenceNumber Index of the chunk (its media sequence number)
+	SequenceNumber uint64 `json:"sequenceNumber"`
+
+	// URI URI of the chunk in the chunklist (its final name)
+	URI string `json:"uri"`
+
+	// FileName File being written (with the in progress extension if it is set)
+	FileName string `json:"fileName"`
+
+	// Bytes Bytes written to the file so far
+	Bytes int `json:"bytes"`
+}
+
+// LiveState State of the media chunk being written, saved on every write
+type LiveState struct {
+	// Version Schema version
+	Version int `json:"version"`
+
+	// UpdatedAt Wall clock time when the state was saved
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Chunk Chunk being written, null between chunks (until the next one has data) and after the last one
+	Chunk *LiveStateChunk `json:"chunk"`
+}
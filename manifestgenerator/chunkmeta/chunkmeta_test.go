@@ -0,0 +1,30 @@
+package chunkmeta
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileName(t *testing.T) {
+	for fileName, xpected := range map[string]string{"results/chunk_00001.ts": "results/chunk_00001.json", "2006/01/chunk.m4s": "2006/01/chunk.json", "chunk": "chunk.json"} {
+		if got := FileName(fileName); got != xpected {
+			t.Errorf("Metadata filename of %s is not correct, got: %s, want: %s.", fileName, got, xpected)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	durationS := 30.0
+	data, _ := json.Marshal(Chunk{Version: Version, SequenceNumber: 7, URI: "chunk_00007.ts", StartPTSS: 1.5, WallClockStart: time.Unix(0, 0).UTC(), DurationS: 4, Bytes: 1000, Keyframes: 2, Discontinuity: true, SCTE35: []SCTE35{{ID: "1", StartDate: time.Unix(0, 0).UTC(), DurationS: &durationS, Out: "0xFC"}}})
+
+	// The fields without value are omitted
+	xpected := `{"version":1,"sequenceNumber":7,"uri":"chunk_00007.ts","startPtsS":1.5,"wallClockStart":"1970-01-01T00:00:00Z","durationS":4,"bytes":1000,"keyframes":2,"discontinuity":true,"scte35":[{"id":"1","startDate":"1970-01-01T00:00:00Z","durationS":30,"out":"0xFC"}]}`
+	if got := string(data); got != xpected {
+		t.Errorf("Metadata is not correct, got: %s, want: %s.", got, xpected)
+	}
+	if data, _ := json.Marshal(Chunk{}); strings.Contains(string(data), "scte35") || strings.Contains(string(data), "programDateTime") {
+		t.Errorf("Metadata without splices / PDT is not correct, got: %s.", data)
+	}
+}
@@ -0,0 +1,97 @@
+package chunkverify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+)
+
+// syncByte 1st byte of every TS packet
+const syncByte = 0x47
+
+// Expected What the TS data of a chunk has to be
+type Expected struct {
+	// Packets TS packets written to the chunk, < 0 not checked
+	Packets int
+
+	// StartsWithPSI The chunk starts with the PAT and its PMT (init data at every chunk start)
+	StartsWithPSI bool
+
+	// VideoPID and IsRandomAccess PID of the video (< 0 none) and its keyframe detection, with StartsWithKeyframe the 1st video PES of the chunk has to be a keyframe
+	VideoPID           int
+	IsRandomAccess     func(p *tspacket.TsPacket) bool
+	StartsWithKeyframe bool
+
+	// PTSPID PID whose PTS span has to be DurationS (the EXTINF) within ToleranceS, < 0 not checked
+	PTSPID     int
+	DurationS  float64
+	ToleranceS float64
+}
+
+// Verify Checks the data of the chunk: whole packets with the sync byte, the number of packets, the start (PSI / keyframe) and the duration of the PTS. Returns the 1st failed check
+func Verify(data []byte, expected Expected) error {
+	if len(data) <= 0 {
+		return errors.New("empty chunk")
+	}
+	if len(data)%tspacket.TsDefaultPacketSize != 0 {
+		return fmt.Errorf("chunk size %d is not a multiple of %d (truncated packet)", len(data), tspacket.TsDefaultPacketSize)
+	}
+	packets := len(data) / tspacket.TsDefaultPacketSize
+	if expected.Packets >= 0 && packets != expected.Packets {
+		return fmt.Errorf("chunk has %d packets, %d written", packets, expected.Packets)
+	}
+
+	pmtPID := -1
+	isVideoChecked := false
+	firstPTSS, minPTSS, maxPTSS := -1.0, 0.0, 0.0
+	for i := 0; i < packets; i++ {
+		buf := data[i*tspacket.TsDefaultPacketSize : (i+1)*tspacket.TsDefaultPacketSize]
+		if buf[0] != syncByte {
+			return fmt.Errorf("packet %d without sync byte (0x%02X)", i, buf[0])
+		}
+
+		p := tspacket.New(tspacket.TsDefaultPacketSize)
+		p.AddData(buf)
+		p.Parse(pmtPID)
+		pID := p.GetPID()
+		if expected.StartsWithPSI && i == 0 {
+			if pID != 0 {
+				return fmt.Errorf("chunk starts with PID %d, not the PAT", pID)
+			}
+			pmtPID = p.GetPATdata()
+		} else if expected.StartsWithPSI && i == 1 && pID != pmtPID {
+			return fmt.Errorf("PAT of the chunk is followed by PID %d, not the PMT (PID %d)", pID, pmtPID)
+		}
+
+		if expected.StartsWithKeyframe && !isVideoChecked && pID == expected.VideoPID && p.IsPayloadUnitStart() {
+			isVideoChecked = true
+			if expected.IsRandomAccess != nil && !expected.IsRandomAccess(&p) {
+				return fmt.Errorf("1st video packet (packet %d) is not a keyframe", i)
+			}
+		}
+
+		if expected.PTSPID >= 0 && pID == expected.PTSPID {
+			if ptsS := p.GetPESPTS(); ptsS >= 0 {
+				if firstPTSS < 0 {
+					firstPTSS = ptsS
+				}
+				offsetS := tspacket.TimeDiffS(firstPTSS, ptsS, tspacket.MaxPCRSValue)
+				minPTSS, maxPTSS = math.Min(minPTSS, offsetS), math.Max(maxPTSS, offsetS)
+			}
+		}
+	}
+
+	if expected.PTSPID >= 0 {
+		if firstPTSS < 0 {
+			return fmt.Errorf("chunk without PTS of PID %d", expected.PTSPID)
+		}
+		// The span does not include the duration of the last sample
+		if spanS := maxPTSS - minPTSS; math.Abs(expected.DurationS-spanS) > expected.ToleranceS {
+			return fmt.Errorf("PTS span %.3fs does not match the duration %.3fs (tolerance %.3fs)", spanS, expected.DurationS, expected.ToleranceS)
+		}
+	}
+
+	return nil
+}
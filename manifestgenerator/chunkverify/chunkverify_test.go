@@ -0,0 +1,44 @@
+package chunkverify
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+)
+
+func readFixture(t *testing.T, packets int) []byte {
+	data, err := os.ReadFile("../../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading fixture. Err: ", err)
+	}
+	return data[:packets*tspacket.TsDefaultPacketSize]
+}
+
+func TestVerify(t *testing.T) {
+	data := readFixture(t, 100)
+
+	if err := Verify(data, Expected{Packets: 100, VideoPID: -1, PTSPID: -1}); err != nil {
+		t.Errorf("Valid chunk failed the verification. Err: %v", err)
+	}
+
+	corrupted := append([]byte{}, data...)
+	corrupted[10*tspacket.TsDefaultPacketSize] = 0
+	for name, test := range map[string]struct {
+		data     []byte
+		expected Expected
+		xpected  string
+	}{
+		"empty":     {nil, Expected{Packets: -1, PTSPID: -1}, "empty"},
+		"truncated": {data[:len(data)-100], Expected{Packets: -1, PTSPID: -1}, "multiple"},
+		"packets":   {data, Expected{Packets: 101, PTSPID: -1}, "packets"},
+		"sync byte": {corrupted, Expected{Packets: 100, PTSPID: -1}, "sync byte"},
+		"no PTS":    {data, Expected{Packets: 100, PTSPID: 8000}, "without PTS"},
+	} {
+		err := Verify(test.data, test.expected)
+		if err == nil || !strings.Contains(err.Error(), test.xpected) {
+			t.Errorf("Verification %s is not correct, got: %v, want: %s.", name, err, test.xpected)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package dvbtime
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// TimePID PID of the DVB time_date_section (TDT) and time_offset_section (TOT)
+	TimePID = 0x14
+
+	// TDTTableID Table ID of the time_date_section
+	TDTTableID uint8 = 0x70
+
+	// TOTTableID Table ID of the time_offset_section
+	TOTTableID uint8 = 0x73
+
+	// utcTimeSize Bytes of the UTC_time (16 bits MJD + 24 bits BCD hh:mm:ss)
+	utcTimeSize = 5
+)
+
+// mjdEpoch Day 0 of the Modified Julian Date
+var mjdEpoch = time.Date(1858, time.November, 17, 0, 0, 0, 0, time.UTC)
+
+// Parse Returns the UTC time of a TDT or TOT section (the TOT CRC and descriptors are not checked)
+func Parse(section []byte) (time.Time, error) {
+	if len(section) < 3+utcTimeSize {
+		return time.Time{}, errors.New("Section too short")
+	}
+	if section[0] != TDTTableID && section[0] != TOTTableID {
+		return time.Time{}, fmt.Errorf("Not a TDT / TOT section, table ID: 0x%X", section[0])
+	}
+
+	return parseUTCTime(section[3 : 3+utcTimeSize])
+}
+
+// parseUTCTime Parses the 40 bits UTC_time (EN 300 468 annex C)
+func parseUTCTime(b []byte) (time.Time, error) {
+	mjd := int(b[0])<<8 | int(b[1])
+
+	hms := [3]int{}
+	for i := range hms {
+		high, low := int(b[2+i]>>4), int(b[2+i]&0x0F)
+		if high > 9 || low > 9 {
+			return time.Time{}, fmt.Errorf("Invalid BCD time: %X", b[2:])
+		}
+		hms[i] = high*10 + low
+	}
+	if hms[0] > 23 || hms[1] > 59 || hms[2] > 59 {
+		return time.Time{}, fmt.Errorf("Invalid time: %X", b[2:])
+	}
+
+	return mjdEpoch.AddDate(0, 0, mjd).Add(time.Duration(hms[0])*time.Hour + time.Duration(hms[1])*time.Minute + time.Duration(hms[2])*time.Second), nil
+}
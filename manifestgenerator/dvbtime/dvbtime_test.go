@@ -0,0 +1,44 @@
+package dvbtime
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestParseTDT(t *testing.T) {
+	// EN 300 468 annex C example, 93/10/13 12:45:00
+	section, _ := hex.DecodeString("707005C079124500")
+
+	got, err := Parse(section)
+	if err != nil {
+		t.Fatal("Error parsing TDT. Err: ", err)
+	}
+	xpected := time.Date(1993, time.October, 13, 12, 45, 0, 0, time.UTC)
+	if !got.Equal(xpected) {
+		t.Errorf("TDT time is not correct, got: %v, want: %v.", got, xpected)
+	}
+}
+
+func TestParseTOT(t *testing.T) {
+	// 2020/01/01 23:59:58, local_time_offset_descriptor (ESP +01:00), CRC not set
+	section, _ := hex.DecodeString("73701A" + "E5E1235958" + "F00F" + "580D" + "455350020100" + "0000000000" + "0000" + "00000000")
+
+	got, err := Parse(section)
+	if err != nil {
+		t.Fatal("Error parsing TOT. Err: ", err)
+	}
+	xpected := time.Date(2020, time.January, 1, 23, 59, 58, 0, time.UTC)
+	if !got.Equal(xpected) {
+		t.Errorf("TOT time is not correct, got: %v, want: %v.", got, xpected)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, sample := range []string{"707005C079", "00B00D0001C100000001F000", "707005C0791245A0", "707005C079244500"} {
+		section, _ := hex.DecodeString(sample)
+		if _, err := Parse(section); err == nil {
+			t.Errorf("Invalid section accepted: %s.", sample)
+		}
+	}
+}
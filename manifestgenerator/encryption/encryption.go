@@ -0,0 +1,110 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// Methods Encryption method of the chunks (EXT-X-KEY METHOD)
+type Methods int
+
+const (
+	// MethodNone Chunks not encrypted
+	MethodNone Methods = iota
+
+	// MethodAES128 Whole chunks encrypted with AES-128 CBC (PKCS7 padding)
+	MethodAES128
+
+	// MethodSampleAES Only the elementary streams samples encrypted (H.264 NAL units and AAC frames), the TS headers and PSI are clear
+	MethodSampleAES
+)
+
+// String Returns the EXT-X-KEY METHOD value
+func (m Methods) String() string {
+	switch m {
+	case MethodAES128:
+		return "AES-128"
+	case MethodSampleAES:
+		return "SAMPLE-AES"
+	}
+	return "NONE"
+}
+
+// IVModes Source of the IV of every chunk
+type IVModes int
+
+const (
+	// IVMediaSequence The IV is the media sequence number of the chunk (not written in the EXT-X-KEY)
+	IVMediaSequence IVModes = iota
+
+	// IVRandom Random IV per chunk (written in the EXT-X-KEY)
+	IVRandom
+)
+
+// KeyLength Length (bytes) of the AES-128 keys and IVs
+const KeyLength = 16
+
+// NewKey Returns a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SequenceIV Returns the IV of the media sequence number (big-endian, RFC 8216 section 5.2)
+func SequenceIV(mediaSequence uint64) []byte {
+	iv := make([]byte, KeyLength)
+	binary.BigEndian.PutUint64(iv[8:], mediaSequence)
+	return iv
+}
+
+// RandomIV Returns a random IV
+func RandomIV() ([]byte, error) {
+	return NewKey()
+}
+
+// CBCEncrypter AES-128 CBC encrypter of a stream, the data is encrypted as it is added (the CBC chain continues between calls) and the last block is padded (PKCS7) at the end
+type CBCEncrypter struct {
+	mode    cipher.BlockMode
+	pending []byte
+}
+
+// NewCBCEncrypter Creates an encrypter of the key and IV
+func NewCBCEncrypter(key []byte, iv []byte) (*CBCEncrypter, error) {
+	if len(key) != KeyLength || len(iv) != KeyLength {
+		return nil, errors.New("AES-128 key and IV have to be 16 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CBCEncrypter{cipher.NewCBCEncrypter(block, iv), []byte{}}, nil
+}
+
+// Encrypt Returns the encrypted whole blocks of the data added (the rest is kept for the next call)
+func (e *CBCEncrypter) Encrypt(buf []byte) []byte {
+	e.pending = append(e.pending, buf...)
+
+	n := len(e.pending) - len(e.pending)%aes.BlockSize
+	out := make([]byte, n)
+	e.mode.CryptBlocks(out, e.pending[:n])
+	e.pending = append(e.pending[:0], e.pending[n:]...)
+
+	return out
+}
+
+// Final Returns the last block (the data kept with the PKCS7 padding), nothing can be encrypted after it
+func (e *CBCEncrypter) Final() []byte {
+	padding := aes.BlockSize - len(e.pending)%aes.BlockSize
+	for i := 0; i < padding; i++ {
+		e.pending = append(e.pending, byte(padding))
+	}
+
+	return e.Encrypt(nil)
+}
@@ -0,0 +1,63 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func decrypt(t *testing.T, key []byte, iv []byte, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal("Error creating cipher. Err: ", err)
+	}
+	if len(data)%aes.BlockSize != 0 {
+		t.Fatalf("Encrypted data is not whole blocks, got: %d bytes.", len(data))
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	padding := int(out[len(out)-1])
+	if padding <= 0 || padding > aes.BlockSize {
+		t.Fatalf("Padding is not correct, got: %d.", padding)
+	}
+	return out[:len(out)-padding]
+}
+
+func TestCBCEncrypter(t *testing.T) {
+	key, _ := NewKey()
+	iv := SequenceIV(7)
+
+	plain := make([]byte, 188*5)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+
+	e, err := NewCBCEncrypter(key, iv)
+	if err != nil {
+		t.Fatal("Error creating encrypter. Err: ", err)
+	}
+	encrypted := []byte{}
+	for i := 0; i < len(plain); i = i + 188 {
+		encrypted = append(encrypted, e.Encrypt(plain[i:i+188])...)
+	}
+	encrypted = append(encrypted, e.Final()...)
+
+	if got, xpected := len(encrypted), (len(plain)/aes.BlockSize+1)*aes.BlockSize; got != xpected {
+		t.Errorf("Encrypted length is not correct, got: %d, want: %d.", got, xpected)
+	}
+	if !bytes.Equal(decrypt(t, key, iv, encrypted), plain) {
+		t.Errorf("Decrypted data is not the plaintext")
+	}
+}
+
+func TestSequenceIV(t *testing.T) {
+	xpected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2}
+	if got := SequenceIV(258); !bytes.Equal(got, xpected) {
+		t.Errorf("IV is not correct, got: %x, want: %x.", got, xpected)
+	}
+	if _, err := NewCBCEncrypter([]byte{1, 2}, xpected); err == nil {
+		t.Errorf("Invalid key was accepted")
+	}
+}
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProvidedKey Key of a key provider and its URI (written as it is in the EXT-X-KEY)
+type ProvidedKey struct {
+	Key []byte `json:"key"`
+	URI string `json:"uri"`
+}
+
+// KeyProvider Source of the encryption keys (Ex: key server), index is the number of the key (0 the 1st one, then +1 every rotation)
+type KeyProvider interface {
+	GetKey(index uint64) (ProvidedKey, error)
+}
+
+// HTTPKeyProvider Key server provider: GET (index in the query) or POST (JSON body {"index": N}) of the URL with the auth token as bearer, the response is JSON {"key": "<16 bytes in base64>", "uri": "..."}. The last key is cached, the requests are retried with backoff (the delay doubles each intent)
+type HTTPKeyProvider struct {
+	HTTPClient *http.Client
+
+	Log                 *logrus.Logger
+	URL                 string
+	Method              string
+	AuthToken           string
+	MaxRetries          int
+	InitialRetryDelayMs int
+
+	cachedIndex uint64
+	cached      *ProvidedKey
+}
+
+// NewHTTPKeyProvider Creates a key server provider, method is GET or POST
+func NewHTTPKeyProvider(log *logrus.Logger, keyURL string, method string, authToken string, maxRetries int, initialRetryDelayMs int) (*HTTPKeyProvider, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+	if method != http.MethodGet && method != http.MethodPost {
+		return nil, fmt.Errorf("invalid key provider method %s, it has to be GET or POST", method)
+	}
+	if _, err := url.Parse(keyURL); err != nil || keyURL == "" {
+		return nil, fmt.Errorf("invalid key provider URL %s", keyURL)
+	}
+
+	return &HTTPKeyProvider{&http.Client{Timeout: 10 * time.Second}, log, keyURL, method, authToken, maxRetries, initialRetryDelayMs, 0, nil}, nil
+}
+
+// GetKey Returns the key of the index (the cached one if it is the last requested)
+func (p *HTTPKeyProvider) GetKey(index uint64) (ProvidedKey, error) {
+	if p.cached != nil && p.cachedIndex == index {
+		return *p.cached, nil
+	}
+
+	retryDelay := time.Duration(p.InitialRetryDelayMs) * time.Millisecond
+	retryIntent := 0
+	for {
+		key, err := p.requestKey(index)
+		if err == nil {
+			p.cachedIndex = index
+			p.cached = &key
+			return key, nil
+		}
+		if retryIntent >= p.MaxRetries {
+			return ProvidedKey{}, err
+		}
+
+		p.Log.Warn("Error getting the key ", index, " from ", p.URL, ", retrying in ", retryDelay, " (", retryIntent+1, "/", p.MaxRetries, "). Err: ", err)
+		time.Sleep(retryDelay)
+
+		retryDelay = retryDelay * 2
+		retryIntent++
+	}
+}
+
+// requestKey Requests the key of the index to the server
+func (p *HTTPKeyProvider) requestKey(index uint64) (ProvidedKey, error) {
+	var req *http.Request
+	var err error
+	if p.Method == http.MethodPost {
+		body, _ := json.Marshal(map[string]uint64{"index": index})
+		req, err = http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		var u *url.URL
+		if u, err = url.Parse(p.URL); err == nil {
+			query := u.Query()
+			query.Set("index", strconv.FormatUint(index, 10))
+			u.RawQuery = query.Encode()
+			req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+		}
+	}
+	if err != nil {
+		return ProvidedKey{}, err
+	}
+	if p.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.AuthToken)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return ProvidedKey{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ProvidedKey{}, fmt.Errorf("key server status %d", resp.StatusCode)
+	}
+
+	var key ProvidedKey
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&key); err != nil {
+		return ProvidedKey{}, err
+	}
+	if len(key.Key) != KeyLength {
+		return ProvidedKey{}, fmt.Errorf("invalid key length %d, it has to be %d bytes", len(key.Key), KeyLength)
+	}
+	if key.URI == "" {
+		return ProvidedKey{}, errors.New("key server response without URI")
+	}
+
+	return key, nil
+}
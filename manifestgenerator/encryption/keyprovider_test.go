@@ -0,0 +1,60 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPKeyProvider(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if requests == 1 {
+			// The 1st request fails, it is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		index := r.URL.Query().Get("index")
+		if r.Method == http.MethodPost {
+			body := map[string]uint64{}
+			json.NewDecoder(r.Body).Decode(&body)
+			index = fmt.Sprint(body["index"])
+		}
+		json.NewEncoder(w).Encode(ProvidedKey{key, "skd://keys/" + index})
+	}))
+	defer server.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		requests = 0
+		p, err := NewHTTPKeyProvider(nil, server.URL, method, "token", 2, 1)
+		if err != nil {
+			t.Fatal("Error creating the key provider. Err: ", err)
+		}
+
+		got, err := p.GetKey(3)
+		if err != nil || !bytes.Equal(got.Key, key) || got.URI != "skd://keys/3" {
+			t.Errorf("Key is not correct (%s), got: %x %s. Err: %v", method, got.Key, got.URI, err)
+		}
+		if _, err := p.GetKey(3); err != nil || requests != 2 {
+			t.Errorf("Key is not cached (%s), got: %d requests, want: %d.", method, requests, 2)
+		}
+	}
+
+	p, _ := NewHTTPKeyProvider(nil, server.URL, http.MethodGet, "wrong", 1, 1)
+	if _, err := p.GetKey(0); err == nil {
+		t.Errorf("Key got without the auth token")
+	}
+	if _, err := NewHTTPKeyProvider(nil, server.URL, http.MethodPut, "", 1, 1); err == nil {
+		t.Errorf("Invalid method is accepted")
+	}
+}
@@ -0,0 +1,194 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+const (
+	// avcClearLeader Clear bytes at the start of an encrypted H.264 NAL unit (the NAL unit type byte and 31 bytes)
+	avcClearLeader = 32
+
+	// avcMinEncryptedLength NAL units of this length or shorter are not encrypted
+	avcMinEncryptedLength = 48
+
+	// avcClearBlocks Clear blocks after every encrypted block of a NAL unit (pattern 1 of 10)
+	avcClearBlocks = 9
+
+	// aacClearLeader Clear bytes at the start of the raw data of an AAC frame
+	aacClearLeader = 16
+)
+
+// SampleAES SAMPLE-AES encrypter of the elementary streams samples (MPEG-2 Stream Encryption Format for HTTP Live Streaming), the CBC chain starts with the IV at every NAL unit / AAC frame. It only needs the ES data, so it is not tied to the container
+type SampleAES struct {
+	block cipher.Block
+	key   []byte
+	iv    []byte
+}
+
+// NewSampleAES Creates a SAMPLE-AES encrypter of the key, the IV has to be set before encrypting
+func NewSampleAES(key []byte) (*SampleAES, error) {
+	if len(key) != KeyLength {
+		return nil, errors.New("AES-128 key has to be 16 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SampleAES{block, append([]byte{}, key...), make([]byte, KeyLength)}, nil
+}
+
+// SetKey Changes the key (Ex: key rotation), nothing is done if it is the current one
+func (s *SampleAES) SetKey(key []byte) error {
+	if bytes.Equal(key, s.key) {
+		return nil
+	}
+	if len(key) != KeyLength {
+		return errors.New("AES-128 key has to be 16 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	s.block = block
+	s.key = append(s.key[:0], key...)
+	return nil
+}
+
+// SetIV Sets the IV of the chunk being written
+func (s *SampleAES) SetIV(iv []byte) {
+	s.iv = append(s.iv[:0], iv...)
+}
+
+// encryptBlocks Encrypts (in place) the whole blocks of the data
+func (s *SampleAES) encryptBlocks(data []byte) {
+	n := len(data) - len(data)%aes.BlockSize
+	cipher.NewCBCEncrypter(s.block, s.iv).CryptBlocks(data[:n], data[:n])
+}
+
+// EncryptAVC Returns the H.264 Annex B data with the slices (NAL unit types 1 and 5) longer than 48 bytes encrypted: the first 32 bytes clear, then 1 encrypted block every 10 (the last partial one clear). The emulation prevention is applied after the encryption
+func (s *SampleAES) EncryptAVC(es []byte) []byte {
+	out := make([]byte, 0, len(es)+len(es)/64)
+
+	for start := 0; start < len(es); {
+		unitStart, unitEnd, next := nextNALUnit(es, start)
+		out = append(out, es[start:unitStart]...)
+		if unitStart >= unitEnd {
+			break
+		}
+
+		unit := es[unitStart:unitEnd]
+		unitType := unit[0] & 0x1F
+		if (unitType != 1 && unitType != 5) || len(unit) <= avcMinEncryptedLength {
+			out = append(out, unit...)
+		} else {
+			out = append(out, addEmulationPrevention(s.encryptNALUnit(unit))...)
+		}
+		out = append(out, es[unitEnd:next]...)
+		start = next
+	}
+
+	return out
+}
+
+// encryptNALUnit Returns the NAL unit with the pattern of blocks encrypted (they are chained)
+func (s *SampleAES) encryptNALUnit(unit []byte) []byte {
+	data := append([]byte{}, unit...)
+
+	encrypted := []byte{}
+	positions := []int{}
+	for pos := avcClearLeader; pos+aes.BlockSize < len(data); pos = pos + aes.BlockSize*(1+avcClearBlocks) {
+		encrypted = append(encrypted, data[pos:pos+aes.BlockSize]...)
+		positions = append(positions, pos)
+	}
+	s.encryptBlocks(encrypted)
+	for i, pos := range positions {
+		copy(data[pos:], encrypted[i*aes.BlockSize:(i+1)*aes.BlockSize])
+	}
+
+	return data
+}
+
+// EncryptADTS Returns the ADTS data with the raw data of every frame encrypted after the first 16 bytes (the last partial block clear), the headers are clear
+func (s *SampleAES) EncryptADTS(es []byte) []byte {
+	out := append([]byte{}, es...)
+
+	for pos := 0; pos+7 <= len(out); {
+		if out[pos] != 0xFF || (out[pos+1]&0xF0) != 0xF0 {
+			// Resync to the next syncword
+			pos++
+			continue
+		}
+
+		headerLength := 7
+		if (out[pos+1] & 0x01) == 0 {
+			// CRC
+			headerLength = 9
+		}
+		frameLength := int(out[pos+3]&0x03)<<11 | int(out[pos+4])<<3 | int(out[pos+5])>>5
+		if frameLength < headerLength || pos+frameLength > len(out) {
+			break
+		}
+
+		if raw := out[pos+headerLength : pos+frameLength]; len(raw) > aacClearLeader {
+			s.encryptBlocks(raw[aacClearLeader:])
+		}
+		pos = pos + frameLength
+	}
+
+	return out
+}
+
+// nextNALUnit Returns the position of the NAL unit after the start code at or after start, its end (before the trailing zeros of the next start code) and the position of the next start code (the data end if there is none)
+func nextNALUnit(es []byte, start int) (unitStart int, unitEnd int, next int) {
+	unitStart = len(es)
+	for i := start; i+2 < len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 {
+			unitStart = i + 3
+			break
+		}
+	}
+
+	next = len(es)
+	for i := unitStart; i+2 < len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] <= 1 {
+			next = i
+			break
+		}
+	}
+
+	unitEnd = next
+	for unitEnd > unitStart && es[unitEnd-1] == 0 {
+		unitEnd--
+	}
+
+	return unitStart, unitEnd, next
+}
+
+// addEmulationPrevention Returns the NAL unit with an emulation_prevention_three_byte after every 2 zeros followed by a byte <= 3
+func addEmulationPrevention(unit []byte) []byte {
+	out := make([]byte, 0, len(unit)+len(unit)/64)
+	zeros := 0
+	for _, b := range unit {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	if zeros > 0 {
+		// A trailing zero would be taken as the next start code
+		out = append(out, 3)
+	}
+
+	return out
+}
@@ -0,0 +1,105 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// removeEmulationPrevention Returns the NAL unit without the emulation_prevention_three_byte
+func removeEmulationPrevention(unit []byte) []byte {
+	out := []byte{}
+	zeros := 0
+	for _, b := range unit {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// decryptNALUnit Decrypts the pattern of blocks of the SAMPLE-AES NAL unit (after removing the emulation prevention)
+func decryptNALUnit(key []byte, iv []byte, unit []byte) []byte {
+	data := removeEmulationPrevention(unit)
+	block, _ := aes.NewCipher(key)
+	mode := cipher.NewCBCDecrypter(block, iv)
+	for pos := avcClearLeader; pos+aes.BlockSize < len(data); pos = pos + aes.BlockSize*(1+avcClearBlocks) {
+		mode.CryptBlocks(data[pos:pos+aes.BlockSize], data[pos:pos+aes.BlockSize])
+	}
+	return data
+}
+
+func TestEncryptAVC(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := SequenceIV(3)
+	s, err := NewSampleAES(key)
+	if err != nil {
+		t.Fatal("Error creating encrypter. Err: ", err)
+	}
+	s.SetIV(iv)
+
+	// AUD, short slice (clear) and long IDR slice (with an emulation prevention byte)
+	aud := []byte{0x09, 0xF0}
+	short := append([]byte{0x41}, bytes.Repeat([]byte{0x9A}, 40)...)
+	idr := []byte{0x65}
+	for i := 0; i < 500; i++ {
+		idr = append(idr, byte(i*7+1))
+	}
+	idr[100], idr[101], idr[102] = 0, 0, 3
+	es := append(append(append(append(append([]byte{0, 0, 0, 1}, aud...), 0, 0, 1), short...), 0, 0, 1), idr...)
+
+	encrypted := s.EncryptAVC(es)
+	prefix := len(es) - len(idr)
+	if !bytes.Equal(encrypted[:prefix], es[:prefix]) {
+		t.Errorf("Clear NAL units were modified")
+	}
+	encryptedIDR := encrypted[prefix:]
+	if !bytes.Equal(encryptedIDR[:avcClearLeader], idr[:avcClearLeader]) || bytes.Equal(encryptedIDR, idr) {
+		t.Errorf("IDR slice is not correctly encrypted")
+	}
+	if bytes.Contains(encryptedIDR, []byte{0, 0, 1}) || bytes.Contains(encryptedIDR, []byte{0, 0, 0}) {
+		t.Errorf("Encrypted slice has a start code emulation")
+	}
+	if got := decryptNALUnit(key, iv, encryptedIDR); !bytes.Equal(got, idr) {
+		t.Errorf("Decrypted IDR slice is not the original one")
+	}
+}
+
+func TestEncryptADTS(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := SequenceIV(0)
+	s, _ := NewSampleAES(key)
+	s.SetIV(iv)
+
+	// 2 frames (7 bytes header without CRC) of 100 and 20 bytes of raw data
+	frame := func(rawLength int) []byte {
+		length := 7 + rawLength
+		header := []byte{0xFF, 0xF1, 0x4C, 0x80 | byte(length>>11), byte(length >> 3), byte(length<<5) | 0x1F, 0xFC}
+		return append(header, bytes.Repeat([]byte{0x21}, rawLength)...)
+	}
+	es := append(frame(100), frame(20)...)
+
+	encrypted := s.EncryptADTS(es)
+	if len(encrypted) != len(es) {
+		t.Fatalf("Encrypted length is not correct, got: %d, want: %d.", len(encrypted), len(es))
+	}
+	// 1st frame: header and 16 bytes clear, 5 blocks encrypted and 4 bytes clear. 2nd frame: not a whole block
+	if !bytes.Equal(encrypted[:7+aacClearLeader], es[:7+aacClearLeader]) || !bytes.Equal(encrypted[107-4:], es[107-4:]) {
+		t.Errorf("Clear data was modified")
+	}
+
+	block, _ := aes.NewCipher(key)
+	decrypted := append([]byte{}, encrypted...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted[7+aacClearLeader:107-4], decrypted[7+aacClearLeader:107-4])
+	if !bytes.Equal(decrypted, es) {
+		t.Errorf("Decrypted frames are not the original ones")
+	}
+}
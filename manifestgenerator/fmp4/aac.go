@@ -0,0 +1,79 @@
+package fmp4
+
+const (
+	// AACFrameSamples Samples of an AAC frame (the duration of each audio sample in the audio timescale)
+	AACFrameSamples = 1024
+
+	// mp4AudioObjectType ISO/IEC 14496-3 audio objectTypeIndication of the esds
+	mp4AudioObjectType = 0x40
+
+	// mp4AudioStreamType AudioStream streamType of the esds (with the reserved bit)
+	mp4AudioStreamType = 0x15
+)
+
+// aacSampleRates Sample rates of the sampling_frequency_index
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsFrame AAC raw frame of an ADTS frame, with the AudioSpecificConfig of its header
+type adtsFrame struct {
+	data        []byte
+	audioConfig []byte
+	sampleRate  int
+	channels    int
+}
+
+// parseADTS Returns the AAC frames of the ADTS data, the rest (an incomplete or invalid frame) is discarded
+func parseADTS(es []byte) []adtsFrame {
+	frames := []adtsFrame{}
+
+	for len(es) >= 7 {
+		if es[0] != 0xFF || (es[1]&0xF0) != 0xF0 {
+			// Resync to the next syncword
+			es = es[1:]
+			continue
+		}
+
+		headerLength := 7
+		if (es[1] & 0x01) == 0 {
+			// CRC
+			headerLength = 9
+		}
+		frameLength := int(es[3]&0x03)<<11 | int(es[4])<<3 | int(es[5])>>5
+		sampleRateIndex := int(es[2]>>2) & 0x0F
+		if frameLength < headerLength || frameLength > len(es) || sampleRateIndex >= len(aacSampleRates) {
+			break
+		}
+
+		objectType := int(es[2]>>6) + 1
+		channels := int(es[2]&0x01)<<2 | int(es[3]>>6)
+		audioConfig := []byte{byte(objectType<<3 | sampleRateIndex>>1), byte((sampleRateIndex&1)<<7 | channels<<3)}
+
+		frames = append(frames, adtsFrame{es[headerLength:frameLength], audioConfig, aacSampleRates[sampleRateIndex], channels})
+		es = es[frameLength:]
+	}
+
+	return frames
+}
+
+// descriptor MPEG-4 descriptor (ISO/IEC 14496-1) with the payloads
+func descriptor(tag byte, payloads ...[]byte) []byte {
+	size := 0
+	for _, payload := range payloads {
+		size = size + len(payload)
+	}
+
+	// Size in 4 bytes, 7 bits each
+	d := []byte{tag, 0x80 | byte(size>>21&0x7F), 0x80 | byte(size>>14&0x7F), 0x80 | byte(size>>7&0x7F), byte(size & 0x7F)}
+	for _, payload := range payloads {
+		d = append(d, payload...)
+	}
+
+	return d
+}
+
+// esds Elementary stream descriptor of the AAC AudioSpecificConfig
+func esds(trackID uint32, audioConfig []byte) []byte {
+	decoderConfig := descriptor(0x04, []byte{mp4AudioObjectType, mp4AudioStreamType}, make([]byte, 3), u32(0, 0), descriptor(0x05, audioConfig))
+
+	return fullBox("esds", 0, 0, descriptor(0x03, u16(uint16(trackID)), []byte{0}, decoderConfig, descriptor(0x06, []byte{0x02})))
+}
@@ -0,0 +1,265 @@
+package fmp4
+
+import (
+	"errors"
+)
+
+const (
+	// avcIDRNALType H.264 IDR slice NAL unit type
+	avcIDRNALType = 5
+
+	// avcSPSNALType H.264 sequence parameter set NAL unit type
+	avcSPSNALType = 7
+
+	// avcPPSNALType H.264 picture parameter set NAL unit type
+	avcPPSNALType = 8
+
+	// avcAUDNALType H.264 access unit delimiter NAL unit type
+	avcAUDNALType = 9
+
+	// avcFillerNALType H.264 filler data NAL unit type
+	avcFillerNALType = 12
+)
+
+// avcHighProfiles Profiles with chroma format and bit depth in the SPS (and in the avcC)
+var avcHighProfiles = map[byte]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// spsInfo Fields of the SPS used in the init segment
+type spsInfo struct {
+	width                int
+	height               int
+	chromaFormat         int
+	bitDepthLumaMinus8   int
+	bitDepthChromaMinus8 int
+}
+
+// bitReader Reads the bits (and Exp-Golomb codes) of a RBSP, reading after the end returns an error
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bitReader) bit() int {
+	if r.pos >= 8*len(r.data) {
+		r.err = errors.New("SPS truncated")
+		return 0
+	}
+	b := int(r.data[r.pos/8]>>(7-uint(r.pos%8))) & 1
+	r.pos++
+
+	return b
+}
+
+func (r *bitReader) bits(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+
+	return v
+}
+
+// ue Unsigned Exp-Golomb code
+func (r *bitReader) ue() int {
+	zeros := 0
+	for r.bit() == 0 && r.err == nil && zeros < 32 {
+		zeros++
+	}
+
+	return (1 << uint(zeros)) - 1 + r.bits(zeros)
+}
+
+// se Signed Exp-Golomb code
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 0 {
+		return -v / 2
+	}
+
+	return (v + 1) / 2
+}
+
+// splitNALUnits Returns the NAL units of the Annex B data (without the start codes)
+func splitNALUnits(es []byte) [][]byte {
+	units := [][]byte{}
+
+	start := -1
+	for i := 0; i+2 < len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 {
+			if start >= 0 {
+				units = appendNALUnit(units, es[start:i])
+			}
+			start = i + 3
+			i = i + 2
+		}
+	}
+	if start >= 0 && start < len(es) {
+		units = appendNALUnit(units, es[start:])
+	}
+
+	return units
+}
+
+// appendNALUnit Adds the unit without the trailing zeros (next 4 bytes start code or trailing_zero_8bits)
+func appendNALUnit(units [][]byte, unit []byte) [][]byte {
+	for len(unit) > 0 && unit[len(unit)-1] == 0 {
+		unit = unit[:len(unit)-1]
+	}
+	if len(unit) > 0 {
+		units = append(units, unit)
+	}
+
+	return units
+}
+
+// removeEmulationPrevention Returns the RBSP of the NAL unit (without the emulation_prevention_three_byte)
+func removeEmulationPrevention(unit []byte) []byte {
+	rbsp := make([]byte, 0, len(unit))
+	zeros := 0
+	for _, b := range unit {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
+
+// skipScalingList Skips a scaling_list of the SPS
+func skipScalingList(r *bitReader, size int) {
+	lastScale, nextScale := 8, 8
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			nextScale = (lastScale + r.se() + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
+
+// parseSPS Returns the picture size (cropped) and the chroma format / bit depths of the SPS NAL unit
+func parseSPS(sps []byte) (spsInfo, error) {
+	info := spsInfo{chromaFormat: 1}
+	if len(sps) < 4 {
+		return info, errors.New("SPS too short")
+	}
+
+	r := bitReader{data: removeEmulationPrevention(sps[1:])}
+	profile := byte(r.bits(8))
+	r.bits(16) // constraint flags and level
+	r.ue()     // seq_parameter_set_id
+
+	if avcHighProfiles[profile] {
+		info.chromaFormat = r.ue()
+		if info.chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		info.bitDepthLumaMinus8 = r.ue()
+		info.bitDepthChromaMinus8 = r.ue()
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			lists := 8
+			if info.chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 1 {
+					if i < 6 {
+						skipScalingList(&r, 16)
+					} else {
+						skipScalingList(&r, 64)
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	pocType := r.ue()
+	if pocType == 0 {
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	} else if pocType == 1 {
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := 0; i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMbsOnly := r.bit()
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	cropLeft, cropRight, cropTop, cropBottom := 0, 0, 0, 0
+	if r.bit() == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return info, r.err
+	}
+
+	// Crop units (ChromaArrayType 0 is monochrome or separated planes)
+	cropUnitX, cropUnitY := 1, 2-frameMbsOnly
+	if info.chromaFormat == 1 {
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	} else if info.chromaFormat == 2 {
+		cropUnitX = 2
+	}
+
+	info.width = widthMbs*16 - cropUnitX*(cropLeft+cropRight)
+	info.height = (2-frameMbsOnly)*heightMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+
+	return info, nil
+}
+
+// avcC AVC decoder configuration record of the SPS and PPS (4 bytes NAL unit lengths)
+func avcC(sps []byte, pps []byte) []byte {
+	record := []byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1}
+	record = append(append(record, u16(uint16(len(sps)))...), sps...)
+	record = append(append(append(record, 1), u16(uint16(len(pps)))...), pps...)
+
+	if avcHighProfiles[sps[1]] {
+		info, _ := parseSPS(sps)
+		record = append(record, 0xFC|byte(info.chromaFormat&3), 0xF8|byte(info.bitDepthLumaMinus8&7), 0xF8|byte(info.bitDepthChromaMinus8&7), 0)
+	}
+
+	return box("avcC", record)
+}
+
+// avcSample Returns the sample data (NAL units with 4 bytes lengths, without the parameter sets, delimiters and filler) of the access unit, if it is a sync sample (IDR) and its SPS and PPS (nil if not present)
+func avcSample(es []byte) (data []byte, isSync bool, sps []byte, pps []byte) {
+	data = make([]byte, 0, len(es))
+
+	for _, unit := range splitNALUnits(es) {
+		switch unit[0] & 0x1F {
+		case avcSPSNALType:
+			sps = unit
+		case avcPPSNALType:
+			pps = unit
+		case avcAUDNALType, avcFillerNALType:
+		default:
+			if unit[0]&0x1F == avcIDRNALType {
+				isSync = true
+			}
+			data = append(append(data, u32(uint32(len(unit)))...), unit...)
+		}
+	}
+
+	return data, isSync, sps, pps
+}
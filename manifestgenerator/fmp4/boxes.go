@@ -0,0 +1,175 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// trunDataOffset trun flag data_offset present
+	trunDataOffset = 0x000001
+
+	// trunSampleDuration trun flag sample_duration present
+	trunSampleDuration = 0x000100
+
+	// trunSampleSize trun flag sample_size present
+	trunSampleSize = 0x000200
+
+	// trunSampleFlags trun flag sample_flags present
+	trunSampleFlags = 0x000400
+
+	// trunSampleCTO trun flag sample_composition_time_offset present
+	trunSampleCTO = 0x000800
+
+	// tfhdDefaultBaseIsMoof tfhd flag default-base-is-moof (the data offsets are from the moof start)
+	tfhdDefaultBaseIsMoof = 0x020000
+
+	// syncSampleFlags Sample flags of a sync sample (depends on no other)
+	syncSampleFlags = 0x02000000
+
+	// nonSyncSampleFlags Sample flags of a non sync sample (depends on others, sample_is_non_sync_sample)
+	nonSyncSampleFlags = 0x01010000
+)
+
+// unityMatrix Transformation matrix of mvhd / tkhd (no transformation)
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// box Returns the ISO BMFF box with the payloads
+func box(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size = size + len(payload)
+	}
+
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], boxType)
+	for _, payload := range payloads {
+		b = append(b, payload...)
+	}
+
+	return b
+}
+
+// fullBox Returns the ISO BMFF full box (version and flags) with the payloads
+func fullBox(boxType string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+
+	return box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+func u16(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func u32(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+
+	return b
+}
+
+// ftyp CMAF compatible file type
+func ftyp() []byte {
+	return box("ftyp", []byte("iso6"), u32(0), []byte("iso6cmfcmp41"))
+}
+
+// mvhd Movie header, the durations are 0 (fragmented)
+func mvhd(nextTrackID uint32) []byte {
+	return fullBox("mvhd", 0, 0,
+		u32(0, 0, 1000, 0),
+		u32(0x00010000), u16(0x0100), make([]byte, 10),
+		u32(unityMatrix...),
+		make([]byte, 24),
+		u32(nextTrackID))
+}
+
+// trak Track of the init segment
+func (t *track) trak() []byte {
+	volume := uint16(0)
+	handler, name, mediaHeader := "vide", "VideoHandler", fullBox("vmhd", 0, 1, make([]byte, 8))
+	if t.trackType == TrackAudio {
+		volume = 0x0100
+		handler, name, mediaHeader = "soun", "SoundHandler", fullBox("smhd", 0, 0, make([]byte, 4))
+	}
+
+	tkhd := fullBox("tkhd", 0, 3,
+		u32(0, 0, t.id, 0, 0),
+		make([]byte, 8),
+		u16(0), u16(0), u16(volume), u16(0),
+		u32(unityMatrix...),
+		u32(uint32(t.width)<<16, uint32(t.height)<<16))
+
+	mdhd := fullBox("mdhd", 0, 0, u32(0, 0, t.timescale, 0), u16(0x55C4), u16(0))
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12), []byte(name), []byte{0})
+
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), t.sampleEntry()),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0, 0)),
+		fullBox("stco", 0, 0, u32(0)))
+
+	return box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mediaHeader, dinf, stbl)))
+}
+
+// sampleEntry avc1 or mp4a sample entry with the decoder configuration
+func (t *track) sampleEntry() []byte {
+	if t.trackType == TrackAudio {
+		return box("mp4a",
+			make([]byte, 6), u16(1),
+			make([]byte, 8),
+			u16(uint16(t.channels)), u16(16), u16(0), u16(0),
+			u32(uint32(t.timescale)<<16),
+			esds(t.id, t.audioConfig))
+	}
+
+	return box("avc1",
+		make([]byte, 6), u16(1),
+		make([]byte, 16),
+		u16(uint16(t.width)), u16(uint16(t.height)),
+		u32(0x00480000, 0x00480000, 0),
+		u16(1),
+		make([]byte, 32),
+		u16(0x0018), u16(0xFFFF),
+		avcC(t.sps, t.pps))
+}
+
+// trex Default values of the track fragments
+func (t *track) trex() []byte {
+	return fullBox("trex", 0, 0, u32(t.id, 1, 0, 0, 0))
+}
+
+// traf Track fragment of the samples, dataOffset is the position of their data from the moof start
+func (t *track) traf(samples []sample, dataOffset int) []byte {
+	flags := uint32(trunDataOffset | trunSampleDuration | trunSampleSize)
+	if t.trackType == TrackVideo {
+		flags = flags | trunSampleFlags | trunSampleCTO
+	}
+
+	entries := make([]byte, 0, 16*len(samples))
+	for _, s := range samples {
+		entries = append(entries, u32(s.duration, uint32(len(s.data)))...)
+		if t.trackType == TrackVideo {
+			sampleFlags := uint32(nonSyncSampleFlags)
+			if s.isSync {
+				sampleFlags = syncSampleFlags
+			}
+			entries = append(entries, u32(sampleFlags, uint32(s.cto))...)
+		}
+	}
+
+	return box("traf",
+		fullBox("tfhd", 0, tfhdDefaultBaseIsMoof, u32(t.id)),
+		fullBox("tfdt", 1, 0, u64(uint64(samples[0].dts))),
+		fullBox("trun", 1, flags, u32(uint32(len(samples)), uint32(dataOffset)), entries))
+}
@@ -0,0 +1,337 @@
+package fmp4
+
+import (
+	"math"
+)
+
+// TrackTypes Type of the track of an elementary stream
+type TrackTypes int
+
+const (
+	// TrackVideo H.264 video (Annex B access units)
+	TrackVideo TrackTypes = iota
+
+	// TrackAudio AAC audio (ADTS frames)
+	TrackAudio
+)
+
+const (
+	// VideoTimescale Timescale of the video track (the PES timestamps clock)
+	VideoTimescale = 90000
+
+	// defaultVideoSampleDuration Duration (in VideoTimescale) of a video sample without the next DTS and no previous one (1/30 s)
+	defaultVideoSampleDuration = 3000
+
+	// maxTimestamp PES timestamps rollover (33 bits)
+	maxTimestamp = int64(1) << 33
+)
+
+// sample Sample pending to be written, its decode time (in the track timescale), composition offset and duration (0 not known yet)
+type sample struct {
+	data     []byte
+	dts      int64
+	cto      int32
+	duration uint32
+	isSync   bool
+}
+
+// track Track of an elementary stream
+type track struct {
+	trackType TrackTypes
+	id        uint32
+	timescale uint32
+
+	// PES being received, its ES length (0 unbounded) and timestamps (90KHz unwrapped, -1 not present)
+	pes       []byte
+	isPES     bool
+	pesLength int
+	pesPTS    int64
+	pesDTS    int64
+
+	// DTS of the last PES (90KHz unwrapped, -1 none), time of the next audio sample, samples pending to be written and the last video sample duration
+	lastDTS       int64
+	nextAudioTime int64
+	samples       []sample
+	lastDuration  uint32
+
+	// Decoder configuration (SPS and PPS, or AudioSpecificConfig)
+	sps         []byte
+	pps         []byte
+	width       int
+	height      int
+	audioConfig []byte
+	channels    int
+}
+
+// Muxer CMAF (fMP4) muxer of an H.264 video and an AAC audio elementary streams. The samples of the PES received are returned as a fragment (moof and mdat) of the tracks in the init segment (ftyp and moov) of their configuration
+type Muxer struct {
+	tracks         []*track
+	sequenceNumber uint32
+}
+
+// New Creates a CMAF muxer instance
+func New() Muxer {
+	return Muxer{[]*track{newTrack(TrackVideo, 1, VideoTimescale), newTrack(TrackAudio, 2, 0)}, 0}
+}
+
+func newTrack(trackType TrackTypes, id uint32, timescale uint32) *track {
+	return &track{trackType, id, timescale, []byte{}, false, 0, -1, -1, -1, -1, []sample{}, 0, nil, nil, 0, 0, nil, 0}
+}
+
+// secondsToTicks Returns the 90KHz ticks of the timestamp in seconds (-1 not present)
+func secondsToTicks(timeS float64) int64 {
+	if timeS < 0 {
+		return -1
+	}
+	return int64(math.Round(timeS * 90000))
+}
+
+// wrapDiff Returns the difference between the timestamps in the 33 bits modular space (the shortest one)
+func wrapDiff(from int64, to int64) int64 {
+	diff := ((to-from)%maxTimestamp + maxTimestamp) % maxTimestamp
+	if diff > maxTimestamp/2 {
+		diff = diff - maxTimestamp
+	}
+	return diff
+}
+
+// unwrap Returns the timestamp (90KHz) continuing the last DTS, so the rollover does not go backwards
+func (t *track) unwrap(ts int64) int64 {
+	if t.lastDTS < 0 {
+		return ts
+	}
+	return int64(math.Max(0, float64(t.lastDTS+wrapDiff(t.lastDTS, ts))))
+}
+
+// AddPayload Adds the TS payload of a packet of the track, isPESStart is the payload_unit_start_indicator and ptsS / dtsS the PES PTS / DTS (< 0 if not present). A new PES completes the previous one
+func (m *Muxer) AddPayload(trackType TrackTypes, payload []byte, isPESStart bool, ptsS float64, dtsS float64) {
+	t := m.tracks[trackType]
+
+	if isPESStart {
+		t.endPES()
+
+		// PES header
+		if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+			return
+		}
+		headerLength := 9 + int(payload[8])
+		if headerLength > len(payload) {
+			return
+		}
+		t.pesLength = 0
+		if pesPacketLength := int(payload[4])<<8 | int(payload[5]); pesPacketLength > 0 {
+			t.pesLength = 6 + pesPacketLength - headerLength
+		}
+
+		t.pesPTS, t.pesDTS = -1, -1
+		if ptsS >= 0 {
+			if dtsS < 0 {
+				dtsS = ptsS
+			}
+			t.pesDTS = t.unwrap(secondsToTicks(dtsS))
+			t.pesPTS = t.pesDTS + wrapDiff(secondsToTicks(dtsS), secondsToTicks(ptsS))
+			t.setNextDTS(t.pesDTS)
+		}
+		t.isPES = true
+		payload = payload[headerLength:]
+	} else if !t.isPES {
+		return
+	}
+
+	t.pes = append(t.pes, payload...)
+	if t.pesLength > 0 && len(t.pes) >= t.pesLength {
+		t.pes = t.pes[:t.pesLength]
+		t.endPES()
+	}
+}
+
+// EndPES Completes the PES being received (Ex: before a chunk is closed at the start of the next one), nextDTSS is the DTS of the next PES (< 0 unknown) that sets the duration of the last video sample
+func (m *Muxer) EndPES(trackType TrackTypes, nextDTSS float64) {
+	t := m.tracks[trackType]
+
+	t.endPES()
+	if nextDTSS >= 0 {
+		t.setNextDTS(t.unwrap(secondsToTicks(nextDTSS)))
+	}
+}
+
+// Reset Discards the PES being received and the timeline (Ex: timestamps discontinuity), the configuration is kept
+func (m *Muxer) Reset() {
+	for _, t := range m.tracks {
+		t.pes = t.pes[:0]
+		t.isPES = false
+		t.lastDTS = -1
+		t.nextAudioTime = -1
+	}
+}
+
+// IsEmpty Returns true if there is no sample or PES data pending
+func (m *Muxer) IsEmpty() bool {
+	for _, t := range m.tracks {
+		if len(t.samples) > 0 || len(t.pes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setNextDTS Sets the duration of the last video sample (if not set yet) from the DTS of the next one
+func (t *track) setNextDTS(dts int64) {
+	if t.trackType != TrackVideo || len(t.samples) <= 0 || dts < 0 {
+		return
+	}
+
+	last := &t.samples[len(t.samples)-1]
+	if last.duration == 0 && dts > last.dts {
+		last.duration = uint32(dts - last.dts)
+		t.lastDuration = last.duration
+	}
+}
+
+// endPES Creates the samples of the PES received
+func (t *track) endPES() {
+	if t.isPES && len(t.pes) > 0 {
+		if t.trackType == TrackVideo {
+			t.addVideoSample()
+		} else {
+			t.addAudioSamples()
+		}
+	}
+
+	t.pes = t.pes[:0]
+	t.isPES = false
+}
+
+func (t *track) addVideoSample() {
+	data, isSync, sps, pps := avcSample(t.pes)
+	if sps != nil {
+		if info, err := parseSPS(sps); err == nil {
+			t.sps = append([]byte{}, sps...)
+			t.width, t.height = info.width, info.height
+		}
+	}
+	if pps != nil {
+		t.pps = append([]byte{}, pps...)
+	}
+	if t.sps == nil || t.pps == nil || len(data) <= 0 {
+		// Not decodable
+		return
+	}
+
+	dts, pts := t.pesDTS, t.pesPTS
+	if dts < 0 {
+		if t.lastDTS < 0 {
+			return
+		}
+		duration := t.lastDuration
+		if duration == 0 {
+			duration = defaultVideoSampleDuration
+		}
+		t.setNextDTS(t.lastDTS + int64(duration))
+		dts, pts = t.lastDTS+int64(duration), t.lastDTS+int64(duration)
+	}
+
+	t.samples = append(t.samples, sample{data, dts, int32(pts - dts), 0, isSync})
+	t.lastDTS = dts
+}
+
+func (t *track) addAudioSamples() {
+	for i, frame := range parseADTS(t.pes) {
+		if uint32(frame.sampleRate) != t.timescale {
+			t.timescale = uint32(frame.sampleRate)
+			t.nextAudioTime = -1
+		}
+		t.audioConfig = frame.audioConfig
+		t.channels = frame.channels
+
+		if i == 0 && t.pesDTS >= 0 {
+			// Continuous (without rounding drift) unless it jumps more than 2 frames
+			pesTime := t.pesDTS * int64(t.timescale) / 90000
+			if t.nextAudioTime < 0 || math.Abs(float64(pesTime-t.nextAudioTime)) > 2*AACFrameSamples {
+				t.nextAudioTime = pesTime
+			}
+			t.lastDTS = t.pesDTS
+		}
+		if t.nextAudioTime < 0 {
+			continue
+		}
+
+		t.samples = append(t.samples, sample{append([]byte{}, frame.data...), t.nextAudioTime, 0, AACFrameSamples, true})
+		t.nextAudioTime = t.nextAudioTime + AACFrameSamples
+	}
+}
+
+// isConfigured Returns true if the decoder configuration of the track is known
+func (t *track) isConfigured() bool {
+	if t.trackType == TrackVideo {
+		return t.sps != nil && t.pps != nil
+	}
+	return t.audioConfig != nil
+}
+
+// InitSegment Returns the init segment (ftyp and moov) of the tracks with a known configuration, nil if there are none
+func (m *Muxer) InitSegment() []byte {
+	traks := [][]byte{mvhd(uint32(len(m.tracks) + 1))}
+	trexs := [][]byte{}
+	for _, t := range m.tracks {
+		if t.isConfigured() {
+			traks = append(traks, t.trak())
+			trexs = append(trexs, t.trex())
+		}
+	}
+	if len(trexs) <= 0 {
+		return nil
+	}
+
+	return append(ftyp(), box("moov", append(traks, box("mvex", trexs...))...)...)
+}
+
+// Fragment Returns the fragment (moof and mdat) of the samples completed, nil if there are none. The last video sample without the next DTS gets the previous duration
+func (m *Muxer) Fragment() []byte {
+	tracks := []*track{}
+	for _, t := range m.tracks {
+		if len(t.samples) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	if len(tracks) <= 0 {
+		return nil
+	}
+
+	for _, t := range tracks {
+		if last := &t.samples[len(t.samples)-1]; last.duration == 0 {
+			last.duration = t.lastDuration
+			if last.duration == 0 {
+				last.duration = defaultVideoSampleDuration
+			}
+		}
+	}
+
+	// The moof size does not depend on the data offsets
+	m.sequenceNumber++
+	moof := m.moof(tracks, 0)
+	moof = m.moof(tracks, len(moof)+8)
+
+	mdat := [][]byte{}
+	for _, t := range tracks {
+		for _, s := range t.samples {
+			mdat = append(mdat, s.data)
+		}
+		t.samples = t.samples[:0]
+	}
+
+	return append(moof, box("mdat", mdat...)...)
+}
+
+// moof Returns the movie fragment of the samples of the tracks, their data starts at dataOffset from the moof start
+func (m *Muxer) moof(tracks []*track, dataOffset int) []byte {
+	boxes := [][]byte{fullBox("mfhd", 0, 0, u32(m.sequenceNumber))}
+	for _, t := range tracks {
+		boxes = append(boxes, t.traf(t.samples, dataOffset))
+		for _, s := range t.samples {
+			dataOffset = dataOffset + len(s.data)
+		}
+	}
+
+	return box("moof", boxes...)
+}
@@ -0,0 +1,178 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+)
+
+// findBoxes Returns the payloads of the boxes of the type in the data (the path of types inside containers)
+func findBoxes(data []byte, path ...string) [][]byte {
+	found := [][]byte{}
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+		if string(data[4:8]) == path[0] {
+			if len(path) == 1 {
+				found = append(found, data[8:size])
+			} else {
+				found = append(found, findBoxes(data[8:size], path[1:]...)...)
+			}
+		}
+		data = data[size:]
+	}
+	return found
+}
+
+// addFixture Adds the video (PID 256) and audio (PID 257) payloads of the fixture, from the first video sync sample fragmenting at every one, returns the fragments
+func addFixture(t *testing.T, m *Muxer) [][]byte {
+	data, err := os.ReadFile("../../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading fixture. Err: ", err)
+	}
+
+	fragments := [][]byte{}
+	isStarted := false
+	p := tspacket.New(tspacket.TsDefaultPacketSize)
+	for i := 0; i+tspacket.TsDefaultPacketSize <= len(data); i = i + tspacket.TsDefaultPacketSize {
+		p.Reset()
+		p.AddData(data[i : i+tspacket.TsDefaultPacketSize])
+		if !p.Parse(-1) {
+			continue
+		}
+
+		trackType := TrackVideo
+		if p.GetPID() == 257 {
+			trackType = TrackAudio
+		} else if p.GetPID() != 256 {
+			continue
+		}
+		if trackType == TrackVideo && p.IsRandomAccess(256) {
+			m.EndPES(TrackVideo, p.GetPESDTS())
+			m.EndPES(TrackAudio, -1)
+			if fragment := m.Fragment(); fragment != nil {
+				fragments = append(fragments, fragment)
+			}
+			isStarted = true
+		}
+		if !isStarted {
+			continue
+		}
+		m.AddPayload(trackType, p.GetPayload(), p.IsPayloadUnitStart(), p.GetPESPTS(), p.GetPESDTS())
+	}
+	m.EndPES(TrackVideo, -1)
+	m.EndPES(TrackAudio, -1)
+	if fragment := m.Fragment(); fragment != nil {
+		fragments = append(fragments, fragment)
+	}
+
+	return fragments
+}
+
+func TestInitSegment(t *testing.T) {
+	m := New()
+	if m.InitSegment() != nil || m.Fragment() != nil {
+		t.Errorf("Init segment and fragment of an empty muxer are not nil")
+	}
+
+	addFixture(t, &m)
+	init := m.InitSegment()
+
+	if ftyps := findBoxes(init, "ftyp"); len(ftyps) != 1 || string(ftyps[0][:4]) != "iso6" {
+		t.Errorf("Init segment ftyp is not correct, got: %v.", ftyps)
+	}
+	traks := findBoxes(init, "moov", "trak")
+	if len(traks) != 2 {
+		t.Fatalf("Init segment tracks are not correct, got: %d, want: 2.", len(traks))
+	}
+	if trexs := findBoxes(init, "moov", "mvex", "trex"); len(trexs) != 2 {
+		t.Errorf("Init segment trex are not correct, got: %d, want: 2.", len(trexs))
+	}
+
+	avc1 := findBoxes(traks[0], "mdia", "minf", "stbl", "stsd")
+	if len(avc1) != 1 || len(findBoxes(avc1[0][8:], "avc1")) != 1 {
+		t.Fatalf("Video sample entry is not correct")
+	}
+	entry := findBoxes(avc1[0][8:], "avc1")[0]
+	width, height := binary.BigEndian.Uint16(entry[24:]), binary.BigEndian.Uint16(entry[26:])
+	if width != uint16(m.tracks[TrackVideo].width) || width == 0 || height == 0 {
+		t.Errorf("Video size is not correct, got: %dx%d.", width, height)
+	}
+	if len(findBoxes(entry[78:], "avcC")) != 1 {
+		t.Errorf("Video avcC not found")
+	}
+
+	mdhd := findBoxes(traks[1], "mdia", "mdhd")
+	if len(mdhd) != 1 {
+		t.Fatalf("Audio mdhd not found")
+	}
+	if got, xpected := binary.BigEndian.Uint32(mdhd[0][12:]), m.tracks[TrackAudio].timescale; got != xpected || got == 0 {
+		t.Errorf("Audio timescale is not correct, got: %d, want: %d.", got, xpected)
+	}
+}
+
+func TestFragments(t *testing.T) {
+	m := New()
+	fragments := addFixture(t, &m)
+
+	// An IDR every 2s (12s)
+	if len(fragments) != 6 {
+		t.Fatalf("Fragments are not correct, got: %d, want: 6.", len(fragments))
+	}
+
+	lastVideoDTS := uint64(0)
+	for i, fragment := range fragments {
+		mfhd := findBoxes(fragment, "moof", "mfhd")
+		if got := binary.BigEndian.Uint32(mfhd[0][4:]); got != uint32(i+1) {
+			t.Errorf("Fragment sequence number is not correct, got: %d, want: %d.", got, i+1)
+		}
+
+		trafs := findBoxes(fragment, "moof", "traf")
+		if len(trafs) != 2 {
+			t.Fatalf("Fragment tracks are not correct, got: %d, want: 2.", len(trafs))
+		}
+		mdats := findBoxes(fragment, "mdat")
+		if len(mdats) != 1 {
+			t.Fatalf("Fragment mdat is not correct")
+		}
+
+		// The samples are in the mdat (the last one ends it) and the video starts with a sync sample
+		videoDTS := binary.BigEndian.Uint64(findBoxes(trafs[0], "tfdt")[0][4:])
+		if i > 0 && videoDTS <= lastVideoDTS {
+			t.Errorf("Fragment video decode time is not correct, got: %d, previous: %d.", videoDTS, lastVideoDTS)
+		}
+		lastVideoDTS = videoDTS
+
+		trun := findBoxes(trafs[1], "trun")[0]
+		count, offset := binary.BigEndian.Uint32(trun[4:]), binary.BigEndian.Uint32(trun[8:])
+		size := uint32(0)
+		for s := uint32(0); s < count; s++ {
+			size = size + binary.BigEndian.Uint32(trun[16+8*s:])
+		}
+		moofSize := binary.BigEndian.Uint32(fragment)
+		if got, xpected := offset+size, moofSize+8+uint32(len(mdats[0])); got != xpected {
+			t.Errorf("Fragment audio data end is not correct, got: %d, want: %d.", got, xpected)
+		}
+
+		videoTrun := findBoxes(trafs[0], "trun")[0]
+		if flags := binary.BigEndian.Uint32(videoTrun[20:]); flags != syncSampleFlags {
+			t.Errorf("Fragment first video sample is not sync, flags: 0x%08X.", flags)
+		}
+	}
+}
+
+func TestTimestampRollover(t *testing.T) {
+	tr := newTrack(TrackVideo, 1, VideoTimescale)
+	tr.lastDTS = maxTimestamp - 3000
+
+	if got, xpected := tr.unwrap(1000), maxTimestamp+1000; got != xpected {
+		t.Errorf("Unwrapped timestamp is not correct, got: %d, want: %d.", got, xpected)
+	}
+	if got, xpected := tr.unwrap(maxTimestamp-6000), maxTimestamp-6000; got != xpected {
+		t.Errorf("Unwrapped timestamp (backwards) is not correct, got: %d, want: %d.", got, xpected)
+	}
+}
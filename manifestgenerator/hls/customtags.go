@@ -0,0 +1,96 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// SegmentInfo Chunk of the chunklist passed to the ChunkTagsCallback
+type SegmentInfo struct {
+	FileName        string
+	URI             string
+	MediaSequence   int64
+	DurationS       float64
+	IsDisco         bool
+	IsGap           bool
+	ProgramDateTime time.Time
+}
+
+// ChunkTagsCallback Returns the custom lines written before the EXTINF of the chunk (Ex: #EXT-X-ASSET for SSAI), it is called every time the chunklist is rendered so it has to return the same lines for the same chunk
+type ChunkTagsCallback func(segment SegmentInfo) []string
+
+// ValidateTag Checks a custom line, it has to be a single line that starts with # (tag or comment)
+func ValidateTag(tag string) error {
+	if !strings.HasPrefix(tag, "#") {
+		return fmt.Errorf("Custom tag %q does not start with #", tag)
+	}
+	if strings.ContainsAny(tag, "\r\n") {
+		return fmt.Errorf("Custom tag %q has more than one line", tag)
+	}
+
+	return nil
+}
+
+// LoadCustomTags Reads the custom header tags of a JSON array of strings, or a text file with one tag per line (the empty lines are skipped)
+func LoadCustomTags(fileName string) ([]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &tags); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				tags = append(tags, line)
+			}
+		}
+	}
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return nil, fmt.Errorf("%v in %s", err, fileName)
+		}
+	}
+
+	return tags, nil
+}
+
+// SetCustomTags Sets the custom lines written after the header tags of the chunklist, and the callback of the lines written before every chunk (nil none). They are in every save of the chunklist (and its delta)
+func (p *Hls) SetCustomTags(headerTags []string, chunkTags ChunkTagsCallback) error {
+	for _, tag := range headerTags {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	p.headerTags = append([]string{}, headerTags...)
+	p.chunkTags = chunkTags
+	return nil
+}
+
+// chunkCustomTags Returns the custom lines of the chunk in the position i of the chunklist, the invalid ones are skipped
+func (p *Hls) chunkCustomTags(i int) string {
+	if p.chunkTags == nil {
+		return ""
+	}
+
+	chunk := p.chunks[i]
+	ret := ""
+	for _, tag := range p.chunkTags(SegmentInfo{chunk.FileName, p.MediaURI(chunk.FileName), p.mseq + int64(i), chunk.DurationS, chunk.IsDisco, chunk.IsGap, chunk.ProgramDateTime}) {
+		if err := ValidateTag(tag); err != nil {
+			p.log.Warn("Skipped custom tag of ", chunk.FileName, ". Err: ", err)
+			continue
+		}
+		ret = ret + tag + "\n"
+	}
+
+	return ret
+}
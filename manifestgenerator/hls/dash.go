@@ -0,0 +1,272 @@
+package hls
+
+import (
+	"bytes"
+	"math"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go-ts-segmenter/uploaders/httpserver"
+	"go-ts-segmenter/uploaders/httpuploader"
+	"go-ts-segmenter/uploaders/s3uploader"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DashTimescale Timescale of the MPD SegmentTimeline (the PES timestamps clock)
+const DashTimescale = 90000
+
+// Dash MIME types of the segments
+const (
+	// DashMimeTypeTS TS segments (MPEG-2 TS simple profile)
+	DashMimeTypeTS = "video/mp2t"
+
+	// DashMimeTypeMP4 CMAF (fMP4) segments (ISO BMFF live profile)
+	DashMimeTypeMP4 = "video/mp4"
+)
+
+// DashSegment Segment of the MPD, its number ($Number$), duration, size (to measure the bandwidth) and start PTS (< 0 unknown, only the one of the 1st segment is used as presentationTimeOffset)
+type DashSegment struct {
+	Number    uint64
+	DurationS float64
+	Bytes     uint64
+	StartPTSS float64
+
+	durationTicks int64
+}
+
+// Dash MPEG-DASH manifest (MPD) of the same segments than the chunklist, single period and single representation. The segments are referenced with a SegmentTemplate ($Number$) and a SegmentTimeline, the discontinuities are not signaled
+type Dash struct {
+	log               *logrus.Logger
+	manifestType      ManifestTypes
+	targetDurS        float64
+	slidingWindowSize int
+	mpdFileName       string
+	mediaTemplate     string
+	mimeType          string
+	initFileName      string
+	segments          []DashSegment
+	startTicks        int64
+	ptoTicks          int64
+	availabilityStart time.Time
+	isClosed          bool
+	outputType        OutputTypes
+	httpUploader      *httpuploader.HTTPUploader
+	s3Uploader        *s3uploader.S3Uploader
+	httpServer        *httpserver.HTTPServer
+	isFsync           bool
+	onManifestUpdated ManifestUpdatedCallback
+	secondaryOutputs  SecondaryOutputs
+}
+
+// NewDash Creates a DASH manifest, mediaTemplate is the path of the segments with the $Number$ identifier (Ex: results/chunk_$Number%05d$.ts)
+func NewDash(
+	log *logrus.Logger,
+	manifestType ManifestTypes,
+	targetDurS float64,
+	slidingWindowSize int,
+	mpdFileName string,
+	mediaTemplate string,
+	mimeType string,
+	outputType OutputTypes,
+	httpUploader *httpuploader.HTTPUploader,
+	s3Uploader *s3uploader.S3Uploader,
+) Dash {
+	d := Dash{
+		log,
+		manifestType,
+		targetDurS,
+		slidingWindowSize,
+		mpdFileName,
+		mediaTemplate,
+		mimeType,
+		"",
+		[]DashSegment{},
+		-1,
+		0,
+		time.Time{},
+		false,
+		outputType,
+		httpUploader,
+		s3Uploader,
+		nil,
+		false,
+		nil,
+		SecondaryOutputs{},
+	}
+
+	return d
+}
+
+// SetInitSegment Sets the initialization segment of the representation (empty none)
+func (d *Dash) SetInitSegment(initFileName string) {
+	d.initFileName = initFileName
+}
+
+// SetMediaTemplate Sets the path of the segments with the $Number$ identifier (Ex: results/chan1_$Number%05d$.ts)
+func (d *Dash) SetMediaTemplate(mediaTemplate string) {
+	d.mediaTemplate = mediaTemplate
+}
+
+// SetFsync Fsyncs the MPD file before it replaces the previous one (HlsOutputModeFile)
+func (d *Dash) SetFsync(isFsync bool) {
+	d.isFsync = isFsync
+}
+
+// SetHTTPServer Sets the built-in HTTP server used by HlsOutputModeHTTPServer
+func (d *Dash) SetHTTPServer(httpServer *httpserver.HTTPServer) {
+	d.httpServer = httpServer
+}
+
+// SetOnManifestUpdated Sets the receiver of the MPD in HlsOutputModeCallback
+func (d *Dash) SetOnManifestUpdated(onManifestUpdated ManifestUpdatedCallback) {
+	d.onManifestUpdated = onManifestUpdated
+}
+
+// SetSecondaryOutputs Sets the other outputs where the MPD is saved
+func (d *Dash) SetSecondaryOutputs(secondaryOutputs SecondaryOutputs) {
+	d.secondaryOutputs = secondaryOutputs
+}
+
+// AddSegment Adds a completed segment (the ones without duration are not added), in LiveWindow the first ones are removed to keep the window size
+func (d *Dash) AddSegment(segment DashSegment, saveMPD bool) error {
+	segment.durationTicks = int64(math.Round(segment.DurationS * DashTimescale))
+	if segment.durationTicks <= 0 {
+		d.log.Warn("Segment ", segment.Number, " without duration, it is not added to the MPD")
+		return nil
+	}
+
+	if d.startTicks < 0 {
+		// The 1st segment just finished
+		d.availabilityStart = time.Now().Add(-time.Duration(segment.DurationS * float64(time.Second)))
+		if segment.StartPTSS >= 0 {
+			d.ptoTicks = int64(math.Round(segment.StartPTSS * DashTimescale))
+		}
+		d.startTicks = d.ptoTicks
+	}
+	d.segments = append(d.segments, segment)
+
+	if d.manifestType == LiveWindow {
+		for len(d.segments) > d.slidingWindowSize && len(d.segments) > 0 {
+			d.startTicks = d.startTicks + d.segments[0].durationTicks
+			d.segments = d.segments[1:]
+		}
+	}
+
+	if saveMPD {
+		return d.save()
+	}
+	return nil
+}
+
+// Close Sets the presentation as ended (static MPD with its duration) and saves it
+func (d *Dash) Close() error {
+	d.isClosed = true
+
+	return d.save()
+}
+
+func (d *Dash) save() error {
+	if len(d.segments) <= 0 {
+		return nil
+	}
+	mpd := []byte(d.String())
+	ret := saveManifest(d.mpdFileName, mpd, d.outputType, d.httpUploader, d.s3Uploader, d.httpServer, d.isFsync, d.onManifestUpdated)
+	d.secondaryOutputs.save(d.log, d.mpdFileName, mpd, d.httpUploader, d.s3Uploader, d.httpServer, d.isFsync, d.onManifestUpdated)
+
+	return ret
+}
+
+// durationTicks Returns the duration (ticks) of the segments in the MPD
+func (d *Dash) durationTicks() int64 {
+	ticks := int64(0)
+	for _, segment := range d.segments {
+		ticks = ticks + segment.durationTicks
+	}
+	return ticks
+}
+
+// bandwidth Returns the peak bandwidth (bps) of the segments in the MPD
+func (d *Dash) bandwidth() int {
+	peak := 0.0
+	for _, segment := range d.segments {
+		peak = math.Max(peak, float64(segment.Bytes*8)*DashTimescale/float64(segment.durationTicks))
+	}
+	return int(math.Ceil(peak))
+}
+
+// isoDuration Returns the duration in the xs:duration format (Ex: PT4.000S)
+func isoDuration(durationS float64) string {
+	return "PT" + strconv.FormatFloat(durationS, 'f', 3, 64) + "S"
+}
+
+// isoTime Returns the time in the xs:dateTime format (UTC, milliseconds)
+func isoTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// String Returns the MPD
+func (d *Dash) String() string {
+	var buffer bytes.Buffer
+
+	profile := "urn:mpeg:dash:profile:mp2t-simple:2011"
+	if d.mimeType == DashMimeTypeMP4 {
+		profile = "urn:mpeg:dash:profile:isoff-live:2011"
+	}
+
+	buffer.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	buffer.WriteString("<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"" + profile + "\"")
+	if d.manifestType == Vod || d.isClosed {
+		buffer.WriteString(" type=\"static\" mediaPresentationDuration=\"" + isoDuration(float64(d.startTicks-d.ptoTicks+d.durationTicks())/DashTimescale) + "\"")
+	} else {
+		buffer.WriteString(" type=\"dynamic\" availabilityStartTime=\"" + isoTime(d.availabilityStart) + "\" publishTime=\"" + isoTime(time.Now()) + "\" minimumUpdatePeriod=\"" + isoDuration(d.targetDurS) + "\"")
+		if d.manifestType == LiveWindow {
+			buffer.WriteString(" timeShiftBufferDepth=\"" + isoDuration(float64(d.durationTicks())/DashTimescale) + "\"")
+		}
+	}
+	buffer.WriteString(" minBufferTime=\"" + isoDuration(d.targetDurS) + "\">\n")
+
+	buffer.WriteString("  <Period id=\"0\" start=\"PT0S\">\n")
+	buffer.WriteString("    <AdaptationSet id=\"0\" mimeType=\"" + d.mimeType + "\" segmentAlignment=\"true\" startWithSAP=\"1\">\n")
+	buffer.WriteString("      <Representation id=\"0\" bandwidth=\"" + strconv.Itoa(d.bandwidth()) + "\">\n")
+
+	mediaPath, _ := filepath.Rel(path.Dir(d.mpdFileName), d.mediaTemplate)
+	buffer.WriteString("        <SegmentTemplate timescale=\"" + strconv.Itoa(DashTimescale) + "\" presentationTimeOffset=\"" + strconv.FormatInt(d.ptoTicks, 10) + "\" startNumber=\"" + strconv.FormatUint(d.segments[0].Number, 10) + "\" media=\"" + mediaPath + "\"")
+	if d.initFileName != "" {
+		initPath, _ := filepath.Rel(path.Dir(d.mpdFileName), d.initFileName)
+		buffer.WriteString(" initialization=\"" + initPath + "\"")
+	}
+	buffer.WriteString(">\n")
+
+	// Consecutive segments with the same duration are repeated (r)
+	buffer.WriteString("          <SegmentTimeline>\n")
+	for i := 0; i < len(d.segments); {
+		repeat := 0
+		for i+repeat+1 < len(d.segments) && d.segments[i+repeat+1].durationTicks == d.segments[i].durationTicks {
+			repeat++
+		}
+
+		buffer.WriteString("            <S")
+		if i == 0 {
+			buffer.WriteString(" t=\"" + strconv.FormatInt(d.startTicks, 10) + "\"")
+		}
+		buffer.WriteString(" d=\"" + strconv.FormatInt(d.segments[i].durationTicks, 10) + "\"")
+		if repeat > 0 {
+			buffer.WriteString(" r=\"" + strconv.Itoa(repeat) + "\"")
+		}
+		buffer.WriteString("/>\n")
+
+		i = i + repeat + 1
+	}
+	buffer.WriteString("          </SegmentTimeline>\n")
+	buffer.WriteString("        </SegmentTemplate>\n")
+
+	buffer.WriteString("      </Representation>\n")
+	buffer.WriteString("    </AdaptationSet>\n")
+	buffer.WriteString("  </Period>\n")
+	buffer.WriteString("</MPD>\n")
+
+	return buffer.String()
+}
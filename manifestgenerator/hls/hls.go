@@ -2,13 +2,19 @@ package hls
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"math"
+	"net/url"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"go-ts-segmenter/manifestgenerator/atomicfile"
+	"go-ts-segmenter/uploaders/httpserver"
 	"go-ts-segmenter/uploaders/httpuploader"
 	"go-ts-segmenter/uploaders/s3uploader"
 
@@ -18,6 +24,54 @@ import (
 // Version Indicates the package version
 var Version = "1.0.0"
 
+// DefaultMasterVersion HLS version of the master playlist
+const DefaultMasterVersion = 3
+
+// PartsMaxTargetDurations Parts of the chunks more than these target durations from the end are removed from the chunklist
+const PartsMaxTargetDurations = 3
+
+// DefaultExtinfPrecision Decimal places of the EXTINF durations
+const DefaultExtinfPrecision = 8
+
+// Min EXT-X-SERVER-CONTROL values (RFC 8216bis section 4.4.3.8), in target durations or part targets
+const (
+	// HoldBackMinTargetDurations Min HOLD-BACK in target durations (default value)
+	HoldBackMinTargetDurations = 3
+
+	// PartHoldBackMinPartTargets Min PART-HOLD-BACK in part targets
+	PartHoldBackMinPartTargets = 2
+
+	// PartHoldBackDefaultPartTargets Default PART-HOLD-BACK in part targets
+	PartHoldBackDefaultPartTargets = 3
+
+	// CanSkipUntilMinTargetDurations Min CAN-SKIP-UNTIL in target durations (default value)
+	CanSkipUntilMinTargetDurations = 6
+)
+
+// DeltaMinVersion Min EXT-X-VERSION of the delta chunklist (EXT-X-SKIP)
+const DeltaMinVersion = 9
+
+// GapMinVersion Min EXT-X-VERSION of a chunklist with EXT-X-GAP
+const GapMinVersion = 8
+
+// RequiredVersion Returns the min EXT-X-VERSION of a chunklist with the features (RFC 8216 section 7): decimal EXTINF (extinfPrecision > 0) 3, byte ranges or I-frames only 4, EXT-X-MAP 6 (5 in I-frames only)
+func RequiredVersion(extinfPrecision int, hasByteRanges bool, hasMap bool, isIFramesOnly bool) int {
+	version := 1
+	if extinfPrecision > 0 {
+		version = 3
+	}
+	if hasByteRanges || isIFramesOnly {
+		version = 4
+	}
+	if hasMap && isIFramesOnly {
+		version = 5
+	} else if hasMap {
+		version = 6
+	}
+
+	return version
+}
+
 // ManifestTypes indicates the manifest type
 type ManifestTypes int
 
@@ -32,6 +86,20 @@ const (
 	LiveWindow
 )
 
+// PublishPolicies indicates when the chunklist is saved to the output
+type PublishPolicies int
+
+const (
+	// PublishOnChange Saves the chunklist on every change
+	PublishOnChange PublishPolicies = iota
+
+	// PublishCoalesced Saves the chunklist as soon as a chunk is completed, the rest of the changes (parts, preload hints, growing chunks) at most every publish interval (checked on the next change)
+	PublishCoalesced
+
+	// PublishOnChunk Saves the chunklist only when a chunk is completed (and when it is closed)
+	PublishOnChunk
+)
+
 // OutputTypes indicates the manifest type
 type OutputTypes int
 
@@ -47,14 +115,180 @@ const (
 
 	// HlsOutputModeS3 data to S3 (using AWS API)
 	HlsOutputModeS3
+
+	// HlsOutputModeHTTPServer data served from the built-in HTTP server
+	HlsOutputModeHTTPServer
+
+	// HlsOutputModeCallback data passed to the manifest updated callback (SetOnManifestUpdated)
+	HlsOutputModeCallback
 )
 
-// Chunk Chunk information
+// ManifestUpdatedCallback Receiver of the manifests in HlsOutputModeCallback (body is the whole manifest, it is not modified after the call), name is the manifest filename
+type ManifestUpdatedCallback func(name string, body []byte)
+
+// Chunk Chunk information (InitFileName is the init chunk used by it, if empty the current one is set when it is added, ProgramDateTime is the wall clock time of its 1st sample, zero if unknown)
 type Chunk struct {
-	IsGrowing bool
-	FileName  string
-	DurationS float64
-	IsDisco   bool
+	IsGrowing       bool
+	FileName        string
+	DurationS       float64
+	IsDisco         bool
+	DateRanges      []DateRange
+	InitFileName    string
+	ProgramDateTime time.Time
+
+	// ByteRangeLength and ByteRangeOffset Position of the chunk in FileName (EXT-X-BYTERANGE), length 0 is a whole file chunk
+	ByteRangeLength int64
+	ByteRangeOffset int64
+
+	// Parts LL-HLS parts of the chunk, they are removed when the chunk is more than PartsMaxTargetDurations from the end
+	Parts []Part
+
+	// Key Encryption of the chunk (EXT-X-KEY), nil not encrypted
+	Key *Key
+
+	// IsGap The chunk is not available (EXT-X-GAP, Ex: its upload failed), it keeps its duration in the timeline
+	IsGap bool
+
+	// Bytes Size of the chunk (EXT-X-BITRATE), 0 unknown (Ex: growing chunk)
+	Bytes uint64
+
+	// discontinuitySeq Discontinuity sequence number of the chunk (discontinuities since the 1st chunk, including its own), set when it is added
+	discontinuitySeq int64
+}
+
+// State Media and discontinuity sequences (EXT-X-MEDIA-SEQUENCE and EXT-X-DISCONTINUITY-SEQUENCE) and completed chunks of the chunklist, to restore it after a restart
+type State struct {
+	MediaSequence         int64   `json:"mediaSequence"`
+	DiscontinuitySequence int64   `json:"discontinuitySequence"`
+	Chunks                []Chunk `json:"chunks"`
+}
+
+// Window Additional chunklist of the same chunks (media and discontinuity sequences, target duration) with its own sliding window (Ex: DVR), WindowSize <= 0 all the chunks since the start (EVENT). It is saved to FileName in OutputType every time a chunk is completed
+type Window struct {
+	FileName   string
+	WindowSize int
+	OutputType OutputTypes
+}
+
+// SecondaryOutputs Other outputs where a manifest is also saved (its output type is the primary one). Their errors are reported to OnFailed (logged if nil) and they are not returned
+type SecondaryOutputs struct {
+	OutputTypes []OutputTypes
+	OnFailed    func(outputType OutputTypes, fileName string, err error)
+}
+
+// failed Reports the error saving the manifest to a secondary output
+func (s *SecondaryOutputs) failed(log *logrus.Logger, outputType OutputTypes, fileName string, err error) {
+	if s.OnFailed != nil {
+		s.OnFailed(outputType, fileName, err)
+	} else {
+		log.Error("Error saving manifest ", fileName, " to the secondary output ", outputType, ". Err: ", err)
+	}
+}
+
+// save Saves the manifest to the secondary outputs
+func (s *SecondaryOutputs) save(log *logrus.Logger, fileName string, manifestByte []byte, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader, httpServer *httpserver.HTTPServer, isFsync bool, onUpdated ManifestUpdatedCallback) {
+	for _, outputType := range s.OutputTypes {
+		if err := saveManifest(fileName, manifestByte, outputType, httpUploader, s3Uploader, httpServer, isFsync, onUpdated); err != nil {
+			s.failed(log, outputType, fileName, err)
+		}
+	}
+}
+
+// ServerControl EXT-X-SERVER-CONTROL values, the ones <= 0 are computed from the target duration / part target, and the ones below the min are raised to it
+type ServerControl struct {
+	CanBlockReload bool
+	HoldBackS      float64
+	PartHoldBackS  float64
+
+	// CanSkip Delta updates (CAN-SKIP-UNTIL) are available
+	CanSkip       bool
+	CanSkipUntilS float64
+}
+
+// Part LL-HLS partial segment (EXT-X-PART), independent if it starts with a keyframe
+type Part struct {
+	FileName      string
+	DurationS     float64
+	IsIndependent bool
+}
+
+// String Returns the EXT-X-PART tag with the URI
+func (p *Part) String(uri string) string {
+	ret := "#EXT-X-PART:DURATION=" + strconv.FormatFloat(p.DurationS, 'f', 5, 64) + ",URI=\"" + uri + "\""
+	if p.IsIndependent {
+		ret = ret + ",INDEPENDENT=YES"
+	}
+
+	return ret + "\n"
+}
+
+// Key EXT-X-KEY information, the URI is written as it is, the IV (empty not written) in hexadecimal and the KEYFORMAT / KEYFORMATVERSIONS if not empty
+type Key struct {
+	Method            string
+	URI               string
+	IV                []byte
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// String Returns the EXT-X-KEY tag
+func (k *Key) String() string {
+	ret := "#EXT-X-KEY:METHOD=" + k.Method
+	if k.URI != "" {
+		ret = ret + ",URI=\"" + k.URI + "\""
+	}
+	if len(k.IV) > 0 {
+		ret = ret + ",IV=0x" + hex.EncodeToString(k.IV)
+	}
+	if k.KeyFormat != "" {
+		ret = ret + ",KEYFORMAT=\"" + k.KeyFormat + "\""
+	}
+	if k.KeyFormatVersions != "" {
+		ret = ret + ",KEYFORMATVERSIONS=\"" + k.KeyFormatVersions + "\""
+	}
+
+	return ret + "\n"
+}
+
+// keyTag Returns the EXT-X-KEY tag of the chunk (METHOD=NONE if it is not encrypted)
+func (c *Chunk) keyTag() string {
+	if c.Key == nil {
+		return "#EXT-X-KEY:METHOD=NONE\n"
+	}
+	return c.Key.String()
+}
+
+// DateRange EXT-X-DATERANGE information (SCTE-35 signaling), durations < 0 and empty SCTE-35 data are not written
+type DateRange struct {
+	ID               string
+	StartDate        time.Time
+	DurationS        float64
+	PlannedDurationS float64
+	SCTE35Cmd        string
+	SCTE35Out        string
+	SCTE35In         string
+}
+
+// String Returns the EXT-X-DATERANGE tag
+func (d *DateRange) String() string {
+	ret := "#EXT-X-DATERANGE:ID=\"" + d.ID + "\",START-DATE=\"" + d.StartDate.UTC().Format("2006-01-02T15:04:05.000Z") + "\""
+	if d.DurationS >= 0 {
+		ret = ret + ",DURATION=" + fmt.Sprintf("%.3f", d.DurationS)
+	}
+	if d.PlannedDurationS >= 0 {
+		ret = ret + ",PLANNED-DURATION=" + fmt.Sprintf("%.3f", d.PlannedDurationS)
+	}
+	if d.SCTE35Cmd != "" {
+		ret = ret + ",SCTE35-CMD=\"" + d.SCTE35Cmd + "\""
+	}
+	if d.SCTE35Out != "" {
+		ret = ret + ",SCTE35-OUT=\"" + d.SCTE35Out + "\""
+	}
+	if d.SCTE35In != "" {
+		ret = ret + ",SCTE35-IN=\"" + d.SCTE35In + "\""
+	}
+
+	return ret + "\n"
 }
 
 // Hls Hls chunklist
@@ -74,6 +308,34 @@ type Hls struct {
 	httpUploader          *httpuploader.HTTPUploader
 	s3Uploader            *s3uploader.S3Uploader
 	isClosed              bool
+	programDateTimeEvery  int
+	isIFramesOnly         bool
+	extinfPrecision       int
+	pinnedVersion         int
+	isStart               bool
+	startTimeOffsetS      float64
+	isStartPrecise        bool
+	partTargetS           float64
+	pendingParts          []Part
+	preloadHintFileName   string
+	isServerControl       bool
+	serverControl         ServerControl
+	deltaFileName         string
+	httpServer            *httpserver.HTTPServer
+	publishPolicy         PublishPolicies
+	publishInterval       time.Duration
+	lastPublishAt         time.Time
+	isChunkPending        bool
+	isFsync               bool
+	uriPrefix             string
+	headerTags            []string
+	chunkTags             ChunkTagsCallback
+	windows               []Window
+	history               []Chunk
+	isBitrate             bool
+	onChunksRemoved       func(chunks []Chunk)
+	onManifestUpdated     ManifestUpdatedCallback
+	secondaryOutputs      SecondaryOutputs
 }
 
 // New Creates a hls chunklist manifest
@@ -106,25 +368,303 @@ func New(
 		httpUploader,
 		s3Uploader,
 		false,
+		0,
+		false,
+		DefaultExtinfPrecision,
+		0,
+		false,
+		0,
+		false,
+		0,
+		[]Part{},
+		"",
+		false,
+		ServerControl{},
+		"",
+		nil,
+		PublishOnChange,
+		0,
+		time.Time{},
+		false,
+		false,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		false,
+		nil,
+		nil,
+		SecondaryOutputs{},
 	}
 
 	return h
 }
 
-// SetInitChunk Adds a chunk init infomation
+// SetMediaSequence Sets the media sequence of the 1st chunk (Ex: numbering from the wall clock), it has to be set before adding chunks
+func (p *Hls) SetMediaSequence(mseq int64) {
+	if len(p.chunks) <= 0 {
+		p.mseq = mseq
+	}
+}
+
+// GetState Returns the state of the chunklist, the growing chunks (LHLS) are not included and the parts are removed
+func (p *Hls) GetState() State {
+	state := State{p.mseq, p.discontinuitySequence(), []Chunk{}}
+	for _, chunk := range p.chunks {
+		if chunk.IsGrowing {
+			break
+		}
+		chunk.Parts = nil
+		state.Chunks = append(state.Chunks, chunk)
+	}
+	return state
+}
+
+// GetChunk Returns the chunk of the chunklist with the filename, false if it is not in it
+func (p *Hls) GetChunk(fileName string) (Chunk, bool) {
+	for i := len(p.chunks) - 1; i >= 0; i-- {
+		if p.chunks[i].FileName == fileName {
+			return p.chunks[i], true
+		}
+	}
+	return Chunk{}, false
+}
+
+// RestoreState Restores the chunks and sequences of a previous state (Ex: after a restart), it has to be done before adding chunks. The chunklist is saved with the next chunk
+func (p *Hls) RestoreState(state State) error {
+	if len(p.chunks) > 0 {
+		return errors.New("chunklist state restored after adding chunks")
+	}
+	if state.MediaSequence < 0 || state.DiscontinuitySequence < 0 {
+		return fmt.Errorf("invalid chunklist state sequences %d / %d", state.MediaSequence, state.DiscontinuitySequence)
+	}
+
+	p.mseq = state.MediaSequence
+	p.dseq = state.DiscontinuitySequence
+	for _, chunk := range state.Chunks {
+		if chunk.FileName == "" {
+			return errors.New("chunklist state chunk without filename")
+		}
+		if chunk.IsDisco {
+			p.dseq++
+		}
+		chunk.IsGrowing = false
+		chunk.discontinuitySeq = p.dseq
+		p.chunks = append(p.chunks, chunk)
+		p.updateTargetDuration(chunk)
+	}
+	return nil
+}
+
+// SetInitChunk Adds a chunk init infomation, it is used by the chunks added from now on
 func (p *Hls) SetInitChunk(initChunkFileName string) {
 	p.initChunkDataFileName = initChunkFileName
 }
 
+// SetHTTPServer Sets the built-in HTTP server used by HlsOutputModeHTTPServer
+func (p *Hls) SetHTTPServer(httpServer *httpserver.HTTPServer) {
+	p.httpServer = httpServer
+}
+
+// SetOnManifestUpdated Sets the receiver of the chunklists (also the delta and windows ones) in HlsOutputModeCallback
+func (p *Hls) SetOnManifestUpdated(onManifestUpdated ManifestUpdatedCallback) {
+	p.onManifestUpdated = onManifestUpdated
+}
+
+// SetSecondaryOutputs Sets the other outputs where the chunklist (also the delta) is saved, the windows have their own output
+func (p *Hls) SetSecondaryOutputs(secondaryOutputs SecondaryOutputs) {
+	p.secondaryOutputs = secondaryOutputs
+}
+
+// SetFsync Fsyncs the chunklist files before they replace the previous ones (HlsOutputModeFile)
+func (p *Hls) SetFsync(isFsync bool) {
+	p.isFsync = isFsync
+}
+
+// SetPublishPolicy Sets when the chunklist is saved, the interval is only used by PublishCoalesced
+func (p *Hls) SetPublishPolicy(publishPolicy PublishPolicies, publishInterval time.Duration) {
+	p.publishPolicy = publishPolicy
+	p.publishInterval = publishInterval
+}
+
+// saveChunklist Saves the chunklist if the publish policy allows it, a completed chunk (even one changed without saving) is never delayed
 func (p *Hls) saveChunklist() error {
+	if !p.isChunkPending && !p.isClosed {
+		if p.publishPolicy == PublishOnChunk {
+			return nil
+		}
+		if p.publishPolicy == PublishCoalesced && time.Since(p.lastPublishAt) < p.publishInterval {
+			return nil
+		}
+	}
+	isChunkCompleted := p.isChunkPending || p.isClosed
+	p.isChunkPending = false
+	p.lastPublishAt = time.Now()
+
+	ret := p.publishChunklist()
+	if isChunkCompleted {
+		if err := p.publishWindows(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// publishChunklist Saves the chunklist (and the delta) to the output and to the secondary ones
+func (p *Hls) publishChunklist() error {
+	ret := p.publishChunklistTo(p.outputType)
+
+	for _, outputType := range p.secondaryOutputs.OutputTypes {
+		if err := p.publishChunklistTo(outputType); err != nil {
+			p.secondaryOutputs.failed(p.log, outputType, p.chunklistFileName, err)
+		}
+	}
+	return ret
+}
+
+// publishChunklistTo Saves the chunklist (and the delta) to the output type
+func (p *Hls) publishChunklistTo(outputType OutputTypes) error {
+	if outputType == HlsOutputModeHTTPServer && p.httpServer != nil && p.chunklistFileName != "" {
+		// The delta is also published as the _HLS_skip=YES response of the chunklist
+		deltaData := []byte(nil)
+		if p.deltaFileName != "" {
+			deltaData = []byte(p.DeltaString())
+		}
+		p.httpServer.PutPlaylist(p.chunklistFileName, []byte(p.String()), deltaData, p.playlistState())
+	} else {
+		if p.isFsync && outputType == HlsOutputModeFile {
+			defer func(startedAt time.Time) {
+				p.log.Debug("Chunklist ", p.chunklistFileName, " saved with fsync in ", time.Since(startedAt))
+			}(time.Now())
+		}
+		ret := saveManifest(p.chunklistFileName, []byte(p.String()), outputType, p.httpUploader, p.s3Uploader, p.httpServer, p.isFsync, p.onManifestUpdated)
+		if ret != nil {
+			return ret
+		}
+	}
+
+	if p.deltaFileName != "" {
+		return saveManifest(p.deltaFileName, []byte(p.DeltaString()), outputType, p.httpUploader, p.s3Uploader, p.httpServer, p.isFsync, p.onManifestUpdated)
+	}
+	return nil
+}
+
+// AddWindow Adds a window chunklist, the chunks removed from the live window are retained (only in memory) until they are out of the largest one
+func (p *Hls) AddWindow(window Window) {
+	p.windows = append(p.windows, window)
+}
+
+// publishWindows Saves the window chunklists to their outputs
+func (p *Hls) publishWindows() error {
 	ret := error(nil)
+	for _, window := range p.windows {
+		w := p.windowChunklist(window)
+		if err := w.publishChunklist(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
 
-	hlsStrByte := []byte(p.String())
+// windowChunklist Returns a copy of the chunklist with the retained chunks and the window size of the window (without delta)
+func (p *Hls) windowChunklist(window Window) Hls {
+	w := *p
+	w.chunks = append(append(make([]Chunk, 0, len(p.history)+len(p.chunks)), p.history...), p.chunks...)
+	w.mseq = p.mseq - int64(len(p.history))
+	w.chunklistFileName = window.FileName
+	w.outputType = window.OutputType
+	w.secondaryOutputs = SecondaryOutputs{}
+	w.deltaFileName = ""
+	w.windows = nil
+	w.history = nil
+	w.onChunksRemoved = nil
+	if p.manifestType != Vod {
+		w.manifestType = LiveWindow
+		w.slidingWindowSize = window.WindowSize
+		if window.WindowSize <= 0 {
+			w.manifestType = LiveEvent
+		}
+	}
+	w.slideWindow()
+
+	return w
+}
+
+// WindowString Returns the chunklist of the window i (in the order they were added)
+func (p *Hls) WindowString(i int) string {
+	w := p.windowChunklist(p.windows[i])
+	return w.String()
+}
 
-	if p.outputType == HlsOutputModeFile {
-		ret = p.saveManifestToFile(hlsStrByte)
-	} else if p.outputType == HlsOutputModeHTTP || p.outputType == HlsOutputModeS3 {
-		ret = p.saveManifestExternal(hlsStrByte, p.outputType)
+// SetOnChunksRemoved Sets the function called (nil none) with the chunks that are not in the chunklist or its windows anymore (Ex: to delete their files), only in LiveWindow
+func (p *Hls) SetOnChunksRemoved(onChunksRemoved func(chunks []Chunk)) {
+	p.onChunksRemoved = onChunksRemoved
+}
+
+// chunksRemoved Reports the chunks that are not in any chunklist
+func (p *Hls) chunksRemoved(chunks []Chunk) {
+	if p.onChunksRemoved != nil && len(chunks) > 0 {
+		p.onChunksRemoved(append([]Chunk{}, chunks...))
+	}
+}
+
+// retainChunks Keeps the chunks removed from the live window that are still in a window chunklist (all if one has no window size), the rest are reported as removed
+func (p *Hls) retainChunks(removed []Chunk) {
+	if len(removed) <= 0 {
+		return
+	}
+	if len(p.windows) <= 0 {
+		p.chunksRemoved(removed)
+		return
+	}
+
+	maxWindowSize := 0
+	for _, window := range p.windows {
+		if window.WindowSize <= 0 {
+			p.history = append(p.history, removed...)
+			return
+		}
+		if window.WindowSize > maxWindowSize {
+			maxWindowSize = window.WindowSize
+		}
+	}
+
+	p.history = append(p.history, removed...)
+	retained := maxWindowSize - p.slidingWindowSize
+	if retained < 0 {
+		retained = 0
+	}
+	if len(p.history) > retained {
+		p.chunksRemoved(p.history[:len(p.history)-retained])
+		p.history = append([]Chunk{}, p.history[len(p.history)-retained:]...)
+	}
+}
+
+// playlistState Returns the media sequence of the last completed chunk (the growing ones are not) and the last part of the next one
+func (p *Hls) playlistState() httpserver.PlaylistState {
+	completed := int64(0)
+	for _, chunk := range p.chunks {
+		if !chunk.IsGrowing {
+			completed++
+		}
+	}
+
+	return httpserver.PlaylistState{MSN: p.mseq + completed - 1, Part: len(p.pendingParts) - 1, IsEnded: p.isClosed}
+}
+
+// saveManifest Saves the manifest to the output, isFsync only applies to the files and onUpdated to the callback
+func saveManifest(fileName string, manifestByte []byte, outputType OutputTypes, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader, httpServer *httpserver.HTTPServer, isFsync bool, onUpdated ManifestUpdatedCallback) error {
+	ret := error(nil)
+
+	if outputType == HlsOutputModeFile {
+		ret = saveManifestToFile(fileName, manifestByte, isFsync)
+	} else if outputType == HlsOutputModeHTTP || outputType == HlsOutputModeS3 {
+		ret = saveManifestExternal(fileName, manifestByte, outputType, httpUploader, s3Uploader)
+	} else if outputType == HlsOutputModeHTTPServer && httpServer != nil && fileName != "" {
+		httpServer.Put(fileName, manifestByte)
+	} else if outputType == HlsOutputModeCallback && onUpdated != nil && fileName != "" {
+		onUpdated(fileName, manifestByte)
 	}
 	return ret
 }
@@ -142,14 +682,255 @@ func (p *Hls) CloseManifest(saveChunklist bool) error {
 	return ret
 }
 
-// SetHlsVersion Sets manifest version
+// IsClosed Returns true if the chunklist has the EXT-X-ENDLIST
+func (p *Hls) IsClosed() bool {
+	return p.isClosed
+}
+
+// ConvertToVod Converts the chunklist (Ex: EVENT) to VOD, without the live only data (LL-HLS parts, preload hint, server control and delta) and the growing chunks not finalized. It is saved when it is closed with CloseManifest
+func (p *Hls) ConvertToVod() {
+	p.manifestType = Vod
+	p.isServerControl = false
+	p.partTargetS = 0
+	p.pendingParts = []Part{}
+	p.preloadHintFileName = ""
+	p.deltaFileName = ""
+
+	chunks := make([]Chunk, 0, len(p.chunks))
+	for _, chunk := range p.chunks {
+		if !chunk.IsGrowing {
+			chunk.Parts = nil
+			chunks = append(chunks, chunk)
+		}
+	}
+	p.chunks = chunks
+}
+
+// SetProgramDateTime Sets every how many chunks (by media sequence, <= 0 disabled) the EXT-X-PROGRAM-DATE-TIME is written, it is always written in the 1st chunk and after a discontinuity
+func (p *Hls) SetProgramDateTime(everyNChunks int) {
+	p.programDateTimeEvery = everyNChunks
+}
+
+// SetIndependentSegments Sets if all the chunks can be decoded without the previous ones (EXT-X-INDEPENDENT-SEGMENTS)
+func (p *Hls) SetIndependentSegments(isIndependentSegments bool) {
+	p.isIndependentSegments = isIndependentSegments
+}
+
+// SetIFramesOnly Sets if the chunks are I-frames (EXT-X-I-FRAMES-ONLY, version 4+), their durations are the spans to the next I-frame
+func (p *Hls) SetIFramesOnly(isIFramesOnly bool) {
+	p.isIFramesOnly = isIFramesOnly
+}
+
+// SetHlsVersion Sets manifest version, it is the min version written (it is increased if the features in use need it)
 func (p *Hls) SetHlsVersion(version int) {
 	p.version = version
 }
 
-func (p *Hls) saveManifestToFile(manifestByte []byte) error {
-	if p.chunklistFileName != "" {
-		err := ioutil.WriteFile(p.chunklistFileName, manifestByte, 0644)
+// SetPartTarget Sets the LL-HLS part target duration (EXT-X-PART-INF, <= 0 no parts)
+func (p *Hls) SetPartTarget(partTargetS float64) {
+	p.partTargetS = partTargetS
+}
+
+// AddPart Adds a part of the chunk being generated, the parts are moved to the chunk when it is added
+func (p *Hls) AddPart(part Part, saveChunklist bool) error {
+	ret := error(nil)
+
+	p.pendingParts = append(p.pendingParts, part)
+	p.removeOldParts()
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
+
+	return ret
+}
+
+// SetPreloadHint Sets the part that is being generated (EXT-X-PRELOAD-HINT), empty removes it. It is not written once the chunklist is closed
+func (p *Hls) SetPreloadHint(fileName string, saveChunklist bool) error {
+	p.preloadHintFileName = fileName
+
+	if saveChunklist {
+		return p.saveChunklist()
+	}
+	return nil
+}
+
+// removeOldParts Removes the parts of the chunks that start more than PartsMaxTargetDurations from the end of the chunklist
+func (p *Hls) removeOldParts() {
+	durationS := 0.0
+	for _, part := range p.pendingParts {
+		durationS = durationS + part.DurationS
+	}
+	for i := len(p.chunks) - 1; i >= 0; i-- {
+		durationS = durationS + p.chunks[i].DurationS
+		if durationS > PartsMaxTargetDurations*p.targetDurS {
+			p.chunks[i].Parts = nil
+		}
+	}
+}
+
+// SetStart Sets the EXT-X-START of the chunklist (< 0 offset from the end), the offset is clamped to the chunklist duration
+func (p *Hls) SetStart(isEnabled bool, timeOffsetS float64, isPrecise bool) {
+	p.isStart = isEnabled
+	p.startTimeOffsetS = timeOffsetS
+	p.isStartPrecise = isPrecise
+}
+
+// startTag Returns the EXT-X-START tag, with the offset clamped to the duration of the chunks
+func (p *Hls) startTag() string {
+	durationS := 0.0
+	for _, chunk := range p.chunks {
+		durationS = durationS + chunk.DurationS
+	}
+	timeOffsetS := math.Max(-durationS, math.Min(durationS, p.startTimeOffsetS))
+
+	ret := "#EXT-X-START:TIME-OFFSET=" + strconv.FormatFloat(timeOffsetS, 'f', 3, 64)
+	if p.isStartPrecise {
+		ret = ret + ",PRECISE=YES"
+	}
+
+	return ret + "\n"
+}
+
+// SetServerControl Sets the EXT-X-SERVER-CONTROL of the chunklist, the computed values follow the target duration (recomputed when it changes)
+func (p *Hls) SetServerControl(isEnabled bool, serverControl ServerControl) {
+	p.isServerControl = isEnabled
+	p.serverControl = serverControl
+}
+
+// SetDelta Saves the delta chunklist (EXT-X-SKIP) to deltaFileName with the full one (empty disabled), it enables CAN-SKIP-UNTIL (<= 0 computed) in the EXT-X-SERVER-CONTROL
+func (p *Hls) SetDelta(deltaFileName string, canSkipUntilS float64) {
+	p.deltaFileName = deltaFileName
+	p.serverControl.CanSkip = deltaFileName != ""
+	p.serverControl.CanSkipUntilS = canSkipUntilS
+}
+
+// serverControlValue Returns the value, or the default if it is <= 0, never lower than the min
+func serverControlValue(valueS float64, defaultS float64, minS float64) float64 {
+	if valueS <= 0 {
+		valueS = defaultS
+	}
+	return math.Max(valueS, minS)
+}
+
+// canSkipUntilS Returns the CAN-SKIP-UNTIL (skip boundary)
+func (p *Hls) canSkipUntilS() float64 {
+	minS := CanSkipUntilMinTargetDurations * math.Round(p.targetDurS)
+	return serverControlValue(p.serverControl.CanSkipUntilS, minS, minS)
+}
+
+// skippedChunks Returns the number of chunks of the delta chunklist that are skipped, the ones that start before the skip boundary from the end
+func (p *Hls) skippedChunks() int {
+	durationS := 0.0
+	for _, chunk := range p.chunks {
+		durationS = durationS + chunk.DurationS
+	}
+
+	skipped := 0
+	startS := 0.0
+	canSkipUntilS := p.canSkipUntilS()
+	for _, chunk := range p.chunks {
+		if durationS-startS <= canSkipUntilS {
+			break
+		}
+		startS = startS + chunk.DurationS
+		skipped++
+	}
+
+	return skipped
+}
+
+// serverControlTag Returns the EXT-X-SERVER-CONTROL tag, PART-HOLD-BACK only with parts
+func (p *Hls) serverControlTag() string {
+	targetDurS := math.Round(p.targetDurS)
+	attrs := []string{}
+
+	if p.serverControl.CanSkip {
+		attrs = append(attrs, "CAN-SKIP-UNTIL="+strconv.FormatFloat(p.canSkipUntilS(), 'f', 3, 64))
+	}
+	if p.serverControl.CanBlockReload {
+		attrs = append(attrs, "CAN-BLOCK-RELOAD=YES")
+	}
+	minS := HoldBackMinTargetDurations * targetDurS
+	attrs = append(attrs, "HOLD-BACK="+strconv.FormatFloat(serverControlValue(p.serverControl.HoldBackS, minS, minS), 'f', 3, 64))
+	if p.partTargetS > 0 {
+		partHoldBackS := serverControlValue(p.serverControl.PartHoldBackS, PartHoldBackDefaultPartTargets*p.partTargetS, PartHoldBackMinPartTargets*p.partTargetS)
+		attrs = append(attrs, "PART-HOLD-BACK="+strconv.FormatFloat(partHoldBackS, 'f', 3, 64))
+	}
+
+	return "#EXT-X-SERVER-CONTROL:" + strings.Join(attrs, ",") + "\n"
+}
+
+// PinVersion Sets the version written regardless of the features in use (<= 0 not pinned)
+func (p *Hls) PinVersion(version int) {
+	p.pinnedVersion = version
+}
+
+// SetExtinfPrecision Sets the decimal places of the EXTINF durations (0 rounded integers)
+func (p *Hls) SetExtinfPrecision(extinfPrecision int) {
+	p.extinfPrecision = extinfPrecision
+}
+
+// SetBitrate Writes the EXT-X-BITRATE of every chunk with a known size, except the byte ranges
+func (p *Hls) SetBitrate(isEnabled bool) {
+	p.isBitrate = isEnabled
+}
+
+// bitrateTag Returns the EXT-X-BITRATE tag of the chunk (kbps of its size and duration), empty if it is disabled, a byte range or the size is not known yet
+func (p *Hls) bitrateTag(chunk Chunk) string {
+	if !p.isBitrate || chunk.IsGrowing || chunk.Bytes <= 0 || chunk.DurationS <= 0 || chunk.ByteRangeLength > 0 {
+		return ""
+	}
+
+	return "#EXT-X-BITRATE:" + strconv.FormatFloat(math.Round(float64(chunk.Bytes)*8/1000/chunk.DurationS), 'f', 0, 64) + "\n"
+}
+
+// formatDuration Returns the EXTINF duration, rounded to the precision
+func (p *Hls) formatDuration(durationS float64) string {
+	if p.extinfPrecision <= 0 {
+		return strconv.FormatFloat(math.Round(durationS), 'f', 0, 64)
+	}
+
+	return strconv.FormatFloat(durationS, 'f', p.extinfPrecision, 64)
+}
+
+// currentVersion Returns the version written, the pinned one or the min needed by the chunks in the chunklist
+func (p *Hls) currentVersion() int {
+	if p.pinnedVersion > 0 {
+		return p.pinnedVersion
+	}
+
+	hasByteRanges, hasMap, hasIV, hasKeyFormat, hasGap := false, p.initChunkDataFileName != "", false, false, false
+	for _, chunk := range p.chunks {
+		hasGap = hasGap || chunk.IsGap
+		hasByteRanges = hasByteRanges || chunk.ByteRangeLength > 0
+		hasMap = hasMap || chunk.InitFileName != ""
+		hasIV = hasIV || (chunk.Key != nil && len(chunk.Key.IV) > 0)
+		hasKeyFormat = hasKeyFormat || (chunk.Key != nil && (chunk.Key.KeyFormat != "" || chunk.Key.KeyFormatVersions != "" || chunk.Key.Method == "SAMPLE-AES"))
+	}
+	required := RequiredVersion(p.extinfPrecision, hasByteRanges, hasMap, p.isIFramesOnly)
+	if hasIV && required < 2 {
+		// EXT-X-KEY IV attribute
+		required = 2
+	}
+	if hasKeyFormat && required < 5 {
+		// EXT-X-KEY KEYFORMAT / KEYFORMATVERSIONS, and SAMPLE-AES
+		required = 5
+	}
+	if hasGap && required < GapMinVersion {
+		required = GapMinVersion
+	}
+	if required > p.version {
+		return required
+	}
+
+	return p.version
+}
+
+// saveManifestToFile Replaces the file atomically (temp file renamed over it), the origin never serves a truncated manifest
+func saveManifestToFile(fileName string, manifestByte []byte, isFsync bool) error {
+	if fileName != "" {
+		err := atomicfile.WriteFile(fileName, manifestByte, 0644, isFsync)
 		if err != nil {
 			return err
 		}
@@ -158,18 +939,26 @@ func (p *Hls) saveManifestToFile(manifestByte []byte) error {
 	return nil
 }
 
-func (p *Hls) saveManifestExternal(manifestByte []byte, outputType OutputTypes) error {
-	if p.chunklistFileName != "" {
+func saveManifestExternal(fileName string, manifestByte []byte, outputType OutputTypes, httpUploader *httpuploader.HTTPUploader, s3Uploader *s3uploader.S3Uploader) error {
+	if fileName != "" {
 		h := make(map[string]string)
-		if strings.ToLower(path.Ext(p.chunklistFileName)) == ".m3u8" {
+		if strings.ToLower(path.Ext(fileName)) == ".m3u8" {
 			h["Content-Type"] = "application/vnd.apple.mpegurl"
+		} else if strings.ToLower(path.Ext(fileName)) == ".mpd" {
+			h["Content-Type"] = "application/dash+xml"
 		}
 
 		// TODO: Use interfaces
 		if outputType == HlsOutputModeS3 {
-			return p.s3Uploader.UploadData(manifestByte, p.chunklistFileName, h)
+			return s3Uploader.UploadData(manifestByte, fileName, h)
 		}
-		return p.httpUploader.UploadData(manifestByte, p.chunklistFileName, h)
+		manifestUploader := httpUploader.ForTarget(httpuploader.Target{Type: httpuploader.UploadTypeManifest, Seq: -1})
+		if manifestUploader.GetConcurrency() > 1 {
+			// Uploaded after the chunks it references (queued before it), the errors are logged by the uploader
+			manifestUploader.UploadDataNotify(manifestByte, fileName, h, nil)
+			return nil
+		}
+		return manifestUploader.UploadData(manifestByte, fileName, h)
 	}
 	return nil
 }
@@ -178,15 +967,189 @@ func (p *Hls) saveManifestExternal(manifestByte []byte, outputType OutputTypes)
 func (p *Hls) AddChunk(chunkData Chunk, saveChunklist bool) error {
 	ret := error(nil)
 
+	if chunkData.InitFileName == "" {
+		chunkData.InitFileName = p.initChunkDataFileName
+	}
+	if chunkData.IsDisco {
+		p.dseq++
+	}
+	chunkData.discontinuitySeq = p.dseq
+	if len(p.pendingParts) > 0 {
+		chunkData.Parts = p.pendingParts
+		p.pendingParts = []Part{}
+	}
 	p.chunks = append(p.chunks, chunkData)
+	p.updateTargetDuration(chunkData)
+	if !chunkData.IsGrowing {
+		p.isChunkPending = true
+	}
+
+	p.slideWindow()
+	p.removeOldParts()
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
+
+	return ret
+}
+
+// slideWindow Removes the 1st chunks of the live window when there are more completed ones than the window size, the growing ones (LHLS advanced chunks) are always after them. The media sequence only counts the removed chunks
+func (p *Hls) slideWindow() {
+	if p.manifestType != LiveWindow {
+		return
+	}
+
+	completedChunks := 0
+	for _, chunk := range p.chunks {
+		if !chunk.IsGrowing {
+			completedChunks++
+		}
+	}
+	removed := 0
+	for completedChunks > p.slidingWindowSize && len(p.chunks) > removed && !p.chunks[removed].IsGrowing {
+		//Remove first, the discontinuity sequence is computed from the 1st chunk left
+		removed++
+		completedChunks--
+	}
+	if removed > 0 {
+		p.retainChunks(p.chunks[:removed])
+		p.chunks = p.chunks[removed:]
+		p.mseq = p.mseq + int64(removed)
+	}
+}
+
+// updateTargetDuration Raises the target duration if the chunk is longer, the written EXTINF rounded to the nearest integer can not be bigger than it. It never decreases
+func (p *Hls) updateTargetDuration(chunkData Chunk) {
+	writtenDurS, _ := strconv.ParseFloat(p.formatDuration(chunkData.DurationS), 64)
+	if durS := math.Round(writtenDurS); durS > p.targetDurS {
+		p.log.Info("Chunk ", chunkData.FileName, " longer than target duration (", chunkData.DurationS, "s), target duration set to ", durS, "s")
+		p.targetDurS = durS
+	}
+}
+
+// SetChunkDuration Sets the real duration of an already added growing chunk when it is finalized (Ex: LHLS chunks announced with the target duration), it is completed from now on
+func (p *Hls) SetChunkDuration(fileName string, durationS float64, saveChunklist bool) error {
+	ret := error(nil)
+
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			p.chunks[i].DurationS = durationS
+			p.chunks[i].IsGrowing = false
+			p.isChunkPending = true
+			p.updateTargetDuration(p.chunks[i])
+			p.slideWindow()
+			p.removeOldParts()
+			break
+		}
+	}
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
 
-	if p.manifestType == LiveWindow && len(p.chunks) > p.slidingWindowSize {
-		//Remove first
-		if p.chunks[0].IsDisco {
+	return ret
+}
+
+// SetChunkBytes Sets the size of an already added chunk (Ex: a growing chunk when it is finalized)
+func (p *Hls) SetChunkBytes(fileName string, bytes uint64, saveChunklist bool) error {
+	ret := error(nil)
+
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			p.chunks[i].Bytes = bytes
+			break
+		}
+	}
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
+
+	return ret
+}
+
+// SetChunkDiscontinuity Flags an already added chunk as discontinuity
+func (p *Hls) SetChunkDiscontinuity(fileName string, saveChunklist bool) error {
+	ret := error(nil)
+
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			if !p.chunks[i].IsDisco {
+				// This chunk and the next ones are in the next discontinuity sequence
+				p.chunks[i].IsDisco = true
+				for n := i; n < len(p.chunks); n++ {
+					p.chunks[n].discontinuitySeq++
+				}
+				p.dseq++
+			}
+			break
+		}
+	}
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
+
+	return ret
+}
 
+// SetChunkGap Flags an already added chunk as gap (Ex: its upload failed after the chunklist was saved), returns false if it is not in the chunklist. The chunklist is saved even with PublishOnChunk
+func (p *Hls) SetChunkGap(fileName string, saveChunklist bool) (bool, error) {
+	found := false
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			found = true
+			if !p.chunks[i].IsGap {
+				p.chunks[i].IsGap = true
+				p.isChunkPending = true
+			}
+			break
+		}
+	}
+	for i := range p.history {
+		if p.history[i].FileName == fileName && !p.history[i].IsGap {
+			// Only in the window chunklists
+			found = true
+			p.history[i].IsGap = true
+			p.isChunkPending = true
+		}
+	}
+
+	if found && saveChunklist {
+		return found, p.saveChunklist()
+	}
+
+	return found, nil
+}
+
+// AddChunkDateRanges Adds date ranges to an already added chunk
+func (p *Hls) AddChunkDateRanges(fileName string, dateRanges []DateRange, saveChunklist bool) error {
+	ret := error(nil)
+
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			p.chunks[i].DateRanges = append(p.chunks[i].DateRanges, dateRanges...)
+			break
+		}
+	}
+
+	if saveChunklist {
+		ret = p.saveChunklist()
+	}
+
+	return ret
+}
+
+// SetChunkProgramDateTime Sets the program date time of an already added chunk (Ex: LHLS chunks announced before receiving data)
+func (p *Hls) SetChunkProgramDateTime(fileName string, programDateTime time.Time, saveChunklist bool) error {
+	ret := error(nil)
+
+	for i := range p.chunks {
+		if p.chunks[i].FileName == fileName {
+			p.chunks[i].ProgramDateTime = programDateTime
+			break
 		}
-		p.chunks = p.chunks[1:]
-		p.mseq++
 	}
 
 	if saveChunklist {
@@ -196,14 +1159,87 @@ func (p *Hls) AddChunk(chunkData Chunk, saveChunklist bool) error {
 	return ret
 }
 
-// addChunk Adds a new chunk
+// isProgramDateTimeChunk Returns true if the EXT-X-PROGRAM-DATE-TIME is written before the chunk in the position i of the chunklist (firstChunk is the 1st one written)
+func (p *Hls) isProgramDateTimeChunk(i int, firstChunk int) bool {
+	if p.programDateTimeEvery <= 0 || p.chunks[i].ProgramDateTime.IsZero() {
+		return false
+	}
+
+	return i == firstChunk || p.chunks[i].IsDisco || (p.mseq+int64(i))%int64(p.programDateTimeEvery) == 0
+}
+
+// discontinuitySequence Returns the EXT-X-DISCONTINUITY-SEQUENCE, the discontinuity sequence number of the 1st chunk before its own discontinuity tag
+func (p *Hls) discontinuitySequence() int64 {
+	if len(p.chunks) <= 0 {
+		return p.dseq
+	}
+	if p.chunks[0].IsDisco {
+		return p.chunks[0].discontinuitySeq - 1
+	}
+
+	return p.chunks[0].discontinuitySeq
+}
+
+// RelativeURI Returns the URI of the file relative to the chunklist
+func (p *Hls) RelativeURI(fileName string) string {
+	uri, _ := filepath.Rel(path.Dir(p.chunklistFileName), fileName)
+	return uri
+}
+
+// SetURIPrefix Sets the prefix of the media URIs written in the chunklist (Ex: https://media.cdn.example/chan1/), empty they are relative to the chunklist. The files are saved to the same paths
+func (p *Hls) SetURIPrefix(uriPrefix string) {
+	p.uriPrefix = uriPrefix
+}
+
+// MediaURI Returns the URI of the media file (chunk, init chunk, part, key) written in the chunklist, see PrefixURI
+func (p *Hls) MediaURI(fileName string) string {
+	return PrefixURI(p.uriPrefix, p.RelativeURI(fileName))
+}
+
+// PrefixURI Returns the relative URI after the prefix (a single slash between them) with its path segments escaped. The URI as it is if the prefix is empty or it is absolute (scheme or path)
+func PrefixURI(uriPrefix string, uri string) string {
+	if uriPrefix == "" || strings.HasPrefix(uri, "/") {
+		return uri
+	}
+	if u, err := url.Parse(uri); err == nil && u.Scheme != "" {
+		return uri
+	}
+
+	segments := strings.Split(filepath.ToSlash(uri), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.TrimRight(uriPrefix, "/") + "/" + strings.Join(segments, "/")
+}
+
+// mapTag Returns the EXT-X-MAP tag of the init chunk
+func (p *Hls) mapTag(initChunkFileName string) string {
+	return "#EXT-X-MAP:URI=\"" + p.MediaURI(initChunkFileName) + "\"\n"
+}
+
+// String Returns the full chunklist
 func (p *Hls) String() string {
+	return p.render(0)
+}
+
+// DeltaString Returns the delta chunklist, the chunks before the skip boundary are replaced by EXT-X-SKIP (same media and discontinuity sequence than the full one). Their date ranges are kept
+func (p *Hls) DeltaString() string {
+	return p.render(p.skippedChunks())
+}
+
+// render Returns the chunklist without the 1st skippedChunks
+func (p *Hls) render(skippedChunks int) string {
 	var buffer bytes.Buffer
 
+	version := p.currentVersion()
+	if skippedChunks > 0 && version < DeltaMinVersion {
+		version = DeltaMinVersion
+	}
+
 	buffer.WriteString("#EXTM3U\n")
-	buffer.WriteString("#EXT-X-VERSION:" + strconv.Itoa(p.version) + "\n")
+	buffer.WriteString("#EXT-X-VERSION:" + strconv.Itoa(version) + "\n")
 	buffer.WriteString("#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(p.mseq, 10) + "\n")
-	buffer.WriteString("#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.FormatInt(p.dseq, 10) + "\n")
+	buffer.WriteString("#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.FormatInt(p.discontinuitySequence(), 10) + "\n")
 
 	if p.manifestType == Vod {
 		buffer.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
@@ -214,23 +1250,93 @@ func (p *Hls) String() string {
 
 	buffer.WriteString("#EXT-X-TARGETDURATION:" + fmt.Sprintf("%.0f", p.targetDurS) + "\n")
 
+	if p.partTargetS > 0 {
+		buffer.WriteString("#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(p.partTargetS, 'f', 5, 64) + "\n")
+	}
+
+	if p.isServerControl {
+		buffer.WriteString(p.serverControlTag())
+	}
+
 	if p.isIndependentSegments {
 		buffer.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
-	if p.initChunkDataFileName != "" {
-		chunkPath, _ := filepath.Rel(path.Dir(p.chunklistFileName), p.initChunkDataFileName)
-		buffer.WriteString("#EXT-X-MAP:URI=\"" + chunkPath + "\"\n")
+	if p.isIFramesOnly {
+		buffer.WriteString("#EXT-X-I-FRAMES-ONLY\n")
 	}
 
-	for _, chunk := range p.chunks {
+	if p.isStart {
+		buffer.WriteString(p.startTag())
+	}
+
+	for _, tag := range p.headerTags {
+		buffer.WriteString(tag + "\n")
+	}
+
+	if skippedChunks > 0 {
+		buffer.WriteString("#EXT-X-SKIP:SKIPPED-SEGMENTS=" + strconv.Itoa(skippedChunks) + "\n")
+		for _, chunk := range p.chunks[:skippedChunks] {
+			for _, dateRange := range chunk.DateRanges {
+				buffer.WriteString(dateRange.String())
+			}
+		}
+	}
+
+	initFileName := p.initChunkDataFileName
+	if len(p.chunks) > skippedChunks {
+		initFileName = p.chunks[skippedChunks].InitFileName
+	}
+	if initFileName != "" {
+		buffer.WriteString(p.mapTag(initFileName))
+	}
+
+	keyTag := ""
+	for i := skippedChunks; i < len(p.chunks); i++ {
+		chunk := p.chunks[i]
 		if chunk.IsDisco {
 			buffer.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
-		buffer.WriteString("#EXTINF:" + fmt.Sprintf("%.8f", chunk.DurationS) + ",\n")
+		if chunkKeyTag := chunk.keyTag(); chunkKeyTag != keyTag && (keyTag != "" || chunk.Key != nil) {
+			// The key applies until the next one (the 1st chunk is not encrypted if there is none)
+			keyTag = chunkKeyTag
+			buffer.WriteString(keyTag)
+		}
+		if chunk.InitFileName != initFileName {
+			// Init chunk changed (Ex: new PMT)
+			initFileName = chunk.InitFileName
+			if initFileName != "" {
+				buffer.WriteString(p.mapTag(initFileName))
+			}
+		}
+		if p.isProgramDateTimeChunk(i, skippedChunks) {
+			buffer.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + chunk.ProgramDateTime.Format("2006-01-02T15:04:05.000Z07:00") + "\n")
+		}
+		for _, dateRange := range chunk.DateRanges {
+			buffer.WriteString(dateRange.String())
+		}
+		for _, part := range chunk.Parts {
+			buffer.WriteString(part.String(p.MediaURI(part.FileName)))
+		}
+		if chunk.IsGap {
+			buffer.WriteString("#EXT-X-GAP\n")
+		}
+		buffer.WriteString(p.bitrateTag(chunk))
+		buffer.WriteString(p.chunkCustomTags(i))
+		buffer.WriteString("#EXTINF:" + p.formatDuration(chunk.DurationS) + ",\n")
+		if chunk.ByteRangeLength > 0 {
+			buffer.WriteString("#EXT-X-BYTERANGE:" + strconv.FormatInt(chunk.ByteRangeLength, 10) + "@" + strconv.FormatInt(chunk.ByteRangeOffset, 10) + "\n")
+		}
 
-		chunkPath, _ := filepath.Rel(path.Dir(p.chunklistFileName), chunk.FileName)
-		buffer.WriteString(chunkPath + "\n")
+		buffer.WriteString(p.MediaURI(chunk.FileName) + "\n")
+	}
+
+	// Parts of the chunk being generated
+	for _, part := range p.pendingParts {
+		buffer.WriteString(part.String(p.MediaURI(part.FileName)))
+	}
+	if p.preloadHintFileName != "" && !p.isClosed {
+		buffer.WriteString("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"" + p.MediaURI(p.preloadHintFileName) + "\"\n")
 	}
 
 	if p.isClosed {
@@ -1,3 +1,940 @@
 package hls
 
-//TODO: Add testing
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parsedSegment Segment of a parsed playlist, with its media and discontinuity sequence numbers
+type parsedSegment struct {
+	uri              string
+	mediaSeq         int64
+	discontinuitySeq int64
+}
+
+// parsePlaylist Parses a media playlist, the sequence numbers of the segments are calculated as RFC 8216 (6.2.1 and 4.3.3.3)
+func parsePlaylist(t *testing.T, playlist string) []parsedSegment {
+	lines := strings.Split(strings.TrimSpace(playlist), "\n")
+	if len(lines) <= 0 || lines[0] != "#EXTM3U" {
+		t.Fatalf("Playlist does not start with #EXTM3U, got: %s.", playlist)
+	}
+
+	segments := []parsedSegment{}
+	mediaSeq, discontinuitySeq := int64(0), int64(0)
+	isHeader, isInf := true, false
+	for _, line := range lines[1:] {
+		var err error
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if !isHeader {
+				t.Fatalf("EXT-X-MEDIA-SEQUENCE after the 1st segment, got: %s.", playlist)
+			}
+			mediaSeq, err = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			if !isHeader {
+				t.Fatalf("EXT-X-DISCONTINUITY-SEQUENCE after the 1st segment, got: %s.", playlist)
+			}
+			discontinuitySeq, err = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"), 10, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			isHeader = false
+			discontinuitySeq++
+		case strings.HasPrefix(line, "#EXTINF:"):
+			isHeader = false
+			isInf = true
+		case strings.HasPrefix(line, "#") || line == "":
+		default:
+			if !isInf {
+				t.Fatalf("Segment URI %s without EXTINF, got: %s.", line, playlist)
+			}
+			segments = append(segments, parsedSegment{line, mediaSeq, discontinuitySeq})
+			mediaSeq++
+			isInf = false
+		}
+		if err != nil {
+			t.Fatalf("Error parsing %s. Err: %v", line, err)
+		}
+	}
+
+	return segments
+}
+
+func TestDiscontinuitySequenceSlidingWindow(t *testing.T) {
+	// Chunks 3, 4 (consecutive), 8 and 9 (flagged after being added, Ex: LHLS) start a discontinuity
+	discos := map[int]bool{3: true, 4: true, 8: true}
+	xpectedDiscontinuitySeqs := []int64{0, 0, 0, 1, 2, 2, 2, 2, 3, 4, 4, 4, 4, 4}
+
+	for _, manifestType := range []ManifestTypes{LiveWindow, LiveEvent} {
+		p := New(logrus.New(), manifestType, 3, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+
+		for i := range xpectedDiscontinuitySeqs {
+			fileName := fmt.Sprintf("chunk_%05d.ts", i)
+			p.AddChunk(Chunk{FileName: fileName, DurationS: 4, IsDisco: discos[i]}, false)
+			if i == 9 {
+				p.SetChunkDiscontinuity(fileName, false)
+			}
+
+			segments := parsePlaylist(t, p.String())
+			if manifestType == LiveWindow && len(segments) != min(i+1, 3) {
+				t.Fatalf("Sliding window size is not correct, got: %d, want: %d.", len(segments), min(i+1, 3))
+			}
+			if manifestType == LiveEvent && len(segments) != i+1 {
+				t.Fatalf("Event size is not correct, got: %d, want: %d.", len(segments), i+1)
+			}
+
+			// Every segment keeps its media and discontinuity sequence numbers while it is in the window
+			for _, segment := range segments {
+				n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(segment.uri, "chunk_"), ".ts"))
+				if segment.mediaSeq != int64(n) {
+					t.Errorf("Media sequence of %s is not correct (type: %d, last chunk: %d), got: %d, want: %d.", segment.uri, manifestType, i, segment.mediaSeq, n)
+				}
+				if segment.discontinuitySeq != xpectedDiscontinuitySeqs[n] {
+					t.Errorf("Discontinuity sequence of %s is not correct (type: %d, last chunk: %d), got: %d, want: %d.", segment.uri, manifestType, i, segment.discontinuitySeq, xpectedDiscontinuitySeqs[n])
+				}
+			}
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	descriptor := path.Join(t.TempDir(), "master.json")
+	err := ioutil.WriteFile(descriptor, []byte(`[{"uri":"720p/chunklist.m3u8","bandwidth":3000000,"averageBandwidth":2500000,"resolution":"1280x720","frameRate":29.97,"codecs":"avc1.64001f,mp4a.40.2"},{"uri":"audio/chunklist.m3u8","bandwidth":128000,"codecs":"mp4a.40.2"}]`), 0644)
+	if err != nil {
+		t.Fatal("Error writing descriptor. Err: ", err)
+	}
+
+	d, err := LoadDescriptor(descriptor)
+	if err != nil {
+		t.Fatal("Error loading variants. Err: ", err)
+	}
+	m := NewMaster(logrus.New(), "master.m3u8", d.Variants, d.Renditions, HlsOutputModeNone, nil, nil)
+
+	got := m.String()
+	xpected := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,AVERAGE-BANDWIDTH=2500000,RESOLUTION=1280x720,FRAME-RATE=29.970,CODECS=\"avc1.64001f,mp4a.40.2\"\n720p/chunklist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\naudio/chunklist.m3u8\n"
+	if got != xpected {
+		t.Errorf("Master playlist is not correct, got: %s, want: %s.", got, xpected)
+	}
+
+	// Measured bandwidth
+	if i := m.VariantIndex("audio/chunklist.m3u8"); i != 1 {
+		t.Fatalf("Variant index is not correct, got: %d, want: 1.", i)
+	}
+	m.SetVariantBandwidth(1, 140000, 131000)
+	if got := m.GetVariant(1); got.Bandwidth != 140000 || got.AverageBandwidth != 131000 {
+		t.Errorf("Variant bandwidth is not correct, got: %+v.", got)
+	}
+
+	if _, err := LoadDescriptor(path.Join(t.TempDir(), "none.json")); err == nil {
+		t.Error("Missing descriptor accepted")
+	}
+}
+
+func TestMasterPlaylistRenditions(t *testing.T) {
+	descriptor := path.Join(t.TempDir(), "master.json")
+	err := ioutil.WriteFile(descriptor, []byte(`{"variants":[{"uri":"720p/chunklist.m3u8","bandwidth":3000000,"codecs":"avc1.64001f,mp4a.40.2","audio":"aac","subtitles":"subs"}],
+		"renditions":[{"type":"AUDIO","groupId":"aac","name":"English","language":"en","default":true,"uri":"en/chunklist.m3u8"},{"type":"AUDIO","groupId":"aac","name":"Español","language":"es","autoselect":true,"uri":"es/chunklist.m3u8"}]}`), 0644)
+	if err != nil {
+		t.Fatal("Error writing descriptor. Err: ", err)
+	}
+	d, err := LoadDescriptor(descriptor)
+	if err != nil {
+		t.Fatal("Error loading descriptor. Err: ", err)
+	}
+
+	masterFileName := path.Join(t.TempDir(), "master.m3u8")
+	m := NewMaster(logrus.New(), masterFileName, d.Variants, d.Renditions, HlsOutputModeFile, nil, nil)
+	if err := m.Validate(); err == nil {
+		t.Error("Variant referencing a group without renditions accepted")
+	}
+
+	// The master playlist is saved when a rendition is added
+	err = m.AddRendition(Rendition{Type: RenditionSubtitles, GroupID: "subs", Name: "Captions", Autoselect: true, URI: "chunklist_captions.m3u8"})
+	if err != nil {
+		t.Fatal("Error adding rendition. Err: ", err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Valid master playlist rejected. Err: %v", err)
+	}
+
+	got, _ := ioutil.ReadFile(masterFileName)
+	xpected := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,AUTOSELECT=YES,URI=\"en/chunklist.m3u8\"\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"Español\",LANGUAGE=\"es\",DEFAULT=NO,AUTOSELECT=YES,URI=\"es/chunklist.m3u8\"\n" +
+		"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"Captions\",DEFAULT=NO,AUTOSELECT=YES,URI=\"chunklist_captions.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,CODECS=\"avc1.64001f,mp4a.40.2\",AUDIO=\"aac\",SUBTITLES=\"subs\"\n720p/chunklist.m3u8\n"
+	if string(got) != xpected {
+		t.Errorf("Master playlist is not correct, got: %s, want: %s.", got, xpected)
+	}
+
+	invalidRenditions := []Rendition{
+		{Type: "VIDEO", GroupID: "aac", Name: "Other"},
+		{Type: RenditionAudio, GroupID: "aac", Name: "English", URI: "en2/chunklist.m3u8"},
+		{Type: RenditionAudio, GroupID: "aac", Name: "Français", Default: true, URI: "fr/chunklist.m3u8"},
+		{Type: RenditionSubtitles, GroupID: "subs", Name: "No URI"},
+	}
+	for _, r := range invalidRenditions {
+		if err := m.AddRendition(r); err == nil {
+			t.Errorf("Invalid rendition accepted: %+v.", r)
+		}
+	}
+}
+
+func TestIndependentSegments(t *testing.T) {
+	for _, manifestType := range []ManifestTypes{Vod, LiveEvent, LiveWindow} {
+		p := New(logrus.New(), manifestType, 3, true, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+		p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4}, false)
+		p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 4}, false)
+
+		// Once, in the header (before the 1st segment)
+		playlist := p.String()
+		if strings.Count(playlist, "#EXT-X-INDEPENDENT-SEGMENTS\n") != 1 {
+			t.Errorf("EXT-X-INDEPENDENT-SEGMENTS is not correct (type: %d), got: %s.", manifestType, playlist)
+		}
+		if pos := strings.Index(playlist, "#EXT-X-INDEPENDENT-SEGMENTS\n"); pos < 0 || pos > strings.Index(playlist, "#EXTINF:") {
+			t.Errorf("EXT-X-INDEPENDENT-SEGMENTS is not in the header (type: %d), got: %s.", manifestType, playlist)
+		}
+
+		p.SetIndependentSegments(false)
+		if playlist := p.String(); strings.Contains(playlist, "#EXT-X-INDEPENDENT-SEGMENTS") {
+			t.Errorf("EXT-X-INDEPENDENT-SEGMENTS written after removing it (type: %d), got: %s.", manifestType, playlist)
+		}
+	}
+}
+
+func TestExtinfPrecision(t *testing.T) {
+	p := New(logrus.New(), Vod, 1, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetExtinfPrecision(0)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4.4}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 4.6}, false)
+
+	// Integers are version 1, the target duration covers the rounded EXTINF
+	got := p.String()
+	xpected := "#EXTM3U\n#EXT-X-VERSION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:5\n#EXTINF:4,\nchunk_00000.ts\n#EXTINF:5,\nchunk_00001.ts\n"
+	if got != xpected {
+		t.Errorf("Integer EXTINF chunklist is not correct, got: %s, want: %s.", got, xpected)
+	}
+
+	// The target duration is calculated from the written EXTINF (4.46 is written as 4.5)
+	p = New(logrus.New(), Vod, 1, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetExtinfPrecision(1)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4.46}, false)
+	got = p.String()
+	xpected = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:5\n#EXTINF:4.5,\nchunk_00000.ts\n"
+	if got != xpected {
+		t.Errorf("Decimal EXTINF chunklist is not correct, got: %s, want: %s.", got, xpected)
+	}
+
+	// Byte ranges need version 4, unless it is pinned
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 4, ByteRangeLength: 188, ByteRangeOffset: 0}, false)
+	if got := p.String(); !strings.Contains(got, "#EXT-X-VERSION:4\n") {
+		t.Errorf("Byte ranges version is not correct, got: %s.", got)
+	}
+	p.PinVersion(3)
+	if got := p.String(); !strings.Contains(got, "#EXT-X-VERSION:3\n") {
+		t.Errorf("Pinned version is not correct, got: %s.", got)
+	}
+}
+
+func TestRequiredVersion(t *testing.T) {
+	tests := []struct {
+		extinfPrecision int
+		hasByteRanges   bool
+		hasMap          bool
+		isIFramesOnly   bool
+		xpected         int
+	}{
+		{0, false, false, false, 1},
+		{3, false, false, false, 3},
+		{0, true, false, false, 4},
+		{3, false, false, true, 4},
+		{3, false, true, true, 5},
+		{3, true, true, false, 6},
+	}
+
+	for _, test := range tests {
+		if got := RequiredVersion(test.extinfPrecision, test.hasByteRanges, test.hasMap, test.isIFramesOnly); got != test.xpected {
+			t.Errorf("Required version of %+v is not correct, got: %d, want: %d.", test, got, test.xpected)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetStart(true, -12, false)
+
+	// Clamped to the window duration while it is shorter than the offset
+	xpectedStarts := []string{"-4.000", "-8.000", "-12.000", "-12.000", "-12.000"}
+	for i, xpectedStart := range xpectedStarts {
+		p.AddChunk(Chunk{FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 4}, false)
+
+		playlist := p.String()
+		xpected := "#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-START:TIME-OFFSET=" + xpectedStart + "\n#EXTINF:"
+		if !strings.Contains(playlist, xpected) {
+			t.Errorf("EXT-X-START is not correct (chunks: %d), got: %s, want: %s.", i+1, playlist, xpected)
+		}
+	}
+
+	p = New(logrus.New(), Vod, 3, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetStart(true, 6, true)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 4}, false)
+	if playlist := p.String(); !strings.Contains(playlist, "#EXT-X-TARGETDURATION:4\n#EXT-X-START:TIME-OFFSET=6.000,PRECISE=YES\n") {
+		t.Errorf("VOD EXT-X-START is not correct, got: %s.", playlist)
+	}
+}
+
+func TestPartsRemoval(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 2, 10, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetPartTarget(1)
+
+	for i := 0; i < 5; i++ {
+		for n := 0; n < 2; n++ {
+			p.AddPart(Part{FileName: fmt.Sprintf("chunk_%05d.%d.ts", i, n), DurationS: 1, IsIndependent: n == 0}, false)
+		}
+		p.AddChunk(Chunk{FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 2}, false)
+	}
+	p.AddPart(Part{FileName: "chunk_00005.0.ts", DurationS: 1, IsIndependent: true}, false)
+
+	// Parts more than 3 target durations (6s) from the end are removed, the pending one is after the last chunk
+	playlist := p.String()
+	for i := 0; i < 5; i++ {
+		if got, xpected := strings.Contains(playlist, fmt.Sprintf("URI=\"chunk_%05d.0.ts\"", i)), i >= 3; got != xpected {
+			t.Errorf("Parts of the chunk %d are not correct (in chunklist: %t, want: %t), got: %s.", i, got, xpected, playlist)
+		}
+	}
+	if !strings.HasSuffix(playlist, "chunk_00004.ts\n#EXT-X-PART:DURATION=1.00000,URI=\"chunk_00005.0.ts\",INDEPENDENT=YES\n") {
+		t.Errorf("Pending part is not correct, got: %s.", playlist)
+	}
+}
+
+func TestPreloadHint(t *testing.T) {
+	p := New(logrus.New(), LiveEvent, 3, true, 2, 10, "out/chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetPartTarget(1)
+
+	p.AddPart(Part{FileName: "out/chunk_00000.0.ts", DurationS: 1, IsIndependent: true}, false)
+	p.SetPreloadHint("out/chunk_00000.1.ts", false)
+
+	// The hint is relative to the chunklist, after the pending parts
+	playlist := p.String()
+	if !strings.HasSuffix(playlist, "#EXT-X-PART:DURATION=1.00000,URI=\"chunk_00000.0.ts\",INDEPENDENT=YES\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"chunk_00000.1.ts\"\n") {
+		t.Errorf("Preload hint is not correct, got: %s.", playlist)
+	}
+
+	p.SetPreloadHint("", false)
+	if playlist = p.String(); strings.Contains(playlist, "#EXT-X-PRELOAD-HINT") {
+		t.Errorf("Preload hint is not removed, got: %s.", playlist)
+	}
+
+	// Not written once closed
+	p.SetPreloadHint("out/chunk_00000.1.ts", false)
+	p.CloseManifest(false)
+	if playlist = p.String(); strings.Contains(playlist, "#EXT-X-PRELOAD-HINT") {
+		t.Errorf("Preload hint is in the closed chunklist, got: %s.", playlist)
+	}
+}
+
+func TestConvertToVod(t *testing.T) {
+	p := New(logrus.New(), LiveEvent, 3, true, 2, 10, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetExtinfPrecision(0)
+	p.SetServerControl(true, ServerControl{CanBlockReload: true})
+	p.SetPartTarget(1)
+	p.AddPart(Part{FileName: "chunk_00000.0.ts", DurationS: 1, IsIndependent: true}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 2}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 1}, false)
+	p.AddPart(Part{FileName: "chunk_00002.0.ts", DurationS: 1, IsIndependent: true}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00002.ts", DurationS: 2, IsGrowing: true}, false)
+	p.SetPreloadHint("chunk_00002.1.ts", false)
+
+	// The growing chunk is removed, the last one keeps its duration
+	p.ConvertToVod()
+	p.CloseManifest(false)
+	xpected := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:2\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:2,\nchunk_00000.ts\n#EXTINF:1,\nchunk_00001.ts\n#EXT-X-ENDLIST\n"
+	if playlist := p.String(); playlist != xpected {
+		t.Errorf("VOD chunklist is not correct, got: %s, want: %s.", playlist, xpected)
+	}
+}
+
+func TestServerControl(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 2, 10, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetServerControl(true, ServerControl{CanBlockReload: true})
+
+	// Computed from the target duration, after EXT-X-PART-INF
+	p.SetPartTarget(0.5)
+	xpected := "#EXT-X-PART-INF:PART-TARGET=0.50000\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,HOLD-BACK=6.000,PART-HOLD-BACK=1.500\n"
+	if playlist := p.String(); !strings.Contains(playlist, xpected) {
+		t.Errorf("Computed server control is not correct, got: %s, want: %s.", playlist, xpected)
+	}
+
+	// Recomputed when the target duration grows, the overrides below the min are raised
+	p.SetServerControl(true, ServerControl{CanBlockReload: true, HoldBackS: 7, PartHoldBackS: 0.8, CanSkip: true})
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 3}, false)
+	xpected = "#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=18.000,CAN-BLOCK-RELOAD=YES,HOLD-BACK=9.000,PART-HOLD-BACK=1.000\n"
+	if playlist := p.String(); !strings.Contains(playlist, xpected) {
+		t.Errorf("Recomputed server control is not correct, got: %s, want: %s.", playlist, xpected)
+	}
+
+	p.SetServerControl(false, ServerControl{})
+	if playlist := p.String(); strings.Contains(playlist, "#EXT-X-SERVER-CONTROL") {
+		t.Errorf("Server control is not removed, got: %s.", playlist)
+	}
+}
+
+// chunkURIs Returns the chunk URIs of the chunklist
+func chunkURIs(playlist string) []string {
+	uris := []string{}
+	for _, line := range strings.Split(playlist, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	return uris
+}
+
+// tagLine Returns the 1st line of the chunklist that starts with the tag
+func tagLine(playlist string, tag string) string {
+	for _, line := range strings.Split(playlist, "\n") {
+		if strings.HasPrefix(line, tag) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestDelta(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 2, 10, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetServerControl(true, ServerControl{CanBlockReload: true})
+	p.SetDelta("chunklist_delta.m3u8", 0)
+
+	dateRange := DateRange{ID: "splice-1", StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for i := 0; i < 16; i++ {
+		chunk := Chunk{FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 2, IsDisco: i == 3 || i == 9}
+		if i == 5 {
+			chunk.DateRanges = []DateRange{dateRange}
+		}
+		p.AddChunk(chunk, false)
+
+		// The chunks that start more than CAN-SKIP-UNTIL (6 target durations, 12s) from the end are skipped, same sequences than the full chunklist
+		full, delta := p.String(), p.DeltaString()
+		uris := chunkURIs(full)
+		xpectedSkipped := len(uris) - 6
+		if xpectedSkipped <= 0 {
+			if delta != full {
+				t.Errorf("Delta chunklist without skipped chunks is not the full one (chunk %d), got: %s, want: %s.", i, delta, full)
+			}
+			continue
+		}
+		if got, xpected := tagLine(delta, "#EXT-X-SKIP"), fmt.Sprintf("#EXT-X-SKIP:SKIPPED-SEGMENTS=%d", xpectedSkipped); got != xpected {
+			t.Errorf("Skipped chunks are not correct (chunk %d), got: %s, want: %s.", i, got, xpected)
+		}
+		if got := chunkURIs(delta); !reflect.DeepEqual(got, uris[xpectedSkipped:]) {
+			t.Errorf("Delta chunks are not correct (chunk %d), got: %v, want: %v.", i, got, uris[xpectedSkipped:])
+		}
+		for _, tag := range []string{"#EXT-X-MEDIA-SEQUENCE", "#EXT-X-DISCONTINUITY-SEQUENCE", "#EXT-X-SERVER-CONTROL", "#EXT-X-DATERANGE"} {
+			if got, xpected := tagLine(delta, tag), tagLine(full, tag); got != xpected {
+				t.Errorf("Delta %s is not correct (chunk %d), got: %s, want: %s.", tag, i, got, xpected)
+			}
+		}
+		if got := tagLine(delta, "#EXT-X-VERSION"); got != "#EXT-X-VERSION:9" {
+			t.Errorf("Delta version is not correct (chunk %d), got: %s, want: #EXT-X-VERSION:9.", i, got)
+		}
+	}
+
+	xpected := "#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=12.000,CAN-BLOCK-RELOAD=YES,HOLD-BACK=6.000\n"
+	if playlist := p.String(); !strings.Contains(playlist, xpected) {
+		t.Errorf("Server control is not correct, got: %s, want: %s.", playlist, xpected)
+	}
+}
+
+func TestSetChunkDuration(t *testing.T) {
+	p := New(logrus.New(), LiveEvent, 3, true, 4, 10, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+
+	// Announced with the target duration, real durations around it
+	realDurationsS := []float64{3.8, 4.3, 3.6, 4.6}
+	for i, durationS := range realDurationsS {
+		fileName := fmt.Sprintf("chunk_%05d.ts", i)
+		p.AddChunk(Chunk{IsGrowing: true, FileName: fileName, DurationS: 4}, false)
+		p.SetChunkDuration(fileName, durationS, false)
+	}
+	p.AddChunk(Chunk{IsGrowing: true, FileName: "chunk_00004.ts", DurationS: 4}, false)
+
+	xpected := "#EXT-X-TARGETDURATION:5\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:3.80000000,\nchunk_00000.ts\n#EXTINF:4.30000000,\nchunk_00001.ts\n#EXTINF:3.60000000,\nchunk_00002.ts\n#EXTINF:4.60000000,\nchunk_00003.ts\n#EXTINF:4.00000000,\nchunk_00004.ts\n"
+	if playlist := p.String(); !strings.HasSuffix(playlist, xpected) {
+		t.Errorf("Chunk durations are not correct, got: %s, want: %s.", playlist, xpected)
+	}
+}
+
+func TestLiveWindowGrowingChunks(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 2, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+
+	// LHLS with 3 advanced chunks, the oldest growing one is finalized before a new one is announced
+	advancedChunks := 3
+	for i := 0; i < advancedChunks; i++ {
+		p.AddChunk(Chunk{IsGrowing: true, FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 2}, false)
+	}
+	for i := 0; i < 20; i++ {
+		p.SetChunkDuration(fmt.Sprintf("chunk_%05d.ts", i), 2, false)
+		p.AddChunk(Chunk{IsGrowing: true, FileName: fmt.Sprintf("chunk_%05d.ts", i+advancedChunks), DurationS: 2}, false)
+
+		// The window has 3 completed chunks (less at the start) and the advanced ones, the media sequence counts the removed completed chunks
+		completed := min(i+1, 3)
+		xpectedMseq := i + 1 - completed
+		uris := chunkURIs(p.String())
+		if len(uris) != completed+advancedChunks {
+			t.Errorf("Chunks in the window are not correct (slide %d), got: %d, want: %d.", i, len(uris), completed+advancedChunks)
+		}
+		if got, xpected := tagLine(p.String(), "#EXT-X-MEDIA-SEQUENCE"), "#EXT-X-MEDIA-SEQUENCE:"+strconv.Itoa(xpectedMseq); got != xpected {
+			t.Errorf("Media sequence is not correct (slide %d), got: %s, want: %s.", i, got, xpected)
+		}
+		if xpected := fmt.Sprintf("chunk_%05d.ts", xpectedMseq); len(uris) > 0 && uris[0] != xpected {
+			t.Errorf("1st chunk is not correct (slide %d), got: %s, want: %s.", i, uris[0], xpected)
+		}
+	}
+}
+
+func TestPublishPolicy(t *testing.T) {
+	type step struct {
+		name string
+		do   func(p *Hls, dir string)
+		uri  string
+	}
+	// Every step adds the uri, if the policy publishes it the saved chunklist has it
+	steps := []step{
+		{"1st part", func(p *Hls, dir string) {
+			p.AddPart(Part{FileName: path.Join(dir, "chunk_00000.0.ts"), DurationS: 1}, true)
+		}, "chunk_00000.0.ts"},
+		{"2nd part", func(p *Hls, dir string) {
+			p.AddPart(Part{FileName: path.Join(dir, "chunk_00000.1.ts"), DurationS: 1}, true)
+		}, "chunk_00000.1.ts"},
+		{"chunk", func(p *Hls, dir string) {
+			p.AddChunk(Chunk{FileName: path.Join(dir, "chunk_00000.ts"), DurationS: 2}, true)
+		}, "\nchunk_00000.ts"},
+		{"growing chunk", func(p *Hls, dir string) {
+			p.AddChunk(Chunk{IsGrowing: true, FileName: path.Join(dir, "chunk_00001.ts"), DurationS: 2}, true)
+		}, "\nchunk_00001.ts"},
+		{"completed without saving", func(p *Hls, dir string) {
+			p.AddChunk(Chunk{IsGrowing: true, FileName: path.Join(dir, "chunk_00002.ts"), DurationS: 2}, false)
+			p.SetChunkDuration(path.Join(dir, "chunk_00001.ts"), 2, false)
+			p.SetPreloadHint(path.Join(dir, "chunk_00002.ts"), true)
+		}, "\nchunk_00002.ts"},
+	}
+	xpected := map[PublishPolicies][]bool{
+		PublishOnChange:  {true, true, true, true, true},
+		PublishCoalesced: {true, false, true, false, true},
+		PublishOnChunk:   {false, false, true, false, true},
+	}
+
+	for policy, xpectedPublished := range xpected {
+		dir := t.TempDir()
+		chunklistFileName := path.Join(dir, "chunklist.m3u8")
+		p := New(logrus.New(), LiveEvent, 3, true, 2, 10, chunklistFileName, "", HlsOutputModeFile, nil, nil)
+		p.SetPublishPolicy(policy, time.Hour)
+
+		for i, s := range steps {
+			s.do(&p, dir)
+			saved, _ := ioutil.ReadFile(chunklistFileName)
+			if got := strings.Contains(string(saved), s.uri); got != xpectedPublished[i] {
+				t.Errorf("Chunklist saved after %s (policy %d) is not correct, got published: %t, want: %t.", s.name, policy, got, xpectedPublished[i])
+			}
+		}
+
+		// The pending changes are saved when it is closed
+		p.CloseManifest(true)
+		saved, _ := ioutil.ReadFile(chunklistFileName)
+		if string(saved) != p.String() {
+			t.Errorf("Chunklist saved after close (policy %d) is not correct, got: %s, want: %s.", policy, string(saved), p.String())
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 4, 3, "results/chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	key := &Key{Method: "AES-128", URI: "key00000.key"}
+	p.AddChunk(Chunk{FileName: "results/chunk_00000.ts", DurationS: 4, Key: key}, false)
+	p.AddChunk(Chunk{FileName: "results/chunk_00001.ts", DurationS: 4, Key: key}, false)
+
+	// Once, before the 1st chunk (the IV is the media sequence)
+	playlist := p.String()
+	if strings.Count(playlist, "#EXT-X-KEY:METHOD=AES-128,URI=\"key00000.key\"\n#EXTINF:") != 1 || strings.Count(playlist, "#EXT-X-KEY") != 1 {
+		t.Errorf("EXT-X-KEY is not correct, got: %s.", playlist)
+	}
+	if strings.Index(playlist, "#EXT-X-KEY") > strings.Index(playlist, "#EXTINF:") {
+		t.Errorf("EXT-X-KEY is not before the 1st chunk, got: %s.", playlist)
+	}
+
+	// Explicit IV (version 2) and back to clear
+	p.AddChunk(Chunk{FileName: "results/chunk_00002.ts", DurationS: 4, Key: &Key{Method: "AES-128", URI: "key00000.key", IV: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}}}, false)
+	p.AddChunk(Chunk{FileName: "results/chunk_00003.ts", DurationS: 4}, false)
+	playlist = p.String()
+	if !strings.Contains(playlist, "#EXT-X-KEY:METHOD=AES-128,URI=\"key00000.key\",IV=0x000102030405060708090a0b0c0d0e0f\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00002.ts\n") {
+		t.Errorf("EXT-X-KEY with IV is not correct, got: %s.", playlist)
+	}
+	if !strings.Contains(playlist, "#EXT-X-KEY:METHOD=NONE\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00003.ts\n") {
+		t.Errorf("EXT-X-KEY METHOD=NONE is not correct, got: %s.", playlist)
+	}
+	if !strings.Contains(playlist, "#EXT-X-VERSION:3\n") {
+		t.Errorf("Version is not correct, got: %s.", playlist)
+	}
+
+	// A clear chunk before the encrypted ones does not need METHOD=NONE
+	p = New(logrus.New(), Vod, 1, true, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetExtinfPrecision(0)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 4, Key: &Key{Method: "AES-128", URI: "k.key", IV: []byte{1}}}, false)
+	playlist = p.String()
+	if strings.Contains(playlist, "METHOD=NONE") || !strings.Contains(playlist, "#EXT-X-VERSION:2\n") {
+		t.Errorf("Chunklist with a clear 1st chunk is not correct, got: %s.", playlist)
+	}
+}
+
+func TestChunkGap(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, true, 4, 3, "results/chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.AddChunk(Chunk{FileName: "results/chunk_00000.ts", DurationS: 4}, false)
+	p.AddChunk(Chunk{FileName: "results/chunk_00001.ts", DurationS: 4, IsGap: true}, false)
+	p.AddChunk(Chunk{FileName: "results/chunk_00002.ts", DurationS: 4}, false)
+
+	// Flagged after it was added
+	if found, _ := p.SetChunkGap("results/chunk_00002.ts", false); !found {
+		t.Errorf("Gap chunk is not found")
+	}
+	if found, _ := p.SetChunkGap("results/chunk_00009.ts", false); found {
+		t.Errorf("Gap chunk not in the chunklist is found")
+	}
+
+	playlist := p.String()
+	if !strings.Contains(playlist, "#EXT-X-GAP\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00001.ts\n#EXT-X-GAP\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00002.ts\n") || strings.Count(playlist, "#EXT-X-GAP") != 2 {
+		t.Errorf("EXT-X-GAP is not correct, got: %s.", playlist)
+	}
+	if !strings.Contains(playlist, "#EXT-X-VERSION:8\n") {
+		t.Errorf("Version is not correct, got: %s.", playlist)
+	}
+}
+
+func TestDash(t *testing.T) {
+	dir := t.TempDir()
+	mpdFileName := path.Join(dir, "manifest.mpd")
+	d := NewDash(logrus.New(), LiveWindow, 4, 3, mpdFileName, path.Join(dir, "chunk_$Number%05d$.ts"), DashMimeTypeTS, HlsOutputModeFile, nil, nil)
+
+	// 4s segments of 500KB (1Mbps), the 3rd one of 2s
+	for i, durationS := range []float64{4, 4, 2, 4, 4} {
+		if err := d.AddSegment(DashSegment{Number: uint64(10 + i), DurationS: durationS, Bytes: 500000, StartPTSS: 1.5}, true); err != nil {
+			t.Fatal("Error adding segment. Err: ", err)
+		}
+	}
+	d.AddSegment(DashSegment{Number: 15, DurationS: 0, Bytes: 100}, true)
+
+	data, err := ioutil.ReadFile(mpdFileName)
+	if err != nil {
+		t.Fatal("Error reading MPD. Err: ", err)
+	}
+	mpd := string(data)
+
+	// Window of the last 3, starting after the 1st two (8s from the 1st segment start PTS)
+	for _, xpected := range []string{
+		"profiles=\"urn:mpeg:dash:profile:mp2t-simple:2011\" type=\"dynamic\"",
+		"minimumUpdatePeriod=\"PT4.000S\" timeShiftBufferDepth=\"PT10.000S\"",
+		"<AdaptationSet id=\"0\" mimeType=\"video/mp2t\"",
+		"<Representation id=\"0\" bandwidth=\"2000000\">",
+		"<SegmentTemplate timescale=\"90000\" presentationTimeOffset=\"135000\" startNumber=\"12\" media=\"chunk_$Number%05d$.ts\">",
+		"<S t=\"855000\" d=\"180000\"/>\n            <S d=\"360000\" r=\"1\"/>\n",
+	} {
+		if !strings.Contains(mpd, xpected) {
+			t.Errorf("MPD is not correct, got: %s, want it to contain: %s.", mpd, xpected)
+		}
+	}
+
+	// Ended, static with the whole presentation duration
+	d.SetInitSegment(path.Join(dir, "init00000.ts"))
+	d.Close()
+	data, _ = ioutil.ReadFile(mpdFileName)
+	mpd = string(data)
+	for _, xpected := range []string{"type=\"static\" mediaPresentationDuration=\"PT18.000S\"", "initialization=\"init00000.ts\""} {
+		if !strings.Contains(mpd, xpected) {
+			t.Errorf("Closed MPD is not correct, got: %s, want it to contain: %s.", mpd, xpected)
+		}
+	}
+	if strings.Contains(mpd, "availabilityStartTime") {
+		t.Errorf("Closed MPD has availabilityStartTime, got: %s.", mpd)
+	}
+}
+
+func TestPrefixURI(t *testing.T) {
+	tests := []struct {
+		uriPrefix string
+		uri       string
+		xpected   string
+	}{
+		{"", "chunk_00000.ts", "chunk_00000.ts"},
+		{"https://media.cdn.example/chan1/", "chunk_00000.ts", "https://media.cdn.example/chan1/chunk_00000.ts"},
+		{"https://media.cdn.example/chan1", "chunk_00000.ts", "https://media.cdn.example/chan1/chunk_00000.ts"},
+		{"https://media.cdn.example/chan1//", "720p/chunk 00000#1.ts", "https://media.cdn.example/chan1/720p/chunk%2000000%231.ts"},
+		{"/media/", "chunk_00000.ts", "/media/chunk_00000.ts"},
+		{"https://media.cdn.example/chan1/", "https://keys.example.com/0", "https://keys.example.com/0"},
+		{"https://media.cdn.example/chan1/", "/keys/key00000.key", "/keys/key00000.key"},
+	}
+	for _, test := range tests {
+		if got := PrefixURI(test.uriPrefix, test.uri); got != test.xpected {
+			t.Errorf("URI is not correct (prefix: %s, URI: %s), got: %s, want: %s.", test.uriPrefix, test.uri, got, test.xpected)
+		}
+	}
+}
+
+func TestChunklistURIPrefix(t *testing.T) {
+	p := New(logrus.New(), LiveEvent, 3, true, 4, 10, "out/chunklist.m3u8", "out/init00000.ts", HlsOutputModeNone, nil, nil)
+	p.SetURIPrefix("https://media.cdn.example/chan1/")
+	p.SetPartTarget(1)
+
+	p.AddChunk(Chunk{FileName: "out/chunk_00000.ts", DurationS: 4}, false)
+	p.AddPart(Part{FileName: "out/chunk_00001.0.ts", DurationS: 1, IsIndependent: true}, false)
+	p.SetPreloadHint("out/chunk_00001.1.ts", false)
+
+	playlist := p.String()
+	for _, xpected := range []string{
+		"#EXT-X-MAP:URI=\"https://media.cdn.example/chan1/init00000.ts\"\n",
+		"\nhttps://media.cdn.example/chan1/chunk_00000.ts\n",
+		"#EXT-X-PART:DURATION=1.00000,URI=\"https://media.cdn.example/chan1/chunk_00001.0.ts\",INDEPENDENT=YES\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"https://media.cdn.example/chan1/chunk_00001.1.ts\"\n",
+	} {
+		if !strings.Contains(playlist, xpected) {
+			t.Errorf("Chunklist is not correct, got: %s, want it to contain: %s.", playlist, xpected)
+		}
+	}
+	if got := p.MediaURI("out/key00000.key"); got != "https://media.cdn.example/chan1/key00000.key" {
+		t.Errorf("Key URI is not correct, got: %s.", got)
+	}
+}
+
+func TestMasterPlaylistURIPrefix(t *testing.T) {
+	variants := []Variant{{URI: "720p/chunklist.m3u8", Bandwidth: 3000000, IFramesURI: "720p/iframes.m3u8", IFramesBandwidth: 300000}, {URI: "https://other.example/audio.m3u8", Bandwidth: 128000}}
+	renditions := []Rendition{{Type: RenditionSubtitles, GroupID: "subs", Name: "Captions", URI: "captions.m3u8"}}
+	m := NewMaster(logrus.New(), "master.m3u8", variants, renditions, HlsOutputModeNone, nil, nil)
+	m.SetURIPrefix("https://cdn.example/live/chan1")
+
+	got := m.String()
+	for _, xpected := range []string{
+		"URI=\"https://cdn.example/live/chan1/captions.m3u8\"\n",
+		"\nhttps://cdn.example/live/chan1/720p/chunklist.m3u8\n",
+		"\nhttps://other.example/audio.m3u8\n",
+		"URI=\"https://cdn.example/live/chan1/720p/iframes.m3u8\"\n",
+	} {
+		if !strings.Contains(got, xpected) {
+			t.Errorf("Master playlist is not correct, got: %s, want it to contain: %s.", got, xpected)
+		}
+	}
+	if m.GetVariant(0).URI != "720p/chunklist.m3u8" {
+		t.Errorf("Variant URI is modified, got: %s.", m.GetVariant(0).URI)
+	}
+}
+
+func TestCustomTags(t *testing.T) {
+	dir := t.TempDir()
+	chunklistFileName := path.Join(dir, "chunklist.m3u8")
+	p := New(logrus.New(), LiveWindow, 3, true, 4, 2, chunklistFileName, "", HlsOutputModeFile, nil, nil)
+
+	if err := p.SetCustomTags([]string{"EXT-X-CUSTOM-ORIGIN-ID:chan1"}, nil); err == nil {
+		t.Error("Custom tag without # accepted")
+	}
+	err := p.SetCustomTags([]string{"#EXT-X-CUSTOM-ORIGIN-ID:chan1"}, func(segment SegmentInfo) []string {
+		if segment.MediaSequence%2 == 0 {
+			return []string{"#EXT-X-ASSET:CAID=" + segment.URI, "#INVALID\n#EXTINF:1,"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Error setting custom tags. Err: ", err)
+	}
+
+	// Saved on every chunk, the window removes the 1st one
+	for i := 0; i < 3; i++ {
+		p.AddChunk(Chunk{FileName: path.Join(dir, fmt.Sprintf("chunk_%05d.ts", i)), DurationS: 4}, true)
+	}
+	data, err := ioutil.ReadFile(chunklistFileName)
+	if err != nil {
+		t.Fatal("Error reading chunklist. Err: ", err)
+	}
+
+	xpected := "#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-CUSTOM-ORIGIN-ID:chan1\n#EXTINF:" + p.formatDuration(4) + ",\nchunk_00001.ts\n#EXT-X-ASSET:CAID=chunk_00002.ts\n#EXTINF:" + p.formatDuration(4) + ",\nchunk_00002.ts\n"
+	if !strings.HasSuffix(string(data), xpected) {
+		t.Errorf("Custom tags are not correct, got: %s, want: %s.", data, xpected)
+	}
+}
+
+func TestLoadCustomTags(t *testing.T) {
+	dir := t.TempDir()
+	xpected := []string{"#EXT-X-CUSTOM-ORIGIN-ID:chan1", "#EXT-X-CUSTOM:A=1"}
+
+	for i, data := range []string{"[\"#EXT-X-CUSTOM-ORIGIN-ID:chan1\", \"#EXT-X-CUSTOM:A=1\"]", "#EXT-X-CUSTOM-ORIGIN-ID:chan1\r\n\n#EXT-X-CUSTOM:A=1\n"} {
+		fileName := path.Join(dir, fmt.Sprintf("tags%d", i))
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+
+		got, err := LoadCustomTags(fileName)
+		if err != nil {
+			t.Fatal("Error loading custom tags. Err: ", err)
+		}
+		if strings.Join(got, ",") != strings.Join(xpected, ",") {
+			t.Errorf("Custom tags are not correct, got: %v, want: %v.", got, xpected)
+		}
+	}
+
+	fileName := path.Join(dir, "invalid")
+	ioutil.WriteFile(fileName, []byte("#EXT-X-CUSTOM:A=1\nEXT-X-CUSTOM:B=2\n"), 0644)
+	if _, err := LoadCustomTags(fileName); err == nil {
+		t.Error("Custom tag without # accepted")
+	}
+}
+
+func TestWindowChunklists(t *testing.T) {
+	dir := t.TempDir()
+	discos := map[int]bool{2: true, 7: true}
+	xpectedDiscontinuitySeqs := []int64{0, 0, 1, 1, 1, 1, 1, 2, 2, 2}
+	xpectedWindowSizes := []int{3, 6, 0}
+
+	p := New(logrus.New(), LiveWindow, 3, false, 4, 3, path.Join(dir, "chunklist.m3u8"), "", HlsOutputModeNone, nil, nil)
+	p.AddWindow(Window{path.Join(dir, "chunklist_dvr.m3u8"), 6, HlsOutputModeFile})
+	p.AddWindow(Window{path.Join(dir, "chunklist_all.m3u8"), 0, HlsOutputModeNone})
+
+	for i := range xpectedDiscontinuitySeqs {
+		p.AddChunk(Chunk{FileName: path.Join(dir, fmt.Sprintf("chunk_%05d.ts", i)), DurationS: 4, IsDisco: discos[i]}, true)
+
+		playlists := []string{p.String(), p.WindowString(0), p.WindowString(1)}
+		for w, playlist := range playlists {
+			segments := parsePlaylist(t, playlist)
+			xpectedSize := i + 1
+			if xpectedWindowSizes[w] > 0 {
+				xpectedSize = min(i+1, xpectedWindowSizes[w])
+			}
+			if len(segments) != xpectedSize {
+				t.Fatalf("Window %d size is not correct (last chunk: %d), got: %d, want: %d.", w, i, len(segments), xpectedSize)
+			}
+
+			// Same timeline in all the chunklists
+			for _, segment := range segments {
+				n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(segment.uri, "chunk_"), ".ts"))
+				if segment.mediaSeq != int64(n) || segment.discontinuitySeq != xpectedDiscontinuitySeqs[n] {
+					t.Errorf("Sequences of %s in window %d are not correct (last chunk: %d), got: %d %d, want: %d %d.", segment.uri, w, i, segment.mediaSeq, segment.discontinuitySeq, n, xpectedDiscontinuitySeqs[n])
+				}
+			}
+		}
+		if !strings.Contains(playlists[2], "#EXT-X-PLAYLIST-TYPE:EVENT\n") || strings.Contains(playlists[1], "#EXT-X-PLAYLIST-TYPE") {
+			t.Errorf("Window playlist types are not correct, got: %s %s.", playlists[1], playlists[2])
+		}
+
+		saved, err := ioutil.ReadFile(path.Join(dir, "chunklist_dvr.m3u8"))
+		if err != nil || string(saved) != playlists[1] {
+			t.Errorf("Saved window chunklist is not correct (last chunk: %d), got: %s, want: %s.", i, saved, playlists[1])
+		}
+	}
+
+	// A gap out of the live window is still flagged in the window chunklists
+	if found, _ := p.SetChunkGap(path.Join(dir, "chunk_00005.ts"), false); !found {
+		t.Errorf("Gap chunk of the windows is not found")
+	}
+	if playlist := p.WindowString(0); !strings.Contains(playlist, "#EXT-X-GAP\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00005.ts\n") {
+		t.Errorf("Window chunklist gap is not correct, got: %s.", playlist)
+	}
+}
+
+func TestBitrate(t *testing.T) {
+	p := New(logrus.New(), LiveEvent, 3, true, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	p.SetBitrate(true)
+	p.AddChunk(Chunk{FileName: "chunk_00000.ts", DurationS: 4, Bytes: 1000000}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00001.ts", DurationS: 2, Bytes: 300000, ByteRangeLength: 300000}, false)
+	p.AddChunk(Chunk{FileName: "chunk_00002.ts", DurationS: 4, IsGrowing: true}, false)
+
+	playlist := p.String()
+	if !strings.Contains(playlist, "#EXT-X-BITRATE:2000\n#EXTINF:"+p.formatDuration(4)+",\nchunk_00000.ts\n") || strings.Count(playlist, "#EXT-X-BITRATE") != 1 {
+		t.Errorf("EXT-X-BITRATE is not correct, got: %s.", playlist)
+	}
+
+	// Backfilled when the growing chunk is finalized
+	p.SetChunkBytes("chunk_00002.ts", 1500000, false)
+	p.SetChunkDuration("chunk_00002.ts", 3.9, false)
+	if playlist = p.String(); !strings.Contains(playlist, "#EXT-X-BITRATE:3077\n#EXTINF:"+p.formatDuration(3.9)+",\nchunk_00002.ts\n") {
+		t.Errorf("EXT-X-BITRATE of the finalized chunk is not correct, got: %s.", playlist)
+	}
+
+	p.SetBitrate(false)
+	if playlist = p.String(); strings.Contains(playlist, "#EXT-X-BITRATE") {
+		t.Errorf("EXT-X-BITRATE is written when disabled, got: %s.", playlist)
+	}
+}
+
+func TestChunksRemoved(t *testing.T) {
+	for _, windowSize := range []int{0, 5} {
+		p := New(logrus.New(), LiveWindow, 3, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+		if windowSize > 0 {
+			p.AddWindow(Window{"chunklist_dvr.m3u8", windowSize, HlsOutputModeNone})
+		}
+		removed := []string{}
+		p.SetOnChunksRemoved(func(chunks []Chunk) {
+			for _, chunk := range chunks {
+				removed = append(removed, chunk.FileName)
+			}
+		})
+
+		for i := 0; i < 8; i++ {
+			p.AddChunk(Chunk{FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 4}, false)
+		}
+
+		// Only the ones out of the largest window
+		xpectedWindowSize := windowSize
+		if xpectedWindowSize <= 0 {
+			xpectedWindowSize = 3
+		}
+		xpected := []string{}
+		for i := 0; i < 8-xpectedWindowSize; i++ {
+			xpected = append(xpected, fmt.Sprintf("chunk_%05d.ts", i))
+		}
+		if !reflect.DeepEqual(removed, xpected) {
+			t.Errorf("Removed chunks are not correct (window: %d), got: %v, want: %v.", windowSize, removed, xpected)
+		}
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	p := New(logrus.New(), LiveWindow, 3, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	for i := 0; i < 5; i++ {
+		p.AddChunk(Chunk{FileName: fmt.Sprintf("chunk_%05d.ts", i), DurationS: 4, IsDisco: i == 2 || i == 3, Parts: []Part{{FileName: "part.ts", DurationS: 1}}}, false)
+	}
+	p.AddChunk(Chunk{IsGrowing: true, FileName: "chunk_00005.ts", DurationS: 4}, false)
+
+	state := p.GetState()
+	if state.MediaSequence != 2 || state.DiscontinuitySequence != 0 || len(state.Chunks) != 3 {
+		t.Fatalf("State is not correct, got: %+v.", state)
+	}
+	data, _ := json.Marshal(state)
+	restoredState := State{}
+	if err := json.Unmarshal(data, &restoredState); err != nil {
+		t.Fatal("Error decoding the state. Err: ", err)
+	}
+
+	r := New(logrus.New(), LiveWindow, 3, false, 4, 3, "chunklist.m3u8", "", HlsOutputModeNone, nil, nil)
+	if err := r.RestoreState(restoredState); err != nil {
+		t.Fatal("Error restoring the state. Err: ", err)
+	}
+	r.AddChunk(Chunk{FileName: "chunk_00006.ts", DurationS: 4, IsDisco: true}, false)
+
+	xpected := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n#EXT-X-TARGETDURATION:4\n#EXT-X-DISCONTINUITY\n#EXTINF:4.00000000,\nchunk_00003.ts\n#EXTINF:4.00000000,\nchunk_00004.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4.00000000,\nchunk_00006.ts\n"
+	if got := r.String(); got != xpected {
+		t.Errorf("Restored chunklist is not correct, got: %s, want: %s.", got, xpected)
+	}
+	if err := r.RestoreState(restoredState); err == nil {
+		t.Errorf("State restored after adding chunks")
+	}
+}
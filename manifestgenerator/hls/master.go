@@ -0,0 +1,413 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"go-ts-segmenter/uploaders/httpserver"
+	"go-ts-segmenter/uploaders/httpuploader"
+	"go-ts-segmenter/uploaders/s3uploader"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Variant EXT-X-STREAM-INF information of a chunklist (URI relative to the master playlist), zero / empty attributes are not written. Audio and Subtitles are the GROUP-ID of its renditions. The I-frames chunklist (EXT-X-I-FRAME-STREAM-INF) is written if it has URI and bandwidth
+type Variant struct {
+	URI              string  `json:"uri"`
+	Bandwidth        int     `json:"bandwidth"`
+	AverageBandwidth int     `json:"averageBandwidth"`
+	Resolution       string  `json:"resolution"`
+	FrameRate        float64 `json:"frameRate"`
+	Codecs           string  `json:"codecs"`
+	Audio            string  `json:"audio"`
+	Subtitles        string  `json:"subtitles"`
+	IFramesURI       string  `json:"iFramesUri"`
+	IFramesBandwidth int     `json:"iFramesBandwidth"`
+}
+
+// RenditionTypes Type of an alternate rendition
+type RenditionTypes string
+
+const (
+	// RenditionAudio Audio rendition (Ex: other language)
+	RenditionAudio RenditionTypes = "AUDIO"
+
+	// RenditionSubtitles Subtitles rendition (WebVTT chunklist)
+	RenditionSubtitles RenditionTypes = "SUBTITLES"
+)
+
+// Rendition EXT-X-MEDIA information of an alternate rendition (URI relative to the master playlist, the audio ones without URI are in the variants)
+type Rendition struct {
+	Type       RenditionTypes `json:"type"`
+	GroupID    string         `json:"groupId"`
+	Name       string         `json:"name"`
+	Language   string         `json:"language"`
+	Default    bool           `json:"default"`
+	Autoselect bool           `json:"autoselect"`
+	URI        string         `json:"uri"`
+}
+
+// String Returns the EXT-X-MEDIA tag
+func (r *Rendition) String() string {
+	ret := "#EXT-X-MEDIA:TYPE=" + string(r.Type) + ",GROUP-ID=\"" + r.GroupID + "\",NAME=\"" + r.Name + "\""
+	if r.Language != "" {
+		ret = ret + ",LANGUAGE=\"" + r.Language + "\""
+	}
+	ret = ret + ",DEFAULT=" + yesNo(r.Default) + ",AUTOSELECT=" + yesNo(r.Autoselect || r.Default)
+	if r.URI != "" {
+		ret = ret + ",URI=\"" + r.URI + "\""
+	}
+
+	return ret + "\n"
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "YES"
+	}
+	return "NO"
+}
+
+// MasterDescriptor Variants and alternate renditions of a master playlist
+type MasterDescriptor struct {
+	Variants   []Variant   `json:"variants"`
+	Renditions []Rendition `json:"renditions"`
+}
+
+// String Returns the EXT-X-STREAM-INF tag and the URI
+func (v *Variant) String() string {
+	ret := "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.Itoa(v.Bandwidth)
+	if v.AverageBandwidth > 0 {
+		ret = ret + ",AVERAGE-BANDWIDTH=" + strconv.Itoa(v.AverageBandwidth)
+	}
+	if v.Resolution != "" {
+		ret = ret + ",RESOLUTION=" + v.Resolution
+	}
+	if v.FrameRate > 0 {
+		ret = ret + ",FRAME-RATE=" + fmt.Sprintf("%.3f", v.FrameRate)
+	}
+	if v.Codecs != "" {
+		ret = ret + ",CODECS=\"" + v.Codecs + "\""
+	}
+	if v.Audio != "" {
+		ret = ret + ",AUDIO=\"" + v.Audio + "\""
+	}
+	if v.Subtitles != "" {
+		ret = ret + ",SUBTITLES=\"" + v.Subtitles + "\""
+	}
+
+	return ret + "\n" + v.URI + "\n"
+}
+
+// IFramesString Returns the EXT-X-I-FRAME-STREAM-INF tag, empty if the variant has no I-frames chunklist
+func (v *Variant) IFramesString() string {
+	if v.IFramesURI == "" || v.IFramesBandwidth <= 0 {
+		return ""
+	}
+
+	ret := "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=" + strconv.Itoa(v.IFramesBandwidth)
+	if v.Resolution != "" {
+		ret = ret + ",RESOLUTION=" + v.Resolution
+	}
+	if v.Codecs != "" {
+		ret = ret + ",CODECS=\"" + v.Codecs + "\""
+	}
+
+	return ret + ",URI=\"" + v.IFramesURI + "\"\n"
+}
+
+// LoadDescriptor Reads a JSON master descriptor, an object with variants and renditions or an array of variants
+func LoadDescriptor(fileName string) (MasterDescriptor, error) {
+	descriptor := MasterDescriptor{}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return descriptor, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &descriptor.Variants)
+	} else {
+		err = json.Unmarshal(data, &descriptor)
+	}
+	if err != nil {
+		return descriptor, err
+	}
+	for _, v := range descriptor.Variants {
+		if v.URI == "" {
+			return descriptor, fmt.Errorf("Variant without uri in %s", fileName)
+		}
+	}
+
+	return descriptor, nil
+}
+
+// Master Master (multivariant) playlist, it can be shared by several chunklists generators (Ex: ABR ladder)
+type Master struct {
+	log               *logrus.Logger
+	masterFileName    string
+	variants          []Variant
+	renditions        []Rendition
+	outputType        OutputTypes
+	httpUploader      *httpuploader.HTTPUploader
+	s3Uploader        *s3uploader.S3Uploader
+	httpServer        *httpserver.HTTPServer
+	isFsync           bool
+	uriPrefix         string
+	mutex             sync.Mutex
+	onManifestUpdated ManifestUpdatedCallback
+	secondaryOutputs  SecondaryOutputs
+}
+
+// NewMaster Creates a master playlist, the renditions are not validated (see Validate)
+func NewMaster(
+	log *logrus.Logger,
+	masterFileName string,
+	variants []Variant,
+	renditions []Rendition,
+	outputType OutputTypes,
+	httpUploader *httpuploader.HTTPUploader,
+	s3Uploader *s3uploader.S3Uploader,
+) *Master {
+	m := Master{
+		log,
+		masterFileName,
+		append([]Variant{}, variants...),
+		append([]Rendition{}, renditions...),
+		outputType,
+		httpUploader,
+		s3Uploader,
+		nil,
+		false,
+		"",
+		sync.Mutex{},
+		nil,
+		SecondaryOutputs{},
+	}
+
+	return &m
+}
+
+// Validate Checks the renditions, and that the groups referenced by the variants exist
+func (m *Master) Validate() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.renditions {
+		if err := m.validateRendition(m.renditions[i], m.renditions[:i]); err != nil {
+			return err
+		}
+	}
+	for _, v := range m.variants {
+		if v.Audio != "" && !m.hasGroup(RenditionAudio, v.Audio) {
+			return fmt.Errorf("Variant %s references the audio group %s that has no renditions", v.URI, v.Audio)
+		}
+		if v.Subtitles != "" && !m.hasGroup(RenditionSubtitles, v.Subtitles) {
+			return fmt.Errorf("Variant %s references the subtitles group %s that has no renditions", v.URI, v.Subtitles)
+		}
+	}
+
+	return nil
+}
+
+// AddRendition Adds an alternate rendition (if its URI is not already in the group), the master playlist is saved
+func (m *Master) AddRendition(r Rendition) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, p := range m.renditions {
+		if p.Type == r.Type && p.GroupID == r.GroupID && p.URI == r.URI && r.URI != "" {
+			return nil
+		}
+	}
+	if err := m.validateRendition(r, m.renditions); err != nil {
+		return err
+	}
+	m.renditions = append(m.renditions, r)
+
+	return m.save()
+}
+
+// validateRendition Checks the rendition attributes, and that its NAME and DEFAULT are unique in its group of the previous renditions
+func (m *Master) validateRendition(r Rendition, previous []Rendition) error {
+	if r.Type != RenditionAudio && r.Type != RenditionSubtitles {
+		return fmt.Errorf("Invalid rendition type %s (valid: %s, %s)", r.Type, RenditionAudio, RenditionSubtitles)
+	}
+	if r.GroupID == "" || r.Name == "" {
+		return errors.New("Rendition without groupId or name")
+	}
+	if r.Type == RenditionSubtitles && r.URI == "" {
+		return fmt.Errorf("Subtitles rendition %s without uri", r.Name)
+	}
+
+	for _, p := range previous {
+		if p.Type != r.Type || p.GroupID != r.GroupID {
+			continue
+		}
+		if p.Name == r.Name {
+			return fmt.Errorf("Rendition name %s repeated in the group %s", r.Name, r.GroupID)
+		}
+		if p.Default && r.Default {
+			return fmt.Errorf("Group %s has several default renditions (%s, %s)", r.GroupID, p.Name, r.Name)
+		}
+	}
+
+	return nil
+}
+
+// hasGroup Returns true if there are renditions of the type in the group
+func (m *Master) hasGroup(renditionType RenditionTypes, groupID string) bool {
+	for _, r := range m.renditions {
+		if r.Type == renditionType && r.GroupID == groupID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VariantIndex Returns the index of the variant of the URI, -1 if it is not found
+func (m *Master) VariantIndex(uri string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, v := range m.variants {
+		if v.URI == uri {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// GetVariant Returns the variant of the index
+func (m *Master) GetVariant(index int) Variant {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.variants[index]
+}
+
+// SetVariantBandwidth Sets the bandwidths (bits per second) of the variant, the master playlist is saved if they changed
+func (m *Master) SetVariantBandwidth(index int, bandwidth int, averageBandwidth int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v := &m.variants[index]
+	if v.Bandwidth == bandwidth && v.AverageBandwidth == averageBandwidth {
+		return nil
+	}
+	v.Bandwidth = bandwidth
+	v.AverageBandwidth = averageBandwidth
+
+	return m.save()
+}
+
+// SetVariantIFrames Sets the I-frames chunklist (URI relative to the master playlist) and its peak bandwidth (bits per second) of the variant, the master playlist is saved if they changed
+func (m *Master) SetVariantIFrames(index int, uri string, bandwidth int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v := &m.variants[index]
+	if v.IFramesURI == uri && v.IFramesBandwidth == bandwidth {
+		return nil
+	}
+	v.IFramesURI = uri
+	v.IFramesBandwidth = bandwidth
+
+	return m.save()
+}
+
+// Save Saves the master playlist
+func (m *Master) Save() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.save()
+}
+
+func (m *Master) save() error {
+	master := []byte(m.string())
+	ret := saveManifest(m.masterFileName, master, m.outputType, m.httpUploader, m.s3Uploader, m.httpServer, m.isFsync, m.onManifestUpdated)
+	m.secondaryOutputs.save(m.log, m.masterFileName, master, m.httpUploader, m.s3Uploader, m.httpServer, m.isFsync, m.onManifestUpdated)
+
+	return ret
+}
+
+// SetURIPrefix Sets the prefix of the variants, I-frames and renditions URIs written in the master playlist (Ex: https://cdn.example/chan1/), empty they are written as they are (relative to the master playlist). See PrefixURI
+func (m *Master) SetURIPrefix(uriPrefix string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.uriPrefix = uriPrefix
+}
+
+// SetFsync Fsyncs the master playlist file before it replaces the previous one (HlsOutputModeFile)
+func (m *Master) SetFsync(isFsync bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isFsync = isFsync
+}
+
+// SetHTTPServer Sets the built-in HTTP server used by HlsOutputModeHTTPServer
+func (m *Master) SetHTTPServer(httpServer *httpserver.HTTPServer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.httpServer = httpServer
+}
+
+// SetOnManifestUpdated Sets the receiver of the master playlist in HlsOutputModeCallback
+func (m *Master) SetOnManifestUpdated(onManifestUpdated ManifestUpdatedCallback) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.onManifestUpdated = onManifestUpdated
+}
+
+// SetSecondaryOutputs Sets the other outputs where the master playlist is saved
+func (m *Master) SetSecondaryOutputs(secondaryOutputs SecondaryOutputs) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.secondaryOutputs = secondaryOutputs
+}
+
+// String Returns the master playlist
+func (m *Master) String() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.string()
+}
+
+func (m *Master) string() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("#EXTM3U\n")
+	buffer.WriteString("#EXT-X-VERSION:" + strconv.Itoa(DefaultMasterVersion) + "\n")
+	for _, r := range m.renditions {
+		if r.URI != "" {
+			r.URI = PrefixURI(m.uriPrefix, r.URI)
+		}
+		buffer.WriteString(r.String())
+	}
+	for _, v := range m.variants {
+		v.URI = PrefixURI(m.uriPrefix, v.URI)
+		buffer.WriteString(v.String())
+	}
+	for _, v := range m.variants {
+		if v.IFramesURI != "" {
+			v.IFramesURI = PrefixURI(m.uriPrefix, v.IFramesURI)
+		}
+		buffer.WriteString(v.IFramesString())
+	}
+
+	return buffer.String()
+}
@@ -1,12 +1,34 @@
 package manifestgenerator
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go-ts-segmenter/manifestgenerator/atomicfile"
+	"go-ts-segmenter/manifestgenerator/captions"
+	"go-ts-segmenter/manifestgenerator/chunkmeta"
+	"go-ts-segmenter/manifestgenerator/chunkverify"
+	"go-ts-segmenter/manifestgenerator/dvbtime"
+	"go-ts-segmenter/manifestgenerator/encryption"
+	"go-ts-segmenter/manifestgenerator/fmp4"
 	"go-ts-segmenter/manifestgenerator/hls"
 	"go-ts-segmenter/manifestgenerator/mediachunk"
+	"go-ts-segmenter/manifestgenerator/pestransform"
+	"go-ts-segmenter/manifestgenerator/scte35"
 	"go-ts-segmenter/manifestgenerator/tspacket"
+	"go-ts-segmenter/uploaders/httpserver"
 	"go-ts-segmenter/uploaders/httpuploader"
 	"go-ts-segmenter/uploaders/s3uploader"
 
@@ -19,6 +41,15 @@ var Version = "1.1.0"
 // HlsDefaultVersion to use
 const HlsDefaultVersion int = 3
 
+// HlsByteRangeVersion min version to use with byte range chunks (EXT-X-BYTERANGE)
+const HlsByteRangeVersion int = 4
+
+// HlsIFramesVersion min version to use in the I-frames only chunklist (EXT-X-I-FRAMES-ONLY)
+const HlsIFramesVersion int = 4
+
+// IFramesBandwidthWindow Number of I-frames used to measure the peak bandwidth of the I-frames chunklist in the master playlist
+const IFramesBandwidthWindow int = 30
+
 // ChunkInitTypes types indicates where to put the init data (PAT and PMT)
 type ChunkInitTypes int
 
@@ -33,20 +64,160 @@ const (
 	ChunkInitStart
 )
 
+// ChunkFormats Container of the media chunks
+type ChunkFormats int
+
+const (
+	// ChunkFormatTS MPEG-TS chunks
+	ChunkFormatTS ChunkFormats = iota
+
+	// ChunkFormatCMAF CMAF (fMP4) chunks with their init segment (EXT-X-MAP), only H.264 video and AAC audio
+	ChunkFormatCMAF
+)
+
 const (
 	//GhostPrefixDefault ghost chunk prefix
 	GhostPrefixDefault = ".growing_"
 
+	//InProgressExtensionDefault Extension of the media chunk files while they are written (SetInProgressSignaling)
+	InProgressExtensionDefault = ".part"
+
 	//ChunkFileExtensionDefault default chunk extension
 	ChunkFileExtensionDefault = ".ts"
 
 	//ChunkInitFileName Init chunk filename
 	ChunkInitFileName = "init"
+
+	//CMAFChunkFileExtension CMAF (fMP4) chunk extension
+	CMAFChunkFileExtension = ".m4s"
+
+	//CMAFInitFileExtension CMAF (fMP4) init segment extension
+	CMAFInitFileExtension = ".mp4"
+
+	//ManualPMTPID PMT PID of the PMT generated for the manual PIDs
+	ManualPMTPID = 0x1000
+
+	//CaptionsFileExtension WebVTT captions chunk extension
+	CaptionsFileExtension = ".vtt"
+
+	//IFrameChunkPrefix I-frame chunk filename prefix (before the chunk base filename)
+	IFrameChunkPrefix = "iframe_"
+
+	//KeyFileName Encryption key filename
+	KeyFileName = "key"
+
+	//KeyFileExtension Encryption key extension
+	KeyFileExtension = ".key"
+
+	//KeyURINamePlaceholder Replaced by the key filename in the key URI template
+	KeyURINamePlaceholder = "{name}"
 )
 
 const (
 	// ChunkLengthToleranceS Tolerance calculating chunk length
 	ChunkLengthToleranceS = 0.25
+
+	// MaxTimestampJumpSDefault PTS jump (in seconds) considered a discontinuity if the discontinuity_indicator is not set
+	MaxTimestampJumpSDefault = 5.0
+
+	// MaxFrameDurationS PTS increments (in seconds) of this value or longer are not taken as the frame duration (Ex: gaps in the stream)
+	MaxFrameDurationS = 0.5
+
+	// MaxDurationDriftSDefault Difference (in seconds) between the chunk duration and the PCR PID elapsed time that makes the auto duration source use the PCR
+	MaxDurationDriftSDefault = 1.0
+
+	// MaxDVBTimeGapS Max time (in seconds of PCR) without TDT / TOT before it is considered lost (they are sent at least every 30s), the last one is extrapolated
+	MaxDVBTimeGapS = 30.0
+
+	// MaxHEVCHeldPackets Max packets held waiting the 1st VCL NAL unit of a HEVC PES, after them the PES is not a random access point
+	MaxHEVCHeldPackets = 64
+)
+
+// videoCodecs Video codec, it indicates how the random access points are detected
+type videoCodecs int
+
+const (
+	// VideoCodecH264 h264, random_access_indicator
+	VideoCodecH264 videoCodecs = iota
+
+	// VideoCodecHEVC h265, IRAP NAL unit types
+	VideoCodecHEVC
+
+	// VideoCodecMPEG2 MPEG-2 video, sequence header + I picture
+	VideoCodecMPEG2
+)
+
+// AudioCodecs Audio codec of the audio PID
+type AudioCodecs int
+
+const (
+	// AudioCodecAAC AAC (ADTS)
+	AudioCodecAAC AudioCodecs = iota
+
+	// AudioCodecAC3 AC-3 (Dolby Digital)
+	AudioCodecAC3
+
+	// AudioCodecEAC3 E-AC-3 (Dolby Digital Plus)
+	AudioCodecEAC3
+)
+
+// CodecsString Returns the codec string to use in the HLS CODECS attribute (AAC is assumed to be AAC-LC)
+func (c AudioCodecs) CodecsString() string {
+	switch c {
+	case AudioCodecAC3:
+		return "ac-3"
+	case AudioCodecEAC3:
+		return "ec-3"
+	}
+
+	return "mp4a.40.2"
+}
+
+// MaxSegmentDurActions Action when the open chunk reaches the max duration without a random access point
+type MaxSegmentDurActions int
+
+const (
+	// MaxSegmentDurCut Cuts the chunk at the next packet (the next chunk does not start with a random access point)
+	MaxSegmentDurCut MaxSegmentDurActions = iota
+
+	// MaxSegmentDurDrop Cuts the chunk and drops the media data until the next random access point
+	MaxSegmentDurDrop
+)
+
+// DurationSources Clock used to measure the chunk durations
+type DurationSources int
+
+const (
+	// DurationSourceAuto PCR of the keyframe packet (PTS for audio only / video only), the PCR PID is used if it is missing or not consistent with the PCR
+	DurationSourceAuto DurationSources = iota
+
+	// DurationSourcePCR PCR of the PCR PID declared in the PMT (the video / audio PCR if there is no PMT)
+	DurationSourcePCR
+)
+
+// EndlistModes EXT-X-ENDLIST of the live chunklists when the generator is closed (Ex: input EOF)
+type EndlistModes int
+
+const (
+	// EndlistNone The chunklists are left open (Ex: the encoder is restarted)
+	EndlistNone EndlistModes = iota
+
+	// EndlistOnClose The EXT-X-ENDLIST is added when it is closed
+	EndlistOnClose
+
+	// EndlistAfterGrace The EXT-X-ENDLIST is added after a grace period without new data since it is closed
+	EndlistAfterGrace
+)
+
+// PDTSources Source of the EXT-X-PROGRAM-DATE-TIME
+type PDTSources int
+
+const (
+	// PDTSourceClock Local wall clock when the 1st chunk received its 1st byte, carried forward by the chunk durations
+	PDTSourceClock PDTSources = iota
+
+	// PDTSourceDVB UTC time of the DVB TDT / TOT mapped to the chunk start PCR
+	PDTSourceDVB
 )
 
 // packetTableTypes
@@ -91,6 +262,351 @@ type options struct {
 	lhlsAdvancedChunks int
 	httpUploader       *httpuploader.HTTPUploader
 	s3Uploader         *s3uploader.S3Uploader
+	httpServer         *httpserver.HTTPServer
+	flushBytes         int
+	flushInterval      time.Duration
+	isManifestFsync    bool
+
+	// chunkFilenameTemplate Filename of the media chunks (nil chunkBaseFilename and the index)
+	chunkFilenameTemplate *mediachunk.FilenameTemplate
+
+	// isChunkChecksum and isChunkChecksumSidecar SHA-256 of the media chunks sent with the uploads, and saved in a sidecar file (file output)
+	isChunkChecksum        bool
+	isChunkChecksumSidecar bool
+
+	// chunkCallbacks and onManifestUpdated Receivers of the media files in mediachunk.ChunkOutputModeCallback and of the manifests in hls.HlsOutputModeCallback
+	chunkCallbacks    *mediachunk.Callbacks
+	onManifestUpdated hls.ManifestUpdatedCallback
+
+	// isNoFlagFiles The file output writes the media files to hidden temporary files renamed when they are completed instead of using flag files
+	isNoFlagFiles bool
+
+	// mediaFsyncEvery The file output fsyncs every this number of media chunks (the init and key files always), 0 disabled
+	mediaFsyncEvery int
+
+	// writeQueueBytes The file output writes the media chunks from a queue of this size in other goroutine, 0 disabled
+	writeQueueBytes int
+
+	// secondaryChunkOutputTypes and secondaryManifestOutputs Other destinations of the media files and manifests (the primary ones are chunkOutputType and manifestOutputType), and the report of their failures
+	secondaryChunkOutputTypes []mediachunk.OutputTypes
+	onSecondaryChunkFailed    func(outputType mediachunk.OutputTypes, fileName string, err error)
+	secondaryManifestOutputs  hls.SecondaryOutputs
+
+	// isNoChunkFlagFile and chunkInProgressExtension The media chunks being written have no flag file, and the extension of their files until they are completed (empty none)
+	isNoChunkFlagFile        bool
+	chunkInProgressExtension string
+}
+
+// splice SCTE-35 splice pending to be attached to a chunk (isLate indicates it arrived after its splice time), startDate is set when it is attached
+type splice struct {
+	info      scte35.SpliceInfo
+	ptsS      float64
+	startDate time.Time
+	isLate    bool
+}
+
+// chunkEncryption Encryption of the media chunks, the key file is saved (in keyPath, empty the chunks destination) before the 1st chunk that uses it, keyURI is empty until then
+type chunkEncryption struct {
+	method         encryption.Methods
+	key            []byte
+	ivMode         encryption.IVModes
+	keyURITemplate string
+	keyPath        string
+	keyIndex       uint64
+	keyURI         string
+
+	// keyFormat and keyFormatVersions EXT-X-KEY KEYFORMAT and KEYFORMATVERSIONS (empty not written)
+	keyFormat         string
+	keyFormatVersions string
+
+	// rotationChunks and rotationS A new key is used after these chunks / seconds of media with the current one (0 never)
+	rotationChunks int
+	rotationS      float64
+	keyChunks      int
+	keyDurationS   float64
+
+	// provider Source of the keys (nil random ones), its keys are not saved and its URIs are used as they are. nextKey is the request of the next key (nil not requested yet)
+	provider encryption.KeyProvider
+	nextKey  *keyPrefetch
+}
+
+// keyPrefetch Request of a key to the provider done in the background, done is closed when the key or the error are set
+type keyPrefetch struct {
+	index uint64
+	key   encryption.ProvidedKey
+	err   error
+	done  chan struct{}
+}
+
+// prefetchKey Requests the key of the index to the provider in the background
+func (e *chunkEncryption) prefetchKey(index uint64) {
+	p := &keyPrefetch{index, encryption.ProvidedKey{}, nil, make(chan struct{})}
+	e.nextKey = p
+
+	provider := e.provider
+	go func() {
+		p.key, p.err = provider.GetKey(index)
+		close(p.done)
+	}()
+}
+
+// isDone Returns true if the request finished (without waiting for it)
+func (p *keyPrefetch) isDone() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// encryptedChunk Key of a chunk in the chunklist, the key and the IV of its data
+type encryptedChunk struct {
+	key    *hls.Key
+	aesKey []byte
+	iv     []byte
+}
+
+// sampleAESChunks SAMPLE-AES encryption of the chunks, the video (H.264) and audio (AAC) PES are rewritten with their samples encrypted
+type sampleAESChunks struct {
+	encrypter *encryption.SampleAES
+	rewriter  pestransform.Rewriter
+	videoPID  int
+}
+
+// transform Encrypts the ES of a PES of the video or audio PID in the chunks
+func (s *sampleAESChunks) transform(pID int, es []byte) []byte {
+	if pID == s.videoPID {
+		return s.encrypter.EncryptAVC(es)
+	}
+	return s.encrypter.EncryptADTS(es)
+}
+
+// Stats Input counters, they can be read from other goroutines with GetStats
+type Stats struct {
+	InputBytes       uint64
+	Packets          uint64
+	VideoBytes       uint64
+	AudioBytes       uint64
+	LastDataUnixNano int64
+	VideoPID         int64
+	AudioPID         int64
+	CCErrors         uint64
+	VideoCCErrors    uint64
+	AudioCCErrors    uint64
+	NullPackets      uint64
+
+	// Gaps Chunks flagged as EXT-X-GAP because their upload failed
+	Gaps uint64
+
+	// WriteQueueHighWaterBytes, WriteQueueBlocks and WriteQueueBlockedNs Max bytes queued to be written to the media files, writes blocked because the queue was full and the time blocked (only SetWriteQueue)
+	WriteQueueHighWaterBytes uint64
+	WriteQueueBlocks         uint64
+	WriteQueueBlockedNs      uint64
+
+	// VerifyFailures Media chunks that failed the verification after they were written (only SetChunkVerification)
+	VerifyFailures uint64
+
+	// SecondaryFailures Media files and manifests that failed in a secondary destination (only SetSecondaryDestinations)
+	SecondaryFailures uint64
+}
+
+// chunkVerification Checks of the media chunks after they are written, the EXTINF and PTS span can differ toleranceS, with isGap the chunks that fail are flagged as EXT-X-GAP
+type chunkVerification struct {
+	toleranceS float64
+	isGap      bool
+}
+
+// uploadFailures Chunks whose upload failed (reported from the upload goroutines), with isGap they are kept until they are flagged as EXT-X-GAP in the chunklist
+type uploadFailures struct {
+	mutex     sync.Mutex
+	isGap     bool
+	fileNames []string
+	failed    int
+}
+
+// add Adds the chunk whose upload failed
+func (u *uploadFailures) add(fileName string, err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.failed++
+	if u.isGap {
+		u.fileNames = append(u.fileNames, fileName)
+	}
+}
+
+// failedUploads Returns the number of chunks whose upload failed
+func (u *uploadFailures) failedUploads() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.failed
+}
+
+// take Returns the chunks added and removes them
+func (u *uploadFailures) take() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	fileNames := u.fileNames
+	u.fileNames = nil
+	return fileNames
+}
+
+// takeFileName Returns true if the upload of the chunk failed and removes it
+func (u *uploadFailures) takeFileName(fileName string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for i, f := range u.fileNames {
+		if f == fileName {
+			u.fileNames = append(u.fileNames[:i], u.fileNames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pendingEndlist EXT-X-ENDLIST waiting for the grace period, it is canceled by new data
+type pendingEndlist struct {
+	mutex sync.Mutex
+	timer *time.Timer
+	done  chan bool
+}
+
+// start Calls closeChunklists after the grace period (the pending one is canceled)
+func (e *pendingEndlist) start(grace time.Duration, closeChunklists func()) {
+	e.cancel()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	done := make(chan bool)
+	e.done = done
+	e.timer = time.AfterFunc(grace, func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		if e.done == done {
+			closeChunklists()
+			close(done)
+			e.timer = nil
+			e.done = nil
+		}
+	})
+}
+
+// cancel Cancels the pending EXT-X-ENDLIST, returns false if there is none
+func (e *pendingEndlist) cancel() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.timer == nil {
+		return false
+	}
+	e.timer.Stop()
+	close(e.done)
+	e.timer = nil
+	e.done = nil
+	return true
+}
+
+// wait Waits until the pending EXT-X-ENDLIST is added or canceled
+func (e *pendingEndlist) wait() {
+	e.mutex.Lock()
+	done := e.done
+	e.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// chunkRetention Deletes the local files of the chunks removed from a live chunklist once there are more than margin pending (the players can still be downloading them), with their flag, checksum and metadata files and the ones of their parts
+type chunkRetention struct {
+	log     *logrus.Logger
+	margin  int
+	pending []string
+	parts   map[string][]string
+}
+
+// newChunkRetention Creates the deleter of the chunks removed from a chunklist
+func newChunkRetention(log *logrus.Logger, margin int) *chunkRetention {
+	return &chunkRetention{log, margin, []string{}, map[string][]string{}}
+}
+
+// addPart Adds the part file of the chunk, it is deleted with it
+func (r *chunkRetention) addPart(chunkFileName string, partFileName string) {
+	r.parts[chunkFileName] = append(r.parts[chunkFileName], partFileName)
+}
+
+// removed Adds the chunks removed from the chunklist and deletes the ones out of the margin
+func (r *chunkRetention) removed(chunks []hls.Chunk) {
+	for _, chunk := range chunks {
+		r.pending = append(r.pending, chunk.FileName)
+	}
+	for len(r.pending) > r.margin {
+		r.deleteChunk(r.pending[0])
+		r.pending = r.pending[1:]
+	}
+}
+
+// deleteChunk Deletes the chunk file, its parts and their flag, checksum and metadata files, the errors are only logged
+func (r *chunkRetention) deleteChunk(fileName string) {
+	fileNames := append([]string{fileName}, r.parts[fileName]...)
+	delete(r.parts, fileName)
+
+	for _, f := range fileNames {
+		for _, name := range []string{f, path.Join(path.Dir(f), GhostPrefixDefault+path.Base(f)), f + mediachunk.ChecksumSidecarExtension, chunkmeta.FileName(f)} {
+			err := os.Remove(name)
+			if err != nil && !os.IsNotExist(err) {
+				r.log.Error("Error deleting the chunk file ", name, ". Err: ", err)
+			}
+		}
+	}
+	r.log.Debug("Deleted chunk ", fileName, " out of the live window")
+}
+
+// liveState Saves the live state file (chunkmeta.LiveState) with the media chunk being written on every write, and without chunk when it is closed. The file has the extension of the chunk being written
+type liveState struct {
+	log       *logrus.Logger
+	fileName  string
+	extension string
+}
+
+// onWritten Returns the OnWritten of a media chunk, mediaURI returns its URI in the chunklist
+func (s *liveState) onWritten(mediaURI func(fileName string) string) func(info mediachunk.ChunkInfo) {
+	return func(info mediachunk.ChunkInfo) {
+		s.save(&chunkmeta.LiveStateChunk{SequenceNumber: info.Index, URI: mediaURI(info.FileName), FileName: info.FileName + s.extension, Bytes: info.Bytes})
+	}
+}
+
+// save Saves the state with the chunk (nil none), the errors are only logged
+func (s *liveState) save(chunk *chunkmeta.LiveStateChunk) {
+	data, err := json.Marshal(chunkmeta.LiveState{Version: chunkmeta.Version, UpdatedAt: time.Now(), Chunk: chunk})
+	if err == nil {
+		err = atomicfile.WriteFile(s.fileName, data, 0644, false)
+	}
+	if err != nil {
+		s.log.Error("Error saving the live state file ", s.fileName, ". Err: ", err)
+	}
+}
+
+// chunkMetadata JSON metadata sidecar of the chunks and rolling index (indexFileName, empty none) of the last windowSize ones (<= 0 all)
+type chunkMetadata struct {
+	indexFileName string
+	windowSize    int
+	chunks        []chunkmeta.Chunk
+}
+
+// Callbacks Receivers of the media files (chunks, parts, init, captions, I-frames, keys, metadata) in mediachunk.ChunkOutputModeCallback and of the chunklists and MPD in hls.HlsOutputModeCallback, to embed the segmenter without files or uploads. They are called from the goroutine that adds the data (the delayed EXT-X-ENDLIST from a timer), the ones nil are not called
+type Callbacks struct {
+	OnChunkData       func(info mediachunk.ChunkInfo, r io.Reader)
+	OnChunkClosed     func(info mediachunk.ChunkInfo)
+	OnManifestUpdated func(name string, body []byte)
+}
+
+// segmenterState State saved to the state file after every chunk, it is loaded to resume the numbering and the chunklist after a restart
+type segmenterState struct {
+	NextChunkIndex     uint64    `json:"nextChunkIndex"`
+	NextIFrameIndex    uint64    `json:"nextIFrameIndex"`
+	NextInitChunkIndex uint64    `json:"nextInitChunkIndex"`
+	Chunklist          hls.State `json:"chunklist"`
+	SavedAt            time.Time `json:"savedAt"`
+	NextKeyIndex       uint64    `json:"nextKeyIndex"`
 }
 
 // ManifestGenerator Creates the manifest and chunks the media
@@ -129,6 +645,250 @@ type ManifestGenerator struct {
 
 	//initialChunkCreation Flag tha indicates the first chunk[s] has been created
 	fistChunkCreated bool
+
+	//isNextChunkDisco Flag that indicates the next chunk added to the chunklist starts a discontinuity
+	isNextChunkDisco bool
+
+	// Resync data (number of consecutive sync bytes needed, bytes pending to confirm sync, stats)
+	resyncPackets int
+	unsyncedBuf   []byte
+	hasBeenInSync bool
+	skippedBytes  uint64
+	resyncs       uint64
+
+	// Input packet size (188 or 204), the Reed-Solomon bytes of 204 bytes packets are discarded
+	inputPacketSize  int
+	forcedPacketSize int
+	rsBytes          []byte
+
+	// Input stats (pointer to keep the atomic counters aligned)
+	stats *Stats
+
+	// Audio only mode, chunks are created based on the audio PTS
+	forcedAudioOnly bool
+	isAudioOnly     bool
+
+	// Video only (auto PIDs found a PMT without audio), chunks are created based on the video PTS
+	isVideoOnly bool
+
+	// Video codec (auto PIDs), the random access points are detected based on it
+	videoCodec videoCodecs
+
+	// Audio codec (auto PIDs), and the codec to select if the PMT has several audio codecs
+	audioCodec          AudioCodecs
+	preferredAudioCodec AudioCodecs
+
+	// Program selection for MPTS (< 0 any), and the last PAT programs seen
+	programNumber int
+	programPMTPID int
+	patPrograms   []tspacket.PATProgram
+
+	// Unrecoverable processing error (Ex: selected program not found)
+	err error
+
+	// SCTE-35 (configured PID: -1 auto detected from the PMT, 0 disabled), splices not attached to a chunk yet, and the break starts (by event ID)
+	scte35PID         int
+	detectedSCTE35PID int
+	scte35Assembler   scte35.SectionAssembler
+	pendingSplices    []splice
+	breakStarts       map[uint32]splice
+	isSCTE35Cut       bool
+
+	// Last PES PTS (video, or audio if there is no video), the last PTS of the video random access point, and the PTS of the current chunk start
+	lastPTSS       float64
+	lastIDRPTSS    float64
+	chunkStartPTSS float64
+
+	// Max PES PTS of the current chunk, and the frame duration (the min PTS increment seen, -1 unknown), used for the duration of the last chunk
+	chunkMaxPTSS   float64
+	frameDurationS float64
+
+	// PTS jump (in seconds) considered a discontinuity (<= 0 disabled), and the last PCR of the video / audio packets
+	maxTimestampJumpS float64
+	lastMediaPCRS     float64
+
+	// PMT version_number (-1 no PMT yet), the last PAT of the selected program (used to create a new init chunk when the PMT changes), and the init chunks created
+	pmtVersion     int
+	lastPATPacket  tspacket.TsPacket
+	initChunkIndex uint64
+
+	// Continuity counter check, last CC per PID and if that CC was already repeated (a duplicate packet is allowed once)
+	lastCCs      map[int]int
+	isCCRepeated map[int]bool
+
+	// Max chunk duration (in seconds, <= 0 disabled) when no random access point arrives, what to do when it is reached, and if we are dropping data until the next random access point
+	maxSegmentDurS      float64
+	maxSegmentDurAction MaxSegmentDurActions
+	isDroppingToRAP     bool
+	droppedPackets      uint64
+
+	// Min chunk duration (in seconds, <= 0 disabled), the random access points before it do not cut the chunk
+	minSegmentDurS float64
+
+	// PID filtering (the PAT / PMT are rewritten to reference only the PIDs written in the chunks), extra PIDs written in the chunks, the PCR PID, and the bytes of the current chunk (input / written)
+	filterPIDs       bool
+	keepPIDs         map[int]bool
+	pcrPID           int
+	chunkInputBytes  uint64
+	chunkOutputBytes uint64
+
+	// PIDs used in the chunks for the video, audio and PMT (< 0 the source PID is kept)
+	remapVideoPID int
+	remapAudioPID int
+	remapPMTPID   int
+
+	// Data PIDs (Ex: ID3 timed metadata) written in the chunks, if auto the PMT metadata PIDs (stream type 0x15). Their PES are written whole (packets pending by PID), so a cut never orphans part of them
+	dataPIDs           map[int]bool
+	isAutoDataPIDs     bool
+	pendingDataPackets map[int][][]byte
+	pendingDataBytes   map[int]int
+
+	// Clock used for the chunk durations, the max difference with the PCR PID elapsed time (auto), the last PCR of the PCR PID, and that PCR at the current chunk start
+	durationSource      DurationSources
+	maxDurationDriftS   float64
+	lastClockPCRS       float64
+	chunkStartClockPCRS float64
+
+	// PSI generation, the init data is a generated PAT (single program) and PMT (only the PIDs written in the chunks) instead of the source ones
+	generatePSI  bool
+	generatedPMT tspacket.TsPacket
+
+	// PSI repetition interval inside the chunks (in seconds of the PCR PID, <= 0 disabled), the PAT and PMT repeated (the ones used as init data), the PCR of the last repetition, and the continuity counters of the repeated PAT and PMT
+	psiIntervalS   float64
+	hasRepeatedPSI bool
+	repeatedPAT    tspacket.TsPacket
+	repeatedPMT    tspacket.TsPacket
+	lastPSITimeS   float64
+	patCC          int
+	pmtCC          int
+
+	// Program date time written every N chunks (<= 0 disabled), the wall clock time when the 1st chunk (or the 1st one after a discontinuity) received its 1st byte, the ticks of the chunks published since then, if it has to be re-anchored (discontinuity), and the program date time of the current chunk
+	programDateTimeEvery int
+	pdtAnchor            time.Time
+	pdtTicks             int64
+	isPDTReanchor        bool
+	chunkProgramDateTime time.Time
+
+	// Program date time source, and the last DVB time (TDT / TOT) with the PCR when it was received (< 0 not received after the start / a discontinuity), and if it has not been received for MaxDVBTimeGapS
+	pdtSource        PDTSources
+	dvbTimeAssembler scte35.SectionAssembler
+	dvbTime          time.Time
+	dvbTimePCRS      float64
+	isDVBTimeLost    bool
+
+	// Master playlist (nil disabled), the variant of this chunklist (< 0 not in it), and the chunks (bits and duration) of the rolling window used to measure its bandwidth (0 chunks, not measured)
+	master          *hls.Master
+	masterVariant   int
+	bandwidthWindow int
+	windowChunkBits []float64
+	windowChunkDurS []float64
+
+	// Byte range output, the size that rotates the file (<= 0 never rotated), the index of the file the chunks are appended to, and its size
+	byteRangeMaxFileBytes int64
+	byteRangeFileIndex    uint64
+	byteRangeFileBytes    int64
+
+	// If the chunklist has EXT-X-INDEPENDENT-SEGMENTS, it is removed when a chunk does not start with a keyframe (or the PSI in ChunkInitStart mode). If the current chunk 1st video packet was checked, and if the chunk is independent so far
+	isIndependentSegments bool
+	isChunkVideoChecked   bool
+	isChunkIndependent    bool
+
+	// LL-HLS parts (partDurationS <= 0 disabled), the part being written with its index in the chunk, the PCR PID clock when it started, if its 1st video packet was checked and if it is a keyframe, and the duration of the closed parts of the current chunk
+	partDurationS      float64
+	currentPart        *mediachunk.Chunk
+	partIndex          int
+	partStartClockS    float64
+	isPartVideoChecked bool
+	isPartIndependent  bool
+	chunkPartsDurS     float64
+
+	// Version written in the chunklists (<= 0 the min needed by the features in use), and the decimal places of the EXTINF durations (0 rounded integers)
+	hlsVersion      int
+	extinfPrecision int
+
+	// I-frames only chunklist (disabled if !isIFrames) with a file per keyframe (PAT, PMT and the keyframe PES), its filename and URI in the master playlist, the keyframe chunk being written with its PTS and if its PES is complete, the index of the next one, if it starts a discontinuity, and the I-frames (bits and duration) window used to measure the bandwidth
+	isIFrames                bool
+	iFramesChunklist         hls.Hls
+	iFramesChunklistFilename string
+	iFramesURI               string
+	iFrameChunk              *mediachunk.Chunk
+	iFramePTSS               float64
+	isIFrameComplete         bool
+	iFrameIndex              uint64
+	isNextIFrameDisco        bool
+	windowIFrameBits         []float64
+	windowIFrameDurS         []float64
+
+	// Captions extracted from the video (nil disabled), written as a WebVTT file per chunk listed in their own chunklist, and if the next captions chunk starts a discontinuity
+	captions            *captions.Captions
+	captionsChunklist   hls.Hls
+	isNextCaptionsDisco bool
+
+	// CMAF chunks muxer of the video and audio (nil TS chunks), and the last init segment written
+	fmp4Muxer *fmp4.Muxer
+	fmp4Init  []byte
+
+	// MPEG-DASH manifest of the same chunks (nil disabled)
+	dash *hls.Dash
+
+	// Encryption of the media chunks (nil not encrypted), the key of the chunks being written (by chunk index) and the SAMPLE-AES PES rewriting (nil whole chunks encryption)
+	encryption *chunkEncryption
+	chunkKeys  map[uint64]encryptedChunk
+	sampleAES  *sampleAESChunks
+
+	// Chunks whose upload failed (and the ones pending to be flagged as EXT-X-GAP)
+	uploadFailures *uploadFailures
+
+	// finalizeAsVod The EVENT chunklists are converted to VOD at Close, after waiting finalizeUploadsTimeout for the uploads in progress
+	finalizeAsVod          bool
+	finalizeUploadsTimeout time.Duration
+
+	// EXT-X-ENDLIST of the live chunklists at Close, and the one waiting for the grace period
+	endlistMode    EndlistModes
+	endlistGrace   time.Duration
+	pendingEndlist *pendingEndlist
+
+	// Prefix of the media URIs written in the chunklists (empty relative to them)
+	segmentURIPrefix string
+
+	// Index of the 1st chunk and I-frame (media sequence of their chunklists), not 0 with the numbering from the wall clock
+	firstChunkIndex  uint64
+	firstIFrameIndex uint64
+
+	// Subdirectories of the media files in the Go layout of the wall clock when they are created (empty disabled), the one of the chunks being written (by index) and the last one created
+	chunkShardLayout string
+	chunkShards      map[uint64]string
+	lastShardPath    string
+
+	// Deletes the local media files out of the live window (nil disabled)
+	chunkRetention *chunkRetention
+
+	// File where the state is saved after every chunk (empty disabled)
+	stateFileName string
+
+	// JSON metadata of every chunk and index of the window (nil disabled), and the random access points and wall clock start of the chunk being written
+	chunkMetadata  *chunkMetadata
+	chunkKeyframes int
+	chunkWrittenAt time.Time
+
+	// Counters of the write queues of the media chunks
+	writeQueueStats *mediachunk.WriteQueueStats
+
+	// Verification of the media chunks written (nil disabled), and if the next chunk added to the chunklist failed it and has to be a gap
+	chunkVerification *chunkVerification
+	isNextChunkGap    bool
+
+	// Saves the media chunk being written (nil disabled)
+	liveState *liveState
+
+	// Packets (all PIDs) held from a HEVC video PES start without its 1st VCL NAL unit until it is found, their video data, and if the PES start being replayed is a random access point
+	hevcHeldPackets       [][]byte
+	hevcHeldES            []byte
+	isReplayingHEVCStart  bool
+	hevcStartRandomAccess bool
+
+	// Drops the null packets instead of writing them in the chunks
+	stripNullPackets bool
 }
 
 // New Creates a chunklistgenerator instance
@@ -176,6 +936,23 @@ func New(
 			lhlsAdvancedChunks,
 			httpUploader,
 			s3Uploader,
+			nil,
+			0,
+			0,
+			false,
+			nil,
+			false,
+			false,
+			nil,
+			nil,
+			false,
+			0,
+			0,
+			nil,
+			nil,
+			hls.SecondaryOutputs{},
+			false,
+			"",
 		},
 		false,
 		0,
@@ -196,7 +973,7 @@ func New(
 			HlsDefaultVersion,
 			true,
 			targetSegmentDurS,
-			liveWindowSize+lhlsAdvancedChunks,
+			liveWindowSize,
 			chunklistFileName,
 			"",
 			manifestOutputType,
@@ -204,63 +981,2557 @@ func New(
 			s3Uploader,
 		),
 		false,
-	}
-
-	return mg
-}
-
-func (mg *ManifestGenerator) resync(buf []byte) []byte {
-	mg.isInSync = false
-
-	start := 0
-	for {
-		if start < len(buf) {
-			if buf[start] == 0x47 {
-				mg.isInSync = true
-				break
-			} else {
-				start++
-			}
-		} else {
-			break
-		}
-	}
-
-	return buf[start:]
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-
-	return b
-}
-
-func (mg *ManifestGenerator) isSavingMediaPacket() bool {
-	ret := false
-	if !mg.options.autoPIDs {
-		// Manual detection PIDs
-		ret = true
-	} else {
-		if mg.options.chunkInitType == ChunkInit || mg.options.chunkInitType == ChunkInitStart {
-			if mg.initState == InitsavedPMT {
-				ret = true
-			}
-		} else if mg.options.chunkInitType == ChunkNoIni {
-			ret = true
-		}
-	}
-
-	return ret
-}
-
-func (mg *ManifestGenerator) saveInitPacket(tableType packetTableTypes) bool {
+		false,
+		1,
+		nil,
+		false,
+		0,
+		0,
+		tspacket.TsDefaultPacketSize,
+		0,
+		nil,
+		&Stats{0, 0, 0, 0, 0, int64(videoPID), int64(audioPID), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		false,
+		!autoPIDs && videoPID < 0 && audioPID >= 0,
+		false,
+		VideoCodecH264,
+		AudioCodecAAC,
+		AudioCodecAAC,
+		-1,
+		-1,
+		nil,
+		nil,
+		-1,
+		-1,
+		scte35.SectionAssembler{},
+		nil,
+		map[uint32]splice{},
+		false,
+		-1.0,
+		-1.0,
+		-1.0,
+		-1.0,
+		-1.0,
+		MaxTimestampJumpSDefault,
+		-1.0,
+		-1,
+		tspacket.New(tspacket.TsDefaultPacketSize),
+		0,
+		map[int]int{},
+		map[int]bool{},
+		0,
+		MaxSegmentDurCut,
+		false,
+		0,
+		0,
+		false,
+		map[int]bool{},
+		-1,
+		0,
+		0,
+		-1,
+		-1,
+		-1,
+		map[int]bool{},
+		false,
+		map[int][][]byte{},
+		map[int]int{},
+		DurationSourceAuto,
+		MaxDurationDriftSDefault,
+		-1.0,
+		-1.0,
+		false,
+		tspacket.New(tspacket.TsDefaultPacketSize),
+		0,
+		false,
+		tspacket.New(tspacket.TsDefaultPacketSize),
+		tspacket.New(tspacket.TsDefaultPacketSize),
+		-1.0,
+		0,
+		0,
+		0,
+		time.Time{},
+		0,
+		false,
+		time.Time{},
+		PDTSourceClock,
+		scte35.SectionAssembler{},
+		time.Time{},
+		-1.0,
+		false,
+		nil,
+		-1,
+		0,
+		[]float64{},
+		[]float64{},
+		0,
+		0,
+		0,
+		true,
+		false,
+		true,
+		0,
+		nil,
+		0,
+		-1.0,
+		false,
+		false,
+		0,
+		0,
+		hls.DefaultExtinfPrecision,
+		false,
+		hls.Hls{},
+		"",
+		"",
+		nil,
+		-1.0,
+		false,
+		0,
+		false,
+		[]float64{},
+		[]float64{},
+		nil,
+		hls.Hls{},
+		false,
+		nil,
+		nil,
+		nil,
+		nil,
+		map[uint64]encryptedChunk{},
+		nil,
+		&uploadFailures{},
+		false,
+		0,
+		EndlistNone,
+		0,
+		&pendingEndlist{},
+		"",
+		0,
+		0,
+		"",
+		map[uint64]string{},
+		"",
+		nil,
+		"",
+		nil,
+		0,
+		time.Time{},
+		&mediachunk.WriteQueueStats{},
+		nil,
+		false,
+		nil,
+		[][]byte{},
+		[]byte{},
+		false,
+		false,
+		false,
+	}
+
+	if chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		mg.hlsChunklist.SetHlsVersion(HlsByteRangeVersion)
+	}
+
+	return mg
+}
+
+// ValidateManualPIDs Checks the video and audio PIDs used in manual PID mode, one of them can be absent (< 0) for single essence streams
+func ValidateManualPIDs(videoPID int, audioPID int) error {
+	if videoPID < 0 && audioPID < 0 {
+		return errors.New("manual PID mode needs at least a video or audio PID")
+	}
+	if videoPID >= 0 && videoPID == audioPID {
+		return fmt.Errorf("video and audio PIDs can not be the same (%d)", videoPID)
+	}
+
+	for _, pid := range []int{videoPID, audioPID} {
+		if pid < 0 {
+			continue
+		}
+		if pid < tspacket.MinESPID || pid >= tspacket.NullPID {
+			return fmt.Errorf("invalid PID %d (0x%04X), valid range is %d - %d (0x%04X - 0x%04X)", pid, pid, tspacket.MinESPID, tspacket.NullPID-1, tspacket.MinESPID, tspacket.NullPID-1)
+		}
+	}
+
+	return nil
+}
+
+// ValidateHlsVersion Checks that the pinned chunklist version (<= 0 not pinned) supports the features: decimal EXTINF, byte ranges and init chunks (EXT-X-MAP)
+func ValidateHlsVersion(version int, extinfPrecision int, chunkOutputType mediachunk.OutputTypes, chunkInitType ChunkInitTypes) error {
+	if extinfPrecision < 0 {
+		return fmt.Errorf("invalid EXTINF precision %d, it can not be negative", extinfPrecision)
+	}
+	if version <= 0 {
+		return nil
+	}
+
+	required := hls.RequiredVersion(extinfPrecision, chunkOutputType == mediachunk.ChunkOutputModeFileByteRange, chunkInitType == ChunkInit, false)
+	if version < required {
+		return fmt.Errorf("version %d does not support the features in use (decimal EXTINF, byte ranges or init chunks), min version %d", version, required)
+	}
+
+	return nil
+}
+
+// ValidateChunkFormat Checks that the features in use can be used with the chunks format, CMAF has its own init segment and it does not support LHLS, parts and I-frames chunklists
+func ValidateChunkFormat(chunkFormat ChunkFormats, chunkInitType ChunkInitTypes, lhlsAdvancedChunks int, partDurationS float64, isIFrames bool) error {
+	if chunkFormat != ChunkFormatTS && chunkFormat != ChunkFormatCMAF {
+		return fmt.Errorf("invalid chunk format %d", chunkFormat)
+	}
+	if chunkFormat == ChunkFormatTS {
+		return nil
+	}
+
+	if chunkInitType == ChunkInit {
+		return errors.New("CMAF chunks have their own init segment, the TS init segment (initType 1) can not be used")
+	}
+	if lhlsAdvancedChunks > 0 {
+		return errors.New("CMAF chunks can not be used with LHLS")
+	}
+	if partDurationS > 0 {
+		return errors.New("CMAF chunks can not be used with LL-HLS parts")
+	}
+	if isIFrames {
+		return errors.New("CMAF chunks can not be used with the I-frames chunklist")
+	}
+
+	return nil
+}
+
+// ValidateEncryption Checks that the chunks can be encrypted, they can not be used with CMAF, parts and the I-frames chunklist. In AES-128 the whole chunks are encrypted so it can not be used with byte ranges and TS init chunks either
+func ValidateEncryption(method encryption.Methods, chunkOutputType mediachunk.OutputTypes, chunkInitType ChunkInitTypes, chunkFormat ChunkFormats, partDurationS float64, isIFrames bool) error {
+	if method != encryption.MethodNone && method != encryption.MethodAES128 && method != encryption.MethodSampleAES {
+		return fmt.Errorf("invalid encryption method %d", method)
+	}
+	if method == encryption.MethodNone {
+		return nil
+	}
+
+	if chunkFormat == ChunkFormatCMAF {
+		return errors.New("encrypted chunks can not be used with CMAF")
+	}
+	if method == encryption.MethodAES128 && chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("AES-128 encrypted chunks can not be written as byte ranges")
+	}
+	if method == encryption.MethodAES128 && chunkInitType == ChunkInit {
+		return errors.New("AES-128 encrypted chunks can not be used with the init chunk (initType 1)")
+	}
+	if partDurationS > 0 {
+		return errors.New("encrypted chunks can not be used with LL-HLS parts")
+	}
+	if isIFrames {
+		return errors.New("encrypted chunks can not be used with the I-frames chunklist")
+	}
+
+	return nil
+}
+
+// ValidateServerControl Checks the EXT-X-SERVER-CONTROL hold backs and CAN-SKIP-UNTIL (<= 0 computed) against the min of the target and part durations (partDurationS <= 0 no parts)
+func ValidateServerControl(holdBackS float64, partHoldBackS float64, canSkipUntilS float64, targetDurS float64, partDurationS float64) error {
+	if minS := hls.CanSkipUntilMinTargetDurations * math.Round(targetDurS); canSkipUntilS > 0 && canSkipUntilS < minS {
+		return fmt.Errorf("CAN-SKIP-UNTIL %.3fs is lower than %d target durations (%.3fs)", canSkipUntilS, hls.CanSkipUntilMinTargetDurations, minS)
+	}
+	if minS := hls.HoldBackMinTargetDurations * math.Round(targetDurS); holdBackS > 0 && holdBackS < minS {
+		return fmt.Errorf("HOLD-BACK %.3fs is lower than %d target durations (%.3fs)", holdBackS, hls.HoldBackMinTargetDurations, minS)
+	}
+	if partHoldBackS > 0 {
+		if partDurationS <= 0 {
+			return fmt.Errorf("PART-HOLD-BACK is only used with LL-HLS parts")
+		}
+		if minS := hls.PartHoldBackMinPartTargets * partDurationS; partHoldBackS < minS {
+			return fmt.Errorf("PART-HOLD-BACK %.3fs is lower than %d part durations (%.3fs)", partHoldBackS, hls.PartHoldBackMinPartTargets, minS)
+		}
+	}
+
+	return nil
+}
+
+// ValidateRemapPIDs Checks the PIDs used to remap the video, audio and PMT (< 0 not remapped), they can not be the same or one of the extra PIDs written in the chunks
+func ValidateRemapPIDs(videoPID int, audioPID int, pmtPID int, keepPIDs []int) error {
+	usedPIDs := map[int]bool{}
+	for _, pid := range keepPIDs {
+		usedPIDs[pid] = true
+	}
+
+	for _, pid := range []int{videoPID, audioPID, pmtPID} {
+		if pid < 0 {
+			continue
+		}
+		if pid < tspacket.MinESPID || pid >= tspacket.NullPID {
+			return fmt.Errorf("invalid PID %d (0x%04X), valid range is %d - %d (0x%04X - 0x%04X)", pid, pid, tspacket.MinESPID, tspacket.NullPID-1, tspacket.MinESPID, tspacket.NullPID-1)
+		}
+		if usedPIDs[pid] {
+			return fmt.Errorf("PID %d (0x%04X) used more than once", pid, pid)
+		}
+		usedPIDs[pid] = true
+	}
+
+	return nil
+}
+
+// resync Looks for a position where there are resyncPackets consecutive sync bytes (at 188 or 204 bytes intervals), discarding the data before it. If more data is needed to confirm the sync it is kept for the next call
+func (mg *ManifestGenerator) resync(buf []byte) []byte {
+	mg.isInSync = false
+
+	data := buf
+	if len(mg.unsyncedBuf) > 0 {
+		data = append(mg.unsyncedBuf, buf...)
+	}
+	mg.unsyncedBuf = mg.unsyncedBuf[:0]
+
+	start := 0
+	packetSize := 0
+	for start < len(data) {
+		if data[start] == 0x47 {
+			var needMoreData bool
+			packetSize, needMoreData = mg.detectPacketSizeAt(data, start)
+			if packetSize > 0 {
+				mg.isInSync = true
+				break
+			}
+			if needMoreData {
+				// Keep it until the next data arrives
+				mg.skippedBytes = mg.skippedBytes + uint64(start)
+				mg.unsyncedBuf = append(mg.unsyncedBuf, data[start:]...)
+				return nil
+			}
+		}
+		start++
+	}
+
+	mg.skippedBytes = mg.skippedBytes + uint64(start)
+	if !mg.isInSync {
+		return nil
+	}
+	// The returned data can share memory with unsyncedBuf
+	mg.unsyncedBuf = nil
+
+	if packetSize != mg.inputPacketSize {
+		mg.options.log.Info("Detected TS packet size: ", packetSize, " bytes")
+		mg.inputPacketSize = packetSize
+	}
+
+	if mg.hasBeenInSync {
+		mg.resyncs++
+		mg.options.log.Warn("TS resync (", mg.resyncs, "), skipped ", mg.skippedBytes, " bytes")
+	} else if mg.skippedBytes > 0 {
+		mg.options.log.Info("TS sync found, skipped ", mg.skippedBytes, " bytes")
+	}
+	mg.hasBeenInSync = true
+	mg.skippedBytes = 0
+
+	return data[start:]
+}
+
+// detectPacketSizeAt Returns the packet size if there are resyncPackets consecutive sync bytes from start (188 bytes packets checked first), 0 if not
+func (mg *ManifestGenerator) detectPacketSizeAt(data []byte, start int) (packetSize int, needMoreData bool) {
+	packetSizes := []int{tspacket.TsDefaultPacketSize, tspacket.TsRSPacketSize}
+	if mg.forcedPacketSize > 0 {
+		packetSizes = []int{mg.forcedPacketSize}
+	}
+
+	for _, packetSize := range packetSizes {
+		synced, needMoreData := mg.isSyncedAt(data, start, packetSize)
+		if synced {
+			return packetSize, false
+		}
+		if needMoreData {
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// isSyncedAt Checks if there are resyncPackets consecutive sync bytes from start at packetSize intervals
+func (mg *ManifestGenerator) isSyncedAt(data []byte, start int, packetSize int) (synced bool, needMoreData bool) {
+	for n := 1; n < mg.resyncPackets; n++ {
+		pos := start + n*packetSize
+		if pos >= len(data) {
+			return false, true
+		}
+		if data[pos] != 0x47 {
+			return false, false
+		}
+	}
+
+	return true, false
+}
+
+// lostSync Rescans the data of the invalid packet (except the 1st byte) looking for the sync
+func (mg *ManifestGenerator) lostSync() {
+	mg.options.log.Warn("Lost TS sync, resyncing")
+
+	mg.isInSync = false
+	mg.skippedBytes = mg.skippedBytes + 1
+	mg.unsyncedBuf = append(mg.unsyncedBuf[:0], mg.tsPacket.GetBuffer()[1:]...)
+	mg.unsyncedBuf = append(mg.unsyncedBuf, mg.rsBytes...)
+	mg.tsPacket.Reset()
+	mg.rsBytes = mg.rsBytes[:0]
+	mg.resetContinuity()
+}
+
+// SetResyncPackets Sets the number of consecutive sync bytes (at 188 bytes intervals) needed to consider the stream in sync (1 by default)
+func (mg *ManifestGenerator) SetResyncPackets(resyncPackets int) {
+	if resyncPackets < 1 {
+		resyncPackets = 1
+	}
+	mg.resyncPackets = resyncPackets
+}
+
+// SetInputPacketSize Forces the input TS packet size (188 or 204 with Reed-Solomon bytes), 0 autodetects it (204 detection needs resyncPackets >= 2)
+func (mg *ManifestGenerator) SetInputPacketSize(packetSize int) {
+	mg.forcedPacketSize = packetSize
+	if packetSize > 0 {
+		mg.inputPacketSize = packetSize
+	}
+}
+
+// SetAudioOnly Forces to chunk based on the audio PTS (if not it is only enabled when there is no video)
+func (mg *ManifestGenerator) SetAudioOnly(forced bool) {
+	mg.forcedAudioOnly = forced
+	mg.setAudioOnly(forced || (mg.options.videoPID < 0 && mg.options.audioPID >= 0))
+}
+
+// setAudioOnly Enables / disables chunking based on the audio PTS
+func (mg *ManifestGenerator) setAudioOnly(isAudioOnly bool) {
+	if isAudioOnly != mg.isAudioOnly {
+		mg.options.log.Info("Audio only mode (chunking based on audio PTS): ", isAudioOnly)
+	}
+	mg.isAudioOnly = isAudioOnly
+}
+
+// setVideoOnly Enables / disables chunking based on the video PTS
+func (mg *ManifestGenerator) setVideoOnly(isVideoOnly bool) {
+	if isVideoOnly && !mg.isVideoOnly {
+		mg.options.log.Warn("No audio found in the PMT, segmenting video only (chunking based on video PTS)")
+	}
+	mg.isVideoOnly = isVideoOnly
+}
+
+// IsVideoOnly Returns true if the auto detected PMT has video but no audio
+func (mg *ManifestGenerator) IsVideoOnly() bool {
+	return mg.isVideoOnly
+}
+
+// SetProgramSelection Selects the program to segment in MPTS by program number and / or PMT PID (< 0 any, so the 1st program of the PAT)
+func (mg *ManifestGenerator) SetProgramSelection(programNumber int, pmtPID int) {
+	mg.programNumber = programNumber
+	mg.programPMTPID = pmtPID
+}
+
+// selectProgram Returns the PMT PID of the selected program from the current PAT (packets of other programs are ignored), -1 if it is not present
+func (mg *ManifestGenerator) selectProgram(firstPMTID int) int {
+	if firstPMTID < 0 || (mg.programNumber < 0 && mg.programPMTPID < 0) {
+		return firstPMTID
+	}
+
+	programs := mg.tsPacket.GetPATPrograms()
+	patChanged := !equalPrograms(programs, mg.patPrograms)
+	if patChanged {
+		mg.options.log.Info("PAT programs (number: PMT PID): ", programsString(programs))
+		mg.patPrograms = append(mg.patPrograms[:0], programs...)
+	}
+
+	for _, program := range programs {
+		if (mg.programNumber < 0 || program.ProgramNumber == mg.programNumber) && (mg.programPMTPID < 0 || program.PMTPID == mg.programPMTPID) {
+			if mg.detectedPMTID >= 0 && mg.detectedPMTID != program.PMTPID {
+				mg.options.log.Warn("PMT PID of the selected program changed from ", mg.detectedPMTID, " to ", program.PMTPID)
+			}
+			return program.PMTPID
+		}
+	}
+
+	if mg.detectedPMTID < 0 {
+		// Never found
+		if mg.err == nil {
+			mg.err = fmt.Errorf("selected program (number: %d, PMT PID: %d) not found in the PAT, available programs (number: PMT PID): %s", mg.programNumber, mg.programPMTPID, programsString(programs))
+		}
+	} else if patChanged {
+		mg.options.log.Warn("Selected program removed from the PAT, keeping the current PIDs until it is back")
+	}
+
+	return -1
+}
+
+func equalPrograms(a []tspacket.PATProgram, b []tspacket.PATProgram) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func programsString(programs []tspacket.PATProgram) string {
+	ret := ""
+	for i, program := range programs {
+		if i > 0 {
+			ret = ret + ", "
+		}
+		ret = ret + fmt.Sprintf("%d: %d", program.ProgramNumber, program.PMTPID)
+	}
+
+	return ret
+}
+
+// SetMaxTimestampJumpS Sets the PTS jump (in seconds) between consecutive PES considered a discontinuity when the discontinuity_indicator is not set (<= 0 disabled)
+func (mg *ManifestGenerator) SetMaxTimestampJumpS(maxTimestampJumpS float64) {
+	mg.maxTimestampJumpS = maxTimestampJumpS
+}
+
+// SetDurationSource Sets the clock used to measure the chunk durations, and the difference (in seconds) with the PCR PID elapsed time that makes the auto source use the PCR
+func (mg *ManifestGenerator) SetDurationSource(source DurationSources, maxDurationDriftS float64) {
+	mg.durationSource = source
+	mg.maxDurationDriftS = maxDurationDriftS
+}
+
+// SetMaxSegmentDurS Sets the max chunk duration (in seconds, <= 0 disabled), if it is reached without a random access point the chunk is cut anyway (or the data is dropped until the next one)
+func (mg *ManifestGenerator) SetMaxSegmentDurS(maxSegmentDurS float64, action MaxSegmentDurActions) {
+	mg.maxSegmentDurS = maxSegmentDurS
+	mg.maxSegmentDurAction = action
+}
+
+// SetHlsVersion Pins the version of the chunklists (<= 0 the min needed by the features in use), see ValidateHlsVersion
+func (mg *ManifestGenerator) SetHlsVersion(version int) {
+	mg.hlsVersion = version
+	mg.hlsChunklist.PinVersion(version)
+	if mg.captions != nil {
+		mg.captionsChunklist.PinVersion(version)
+	}
+}
+
+// SetExtinfPrecision Sets the decimal places of the EXTINF durations in the chunklists (0 rounded integers, by default hls.DefaultExtinfPrecision)
+func (mg *ManifestGenerator) SetExtinfPrecision(extinfPrecision int) {
+	mg.extinfPrecision = extinfPrecision
+	mg.hlsChunklist.SetExtinfPrecision(extinfPrecision)
+	if mg.captions != nil {
+		mg.captionsChunklist.SetExtinfPrecision(extinfPrecision)
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetExtinfPrecision(extinfPrecision)
+	}
+}
+
+// SetPartDuration Sets the LL-HLS part duration (in seconds of the PCR PID, <= 0 disabled), every chunk is also written as parts (EXT-X-PART) of this duration, the last one has the rest of the chunk
+func (mg *ManifestGenerator) SetPartDuration(partDurationS float64) {
+	mg.partDurationS = partDurationS
+	mg.hlsChunklist.SetPartTarget(partDurationS)
+}
+
+// SetChunkFormat Sets the container of the media chunks, in CMAF only the video and audio are written (the other PIDs are dropped)
+func (mg *ManifestGenerator) SetChunkFormat(chunkFormat ChunkFormats) {
+	mg.fmp4Muxer = nil
+	if chunkFormat == ChunkFormatCMAF {
+		m := fmp4.New()
+		mg.fmp4Muxer = &m
+	}
+}
+
+// chunkFileExtension Returns the extension of the media or init chunks of the chunks format
+func (mg *ManifestGenerator) chunkFileExtension(isInit bool) string {
+	if mg.fmp4Muxer != nil && isInit {
+		return CMAFInitFileExtension
+	} else if mg.fmp4Muxer != nil {
+		return CMAFChunkFileExtension
+	}
+	return ChunkFileExtensionDefault
+}
+
+// isCurrentChunkEmpty Returns true if nothing was added to the current chunk (in CMAF the data is written when it is closed)
+func (mg *ManifestGenerator) isCurrentChunkEmpty() bool {
+	if len(mg.currentChunks) <= 0 {
+		return true
+	}
+	if mg.fmp4Muxer != nil {
+		return mg.chunkOutputBytes == 0
+	}
+	return mg.currentChunks[0].IsEmpty()
+}
+
+// SetDash Enables the MPEG-DASH manifest (MPD) of the chunks in mpdFilename (empty disabled), it has to be set after the chunk format and the built-in HTTP server
+func (mg *ManifestGenerator) SetDash(mpdFilename string) {
+	if mpdFilename == "" {
+		mg.dash = nil
+		return
+	}
+
+	mimeType := hls.DashMimeTypeTS
+	if mg.fmp4Muxer != nil {
+		mimeType = hls.DashMimeTypeMP4
+	}
+	mediaTemplate := mg.options.chunkBaseFilename + "$Number%0" + strconv.Itoa(mg.options.fileNumberLength) + "d$" + mg.chunkFileExtension(false)
+	if mg.options.chunkFilenameTemplate != nil {
+		// Validated when the template is set
+		dashTemplate, _ := mg.options.chunkFilenameTemplate.DashTemplate(mg.options.fileNumberLength)
+		mediaTemplate = dashTemplate + mg.chunkFileExtension(false)
+	}
+
+	d := hls.NewDash(
+		mg.options.log,
+		mg.options.manifestType,
+		mg.options.targetSegmentDurS,
+		mg.options.liveWindowSize,
+		path.Join(mg.options.baseOutPath, mpdFilename),
+		path.Join(mg.options.baseOutPath, mediaTemplate),
+		mimeType,
+		mg.options.manifestOutputType,
+		mg.options.httpUploader,
+		mg.options.s3Uploader,
+	)
+	d.SetHTTPServer(mg.options.httpServer)
+	d.SetFsync(mg.options.isManifestFsync)
+	d.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	d.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	mg.dash = &d
+}
+
+// addDashSegment Adds the closed chunk to the MPD, the final one ends the presentation
+func (mg *ManifestGenerator) addDashSegment(index uint64, chunkDurationS float64, isFinalChunk bool) {
+	err := mg.dash.AddSegment(hls.DashSegment{Number: index, DurationS: chunkDurationS, Bytes: mg.chunkOutputBytes, StartPTSS: mg.chunkStartPTSS}, !isFinalChunk)
+	if err == nil && isFinalChunk {
+		err = mg.dash.Close()
+	}
+	if err != nil {
+		mg.options.log.Error("Error generating / saving the MPD. Err: ", err)
+	}
+}
+
+// SetChunkSharding Saves the media chunks (with their parts and captions) and the I-frames in subdirectories of the wall clock (UTC) when they are created, in the Go layout (Ex: 2006/01/02/15, empty disabled). The directories are created when they are needed and a chunk is never split between two of them. The subdirectories are in the file, upload and object paths and in the chunklist URIs. It has to be set after DASH (not compatible, as the byte range chunks)
+func (mg *ManifestGenerator) SetChunkSharding(layout string) error {
+	if layout == "" {
+		mg.chunkShardLayout = ""
+		return nil
+	}
+
+	sample := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Format(layout)
+	if path.IsAbs(sample) || path.Clean(sample) != sample || sample == "." || strings.HasPrefix(sample, "..") {
+		return fmt.Errorf("invalid sharding layout %s, it has to be a relative path (Ex: 2006/01/02/15)", layout)
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("sharding with byte range chunks (a single file)")
+	}
+	if mg.dash != nil {
+		return errors.New("sharding with DASH, the segments template can not have the subdirectories")
+	}
+
+	mg.chunkShardLayout = layout
+	return nil
+}
+
+// chunkBasePath Returns the directory of the media files of the chunk index, with sharding the one of the wall clock when it is first needed (Ex: the preload hint of its 1st part before the chunk is created)
+func (mg *ManifestGenerator) chunkBasePath(index uint64) string {
+	if mg.chunkShardLayout == "" {
+		return mg.options.baseOutPath
+	}
+	if basePath, found := mg.chunkShards[index]; found {
+		return basePath
+	}
+
+	basePath := mg.currentShardPath()
+	mg.chunkShards[index] = basePath
+	return basePath
+}
+
+// currentShardPath Returns the directory of the media files created now, it is created (file output) when it changes
+func (mg *ManifestGenerator) currentShardPath() string {
+	if mg.chunkShardLayout == "" {
+		return mg.options.baseOutPath
+	}
+
+	basePath := path.Join(mg.options.baseOutPath, time.Now().UTC().Format(mg.chunkShardLayout))
+	if basePath != mg.lastShardPath {
+		if mg.wholeFileOutputType() == mediachunk.ChunkOutputModeFile {
+			if err := os.MkdirAll(basePath, 0744); err != nil {
+				mg.options.log.Error("Error creating the chunks directory ", basePath, ". Err: ", err)
+			}
+		}
+		mg.options.log.Info("Chunks directory: ", basePath)
+		mg.lastShardPath = basePath
+	}
+	return basePath
+}
+
+// SetEpochNumbering Starts the chunk index (filenames) and the media sequence from the wall clock, the ms since the epoch over the target duration (over 1s the I-frames ones), so the names are unique and increasing across restarts without persisted state. It assumes the chunks are not shorter than the target duration on average (the I-frames than 1s). It has to be set before the 1st chunk
+func (mg *ManifestGenerator) SetEpochNumbering(isEnabled bool) {
+	if mg.currentChunkIndex != mg.firstChunkIndex || len(mg.currentChunks) > 0 || mg.iFrameIndex != mg.firstIFrameIndex {
+		mg.options.log.Error("Epoch numbering set after the 1st chunk, ignored")
+		return
+	}
+
+	mg.firstChunkIndex, mg.firstIFrameIndex = 0, 0
+	if isEnabled {
+		now := time.Now()
+		mg.firstChunkIndex = epochIndex(now, mg.options.targetSegmentDurS)
+		mg.firstIFrameIndex = epochIndex(now, 1)
+		mg.options.log.Info("Epoch numbering, 1st chunk index: ", mg.firstChunkIndex, ", 1st I-frame index: ", mg.firstIFrameIndex)
+	}
+	mg.currentChunkIndex = mg.firstChunkIndex
+	mg.iFrameIndex = mg.firstIFrameIndex
+
+	mg.hlsChunklist.SetMediaSequence(int64(mg.firstChunkIndex))
+	if mg.captions != nil {
+		mg.captionsChunklist.SetMediaSequence(int64(mg.firstChunkIndex))
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetMediaSequence(int64(mg.firstIFrameIndex))
+	}
+}
+
+// SetCallbacks Sets the receivers of the callback outputs, it has to be set before the other options. The master playlist gets its receiver with hls.Master.SetOnManifestUpdated
+func (mg *ManifestGenerator) SetCallbacks(callbacks Callbacks) {
+	mg.options.chunkCallbacks = &mediachunk.Callbacks{OnChunkData: callbacks.OnChunkData, OnChunkClosed: callbacks.OnChunkClosed}
+	mg.options.onManifestUpdated = callbacks.OnManifestUpdated
+
+	mg.hlsChunklist.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	if mg.captions != nil {
+		mg.captionsChunklist.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	}
+	if mg.dash != nil {
+		mg.dash.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	}
+}
+
+// SetChunkMetadata Saves a JSON metadata sidecar (see chunkmeta.Chunk) of every media chunk (same name, .json) to the same destination, and the index of the chunks in the live window (all of them in VOD / event) to indexFileName (empty none) in the chunks destination. Not compatible with the byte range output
+func (mg *ManifestGenerator) SetChunkMetadata(isEnabled bool, indexFileName string) error {
+	mg.chunkMetadata = nil
+	if !isEnabled {
+		return nil
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("chunk metadata files are not compatible with the byte range output (a single file)")
+	}
+
+	windowSize := 0
+	if mg.options.manifestType == hls.LiveWindow {
+		windowSize = mg.options.liveWindowSize
+	}
+	if indexFileName != "" {
+		indexFileName = path.Join(mg.options.baseOutPath, indexFileName)
+	}
+	mg.chunkMetadata = &chunkMetadata{indexFileName, windowSize, []chunkmeta.Chunk{}}
+	return nil
+}
+
+// saveChunkMetadata Saves the metadata of the closed chunk and the index, the discontinuity, PDT and splices are the ones of the chunklist
+func (mg *ManifestGenerator) saveChunkMetadata(index uint64, fileName string, durationS float64, bytes uint64) {
+	writtenAt := mg.chunkWrittenAt
+	if writtenAt.IsZero() {
+		// CMAF, the fragments are written when they are completed
+		writtenAt = time.Now().Add(-time.Duration(durationS * float64(time.Second)))
+	}
+	meta := chunkmeta.Chunk{
+		Version:        chunkmeta.Version,
+		SequenceNumber: index,
+		URI:            mg.hlsChunklist.MediaURI(fileName),
+		StartPTSS:      mg.chunkStartPTSS,
+		WallClockStart: writtenAt,
+		DurationS:      durationS,
+		Bytes:          bytes,
+		Keyframes:      mg.chunkKeyframes,
+	}
+	if hlsChunk, found := mg.hlsChunklist.GetChunk(fileName); found {
+		meta.Discontinuity = hlsChunk.IsDisco
+		if !hlsChunk.ProgramDateTime.IsZero() {
+			meta.ProgramDateTime = &hlsChunk.ProgramDateTime
+		}
+		for _, d := range hlsChunk.DateRanges {
+			meta.SCTE35 = append(meta.SCTE35, scte35Metadata(d))
+		}
+	}
+
+	data, _ := json.Marshal(meta)
+	mg.saveMetadataFile(chunkmeta.FileName(fileName), data)
+
+	m := mg.chunkMetadata
+	m.chunks = append(m.chunks, meta)
+	if m.windowSize > 0 && len(m.chunks) > m.windowSize {
+		m.chunks = append([]chunkmeta.Chunk{}, m.chunks[len(m.chunks)-m.windowSize:]...)
+	}
+	if m.indexFileName != "" {
+		data, _ := json.Marshal(chunkmeta.Index{Version: chunkmeta.Version, UpdatedAt: time.Now(), Chunks: m.chunks})
+		mg.saveMetadataFile(m.indexFileName, data)
+	}
+}
+
+// scte35Metadata Returns the metadata of the splice of the EXT-X-DATERANGE
+func scte35Metadata(d hls.DateRange) chunkmeta.SCTE35 {
+	s := chunkmeta.SCTE35{ID: d.ID, StartDate: d.StartDate, Cmd: d.SCTE35Cmd, Out: d.SCTE35Out, In: d.SCTE35In}
+	if d.DurationS >= 0 {
+		durationS := d.DurationS
+		s.DurationS = &durationS
+	}
+	if d.PlannedDurationS >= 0 {
+		plannedDurationS := d.PlannedDurationS
+		s.PlannedDurationS = &plannedDurationS
+	}
+	return s
+}
+
+// saveMetadataFile Saves the metadata file to the destination of the chunks (the local files are replaced atomically), the errors are logged
+func (mg *ManifestGenerator) saveMetadataFile(fileName string, data []byte) {
+	err := error(nil)
+	outputTypes := append([]mediachunk.OutputTypes{mg.wholeFileOutputType()}, mg.wholeFileSecondaryOutputTypes()...)
+	if outputTypes[0] == mediachunk.ChunkOutputModeFile {
+		err = atomicfile.WriteFile(fileName, data, 0644, false)
+		outputTypes = outputTypes[1:]
+	}
+	if len(outputTypes) > 0 {
+		metaOptions := mediachunk.Options{
+			Log:                  mg.options.log,
+			OutputType:           outputTypes[0],
+			LHLS:                 false,
+			EstimatedDurationS:   -1,
+			Filename:             fileName,
+			HTTPUploader:         mg.options.httpUploader,
+			S3Uploader:           mg.options.s3Uploader,
+			HTTPServer:           mg.options.httpServer,
+			Callbacks:            mg.options.chunkCallbacks,
+			SecondaryOutputTypes: outputTypes[1:],
+			OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+			UploadType:           httpuploader.UploadTypeMetadata,
+		}
+		metaChunk := mediachunk.New(0, metaOptions)
+		metaErr := metaChunk.InitializeChunk()
+		if metaErr == nil {
+			metaErr = metaChunk.AddData(data)
+		}
+		metaChunk.Close(-1)
+		if err == nil {
+			err = metaErr
+		}
+	}
+	if err != nil {
+		mg.options.log.Error("Error saving the chunk metadata file ", fileName, ". Err: ", err)
+	}
+}
+
+// SetStateFile Saves the state (chunk indexes, media and discontinuity sequences and chunks of the chunklist) to stateFileName (atomically) every time a chunk is closed, empty disabled. With isResume the state saved by a previous run is loaded: the numbering continues from it, the chunklist keeps its chunks and the 1st new chunk is a discontinuity, and the saved keys continue the key numbering (their files are never overwritten). A missing or corrupt state file starts from scratch (warning). It has to be set after the numbering, captions, I-frames playlist and encryption, before the 1st chunk
+func (mg *ManifestGenerator) SetStateFile(stateFileName string, isResume bool) error {
+	mg.stateFileName = stateFileName
+	if stateFileName == "" {
+		return nil
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("state file is not compatible with the byte range output")
+	}
+	if !isResume {
+		return nil
+	}
+	if len(mg.currentChunks) > 0 || mg.currentChunkIndex != mg.firstChunkIndex {
+		return errors.New("state resumed after the 1st chunk")
+	}
+
+	state, err := loadState(stateFileName)
+	if err != nil {
+		mg.options.log.Warn("Error loading the state file ", stateFileName, ", starting from scratch. Err: ", err)
+		return nil
+	}
+	if err := mg.hlsChunklist.RestoreState(state.Chunklist); err != nil {
+		mg.options.log.Warn("Error restoring the chunklist of the state file ", stateFileName, ", starting from scratch. Err: ", err)
+		return nil
+	}
+
+	mg.firstChunkIndex, mg.currentChunkIndex = state.NextChunkIndex, state.NextChunkIndex
+	mg.firstIFrameIndex, mg.iFrameIndex = state.NextIFrameIndex, state.NextIFrameIndex
+	mg.initChunkIndex = state.NextInitChunkIndex
+	if mg.encryption != nil && mg.encryption.provider == nil {
+		mg.encryption.keyIndex = state.NextKeyIndex
+		if mg.encryption.keyURITemplate != "" && !strings.Contains(mg.encryption.keyURITemplate, KeyURINamePlaceholder) {
+			mg.options.log.Warn("The encryption key URI template ", mg.encryption.keyURITemplate, " has no ", KeyURINamePlaceholder, ", the new keys have the same URI as the ones of the resumed chunks")
+		}
+	}
+	if mg.captions != nil {
+		mg.captionsChunklist.SetMediaSequence(int64(state.NextChunkIndex))
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetMediaSequence(int64(state.NextIFrameIndex))
+	}
+
+	// The restart is a discontinuity (the captions and I-frames chunklists start empty)
+	mg.isNextChunkDisco = len(state.Chunklist.Chunks) > 0
+
+	mg.options.log.Info("Resumed the state saved at ", state.SavedAt, ", next chunk index: ", state.NextChunkIndex, ", media sequence: ", state.Chunklist.MediaSequence, ", chunks: ", len(state.Chunklist.Chunks))
+	return nil
+}
+
+// loadState Returns the state saved in the file
+func loadState(stateFileName string) (segmenterState, error) {
+	state := segmenterState{}
+	data, err := os.ReadFile(stateFileName)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+	if state.NextChunkIndex < uint64(len(state.Chunklist.Chunks)) {
+		return state, fmt.Errorf("invalid next chunk index %d with %d chunks", state.NextChunkIndex, len(state.Chunklist.Chunks))
+	}
+	return state, nil
+}
+
+// saveState Saves the current state to the state file (if it is enabled), the errors are logged
+func (mg *ManifestGenerator) saveState() {
+	if mg.stateFileName == "" {
+		return
+	}
+
+	// The LHLS advanced chunks are already announced, their indexes are not reused
+	state := segmenterState{mg.currentChunkIndex + uint64(len(mg.currentChunks)), mg.iFrameIndex, mg.initChunkIndex, mg.hlsChunklist.GetState(), time.Now(), 0}
+	if mg.encryption != nil && mg.encryption.provider == nil {
+		// The current key file may be saved already, its name is not reused
+		state.NextKeyIndex = mg.encryption.keyIndex + 1
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = atomicfile.WriteFile(mg.stateFileName, data, 0644, mg.options.isManifestFsync)
+	}
+	if err != nil {
+		mg.options.log.Error("Error saving the state file ", mg.stateFileName, ". Err: ", err)
+	}
+}
+
+// epochIndex Returns the number of periods since the epoch
+func epochIndex(now time.Time, periodS float64) uint64 {
+	if periodS <= 0 {
+		return 0
+	}
+	return uint64(float64(now.UnixNano()/int64(time.Millisecond)) / (periodS * 1000))
+}
+
+// SetChunkFilenameTemplate Sets the filename template of the media chunks (see mediachunk.FilenameTemplate, empty chunkBaseFilename and the index), the name is used for the file, the uploaded object and the chunklist URI. It has to be set after the chunk format, the LL-HLS parts and DASH. It returns an error if a token can not be resolved: the PDT, PTS and duration are only known when the chunk is closed, so they need an output that saves it then (file, HTTP or S3) without LHLS advanced chunks or parts, and DASH needs {seq} only
+func (mg *ManifestGenerator) SetChunkFilenameTemplate(template string) error {
+	if template == "" {
+		mg.options.chunkFilenameTemplate = nil
+		return nil
+	}
+
+	t, err := mediachunk.ParseFilenameTemplate(template)
+	if err != nil {
+		return err
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("filename template with byte range chunks (a single file)")
+	}
+	if t.IsResolvedAtClose() {
+		if mg.options.lhlsAdvancedChunks > 0 {
+			return fmt.Errorf("filename template %s with tokens only known when the chunk is closed, the LHLS advanced chunks are announced before they have data", template)
+		}
+		if mg.partDurationS > 0 {
+			return fmt.Errorf("filename template %s with tokens only known when the chunk is closed, the LL-HLS parts are announced before", template)
+		}
+		if mg.options.chunkOutputType == mediachunk.ChunkOutputModeHTTPChunkedTransfer || mg.options.chunkOutputType == mediachunk.ChunkOutputModeHTTPServer || mg.options.chunkOutputType == mediachunk.ChunkOutputModeCallback {
+			return fmt.Errorf("filename template %s with tokens only known when the chunk is closed, the chunks are streamed when they are created", template)
+		}
+	}
+	dashTemplate, err := t.DashTemplate(mg.options.fileNumberLength)
+	if mg.dash != nil {
+		if err != nil {
+			return err
+		}
+		mg.dash.SetMediaTemplate(path.Join(mg.options.baseOutPath, dashTemplate+mg.chunkFileExtension(false)))
+	}
+
+	mg.options.chunkFilenameTemplate = t
+	return nil
+}
+
+// SetChunkChecksum Computes the SHA-256 of the media chunks (the bytes published, after the encryption): it is sent in a header of the HTTP uploads and as metadata of the S3 ones (S3 also verifies the Content-MD5), and with isSidecar the file output saves it next to the chunk (.sha256). The chunked transfer and the built-in HTTP server send the chunks before they are completed, so they can not have it
+func (mg *ManifestGenerator) SetChunkChecksum(isEnabled bool, isSidecar bool) error {
+	mg.options.isChunkChecksum, mg.options.isChunkChecksumSidecar = false, false
+	if !isEnabled {
+		return nil
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeHTTPChunkedTransfer || mg.options.chunkOutputType == mediachunk.ChunkOutputModeHTTPServer {
+		return errors.New("chunk checksum with an output that sends the chunks while they are written")
+	}
+	if isSidecar && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile {
+		return errors.New("chunk checksum sidecar files are only for the file output")
+	}
+
+	mg.options.isChunkChecksum, mg.options.isChunkChecksumSidecar = true, isSidecar
+	return nil
+}
+
+// SetInProgressSignaling Sets how the media chunks being written are signaled (file output, also the LHLS chunks): isFlagFile with the flag file (GhostPrefixDefault) next to them (the default), extension (Ex: InProgressExtensionDefault, empty none) their files have it until they are completed (renamed then) and liveStateFileName (with its path, empty none) a JSON file (chunkmeta.LiveState) with the chunk being written and its bytes, saved on every write (SetChunkFlush limits them). The chunklist URIs are always the final names. The other media files (init, parts, I-frames, captions) keep their flag files
+func (mg *ManifestGenerator) SetInProgressSignaling(isFlagFile bool, extension string, liveStateFileName string) error {
+	if (!isFlagFile || extension != "" || liveStateFileName != "") && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile {
+		return errors.New("in progress signaling is only for the file output")
+	}
+	if extension != "" && mg.options.isNoFlagFiles {
+		return errors.New("in progress extension is not compatible with no flag files")
+	}
+	if liveStateFileName != "" && mg.options.writeQueueBytes > 0 {
+		return errors.New("live state is not compatible with the write queue, the bytes queued are not in the file yet")
+	}
+
+	mg.options.isNoChunkFlagFile = !isFlagFile
+	mg.options.chunkInProgressExtension = extension
+	mg.liveState = nil
+	if liveStateFileName != "" {
+		mg.liveState = &liveState{mg.options.log, liveStateFileName, extension}
+	}
+	return nil
+}
+
+// SetNoFlagFiles Writes the media files (file output) to hidden temporary files renamed to their names when they are completed, instead of the flag file (GhostPrefixDefault) next to them while they are written. The files announced before they are completed (LHLS advanced chunks and LL-HLS parts) are read while they grow, they keep the flag files
+func (mg *ManifestGenerator) SetNoFlagFiles(isEnabled bool) error {
+	if isEnabled && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile {
+		return errors.New("no flag files is only for the file output")
+	}
+
+	mg.options.isNoFlagFiles = isEnabled
+	return nil
+}
+
+// SetMediaFsync Fsyncs (file and byte range outputs) every everyNChunks media chunks (with their parts, captions and I-frames of the same number) when they are closed, before they are added to the chunklist, and their directory after they have their final name. The init and key files are always fsynced, 0 disabled. The manifests fsync is SetManifestFsync
+func (mg *ManifestGenerator) SetMediaFsync(everyNChunks int) error {
+	if everyNChunks < 0 {
+		return fmt.Errorf("invalid media fsync every %d chunks", everyNChunks)
+	}
+	if everyNChunks > 0 && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("media fsync is only for the file outputs")
+	}
+
+	mg.options.mediaFsyncEvery = everyNChunks
+	return nil
+}
+
+// isMediaFsync Returns true if the media file of the index has to be fsynced
+func (mg *ManifestGenerator) isMediaFsync(index uint64) bool {
+	return mg.options.mediaFsyncEvery > 0 && index%uint64(mg.options.mediaFsyncEvery) == 0
+}
+
+// SetWriteQueue Writes the media chunks and parts (file and byte range outputs) from a queue of maxBytes in other goroutine, so AddData only blocks on the disk when the queue is full. The chunks are completely written before the chunklist references them, the queue counters are in GetStats. 0 disabled
+func (mg *ManifestGenerator) SetWriteQueue(maxBytes int) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("invalid write queue size %d", maxBytes)
+	}
+	if maxBytes > 0 && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("write queue is only for the file outputs")
+	}
+
+	mg.options.writeQueueBytes = maxBytes
+	return nil
+}
+
+// SetSecondaryDestinations Writes the media files and saves the manifests also to these destinations (the primary ones are the outputs of New), every one is independent: its failures are logged and counted (Stats.SecondaryFailures), they are not retried and do not flag chunks as EXT-X-GAP (only the failed uploads of the primary destination). The byte range output can not have secondary destinations. Empty disabled
+func (mg *ManifestGenerator) SetSecondaryDestinations(chunkOutputTypes []mediachunk.OutputTypes, manifestOutputTypes []hls.OutputTypes) error {
+	if len(chunkOutputTypes) > 0 && mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("byte range output can not have secondary destinations")
+	}
+	for i, outputType := range chunkOutputTypes {
+		if outputType == mediachunk.ChunkOutputModeNone || outputType == mediachunk.ChunkOutputModeFileByteRange {
+			return fmt.Errorf("invalid secondary media destination %d", outputType)
+		}
+		for _, other := range append([]mediachunk.OutputTypes{mg.options.chunkOutputType}, chunkOutputTypes[:i]...) {
+			if outputType == other {
+				return fmt.Errorf("media destination %d is repeated", outputType)
+			}
+		}
+	}
+	for i, outputType := range manifestOutputTypes {
+		if outputType == hls.HlsOutputModeNone {
+			return fmt.Errorf("invalid secondary manifest destination %d", outputType)
+		}
+		for _, other := range append([]hls.OutputTypes{mg.options.manifestOutputType}, manifestOutputTypes[:i]...) {
+			if outputType == other {
+				return fmt.Errorf("manifest destination %d is repeated", outputType)
+			}
+		}
+	}
+
+	log := mg.options.log
+	stats := mg.stats
+	mg.options.secondaryChunkOutputTypes = append([]mediachunk.OutputTypes{}, chunkOutputTypes...)
+	mg.options.onSecondaryChunkFailed = func(outputType mediachunk.OutputTypes, fileName string, err error) {
+		atomic.AddUint64(&stats.SecondaryFailures, 1)
+		log.Error("Error writing media file ", fileName, " to the secondary destination ", outputType, ". Err: ", err)
+	}
+	mg.options.secondaryManifestOutputs = hls.SecondaryOutputs{}
+	if len(manifestOutputTypes) > 0 {
+		mg.options.secondaryManifestOutputs = hls.SecondaryOutputs{
+			OutputTypes: append([]hls.OutputTypes{}, manifestOutputTypes...),
+			OnFailed: func(outputType hls.OutputTypes, fileName string, err error) {
+				atomic.AddUint64(&stats.SecondaryFailures, 1)
+				log.Error("Error saving manifest ", fileName, " to the secondary destination ", outputType, ". Err: ", err)
+			},
+		}
+	}
+
+	mg.hlsChunklist.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	if mg.captions != nil {
+		mg.captionsChunklist.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	}
+	if mg.dash != nil {
+		mg.dash.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	}
+	return nil
+}
+
+// SetEncryption Encrypts the media chunks with the method (encryption.MethodNone disabled) and the 16 bytes key (nil a random one). The key file is saved in keyPath (empty the chunks destination), its URI in the chunklist is keyURITemplate with KeyURINamePlaceholder replaced by the key filename (empty the key file relative to the chunklist). The KEYFORMAT and KEYFORMATVERSIONS are written if not empty
+func (mg *ManifestGenerator) SetEncryption(method encryption.Methods, key []byte, keyURITemplate string, keyPath string, ivMode encryption.IVModes, keyFormat string, keyFormatVersions string) error {
+	mg.sampleAES = nil
+	if method == encryption.MethodNone {
+		mg.encryption = nil
+		return nil
+	}
+
+	if key == nil {
+		var err error
+		if key, err = encryption.NewKey(); err != nil {
+			return err
+		}
+	}
+	if len(key) != encryption.KeyLength {
+		return fmt.Errorf("invalid key length %d, it has to be %d bytes", len(key), encryption.KeyLength)
+	}
+	if ivMode != encryption.IVMediaSequence && ivMode != encryption.IVRandom {
+		return fmt.Errorf("invalid IV mode %d", ivMode)
+	}
+	if method != encryption.MethodAES128 && method != encryption.MethodSampleAES {
+		return fmt.Errorf("invalid encryption method %d", method)
+	}
+
+	if method == encryption.MethodSampleAES {
+		encrypter, err := encryption.NewSampleAES(key)
+		if err != nil {
+			return err
+		}
+		s := sampleAESChunks{encrypter, pestransform.Rewriter{}, -1}
+		s.rewriter = pestransform.New(s.transform)
+		mg.sampleAES = &s
+	}
+
+	mg.encryption = &chunkEncryption{method, key, ivMode, keyURITemplate, keyPath, 0, "", keyFormat, keyFormatVersions, 0, 0, 0, 0, nil, nil}
+	return nil
+}
+
+// SetKeyProvider Gets the encryption keys (of SetEncryption) from the provider, the 1st one now (error if it can not be got) and a new one every rotation, requested in the background ahead of it. The keys are never saved, the EXT-X-KEY URI is the provider one
+func (mg *ManifestGenerator) SetKeyProvider(provider encryption.KeyProvider) error {
+	if mg.encryption == nil {
+		return errors.New("the key provider needs the chunks encryption")
+	}
+
+	key, err := provider.GetKey(0)
+	if err != nil {
+		return err
+	}
+	if err := mg.setEncryptionKey(key.Key); err != nil {
+		return err
+	}
+	mg.encryption.provider = provider
+	mg.encryption.nextKey = nil
+	mg.encryption.keyIndex = 0
+	mg.encryption.keyURI = key.URI
+	return nil
+}
+
+// setEncryptionKey Sets the key of the next chunks
+func (mg *ManifestGenerator) setEncryptionKey(key []byte) error {
+	if len(key) != encryption.KeyLength {
+		return fmt.Errorf("invalid key length %d, it has to be %d bytes", len(key), encryption.KeyLength)
+	}
+	mg.encryption.key = key
+	return nil
+}
+
+// SetKeyRotation Rotates the encryption key (set by SetEncryption) after the chunks or the seconds of media (0 disabled) encrypted with it. The new keys are random (saved as new key files) or from the key provider, the rotation is always at the start of a chunk
+func (mg *ManifestGenerator) SetKeyRotation(chunks int, durationS float64) error {
+	if chunks < 0 || durationS < 0 {
+		return fmt.Errorf("invalid key rotation, chunks %d and seconds %f can not be negative", chunks, durationS)
+	}
+	if mg.encryption == nil {
+		return nil
+	}
+
+	mg.encryption.rotationChunks = chunks
+	mg.encryption.rotationS = durationS
+	return nil
+}
+
+// rotateKeyIfNeeded Uses a new key (saved before the chunk) if the current one reached the rotation chunks / duration. The provider keys are requested in the background, if the next one is not received yet the current key is kept for the chunk, and if the key provider fails it is kept until the next rotation
+func (mg *ManifestGenerator) rotateKeyIfNeeded() {
+	e := mg.encryption
+	if e.provider != nil && e.nextKey == nil && (e.rotationChunks > 0 || e.rotationS > 0) {
+		e.prefetchKey(e.keyIndex + 1)
+	}
+	if e.keyURI == "" || ((e.rotationChunks <= 0 || e.keyChunks < e.rotationChunks) && (e.rotationS <= 0 || e.keyDurationS < e.rotationS)) {
+		return
+	}
+
+	if e.provider != nil {
+		if !e.nextKey.isDone() {
+			mg.options.log.Warn("The key ", e.nextKey.index, " is not received yet from the key provider, the chunk is encrypted with the key ", e.keyIndex, " (", e.keyURI, ")")
+			return
+		}
+		e.keyChunks = 0
+		e.keyDurationS = 0
+
+		key, err := e.nextKey.key, e.nextKey.err
+		if err == nil {
+			err = mg.setEncryptionKey(key.Key)
+		}
+		if err != nil {
+			mg.options.log.Error("KEY ROTATION FAILED, the key provider is not available, the chunks are still encrypted with the key ", e.keyIndex, " (", e.keyURI, "). Err: ", err)
+			e.prefetchKey(e.keyIndex + 1)
+			return
+		}
+		e.keyIndex++
+		e.keyURI = key.URI
+		e.prefetchKey(e.keyIndex + 1)
+		return
+	}
+	e.keyChunks = 0
+	e.keyDurationS = 0
+
+	key, err := encryption.NewKey()
+	if err != nil {
+		panic(err)
+	}
+	e.key = key
+	e.keyIndex++
+	e.keyURI = ""
+}
+
+// saveKeyIfNeeded Saves the key file (if not saved yet) and sets its URI
+func (mg *ManifestGenerator) saveKeyIfNeeded() {
+	if mg.encryption.keyURI != "" {
+		return
+	}
+
+	keyOptions := mediachunk.Options{
+		Log:                  mg.options.log,
+		OutputType:           mg.wholeFileOutputType(),
+		LHLS:                 false,
+		EstimatedDurationS:   -1,
+		FileNumberLength:     mg.options.fileNumberLength,
+		GhostPrefix:          GhostPrefixDefault,
+		FileExtension:        KeyFileExtension,
+		BasePath:             mg.options.baseOutPath,
+		ChunkBaseFilename:    KeyFileName,
+		HTTPUploader:         mg.options.httpUploader,
+		S3Uploader:           mg.options.s3Uploader,
+		HTTPServer:           mg.options.httpServer,
+		Callbacks:            mg.options.chunkCallbacks,
+		NoFlagFile:           mg.options.isNoFlagFiles,
+		Fsync:                mg.options.mediaFsyncEvery > 0,
+		SecondaryOutputTypes: mg.wholeFileSecondaryOutputTypes(),
+		OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+		UploadType:           httpuploader.UploadTypeKey,
+	}
+	if mg.encryption.keyPath != "" {
+		keyOptions.OutputType = mediachunk.ChunkOutputModeFile
+		keyOptions.BasePath = mg.encryption.keyPath
+		keyOptions.SecondaryOutputTypes = nil
+	}
+
+	keyChunk := mediachunk.New(mg.encryption.keyIndex, keyOptions)
+	err := keyChunk.InitializeChunk()
+	if err == nil {
+		err = keyChunk.AddData(mg.encryption.key)
+	}
+	if err != nil {
+		mg.options.log.Error("Error saving the key file ", keyChunk.GetFilename(), ". Err: ", err)
+	}
+	keyChunk.Close(-1)
+
+	if mg.encryption.keyURITemplate != "" {
+		mg.encryption.keyURI = strings.ReplaceAll(mg.encryption.keyURITemplate, KeyURINamePlaceholder, path.Base(keyChunk.GetFilename()))
+	} else {
+		mg.encryption.keyURI = mg.hlsChunklist.MediaURI(keyChunk.GetFilename())
+	}
+}
+
+// newChunkKey Returns the key of a new chunk and the IV of its data (nil key if it is not encrypted), in encryption.IVMediaSequence the IV is not written in the chunklist
+func (mg *ManifestGenerator) newChunkKey(index uint64) encryptedChunk {
+	if mg.encryption == nil {
+		return encryptedChunk{nil, nil, nil}
+	}
+	mg.rotateKeyIfNeeded()
+	mg.saveKeyIfNeeded()
+	mg.encryption.keyChunks++
+
+	key := hls.Key{Method: mg.encryption.method.String(), URI: mg.encryption.keyURI, KeyFormat: mg.encryption.keyFormat, KeyFormatVersions: mg.encryption.keyFormatVersions}
+	iv := encryption.SequenceIV(index)
+	if mg.encryption.ivMode == encryption.IVRandom {
+		var err error
+		if iv, err = encryption.RandomIV(); err != nil {
+			panic(err)
+		}
+		key.IV = iv
+	}
+
+	return encryptedChunk{&key, mg.encryption.key, iv}
+}
+
+// setSampleAESKey Sets the key and IV of the current chunk in the SAMPLE-AES encrypter (before the PES written in it are encrypted)
+func (mg *ManifestGenerator) setSampleAESKey() {
+	if len(mg.currentChunks) > 0 {
+		chunkKey := mg.chunkKeys[mg.currentChunks[0].GetIndex()]
+		if err := mg.sampleAES.encrypter.SetKey(chunkKey.aesKey); err != nil {
+			mg.options.log.Error("Error setting the SAMPLE-AES key of the chunk ", mg.currentChunks[0].GetIndex(), ". Err: ", err)
+		}
+		mg.sampleAES.encrypter.SetIV(chunkKey.iv)
+	}
+}
+
+// writeSampleAESPackets Adds the rewritten packets to the current chunk
+func (mg *ManifestGenerator) writeSampleAESPackets(buf []byte) {
+	for i := 0; i+tspacket.TsDefaultPacketSize <= len(buf); i = i + tspacket.TsDefaultPacketSize {
+		mg.addBufferToChunk(buf[i : i+tspacket.TsDefaultPacketSize])
+	}
+}
+
+// endSampleAESPES Writes the PES being received of the PID (all of them if < 0) encrypted in the current chunk
+func (mg *ManifestGenerator) endSampleAESPES(pID int) {
+	if len(mg.currentChunks) <= 0 {
+		return
+	}
+
+	mg.setSampleAESKey()
+	if pID < 0 {
+		mg.writeSampleAESPackets(mg.sampleAES.rewriter.Flush())
+	} else {
+		mg.writeSampleAESPackets(mg.sampleAES.rewriter.EndPES(mg.remappedPID(pID)))
+	}
+}
+
+// addPacketToSampleAES Adds the video or audio packet to the PES rewriting, the complete PES are written encrypted in the current chunk (creating it if needed)
+func (mg *ManifestGenerator) addPacketToSampleAES() {
+	pID := mg.tsPacket.GetPID()
+	if (pID == mg.options.videoPID && mg.videoCodec != VideoCodecH264) || (pID == mg.options.audioPID && mg.audioCodec != AudioCodecAAC) {
+		if mg.err == nil {
+			mg.err = errors.New("SAMPLE-AES chunks only support H.264 video and AAC audio")
+			mg.options.log.Error("Error writing the SAMPLE-AES chunks. Err: ", mg.err)
+		}
+		return
+	}
+
+	remappedPID := mg.remappedPID(pID)
+	if remappedPID != pID {
+		mg.tsPacket.SetPID(remappedPID)
+	}
+	mg.sampleAES.rewriter.AddPID(remappedPID)
+	if pID == mg.options.videoPID {
+		mg.sampleAES.videoPID = remappedPID
+	}
+
+	if mg.currentChunks == nil {
+		mg.createChunk(false)
+	}
+	mg.setSampleAESKey()
+	mg.writeSampleAESPackets(mg.sampleAES.rewriter.AddPacket(mg.tsPacket.GetBuffer()))
+}
+
+// sampleAESStream Returns the PMT ES of the SAMPLE-AES encrypted stream (H.264 and AAC), with the private_data_indicator_descriptor (and the audio setup information of the AAC one, without setup data)
+func sampleAESStream(es tspacket.PSIStream) tspacket.PSIStream {
+	if es.StreamType == tspacket.H264StreamType {
+		es.StreamType = tspacket.SampleAESH264StreamType
+		es.Descriptors = append(append([]byte{}, es.Descriptors...), 0x0F, 4, 'z', 'a', 'v', 'c')
+	} else if es.StreamType == tspacket.ADTSStreamType {
+		es.StreamType = tspacket.SampleAESADTSStreamType
+		es.Descriptors = append(append([]byte{}, es.Descriptors...), 0x0F, 4, 'a', 'a', 'c', 'd')
+		// Registration descriptor with the audio_setup_information (audio type, priming, version and setup data length)
+		es.Descriptors = append(es.Descriptors, 0x05, 12, 'a', 'p', 'a', 'd', 'z', 'a', 'a', 'c', 0, 0, 1, 0)
+	}
+	return es
+}
+
+// sampleAESPMT Rewrites the PMT of the packet with the SAMPLE-AES stream types and descriptors
+func (mg *ManifestGenerator) sampleAESPMT() {
+	_, streams := mg.tsPacket.GetPMTStreams()
+	for i, es := range streams {
+		streams[i] = sampleAESStream(es)
+	}
+	if !mg.tsPacket.SetPMTStreams(streams) {
+		mg.options.log.Warn("Error rewriting the PMT for SAMPLE-AES, it is written as is")
+	}
+}
+
+// SetHTTPServer Sets the built-in HTTP server of the chunks and chunklists (mediachunk.ChunkOutputModeHTTPServer / hls.HlsOutputModeHTTPServer), it has to be set before the captions and I-frames chunklists
+func (mg *ManifestGenerator) SetHTTPServer(httpServer *httpserver.HTTPServer) {
+	mg.options.httpServer = httpServer
+	mg.hlsChunklist.SetHTTPServer(httpServer)
+}
+
+// SetSegmentURIPrefix Sets the prefix of the media URIs (chunks, init chunks, parts and key files) written in the chunklists (Ex: https://media.cdn.example/chan1/), they are still saved to the same destination paths. It has to be set before the captions and I-frames chunklists
+func (mg *ManifestGenerator) SetSegmentURIPrefix(uriPrefix string) {
+	mg.segmentURIPrefix = uriPrefix
+	mg.hlsChunklist.SetURIPrefix(uriPrefix)
+}
+
+// SetBitrateTag Writes the EXT-X-BITRATE (kbps) before every chunk of the chunklist, not in the byte range chunks. The LHLS advanced chunks get it when they are finalized
+func (mg *ManifestGenerator) SetBitrateTag(isEnabled bool) {
+	mg.hlsChunklist.SetBitrate(isEnabled)
+}
+
+// SetCustomTags Sets the custom lines of the chunklist: after its header tags, and the ones returned by chunkTags before every chunk (nil none). See hls.SetCustomTags
+func (mg *ManifestGenerator) SetCustomTags(headerTags []string, chunkTags hls.ChunkTagsCallback) error {
+	return mg.hlsChunklist.SetCustomTags(headerTags, chunkTags)
+}
+
+// SetManifestFsync Fsyncs the chunklists and the MPD files before they replace the previous ones (crash safety), it has to be set before the captions and I-frames chunklists and the MPD
+func (mg *ManifestGenerator) SetManifestFsync(isFsync bool) {
+	mg.options.isManifestFsync = isFsync
+	mg.hlsChunklist.SetFsync(isFsync)
+}
+
+// SetChunkFlush Sets the flush thresholds of the chunks and parts being written, the data is accumulated and sent to the output (the files also synced) when any of them is reached (both <= 0 every TS packet is written when processed)
+func (mg *ManifestGenerator) SetChunkFlush(flushBytes int, flushInterval time.Duration) {
+	mg.options.flushBytes = flushBytes
+	mg.options.flushInterval = flushInterval
+}
+
+// SetPublishPolicy Sets when the chunklist is saved (the interval only for hls.PublishCoalesced), a completed chunk is always saved immediately
+func (mg *ManifestGenerator) SetPublishPolicy(publishPolicy hls.PublishPolicies, publishInterval time.Duration) {
+	mg.hlsChunklist.SetPublishPolicy(publishPolicy, publishInterval)
+}
+
+// SetServerControl Enables EXT-X-SERVER-CONTROL with CAN-BLOCK-RELOAD (the origin supports blocking reloads), the hold backs <= 0 are computed from the target and part durations
+func (mg *ManifestGenerator) SetServerControl(isEnabled bool, holdBackS float64, partHoldBackS float64) {
+	mg.hlsChunklist.SetServerControl(isEnabled, hls.ServerControl{CanBlockReload: true, HoldBackS: holdBackS, PartHoldBackS: partHoldBackS})
+}
+
+// SetDeltaChunklist Saves the delta chunklist deltaChunklistFilename (EXT-X-SKIP, empty disabled) with the media one, CAN-SKIP-UNTIL <= 0 is computed from the target duration. It needs the EXT-X-SERVER-CONTROL
+func (mg *ManifestGenerator) SetDeltaChunklist(deltaChunklistFilename string, canSkipUntilS float64) {
+	if deltaChunklistFilename == "" {
+		mg.hlsChunklist.SetDelta("", 0)
+		return
+	}
+	mg.hlsChunklist.SetDelta(path.Join(mg.options.baseOutPath, deltaChunklistFilename), canSkipUntilS)
+}
+
+// AddWindowChunklist Saves another chunklist of the same chunks to windowChunklistFilename with its own window size (<= 0 all the chunks since the start) and output, it is updated every time a chunk is completed (Ex: a DVR chunklist longer than the live one). The segmenter never deletes chunks, the ones out of the live window are only retained in memory for the largest window
+func (mg *ManifestGenerator) AddWindowChunklist(windowChunklistFilename string, windowSize int, outputType hls.OutputTypes) {
+	mg.hlsChunklist.AddWindow(hls.Window{FileName: path.Join(mg.options.baseOutPath, windowChunklistFilename), WindowSize: windowSize, OutputType: outputType})
+}
+
+// partIfNeeded Closes the current part if it reached the part duration, and creates a new one if needed
+func (mg *ManifestGenerator) partIfNeeded() {
+	if mg.currentPart != nil && mg.lastClockPCRS >= 0 {
+		if mg.partStartClockS < 0 {
+			mg.partStartClockS = mg.lastClockPCRS
+		}
+		// In ticks, so the PCR periods are not lost by rounding. Timestamps going backwards restart the part time
+		durationS := ptsDiffS(mg.partStartClockS, mg.lastClockPCRS)
+		if durationS < 0 {
+			mg.partStartClockS = mg.lastClockPCRS
+		} else if tspacket.SecondsToTicks(durationS) >= tspacket.SecondsToTicks(mg.partDurationS) {
+			nextPart := mediachunk.New(mg.currentChunks[0].GetIndex(), mg.partOptions(mg.currentChunks[0].GetIndex(), mg.partIndex+1))
+			mg.closePart(durationS, true, nextPart.GetFilename())
+		}
+	}
+
+	if mg.currentPart == nil {
+		mg.createPart()
+	}
+}
+
+// partOptions Returns the options of the part partIndex of the chunk index (chunk filename with the part index before the extension)
+func (mg *ManifestGenerator) partOptions(index uint64, partIndex int) mediachunk.Options {
+	return mediachunk.Options{
+		Log:                  mg.options.log,
+		OutputType:           mg.wholeFileOutputType(),
+		LHLS:                 false,
+		EstimatedDurationS:   mg.partDurationS,
+		FileNumberLength:     mg.options.fileNumberLength,
+		GhostPrefix:          GhostPrefixDefault,
+		FileExtension:        "." + strconv.Itoa(partIndex) + ChunkFileExtensionDefault,
+		BasePath:             mg.chunkBasePath(index),
+		ChunkBaseFilename:    mg.options.chunkBaseFilename,
+		HTTPUploader:         mg.options.httpUploader,
+		S3Uploader:           mg.options.s3Uploader,
+		HTTPServer:           mg.options.httpServer,
+		Callbacks:            mg.options.chunkCallbacks,
+		FlushBytes:           mg.options.flushBytes,
+		FlushInterval:        mg.options.flushInterval,
+		Fsync:                mg.isMediaFsync(index),
+		WriteQueueBytes:      mg.options.writeQueueBytes,
+		WriteQueueStats:      mg.writeQueueStats,
+		SecondaryOutputTypes: mg.wholeFileSecondaryOutputTypes(),
+		OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+	}
+}
+
+// createPart Creates the next part of the current chunk
+func (mg *ManifestGenerator) createPart() {
+	part := mediachunk.New(mg.currentChunks[0].GetIndex(), mg.partOptions(mg.currentChunks[0].GetIndex(), mg.partIndex))
+	mg.currentPart = &part
+	mg.hlsChunklist.SetPreloadHint(part.GetFilename(), false)
+	mg.partStartClockS = mg.lastClockPCRS
+	mg.isPartVideoChecked = false
+	mg.isPartIndependent = false
+
+	err := mg.currentPart.InitializeChunk()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// closePart Closes the current part and adds it to the chunklist (if it has duration) with the preload hint of the next one (empty none), without video (Ex: audio only) it is independent
+func (mg *ManifestGenerator) closePart(durationS float64, saveChunklist bool, nextPartFileName string) {
+	mg.currentPart.Close(durationS)
+	mg.hlsChunklist.SetPreloadHint(nextPartFileName, false)
+
+	isIndependent := mg.isPartIndependent
+	if !mg.isPartVideoChecked {
+		isIndependent = mg.isAudioOnly || mg.options.videoPID < 0
+	}
+	if mg.chunkRetention != nil && len(mg.currentChunks) > 0 {
+		mg.chunkRetention.addPart(mg.currentChunks[0].GetFilename(), mg.currentPart.GetFilename())
+	}
+	if durationS > 0 {
+		err := mg.hlsChunklist.AddPart(hls.Part{FileName: mg.currentPart.GetFilename(), DurationS: durationS, IsIndependent: isIndependent}, saveChunklist)
+		if err != nil {
+			mg.options.log.Error("Error generating / saving the chunklists. Err: ", err)
+		}
+	} else {
+		mg.options.log.Debug("Part ", mg.currentPart.GetFilename(), " without duration, not added to the chunklist")
+	}
+
+	mg.chunkPartsDurS = mg.chunkPartsDurS + durationS
+	mg.partIndex++
+	mg.currentPart = nil
+}
+
+// addChunkData Adds the data to the current chunk, and to its current part
+func (mg *ManifestGenerator) addChunkData(buf []byte) error {
+	err := mg.currentChunks[0].AddData(buf)
+	if mg.currentPart != nil {
+		if errPart := mg.currentPart.AddData(buf); err == nil {
+			err = errPart
+		}
+	}
+
+	return err
+}
+
+// SetStart Sets the EXT-X-START of the chunklist, the time offset (< 0 from the live edge) is clamped to the chunklist duration
+func (mg *ManifestGenerator) SetStart(isEnabled bool, timeOffsetS float64, isPrecise bool) {
+	mg.hlsChunklist.SetStart(isEnabled, timeOffsetS, isPrecise)
+}
+
+// SetIndependentSegments Sets if the chunklist has EXT-X-INDEPENDENT-SEGMENTS (by default true), it is removed (error logged) if a chunk does not start with a keyframe (Ex: maxSegmentDurS cut)
+func (mg *ManifestGenerator) SetIndependentSegments(isIndependentSegments bool) {
+	mg.isIndependentSegments = isIndependentSegments
+	mg.hlsChunklist.SetIndependentSegments(isIndependentSegments)
+}
+
+// checkChunkVideoStart Checks if the 1st video packet of the current chunk (and part) is a random access point
+func (mg *ManifestGenerator) checkChunkVideoStart(isRandomAccess bool) {
+	if mg.currentPart != nil && !mg.isPartVideoChecked {
+		mg.isPartVideoChecked = true
+		mg.isPartIndependent = isRandomAccess
+	}
+	if mg.isChunkVideoChecked {
+		return
+	}
+
+	mg.isChunkVideoChecked = true
+	if !isRandomAccess {
+		mg.isChunkIndependent = false
+	}
+}
+
+// checkIndependentChunk Removes EXT-X-INDEPENDENT-SEGMENTS from the chunklist if the closed chunk is not independent, and resets the checks for the next one
+func (mg *ManifestGenerator) checkIndependentChunk(fileName string) {
+	if mg.isIndependentSegments && !mg.isChunkIndependent {
+		mg.options.log.Error("Chunk ", fileName, " does not start with a keyframe (or the PAT and PMT), EXT-X-INDEPENDENT-SEGMENTS removed from the chunklist")
+		mg.isIndependentSegments = false
+		mg.hlsChunklist.SetIndependentSegments(false)
+	}
+
+	mg.isChunkVideoChecked = false
+	mg.isChunkIndependent = true
+}
+
+// SetByteRangeMaxFileBytes Sets the size (in bytes, <= 0 disabled) that starts a new file in byte range output, the 1st chunk of the new file starts a discontinuity
+func (mg *ManifestGenerator) SetByteRangeMaxFileBytes(maxFileBytes int64) {
+	mg.byteRangeMaxFileBytes = maxFileBytes
+}
+
+// SetMinSegmentDurS Sets the min chunk duration (in seconds, <= 0 disabled), a random access point before it does not cut the chunk (SCTE-35 splice cuts are delayed too)
+func (mg *ManifestGenerator) SetMinSegmentDurS(minSegmentDurS float64) {
+	mg.minSegmentDurS = minSegmentDurS
+}
+
+// SetPIDFilter Sets the extra PIDs to write in the chunks (by default only video and audio), if filterPIDs is true the PAT / PMT are rewritten to contain only the selected program and the written PIDs (the PCR PID is written too)
+func (mg *ManifestGenerator) SetPIDFilter(filterPIDs bool, keepPIDs []int) {
+	mg.filterPIDs = filterPIDs
+	mg.keepPIDs = map[int]bool{}
+	for _, pID := range keepPIDs {
+		mg.keepPIDs[pID] = true
+	}
+}
+
+// SetPIDRemap Sets the PIDs to use in the chunks for the video, audio and PMT (< 0 the source PID is kept), the PAT / PMT are rewritten to reference them
+func (mg *ManifestGenerator) SetPIDRemap(videoPID int, audioPID int, pmtPID int) {
+	mg.remapVideoPID = videoPID
+	mg.remapAudioPID = audioPID
+	mg.remapPMTPID = pmtPID
+}
+
+// remappedPID Returns the PID to use in the chunks for the source PID
+func (mg *ManifestGenerator) remappedPID(pID int) int {
+	if pID == mg.options.videoPID && mg.remapVideoPID >= 0 {
+		return mg.remapVideoPID
+	} else if pID == mg.options.audioPID && mg.remapAudioPID >= 0 {
+		return mg.remapAudioPID
+	} else if pID == mg.detectedPMTID && mg.remapPMTPID >= 0 {
+		return mg.remapPMTPID
+	}
+
+	return pID
+}
+
+// remapPMT Rewrites the PMT of the current packet (and its PID) with the remapped video and audio PIDs, returns error if a remapped PID collides with another PID of the PMT
+func (mg *ManifestGenerator) remapPMT(videoPID int, audioPID int) error {
+	if mg.remapVideoPID < 0 && mg.remapAudioPID < 0 && mg.remapPMTPID < 0 {
+		return nil
+	}
+
+	pIDs := map[int]int{}
+	if videoPID >= 0 && mg.remapVideoPID >= 0 {
+		pIDs[videoPID] = mg.remapVideoPID
+	}
+	if audioPID >= 0 && mg.remapAudioPID >= 0 {
+		pIDs[audioPID] = mg.remapAudioPID
+	}
+
+	// PIDs referenced by the rewritten PMT
+	_, Videoh264, AudioADTS, Other := mg.tsPacket.GetPMTdata()
+	AudioAC3, AudioEAC3 := mg.tsPacket.GetPMTDolbyPIDs()
+	esPIDs := [][]uint16{Videoh264, mg.tsPacket.GetPMTHEVCPIDs(), mg.tsPacket.GetPMTMPEG2PIDs(), AudioADTS, AudioAC3, AudioEAC3, mg.tsPacket.GetPMTSCTE35PIDs(), mg.tsPacket.GetPMTMetadataPIDs(), Other, {uint16(mg.pcrPID)}}
+	outputPIDs := mg.outputPIDs(videoPID, audioPID)
+
+	usedPIDs := map[int]int{}
+	if mg.remapPMTPID >= 0 {
+		usedPIDs[mg.remapPMTPID] = mg.detectedPMTID
+	}
+	for _, pIDList := range esPIDs {
+		for _, esPID := range pIDList {
+			pID := int(esPID)
+			if mg.filterPIDs && !outputPIDs[pID] {
+				continue
+			}
+			newPID := pID
+			if remappedPID, found := pIDs[pID]; found {
+				newPID = remappedPID
+			}
+			if srcPID, found := usedPIDs[newPID]; found && srcPID != pID {
+				return fmt.Errorf("remapped PID %d (0x%04X) collides with the PID %d of the PMT", newPID, newPID, srcPID)
+			}
+			usedPIDs[newPID] = pID
+		}
+	}
+
+	if !mg.tsPacket.RemapPMT(pIDs) {
+		mg.options.log.Warn("Error remapping the PMT PIDs, it is written as is")
+	}
+	if mg.remapPMTPID >= 0 {
+		mg.tsPacket.SetPID(mg.remapPMTPID)
+	}
+
+	return nil
+}
+
+// SetDataPIDs Sets the data PIDs (Ex: ID3 timed metadata) written in the chunks, if isAuto the PMT timed metadata PIDs (stream type 0x15) are used too
+func (mg *ManifestGenerator) SetDataPIDs(dataPIDs []int, isAuto bool) {
+	mg.isAutoDataPIDs = isAuto
+	mg.dataPIDs = map[int]bool{}
+	for _, pID := range dataPIDs {
+		mg.dataPIDs[pID] = true
+	}
+}
+
+// addDataPacket Adds the data packet to its pending PES, the PES is written in the current chunk when it is complete (or the next one starts)
+func (mg *ManifestGenerator) addDataPacket(pID int) {
+	payload := mg.tsPacket.GetPayload()
+	if mg.tsPacket.IsPayloadUnitStart() {
+		mg.flushDataPES(pID)
+
+		// PES_packet_length (0 unbounded)
+		mg.pendingDataBytes[pID] = -1
+		if len(payload) >= 6 && payload[0] == 0x00 && payload[1] == 0x00 && payload[2] == 0x01 {
+			if pesLength := int(payload[4])<<8 | int(payload[5]); pesLength > 0 {
+				mg.pendingDataBytes[pID] = 6 + pesLength
+			}
+		}
+	} else if len(mg.pendingDataPackets[pID]) <= 0 {
+		mg.options.log.Debug("Data packet without PES start discarded, PID: ", pID)
+		return
+	}
+
+	mg.pendingDataPackets[pID] = append(mg.pendingDataPackets[pID], append([]byte{}, mg.tsPacket.GetBuffer()...))
+	if mg.pendingDataBytes[pID] > 0 {
+		mg.pendingDataBytes[pID] = mg.pendingDataBytes[pID] - len(payload)
+		if mg.pendingDataBytes[pID] <= 0 {
+			mg.flushDataPES(pID)
+		}
+	}
+}
+
+// flushDataPES Writes the pending packets of the data PID in the current chunk
+func (mg *ManifestGenerator) flushDataPES(pID int) {
+	for _, pckt := range mg.pendingDataPackets[pID] {
+		mg.addBufferToChunk(pckt)
+	}
+	mg.pendingDataPackets[pID] = mg.pendingDataPackets[pID][:0]
+	mg.pendingDataBytes[pID] = 0
+}
+
+// outputPIDs Returns the PIDs referenced by the rewritten PMT
+func (mg *ManifestGenerator) outputPIDs(videoPID int, audioPID int) map[int]bool {
+	pIDs := map[int]bool{videoPID: true, audioPID: true, mg.pcrPID: true}
+	for pID := range mg.keepPIDs {
+		pIDs[pID] = true
+	}
+	for pID := range mg.dataPIDs {
+		pIDs[pID] = true
+	}
+
+	return pIDs
+}
+
+// isExtraOutputPID Returns true if the packets of the PID (not video / audio) are written in the chunks
+func (mg *ManifestGenerator) isExtraOutputPID(pID int) bool {
+	return mg.keepPIDs[pID] || ((mg.filterPIDs || mg.generatePSI) && pID == mg.pcrPID)
+}
+
+// SetStripNullPackets Sets if the null packets (PID 0x1FFF, CBR padding) are dropped instead of written in the chunks, the stripped ones are counted in the stats
+func (mg *ManifestGenerator) SetStripNullPackets(stripNullPackets bool) {
+	mg.stripNullPackets = stripNullPackets
+}
+
+// SetPSIGeneration Sets if the init data is a generated PAT (single program) and PMT (only the PIDs written in the chunks) instead of the source ones, it also allows init data with manual PIDs
+func (mg *ManifestGenerator) SetPSIGeneration(generatePSI bool) {
+	mg.generatePSI = generatePSI
+}
+
+// initPMTPacket Returns the PMT used as init data (the current packet, or the generated PMT)
+func (mg *ManifestGenerator) initPMTPacket() tspacket.TsPacket {
+	if mg.generatePSI {
+		return mg.generatedPMT
+	}
+
+	return mg.tsPacket
+}
+
+// generatePSIPackets Generates the PAT (saved as the last PAT) and the PMT from the current PMT packet (already filtered / remapped), the PMT only has the ES written in the chunks
+func (mg *ManifestGenerator) generatePSIPackets(videoPID int, audioPID int, version int) {
+	pcrPID, sourceStreams := mg.tsPacket.GetPMTStreams()
+
+	pIDs := map[int]bool{remapPID(videoPID, mg.remapVideoPID): true, remapPID(audioPID, mg.remapAudioPID): true}
+	for pID := range mg.keepPIDs {
+		pIDs[pID] = true
+	}
+	for pID := range mg.dataPIDs {
+		pIDs[pID] = true
+	}
+	streams := []tspacket.PSIStream{}
+	for _, es := range sourceStreams {
+		if pIDs[int(es.PID)] {
+			streams = append(streams, es)
+		}
+	}
+
+	programNumber := 1
+	for _, program := range mg.patPrograms {
+		if program.PMTPID == mg.detectedPMTID {
+			programNumber = program.ProgramNumber
+		}
+	}
+	pmtPID := remapPID(mg.detectedPMTID, mg.remapPMTPID)
+
+	pmt, ok := tspacket.NewPMTPacket(pmtPID, programNumber, version, pcrPID, streams)
+	if !ok {
+		mg.options.log.Warn("Error generating the PMT, the source one is used")
+		pmt = tspacket.CloneFrom(mg.tsPacket)
+	}
+	mg.generatedPMT = pmt
+	mg.lastPATPacket = tspacket.NewPATPacket(programNumber, pmtPID)
+}
+
+// generateManualPSI Saves the generated PAT and PMT of the manual PIDs as init data (the PCR is expected in the video PID, or in the audio PID if there is no video)
+func (mg *ManifestGenerator) generateManualPSI() {
+	streams := []tspacket.PSIStream{}
+	pcrPID := -1
+	if mg.options.videoPID >= 0 {
+		pcrPID = remapPID(mg.options.videoPID, mg.remapVideoPID)
+		streams = append(streams, tspacket.PSIStream{StreamType: videoStreamType(mg.videoCodec), PID: uint16(pcrPID)})
+	}
+	if mg.options.audioPID >= 0 {
+		audioPID := remapPID(mg.options.audioPID, mg.remapAudioPID)
+		if pcrPID < 0 {
+			pcrPID = audioPID
+		}
+		streams = append(streams, tspacket.PSIStream{StreamType: audioStreamType(mg.audioCodec), PID: uint16(audioPID)})
+	}
+	for pID := range mg.keepPIDs {
+		streams = append(streams, tspacket.PSIStream{StreamType: tspacket.PrivatePESStreamType, PID: uint16(pID)})
+	}
+	if mg.sampleAES != nil {
+		for i, es := range streams {
+			streams[i] = sampleAESStream(es)
+		}
+	}
+	pmtPID := remapPID(ManualPMTPID, mg.remapPMTPID)
+
+	mg.generatedPMT, _ = tspacket.NewPMTPacket(pmtPID, 1, 0, pcrPID, streams)
+	mg.lastPATPacket = tspacket.NewPATPacket(1, pmtPID)
+
+	mg.saveInitPacket(PatTable, mg.lastPATPacket)
+	mg.saveInitPacket(PmtTable, mg.generatedPMT)
+	mg.setRepeatedPSI(mg.lastPATPacket, mg.generatedPMT)
+}
+
+// remapPID Returns the remapped PID if set (>= 0 and the PID exists)
+func remapPID(pID int, remappedPID int) int {
+	if pID >= 0 && remappedPID >= 0 {
+		return remappedPID
+	}
+
+	return pID
+}
+
+// videoStreamType Returns the PMT stream type of the video codec (h264 if unknown)
+func videoStreamType(codec videoCodecs) uint8 {
+	switch codec {
+	case VideoCodecHEVC:
+		return tspacket.HEVCStreamType
+	case VideoCodecMPEG2:
+		return tspacket.MPEG2VideoStreamType
+	}
+
+	return tspacket.H264StreamType
+}
+
+// audioStreamType Returns the PMT stream type of the audio codec (AAC ADTS if unknown)
+func audioStreamType(codec AudioCodecs) uint8 {
+	switch codec {
+	case AudioCodecAC3:
+		return tspacket.AC3StreamType
+	case AudioCodecEAC3:
+		return tspacket.EAC3StreamType
+	}
+
+	return tspacket.ADTSStreamType
+}
+
+// SetPSIInterval Sets the interval (in ms, <= 0 disabled) to repeat the PAT and PMT inside the chunks, they are also added at the start of every chunk
+func (mg *ManifestGenerator) SetPSIInterval(intervalMs int) {
+	mg.psiIntervalS = float64(intervalMs) / 1000.0
+}
+
+// setRepeatedPSI Sets the PAT and PMT repeated inside the chunks (and at the start of the I-frame chunks)
+func (mg *ManifestGenerator) setRepeatedPSI(pat tspacket.TsPacket, pmt tspacket.TsPacket) {
+	if mg.psiIntervalS <= 0 && !mg.isIFrames {
+		return
+	}
+
+	mg.repeatedPAT = tspacket.CloneFrom(pat)
+	mg.repeatedPMT = tspacket.CloneFrom(pmt)
+	mg.hasRepeatedPSI = true
+}
+
+// addRepeatedPSIIfNeeded Adds the PAT and PMT to the current chunk if it is empty or psiIntervalS passed since the last ones, the continuity counters continue across chunks
+func (mg *ManifestGenerator) addRepeatedPSIIfNeeded() {
+	if !mg.hasRepeatedPSI {
+		return
+	}
+
+	timeS := mg.lastClockPCRS
+	if !mg.currentChunks[0].IsEmpty() {
+		if timeS < 0 {
+			return
+		}
+		// Timestamps going backwards (Ex: discontinuity) restart the interval. In ticks, so the PCR periods are not lost by rounding
+		if diffS := ptsDiffS(mg.lastPSITimeS, timeS); mg.lastPSITimeS >= 0 && diffS >= 0 && tspacket.SecondsToTicks(diffS) < tspacket.SecondsToTicks(mg.psiIntervalS) {
+			return
+		}
+	}
+	mg.lastPSITimeS = timeS
+
+	mg.repeatedPAT.SetContinuityCounter(mg.patCC)
+	mg.repeatedPMT.SetContinuityCounter(mg.pmtCC)
+	mg.patCC = (mg.patCC + 1) % 16
+	mg.pmtCC = (mg.pmtCC + 1) % 16
+
+	mg.addChunkData(mg.repeatedPAT.GetBuffer())
+	mg.addChunkData(mg.repeatedPMT.GetBuffer())
+	mg.chunkOutputBytes = mg.chunkOutputBytes + uint64(2*tspacket.TsDefaultPacketSize)
+}
+
+// SetProgramDateTime Sets every how many chunks the EXT-X-PROGRAM-DATE-TIME is written (<= 0 disabled, every chunk with SCTE-35 splices), it is the wall clock time when the chunk received its 1st byte carried forward by the chunk durations (re-anchored after a discontinuity)
+func (mg *ManifestGenerator) SetProgramDateTime(everyNChunks int) {
+	if everyNChunks <= 0 && mg.detectedSCTE35PID > 0 {
+		everyNChunks = 1
+	}
+	mg.programDateTimeEvery = everyNChunks
+	mg.hlsChunklist.SetProgramDateTime(everyNChunks)
+}
+
+// SetProgramDateTimeSource Sets the source of the program date time, with PDTSourceDVB the TDT / TOT PID is parsed
+func (mg *ManifestGenerator) SetProgramDateTimeSource(source PDTSources) {
+	mg.pdtSource = source
+}
+
+// forceProgramDateTime Writes the EXT-X-PROGRAM-DATE-TIME in every chunk if it is disabled, the EXT-X-DATERANGE of the SCTE-35 splices need it (RFC 8216) and their START-DATE is based on it
+func (mg *ManifestGenerator) forceProgramDateTime() {
+	if mg.programDateTimeEvery > 0 {
+		return
+	}
+
+	mg.options.log.Info("EXT-X-PROGRAM-DATE-TIME enabled in every chunk, the SCTE-35 splices need it")
+	mg.SetProgramDateTime(1)
+	if !mg.isCurrentChunkEmpty() {
+		mg.startChunkProgramDateTime()
+	}
+}
+
+// startChunkProgramDateTime Sets the program date time of the current chunk (it received its 1st byte), the DVB one is set when the chunk is closed
+func (mg *ManifestGenerator) startChunkProgramDateTime() {
+	if mg.programDateTimeEvery <= 0 || mg.pdtSource == PDTSourceDVB {
+		return
+	}
+
+	if mg.pdtAnchor.IsZero() || mg.isPDTReanchor {
+		anchor := time.Now()
+		if !mg.pdtAnchor.IsZero() && anchor.Before(mg.pdtTime()) {
+			// Never before the end of the previous chunk, so it is monotonic (Ex: input faster than real time)
+			anchor = mg.pdtTime()
+		}
+		mg.pdtAnchor = anchor
+		mg.pdtTicks = 0
+		mg.isPDTReanchor = false
+	}
+	mg.chunkProgramDateTime = mg.pdtTime()
+}
+
+// pdtTime Returns the program date time after the chunks published since the anchor
+func (mg *ManifestGenerator) pdtTime() time.Time {
+	return mg.pdtAnchor.Add(ticksToDuration(mg.pdtTicks))
+}
+
+// ticksToDuration Returns the duration of the 90KHz ticks (without overflowing the nanoseconds)
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks/tspacket.ClockHz)*time.Second + time.Duration(ticks%tspacket.ClockHz)*time.Second/tspacket.ClockHz
+}
+
+// processDVBTime Anchors the DVB time to the current PCR with the TDT / TOT sections of the packet
+func (mg *ManifestGenerator) processDVBTime() {
+	sections := mg.dvbTimeAssembler.AddPayload(mg.tsPacket.GetPayload(), mg.tsPacket.IsPayloadUnitStart())
+
+	for _, section := range sections {
+		utc, err := dvbtime.Parse(section)
+		if err != nil {
+			mg.options.log.Warn("Error parsing DVB time section. Err: ", err)
+			continue
+		}
+		if mg.lastClockPCRS < 0 {
+			// No PCR to map it to yet
+			continue
+		}
+
+		if mg.dvbTimePCRS < 0 {
+			mg.options.log.Info("DVB time anchored, UTC: ", utc.Format(time.RFC3339), " at PCR: ", mg.lastClockPCRS)
+		} else if mg.isDVBTimeLost {
+			mg.options.log.Info("DVB time received again, re-anchored. UTC: ", utc.Format(time.RFC3339), ", extrapolated drift: ", utc.Sub(mg.dvbProgramDateTime(mg.lastClockPCRS)))
+		}
+		mg.dvbTime = utc
+		mg.dvbTimePCRS = mg.lastClockPCRS
+		mg.isDVBTimeLost = false
+	}
+}
+
+// dvbProgramDateTime Returns the UTC time of the PCR extrapolated from the last DVB time, zero if there is no DVB time
+func (mg *ManifestGenerator) dvbProgramDateTime(pcrS float64) time.Time {
+	if mg.dvbTimePCRS < 0 || pcrS < 0 {
+		return time.Time{}
+	}
+
+	elapsedS := ptsDiffS(mg.dvbTimePCRS, pcrS)
+	if elapsedS > MaxDVBTimeGapS && !mg.isDVBTimeLost {
+		mg.options.log.Warn("No DVB time (TDT / TOT) received in ", fmt.Sprintf("%.3f", elapsedS), "s, extrapolating the last one")
+		mg.isDVBTimeLost = true
+	}
+
+	return mg.dvbTime.Add(ticksToDuration(tspacket.SecondsToTicks(elapsedS)))
+}
+
+// SetMasterPlaylist Sets the master playlist where this chunklist is the variant of variantURI, if bandwidthWindowChunks > 0 its BANDWIDTH (peak) and AVERAGE-BANDWIDTH are measured from the last chunks. It is saved if the bandwidth is known
+func (mg *ManifestGenerator) SetMasterPlaylist(master *hls.Master, variantURI string, bandwidthWindowChunks int) {
+	mg.master = master
+	mg.masterVariant = master.VariantIndex(variantURI)
+	mg.bandwidthWindow = bandwidthWindowChunks
+	if mg.isIFrames {
+		mg.iFramesURI = path.Join(path.Dir(variantURI), mg.iFramesChunklistFilename)
+	}
+	if mg.masterVariant < 0 {
+		mg.options.log.Warn("Chunklist ", variantURI, " not found in the master playlist, its bandwidth is not measured")
+		mg.bandwidthWindow = 0
+	}
+
+	if mg.bandwidthWindow <= 0 || master.GetVariant(mg.masterVariant).Bandwidth > 0 {
+		err := master.Save()
+		if err != nil {
+			mg.options.log.Error("Error saving the master playlist. Err: ", err)
+		}
+	}
+}
+
+// updateMasterBandwidth Adds the closed chunk to the bandwidth window, and sets the measured bandwidths in the master playlist
+func (mg *ManifestGenerator) updateMasterBandwidth(chunkBytes uint64, chunkDurationS float64) {
+	if mg.master == nil || mg.bandwidthWindow <= 0 || chunkDurationS <= 0 {
+		return
+	}
+
+	mg.windowChunkBits = append(mg.windowChunkBits, float64(chunkBytes*8))
+	mg.windowChunkDurS = append(mg.windowChunkDurS, chunkDurationS)
+	if len(mg.windowChunkBits) > mg.bandwidthWindow {
+		mg.windowChunkBits = mg.windowChunkBits[1:]
+		mg.windowChunkDurS = mg.windowChunkDurS[1:]
+	}
+
+	peakBitrate, totalBits, totalDurS := 0.0, 0.0, 0.0
+	for i := range mg.windowChunkBits {
+		peakBitrate = math.Max(peakBitrate, mg.windowChunkBits[i]/mg.windowChunkDurS[i])
+		totalBits = totalBits + mg.windowChunkBits[i]
+		totalDurS = totalDurS + mg.windowChunkDurS[i]
+	}
+
+	err := mg.master.SetVariantBandwidth(mg.masterVariant, int(math.Ceil(peakBitrate)), int(math.Ceil(totalBits/totalDurS)))
+	if err != nil {
+		mg.options.log.Error("Error saving the master playlist. Err: ", err)
+	}
+}
+
+// SetCaptions Enables the captions extraction (CEA-608 in the video stream), every chunk gets a WebVTT file (empty if there are no captions) listed in captionsChunklistFilename
+func (mg *ManifestGenerator) SetCaptions(isEnabled bool, captionsChunklistFilename string) {
+	if !isEnabled {
+		mg.captions = nil
+		return
+	}
+
+	c := captions.New()
+	mg.captions = &c
+
+	// Same media sequence than the media chunklist (the LHLS advanced chunks are not in it)
+	mg.captionsChunklist = hls.New(
+		mg.options.log,
+		mg.options.manifestType,
+		HlsDefaultVersion,
+		true,
+		mg.options.targetSegmentDurS,
+		mg.options.liveWindowSize,
+		path.Join(mg.options.baseOutPath, captionsChunklistFilename),
+		"",
+		mg.options.manifestOutputType,
+		mg.options.httpUploader,
+		mg.options.s3Uploader,
+	)
+	mg.captionsChunklist.SetHTTPServer(mg.options.httpServer)
+	mg.captionsChunklist.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	mg.captionsChunklist.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	mg.captionsChunklist.SetFsync(mg.options.isManifestFsync)
+	mg.captionsChunklist.SetURIPrefix(mg.segmentURIPrefix)
+	mg.captionsChunklist.PinVersion(mg.hlsVersion)
+	mg.captionsChunklist.SetExtinfPrecision(mg.extinfPrecision)
+	mg.captionsChunklist.SetMediaSequence(int64(mg.firstChunkIndex))
+	if mg.chunkRetention != nil {
+		mg.captionsChunklist.SetOnChunksRemoved(newChunkRetention(mg.options.log, mg.chunkRetention.margin).removed)
+	}
+}
+
+// SetIFramesPlaylist Enables the I-frames only chunklist iFramesChunklistFilename (trick play), every keyframe gets a file with the PAT, PMT and its PES. It has to be set before the master playlist
+func (mg *ManifestGenerator) SetIFramesPlaylist(isEnabled bool, iFramesChunklistFilename string) {
+	mg.isIFrames = isEnabled
+	if !isEnabled {
+		return
+	}
+
+	// The window keeps the same time than the chunklist with GOPs of 1s or longer
+	mg.iFramesChunklistFilename = iFramesChunklistFilename
+	mg.iFramesChunklist = hls.New(
+		mg.options.log,
+		mg.options.manifestType,
+		HlsIFramesVersion,
+		true,
+		mg.options.targetSegmentDurS,
+		mg.options.liveWindowSize*int(math.Ceil(mg.options.targetSegmentDurS)),
+		path.Join(mg.options.baseOutPath, iFramesChunklistFilename),
+		"",
+		mg.options.manifestOutputType,
+		mg.options.httpUploader,
+		mg.options.s3Uploader,
+	)
+	mg.iFramesChunklist.SetHTTPServer(mg.options.httpServer)
+	mg.iFramesChunklist.SetOnManifestUpdated(mg.options.onManifestUpdated)
+	mg.iFramesChunklist.SetSecondaryOutputs(mg.options.secondaryManifestOutputs)
+	mg.iFramesChunklist.SetFsync(mg.options.isManifestFsync)
+	mg.iFramesChunklist.SetURIPrefix(mg.segmentURIPrefix)
+	mg.iFramesChunklist.SetIFramesOnly(true)
+	mg.iFramesChunklist.SetExtinfPrecision(mg.extinfPrecision)
+	mg.iFramesChunklist.SetMediaSequence(int64(mg.firstIFrameIndex))
+	if mg.chunkRetention != nil {
+		mg.iFramesChunklist.SetOnChunksRemoved(newChunkRetention(mg.options.log, mg.chunkRetention.margin).removed)
+	}
+}
+
+// addIFramePacket Adds the video packet to the I-frame chunk, a random access point closes the previous one (its duration is the span to this one) and starts a new one, the next PES start completes it
+func (mg *ManifestGenerator) addIFramePacket(isRandomAccess bool) {
+	if isRandomAccess {
+		mg.closeIFrame(mg.lastIDRPTSS, false)
+
+		chunkOptions := mediachunk.Options{
+			Log:                  mg.options.log,
+			OutputType:           mg.wholeFileOutputType(),
+			LHLS:                 false,
+			EstimatedDurationS:   -1,
+			FileNumberLength:     mg.options.fileNumberLength,
+			GhostPrefix:          "",
+			FileExtension:        ChunkFileExtensionDefault,
+			BasePath:             mg.currentShardPath(),
+			ChunkBaseFilename:    IFrameChunkPrefix + mg.options.chunkBaseFilename,
+			HTTPUploader:         mg.options.httpUploader,
+			S3Uploader:           mg.options.s3Uploader,
+			HTTPServer:           mg.options.httpServer,
+			Callbacks:            mg.options.chunkCallbacks,
+			NoFlagFile:           mg.options.isNoFlagFiles,
+			Fsync:                mg.isMediaFsync(mg.iFrameIndex),
+			SecondaryOutputTypes: mg.wholeFileSecondaryOutputTypes(),
+			OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+		}
+		chunk := mediachunk.New(mg.iFrameIndex, chunkOptions)
+		mg.iFrameIndex++
+		mg.iFrameChunk = &chunk
+		mg.iFramePTSS = mg.lastIDRPTSS
+		mg.isIFrameComplete = false
+
+		err := mg.iFrameChunk.InitializeChunk()
+		if err == nil && mg.hasRepeatedPSI {
+			err = mg.iFrameChunk.AddData(mg.repeatedPAT.GetBuffer())
+			if err == nil {
+				err = mg.iFrameChunk.AddData(mg.repeatedPMT.GetBuffer())
+			}
+		}
+		if err == nil {
+			err = mg.iFrameChunk.AddData(mg.tsPacket.GetBuffer())
+		}
+		if err != nil {
+			mg.options.log.Error("Error writing the I-frame chunk ", mg.iFrameChunk.GetFilename(), ". Err: ", err)
+		}
+		return
+	}
+
+	if mg.iFrameChunk == nil || mg.isIFrameComplete {
+		return
+	}
+	if mg.tsPacket.IsPayloadUnitStart() {
+		// Next PES, the keyframe is complete
+		mg.iFrameChunk.Close(-1)
+		mg.isIFrameComplete = true
+		return
+	}
+
+	err := mg.iFrameChunk.AddData(mg.tsPacket.GetBuffer())
+	if err != nil {
+		mg.options.log.Error("Error writing the I-frame chunk ", mg.iFrameChunk.GetFilename(), ". Err: ", err)
+	}
+}
+
+// closeIFrame Closes the I-frame chunk (if not complete yet) and adds it to the I-frames chunklist with the span until endPTSS, it is not added if the span is unknown
+func (mg *ManifestGenerator) closeIFrame(endPTSS float64, isFinal bool) {
+	if mg.iFrameChunk != nil {
+		if !mg.isIFrameComplete {
+			mg.iFrameChunk.Close(-1)
+		}
+
+		durationS := -1.0
+		if mg.iFramePTSS >= 0 && endPTSS >= 0 {
+			durationS = ptsDiffS(mg.iFramePTSS, endPTSS)
+		}
+		if durationS > 0 {
+			err := mg.iFramesChunklist.AddChunk(hls.Chunk{IsGrowing: false, FileName: mg.iFrameChunk.GetFilename(), DurationS: durationS, IsDisco: mg.isNextIFrameDisco}, true)
+			if err != nil {
+				mg.options.log.Error("Error generating / saving the I-frames chunklist. Err: ", err)
+			}
+			mg.isNextIFrameDisco = false
+			mg.updateMasterIFramesBandwidth(mg.iFrameChunk.GetTotalBytes(), durationS)
+		} else {
+			mg.options.log.Warn("I-frame chunk ", mg.iFrameChunk.GetFilename(), " not added to the I-frames chunklist, unknown duration")
+		}
+		mg.iFrameChunk = nil
+	}
+
+	if isFinal && mg.options.manifestType == hls.Vod {
+		mg.iFramesChunklist.CloseManifest(true)
+	}
+}
+
+// updateMasterIFramesBandwidth Adds the I-frame to the bandwidth window, and sets the I-frames chunklist and its peak bandwidth in the master playlist
+func (mg *ManifestGenerator) updateMasterIFramesBandwidth(iFrameBytes int, durationS float64) {
+	if mg.master == nil || mg.masterVariant < 0 {
+		return
+	}
+
+	mg.windowIFrameBits = append(mg.windowIFrameBits, float64(iFrameBytes*8))
+	mg.windowIFrameDurS = append(mg.windowIFrameDurS, durationS)
+	if len(mg.windowIFrameBits) > IFramesBandwidthWindow {
+		mg.windowIFrameBits = mg.windowIFrameBits[1:]
+		mg.windowIFrameDurS = mg.windowIFrameDurS[1:]
+	}
+
+	peakBitrate := 0.0
+	for i := range mg.windowIFrameBits {
+		peakBitrate = math.Max(peakBitrate, mg.windowIFrameBits[i]/mg.windowIFrameDurS[i])
+	}
+
+	err := mg.master.SetVariantIFrames(mg.masterVariant, mg.iFramesURI, int(math.Ceil(peakBitrate)))
+	if err != nil {
+		mg.options.log.Error("Error saving the master playlist. Err: ", err)
+	}
+}
+
+// addCaptionsPacket Adds the video packet payload to the captions extractor
+func (mg *ManifestGenerator) addCaptionsPacket() {
+	codec := captions.CodecH264
+	if mg.videoCodec == VideoCodecHEVC {
+		codec = captions.CodecHEVC
+	} else if mg.videoCodec == VideoCodecMPEG2 {
+		codec = captions.CodecMPEG2
+	}
+	mg.captions.SetCodec(codec)
+
+	mg.captions.AddPayload(mg.tsPacket.GetPayload(), mg.tsPacket.IsPayloadUnitStart(), mg.tsPacket.GetPESPTS())
+}
+
+// saveCaptionsChunk Writes the WebVTT file of the chunk closed (cues from the chunk start PTS to its end) and adds it to the captions chunklist
+func (mg *ManifestGenerator) saveCaptionsChunk(index uint64, chunkDurationS float64, isDisco bool, isFinalChunk bool) {
+	cues := []captions.Cue{}
+	if mg.chunkStartPTSS >= 0 && chunkDurationS >= 0 {
+		cues = mg.captions.Cues(mg.chunkStartPTSS, mg.chunkStartPTSS+chunkDurationS)
+	}
+
+	chunkOptions := mediachunk.Options{
+		Log:                  mg.options.log,
+		OutputType:           mg.wholeFileOutputType(),
+		LHLS:                 false,
+		EstimatedDurationS:   mg.options.targetSegmentDurS,
+		FileNumberLength:     mg.options.fileNumberLength,
+		GhostPrefix:          "",
+		FileExtension:        CaptionsFileExtension,
+		BasePath:             mg.chunkBasePath(index),
+		ChunkBaseFilename:    mg.options.chunkBaseFilename,
+		HTTPUploader:         mg.options.httpUploader,
+		S3Uploader:           mg.options.s3Uploader,
+		HTTPServer:           mg.options.httpServer,
+		Callbacks:            mg.options.chunkCallbacks,
+		NoFlagFile:           mg.options.isNoFlagFiles,
+		Fsync:                mg.isMediaFsync(index),
+		SecondaryOutputTypes: mg.wholeFileSecondaryOutputTypes(),
+		OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+	}
+	chunk := mediachunk.New(index, chunkOptions)
+	err := chunk.InitializeChunk()
+	if err == nil {
+		err = chunk.AddData(captions.WebVTT(cues, mg.chunkStartPTSS))
+	}
+	if err != nil {
+		mg.options.log.Error("Error writing the captions chunk ", chunk.GetFilename(), ". Err: ", err)
+	}
+	chunk.Close(chunkDurationS)
+
+	err = mg.captionsChunklist.AddChunk(hls.Chunk{IsGrowing: false, FileName: chunk.GetFilename(), DurationS: chunkDurationS, IsDisco: isDisco}, true)
+	if err != nil {
+		mg.options.log.Error("Error generating / saving the captions chunklist. Err: ", err)
+	}
+	if isFinalChunk && mg.options.manifestType == hls.Vod {
+		mg.captionsChunklist.CloseManifest(true)
+	}
+}
+
+// lastTimeS Returns the last time seen in the clock used to chunk (PTS for single essence streams, PCR if not), so the chunk closed at a discontinuity includes the data after the last random access point
+func (mg *ManifestGenerator) lastTimeS() float64 {
+	lastTimeS := mg.lastMediaPCRS
+	if mg.isAudioOnly || mg.isVideoOnly {
+		lastTimeS = mg.lastPTSS
+	}
+	if lastTimeS < 0 || mg.lastPCRS < 0 || ptsDiffS(mg.lastPCRS, lastTimeS) < 0 {
+		return mg.lastPCRS
+	}
+
+	return lastTimeS
+}
+
+// setLastPTS Sets the last PES PTS of the PID used for timing, the max PTS of the chunk and the frame duration
+func (mg *ManifestGenerator) setLastPTS(ptsS float64) {
+	if mg.lastPTSS >= 0 {
+		if incS := ptsDiffS(mg.lastPTSS, ptsS); incS > 0 && incS < MaxFrameDurationS && (mg.frameDurationS < 0 || incS < mg.frameDurationS) {
+			mg.frameDurationS = incS
+		}
+	}
+	if mg.chunkMaxPTSS < 0 || ptsDiffS(mg.chunkMaxPTSS, ptsS) > 0 {
+		mg.chunkMaxPTSS = ptsS
+	}
+	mg.lastPTSS = ptsS
+}
+
+// finalChunkEndS Returns the end of the last chunk (started at startS) in its clock: until the end of its last sample (its 1st PTS to the max one plus a frame), not its last random access point. lastS (the last time seen) if the PTS are missing or not consistent with it (more than maxDurationDriftS)
+func (mg *ManifestGenerator) finalChunkEndS(startS float64, lastS float64) float64 {
+	if startS < 0 || lastS < 0 || mg.chunkStartPTSS < 0 || mg.chunkMaxPTSS < 0 {
+		return lastS
+	}
+
+	samplesDurS := ptsDiffS(mg.chunkStartPTSS, mg.chunkMaxPTSS)
+	if mg.frameDurationS > 0 {
+		samplesDurS = samplesDurS + mg.frameDurationS
+	}
+	if samplesDurS < 0 || math.Abs(samplesDurS-ptsDiffS(startS, lastS)) > mg.maxDurationDriftS {
+		return lastS
+	}
+
+	endS := startS + samplesDurS
+	if endS >= tspacket.MaxPCRSValue {
+		endS = endS - tspacket.MaxPCRSValue
+	}
+	return endS
+}
+
+// isTimestampDiscontinuity Returns true if the packet has the discontinuity_indicator set, or its PTS jumps more than maxTimestampJumpS (only the PID used for timing)
+func (mg *ManifestGenerator) isTimestampDiscontinuity(pID int) bool {
+	// Nothing to close since the last one (Ex: the indicator is set in all the PIDs)
+	if mg.chunkStartTimeS < 0 {
+		return false
+	}
+
+	if mg.tsPacket.IsDiscontinuity() {
+		mg.options.log.Info("Discontinuity indicator detected in PID ", pID)
+		return true
+	}
+
+	isTimingPID := pID == mg.options.videoPID || mg.options.videoPID < 0 || (mg.isAudioOnly && pID == mg.options.audioPID)
+	if mg.maxTimestampJumpS <= 0 || !isTimingPID || mg.lastPTSS < 0 {
+		return false
+	}
+	ptsS := mg.tsPacket.GetPESPTS()
+	if ptsS < 0 {
+		return false
+	}
+	if jumpS := ptsDiffS(mg.lastPTSS, ptsS); math.Abs(jumpS) > mg.maxTimestampJumpS {
+		mg.options.log.Warn("PTS jump of ", jumpS, "s in PID ", pID, " (from ", mg.lastPTSS, " to ", ptsS, "), handled as discontinuity")
+		return true
+	}
+
+	return false
+}
+
+// SetSCTE35PID Sets the PID of the SCTE-35 splices signaled as EXT-X-DATERANGE (-1 auto detected from the PMT, 0 disabled)
+func (mg *ManifestGenerator) SetSCTE35PID(pID int) {
+	mg.scte35PID = pID
+	mg.detectedSCTE35PID = pID
+	if pID > 0 {
+		mg.forceProgramDateTime()
+	}
+}
+
+// processSCTE35 Parses the SCTE-35 sections and saves the splices until the chunk that contains them is closed
+func (mg *ManifestGenerator) processSCTE35() {
+	sections := mg.scte35Assembler.AddPayload(mg.tsPacket.GetPayload(), mg.tsPacket.IsPayloadUnitStart())
+
+	for _, section := range sections {
+		info, err := scte35.Parse(section)
+		if err != nil {
+			mg.options.log.Warn("Error parsing SCTE-35 section. Err: ", err)
+			continue
+		}
+		if info.IsCancel {
+			mg.cancelSplice(info.EventID)
+			continue
+		}
+		if info.Signal == scte35.SignalNone {
+			continue
+		}
+
+		// Immediate splices (or without time) are at the current PTS
+		ptsS := info.GetPTSS()
+		if info.IsImmediate || ptsS < 0 {
+			ptsS = mg.lastPTSS
+		}
+
+		isLate := ptsS >= 0 && mg.lastPTSS >= 0 && ptsDiffS(mg.lastPTSS, ptsS) < 0
+		if isLate {
+			mg.options.log.Warn("SCTE-35 splice received after its splice time (insufficient pre-roll), attached to the current chunk. Event ID: ", info.EventID, ", PTS: ", ptsS, ", current PTS: ", mg.lastPTSS)
+		} else {
+			mg.options.log.Info("SCTE-35 splice received. Event ID: ", info.EventID, ", signal: ", info.Signal, ", PTS: ", ptsS, ", current PTS: ", mg.lastPTSS)
+		}
+		mg.pendingSplices = append(mg.pendingSplices, splice{info, ptsS, time.Time{}, isLate})
+	}
+}
+
+// SetSCTE35Cut Enables to start a new chunk at the 1st random access point at or after each SCTE-35 splice point (even if the target duration is not reached)
+func (mg *ManifestGenerator) SetSCTE35Cut(isSCTE35Cut bool) {
+	mg.isSCTE35Cut = isSCTE35Cut
+}
+
+// isSpliceCut Returns true if there are pending splices up to the current random access point, they are moved to it so they are attached to the next chunk
+func (mg *ManifestGenerator) isSpliceCut(durS float64) bool {
+	if !mg.isSCTE35Cut || mg.lastIDRPTSS < 0 {
+		return false
+	}
+
+	isSpliceCut := false
+	for i := range mg.pendingSplices {
+		s := &mg.pendingSplices[i]
+		if s.isLate || s.ptsS < 0 || (mg.chunkStartPTSS >= 0 && ptsDiffS(mg.chunkStartPTSS, s.ptsS) <= 0) || ptsDiffS(mg.lastIDRPTSS, s.ptsS) > 0 {
+			continue
+		}
+		s.ptsS = mg.lastIDRPTSS
+		isSpliceCut = true
+	}
+
+	// Already at the chunk start
+	if !isSpliceCut || durS <= 0 {
+		return false
+	}
+	mg.options.log.Info("Chunk cut at SCTE-35 splice point, PTS: ", mg.lastIDRPTSS)
+
+	return true
+}
+
+// cancelSplice Removes the pending splices of the event
+func (mg *ManifestGenerator) cancelSplice(eventID uint32) {
+	pendingSplices := mg.pendingSplices[:0]
+	for _, s := range mg.pendingSplices {
+		if s.info.EventID != eventID {
+			pendingSplices = append(pendingSplices, s)
+		}
+	}
+
+	if len(pendingSplices) == len(mg.pendingSplices) {
+		mg.options.log.Info("SCTE-35 cancel of event ID ", eventID, " without pending splices")
+	} else {
+		mg.options.log.Info("SCTE-35 splice cancelled. Event ID: ", eventID)
+	}
+	mg.pendingSplices = pendingSplices
+}
+
+// ptsDiffS Returns toS - fromS (PTS or PCR in seconds) across the 33 bits wraparound
+func ptsDiffS(fromS float64, toS float64) float64 {
+	return tspacket.TimeDiffS(fromS, toS, tspacket.MaxPCRSValue)
+}
+
+// takeSplices Returns the date ranges of the pending splices before endPTSS (< 0 all of them) and removes them from pending
+func (mg *ManifestGenerator) takeSplices(endPTSS float64) []hls.DateRange {
+	var dateRanges []hls.DateRange
+
+	pendingSplices := mg.pendingSplices[:0]
+	for _, s := range mg.pendingSplices {
+		if endPTSS >= 0 && ptsDiffS(endPTSS, s.ptsS) >= 0 {
+			pendingSplices = append(pendingSplices, s)
+			continue
+		}
+		dateRanges = append(dateRanges, mg.spliceDateRange(s))
+	}
+	mg.pendingSplices = pendingSplices
+
+	return dateRanges
+}
+
+// spliceStartDate Returns the START-DATE of the splice at ptsS attached to the current chunk: its program date time plus the splice offset from the chunk start (the late splices are at the chunk start)
+func (mg *ManifestGenerator) spliceStartDate(ptsS float64) time.Time {
+	startDate := mg.chunkProgramDateTime
+	if startDate.IsZero() {
+		// No program date time of the chunk (Ex: no DVB time received yet)
+		startDate = time.Now()
+	}
+	if ptsS >= 0 && mg.chunkStartPTSS >= 0 {
+		if offsetS := ptsDiffS(mg.chunkStartPTSS, ptsS); offsetS > 0 {
+			startDate = startDate.Add(time.Duration(offsetS * float64(time.Second)))
+		}
+	}
+
+	return startDate
+}
+
+// spliceDateRange Creates the date range of a splice, the break end uses the same ID and start date than the break start
+func (mg *ManifestGenerator) spliceDateRange(s splice) hls.DateRange {
+	s.startDate = mg.spliceStartDate(s.ptsS)
+	dateRange := hls.DateRange{ID: fmt.Sprintf("splice-%08X", s.info.EventID), StartDate: s.startDate, DurationS: -1, PlannedDurationS: -1}
+	raw := base64.StdEncoding.EncodeToString(s.info.Raw)
+
+	switch s.info.Signal {
+	case scte35.SignalOut:
+		dateRange.SCTE35Out = raw
+		dateRange.PlannedDurationS = s.info.GetDurationS()
+		mg.breakStarts[s.info.EventID] = s
+	case scte35.SignalIn:
+		dateRange.SCTE35In = raw
+		if breakStart, found := mg.breakStarts[s.info.EventID]; found {
+			dateRange.StartDate = breakStart.startDate
+			dateRange.DurationS = ptsDiffS(breakStart.ptsS, s.ptsS)
+			delete(mg.breakStarts, s.info.EventID)
+		}
+	default:
+		dateRange.SCTE35Cmd = raw
+	}
+
+	return dateRange
+}
+
+// GetError Returns the unrecoverable processing error if any (Ex: the selected program is not in the PAT)
+func (mg *ManifestGenerator) GetError() error {
+	return mg.err
+}
+
+// GetResyncs Returns the number of times the TS sync was lost and found again
+func (mg *ManifestGenerator) GetResyncs() uint64 {
+	return mg.resyncs
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func (mg *ManifestGenerator) isSavingMediaPacket() bool {
+	ret := false
+	if !mg.options.autoPIDs {
+		// Manual detection PIDs
+		ret = true
+	} else {
+		if mg.options.chunkInitType == ChunkInit || mg.options.chunkInitType == ChunkInitStart {
+			if mg.initState == InitsavedPMT {
+				ret = true
+			}
+		} else if mg.options.chunkInitType == ChunkNoIni {
+			ret = true
+		}
+	}
+
+	return ret
+}
+
+func (mg *ManifestGenerator) saveInitPacket(tableType packetTableTypes, pckt tspacket.TsPacket) bool {
 	if mg.options.chunkInitType == ChunkInit {
-		return mg.addPacketToInitChunk(tableType)
+		return mg.addPacketToInitChunk(tableType, pckt)
 	} else if mg.options.chunkInitType == ChunkInitStart {
 		if tableType == PatTable || tableType == PmtTable {
-			return mg.saveInitChunkPacket(tableType)
+			return mg.saveInitChunkPacket(tableType, pckt)
 		}
 
 		return false
@@ -274,116 +3545,634 @@ func (mg *ManifestGenerator) processPacket(forceChunk bool) bool {
 		return false
 	}
 
+	if !mg.options.autoPIDs && mg.generatePSI && ((mg.options.chunkInitType != ChunkNoIni && mg.initState == InitNotIni) || ((mg.psiIntervalS > 0 || mg.isIFrames) && !mg.hasRepeatedPSI)) {
+		mg.generateManualPSI()
+	}
+
 	// Detect video & audio PIDs
 	if mg.options.autoPIDs {
-		pmtID := mg.tsPacket.GetPATdata()
+		pmtID := mg.selectProgram(mg.tsPacket.GetPATdata())
 		if pmtID >= 0 {
 			mg.detectedPMTID = pmtID
+			if mg.filterPIDs && !mg.tsPacket.FilterPAT(pmtID) {
+				mg.options.log.Warn("Error filtering the PAT, it is written as is")
+			}
+			if mg.remapPMTPID >= 0 && !mg.tsPacket.RemapPAT(pmtID, mg.remapPMTPID) {
+				mg.options.log.Warn("Error remapping the PAT PMT PID, it is written as is")
+			}
+			mg.lastPATPacket = tspacket.CloneFrom(mg.tsPacket)
 
-			// Save PAT
-			mg.saveInitPacket(PatTable)
+			// Save PAT (the generated one is saved with the PMT)
+			if !mg.generatePSI {
+				mg.saveInitPacket(PatTable, mg.tsPacket)
+			}
 
 			mg.options.log.Debug("Detected PAT. PMT ID: ", pmtID)
 		}
 
 		valid, Videoh264, AudioADTS, Other := mg.tsPacket.GetPMTdata()
 		if valid {
-			if len(Videoh264) > 0 {
-				mg.options.videoPID = int(Videoh264[0])
+			VideoHEVC := mg.tsPacket.GetPMTHEVCPIDs()
+			VideoMPEG2 := mg.tsPacket.GetPMTMPEG2PIDs()
+			video := append(append(append([]uint16{}, Videoh264...), VideoHEVC...), VideoMPEG2...)
+
+			AudioAC3, AudioEAC3 := mg.tsPacket.GetPMTDolbyPIDs()
+			audio, audioCodec := mg.selectAudio(map[AudioCodecs][]uint16{AudioCodecAAC: AudioADTS, AudioCodecAC3: AudioAC3, AudioCodecEAC3: AudioEAC3})
+
+			version := mg.tsPacket.GetPMTVersion()
+			isVersionChange := mg.pmtVersion >= 0 && version != mg.pmtVersion
+			mg.pmtVersion = version
+
+			// PIDs not present in the new version of the PMT are removed
+			videoPID, videoCodec := mg.options.videoPID, mg.videoCodec
+			if len(video) > 0 {
+				videoPID = int(video[0])
+				videoCodec = VideoCodecH264
+				if len(Videoh264) <= 0 && len(VideoHEVC) > 0 {
+					videoCodec = VideoCodecHEVC
+				} else if len(Videoh264) <= 0 {
+					videoCodec = VideoCodecMPEG2
+				}
+			} else if isVersionChange {
+				videoPID = -1
+			}
+			audioPID := mg.options.audioPID
+			if len(audio) > 0 {
+				audioPID = int(audio[0])
+			} else if isVersionChange {
+				audioPID = -1
+				audioCodec = mg.audioCodec
+			}
+
+			mg.pcrPID = mg.tsPacket.GetPMTPCRPID()
+			if mg.isAutoDataPIDs {
+				for _, pID := range mg.tsPacket.GetPMTMetadataPIDs() {
+					if !mg.dataPIDs[int(pID)] {
+						mg.dataPIDs[int(pID)] = true
+						mg.options.log.Info("Detected timed metadata PID: ", pID)
+					}
+				}
+			}
+			if mg.filterPIDs && !mg.tsPacket.FilterPMT(mg.outputPIDs(videoPID, audioPID)) {
+				mg.options.log.Warn("Error filtering the PMT, it is written as is")
+			}
+			if err := mg.remapPMT(videoPID, audioPID); err != nil && mg.err == nil {
+				mg.options.log.Error("Error remapping the PIDs. Err: ", err)
+				mg.err = err
+			}
+			if mg.sampleAES != nil {
+				mg.sampleAESPMT()
+			}
+			if mg.generatePSI {
+				mg.generatePSIPackets(videoPID, audioPID, version)
+			}
+
+			if isVersionChange {
+				mg.pmtVersionChanged(version, videoPID != mg.options.videoPID || videoCodec != mg.videoCodec || audioPID != mg.options.audioPID || audioCodec != mg.audioCodec)
 			}
-			if len(AudioADTS) > 0 {
-				mg.options.audioPID = int(AudioADTS[0])
+
+			mg.setAudioOnly(mg.forcedAudioOnly || (len(video) <= 0 && len(audio) > 0))
+			mg.setVideoOnly(len(video) > 0 && len(audio) <= 0)
+
+			if len(video) > 0 || isVersionChange {
+				mg.options.videoPID = videoPID
+				mg.videoCodec = videoCodec
+				atomic.StoreInt64(&mg.stats.VideoPID, int64(mg.options.videoPID))
+			}
+			if len(audio) > 0 || isVersionChange {
+				mg.options.audioPID = audioPID
+				mg.audioCodec = audioCodec
+				atomic.StoreInt64(&mg.stats.AudioPID, int64(mg.options.audioPID))
+			}
+
+			SCTE35 := mg.tsPacket.GetPMTSCTE35PIDs()
+			if mg.scte35PID < 0 && len(SCTE35) > 0 && mg.detectedSCTE35PID != int(SCTE35[0]) {
+				mg.detectedSCTE35PID = int(SCTE35[0])
+				mg.options.log.Info("Detected SCTE-35 PID: ", mg.detectedSCTE35PID)
+				mg.forceProgramDateTime()
 			}
 
 			// Save PMT
-			mg.saveInitPacket(PmtTable)
+			if mg.generatePSI {
+				mg.saveInitPacket(PatTable, mg.lastPATPacket)
+			}
+			mg.saveInitPacket(PmtTable, mg.initPMTPacket())
+			mg.setRepeatedPSI(mg.lastPATPacket, mg.initPMTPacket())
 
-			mg.options.log.Debug("Detected PMT. VideoIDs: ", Videoh264, "AudiosIDs: ", AudioADTS, "Other: ", Other)
+			mg.options.log.Debug("Detected PMT. VideoIDs: ", Videoh264, "HEVC VideoIDs: ", VideoHEVC, "MPEG-2 VideoIDs: ", VideoMPEG2, "AudiosIDs: ", AudioADTS, "AC-3 AudiosIDs: ", AudioAC3, "E-AC-3 AudiosIDs: ", AudioEAC3, "Other: ", Other)
 		}
 	}
 
 	pID := mg.tsPacket.GetPID()
+	mg.chunkInputBytes = mg.chunkInputBytes + uint64(tspacket.TsDefaultPacketSize)
+
+	mg.checkContinuity(pID)
+
+	// Timestamps discontinuity (Ex: source switched), the current chunk is closed before this packet
+	if pID >= 0 && (pID == mg.options.videoPID || pID == mg.options.audioPID) && mg.isSavingMediaPacket() && mg.isTimestampDiscontinuity(pID) {
+		mg.discontinuity()
+	}
+	if pID >= 0 && (pID == mg.options.videoPID || pID == mg.options.audioPID) {
+		if pcrS := mg.tsPacket.GetPCRS(); pcrS >= 0 {
+			mg.lastMediaPCRS = pcrS
+		}
+	}
+	if pID >= 0 && (pID == mg.pcrPID || (mg.pcrPID < 0 && (pID == mg.options.videoPID || pID == mg.options.audioPID))) {
+		if pcrS := mg.tsPacket.GetPCRS(); pcrS >= 0 {
+			mg.lastClockPCRS = pcrS
+		}
+	}
+
 	if pID == mg.options.videoPID {
 		if mg.isSavingMediaPacket() {
 			// Detect if we need to chunk it
 			// It will chunk if detect an IDR point with PCR data
-			if mg.tsPacket.IsRandomAccess(mg.options.videoPID) == true {
+			ptsS := mg.tsPacket.GetPESPTS()
+			if ptsS >= 0 {
+				mg.setLastPTS(ptsS)
+			}
+			if mg.captions != nil {
+				// Before chunking, so the previous PES captions are in the closed chunk
+				mg.addCaptionsPacket()
+			}
+			if mg.fmp4Muxer != nil && mg.tsPacket.IsPayloadUnitStart() {
+				// Before chunking, so the previous PES sample (with its duration) is in the closed chunk
+				mg.fmp4Muxer.EndPES(fmp4.TrackVideo, mg.tsPacket.GetPESDTS())
+			}
+			if mg.sampleAES != nil && mg.tsPacket.IsPayloadUnitStart() {
+				// Before chunking, so the previous PES is in the closed chunk
+				mg.endSampleAESPES(pID)
+			}
+			isRandomAccess := mg.isVideoRandomAccess()
+			if isRandomAccess {
 				mg.options.log.Debug("VIDEO: ", mg.tsPacket.String())
-				pcrS := mg.tsPacket.GetPCRS()
-				if pcrS >= 0 {
-					mg.lastPCRS = pcrS
-
-					if mg.chunkStartTimeS < 0 && pcrS >= 0 {
-						mg.chunkStartTimeS = pcrS
-					}
-					durS := pcrS - mg.chunkStartTimeS
-					if (durS + ChunkLengthToleranceS) > mg.options.targetSegmentDurS {
-						_, nextInitialPCRS := mg.nextChunk(pcrS, mg.chunkStartTimeS, tspacket.MaxPCRSValue, false)
-
-						mg.chunkStartTimeS = nextInitialPCRS
-					}
+				mg.lastIDRPTSS = mg.lastPTSS
+				timeS := mg.tsPacket.GetPCRS()
+				if mg.isVideoOnly {
+					// No audio to align with, use the IDR PTS
+					timeS = mg.tsPacket.GetPESPTS()
+				}
+				mg.stopDroppingToRAP()
+				if !mg.isAudioOnly {
+					mg.chunkIfNeeded(timeS)
 				}
 			}
-			mg.addPacketToChunk()
+			if mg.isMaxSegmentDurReached() {
+				mg.forceChunk()
+			}
+			if !mg.isDroppingToRAP {
+				mg.addPacketToChunk()
+				mg.checkChunkVideoStart(isRandomAccess)
+				if isRandomAccess {
+					mg.chunkKeyframes++
+				}
+				if mg.isIFrames && !mg.isAudioOnly {
+					// After adding it, so the packet has the remapped PID
+					mg.addIFramePacket(isRandomAccess)
+				}
+			} else {
+				mg.droppedPackets++
+			}
 
 		} else {
 			mg.options.log.Debug("SKIPPED VIDEO PACKET, not init: ", mg.tsPacket.String())
 		}
 	} else if pID == mg.options.audioPID {
 		if mg.isSavingMediaPacket() {
-			mg.addPacketToChunk()
+			if mg.fmp4Muxer != nil && mg.tsPacket.IsPayloadUnitStart() {
+				mg.fmp4Muxer.EndPES(fmp4.TrackAudio, -1)
+			}
+			if mg.isAudioOnly || mg.options.videoPID < 0 {
+				// Audio only, it will chunk at the 1st PES (with PTS) after target duration
+				ptsS := mg.tsPacket.GetPESPTS()
+				if ptsS >= 0 {
+					mg.setLastPTS(ptsS)
+					if mg.isAudioOnly {
+						mg.lastIDRPTSS = ptsS
+						mg.stopDroppingToRAP()
+						mg.chunkIfNeeded(ptsS)
+					}
+				}
+			}
+			if mg.isMaxSegmentDurReached() {
+				mg.forceChunk()
+			}
+			if !mg.isDroppingToRAP {
+				mg.addPacketToChunk()
+			} else {
+				mg.droppedPackets++
+			}
 			mg.options.log.Debug("AUDIO: ", mg.tsPacket.String())
 		} else {
 			mg.options.log.Debug("SKIPPED AUDIO PACKET, not init: ", mg.tsPacket.String())
 		}
+	} else if pID > 0 && pID == mg.detectedSCTE35PID {
+		mg.processSCTE35()
+		if mg.isExtraOutputPID(pID) && mg.isSavingMediaPacket() {
+			mg.addPacketToChunk()
+		}
+	} else if pID == dvbtime.TimePID && mg.pdtSource == PDTSourceDVB {
+		mg.processDVBTime()
+		if mg.isExtraOutputPID(pID) && mg.isSavingMediaPacket() {
+			mg.addPacketToChunk()
+		}
+	} else if pID == tspacket.NullPID && mg.isSavingMediaPacket() {
+		if mg.stripNullPackets {
+			atomic.AddUint64(&mg.stats.NullPackets, 1)
+		} else {
+			// Padding passed through, the chunks keep the CBR
+			mg.addPacketToChunk()
+		}
 	} else if pID >= 0 {
+		if mg.dataPIDs[pID] && mg.isSavingMediaPacket() {
+			mg.addDataPacket(pID)
+		} else if mg.isExtraOutputPID(pID) && mg.isSavingMediaPacket() {
+			mg.addPacketToChunk()
+		}
 		mg.options.log.Debug("OTHER: ", mg.tsPacket.String())
 	} else {
-		fmt.Println("OUT OF SYNC!!!")
+		mg.options.log.Error("OUT OF SYNC!!!")
+		return false
+	}
+
+	return true
+}
+
+// SetPreferredAudioCodec Sets the audio codec to select when the PMT has several (Ex: AAC and AC-3), by default AAC
+func (mg *ManifestGenerator) SetPreferredAudioCodec(codec AudioCodecs) {
+	mg.preferredAudioCodec = codec
+}
+
+// GetAudioCodec Returns the codec of the detected audio PID (AAC in manual PID mode)
+func (mg *ManifestGenerator) GetAudioCodec() AudioCodecs {
+	return mg.audioCodec
+}
+
+// selectAudio Returns the audio PIDs of the preferred codec, if there are none the 1st codec with PIDs (AAC, AC-3, E-AC-3)
+func (mg *ManifestGenerator) selectAudio(audioPIDs map[AudioCodecs][]uint16) ([]uint16, AudioCodecs) {
+	for _, codec := range []AudioCodecs{mg.preferredAudioCodec, AudioCodecAAC, AudioCodecAC3, AudioCodecEAC3} {
+		if len(audioPIDs[codec]) > 0 {
+			return audioPIDs[codec], codec
+		}
+	}
+
+	return nil, AudioCodecAAC
+}
+
+// pmtVersionChanged Closes the current chunk and starts a discontinuity if the selected PIDs / codecs changed, the init data (PAT + PMT) is captured again from the new PMT. Other changes are only logged
+func (mg *ManifestGenerator) pmtVersionChanged(version int, isSelectionChange bool) {
+	if !isSelectionChange {
+		mg.options.log.Info("PMT version changed to ", version, ", the selected PIDs are not affected")
+		if mg.options.chunkInitType == ChunkInitStart && mg.initState == InitsavedPMT {
+			mg.tsInitPMTPacket = tspacket.CloneFrom(mg.initPMTPacket())
+		}
+		return
+	}
+
+	mg.options.log.Warn("PMT version changed to ", version, ", the selected PIDs changed. Starting a discontinuity")
+	mg.discontinuity()
+
+	if mg.initState != InitsavedPMT {
+		// Init data not captured yet
+		return
+	}
+
+	// The PMT (this packet) is saved after the PAT
+	if mg.options.chunkInitType == ChunkInit {
+		mg.createChunk(true)
+		err := mg.initChunk.AddData(mg.lastPATPacket.GetBuffer())
+		if err != nil {
+			panic(err)
+		}
+		mg.initState = InitsavedPAT
+	} else if mg.options.chunkInitType == ChunkInitStart {
+		mg.tsInitPATPacket = mg.lastPATPacket
+		mg.initState = InitsavedPAT
+	}
+}
+
+// isVideoRandomAccess Returns true if the current packet starts a video random access point (legal chunk cut point)
+func (mg *ManifestGenerator) isVideoRandomAccess() bool {
+	switch mg.videoCodec {
+	case VideoCodecHEVC:
+		if mg.isReplayingHEVCStart {
+			// Known from the held packets
+			return mg.hevcStartRandomAccess
+		}
+		return mg.tsPacket.IsHEVCRandomAccess(mg.options.videoPID)
+	case VideoCodecMPEG2:
+		return mg.tsPacket.IsMPEG2RandomAccess(mg.options.videoPID)
+	}
+
+	return mg.tsPacket.IsRandomAccess(mg.options.videoPID)
+}
+
+// holdHEVCPacket Holds the packet while the 1st VCL NAL unit of a HEVC video PES is not found (Ex: large parameter sets or SEI in the PES start packet), the held packets are processed when its NAL unit type says if it is a random access point. Returns true if the packet was held (it is processed later)
+func (mg *ManifestGenerator) holdHEVCPacket() bool {
+	if mg.videoCodec != VideoCodecHEVC || !mg.tsPacket.Parse(mg.detectedPMTID) {
+		mg.releaseHEVCPackets(false)
+		return false
+	}
+
+	isVideo := mg.tsPacket.GetPID() == mg.options.videoPID
+	if len(mg.hevcHeldPackets) <= 0 {
+		if !isVideo {
+			return false
+		}
+		if _, isKnown := mg.tsPacket.GetHEVCRandomAccess(mg.options.videoPID); isKnown {
+			return false
+		}
+		mg.hevcHeldES = append(mg.hevcHeldES[:0], mg.tsPacket.GetESData()...)
+		mg.hevcHeldPackets = append(mg.hevcHeldPackets, append([]byte{}, mg.tsPacket.GetBuffer()...))
+		return true
+	}
+
+	if isVideo && mg.tsPacket.IsPayloadUnitStart() {
+		// The held PES has no VCL NAL unit
+		mg.releaseHEVCPackets(false)
+		return mg.holdHEVCPacket()
+	}
+
+	mg.hevcHeldPackets = append(mg.hevcHeldPackets, append([]byte{}, mg.tsPacket.GetBuffer()...))
+	if isVideo {
+		mg.hevcHeldES = append(mg.hevcHeldES, mg.tsPacket.GetESData()...)
+		if nalType, found := tspacket.FindHEVCVCLType(mg.hevcHeldES); found {
+			mg.releaseHEVCPackets(tspacket.IsHEVCIRAPType(nalType))
+			return true
+		}
+	}
+	if len(mg.hevcHeldPackets) >= MaxHEVCHeldPackets {
+		mg.options.log.Warn("VCL NAL unit of the HEVC PES not found in ", len(mg.hevcHeldPackets), " packets, it is not a random access point")
+		mg.releaseHEVCPackets(false)
+	}
+
+	return true
+}
+
+// releaseHEVCPackets Processes the held packets in order, the 1st one (the PES start) with the random access result. The current packet is kept
+func (mg *ManifestGenerator) releaseHEVCPackets(isRandomAccess bool) {
+	if len(mg.hevcHeldPackets) <= 0 {
+		return
+	}
+
+	current := append([]byte{}, mg.tsPacket.GetBuffer()...)
+	packets := mg.hevcHeldPackets
+	mg.hevcHeldPackets = [][]byte{}
+	for i, pckt := range packets {
+		mg.tsPacket.Reset()
+		mg.tsPacket.AddData(pckt)
+		mg.isReplayingHEVCStart = i == 0
+		mg.hevcStartRandomAccess = isRandomAccess
+		mg.processPacket(false)
+		mg.processedPackets++
+		mg.countPacket()
+	}
+	mg.isReplayingHEVCStart = false
+
+	mg.tsPacket.Reset()
+	mg.tsPacket.AddData(current)
+	mg.tsPacket.Parse(mg.detectedPMTID)
+}
+
+// chunkIfNeeded Creates a new chunk if the current one reached the target duration (timeS is the PCR, or the PTS for audio only / video only, < 0 if it is missing)
+func (mg *ManifestGenerator) chunkIfNeeded(timeS float64) {
+	if mg.durationSource == DurationSourcePCR {
+		timeS = mg.lastClockPCRS
+	}
+	if timeS >= 0 {
+		mg.lastPCRS = timeS
+	}
+
+	if mg.chunkStartTimeS < 0 && mg.chunkStartClockPCRS < 0 {
+		mg.chunkStartTimeS = timeS
+		mg.chunkStartClockPCRS = mg.lastClockPCRS
+		mg.chunkStartPTSS = mg.lastIDRPTSS
+		mg.chunkMaxPTSS = mg.lastIDRPTSS
+	}
+	startS, endS, source := mg.durationClock(timeS)
+	if startS < 0 || endS < 0 {
+		return
+	}
+	durS := ptsDiffS(startS, endS)
+	if mg.minSegmentDurS > 0 && (durS+ChunkLengthToleranceS) < mg.minSegmentDurS {
+		// Too short, the pending splices are kept for the next random access point
+		return
+	}
+	if mg.isSpliceCut(durS) || (durS+ChunkLengthToleranceS) > mg.options.targetSegmentDurS {
+		mg.options.log.Debug("Chunk duration source: ", source, ". DurS: ", durS)
+		mg.nextChunk(endS, startS, tspacket.MaxPCRSValue, false)
+
+		mg.chunkStartTimeS = timeS
+		mg.chunkStartClockPCRS = mg.lastClockPCRS
+		mg.chunkStartPTSS = mg.lastIDRPTSS
+		mg.chunkMaxPTSS = mg.lastIDRPTSS
+	}
+}
+
+// durationClock Returns the chunk start and the current time in the clock used for the chunk duration, the auto source uses the PCR PID if timeS is missing or its elapsed time differs from the PCR more than maxDurationDriftS
+func (mg *ManifestGenerator) durationClock(timeS float64) (startS float64, endS float64, source string) {
+	source = "PCR"
+	if mg.durationSource == DurationSourcePCR {
+		source = "PCR PID"
+	} else if mg.isAudioOnly || mg.isVideoOnly {
+		source = "PTS"
+	}
+	if mg.durationSource == DurationSourcePCR || mg.chunkStartClockPCRS < 0 || mg.lastClockPCRS < 0 {
+		return mg.chunkStartTimeS, timeS, source
+	}
+
+	clockDurS := ptsDiffS(mg.chunkStartClockPCRS, mg.lastClockPCRS)
+	if timeS < 0 || mg.chunkStartTimeS < 0 {
+		return mg.chunkStartClockPCRS, mg.lastClockPCRS, "PCR PID (" + source + " missing)"
+	}
+	if math.Abs(ptsDiffS(mg.chunkStartTimeS, timeS)-clockDurS) > mg.maxDurationDriftS {
+		return mg.chunkStartClockPCRS, mg.lastClockPCRS, "PCR PID (" + source + " not consistent with the PCR)"
+	}
+
+	return mg.chunkStartTimeS, timeS, source
+}
+
+// isMaxSegmentDurReached Returns true if the current chunk is longer than the max chunk duration (measured with the clock used to chunk)
+func (mg *ManifestGenerator) isMaxSegmentDurReached() bool {
+	if mg.maxSegmentDurS <= 0 || mg.isDroppingToRAP || mg.chunkStartTimeS < 0 {
+		return false
+	}
+	timeS := mg.lastTimeS()
+	if timeS < 0 {
 		return false
 	}
 
-	return true
+	return ptsDiffS(mg.chunkStartTimeS, timeS) > mg.maxSegmentDurS
+}
+
+// forceChunk Cuts the current chunk before this packet (no random access point), in drop mode the data is dropped until the next random access point
+func (mg *ManifestGenerator) forceChunk() {
+	timeS := mg.lastTimeS()
+	mg.lastPCRS = timeS
+	reason := "no random access point in " + fmt.Sprintf("%.3f", mg.maxSegmentDurS) + "s (maxSegmentDurS)"
+
+	if mg.maxSegmentDurAction == MaxSegmentDurDrop {
+		mg.options.log.Warn("Forced chunk cut at PTS: ", mg.lastPTSS, ", reason: ", reason, ". Dropping data until the next random access point")
+		mg.nextChunk(timeS, mg.chunkStartTimeS, tspacket.MaxPCRSValue, false)
+
+		// The next chunk starts at the random access point
+		mg.chunkStartTimeS = -1.0
+		mg.chunkStartClockPCRS = -1.0
+		mg.chunkStartPTSS = -1.0
+		mg.chunkMaxPTSS = -1.0
+		mg.isDroppingToRAP = true
+		mg.droppedPackets = 0
+		return
+	}
+
+	mg.options.log.Warn("Forced chunk cut at PTS: ", mg.lastPTSS, ", reason: ", reason)
+	_, nextInitialPCRS := mg.nextChunk(timeS, mg.chunkStartTimeS, tspacket.MaxPCRSValue, false)
+
+	mg.chunkStartTimeS = nextInitialPCRS
+	mg.chunkStartClockPCRS = mg.lastClockPCRS
+	mg.chunkStartPTSS = mg.lastPTSS
+	mg.chunkMaxPTSS = mg.lastPTSS
+}
+
+// stopDroppingToRAP Stops dropping data, the current packet is a random access point
+func (mg *ManifestGenerator) stopDroppingToRAP() {
+	if !mg.isDroppingToRAP {
+		return
+	}
+
+	mg.options.log.Info("Random access point found at PTS: ", mg.lastPTSS, ", dropped ", mg.droppedPackets, " packets")
+	mg.isDroppingToRAP = false
 }
 
 func (mg *ManifestGenerator) addPacketToChunk() {
+	if mg.fmp4Muxer != nil {
+		mg.addPacketToFMP4()
+		return
+	}
+	if pID := mg.tsPacket.GetPID(); mg.sampleAES != nil && (pID == mg.options.videoPID || pID == mg.options.audioPID) {
+		mg.addPacketToSampleAES()
+		return
+	}
+	if pID := mg.tsPacket.GetPID(); mg.remappedPID(pID) != pID {
+		mg.tsPacket.SetPID(mg.remappedPID(pID))
+	}
+	mg.addBufferToChunk(mg.tsPacket.GetBuffer())
+}
 
+// addBufferToChunk Adds the packet data to the current chunk (creating it if needed), in ChunkInitStart mode the PAT and PMT are added before the 1st packet
+func (mg *ManifestGenerator) addBufferToChunk(buf []byte) {
+	if mg.fmp4Muxer != nil {
+		// Only the video and audio are written in CMAF
+		return
+	}
 	if mg.currentChunks == nil {
 		mg.createChunk(false)
 	}
 
 	if len(mg.currentChunks) > 0 {
+		if mg.currentChunks[0].IsEmpty() {
+			mg.startChunkProgramDateTime()
+			mg.chunkWrittenAt = time.Now()
+		}
+		if mg.partDurationS > 0 {
+			mg.partIfNeeded()
+		}
 
 		//In case we need to save PAT and PMT do it just before the 1st packet
-		if mg.options.chunkInitType == ChunkInitStart && mg.currentChunks[0].IsEmpty() {
+		if mg.psiIntervalS > 0 {
+			// Also at the start of the chunk, with the repetitions continuity counters
+			mg.addRepeatedPSIIfNeeded()
+		} else if mg.options.chunkInitType == ChunkInitStart && mg.currentChunks[0].IsEmpty() {
 			// Save PAT and PMT first if available
 			if mg.initState == InitsavedPMT {
-				mg.currentChunks[0].AddData(mg.tsInitPATPacket.GetBuffer())
-				mg.currentChunks[0].AddData(mg.tsInitPMTPacket.GetBuffer())
+				mg.addChunkData(mg.tsInitPATPacket.GetBuffer())
+				mg.addChunkData(mg.tsInitPMTPacket.GetBuffer())
+				mg.chunkOutputBytes = mg.chunkOutputBytes + uint64(2*tspacket.TsDefaultPacketSize)
+			} else {
+				mg.isChunkIndependent = false
 			}
 		}
 
-		err := mg.currentChunks[0].AddData(mg.tsPacket.GetBuffer())
+		err := mg.addChunkData(buf)
+		if err != nil {
+			panic(err)
+		}
+		mg.chunkOutputBytes = mg.chunkOutputBytes + uint64(tspacket.TsDefaultPacketSize)
+	}
+}
+
+// addPacketToFMP4 Adds the video or audio packet payload to the CMAF muxer (creating the chunk if needed), the other PIDs are not written
+func (mg *ManifestGenerator) addPacketToFMP4() {
+	pID := mg.tsPacket.GetPID()
+	trackType, isSupported := fmp4.TrackVideo, mg.videoCodec == VideoCodecH264
+	if pID == mg.options.audioPID {
+		trackType, isSupported = fmp4.TrackAudio, mg.audioCodec == AudioCodecAAC
+	} else if pID != mg.options.videoPID {
+		return
+	}
+	if !isSupported {
+		if mg.err == nil {
+			mg.err = errors.New("CMAF chunks only support H.264 video and AAC audio")
+			mg.options.log.Error("Error writing the CMAF chunks. Err: ", mg.err)
+		}
+		return
+	}
+
+	if mg.currentChunks == nil {
+		mg.createChunk(false)
+	}
+	if mg.isCurrentChunkEmpty() {
+		mg.startChunkProgramDateTime()
+	}
+
+	payload := mg.tsPacket.GetPayload()
+	mg.fmp4Muxer.AddPayload(trackType, payload, mg.tsPacket.IsPayloadUnitStart(), mg.tsPacket.GetPESPTS(), mg.tsPacket.GetPESDTS())
+	mg.chunkOutputBytes = mg.chunkOutputBytes + uint64(len(payload))
+}
+
+// writeFMP4Fragment Writes the CMAF fragment of the samples of the chunk being closed (all the pending ones in the final chunk), after a new init segment if the tracks configuration changed
+func (mg *ManifestGenerator) writeFMP4Fragment(isFinalChunk bool) {
+	if isFinalChunk {
+		mg.fmp4Muxer.EndPES(fmp4.TrackVideo, -1)
+		mg.fmp4Muxer.EndPES(fmp4.TrackAudio, -1)
+	}
+
+	if init := mg.fmp4Muxer.InitSegment(); init != nil && !bytes.Equal(init, mg.fmp4Init) {
+		mg.createChunk(true)
+		err := mg.initChunk.AddData(init)
 		if err != nil {
 			panic(err)
 		}
+		mg.closeChunk(true, -1, false)
+		mg.fmp4Init = init
+	}
+
+	mg.chunkOutputBytes = 0
+	fragment := mg.fmp4Muxer.Fragment()
+	if fragment == nil {
+		return
 	}
+	err := mg.addChunkData(fragment)
+	if err != nil {
+		panic(err)
+	}
+	mg.chunkOutputBytes = uint64(len(fragment))
 }
 
-func (mg *ManifestGenerator) saveInitChunkPacket(tableType packetTableTypes) bool {
+func (mg *ManifestGenerator) saveInitChunkPacket(tableType packetTableTypes, pckt tspacket.TsPacket) bool {
 	ret := false
 
 	if tableType == PatTable {
 		if mg.initState == InitNotIni {
 			// Save PAT
-			mg.tsInitPATPacket = tspacket.CloneFrom(mg.tsPacket)
+			mg.tsInitPATPacket = tspacket.CloneFrom(pckt)
 			mg.initState = InitsavedPAT
 			ret = true
 		}
 	} else if tableType == PmtTable {
 		if mg.initState == InitsavedPAT {
 			// Save PMT
-			mg.tsInitPMTPacket = tspacket.CloneFrom(mg.tsPacket)
+			mg.tsInitPMTPacket = tspacket.CloneFrom(pckt)
 			mg.initState = InitsavedPMT
 			ret = true
 		}
@@ -392,7 +4181,7 @@ func (mg *ManifestGenerator) saveInitChunkPacket(tableType packetTableTypes) boo
 	return ret
 }
 
-func (mg *ManifestGenerator) addPacketToInitChunk(tableType packetTableTypes) bool {
+func (mg *ManifestGenerator) addPacketToInitChunk(tableType packetTableTypes, pckt tspacket.TsPacket) bool {
 	ret := false
 	saveData := false
 
@@ -411,7 +4200,7 @@ func (mg *ManifestGenerator) addPacketToInitChunk(tableType packetTableTypes) bo
 	}
 
 	if saveData {
-		err := mg.initChunk.AddData(mg.tsPacket.GetBuffer())
+		err := mg.initChunk.AddData(pckt.GetBuffer())
 		if err != nil {
 			panic(err)
 		}
@@ -434,14 +4223,271 @@ func (mg *ManifestGenerator) hlsClose() {
 	mg.hlsChunklist.CloseManifest(true)
 }
 
-func (mg *ManifestGenerator) hlsAddChunk(isGrowing bool, fileName string, durationS float64, isDisco bool) {
+func (mg *ManifestGenerator) hlsAddChunk(isGrowing bool, fileName string, durationS float64, bytes uint64, isDisco bool, dateRanges []hls.DateRange, programDateTime time.Time, byteRangeOffset int64, byteRangeLength int64, key *hls.Key) {
+
+	isGap := mg.uploadFailures.takeFileName(fileName)
+	if isGap {
+		mg.countGap(fileName)
+	}
+	if !isGrowing && mg.isNextChunkGap {
+		// Failed the verification
+		isGap = true
+		mg.isNextChunkGap = false
+	}
 
-	err := mg.hlsChunklist.AddChunk(hls.Chunk{IsGrowing: isGrowing, FileName: fileName, DurationS: durationS, IsDisco: isDisco}, true)
+	err := mg.hlsChunklist.AddChunk(hls.Chunk{IsGrowing: isGrowing, FileName: fileName, DurationS: durationS, IsDisco: isDisco, DateRanges: dateRanges, ProgramDateTime: programDateTime, ByteRangeLength: byteRangeLength, ByteRangeOffset: byteRangeOffset, Key: key, IsGap: isGap, Bytes: bytes}, true)
 	if err != nil {
 		mg.options.log.Error("Error generating / saving the chunklists. Err: ", err)
 	}
 }
 
+// SetChunkRetention Deletes the local media files (chunks, parts, captions and I-frames) when they are out of the live window, after margin more chunks (safety for the players still downloading them). Only for the live window chunklist with file output, the deletion errors are logged
+func (mg *ManifestGenerator) SetChunkRetention(isEnabled bool, margin int) error {
+	if !isEnabled {
+		return nil
+	}
+	if mg.options.manifestType != hls.LiveWindow {
+		return errors.New("chunk retention is only for the live window chunklist, the VOD / event chunks are never deleted")
+	}
+	if mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile {
+		return errors.New("chunk retention is only for the file output of the chunks (not byte range)")
+	}
+	if margin < 0 {
+		return fmt.Errorf("invalid chunk retention margin %d", margin)
+	}
+
+	mg.chunkRetention = newChunkRetention(mg.options.log, margin)
+	mg.hlsChunklist.SetOnChunksRemoved(mg.chunkRetention.removed)
+	if mg.captions != nil {
+		mg.captionsChunklist.SetOnChunksRemoved(newChunkRetention(mg.options.log, margin).removed)
+	}
+	if mg.isIFrames {
+		mg.iFramesChunklist.SetOnChunksRemoved(newChunkRetention(mg.options.log, margin).removed)
+	}
+	return nil
+}
+
+// SetGapOnUploadFailure Flags the chunks whose upload (S3 / HTTP) failed after its retries as EXT-X-GAP, also if they are already in the saved chunklist
+func (mg *ManifestGenerator) SetGapOnUploadFailure(isEnabled bool) {
+	mg.uploadFailures.mutex.Lock()
+	defer mg.uploadFailures.mutex.Unlock()
+	mg.uploadFailures.isGap = isEnabled
+}
+
+// MarkUploadGaps Flags as EXT-X-GAP the chunks already in the chunklist whose upload failed, it is done after every chunk and it can be called after Close (Ex: when the chunked transfer uploads finish)
+func (mg *ManifestGenerator) MarkUploadGaps() {
+	for _, fileName := range mg.uploadFailures.take() {
+		found, err := mg.hlsChunklist.SetChunkGap(fileName, true)
+		if err != nil {
+			mg.options.log.Error("Error generating / saving the chunklists. Err: ", err)
+		}
+		if found {
+			mg.countGap(fileName)
+		} else {
+			mg.options.log.Warn("Upload of chunk ", fileName, " failed, it is not in the chunklist")
+		}
+	}
+}
+
+// SetFinalizeAsVod Converts the EVENT chunklists to VOD (EXT-X-PLAYLIST-TYPE:VOD, EXT-X-ENDLIST and no live only tags) at Close, they are saved again to the same destination. Before it waits (up to uploadsTimeout) for the chunked transfer uploads in progress, and if any chunk upload failed they are not finalized
+func (mg *ManifestGenerator) SetFinalizeAsVod(isEnabled bool, uploadsTimeout time.Duration) {
+	mg.finalizeAsVod = isEnabled
+	mg.finalizeUploadsTimeout = uploadsTimeout
+}
+
+// finalizeVod Converts the EVENT chunklists to VOD if all the chunks were uploaded
+func (mg *ManifestGenerator) finalizeVod() {
+	if mg.options.manifestType != hls.LiveEvent {
+		mg.options.log.Warn("Only EVENT chunklists can be finalized as VOD, manifest type ", mg.options.manifestType)
+		return
+	}
+
+	if mg.options.httpUploader != nil && !mg.options.httpUploader.WaitPendingUploads(mg.finalizeUploadsTimeout) {
+		mg.options.log.Error("Chunklist not finalized as VOD, timeout waiting for the chunk uploads in progress")
+		return
+	}
+	mg.MarkUploadGaps()
+	if failed := mg.uploadFailures.failedUploads(); failed > 0 {
+		mg.options.log.Error("Chunklist not finalized as VOD, the upload of ", failed, " chunks failed")
+		return
+	}
+
+	mg.hlsChunklist.ConvertToVod()
+	if mg.isIFrames {
+		mg.iFramesChunklist.ConvertToVod()
+	}
+	if mg.captions != nil {
+		mg.captionsChunklist.ConvertToVod()
+	}
+	mg.closeChunklists()
+	mg.options.log.Info("Chunklist finalized as VOD")
+}
+
+// SetEndlist Sets the EXT-X-ENDLIST of the live chunklists at Close (grace is only used by EndlistAfterGrace), they are saved to their destination
+func (mg *ManifestGenerator) SetEndlist(mode EndlistModes, grace time.Duration) {
+	mg.endlistMode = mode
+	mg.endlistGrace = grace
+}
+
+// WaitEndlist Waits for the EXT-X-ENDLIST grace period (after Close), it returns when it is added or canceled
+func (mg *ManifestGenerator) WaitEndlist() {
+	mg.pendingEndlist.wait()
+}
+
+// CancelEndlist Cancels the EXT-X-ENDLIST waiting for the grace period, the chunklists are left open
+func (mg *ManifestGenerator) CancelEndlist() {
+	mg.pendingEndlist.cancel()
+}
+
+// closeChunklists Adds the EXT-X-ENDLIST to the chunklists and saves them
+func (mg *ManifestGenerator) closeChunklists() {
+	if err := mg.hlsChunklist.CloseManifest(true); err != nil {
+		mg.options.log.Error("Error saving the closed chunklist. Err: ", err)
+	}
+	if mg.isIFrames {
+		if err := mg.iFramesChunklist.CloseManifest(true); err != nil {
+			mg.options.log.Error("Error saving the closed I-frames chunklist. Err: ", err)
+		}
+	}
+	if mg.captions != nil {
+		if err := mg.captionsChunklist.CloseManifest(true); err != nil {
+			mg.options.log.Error("Error saving the closed captions chunklist. Err: ", err)
+		}
+	}
+}
+
+// SetChunkVerification Re-reads every media chunk (file and byte range outputs, TS chunks) when it is closed and checks it before it is added to the chunklist: whole packets with the sync byte, the number of packets written, the start (PAT / PMT in ChunkInitStart mode and the keyframe if it was cut at one) and the PTS span against the EXTINF (within toleranceS). The failures are logged and counted (Stats.VerifyFailures), with isGap the chunk is flagged as EXT-X-GAP. The AES-128 chunks are not TS packets, they are not checked
+func (mg *ManifestGenerator) SetChunkVerification(isEnabled bool, toleranceS float64, isGap bool) error {
+	mg.chunkVerification = nil
+	if !isEnabled {
+		return nil
+	}
+	if mg.options.chunkOutputType != mediachunk.ChunkOutputModeFile && mg.options.chunkOutputType != mediachunk.ChunkOutputModeFileByteRange {
+		return errors.New("chunk verification is only for the file outputs")
+	}
+	if mg.fmp4Muxer != nil {
+		return errors.New("chunk verification is only for the TS chunks")
+	}
+	if toleranceS < 0 {
+		return fmt.Errorf("invalid chunk verification tolerance %f", toleranceS)
+	}
+
+	mg.chunkVerification = &chunkVerification{toleranceS, isGap}
+	return nil
+}
+
+// verifyChunk Re-reads and checks the closed chunk, returns false if it failed (logged and counted)
+func (mg *ManifestGenerator) verifyChunk(chunk *mediachunk.Chunk, durationS float64, bytes uint64) bool {
+	if mg.chunkVerification == nil || (mg.encryption != nil && mg.sampleAES == nil) {
+		return true
+	}
+
+	videoPID, ptsPID := -1, -1
+	if mg.options.videoPID >= 0 {
+		videoPID = mg.remappedPID(mg.options.videoPID)
+	}
+	if videoPID >= 0 && !mg.isAudioOnly {
+		ptsPID = videoPID
+	} else if mg.options.audioPID >= 0 {
+		ptsPID = mg.remappedPID(mg.options.audioPID)
+	}
+	videoCodec := mg.videoCodec
+	expected := chunkverify.Expected{
+		Packets:            int(bytes / uint64(tspacket.TsDefaultPacketSize)),
+		StartsWithPSI:      mg.options.chunkInitType == ChunkInitStart && mg.isChunkIndependent,
+		VideoPID:           videoPID,
+		StartsWithKeyframe: videoPID >= 0 && mg.isChunkVideoChecked && mg.isChunkIndependent,
+		PTSPID:             ptsPID,
+		DurationS:          durationS,
+		ToleranceS:         mg.chunkVerification.toleranceS,
+	}
+	expected.IsRandomAccess = func(p *tspacket.TsPacket) bool {
+		switch videoCodec {
+		case VideoCodecHEVC:
+			// The PES start has to be checked with the next packets if its 1st VCL NAL unit is not in it
+			isRandomAccess, isKnown := p.GetHEVCRandomAccess(videoPID)
+			return isRandomAccess || !isKnown
+		case VideoCodecMPEG2:
+			return p.IsMPEG2RandomAccess(videoPID)
+		}
+		return p.IsRandomAccess(videoPID)
+	}
+
+	data, err := readChunkFile(chunk)
+	if err == nil {
+		err = chunkverify.Verify(data, expected)
+	}
+	if err != nil {
+		atomic.AddUint64(&mg.stats.VerifyFailures, 1)
+		mg.options.log.Error("Verification of chunk ", chunk.GetFilename(), " failed. Err: ", err)
+		return false
+	}
+
+	mg.options.log.Debug("Chunk ", chunk.GetFilename(), " verified")
+	return true
+}
+
+// readChunkFile Returns the data of the chunk saved (its byte range in the byte range output)
+func readChunkFile(chunk *mediachunk.Chunk) ([]byte, error) {
+	offset, length := chunk.GetByteRange()
+	if length <= 0 {
+		return os.ReadFile(chunk.GetFilename())
+	}
+
+	f, err := os.Open(chunk.GetFilename())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// countGap Counts the chunk flagged as gap
+func (mg *ManifestGenerator) countGap(fileName string) {
+	atomic.AddUint64(&mg.stats.Gaps, 1)
+	mg.options.log.Warn("Upload of chunk ", fileName, " failed, flagged as EXT-X-GAP")
+}
+
+// wholeFileOutputType Returns the output type of the chunks that are always a whole file (init and captions), in byte range output they are regular files. The stdout stream only has the chunks and init data, the other files are not written
+func (mg *ManifestGenerator) wholeFileOutputType() mediachunk.OutputTypes {
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+		return mediachunk.ChunkOutputModeFile
+	}
+	if mg.options.chunkOutputType == mediachunk.ChunkOutputModeStdout {
+		return mediachunk.ChunkOutputModeNone
+	}
+	return mg.options.chunkOutputType
+}
+
+// wholeFileSecondaryOutputTypes Returns the secondary destinations of the files that are not chunks or init data (without stdout)
+func (mg *ManifestGenerator) wholeFileSecondaryOutputTypes() []mediachunk.OutputTypes {
+	outputTypes := []mediachunk.OutputTypes{}
+	for _, outputType := range mg.options.secondaryChunkOutputTypes {
+		if outputType != mediachunk.ChunkOutputModeStdout {
+			outputTypes = append(outputTypes, outputType)
+		}
+	}
+	return outputTypes
+}
+
+// rotateByteRangeFileIfNeeded Starts a new byte range file (the next chunk is a discontinuity) when the current one reaches the max size
+func (mg *ManifestGenerator) rotateByteRangeFileIfNeeded(fileBytes int64) {
+	mg.byteRangeFileBytes = fileBytes
+	if mg.byteRangeMaxFileBytes <= 0 || mg.byteRangeFileBytes < mg.byteRangeMaxFileBytes {
+		return
+	}
+
+	mg.options.log.Info("Byte range file of ", mg.byteRangeFileBytes, " bytes reached the max size (", mg.byteRangeMaxFileBytes, "), starting a new file")
+	mg.byteRangeFileIndex++
+	mg.byteRangeFileBytes = 0
+	mg.isNextChunkDisco = true
+}
+
 func (mg *ManifestGenerator) closeChunk(isInit bool, chunkDurationS float64, isFinalChunk bool) {
 	// Close current
 
@@ -449,17 +4495,100 @@ func (mg *ManifestGenerator) closeChunk(isInit bool, chunkDurationS float64, isF
 		if mg.currentChunks != nil && len(mg.currentChunks) > 0 {
 			currentChunk := mg.currentChunks[0]
 
+			if mg.fmp4Muxer != nil {
+				mg.writeFMP4Fragment(isFinalChunk)
+			}
+			if mg.sampleAES != nil && isFinalChunk {
+				mg.endSampleAESPES(-1)
+			}
+			if mg.programDateTimeEvery > 0 && mg.pdtSource == PDTSourceDVB {
+				mg.chunkProgramDateTime = mg.dvbProgramDateTime(mg.chunkStartClockPCRS)
+			}
+			currentChunk.ResolveFilename(mediachunk.FilenameValues{ProgramDateTime: mg.chunkProgramDateTime, StartPTSS: mg.chunkStartPTSS, DurationS: chunkDurationS})
 			currentChunk.Close(chunkDurationS)
+			if mg.liveState != nil {
+				mg.liveState.save(nil)
+			}
+
+			if mg.currentPart != nil {
+				// The last part has the rest of the chunk, the next one is the 1st of the next chunk
+				nextPartFileName := ""
+				if !isFinalChunk {
+					nextPart := mediachunk.New(currentChunk.GetIndex()+1, mg.partOptions(currentChunk.GetIndex()+1, 0))
+					nextPartFileName = nextPart.GetFilename()
+				}
+				mg.closePart(math.Max(0, chunkDurationS-mg.chunkPartsDurS), false, nextPartFileName)
+			}
+			mg.partIndex = 0
+			mg.chunkPartsDurS = 0
+
+			mg.updateMasterBandwidth(mg.chunkOutputBytes, chunkDurationS)
+			if mg.dash != nil {
+				mg.addDashSegment(currentChunk.GetIndex(), chunkDurationS, isFinalChunk)
+			}
+			isVerified := mg.verifyChunk(&currentChunk, chunkDurationS, mg.chunkOutputBytes)
+			mg.isNextChunkGap = !isVerified && mg.chunkVerification.isGap
+			mg.checkIndependentChunk(currentChunk.GetFilename())
+
+			if mg.filterPIDs && mg.chunkInputBytes > 0 {
+				mg.options.log.Info("Chunk ", currentChunk.GetFilename(), " PID filtering, written ", mg.chunkOutputBytes, " of ", mg.chunkInputBytes, " input bytes (", fmt.Sprintf("%.1f", 100*(1-float64(mg.chunkOutputBytes)/float64(mg.chunkInputBytes))), "% reduction)")
+			}
+			chunkBytes := mg.chunkOutputBytes
+			mg.chunkInputBytes = 0
+			mg.chunkOutputBytes = 0
+
+			if mg.captions != nil {
+				mg.saveCaptionsChunk(currentChunk.GetIndex(), chunkDurationS, mg.isNextCaptionsDisco, isFinalChunk)
+				mg.isNextCaptionsDisco = false
+			}
+
+			endPTSS := mg.lastIDRPTSS
+			if isFinalChunk {
+				endPTSS = -1
+			}
+			dateRanges := mg.takeSplices(endPTSS)
 
 			//NO LHLS
 			if mg.options.lhlsAdvancedChunks <= 0 {
-				mg.hlsAddChunk(false, currentChunk.GetFilename(), chunkDurationS, false)
+				byteRangeOffset, byteRangeLength := currentChunk.GetByteRange()
+				mg.hlsAddChunk(false, currentChunk.GetFilename(), chunkDurationS, chunkBytes, mg.isNextChunkDisco, dateRanges, mg.chunkProgramDateTime, byteRangeOffset, byteRangeLength, mg.chunkKeys[currentChunk.GetIndex()].key)
+				mg.isNextChunkDisco = false
+				if mg.options.chunkOutputType == mediachunk.ChunkOutputModeFileByteRange {
+					mg.rotateByteRangeFileIfNeeded(byteRangeOffset + byteRangeLength)
+				}
 				if mg.options.manifestType == hls.Vod {
 					if isFinalChunk {
 						mg.hlsClose()
 					}
 				}
+			} else {
+				// The announced chunk gets its real duration, the chunklist is saved when the next one is streamed
+				if len(dateRanges) > 0 {
+					mg.hlsChunklist.AddChunkDateRanges(currentChunk.GetFilename(), dateRanges, false)
+				}
+				if !mg.chunkProgramDateTime.IsZero() {
+					mg.hlsChunklist.SetChunkProgramDateTime(currentChunk.GetFilename(), mg.chunkProgramDateTime, false)
+				}
+				mg.hlsChunklist.SetChunkBytes(currentChunk.GetFilename(), chunkBytes, false)
+				mg.hlsChunklist.SetChunkDuration(currentChunk.GetFilename(), chunkDurationS, false)
+				if mg.isNextChunkGap {
+					mg.isNextChunkGap = false
+					mg.hlsChunklist.SetChunkGap(currentChunk.GetFilename(), false)
+				}
 			}
+			if mg.chunkMetadata != nil {
+				mg.saveChunkMetadata(currentChunk.GetIndex(), currentChunk.GetFilename(), chunkDurationS, chunkBytes)
+			}
+			mg.chunkKeyframes = 0
+			mg.chunkWrittenAt = time.Time{}
+			if !mg.chunkProgramDateTime.IsZero() && chunkDurationS > 0 {
+				mg.pdtTicks = mg.pdtTicks + tspacket.SecondsToTicks(chunkDurationS)
+			}
+			mg.chunkProgramDateTime = time.Time{}
+			if mg.encryption != nil {
+				mg.encryption.keyDurationS = mg.encryption.keyDurationS + chunkDurationS
+			}
+			delete(mg.chunkKeys, currentChunk.GetIndex())
 
 			if len(mg.currentChunks) > 1 {
 				// Remove 1st element
@@ -468,14 +4597,35 @@ func (mg *ManifestGenerator) closeChunk(isInit bool, chunkDurationS float64, isF
 				// Empty array
 				mg.currentChunks = mg.currentChunks[:0]
 			}
+			if mg.options.lhlsAdvancedChunks > 0 {
+				// The hint is the advanced chunk streamed now (nothing else is streamed after the last one)
+				preloadHintFileName := ""
+				if !isFinalChunk && len(mg.currentChunks) > 0 {
+					preloadHintFileName = mg.currentChunks[0].GetFilename()
+				}
+				err := mg.hlsChunklist.SetPreloadHint(preloadHintFileName, true)
+				if err != nil {
+					mg.options.log.Error("Error generating / saving the chunklists. Err: ", err)
+				}
+			}
 
+			mg.MarkUploadGaps()
 			mg.currentChunkIndex++
+			for index := range mg.chunkShards {
+				if index < mg.currentChunkIndex {
+					delete(mg.chunkShards, index)
+				}
+			}
+			mg.saveState()
 		}
 	} else {
 		if mg.initChunk != nil {
 			mg.initChunk.Close(-1)
 
 			mg.hlsChunklist.SetInitChunk(mg.initChunk.GetFilename())
+			if mg.dash != nil {
+				mg.dash.SetInitSegment(mg.initChunk.GetFilename())
+			}
 
 			// We need to update version 7 for map chunks
 			mg.hlsChunklist.SetHlsVersion(7)
@@ -490,22 +4640,36 @@ func (mg *ManifestGenerator) closeChunk(isInit bool, chunkDurationS float64, isF
 func (mg *ManifestGenerator) createChunk(isInit bool) {
 	// Close current
 	if isInit {
+		initOutputType := mg.wholeFileOutputType()
+		if mg.options.chunkOutputType == mediachunk.ChunkOutputModeStdout {
+			// The init data is in the stream before the chunks
+			initOutputType = mediachunk.ChunkOutputModeStdout
+		}
 		chunkInitOptions := mediachunk.Options{
-			Log:                mg.options.log,
-			OutputType:         mg.options.chunkOutputType,
-			LHLS:               false,
-			EstimatedDurationS: -1,
-			FileNumberLength:   mg.options.fileNumberLength,
-			GhostPrefix:        GhostPrefixDefault,
-			FileExtension:      ChunkFileExtensionDefault,
-			BasePath:           mg.options.baseOutPath,
-			ChunkBaseFilename:  ChunkInitFileName,
-			HTTPUploader:       mg.options.httpUploader,
-			S3Uploader:         mg.options.s3Uploader,
-		}
-
-		newChunk := mediachunk.New(0, chunkInitOptions)
+			Log:                  mg.options.log,
+			OutputType:           initOutputType,
+			LHLS:                 false,
+			EstimatedDurationS:   -1,
+			FileNumberLength:     mg.options.fileNumberLength,
+			GhostPrefix:          GhostPrefixDefault,
+			FileExtension:        mg.chunkFileExtension(true),
+			BasePath:             mg.options.baseOutPath,
+			ChunkBaseFilename:    ChunkInitFileName,
+			HTTPUploader:         mg.options.httpUploader,
+			S3Uploader:           mg.options.s3Uploader,
+			HTTPServer:           mg.options.httpServer,
+			Callbacks:            mg.options.chunkCallbacks,
+			NoFlagFile:           mg.options.isNoFlagFiles,
+			Fsync:                mg.options.mediaFsyncEvery > 0,
+			SecondaryOutputTypes: mg.options.secondaryChunkOutputTypes,
+			OnSecondaryFailed:    mg.options.onSecondaryChunkFailed,
+			UploadType:           httpuploader.UploadTypeInit,
+		}
+
+		// Every new init chunk (Ex: PMT changes) has its own file
+		newChunk := mediachunk.New(mg.initChunkIndex, chunkInitOptions)
 		mg.initChunk = &newChunk
+		mg.initChunkIndex++
 
 		err := mg.initChunk.InitializeChunk()
 		if err != nil {
@@ -520,6 +4684,7 @@ func (mg *ManifestGenerator) createChunk(isInit bool) {
 
 		n := 0
 		for n < chunksToCreate {
+			index := mg.currentChunkIndex + uint64(len(mg.currentChunks))
 			chunkOptions := mediachunk.Options{
 				Log:                mg.options.log,
 				OutputType:         mg.options.chunkOutputType,
@@ -527,17 +4692,47 @@ func (mg *ManifestGenerator) createChunk(isInit bool) {
 				EstimatedDurationS: mg.options.targetSegmentDurS,
 				FileNumberLength:   mg.options.fileNumberLength,
 				GhostPrefix:        GhostPrefixDefault,
-				FileExtension:      ChunkFileExtensionDefault,
-				BasePath:           mg.options.baseOutPath,
+				FileExtension:      mg.chunkFileExtension(false),
+				BasePath:           mg.chunkBasePath(index),
 				ChunkBaseFilename:  mg.options.chunkBaseFilename,
 				HTTPUploader:       mg.options.httpUploader,
-				S3Uploader:         mg.options.s3Uploader}
+				S3Uploader:         mg.options.s3Uploader,
+				HTTPServer:         mg.options.httpServer,
+				Callbacks:          mg.options.chunkCallbacks,
+				FlushBytes:         mg.options.flushBytes,
+				FlushInterval:      mg.options.flushInterval,
+				FileIndex:          mg.byteRangeFileIndex}
+			chunkOptions.OnUploadFailed = mg.uploadFailures.add
+			chunkOptions.FilenameTemplate = mg.options.chunkFilenameTemplate
+			chunkOptions.Checksum = mg.options.isChunkChecksum
+			chunkOptions.ChecksumSidecar = mg.options.isChunkChecksumSidecar
+			chunkOptions.NoFlagFile = mg.options.isNoFlagFiles
+			chunkOptions.Fsync = mg.isMediaFsync(index)
+			chunkOptions.WriteQueueBytes = mg.options.writeQueueBytes
+			chunkOptions.WriteQueueStats = mg.writeQueueStats
+			chunkOptions.SecondaryOutputTypes = mg.options.secondaryChunkOutputTypes
+			chunkOptions.OnSecondaryFailed = mg.options.onSecondaryChunkFailed
+			if mg.options.isNoChunkFlagFile {
+				chunkOptions.GhostPrefix = ""
+			}
+			chunkOptions.InProgressExtension = mg.options.chunkInProgressExtension
+			if mg.liveState != nil {
+				chunkOptions.OnWritten = mg.liveState.onWritten(mg.hlsChunklist.MediaURI)
+			}
 
 			if mg.options.lhlsAdvancedChunks > 0 {
 				chunkOptions.LHLS = true
 			}
 
-			newChunk := mediachunk.New(mg.currentChunkIndex+uint64(len(mg.currentChunks)), chunkOptions)
+			if chunkKey := mg.newChunkKey(index); chunkKey.key != nil {
+				mg.chunkKeys[index] = chunkKey
+				if mg.sampleAES == nil {
+					chunkOptions.EncryptionKey = chunkKey.aesKey
+					chunkOptions.EncryptionIV = chunkKey.iv
+				}
+			}
+
+			newChunk := mediachunk.New(index, chunkOptions)
 
 			err := newChunk.InitializeChunk()
 			if err != nil {
@@ -546,7 +4741,11 @@ func (mg *ManifestGenerator) createChunk(isInit bool) {
 
 			// Add the advanced chunk to the manifest with target dur
 			if mg.options.lhlsAdvancedChunks > 0 {
-				mg.hlsAddChunk(true, newChunk.GetFilename(), mg.options.targetSegmentDurS, false)
+				if len(mg.currentChunks) <= 0 {
+					mg.hlsChunklist.SetPreloadHint(newChunk.GetFilename(), false)
+				}
+				mg.hlsAddChunk(true, newChunk.GetFilename(), mg.options.targetSegmentDurS, 0, mg.isNextChunkDisco, nil, time.Time{}, 0, 0, mg.chunkKeys[newChunk.GetIndex()].key)
+				mg.isNextChunkDisco = false
 			}
 
 			mg.currentChunks = append(mg.currentChunks, newChunk)
@@ -562,12 +4761,16 @@ func (mg *ManifestGenerator) nextChunk(currentPCRS float64, lastInitialPCRS floa
 	chunkDurationS = -1.0
 	nextInitialPCRS = currentPCRS
 
-	if currentPCRS >= lastInitialPCRS {
-		chunkDurationS = currentPCRS - lastInitialPCRS
-	} else {
-		// Detected possible PCR roll over
-		mg.options.log.Info("Possible PCR rollover! lastInitialPCRS:", lastInitialPCRS, ", currentPCRS: ", currentPCRS, ", maxPCRs: ", maxPCRs)
-		chunkDurationS = maxPCRs - currentPCRS + lastInitialPCRS
+	// Modular distance, so the 33 bits rollover does not break the duration. In 90KHz ticks, so the chunk durations add up to the stream duration without rounding drift
+	chunkDurationTicks := tspacket.TimeDiffTicks(tspacket.SecondsToTicks(lastInitialPCRS), tspacket.SecondsToTicks(currentPCRS), tspacket.SecondsToTicks(maxPCRs))
+	chunkDurationS = tspacket.TicksToSeconds(chunkDurationTicks)
+	if currentPCRS < lastInitialPCRS {
+		if chunkDurationS >= 0 {
+			mg.options.log.Info("PCR rollover! lastInitialPCRS:", lastInitialPCRS, ", currentPCRS: ", currentPCRS, ", maxPCRs: ", maxPCRs)
+		} else {
+			mg.options.log.Warn("Timestamps went backwards, chunk duration set to 0. lastInitialPCRS:", lastInitialPCRS, ", currentPCRS: ", currentPCRS)
+			chunkDurationS = 0
+		}
 	}
 
 	mg.options.log.Info("CHUNK! At PCRs: ", currentPCRS, ". ChunkDurS: ", chunkDurationS)
@@ -580,48 +4783,275 @@ func (mg *ManifestGenerator) nextChunk(currentPCRS float64, lastInitialPCRS floa
 	return
 }
 
+// SetDiscontinuity Closes the current chunk and flags the next one as discontinuity (Ex: input reconnected)
+func (mg *ManifestGenerator) SetDiscontinuity() {
+	// Discard any partial packet from the previous input
+	mg.isInSync = false
+	mg.bytesToNextSync = 0
+	mg.tsPacket.Reset()
+	mg.unsyncedBuf = mg.unsyncedBuf[:0]
+	mg.rsBytes = mg.rsBytes[:0]
+	mg.hasBeenInSync = false
+	mg.skippedBytes = 0
+	mg.resetContinuity()
+
+	mg.discontinuity()
+}
+
+// discontinuity Closes the current chunk (if it has data) at the end of its last sample (as Close) and flags the next one as discontinuity, the timestamps are reset
+func (mg *ManifestGenerator) discontinuity() {
+	if mg.fmp4Muxer != nil {
+		// The pending PES are the last ones of the previous timeline
+		mg.fmp4Muxer.EndPES(fmp4.TrackVideo, -1)
+		mg.fmp4Muxer.EndPES(fmp4.TrackAudio, -1)
+	}
+	if mg.sampleAES != nil {
+		mg.endSampleAESPES(-1)
+	}
+	if !mg.isCurrentChunkEmpty() {
+		mg.nextChunk(mg.finalChunkEndS(mg.chunkStartTimeS, mg.lastTimeS()), mg.chunkStartTimeS, tspacket.MaxPCRSValue, false)
+	}
+
+	if mg.isIFrames {
+		// Spans until the last PTS of the previous timeline
+		mg.closeIFrame(mg.lastPTSS, false)
+	}
+
+	// Timestamps will probably restart
+	mg.chunkStartTimeS = -1.0
+	mg.chunkStartClockPCRS = -1.0
+	mg.lastClockPCRS = -1.0
+	mg.lastPCRS = -1.0
+	mg.lastPTSS = -1.0
+	mg.lastIDRPTSS = -1.0
+	mg.chunkStartPTSS = -1.0
+	mg.chunkMaxPTSS = -1.0
+	mg.lastMediaPCRS = -1.0
+	mg.isPDTReanchor = true
+	mg.dvbTimePCRS = -1.0
+	mg.dvbTimeAssembler.Reset()
+	mg.scte35Assembler.Reset()
+	if mg.captions != nil {
+		mg.captions.Reset()
+	}
+	if mg.fmp4Muxer != nil {
+		mg.fmp4Muxer.Reset()
+	}
+	if len(mg.pendingSplices) > 0 {
+		mg.options.log.Warn("Discarded ", len(mg.pendingSplices), " pending SCTE-35 splices, input discontinuity")
+		mg.pendingSplices = mg.pendingSplices[:0]
+	}
+
+	if mg.currentChunkIndex <= mg.firstChunkIndex {
+		// Nothing published yet
+		return
+	}
+
+	mg.isNextCaptionsDisco = true
+	mg.isNextIFrameDisco = mg.iFrameIndex > mg.firstIFrameIndex
+	if mg.options.lhlsAdvancedChunks > 0 && len(mg.currentChunks) > 0 {
+		// The next chunk is already announced in the chunklist
+		err := mg.hlsChunklist.SetChunkDiscontinuity(mg.currentChunks[0].GetFilename(), true)
+		if err != nil {
+			mg.options.log.Error("Error generating / saving the chunklists. Err: ", err)
+		}
+	} else {
+		mg.isNextChunkDisco = true
+	}
+
+	mg.options.log.Info("Discontinuity set, next chunk index: ", mg.currentChunkIndex)
+}
+
 // Close Closes manigest processing saving last data and last chunk
 func (mg *ManifestGenerator) Close() {
+	// Process the data pending to confirm the sync (not enough data to confirm it)
+	if !mg.isInSync && len(mg.unsyncedBuf) > 0 {
+		resyncPackets := mg.resyncPackets
+		mg.resyncPackets = 1
+		mg.AddData(nil)
+		mg.resyncPackets = resyncPackets
+	}
+
+	// HEVC packets still held
+	mg.releaseHEVCPackets(false)
+
+	// Data PES still pending
+	for pID := range mg.pendingDataPackets {
+		mg.flushDataPES(pID)
+	}
+
 	//Generate last chunk
-	mg.nextChunk(mg.lastPCRS, mg.chunkStartTimeS, tspacket.MaxPCRSValue, true)
+	if mg.durationSource == DurationSourcePCR || (mg.chunkStartTimeS < 0 && mg.chunkStartClockPCRS >= 0) {
+		// Timed with the PCR PID
+		mg.nextChunk(mg.finalChunkEndS(mg.chunkStartClockPCRS, mg.lastClockPCRS), mg.chunkStartClockPCRS, tspacket.MaxPCRSValue, true)
+	} else {
+		mg.nextChunk(mg.finalChunkEndS(mg.chunkStartTimeS, mg.lastTimeS()), mg.chunkStartTimeS, tspacket.MaxPCRSValue, true)
+	}
+	if mg.isIFrames {
+		mg.closeIFrame(mg.lastPTSS, true)
+	}
+	if mg.finalizeAsVod {
+		mg.finalizeVod()
+	}
+	if mg.options.manifestType != hls.Vod && !mg.hlsChunklist.IsClosed() {
+		if mg.endlistMode == EndlistOnClose {
+			mg.closeChunklists()
+		} else if mg.endlistMode == EndlistAfterGrace {
+			mg.options.log.Info("EXT-X-ENDLIST added in ", mg.endlistGrace, " if there is no new data")
+			mg.pendingEndlist.start(mg.endlistGrace, mg.closeChunklists)
+		}
+	}
+
+	stats := mg.GetStats()
+	mg.options.log.WithFields(logrus.Fields{
+		"event":         "input_summary",
+		"inputBytes":    stats.InputBytes,
+		"packets":       stats.Packets,
+		"resyncs":       mg.resyncs,
+		"ccErrors":      stats.CCErrors,
+		"videoCCErrors": stats.VideoCCErrors,
+		"audioCCErrors": stats.AudioCCErrors,
+		"nullPackets":   stats.NullPackets,
+		"gaps":          stats.Gaps,
+	}).Info("Input summary")
 }
 
 // AddData current chunk
 func (mg *ManifestGenerator) AddData(buf []byte) {
-	if !mg.isInSync {
-		buf = mg.resync(buf)
-
-		if len(buf) > 0 {
-			mg.bytesToNextSync = tspacket.TsDefaultPacketSize
+	if len(buf) > 0 {
+		if mg.pendingEndlist.cancel() {
+			mg.options.log.Info("New data after closing, the chunklist is left open")
 		}
+		atomic.AddUint64(&mg.stats.InputBytes, uint64(len(buf)))
+		atomic.StoreInt64(&mg.stats.LastDataUnixNano, time.Now().UnixNano())
 	}
 
-	if len(buf) > 0 {
+	for {
+		if !mg.isInSync {
+			buf = mg.resync(buf)
+			if !mg.isInSync {
+				// All data discarded or pending to confirm the sync
+				return
+			}
+			mg.bytesToNextSync = mg.inputPacketSize
+		}
+
+		if len(buf) <= 0 {
+			return
+		}
+
 		addedSize := min(len(buf), mg.bytesToNextSync)
-		mg.tsPacket.AddData(buf[:addedSize])
+
+		// Only the 1st 188 bytes are TS packet data
+		packetPos := mg.inputPacketSize - mg.bytesToNextSync
+		tsDataSize := 0
+		if packetPos < tspacket.TsDefaultPacketSize {
+			tsDataSize = min(addedSize, tspacket.TsDefaultPacketSize-packetPos)
+			mg.tsPacket.AddData(buf[:tsDataSize])
+		}
+		// Reed-Solomon bytes are only needed if we lose the sync
+		mg.rsBytes = append(mg.rsBytes, buf[tsDataSize:addedSize]...)
 
 		mg.bytesToNextSync = mg.bytesToNextSync - addedSize
 
 		buf = buf[addedSize:]
-	}
 
-	if mg.bytesToNextSync <= 0 {
-		// Process packet
-		if mg.processPacket(false) == false {
-			mg.isInSync = false
-		} else {
-			mg.bytesToNextSync = tspacket.TsDefaultPacketSize
-			mg.processedPackets++
-			mg.tsPacket.Reset()
+		if mg.bytesToNextSync <= 0 {
+			// Process packet
+			if mg.holdHEVCPacket() {
+				mg.bytesToNextSync = mg.inputPacketSize
+				mg.tsPacket.Reset()
+				mg.rsBytes = mg.rsBytes[:0]
+			} else if mg.processPacket(false) == false {
+				mg.lostSync()
+			} else {
+				mg.bytesToNextSync = mg.inputPacketSize
+				mg.processedPackets++
+				mg.countPacket()
+				mg.tsPacket.Reset()
+				mg.rsBytes = mg.rsBytes[:0]
+			}
 		}
+		// Still data to process
 	}
+}
 
-	if len(buf) > 0 {
-		// Still data to process
-		mg.AddData(buf[:])
+// countPacket Updates the packet stats
+func (mg *ManifestGenerator) countPacket() {
+	atomic.AddUint64(&mg.stats.Packets, 1)
+
+	pID := mg.tsPacket.GetPID()
+	if pID == mg.options.videoPID {
+		atomic.AddUint64(&mg.stats.VideoBytes, uint64(tspacket.TsDefaultPacketSize))
+	} else if pID == mg.options.audioPID {
+		atomic.AddUint64(&mg.stats.AudioBytes, uint64(tspacket.TsDefaultPacketSize))
 	}
+}
 
-	return
+// checkContinuity Counts the continuity_counter errors of the PIDs we use (PAT, PMT, video, audio, SCTE-35), a packet repeated once and the discontinuity_indicator are not errors
+func (mg *ManifestGenerator) checkContinuity(pID int) {
+	if pID < 0 || !mg.tsPacket.HasPayload() {
+		return
+	}
+	if pID != 0 && pID != mg.detectedPMTID && pID != mg.options.videoPID && pID != mg.options.audioPID && pID != mg.detectedSCTE35PID {
+		return
+	}
+
+	cc := mg.tsPacket.GetContinuityCounter()
+	lastCC, found := mg.lastCCs[pID]
+	mg.lastCCs[pID] = cc
+
+	if !found || mg.tsPacket.IsDiscontinuity() {
+		mg.isCCRepeated[pID] = false
+		return
+	}
+
+	if cc == lastCC && !mg.isCCRepeated[pID] {
+		// Duplicate packet
+		mg.isCCRepeated[pID] = true
+		return
+	}
+	mg.isCCRepeated[pID] = false
+	if cc == (lastCC+1)&0x0F {
+		return
+	}
+
+	atomic.AddUint64(&mg.stats.CCErrors, 1)
+	if pID == mg.options.videoPID {
+		atomic.AddUint64(&mg.stats.VideoCCErrors, 1)
+	} else if pID == mg.options.audioPID {
+		atomic.AddUint64(&mg.stats.AudioCCErrors, 1)
+	}
+	mg.options.log.Warn("CC error in PID ", pID, ", expected: ", (lastCC+1)&0x0F, ", got: ", cc)
+}
+
+// resetContinuity Forgets the last continuity_counters (Ex: the input changed)
+func (mg *ManifestGenerator) resetContinuity() {
+	mg.lastCCs = map[int]int{}
+	mg.isCCRepeated = map[int]bool{}
+}
+
+// GetStats Returns a snapshot of the input stats, it is safe to call it from other goroutine
+func (mg *ManifestGenerator) GetStats() Stats {
+	return Stats{
+		atomic.LoadUint64(&mg.stats.InputBytes),
+		atomic.LoadUint64(&mg.stats.Packets),
+		atomic.LoadUint64(&mg.stats.VideoBytes),
+		atomic.LoadUint64(&mg.stats.AudioBytes),
+		atomic.LoadInt64(&mg.stats.LastDataUnixNano),
+		atomic.LoadInt64(&mg.stats.VideoPID),
+		atomic.LoadInt64(&mg.stats.AudioPID),
+		atomic.LoadUint64(&mg.stats.CCErrors),
+		atomic.LoadUint64(&mg.stats.VideoCCErrors),
+		atomic.LoadUint64(&mg.stats.AudioCCErrors),
+		atomic.LoadUint64(&mg.stats.NullPackets),
+		atomic.LoadUint64(&mg.stats.Gaps),
+		atomic.LoadUint64(&mg.writeQueueStats.HighWaterBytes),
+		atomic.LoadUint64(&mg.writeQueueStats.Blocks),
+		atomic.LoadUint64(&mg.writeQueueStats.BlockedNs),
+		atomic.LoadUint64(&mg.stats.VerifyFailures),
+		atomic.LoadUint64(&mg.stats.SecondaryFailures),
+	}
 }
 
 func (mg ManifestGenerator) getNumProcessedPackets() uint64 {
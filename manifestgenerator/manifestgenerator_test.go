@@ -2,15 +2,36 @@ package manifestgenerator
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"go-ts-segmenter/manifestgenerator/chunkmeta"
+	"go-ts-segmenter/manifestgenerator/encryption"
 	"go-ts-segmenter/manifestgenerator/hls"
 	"go-ts-segmenter/manifestgenerator/mediachunk"
+	"go-ts-segmenter/manifestgenerator/tspacket"
+	"go-ts-segmenter/uploaders/httpserver"
+	"go-ts-segmenter/uploaders/httpuploader"
 )
 
 func parseHexString(h string) []byte {
@@ -32,7 +53,7 @@ func TestManifestGeneratorBasic1Pckt(t *testing.T) {
 	pathResults := "../results/Basic1Pckt"
 	clearResultsDir(pathResults)
 
-	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
 
 	// Generate TS packet
 	pckt := parseHexString("47410030075000007B0C7E00000001E0000080C00A310007EFD1110007D8610000000109F000000001674D4029965280A00B74A40404050000030001000003003C840000000168E90935200000000165888040006B6FFEF7D4B7CCB2D9A9BED82EA3DE8A78997D0DD494066F86757E1D7F4A3FA82C376EE9C0FE81F4F746A24E305C9A3E0DD5859DE0D287E8BEF70EA0CCF9008A25F52EF9A9CFA59B78AA5D34CB88001425FE7AB544EF7171FC56F27719F9C72D13FA7B0F5F3211A6")
@@ -52,7 +73,7 @@ func TestManifestGeneratorBasic2Pckt(t *testing.T) {
 	pathResults := "../results/Basic2Pckt"
 	clearResultsDir(pathResults)
 
-	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
 
 	// Generate TS packet
 	pckt := parseHexString(
@@ -82,7 +103,7 @@ func TestManifestGeneratorBasicVideoBigPacketsNoIniData(t *testing.T) {
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 4*1024) //4KB Buffers
 
-	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
 
 	for {
 		n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -123,7 +144,7 @@ func TestManifestGeneratorBasicVideoSmallPacketsNoIniData(t *testing.T) {
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 100) //100 bytes
 
-	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
 
 	for {
 		n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -164,7 +185,7 @@ func TestManifestGeneratorInitialResyncVideoBigPacketsNoIniData(t *testing.T) {
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 4*1024) //4KB Buffers
 
-	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
 
 	// Start out of sync
 	n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -211,7 +232,7 @@ func TestManifestGeneratorBasicVideoBigPacketsAutoPIDsInitSegment(t *testing.T)
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 4*1024) //4KB Buffers
 
-	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
 
 	for {
 		n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -285,7 +306,7 @@ func TestManifestGeneratorBasicVideoBigPacketsAutoPIDsInitStartSegment(t *testin
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 4*1024) //4KB Buffers
 
-	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
 
 	for {
 		n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -365,7 +386,7 @@ func TestManifestGeneratorBasicVideoBigPacketsAutoPIDsInitStartSegment(t *testin
 chunk_00000.ts
 #EXTINF:4.00000000,
 chunk_00001.ts
-#EXTINF:2.00000000,
+#EXTINF:4.00000000,
 chunk_00002.ts
 #EXT-X-ENDLIST
 `
@@ -388,7 +409,7 @@ func TestManifestGeneratorBasicVideoBigPacketsAutoPIDsInitStartSegmentLHLS(t *te
 	mediaSourceReader := bufio.NewReader(f)
 	buf := make([]byte, 0, 4*1024) //4KB Buffers
 
-	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 3, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 3, nil, nil)
 
 	for {
 		n, err := mediaSourceReader.Read(buf[:cap(buf)])
@@ -494,3 +515,3383 @@ chunk_00004.ts
 		t.Errorf("Manifest data is different, got %s , expected %s", manifestStr, xpectedmanifestStr)
 	}
 }
+
+// Sends all the file data to the manifest generator
+func addFileData(mg *ManifestGenerator, fileName string, bufSize int) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		panic("Error opening test file")
+	}
+	defer f.Close()
+
+	mediaSourceReader := bufio.NewReader(f)
+	buf := make([]byte, 0, bufSize)
+
+	for {
+		n, err := mediaSourceReader.Read(buf[:cap(buf)])
+		buf = buf[:n]
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			if err == io.EOF {
+				break
+			}
+		} else {
+			mg.AddData(buf)
+		}
+		// process buf
+		if err != nil && err != io.EOF {
+			panic("Error reading test file")
+		}
+	}
+}
+
+// Reads the resulting chunklist
+func readChunklist(t *testing.T, fileName string) string {
+	manifestByte, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Errorf("Error reading HLS chunklist data!, Err: %v", err)
+	}
+
+	return string(manifestByte)
+}
+
+func TestManifestGeneratorDiscontinuity(t *testing.T) {
+	pathResults := "../results/VideoDiscontinuity"
+	chunklistFile := "chunklist.m3u8"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, nil, nil)
+
+	// Simulates an input reconnection
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.SetDiscontinuity()
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	xpectednumProcPackets := uint64(1835 * 2)
+	procPckts := mg.getNumProcessedPackets()
+	if procPckts != xpectednumProcPackets {
+		t.Errorf("Processed packet number is incorrect, got: %d, want: %d.", procPckts, xpectednumProcPackets)
+	}
+
+	manifestStr := readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedmanifestStr := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-DISCONTINUITY-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-TARGETDURATION:4
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXTINF:4.00000000,
+chunk_00000.ts
+#EXTINF:4.00000000,
+chunk_00001.ts
+#EXTINF:4.00000000,
+chunk_00002.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4.00000000,
+chunk_00003.ts
+#EXTINF:4.00000000,
+chunk_00004.ts
+#EXTINF:4.00000000,
+chunk_00005.ts
+`
+	if manifestStr != xpectedmanifestStr {
+		t.Errorf("Manifest data is different, got %s , expected %s", manifestStr, xpectedmanifestStr)
+	}
+}
+
+func TestManifestGeneratorResync(t *testing.T) {
+	pathResults := "../results/Resync"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// Insert corrupted bytes (with a false sync byte) after packet 100 and 500
+	garbage := make([]byte, 50)
+	garbage[10] = 0x47
+	garbage[20] = 0x47
+	data := make([]byte, 0, len(fixture)+2*len(garbage))
+	data = append(data, fixture[:100*188]...)
+	data = append(data, garbage...)
+	data = append(data, fixture[100*188:500*188]...)
+	data = append(data, garbage...)
+	data = append(data, fixture[500*188:]...)
+
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg.SetResyncPackets(3)
+
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		mg.AddData(data[:n])
+		data = data[n:]
+	}
+	mg.Close()
+
+	xpectednumProcPackets := uint64(1835)
+	procPckts := mg.getNumProcessedPackets()
+	if procPckts != xpectednumProcPackets {
+		t.Errorf("Processed packet number is incorrect, got: %d, want: %d.", procPckts, xpectednumProcPackets)
+	}
+
+	xpectedResyncs := uint64(2)
+	resyncs := mg.GetResyncs()
+	if resyncs != xpectedResyncs {
+		t.Errorf("Resyncs number is incorrect, got: %d, want: %d.", resyncs, xpectedResyncs)
+	}
+}
+
+// toRSPackets Converts 188 bytes TS packets to 204 bytes (16 Reed-Solomon bytes added)
+func toRSPackets(data []byte) []byte {
+	rsData := make([]byte, 0, len(data)/188*204)
+	for len(data) >= 188 {
+		rsData = append(rsData, data[:188]...)
+		rsData = append(rsData, make([]byte, 16)...)
+		data = data[188:]
+	}
+	return rsData
+}
+
+func TestManifestGenerator204BytesPackets(t *testing.T) {
+	pathResults := "../results/204BytesPackets"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// 1st half 204 bytes packets, then 188 bytes packets
+	data := toRSPackets(fixture[:1000*188])
+	data = append(data, fixture[1000*188:]...)
+
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg.SetResyncPackets(3)
+
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		mg.AddData(data[:n])
+		data = data[n:]
+	}
+	mg.Close()
+
+	// The 1st 188 bytes packet is lost when the packet size changes
+	xpectednumProcPackets := uint64(1834)
+	procPckts := mg.getNumProcessedPackets()
+	if procPckts != xpectednumProcPackets {
+		t.Errorf("Processed packet number is incorrect, got: %d, want: %d.", procPckts, xpectednumProcPackets)
+	}
+
+	xpectedResyncs := uint64(1)
+	resyncs := mg.GetResyncs()
+	if resyncs != xpectedResyncs {
+		t.Errorf("Resyncs number is incorrect, got: %d, want: %d.", resyncs, xpectedResyncs)
+	}
+}
+
+func TestManifestGeneratorForced204BytesPackets(t *testing.T) {
+	pathResults := "../results/Forced204BytesPackets"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg.SetInputPacketSize(204)
+
+	mg.AddData(toRSPackets(fixture))
+	mg.Close()
+
+	xpectednumProcPackets := uint64(1835)
+	procPckts := mg.getNumProcessedPackets()
+	if procPckts != xpectednumProcPackets {
+		t.Errorf("Processed packet number is incorrect, got: %d, want: %d.", procPckts, xpectednumProcPackets)
+	}
+}
+
+func TestManifestGeneratorStats(t *testing.T) {
+	pathResults := "../results/Stats"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 256, 257, hls.LiveWindow, 3, 0, nil, nil)
+	mg.AddData(fixture)
+	mg.Close()
+
+	stats := mg.GetStats()
+	if stats.InputBytes != uint64(len(fixture)) {
+		t.Errorf("Input bytes are incorrect, got: %d, want: %d.", stats.InputBytes, len(fixture))
+	}
+	if stats.Packets != 1835 {
+		t.Errorf("Packets are incorrect, got: %d, want: %d.", stats.Packets, 1835)
+	}
+	if stats.VideoPID != 256 || stats.AudioPID != 257 {
+		t.Errorf("PIDs are incorrect, got: %d/%d, want: %d/%d.", stats.VideoPID, stats.AudioPID, 256, 257)
+	}
+	if stats.VideoBytes <= 0 || stats.AudioBytes <= 0 || stats.VideoBytes+stats.AudioBytes > stats.InputBytes {
+		t.Errorf("Video / audio bytes are incorrect, got: %d/%d, total: %d.", stats.VideoBytes, stats.AudioBytes, stats.InputBytes)
+	}
+	if stats.LastDataUnixNano <= 0 {
+		t.Errorf("Last data time not set, got: %d.", stats.LastDataUnixNano)
+	}
+}
+
+func TestManifestGeneratorNullPackets(t *testing.T) {
+	pathResults := "../results/NullPackets"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// A null packet after every packet (CBR padding)
+	nullPckt := make([]byte, 188)
+	copy(nullPckt, []byte{0x47, 0x1F, 0xFF, 0x10})
+	data := make([]byte, 0, 2*len(fixture))
+	for i := 0; i+188 <= len(fixture); i = i + 188 {
+		data = append(append(data, fixture[i:i+188]...), nullPckt...)
+	}
+
+	// Passed through by default, stripped (and counted) if requested. The null packets after the PAT and the PMT are before the init data is saved, they are never written
+	for _, stripNullPackets := range []bool{false, true} {
+		clearResultsDir(pathResults)
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetStripNullPackets(stripNullPackets)
+		mg.AddData(data)
+		mg.Close()
+
+		xpectedStripped, xpectedInChunks := uint64(0), 1833
+		if stripNullPackets {
+			xpectedStripped, xpectedInChunks = 1833, 0
+		}
+		if stats := mg.GetStats(); stats.NullPackets != xpectedStripped {
+			t.Errorf("Stripped null packets are incorrect (strip: %t), got: %d, want: %d.", stripNullPackets, stats.NullPackets, xpectedStripped)
+		}
+		if pidsCount := chunksPIDsCount(t, pathResults); pidsCount[0x1FFF] != xpectedInChunks {
+			t.Errorf("Null packets in the chunks are incorrect (strip: %t), got: %d, want: %d.", stripNullPackets, pidsCount[0x1FFF], xpectedInChunks)
+		}
+	}
+}
+
+func TestManifestGeneratorCCErrors(t *testing.T) {
+	pathResults := "../results/CCErrors"
+	clearResultsDir(pathResults)
+
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// Drop 1 video packet, duplicate 1 audio packet once (allowed) and other 2 times (1 error)
+	var data []byte
+	videoPackets := 0
+	audioPackets := 0
+	for pos := 0; pos+188 <= len(fixture); pos += 188 {
+		pckt := fixture[pos : pos+188]
+		pID := (int(pckt[1]&0x1F) << 8) | int(pckt[2])
+		if pID == 256 {
+			videoPackets++
+			if videoPackets == 100 {
+				continue
+			}
+		}
+		data = append(data, pckt...)
+		if pID == 257 {
+			audioPackets++
+			if audioPackets == 100 {
+				data = append(data, pckt...)
+			}
+			if audioPackets == 200 {
+				data = append(data, pckt...)
+				data = append(data, pckt...)
+			}
+		}
+	}
+
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	stats := mg.GetStats()
+	if stats.CCErrors != 2 {
+		t.Errorf("CC errors are incorrect, got: %d, want: %d.", stats.CCErrors, 2)
+	}
+	if stats.VideoCCErrors != 1 || stats.AudioCCErrors != 1 {
+		t.Errorf("Video / audio CC errors are incorrect, got: %d/%d, want: %d/%d.", stats.VideoCCErrors, stats.AudioCCErrors, 1, 1)
+	}
+
+	// Original stream, no errors
+	mg = New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+	mg.AddData(fixture)
+	mg.Close()
+
+	if stats = mg.GetStats(); stats.CCErrors != 0 {
+		t.Errorf("CC errors in the original stream, got: %d, want: %d.", stats.CCErrors, 0)
+	}
+}
+
+func TestValidateManualPIDs(t *testing.T) {
+	validPIDs := [][]int{{256, 257}, {256, -1}, {-1, 257}, {0x0010, 0x1FFE}}
+	for _, pids := range validPIDs {
+		if err := ValidateManualPIDs(pids[0], pids[1]); err != nil {
+			t.Errorf("Valid PIDs %v rejected. Err: %v", pids, err)
+		}
+	}
+
+	invalidPIDs := [][]int{{-1, -1}, {256, 256}, {0, 257}, {256, 0x000F}, {0x1FFF, 257}, {256, 8192}}
+	for _, pids := range invalidPIDs {
+		if err := ValidateManualPIDs(pids[0], pids[1]); err == nil {
+			t.Errorf("Invalid PIDs %v accepted", pids)
+		}
+	}
+}
+
+func TestValidateHlsVersion(t *testing.T) {
+	if err := ValidateHlsVersion(3, 0, mediachunk.ChunkOutputModeFile, ChunkInitStart); err != nil {
+		t.Errorf("Version 3 with integer EXTINF rejected. Err: %v", err)
+	}
+	if err := ValidateHlsVersion(0, 3, mediachunk.ChunkOutputModeFileByteRange, ChunkInit); err != nil {
+		t.Errorf("Not pinned version rejected. Err: %v", err)
+	}
+
+	invalid := []struct {
+		version         int
+		extinfPrecision int
+		chunkOutputType mediachunk.OutputTypes
+		chunkInitType   ChunkInitTypes
+	}{
+		{2, 3, mediachunk.ChunkOutputModeFile, ChunkInitStart},
+		{3, 3, mediachunk.ChunkOutputModeFileByteRange, ChunkInitStart},
+		{5, 3, mediachunk.ChunkOutputModeFile, ChunkInit},
+		{0, -1, mediachunk.ChunkOutputModeFile, ChunkInitStart},
+	}
+	for _, test := range invalid {
+		if err := ValidateHlsVersion(test.version, test.extinfPrecision, test.chunkOutputType, test.chunkInitType); err == nil {
+			t.Errorf("Incompatible version accepted: %+v", test)
+		}
+	}
+}
+
+func TestValidateServerControl(t *testing.T) {
+	if err := ValidateServerControl(0, 0, 0, 4, 0); err != nil {
+		t.Errorf("Computed hold backs rejected. Err: %v", err)
+	}
+	if err := ValidateServerControl(12, 1, 24, 4, 0.5); err != nil {
+		t.Errorf("Min hold backs rejected. Err: %v", err)
+	}
+
+	invalid := []struct {
+		holdBackS     float64
+		partHoldBackS float64
+		canSkipUntilS float64
+		targetDurS    float64
+		partDurationS float64
+	}{
+		{11.9, 0, 0, 4, 0},
+		{0, 0.9, 0, 4, 0.5},
+		{0, 1.5, 0, 4, 0},
+		{0, 0, 23.9, 4, 0},
+	}
+	for _, test := range invalid {
+		if err := ValidateServerControl(test.holdBackS, test.partHoldBackS, test.canSkipUntilS, test.targetDurS, test.partDurationS); err == nil {
+			t.Errorf("Invalid hold backs accepted: %+v", test)
+		}
+	}
+}
+
+func TestValidateChunkFormat(t *testing.T) {
+	if err := ValidateChunkFormat(ChunkFormatTS, ChunkInit, 2, 0.5, true); err != nil {
+		t.Errorf("TS chunks rejected. Err: %v", err)
+	}
+	if err := ValidateChunkFormat(ChunkFormatCMAF, ChunkInitStart, 0, 0, false); err != nil {
+		t.Errorf("CMAF chunks rejected. Err: %v", err)
+	}
+
+	invalid := []struct {
+		chunkFormat        ChunkFormats
+		chunkInitType      ChunkInitTypes
+		lhlsAdvancedChunks int
+		partDurationS      float64
+		isIFrames          bool
+	}{
+		{ChunkFormats(2), ChunkInitStart, 0, 0, false},
+		{ChunkFormatCMAF, ChunkInit, 0, 0, false},
+		{ChunkFormatCMAF, ChunkNoIni, 2, 0, false},
+		{ChunkFormatCMAF, ChunkNoIni, 0, 0.5, false},
+		{ChunkFormatCMAF, ChunkNoIni, 0, 0, true},
+	}
+	for _, test := range invalid {
+		if err := ValidateChunkFormat(test.chunkFormat, test.chunkInitType, test.lhlsAdvancedChunks, test.partDurationS, test.isIFrames); err == nil {
+			t.Errorf("Incompatible chunk format accepted: %+v", test)
+		}
+	}
+}
+
+// getPID Returns the PID of a TS packet
+func getPID(pckt []byte) int {
+	return int(pckt[1]&0x1F)<<8 | int(pckt[2])
+}
+
+// readFixturePackets Returns the packets of the fixture file, they can be modified
+func readFixturePackets(t *testing.T, fileName string) [][]byte {
+	fixture, err := ioutil.ReadFile(path.Join("../fixture", fileName))
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	packets := make([][]byte, 0, len(fixture)/188)
+	for i := 0; i+188 <= len(fixture); i = i + 188 {
+		packets = append(packets, fixture[i:i+188:i+188])
+	}
+
+	return packets
+}
+
+// newPSIPacket Returns a packet with the header and data (hex), stuffed with 0xFF
+func newPSIPacket(h string) []byte {
+	pckt := bytes.Repeat([]byte{0xFF}, 188)
+	copy(pckt, parseHexString(h))
+	return pckt
+}
+
+// chunksPIDsCount Returns the number of packets per PID inside all the chunk files of the directory
+func chunksPIDsCount(t *testing.T, pathResults string) map[int]int {
+	files, err := ioutil.ReadDir(pathResults)
+	if err != nil {
+		t.Fatal("Error reading results dir. Err: ", err)
+	}
+
+	pidsCount := map[int]int{}
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".ts" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(pathResults, file.Name()))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		for i := 0; i+188 <= len(data); i = i + 188 {
+			pidsCount[getPID(data[i:i+188])]++
+		}
+	}
+
+	return pidsCount
+}
+
+func TestManifestGeneratorManualPIDsMultiProgram(t *testing.T) {
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// 2 programs, the 1st one (the one in the PAT) has the original PIDs, the 2nd one the same packets with PIDs + 0x200
+	xpectedPIDsCount := map[int]int{}
+	data := make([]byte, 0, 2*len(fixture))
+	for i := 0; i+188 <= len(fixture); i = i + 188 {
+		pckt := fixture[i : i+188]
+		data = append(data, pckt...)
+
+		pid := getPID(pckt)
+		if pid == 0 {
+			continue
+		}
+		remapped := make([]byte, 188)
+		copy(remapped, pckt)
+		remapped[1] = (remapped[1] & 0xE0) | byte((pid+0x200)>>8)
+		remapped[2] = byte(pid + 0x200)
+		data = append(data, remapped...)
+
+		if pid == 256 || pid == 257 {
+			xpectedPIDsCount[pid+0x200]++
+		}
+	}
+
+	// Auto detection gets the 1st program
+	pathResults := "../results/MultiProgramAuto"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[0x300] > 0 || pidsCount[0x301] > 0 || pidsCount[256] <= 0 {
+		t.Errorf("Auto detection did not select the 1st program, got PIDs: %v.", pidsCount)
+	}
+
+	// Manual PIDs select the 2nd program
+	pathResults = "../results/MultiProgramManual"
+	clearResultsDir(pathResults)
+
+	mg = New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, false, 0x300, 0x301, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	pidsCount = chunksPIDsCount(t, pathResults)
+	for pid, count := range xpectedPIDsCount {
+		if pidsCount[pid] != count {
+			t.Errorf("Packets of PID %d are incorrect, got: %d, want: %d.", pid, pidsCount[pid], count)
+		}
+	}
+	if len(pidsCount) != len(xpectedPIDsCount) {
+		t.Errorf("Unexpected PIDs in the chunks, got: %v, want: %v.", pidsCount, xpectedPIDsCount)
+	}
+}
+
+// singleEssenceFixture Returns testSmall.ts without the packets of dropPID and with the PMT replaced by pmtSection (only 1 essence)
+func singleEssenceFixture(t *testing.T, dropPID int, pmtSection string) []byte {
+	pmt := newPSIPacket("4750001000" + pmtSection + "00000000")
+
+	data := []byte{}
+	for _, pckt := range readFixturePackets(t, "testSmall.ts") {
+		switch getPID(pckt) {
+		case dropPID:
+			continue
+		case 4096:
+			pckt = pmt
+		}
+		data = append(data, pckt...)
+	}
+
+	return data
+}
+
+func TestManifestGeneratorAudioOnly(t *testing.T) {
+	pathResults := "../results/AudioOnly"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+
+	// PMT with only AAC audio (PID 257)
+	data := singleEssenceFixture(t, 256, "02B0120001C10000E101F000"+"0FE101F000")
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		mg.AddData(data[:n])
+		data = data[n:]
+	}
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	// Chunks are cut on AAC frame boundaries (1024 samples at 48KHz)
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:3.75466667,\nchunk_00000.ts\n#EXTINF:3.75466667,\nchunk_00001.ts\n#EXTINF:3.75466667,\nchunk_00002.ts\n#EXTINF:0.93866667,\nchunk_00003.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// Init data (PAT / PMT) at the beginning of each chunk
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[0] != 4 || pidsCount[4096] != 4 || pidsCount[256] != 0 || pidsCount[257] <= 0 {
+		t.Errorf("Chunks PIDs are not correct, got: %v.", pidsCount)
+	}
+}
+
+func TestManifestGeneratorVideoOnly(t *testing.T) {
+	pathResults := "../results/VideoOnly"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+
+	// PMT with only h264 video (PID 256)
+	data := singleEssenceFixture(t, 257, "02B0120001C10000E100F000"+"1BE100F000")
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		mg.AddData(data[:n])
+		data = data[n:]
+	}
+	mg.Close()
+
+	if !mg.IsVideoOnly() {
+		t.Errorf("Video only not detected")
+	}
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	// Chunks are cut at the IDRs (every 2s) using the video PTS
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[256] != 912 || pidsCount[257] != 0 {
+		t.Errorf("Chunks PIDs are not correct, got: %v.", pidsCount)
+	}
+}
+
+func TestManifestGeneratorDolbyAudio(t *testing.T) {
+	pathResults := "../results/DolbyAudio"
+	clearResultsDir(pathResults)
+
+	// PMT with AC-3 audio (PID 257, stream type 0x81)
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(singleEssenceFixture(t, -1, "02B0170001C10000E100F000"+"1BE100F000"+"81E101F000"))
+	mg.Close()
+
+	if stats := mg.GetStats(); stats.AudioPID != 257 || mg.GetAudioCodec() != AudioCodecAC3 {
+		t.Errorf("AC-3 audio not detected, got PID: %d, codec: %s.", stats.AudioPID, mg.GetAudioCodec().CodecsString())
+	}
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[256] != 912 || pidsCount[257] != 822 {
+		t.Errorf("Chunks PIDs are not correct, got: %v.", pidsCount)
+	}
+
+	// PMT with AAC (PID 257) and E-AC-3 (PID 258, DVB descriptor)
+	pmtSection := "02B01F0001C10000E100F000" + "0FE101F000" + "06E102F0037A0100" + "1BE100F000"
+	for _, preferredCodec := range []AudioCodecs{AudioCodecAAC, AudioCodecAC3, AudioCodecEAC3} {
+		mg = New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetPreferredAudioCodec(preferredCodec)
+		mg.AddData(singleEssenceFixture(t, -1, pmtSection))
+		mg.Close()
+
+		xpectedAudioPID := int64(257)
+		xpectedCodec := AudioCodecAAC
+		if preferredCodec == AudioCodecEAC3 {
+			xpectedAudioPID = 258
+			xpectedCodec = AudioCodecEAC3
+		}
+		if stats := mg.GetStats(); stats.AudioPID != xpectedAudioPID || mg.GetAudioCodec() != xpectedCodec {
+			t.Errorf("Audio selection is not correct (preferred: %s), got PID: %d, codec: %s, want PID: %d, codec: %s.", preferredCodec.CodecsString(), stats.AudioPID, mg.GetAudioCodec().CodecsString(), xpectedAudioPID, xpectedCodec.CodecsString())
+		}
+	}
+}
+
+// pmtVersionFixture Returns testSmall.ts with the PMT version 1 (pmtSection) from the PMT before the IDR of 5.43s (packet 582), the audio packets from there are remapped to audioPID
+func pmtVersionFixture(t *testing.T, pmtSection string, audioPID int) []byte {
+	pmt := newPSIPacket("4750001000" + pmtSection + "00000000")
+
+	packets := readFixturePackets(t, "testSmall.ts")
+	for i, pckt := range packets[582:] {
+		switch getPID(pckt) {
+		case 4096:
+			packets[582+i] = pmt
+		case 257:
+			pckt[1] = (pckt[1] & 0xE0) | byte(audioPID>>8)
+			pckt[2] = byte(audioPID)
+		}
+	}
+
+	return bytes.Join(packets, nil)
+}
+
+func TestManifestGeneratorPMTVersionChange(t *testing.T) {
+	pathResults := "../results/PMTVersionChange"
+	clearResultsDir(pathResults)
+
+	// PMT version 1 with the audio PID changed to 258
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(pmtVersionFixture(t, "02B0170001C30000E100F000"+"1BE100F000"+"0FE102F000", 258))
+	mg.Close()
+
+	if stats := mg.GetStats(); stats.AudioPID != 258 {
+		t.Errorf("Audio PID is not correct, got: %d, want: %d.", stats.AudioPID, 258)
+	}
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-MAP:URI=\"init00000.ts\"\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init00001.ts\"\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// New init chunk with PAT and the new PMT
+	initChunk, err := ioutil.ReadFile(path.Join(pathResults, "init00001.ts"))
+	if err != nil {
+		t.Fatal("Error reading init chunk. Err: ", err)
+	}
+	if len(initChunk) != 2*188 || getPID(initChunk) != 0 || hex.EncodeToString(initChunk[188+5:188+5+22]) != "02b0170001c30000e100f0001be100f0000fe102f000" {
+		t.Errorf("New init chunk is not correct, got: %x.", initChunk)
+	}
+
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[257] <= 0 || pidsCount[258] <= 0 || pidsCount[257]+pidsCount[258] != 822 {
+		t.Errorf("Chunks PIDs are not correct, got: %v.", pidsCount)
+	}
+
+	// PMT version 1 with the same PIDs
+	pathResults = "../results/PMTVersionChangeSamePIDs"
+	clearResultsDir(pathResults)
+
+	mg = New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(pmtVersionFixture(t, "02B0170001C30000E100F000"+"1BE100F000"+"0FE101F000", 257))
+	mg.Close()
+
+	chunklist = readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist = "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-MAP:URI=\"init00000.ts\"\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct (same PIDs), got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+// mptsFixture Returns testSmall.ts as 2 programs (1: PMT 4096, PIDs 256 / 257, 2: PMT 0x1200, PIDs 0x300 / 0x301)
+func mptsFixture(t *testing.T) []byte {
+	pat := newPSIPacket("4740001000" + "00B0110001C10000" + "0001F000" + "0002F200" + "00000000")
+	pmt2 := newPSIPacket("4752001000" + "02B0170002C10000E300F000" + "1BE300F000" + "0FE301F000" + "00000000")
+
+	data := []byte{}
+	for _, pckt := range readFixturePackets(t, "testSmall.ts") {
+		pid := getPID(pckt)
+		if pid == 0 {
+			data = append(data, pat...)
+			continue
+		}
+		data = append(data, pckt...)
+
+		if pid == 4096 {
+			data = append(data, pmt2...)
+			continue
+		}
+		remapped := append([]byte{}, pckt...)
+		remapped[1] = (remapped[1] & 0xE0) | byte((pid+0x200)>>8)
+		remapped[2] = byte(pid + 0x200)
+		data = append(data, remapped...)
+	}
+
+	return data
+}
+
+func TestManifestGeneratorProgramSelection(t *testing.T) {
+	data := mptsFixture(t)
+
+	// Select the 2nd program by number, and by PMT PID
+	for _, selection := range [][]int{{2, -1}, {-1, 0x1200}} {
+		pathResults := "../results/ProgramSelection"
+		clearResultsDir(pathResults)
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetProgramSelection(selection[0], selection[1])
+		mg.AddData(data)
+		mg.Close()
+
+		if mg.GetError() != nil {
+			t.Errorf("Unexpected error selecting program %v. Err: %v", selection, mg.GetError())
+		}
+
+		pidsCount := chunksPIDsCount(t, pathResults)
+		if pidsCount[0x300] != 912 || pidsCount[0x301] != 822 || pidsCount[256] > 0 || pidsCount[257] > 0 {
+			t.Errorf("Program %v is not selected, got PIDs: %v.", selection, pidsCount)
+		}
+	}
+
+	// Program not in the PAT
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, "../results/ProgramSelection", "chunk_", "chunklist.m3u8", 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetProgramSelection(5, -1)
+	mg.AddData(data)
+	mg.Close()
+
+	if mg.GetError() == nil {
+		t.Errorf("Selecting a program not present in the PAT did not fail")
+	}
+}
+
+// newSpliceInsertPacket Creates a TS packet (PID 0x1F0) with a SCTE-35 splice_insert section (CRC not set)
+func newSpliceInsertPacket(eventID uint32, isCancel bool, isOut bool, ptsS float64, durationS float64) []byte {
+	command := []byte{byte(eventID >> 24), byte(eventID >> 16), byte(eventID >> 8), byte(eventID)}
+	if isCancel {
+		command = append(command, 0xFF)
+	} else {
+		command = append(command, 0x7F)
+
+		flags := byte(0x4F)
+		if isOut {
+			flags = flags | 0x80
+		}
+		if durationS >= 0 {
+			flags = flags | 0x20
+		}
+		if ptsS < 0 {
+			// Immediate
+			flags = flags | 0x10
+		}
+		command = append(command, flags)
+
+		if ptsS >= 0 {
+			pts := uint64(ptsS * 90000)
+			command = append(command, 0xFE|byte(pts>>32), byte(pts>>24), byte(pts>>16), byte(pts>>8), byte(pts))
+		}
+		if durationS >= 0 {
+			duration := uint64(durationS * 90000)
+			command = append(command, 0xFE|byte(duration>>32), byte(duration>>24), byte(duration>>16), byte(duration>>8), byte(duration))
+		}
+		command = append(command, 0x00, 0x01, 0x00, 0x00)
+	}
+
+	section := []byte{0xFC, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xF0 | byte(len(command)>>8), byte(len(command)), 0x05}
+	section = append(section, command...)
+	section = append(section, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	section[1] = 0x30 | byte((len(section)-3)>>8)
+	section[2] = byte(len(section) - 3)
+
+	pckt := make([]byte, 188)
+	for i := range pckt {
+		pckt[i] = 0xFF
+	}
+	copy(pckt, []byte{0x47, 0x41, 0xF0, 0x10, 0x00})
+	copy(pckt[5:], section)
+
+	return pckt
+}
+
+// scte35Fixture Returns testSmall.ts with SCTE-35 PID (0x1F0) in the PMT and the splice packets inserted after the packet indexes
+func scte35Fixture(t *testing.T, splices map[int][]byte) []byte {
+	// PMT with SCTE-35 PID (0x1F0)
+	pmt := newPSIPacket("4750001000" + "02B01C0001C10000E100F000" + "1BE100F000" + "0FE101F000" + "86E1F0F000" + "00000000")
+
+	data := []byte{}
+	for i, pckt := range readFixturePackets(t, "testSmall.ts") {
+		if getPID(pckt) == 4096 {
+			pckt = pmt
+		}
+		data = append(data, pckt...)
+		data = append(data, splices[i]...)
+	}
+
+	return data
+}
+
+// spliceB64 Returns the section of a splice packet in base64
+func spliceB64(pckt []byte) string {
+	return base64.StdEncoding.EncodeToString(pckt[5 : 5+3+int(pckt[7])])
+}
+
+func TestManifestGeneratorSCTE35DateRanges(t *testing.T) {
+	// Chunks (by video IDR PTS): 1.43s - 5.43s, 5.43s - 9.43s, 9.43s - end
+	splices := map[int][]byte{
+		200:  newSpliceInsertPacket(2, false, true, -1, -1),   // Immediate break start, 1st chunk
+		300:  newSpliceInsertPacket(1, false, true, 6.5, 3.0), // Break start with pre-roll, 2nd chunk
+		400:  newSpliceInsertPacket(3, false, true, 10.0, -1), // Cancelled
+		800:  newSpliceInsertPacket(3, true, false, -1, -1),
+		1200: newSpliceInsertPacket(1, false, false, 9.5, -1), // Break end, 3rd chunk
+	}
+	data := scte35Fixture(t, splices)
+
+	pathResults := "../results/SCTE35"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	// The dates are relative to the 1st EXT-X-PROGRAM-DATE-TIME, the splices are at their PTS offset from the chunk start (5.44s the 2nd chunk)
+	chunklist := relativeDates(t, readChunklist(t, path.Join(pathResults, chunklistFile)))
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+0.00\n#EXT-X-DATERANGE:ID=\"splice-00000002\",START-DATE=\"+1.37\",SCTE35-OUT=\"" + spliceB64(splices[200]) + "\"\n#EXTINF:4.00000000,\nchunk_00000.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+4.00\n#EXT-X-DATERANGE:ID=\"splice-00000001\",START-DATE=\"+5.06\",PLANNED-DURATION=3.000,SCTE35-OUT=\"" + spliceB64(splices[300]) + "\"\n#EXTINF:4.00000000,\nchunk_00001.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+8.00\n#EXT-X-DATERANGE:ID=\"splice-00000001\",START-DATE=\"+5.06\",DURATION=3.000,SCTE35-IN=\"" + spliceB64(splices[1200]) + "\"\n#EXTINF:4.00000000,\nchunk_00002.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+func TestManifestGeneratorSCTE35Cut(t *testing.T) {
+	// IDRs every 2s (1.43s, 3.43s, 5.43s, 7.43s, 9.43s, 11.43s)
+	splices := map[int][]byte{
+		200: newSpliceInsertPacket(2, false, true, 0.5, -1),  // Late, attached to the 1st chunk without cut
+		300: newSpliceInsertPacket(1, false, true, 6.5, 1.5), // Cut at 7.43s
+		800: newSpliceInsertPacket(1, false, false, 8.0, -1), // Cut at 9.43s
+	}
+	data := scte35Fixture(t, splices)
+
+	pathResults := "../results/SCTE35Cut"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetSCTE35Cut(true)
+	mg.AddData(data)
+	mg.Close()
+
+	chunklist := relativeDates(t, readChunklist(t, path.Join(pathResults, chunklistFile)))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+0.00\n#EXT-X-DATERANGE:ID=\"splice-00000002\",START-DATE=\"+0.00\",SCTE35-OUT=\"" + spliceB64(splices[200]) + "\"\n#EXTINF:4.00000000,\nchunk_00000.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+4.00\n#EXTINF:2.00000000,\nchunk_00001.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+6.00\n#EXT-X-DATERANGE:ID=\"splice-00000001\",START-DATE=\"+6.00\",PLANNED-DURATION=1.500,SCTE35-OUT=\"" + spliceB64(splices[300]) + "\"\n#EXTINF:2.00000000,\nchunk_00002.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:+8.00\n#EXT-X-DATERANGE:ID=\"splice-00000001\",START-DATE=\"+6.00\",DURATION=2.000,SCTE35-IN=\"" + spliceB64(splices[800]) + "\"\n#EXTINF:4.00000000,\nchunk_00003.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+// relativeDates Replaces the dates of the EXT-X-PROGRAM-DATE-TIME and START-DATE of the chunklist by their seconds from the 1st EXT-X-PROGRAM-DATE-TIME (in 10ms, the dates are truncated to ms)
+func relativeDates(t *testing.T, chunklist string) string {
+	dateRegexp := regexp.MustCompile(`\d{4}-\d{2}-\d{2}T[0-9:.]+Z`)
+	first := time.Time{}
+	return dateRegexp.ReplaceAllStringFunc(chunklist, func(value string) string {
+		date, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			t.Fatal("Error parsing chunklist date. Err: ", err)
+		}
+		if first.IsZero() {
+			if !strings.Contains(chunklist, "#EXT-X-PROGRAM-DATE-TIME:"+value) {
+				t.Errorf("Date %s before the 1st EXT-X-PROGRAM-DATE-TIME", value)
+			}
+			first = date
+		}
+		return fmt.Sprintf("+%.2f", date.Sub(first).Seconds())
+	})
+}
+
+// shiftTimestamps Adds offsetTicks (90KHz) to the PCRs, PTSs and DTSs of the TS packets (33 bits wraparound)
+func shiftTimestamps(data []byte, offsetTicks uint64) {
+	shift := func(ticks uint64) uint64 {
+		return (ticks + offsetTicks) % (1 << 33)
+	}
+	shiftPESTime := func(b []byte) {
+		ticks := uint64(b[0]&0x0E)<<29 | uint64(b[1])<<22 | uint64(b[2]&0xFE)<<14 | uint64(b[3])<<7 | uint64(b[4])>>1
+		ticks = shift(ticks)
+		b[0] = (b[0] & 0xF1) | byte(ticks>>29)&0x0E
+		b[1] = byte(ticks >> 22)
+		b[2] = byte(ticks>>14)&0xFE | 0x01
+		b[3] = byte(ticks >> 7)
+		b[4] = byte(ticks<<1) | 0x01
+	}
+
+	for i := 0; i+188 <= len(data); i = i + 188 {
+		pckt := data[i : i+188]
+
+		payloadOffset := 4
+		if pckt[3]&0x20 > 0 {
+			// Adaptation field with PCR
+			if pckt[4] > 0 && pckt[5]&0x10 > 0 {
+				base := uint64(pckt[6])<<25 | uint64(pckt[7])<<17 | uint64(pckt[8])<<9 | uint64(pckt[9])<<1 | uint64(pckt[10])>>7
+				base = shift(base)
+				pckt[6], pckt[7], pckt[8], pckt[9] = byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1)
+				pckt[10] = (pckt[10] & 0x7F) | byte(base<<7)
+			}
+			payloadOffset = payloadOffset + 1 + int(pckt[4])
+		}
+
+		pes := pckt[payloadOffset:]
+		if pckt[1]&0x40 == 0 || len(pes) < 19 || pes[0] != 0x00 || pes[1] != 0x00 || pes[2] != 0x01 {
+			continue
+		}
+		if pes[7]&0x80 > 0 {
+			shiftPESTime(pes[9:14])
+		}
+		if pes[7]&0x40 > 0 {
+			shiftPESTime(pes[14:19])
+		}
+	}
+}
+
+func TestManifestGeneratorTimestampsWraparound(t *testing.T) {
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	// The timestamps wrap 6s after the start, in the middle of the 2nd chunk
+	shiftTimestamps(fixture, (1<<33)-6*90000)
+
+	pathResults := "../results/Wraparound"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(fixture)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	// Same chunks than without the wraparound, and no discontinuity
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+// jumpFixture Returns testSmall.ts with the timestamps jumping 1000s at the IDR of 5.43s (packet 583), with or without the discontinuity_indicator
+func jumpFixture(t *testing.T, setIndicator bool) []byte {
+	packets := readFixturePackets(t, "testSmall.ts")
+	for _, pckt := range packets[583:] {
+		shiftTimestamps(pckt, 1000*90000)
+	}
+	if setIndicator {
+		// Adaptation field flags
+		packets[583][5] = packets[583][5] | 0x80
+	}
+
+	return bytes.Join(packets, nil)
+}
+
+func TestManifestGeneratorTimestampsDiscontinuity(t *testing.T) {
+	for _, setIndicator := range []bool{true, false} {
+		pathResults := "../results/TimestampsDiscontinuity"
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.AddData(jumpFixture(t, setIndicator))
+		mg.Close()
+
+		chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+		xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+		if chunklist != xpectedChunklist {
+			t.Errorf("Chunklist is not correct (discontinuity indicator: %t), got: %s, want: %s.", setIndicator, chunklist, xpectedChunklist)
+		}
+	}
+}
+
+func TestManifestGeneratorHEVC(t *testing.T) {
+	pathResults := "../results/HEVC"
+	clearResultsDir(pathResults)
+
+	// 10 fps, IRAPs at 0s (IDR_W_RADL), 4s (CRA), 6s (IDR_N_LP) and 10s (IDR_W_RADL), the TRAIL_R of every 2s have the parameter sets and the random_access_indicator set too
+	// The VCL NAL units of the CRA and the last IDR are in the next packet of their PES (large SEI)
+	data, err := ioutil.ReadFile("../fixture/testHEVC.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:6.00000000,\nchunk_00001.ts\n#EXTINF:2.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// Every chunk starts at an IRAP (video PES start of the frame)
+	pesStarts := []int{}
+	for i := 0; i+188 <= len(data); i = i + 188 {
+		if getPID(data[i:i+188]) == 256 && data[i+1]&0x40 > 0 {
+			pesStarts = append(pesStarts, i)
+		}
+	}
+	for n, frame := range []int{0, 40, 100} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", n)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		for i := 0; i+188 <= len(chunk); i = i + 188 {
+			if getPID(chunk[i:i+188]) != 256 {
+				continue
+			}
+			if !bytes.Equal(chunk[i:i+188], data[pesStarts[frame]:pesStarts[frame]+188]) {
+				t.Errorf("Chunk %d does not start at the IRAP of frame %d", n, frame)
+			}
+			break
+		}
+	}
+}
+
+func TestManifestGeneratorMPEG2Video(t *testing.T) {
+	pathResults := "../results/MPEG2Video"
+	clearResultsDir(pathResults)
+
+	// 25 fps, I pictures every 2s and P pictures. The I pictures of 2s and 8s have no sequence header, they can not start a chunk
+	data, err := ioutil.ReadFile("../fixture/testMPEG2.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:6.00000000,\nchunk_00001.ts\n#EXTINF:2.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// Every chunk starts with sequence header + I picture
+	for n := 0; n < 3; n++ {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", n)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		for i := 0; i+188 <= len(chunk); i = i + 188 {
+			pckt := chunk[i : i+188]
+			if getPID(pckt) != 256 {
+				continue
+			}
+			offset := 4
+			if pckt[3]&0x20 > 0 {
+				offset = 5 + int(pckt[4])
+			}
+			es := pckt[offset+9+int(pckt[offset+8]):]
+			picture := bytes.Index(es, []byte{0x00, 0x00, 0x01, 0x00})
+			if !bytes.HasPrefix(es, []byte{0x00, 0x00, 0x01, 0xB3}) || picture < 0 || (es[picture+5]>>3)&0x07 != 1 {
+				t.Errorf("Chunk %d does not start with sequence header + I picture, got: %x", n, es[:32])
+			}
+			break
+		}
+	}
+}
+
+// noKeyframesFixture Returns testSmall.ts with the random_access_indicator cleared in all the IDRs except the ones in keepIndexes (packet index)
+func noKeyframesFixture(t *testing.T, keepIndexes map[int]bool) []byte {
+	packets := readFixturePackets(t, "testSmall.ts")
+	for i, pckt := range packets {
+		if getPID(pckt) == 256 && pckt[3]&0x20 > 0 && pckt[4] > 0 && !keepIndexes[i] {
+			pckt[5] = pckt[5] &^ 0x40
+		}
+	}
+
+	return bytes.Join(packets, nil)
+}
+
+func TestManifestGeneratorMaxSegmentDur(t *testing.T) {
+	pathResults := "../results/MaxSegmentDur"
+	clearResultsDir(pathResults)
+
+	// Only the 1st IDR (1.44s) is a keyframe, the chunks are cut when they reach 5s
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetMaxSegmentDurS(5.0, MaxSegmentDurCut)
+	mg.AddData(noKeyframesFixture(t, map[int]bool{3: true}))
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	// Target duration updated to the longest chunk, the chunks cut without keyframe are not independent
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:5\n#EXTINF:5.10000000,\nchunk_00000.ts\n#EXTINF:5.10000000,\nchunk_00001.ts\n#EXTINF:1.80000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// No media data is lost
+	mediaBytes := 0
+	for n := 0; n < 3; n++ {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", n)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		mediaBytes = mediaBytes + len(chunk)
+	}
+	if xpectedMediaBytes := (912 + 822) * 188; mediaBytes != xpectedMediaBytes {
+		t.Errorf("Media data is not correct, got: %d (bytes), want: %d (bytes).", mediaBytes, xpectedMediaBytes)
+	}
+}
+
+func TestManifestGeneratorMaxSegmentDurDrop(t *testing.T) {
+	pathResults := "../results/MaxSegmentDurDrop"
+	clearResultsDir(pathResults)
+
+	// Keyframes at 1.44s and 9.44s (packet 1192), the data between 6.54s and 9.44s is dropped
+	fixture := noKeyframesFixture(t, map[int]bool{3: true, 1192: true})
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetMaxSegmentDurS(5.0, MaxSegmentDurDrop)
+	mg.AddData(fixture)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:5\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:5.10000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// The chunk after the drop starts with the keyframe
+	chunk, err := ioutil.ReadFile(path.Join(pathResults, "chunk_00001.ts"))
+	if err != nil {
+		t.Fatal("Error reading chunk. Err: ", err)
+	}
+	if len(chunk) < 188 || !bytes.Equal(chunk[:188], fixture[1192*188:1193*188]) {
+		t.Error("The chunk after the dropped data does not start with the keyframe")
+	}
+}
+
+func TestManifestGeneratorMinSegmentDur(t *testing.T) {
+	pathResults := "../results/MinSegmentDur"
+
+	// Keyframes every 2s, with target duration 1s every keyframe cuts. The min duration skips the keyframes before 3s
+	for _, minSegmentDurS := range []float64{0, 3.0} {
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 1.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetMinSegmentDurS(minSegmentDurS)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+		xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:2\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:2.00000000,\nchunk_00000.ts\n#EXTINF:2.00000000,\nchunk_00001.ts\n#EXTINF:2.00000000,\nchunk_00002.ts\n#EXTINF:2.00000000,\nchunk_00003.ts\n#EXTINF:2.00000000,\nchunk_00004.ts\n#EXTINF:2.00000000,\nchunk_00005.ts\n#EXT-X-ENDLIST\n"
+		if minSegmentDurS > 0 {
+			xpectedChunklist = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+		}
+		if chunklist != xpectedChunklist {
+			t.Errorf("Chunklist is not correct (min duration: %f), got: %s, want: %s.", minSegmentDurS, chunklist, xpectedChunklist)
+		}
+
+		// The last chunk lasts until its last frame (the stream ends 2s after the last keyframe), it is not empty
+		xpectedLastDurS := 2.0
+		if minSegmentDurS > 0 {
+			xpectedLastDurS = 4.0
+		}
+		lastDurS := 0.0
+		fmt.Sscanf(chunklist[strings.LastIndex(chunklist, "#EXTINF:"):], "#EXTINF:%f,", &lastDurS)
+		if math.Abs(lastDurS-xpectedLastDurS) > 0.001 {
+			t.Errorf("Last chunk duration is not correct (min duration: %f), got: %f, want: %f.", minSegmentDurS, lastDurS, xpectedLastDurS)
+		}
+	}
+}
+
+func TestManifestGeneratorMinSegmentDurLHLS(t *testing.T) {
+	pathResults := "../results/MinSegmentDurLHLS"
+	clearResultsDir(pathResults)
+
+	// The advanced chunks are announced with the target duration, not the min
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 1.0, ChunkNoIni, true, -1, -1, hls.LiveWindow, 3, 2, nil, nil)
+	mg.SetMinSegmentDurS(3.0)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	// The chunks are announced with the target duration (1s), the finalized ones get their real duration (4s), the hint is the chunk streamed now
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:1.00000000,\nchunk_00002.ts\n#EXTINF:1.00000000,\nchunk_00003.ts\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"chunk_00002.ts\"\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+// extraPIDsFixture Returns testSmall.ts with a 2nd program in the PAT (PMT PID 0x1010), a private data ES (PID 0x102) in the PMT, and packets of both PIDs after each PMT
+func extraPIDsFixture(t *testing.T) []byte {
+	data := []byte{}
+	extraCC := 0
+	for _, pckt := range readFixturePackets(t, "testSmall.ts") {
+		switch getPID(pckt) {
+		case 0:
+			data = append(data, newPSIPacket(hex.EncodeToString(pckt[:4])+"00"+"00B0110001C100000001F0000002F010"+"6852BC8A")...)
+		case 4096:
+			data = append(data, newPSIPacket(hex.EncodeToString(pckt[:4])+"00"+"02B01C0001C10000E100F0001BE100F0000FE101F00006E102F000"+"9C840842")...)
+			data = append(data, newPSIPacket(fmt.Sprintf("474102%02X", 0x10|extraCC%16)+"000102030405")...)
+			data = append(data, newPSIPacket(fmt.Sprintf("475010%02X", 0x10|extraCC%16)+"00"+"02B0170002C10000E200F0001BE200F0000FE201F000"+"00000000")...)
+			extraCC++
+		default:
+			data = append(data, pckt...)
+		}
+	}
+
+	return data
+}
+
+func TestManifestGeneratorPIDFilter(t *testing.T) {
+	fixture, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+
+	pathResults := "../results/PIDFilter"
+
+	for _, keepPIDs := range [][]int{{}, {0x102}} {
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetPIDFilter(true, keepPIDs)
+		mg.AddData(extraPIDsFixture(t))
+		mg.Close()
+
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, "chunk_00000.ts"))
+		if err != nil || len(chunk) < 2*188 {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+
+		// The PAT only has the selected program (same as the original one)
+		if !bytes.Equal(chunk[:188], fixture[188:2*188]) {
+			t.Errorf("PAT is not correct (keep PIDs: %v), got: %x, want: %x.", keepPIDs, chunk[:188], fixture[188:2*188])
+		}
+
+		// The PMT only has the private data ES if it is kept
+		xpectedPMT := fixture[2*188 : 3*188]
+		if len(keepPIDs) > 0 {
+			xpectedPMT = extraPIDsFixture(t)[2*188 : 3*188]
+		}
+		if !bytes.Equal(chunk[188:2*188], xpectedPMT) {
+			t.Errorf("PMT is not correct (keep PIDs: %v), got: %x, want: %x.", keepPIDs, chunk[188:2*188], xpectedPMT)
+		}
+
+		pidsCount := chunksPIDsCount(t, pathResults)
+		if pidsCount[0x1010] != 0 {
+			t.Errorf("Packets of the other program found in the chunks, got: %d, want: 0.", pidsCount[0x1010])
+		}
+		xpectedExtraPackets := 0
+		if len(keepPIDs) > 0 {
+			xpectedExtraPackets = 46
+		}
+		if pidsCount[0x102] != xpectedExtraPackets {
+			t.Errorf("Kept PID packets are not correct, got: %d, want: %d.", pidsCount[0x102], xpectedExtraPackets)
+		}
+		if pidsCount[256] != 912 || pidsCount[257] != 822 {
+			t.Errorf("Media packets are not correct, got: %d / %d, want: 912 / 822.", pidsCount[256], pidsCount[257])
+		}
+	}
+}
+
+func TestManifestGeneratorPIDRemap(t *testing.T) {
+	pathResults := "../results/PIDRemap"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetPIDRemap(0x200, 0x201, 0x300)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	if err := mg.GetError(); err != nil {
+		t.Fatal("Error remapping PIDs. Err: ", err)
+	}
+
+	// Init segment PAT and PMT reference the new PIDs
+	initData, err := ioutil.ReadFile(path.Join(pathResults, "init00000.ts"))
+	if err != nil || len(initData) != 2*188 {
+		t.Fatal("Error reading init chunk. Err: ", err)
+	}
+	xpectedInit := "474000" + "1000" + "00B00D0001C100000001E300" + "480BC072"
+	if got := hex.EncodeToString(initData[:5+16]); got != strings.ToLower(xpectedInit) {
+		t.Errorf("Init PAT is not correct, got: %s, want: %s.", got, strings.ToLower(xpectedInit))
+	}
+	xpectedInit = "474300" + "1000" + "02B0170001C10000E200F0001BE200F0000FE201F000" + "95EBBBAB"
+	if got := hex.EncodeToString(initData[188 : 188+5+26]); got != strings.ToLower(xpectedInit) {
+		t.Errorf("Init PMT is not correct, got: %s, want: %s.", got, strings.ToLower(xpectedInit))
+	}
+
+	pidsCount := chunksPIDsCount(t, pathResults)
+	if pidsCount[0x200] != 912 || pidsCount[0x201] != 822 || pidsCount[256] != 0 || pidsCount[257] != 0 {
+		t.Errorf("Remapped packets are not correct, got: %v.", pidsCount)
+	}
+}
+
+func TestManifestGeneratorPIDRemapCollision(t *testing.T) {
+	pathResults := "../results/PIDRemapCollision"
+	clearResultsDir(pathResults)
+
+	// Audio remapped to the (not remapped) video PID
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeNone, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetPIDRemap(-1, 256, -1)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	if err := mg.GetError(); err == nil {
+		t.Error("Remapped PID collision not detected")
+	}
+
+	if err := ValidateRemapPIDs(0x200, 0x200, -1, nil); err == nil {
+		t.Error("Invalid remap PIDs accepted (same PID)")
+	}
+	if err := ValidateRemapPIDs(0x200, 0x201, -1, []int{0x201}); err == nil {
+		t.Error("Invalid remap PIDs accepted (kept PID)")
+	}
+}
+
+// metadataFixture Returns testSmall.ts with a timed metadata ES (stream type 0x15, PID 0x102) in the PMT, and a 2 packets ID3 PES split by the IDR of 5.43s (packet 583)
+func metadataFixture(t *testing.T) []byte {
+	newPESPacket := func(h string) []byte {
+		pckt := make([]byte, 188)
+		copy(pckt, parseHexString(h))
+		return pckt
+	}
+
+	data := []byte{}
+	for i, pckt := range readFixturePackets(t, "testSmall.ts") {
+		if i == 583 {
+			// PES start (private_stream_1, PES_packet_length 362, PTS 5.43s), ID3 header
+			data = append(data, newPESPacket("47410210"+"000001BD016A"+"8080052107E1B601"+"4944330400")...)
+		}
+		if getPID(pckt) == 4096 {
+			pckt = newPSIPacket(hex.EncodeToString(pckt[:4]) + "00" + "02B01C0001C10000E100F0001BE100F0000FE101F00015E102F000" + "C441AED9")
+		}
+		data = append(data, pckt...)
+		if i == 583 {
+			// PES end
+			data = append(data, newPESPacket("47010211")...)
+		}
+	}
+
+	return data
+}
+
+func TestManifestGeneratorDataPIDs(t *testing.T) {
+	pathResults := "../results/DataPIDs"
+	clearResultsDir(pathResults)
+
+	fixture := metadataFixture(t)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetPIDFilter(true, nil)
+	mg.SetDataPIDs(nil, true)
+	mg.AddData(fixture)
+	mg.Close()
+
+	// The whole PES is in the chunk after the cut
+	for n, xpectedDataPackets := range []int{0, 2, 0} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", n)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		dataPackets := 0
+		for i := 0; i+188 <= len(chunk); i = i + 188 {
+			if getPID(chunk[i:i+188]) == 0x102 {
+				dataPackets++
+			}
+		}
+		if dataPackets != xpectedDataPackets {
+			t.Errorf("Data packets in chunk %d are not correct, got: %d, want: %d.", n, dataPackets, xpectedDataPackets)
+		}
+
+		// The PMT declares the metadata stream
+		if !bytes.Equal(chunk[188:2*188], fixture[2*188:3*188]) {
+			t.Errorf("PMT in chunk %d is not correct, got: %x, want: %x.", n, chunk[188:2*188], fixture[2*188:3*188])
+		}
+	}
+}
+
+func TestManifestGeneratorCaptions(t *testing.T) {
+	pathResults := "../results/Captions"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	captionsChunklistFile := "chunklist_captions.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetCaptions(true, captionsChunklistFile)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	captionsChunklist := readChunklist(t, path.Join(pathResults, captionsChunklistFile))
+
+	// Same durations and media sequence
+	xpectedCaptionsChunklist := strings.ReplaceAll(chunklist, ".ts\n", ".vtt\n")
+	if captionsChunklist != xpectedCaptionsChunklist {
+		t.Errorf("Captions chunklist is not correct, got: %s, want: %s.", captionsChunklist, xpectedCaptionsChunklist)
+	}
+
+	// No captions in the fixture, every chunk has an empty WebVTT
+	for _, fileName := range []string{"chunk_00000.vtt", "chunk_00001.vtt", "chunk_00002.vtt"} {
+		vtt, err := ioutil.ReadFile(path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error reading captions chunk. Err: ", err)
+		}
+		if !strings.HasPrefix(string(vtt), "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:") || strings.Contains(string(vtt), "-->") {
+			t.Errorf("Captions chunk %s is not correct, got: %s.", fileName, string(vtt))
+		}
+	}
+}
+
+func TestManifestGeneratorCMAF(t *testing.T) {
+	pathResults := "../results/CMAF"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetChunkFormat(ChunkFormatCMAF)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	if err := mg.GetError(); err != nil {
+		t.Fatal("Error generating the CMAF chunks. Err: ", err)
+	}
+
+	// Same durations as the TS chunks, with the init segment
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-MAP:URI=\"init00000.mp4\"\n#EXTINF:4.00000000,\nchunk_00000.m4s\n#EXTINF:4.00000000,\nchunk_00001.m4s\n#EXTINF:4.00000000,\nchunk_00002.m4s\n#EXT-X-ENDLIST\n"
+	if chunklist := readChunklist(t, path.Join(pathResults, chunklistFile)); chunklist != xpectedChunklist {
+		t.Errorf("CMAF chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	for fileName, xpectedBox := range map[string]string{"init00000.mp4": "ftyp", "chunk_00000.m4s": "moof", "chunk_00001.m4s": "moof", "chunk_00002.m4s": "moof"} {
+		data, err := ioutil.ReadFile(path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error reading CMAF file. Err: ", err)
+		}
+		if len(data) < 8 || string(data[4:8]) != xpectedBox {
+			t.Errorf("CMAF file %s does not start with %s", fileName, xpectedBox)
+		}
+	}
+}
+
+func TestManifestGeneratorDash(t *testing.T) {
+	pathResults := "../results/Dash"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetChunkFormat(ChunkFormatCMAF)
+	mg.SetDash("manifest.mpd")
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	data, err := ioutil.ReadFile(path.Join(pathResults, "manifest.mpd"))
+	if err != nil {
+		t.Fatal("Error reading MPD. Err: ", err)
+	}
+	mpd := string(data)
+
+	// Same chunks than the chunklist (3 of 4s)
+	for _, xpected := range []string{
+		"profiles=\"urn:mpeg:dash:profile:isoff-live:2011\" type=\"static\" mediaPresentationDuration=\"PT12.000S\"",
+		"mimeType=\"video/mp4\"",
+		"startNumber=\"0\" media=\"chunk_$Number%05d$.m4s\" initialization=\"init00000.mp4\">",
+		"d=\"360000\" r=\"2\"/>\n",
+	} {
+		if !strings.Contains(mpd, xpected) {
+			t.Errorf("MPD is not correct, got: %s, want it to contain: %s.", mpd, xpected)
+		}
+	}
+}
+
+func TestValidateEncryption(t *testing.T) {
+	if err := ValidateEncryption(encryption.MethodNone, mediachunk.ChunkOutputModeFileByteRange, ChunkInit, ChunkFormatCMAF, 0.5, true); err != nil {
+		t.Errorf("Not encrypted chunks rejected. Err: %v", err)
+	}
+	if err := ValidateEncryption(encryption.MethodAES128, mediachunk.ChunkOutputModeHTTPChunkedTransfer, ChunkInitStart, ChunkFormatTS, 0, false); err != nil {
+		t.Errorf("Encrypted chunks rejected. Err: %v", err)
+	}
+	if err := ValidateEncryption(encryption.MethodSampleAES, mediachunk.ChunkOutputModeFileByteRange, ChunkInit, ChunkFormatTS, 0, false); err != nil {
+		t.Errorf("SAMPLE-AES chunks rejected. Err: %v", err)
+	}
+
+	invalid := []struct {
+		method          encryption.Methods
+		chunkOutputType mediachunk.OutputTypes
+		chunkInitType   ChunkInitTypes
+		chunkFormat     ChunkFormats
+		partDurationS   float64
+		isIFrames       bool
+	}{
+		{encryption.Methods(5), mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatTS, 0, false},
+		{encryption.MethodAES128, mediachunk.ChunkOutputModeFileByteRange, ChunkInitStart, ChunkFormatTS, 0, false},
+		{encryption.MethodAES128, mediachunk.ChunkOutputModeFile, ChunkInit, ChunkFormatTS, 0, false},
+		{encryption.MethodAES128, mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatCMAF, 0, false},
+		{encryption.MethodSampleAES, mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatCMAF, 0, false},
+		{encryption.MethodSampleAES, mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatTS, 0.5, false},
+		{encryption.MethodAES128, mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatTS, 0.5, false},
+		{encryption.MethodAES128, mediachunk.ChunkOutputModeFile, ChunkInitStart, ChunkFormatTS, 0, true},
+	}
+	for _, test := range invalid {
+		if err := ValidateEncryption(test.method, test.chunkOutputType, test.chunkInitType, test.chunkFormat, test.partDurationS, test.isIFrames); err == nil {
+			t.Errorf("Incompatible encryption accepted: %+v", test)
+		}
+	}
+}
+
+// decryptChunk Returns the AES-128 CBC decrypted file (without the PKCS7 padding)
+func decryptChunk(t *testing.T, fileName string, key []byte, iv []byte) []byte {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Error reading encrypted chunk. Err: ", err)
+	}
+	block, _ := aes.NewCipher(key)
+	if len(data) <= 0 || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("Encrypted chunk %s is not whole blocks, got: %d bytes.", fileName, len(data))
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	return data[:len(data)-int(data[len(data)-1])]
+}
+
+func TestManifestGeneratorEncryption(t *testing.T) {
+	pathResults := "../results/Encryption"
+	key := []byte("0123456789abcdef")
+
+	// The key is the same for all the chunks, the IVs are the media sequence or random ones in the chunklist
+	for _, ivMode := range []encryption.IVModes{encryption.IVMediaSequence, encryption.IVRandom} {
+		clearResultsDir(pathResults)
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		if err := mg.SetEncryption(encryption.MethodAES128, key, "", "", ivMode, "", ""); err != nil {
+			t.Fatal("Error setting the encryption. Err: ", err)
+		}
+		mg.SetChunkFlush(1000, 0)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		savedKey, err := ioutil.ReadFile(path.Join(pathResults, "key00000.key"))
+		if err != nil || !bytes.Equal(savedKey, key) {
+			t.Errorf("Key file is not correct, got: %x. Err: %v", savedKey, err)
+		}
+
+		chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+		keys := regexp.MustCompile(`#EXT-X-KEY:METHOD=AES-128,URI="key00000.key"(,IV=0x([0-9a-f]{32}))?\n`).FindAllStringSubmatch(chunklist, -1)
+		xpectedKeys := 1
+		if ivMode == encryption.IVRandom {
+			xpectedKeys = 3
+		}
+		if len(keys) != xpectedKeys {
+			t.Fatalf("EXT-X-KEY tags are not correct (IV mode: %d), got: %s.", ivMode, chunklist)
+		}
+
+		for i := uint64(0); i < 3; i++ {
+			iv := encryption.SequenceIV(i)
+			if ivMode == encryption.IVRandom {
+				iv, _ = hex.DecodeString(keys[i][2])
+			}
+			data := decryptChunk(t, path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i)), key, iv)
+			if len(data) <= 0 || len(data)%188 != 0 || data[0] != 0x47 || data[188] != 0x47 {
+				t.Errorf("Decrypted chunk %d is not TS (IV mode: %d), got: %d bytes.", i, ivMode, len(data))
+			}
+		}
+	}
+}
+
+func TestManifestGeneratorKeyRotation(t *testing.T) {
+	pathResults := "../results/KeyRotation"
+
+	// Chunks of 4, 4 and 2s: every chunk, or after 5s of media (at the 3rd chunk)
+	tests := []struct {
+		chunks      int
+		durationS   float64
+		xpectedKeys []int
+	}{
+		{1, 0, []int{0, 1, 2}},
+		{0, 5, []int{0, 0, 1}},
+		{0, 0, []int{0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		clearResultsDir(pathResults)
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		if err := mg.SetEncryption(encryption.MethodAES128, nil, "", "", encryption.IVMediaSequence, "", ""); err != nil {
+			t.Fatal("Error setting the encryption. Err: ", err)
+		}
+		if err := mg.SetKeyRotation(test.chunks, test.durationS); err != nil {
+			t.Fatal("Error setting the key rotation. Err: ", err)
+		}
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		// The chunklist has the key of every chunk, only when it changes
+		chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+		keys := regexp.MustCompile(`#EXT-X-KEY:METHOD=AES-128,URI="key(\d{5}).key"\n#EXTINF:[0-9.]+,\nchunk_(\d{5}).ts`).FindAllStringSubmatch(chunklist, -1)
+		xpectedTags := test.xpectedKeys[len(test.xpectedKeys)-1] + 1
+		if len(keys) != xpectedTags {
+			t.Fatalf("EXT-X-KEY tags are not correct (rotation: %d, %f), got: %s.", test.chunks, test.durationS, chunklist)
+		}
+
+		for i, keyIndex := range test.xpectedKeys {
+			key, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("key%05d.key", keyIndex)))
+			if err != nil {
+				t.Fatal("Error reading key file. Err: ", err)
+			}
+			data := decryptChunk(t, path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i)), key, encryption.SequenceIV(uint64(i)))
+			if len(data) <= 0 || len(data)%188 != 0 || data[0] != 0x47 || data[188] != 0x47 {
+				t.Errorf("Decrypted chunk %d with key %d is not TS (rotation: %d, %f), got: %d bytes.", i, keyIndex, test.chunks, test.durationS, len(data))
+			}
+		}
+		if _, err := os.Stat(path.Join(pathResults, fmt.Sprintf("key%05d.key", xpectedTags))); err == nil {
+			t.Errorf("Unused key file saved (rotation: %d, %f)", test.chunks, test.durationS)
+		}
+	}
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetKeyRotation(-1, 0); err == nil {
+		t.Errorf("Negative key rotation is accepted")
+	}
+}
+
+// testKeyProvider Key provider of the test, it fails from the key failFromIndex
+type testKeyProvider struct {
+	failFromIndex uint64
+	requests      []uint64
+}
+
+func (p *testKeyProvider) GetKey(index uint64) (encryption.ProvidedKey, error) {
+	p.requests = append(p.requests, index)
+	if index >= p.failFromIndex {
+		return encryption.ProvidedKey{}, errors.New("key server down")
+	}
+	return encryption.ProvidedKey{Key: bytes.Repeat([]byte{byte(index + 1)}, encryption.KeyLength), URI: fmt.Sprintf("https://keys.example.com/%d?token=a", index)}, nil
+}
+
+func TestManifestGeneratorKeyProvider(t *testing.T) {
+	pathResults := "../results/KeyProvider"
+	clearResultsDir(pathResults)
+
+	// New key every chunk, the provider fails at the 3rd one (the 2nd key is kept)
+	provider := testKeyProvider{2, nil}
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetEncryption(encryption.MethodAES128, nil, "", "", encryption.IVMediaSequence, "", ""); err != nil {
+		t.Fatal("Error setting the encryption. Err: ", err)
+	}
+	if err := mg.SetKeyProvider(&provider); err != nil {
+		t.Fatal("Error setting the key provider. Err: ", err)
+	}
+	mg.SetKeyRotation(1, 0)
+	// The next keys are requested in the background, they are received before the next packet
+	for _, pckt := range readFixturePackets(t, "testSmall.ts") {
+		mg.AddData(pckt)
+		if mg.encryption.nextKey != nil {
+			<-mg.encryption.nextKey.done
+		}
+	}
+	mg.Close()
+
+	// The failed key is requested again for the next rotation
+	if fmt.Sprint(provider.requests) != "[0 1 2 2]" {
+		t.Errorf("Key provider requests are not correct, got: %v, want: %v.", provider.requests, "[0 1 2 2]")
+	}
+
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	xpectedKeys := "#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/0?token=a\"\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/1?token=a\"\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n"
+	if !strings.Contains(chunklist, xpectedKeys) {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedKeys)
+	}
+
+	for i, keyIndex := range []int{0, 1, 1} {
+		data := decryptChunk(t, path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i)), bytes.Repeat([]byte{byte(keyIndex + 1)}, encryption.KeyLength), encryption.SequenceIV(uint64(i)))
+		if len(data) <= 0 || data[0] != 0x47 || data[188] != 0x47 {
+			t.Errorf("Decrypted chunk %d with key %d is not TS", i, keyIndex)
+		}
+	}
+
+	// The keys are not saved in the chunks destination
+	if matches, _ := filepath.Glob(path.Join(pathResults, "*"+KeyFileExtension)); len(matches) > 0 {
+		t.Errorf("Key files saved, got: %v.", matches)
+	}
+
+	if err := mg.SetKeyProvider(&testKeyProvider{0, nil}); err == nil {
+		t.Errorf("Key provider without keys is accepted")
+	}
+}
+
+// blockingKeyProvider Key provider of the test, the keys after the 1st one are not returned until release is closed
+type blockingKeyProvider struct {
+	release chan struct{}
+}
+
+func (p *blockingKeyProvider) GetKey(index uint64) (encryption.ProvidedKey, error) {
+	if index > 0 {
+		<-p.release
+	}
+	return encryption.ProvidedKey{Key: bytes.Repeat([]byte{byte(index + 1)}, encryption.KeyLength), URI: fmt.Sprintf("https://keys.example.com/%d", index)}, nil
+}
+
+func TestManifestGeneratorKeyProviderNotReady(t *testing.T) {
+	pathResults := "../results/KeyProviderNotReady"
+	clearResultsDir(pathResults)
+
+	// The next key is never received during the stream, the rotations do not wait for it
+	provider := blockingKeyProvider{make(chan struct{})}
+	defer close(provider.release)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetEncryption(encryption.MethodAES128, nil, "", "", encryption.IVMediaSequence, "", ""); err != nil {
+		t.Fatal("Error setting the encryption. Err: ", err)
+	}
+	if err := mg.SetKeyProvider(&provider); err != nil {
+		t.Fatal("Error setting the key provider. Err: ", err)
+	}
+	mg.SetKeyRotation(1, 0)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if strings.Count(chunklist, "#EXT-X-KEY:") != 1 || !strings.Contains(chunklist, "#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/0\"\n") {
+		t.Errorf("Chunklist keys are not correct (only the 1st one), got: %s.", chunklist)
+	}
+}
+
+// readChunks Returns the data of the chunks (chunk_00000.ts, ...) of the path
+func readChunks(t *testing.T, pathResults string, num int) []byte {
+	data := []byte{}
+	for i := 0; i < num; i++ {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		data = append(data, chunk...)
+	}
+	return data
+}
+
+func TestManifestGeneratorSampleAES(t *testing.T) {
+	pathResults := "../results/SampleAES"
+	key := []byte("0123456789abcdef")
+
+	// Clear run, to compare the audio data
+	clearResultsDir(pathResults)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+	clearData := readChunks(t, pathResults, 3)
+
+	clearResultsDir(pathResults)
+	mg = New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetEncryption(encryption.MethodSampleAES, key, "", "", encryption.IVMediaSequence, "identity", "1"); err != nil {
+		t.Fatal("Error setting the encryption. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if !strings.Contains(chunklist, "#EXT-X-VERSION:5\n") || strings.Count(chunklist, `#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key00000.key",KEYFORMAT="identity",KEYFORMATVERSIONS="1"`+"\n") != 1 {
+		t.Errorf("Chunklist is not correct, got: %s.", chunklist)
+	}
+
+	data := readChunks(t, pathResults, 3)
+	if len(data)%188 != 0 {
+		t.Fatalf("Chunks are not TS packets, got: %d bytes.", len(data))
+	}
+
+	pmtPID := -1
+	streamTypes := map[int]uint8{}
+	ccs := map[int]int{}
+	audioPackets := [][]byte{}
+	for i := 0; i < len(data); i = i + 188 {
+		pckt := data[i : i+188]
+		if pckt[0] != 0x47 {
+			t.Fatalf("Packet %d has no sync byte", i/188)
+		}
+		pID := getPID(pckt)
+
+		tsp := tspacket.New(188)
+		tsp.AddData(pckt)
+		tsp.Parse(pmtPID)
+		if pID == 0 {
+			pmtPID = tsp.GetPATdata()
+		} else if pID == pmtPID {
+			_, streams := tsp.GetPMTStreams()
+			for _, stream := range streams {
+				streamTypes[int(stream.PID)] = stream.StreamType
+			}
+		}
+
+		if pID == 256 || pID == 257 {
+			if pckt[3]&0x10 > 0 {
+				if cc, found := ccs[pID]; found && int(pckt[3]&0x0F) != (cc+1)&0x0F {
+					t.Fatalf("PID %d packet %d continuity counter is not correct, got: %d, want: %d.", pID, i/188, pckt[3]&0x0F, (cc+1)&0x0F)
+				}
+				ccs[pID] = int(pckt[3] & 0x0F)
+			}
+			if pID == 257 {
+				audioPackets = append(audioPackets, pckt)
+			}
+		}
+	}
+	if streamTypes[256] != tspacket.SampleAESH264StreamType || streamTypes[257] != tspacket.SampleAESADTSStreamType {
+		t.Errorf("PMT stream types are not correct, got: %v.", streamTypes)
+	}
+
+	// The audio frames keep their size, so the packets are the same ones with different data
+	clearAudioPackets := [][]byte{}
+	for i := 0; i+188 <= len(clearData); i = i + 188 {
+		if getPID(clearData[i:i+188]) == 257 {
+			clearAudioPackets = append(clearAudioPackets, clearData[i:i+188])
+		}
+	}
+	if len(audioPackets) != len(clearAudioPackets) {
+		t.Fatalf("Audio packets are not correct, got: %d, want: %d.", len(audioPackets), len(clearAudioPackets))
+	}
+	if bytes.Equal(bytes.Join(audioPackets, nil), bytes.Join(clearAudioPackets, nil)) {
+		t.Errorf("Audio is not encrypted")
+	}
+}
+
+func TestManifestGeneratorUploadGap(t *testing.T) {
+	pathResults := "../results/UploadGap"
+
+	// The upload of the 2nd chunk fails (not retryable error)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+		if strings.HasSuffix(req.URL.Path, "chunk_00001.ts") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:8\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXT-X-GAP\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+
+	// Regular upload (before the chunk is added) and chunked transfer (the upload ends later)
+	for _, chunkOutputType := range []mediachunk.OutputTypes{mediachunk.ChunkOutputModeHTTPRegular, mediachunk.ChunkOutputModeHTTPChunkedTransfer} {
+		clearResultsDir(pathResults)
+
+		httpUploader := httpuploader.New(nil, false, u.Scheme, u.Host, 2, 1)
+		mg := New(nil, chunkOutputType, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, &httpUploader, nil)
+		mg.SetGapOnUploadFailure(true)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+		httpUploader.WaitPendingUploads(5 * time.Second)
+		mg.MarkUploadGaps()
+
+		chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+		if chunklist != xpectedChunklist {
+			t.Errorf("Chunklist is not correct (output: %d), got: %s, want: %s.", chunkOutputType, chunklist, xpectedChunklist)
+		}
+		if gaps := mg.GetStats().Gaps; gaps != 1 {
+			t.Errorf("Gaps are not correct (output: %d), got: %d, want: %d.", chunkOutputType, gaps, 1)
+		}
+	}
+}
+
+func TestManifestGeneratorFinalizeAsVod(t *testing.T) {
+	pathResults := "../results/FinalizeAsVod"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, nil, nil)
+	mg.SetFinalizeAsVod(true, time.Second)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// The last (partial) chunk keeps its duration
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// Not finalized if a chunk upload failed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+		if strings.HasSuffix(req.URL.Path, "chunk_00002.ts") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	httpUploader := httpuploader.New(nil, false, u.Scheme, u.Host, 2, 1)
+
+	clearResultsDir(pathResults)
+	mg = New(nil, mediachunk.ChunkOutputModeHTTPChunkedTransfer, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, &httpUploader, nil)
+	mg.SetFinalizeAsVod(true, 5*time.Second)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist = readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if !strings.Contains(chunklist, "#EXT-X-PLAYLIST-TYPE:EVENT\n") || strings.Contains(chunklist, "#EXT-X-ENDLIST") {
+		t.Errorf("Chunklist with a failed upload is finalized, got: %s.", chunklist)
+	}
+}
+
+func TestManifestGeneratorEndlist(t *testing.T) {
+	pathResults := "../results/Endlist"
+
+	closeLive := func(mode EndlistModes, grace time.Duration) (ManifestGenerator, string) {
+		clearResultsDir(pathResults)
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+		mg.SetEndlist(mode, grace)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+		return mg, readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	}
+
+	if _, chunklist := closeLive(EndlistNone, 0); strings.Contains(chunklist, "#EXT-X-ENDLIST") {
+		t.Errorf("Chunklist left open is closed, got: %s.", chunklist)
+	}
+	if _, chunklist := closeLive(EndlistOnClose, 0); !strings.HasSuffix(chunklist, "chunk_00002.ts\n#EXT-X-ENDLIST\n") {
+		t.Errorf("Chunklist is not closed, got: %s.", chunklist)
+	}
+
+	// Added after the grace period
+	mg, chunklist := closeLive(EndlistAfterGrace, 50*time.Millisecond)
+	if strings.Contains(chunklist, "#EXT-X-ENDLIST") {
+		t.Errorf("Chunklist is closed before the grace period, got: %s.", chunklist)
+	}
+	mg.WaitEndlist()
+	if chunklist = readChunklist(t, path.Join(pathResults, "chunklist.m3u8")); !strings.HasSuffix(chunklist, "#EXT-X-ENDLIST\n") {
+		t.Errorf("Chunklist is not closed after the grace period, got: %s.", chunklist)
+	}
+
+	// Canceled by new data
+	mg, _ = closeLive(EndlistAfterGrace, 50*time.Millisecond)
+	fixture, _ := ioutil.ReadFile("../fixture/testSmall.ts")
+	mg.AddData(fixture[:188])
+	mg.WaitEndlist()
+	time.Sleep(100 * time.Millisecond)
+	if chunklist = readChunklist(t, path.Join(pathResults, "chunklist.m3u8")); strings.Contains(chunklist, "#EXT-X-ENDLIST") {
+		t.Errorf("Chunklist with new data is closed, got: %s.", chunklist)
+	}
+}
+
+// noKeyframePCRFixture Returns testSmall.ts without PCR in the video keyframe packets (the other video packets keep it)
+func noKeyframePCRFixture(t *testing.T) []byte {
+	packets := readFixturePackets(t, "testSmall.ts")
+	for _, pckt := range packets {
+		if getPID(pckt) == 256 && pckt[3]&0x20 > 0 && pckt[4] > 0 && pckt[5]&0x40 > 0 {
+			pckt[5] = pckt[5] &^ 0x10
+		}
+	}
+
+	return bytes.Join(packets, nil)
+}
+
+func TestManifestGeneratorDurationSource(t *testing.T) {
+	pathResults := "../results/DurationSource"
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:2.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+
+	// No PCR in the keyframe packets, the auto source uses the PCR PID (before it was never chunked)
+	for _, source := range []DurationSources{DurationSourceAuto, DurationSourcePCR} {
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		mg.SetDurationSource(source, MaxDurationDriftSDefault)
+		mg.AddData(noKeyframePCRFixture(t))
+		mg.Close()
+
+		chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+		if chunklist != xpectedChunklist {
+			t.Errorf("Chunklist is not correct (source: %d), got: %s, want: %s.", source, chunklist, xpectedChunklist)
+		}
+	}
+}
+
+// syntheticAudioStream Returns an audio only TS (AAC PID 0x101) with a PES (1 packet, PTS only) every stepTicks from startTicks
+func syntheticAudioStream(startTicks uint64, stepTicks uint64, numPES int) []byte {
+	newPacket := func(header string, payload []byte) []byte {
+		pckt := make([]byte, 188)
+		for i := range pckt {
+			pckt[i] = 0xFF
+		}
+		copy(pckt, parseHexString(header))
+		copy(pckt[4:], payload)
+		return pckt
+	}
+
+	data := newPacket("47400010", parseHexString("00"+"00B00D0001C100000001F000"+"2AB104B2"))
+	data = append(data, newPacket("47500010", parseHexString("00"+"02B0120001C10000E101F000"+"0FE101F000"+"00000000"))...)
+	for n := 0; n < numPES; n++ {
+		ticks := (startTicks + uint64(n)*stepTicks) % (1 << 33)
+		pes := []byte{0x00, 0x00, 0x01, 0xC0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21 | byte(ticks>>29)&0x0E, byte(ticks >> 22), byte(ticks>>14) | 0x01, byte(ticks >> 7), byte(ticks<<1) | 0x01, 0xFF, 0xF1}
+		data = append(data, newPacket(fmt.Sprintf("474101%X", 0x10|n%16), pes)...)
+	}
+
+	return data
+}
+
+func TestManifestGeneratorExactDurations(t *testing.T) {
+	pathResults := "../results/ExactDurations"
+	clearResultsDir(pathResults)
+
+	// 2 hours of PES every 9009 ticks (0.1001s), the timestamps wrap after 1 hour. The last chunk lasts until the end of its last PES
+	stepTicks := uint64(9009)
+	numPES := int(2*3600*90000/stepTicks) + 1
+	data := syntheticAudioStream((1<<33)-3600*90000, stepTicks, numPES)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeNone, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkNoIni, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.AddData(data)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+
+	sumS := 0.0
+	numChunks := 0
+	for _, line := range strings.Split(chunklist, "\n") {
+		if strings.HasPrefix(line, "#EXTINF:") {
+			var durS float64
+			fmt.Sscanf(strings.TrimPrefix(line, "#EXTINF:"), "%f,", &durS)
+			if ticks := durS * 90000; ticks-math.Round(ticks) > 0.001 || ticks-math.Round(ticks) < -0.001 {
+				t.Errorf("Chunk duration is not an exact number of ticks, got: %.8f.", durS)
+			}
+			sumS = sumS + durS
+			numChunks++
+		}
+	}
+
+	xpectedSumS := float64(uint64(numPES)*stepTicks) / 90000
+	if numChunks < 1700 || sumS < xpectedSumS-1.0/90000 || sumS > xpectedSumS+1.0/90000 {
+		t.Errorf("Sum of the chunk durations is not correct (%d chunks), got: %.8f, want: %.8f.", numChunks, sumS, xpectedSumS)
+	}
+}
+
+func TestManifestGeneratorVodTotalDuration(t *testing.T) {
+	pathResults := "../results/VodTotalDuration"
+
+	// The fixture has 12s of video (360 frames at 30fps, IDRs every 2s), the last chunk lasts until its last frame
+	xpectedSumS := 12.0
+	for _, targetDurS := range []float64{4.0, 5.0} {
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, targetDurS, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+		if !strings.HasSuffix(chunklist, "#EXT-X-ENDLIST\n") {
+			t.Errorf("Chunklist is not closed (target: %f), got: %s.", targetDurS, chunklist)
+		}
+
+		sumS := 0.0
+		maxDurS := 0.0
+		targetDur := 0
+		for _, line := range strings.Split(chunklist, "\n") {
+			if strings.HasPrefix(line, "#EXTINF:") {
+				var durS float64
+				fmt.Sscanf(strings.TrimPrefix(line, "#EXTINF:"), "%f,", &durS)
+				sumS = sumS + durS
+				maxDurS = math.Max(maxDurS, durS)
+			} else if strings.HasPrefix(line, "#EXT-X-TARGETDURATION:") {
+				fmt.Sscanf(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), "%d", &targetDur)
+			}
+		}
+		if math.Abs(sumS-xpectedSumS) > 1.0/30 {
+			t.Errorf("Sum of the chunk durations is not correct (target: %f), got: %.8f, want: %.8f.", targetDurS, sumS, xpectedSumS)
+		}
+		if targetDur != int(math.Round(maxDurS)) {
+			t.Errorf("Target duration is not correct (target: %f), got: %d, want: %d.", targetDurS, targetDur, int(math.Round(maxDurS)))
+		}
+	}
+}
+
+func TestManifestGeneratorGeneratePSI(t *testing.T) {
+	pathResults := "../results/GeneratePSI"
+	clearResultsDir(pathResults)
+
+	// The source PAT has 2 programs, the generated one only the selected
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetProgramSelection(2, -1)
+	mg.SetPSIGeneration(true)
+	mg.AddData(mptsFixture(t))
+	mg.Close()
+
+	initData, err := ioutil.ReadFile(path.Join(pathResults, "init00000.ts"))
+	if err != nil || len(initData) != 2*188 {
+		t.Fatal("Error reading init chunk. Err: ", err)
+	}
+	xpectedInit := "4740001000" + "00B00D0001C100000002F200" + "882A6F34"
+	if got := hex.EncodeToString(initData[:5+16]); got != strings.ToLower(xpectedInit) {
+		t.Errorf("Init PAT is not correct, got: %s, want: %s.", got, strings.ToLower(xpectedInit))
+	}
+	xpectedInit = "4752001000" + "02B0170002C10000E300F0001BE300F0000FE301F000" + "7B182293"
+	if got := hex.EncodeToString(initData[188 : 188+5+26]); got != strings.ToLower(xpectedInit) {
+		t.Errorf("Init PMT is not correct, got: %s, want: %s.", got, strings.ToLower(xpectedInit))
+	}
+	if !bytes.Equal(initData[188+5+26:], bytes.Repeat([]byte{0xFF}, 188-5-26)) {
+		t.Error("Init PMT is not stuffed")
+	}
+}
+
+func TestManifestGeneratorGeneratePSIManualPIDs(t *testing.T) {
+	pathResults := "../results/GeneratePSIManualPIDs"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, false, 256, 257, hls.Vod, 3, 0, nil, nil)
+	mg.SetPSIGeneration(true)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+
+	// Every chunk starts with the generated PAT and PMT (h264 and AAC)
+	for _, fileName := range []string{"chunk_00000.ts", "chunk_00001.ts", "chunk_00002.ts"} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fileName))
+		if err != nil || len(chunk) < 3*188 {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		xpectedPSI := "4740001000" + "00B00D0001C100000001F000" + "2AB104B2"
+		if got := hex.EncodeToString(chunk[:5+16]); got != strings.ToLower(xpectedPSI) {
+			t.Errorf("PAT of %s is not correct, got: %s, want: %s.", fileName, got, strings.ToLower(xpectedPSI))
+		}
+		xpectedPSI = "4750001000" + "02B0170001C10000E100F0001BE100F0000FE101F000" + "2F44B99B"
+		if got := hex.EncodeToString(chunk[188 : 188+5+26]); got != strings.ToLower(xpectedPSI) {
+			t.Errorf("PMT of %s is not correct, got: %s, want: %s.", fileName, got, strings.ToLower(xpectedPSI))
+		}
+	}
+}
+
+func TestManifestGeneratorPSIInterval(t *testing.T) {
+	pathResults := "../results/PSIInterval"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInit, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetPSIInterval(500)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// PAT and PMT at the start of every chunk and every 0.5s of PCR (8 per chunk, the last one has PCRs after its last IDR), continuity counters continue across chunks
+	xpectedPSIs := []int{8, 8, 8}
+	xpectedCC := 0
+	for i, fileName := range []string{"chunk_00000.ts", "chunk_00001.ts", "chunk_00002.ts"} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		if getPID(chunk[0:188]) != 0 || getPID(chunk[188:2*188]) != 4096 {
+			t.Errorf("Chunk %s does not start with PAT and PMT, got PIDs: %d, %d.", fileName, getPID(chunk[0:188]), getPID(chunk[188:2*188]))
+		}
+
+		psis := 0
+		for n := 0; n+188 <= len(chunk); n = n + 188 {
+			pID := getPID(chunk[n : n+188])
+			if pID != 0 && pID != 4096 {
+				continue
+			}
+			if cc := int(chunk[n+3] & 0x0F); cc != xpectedCC%16 {
+				t.Errorf("Continuity counter of the PID %d in %s is not correct, got: %d, want: %d.", pID, fileName, cc, xpectedCC%16)
+			}
+			if pID == 4096 {
+				psis++
+				xpectedCC++
+			}
+		}
+		if psis != xpectedPSIs[i] {
+			t.Errorf("PSI repetitions of %s are not correct, got: %d, want: %d.", fileName, psis, xpectedPSIs[i])
+		}
+	}
+}
+
+func TestManifestGeneratorProgramDateTime(t *testing.T) {
+	pathResults := "../results/ProgramDateTime"
+	chunklistFile := "chunklist.m3u8"
+	clearResultsDir(pathResults)
+
+	startTime := time.Now().Add(-time.Second)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, nil, nil)
+	mg.SetProgramDateTime(2)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.SetDiscontinuity()
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// Every 2 chunks, and after the discontinuity (chunk 3)
+	programDateTimes := map[string]time.Time{}
+	lines := strings.Split(readChunklist(t, path.Join(pathResults, chunklistFile)), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			programDateTime, err := time.Parse("2006-01-02T15:04:05.000Z07:00", strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+			if err != nil {
+				t.Fatal("Error parsing program date time. Err: ", err)
+			}
+			programDateTimes[lines[i+2]] = programDateTime
+		}
+	}
+	for _, fileName := range []string{"chunk_00000.ts", "chunk_00002.ts", "chunk_00003.ts", "chunk_00004.ts"} {
+		if _, found := programDateTimes[fileName]; !found {
+			t.Errorf("Program date time of %s not found, got: %v.", fileName, programDateTimes)
+		}
+	}
+	if len(programDateTimes) != 4 {
+		t.Errorf("Program date times are not correct, got: %v.", programDateTimes)
+	}
+
+	if programDateTimes["chunk_00000.ts"].Before(startTime) || programDateTimes["chunk_00000.ts"].After(time.Now()) {
+		t.Errorf("Program date time is not the wall clock, got: %v.", programDateTimes["chunk_00000.ts"])
+	}
+	if got := programDateTimes["chunk_00002.ts"].Sub(programDateTimes["chunk_00000.ts"]); got != 8*time.Second {
+		t.Errorf("Program date time is not carried forward by the chunk durations, got: %v, want: %v.", got, 8*time.Second)
+	}
+	// Re-anchored, the input is faster than real time so it continues after the previous chunk
+	if got := programDateTimes["chunk_00003.ts"].Sub(programDateTimes["chunk_00002.ts"]); got < 2*time.Second {
+		t.Errorf("Program date time after the discontinuity is not monotonic, got: %v, want: >= %v.", got, 2*time.Second)
+	}
+	if got := programDateTimes["chunk_00004.ts"].Sub(programDateTimes["chunk_00003.ts"]); got != 4*time.Second {
+		t.Errorf("Program date time is not carried forward by the chunk durations, got: %v, want: %v.", got, 4*time.Second)
+	}
+}
+
+// tdtFixture Returns testSmall.ts with a TDT packet after the random access points in tdtSections (by IDR number)
+func tdtFixture(t *testing.T, tdtSections map[int]string) []byte {
+	ret := []byte{}
+	idr := 0
+	for _, pckt := range readFixturePackets(t, "testSmall.ts") {
+		ret = append(ret, pckt...)
+		if getPID(pckt) == 256 && pckt[3]&0x20 > 0 && pckt[4] > 0 && pckt[5]&0x40 > 0 {
+			if section, found := tdtSections[idr]; found {
+				ret = append(ret, newPSIPacket("47401410"+"00"+section)...)
+			}
+			idr++
+		}
+	}
+
+	return ret
+}
+
+func TestManifestGeneratorProgramDateTimeDVB(t *testing.T) {
+	pathResults := "../results/ProgramDateTimeDVB"
+	chunklistFile := "chunklist.m3u8"
+	clearResultsDir(pathResults)
+
+	// 2020/01/01 00:00:10 at the 1st IDR, 00:00:19 (1s jump) at the 3rd chunk IDR (IDR every 2s)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetProgramDateTime(1)
+	mg.SetProgramDateTimeSource(PDTSourceDVB)
+	mg.AddData(tdtFixture(t, map[int]string{0: "707005E5E1000010", 4: "707005E5E1000019"}))
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:10.000Z\n#EXTINF:4.00000000,\nchunk_00000.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:14.000Z\n#EXTINF:4.00000000,\nchunk_00001.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:19.000Z\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-ENDLIST\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+func TestManifestGeneratorMasterBandwidth(t *testing.T) {
+	pathResults := "../results/MasterBandwidth"
+	clearResultsDir(pathResults)
+
+	master := hls.NewMaster(nil, path.Join(pathResults, "master.m3u8"), []hls.Variant{{URI: "chunklist.m3u8", Codecs: "avc1.4d401f,mp4a.40.2"}}, nil, hls.HlsOutputModeFile, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetMasterPlaylist(master, "chunklist.m3u8", 2)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// Last 2 chunks (4s each)
+	chunkBits := []float64{}
+	for _, fileName := range []string{"chunk_00001.ts", "chunk_00002.ts"} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		chunkBits = append(chunkBits, float64(len(chunk)*8))
+	}
+	xpectedBandwidth := int(math.Ceil(math.Max(chunkBits[0]/4, chunkBits[1]/4)))
+	xpectedAvgBandwidth := int(math.Ceil((chunkBits[0] + chunkBits[1]) / 8))
+
+	got := readChunklist(t, path.Join(pathResults, "master.m3u8"))
+	xpected := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"avc1.4d401f,mp4a.40.2\"\nchunklist.m3u8\n", xpectedBandwidth, xpectedAvgBandwidth)
+	if got != xpected {
+		t.Errorf("Master playlist is not correct, got: %s, want: %s.", got, xpected)
+	}
+}
+
+func TestManifestGeneratorByteRange(t *testing.T) {
+	pathResults := "../results/ByteRange"
+	pathResultsFiles := "../results/ByteRangeFiles"
+	clearResultsDir(pathResults)
+	clearResultsDir(pathResultsFiles)
+
+	// Same chunks as whole files, to compare the ranges
+	mgFiles := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResultsFiles, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	addFileData(&mgFiles, "../fixture/testSmall.ts", 4*1024)
+	mgFiles.Close()
+
+	mg := New(nil, mediachunk.ChunkOutputModeFileByteRange, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	file, err := ioutil.ReadFile(path.Join(pathResults, "chunk_00000.ts"))
+	if err != nil {
+		t.Fatal("Error reading byte range file. Err: ", err)
+	}
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n"
+	offset := 0
+	for i, durationS := range []string{"4.00000000", "4.00000000", "4.00000000"} {
+		chunk, err := ioutil.ReadFile(path.Join(pathResultsFiles, fmt.Sprintf("chunk_%05d.ts", i)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		if offset+len(chunk) > len(file) || !bytes.Equal(file[offset:offset+len(chunk)], chunk) {
+			t.Errorf("Byte range %d@%d does not contain the chunk %d", len(chunk), offset, i)
+		}
+		xpectedChunklist = xpectedChunklist + fmt.Sprintf("#EXTINF:%s,\n#EXT-X-BYTERANGE:%d@%d\nchunk_00000.ts\n", durationS, len(chunk), offset)
+		offset = offset + len(chunk)
+	}
+	xpectedChunklist = xpectedChunklist + "#EXT-X-ENDLIST\n"
+
+	if offset != len(file) {
+		t.Errorf("Byte ranges do not add up to the file size, got: %d, want: %d.", offset, len(file))
+	}
+
+	got := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if got != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", got, xpectedChunklist)
+	}
+}
+
+func TestManifestGeneratorByteRangeRotation(t *testing.T) {
+	pathResults := "../results/ByteRangeRotation"
+	clearResultsDir(pathResults)
+
+	// Every chunk is bigger than the max, so each one starts a new file
+	mg := New(nil, mediachunk.ChunkOutputModeFileByteRange, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, nil, nil)
+	mg.SetByteRangeMaxFileBytes(1024)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n"
+	for i, durationS := range []string{"4.00000000", "4.00000000", "4.00000000"} {
+		fileName := fmt.Sprintf("chunk_%05d.ts", i)
+		info, err := os.Stat(path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error reading byte range file. Err: ", err)
+		}
+		if i > 0 {
+			xpectedChunklist = xpectedChunklist + "#EXT-X-DISCONTINUITY\n"
+		}
+		xpectedChunklist = xpectedChunklist + fmt.Sprintf("#EXTINF:%s,\n#EXT-X-BYTERANGE:%d@0\n%s\n", durationS, info.Size(), fileName)
+	}
+
+	got := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if !strings.HasPrefix(got, xpectedChunklist) {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", got, xpectedChunklist)
+	}
+}
+
+func TestManifestGeneratorIFrames(t *testing.T) {
+	pathResults := "../results/IFrames"
+	clearResultsDir(pathResults)
+
+	master := hls.NewMaster(nil, path.Join(pathResults, "master.m3u8"), []hls.Variant{{URI: "chunklist.m3u8", Bandwidth: 1000000, Codecs: "avc1.4d401f,mp4a.40.2"}}, nil, hls.HlsOutputModeFile, nil, nil)
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetIFramesPlaylist(true, "chunklist_iframes.m3u8")
+	mg.SetMasterPlaylist(master, "chunklist.m3u8", 0)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// IDR every 2s, the last one spans until the last video PTS
+	segments := regexp.MustCompile(`#EXTINF:([0-9.]+),\n(iframe_chunk_\d+\.ts)\n`).FindAllStringSubmatch(readChunklist(t, path.Join(pathResults, "chunklist_iframes.m3u8")), -1)
+	if len(segments) != 6 {
+		t.Fatalf("Number of I-frames is not correct, got: %d, want: 6.", len(segments))
+	}
+	got := readChunklist(t, path.Join(pathResults, "chunklist_iframes.m3u8"))
+	xpectedHeader := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-I-FRAMES-ONLY\n"
+	if !strings.HasPrefix(got, xpectedHeader) || !strings.HasSuffix(got, "#EXT-X-ENDLIST\n") {
+		t.Errorf("I-frames chunklist is not correct, got: %s.", got)
+	}
+
+	peakBitrate := 0.0
+	for i, segment := range segments {
+		if i < len(segments)-1 && segment[1] != "2.00000000" {
+			t.Errorf("I-frame %d span is not correct, got: %s, want: 2.00000000.", i, segment[1])
+		}
+
+		data, err := ioutil.ReadFile(path.Join(pathResults, segment[2]))
+		if err != nil {
+			t.Fatal("Error reading I-frame chunk. Err: ", err)
+		}
+		if len(data) < 3*tspacket.TsDefaultPacketSize || getPID(data) != 0 || getPID(data[tspacket.TsDefaultPacketSize:]) != 4096 {
+			t.Fatalf("I-frame chunk %s does not start with the PAT and PMT", segment[2])
+		}
+		// Only the keyframe PES (starting with the random access point)
+		for pos := 2 * tspacket.TsDefaultPacketSize; pos < len(data); pos = pos + tspacket.TsDefaultPacketSize {
+			pckt := data[pos : pos+tspacket.TsDefaultPacketSize]
+			isPESStart := pckt[1]&0x40 != 0
+			isRandomAccess := pckt[3]&0x20 != 0 && pckt[4] > 0 && pckt[5]&0x40 != 0
+			if getPID(pckt) != 256 || (pos == 2*tspacket.TsDefaultPacketSize && !isRandomAccess) || (pos > 2*tspacket.TsDefaultPacketSize && isPESStart) {
+				t.Fatalf("I-frame chunk %s packet at %d is not part of the keyframe", segment[2], pos)
+			}
+		}
+
+		durationS, _ := strconv.ParseFloat(segment[1], 64)
+		peakBitrate = math.Max(peakBitrate, float64(len(data)*8)/durationS)
+	}
+
+	gotMaster := readChunklist(t, path.Join(pathResults, "master.m3u8"))
+	xpectedMaster := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.4d401f,mp4a.40.2\"\nchunklist.m3u8\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,CODECS=\"avc1.4d401f,mp4a.40.2\",URI=\"chunklist_iframes.m3u8\"\n", int(math.Ceil(peakBitrate)))
+	if gotMaster != xpectedMaster {
+		t.Errorf("Master playlist is not correct, got: %s, want: %s.", gotMaster, xpectedMaster)
+	}
+}
+
+func TestManifestGeneratorParts(t *testing.T) {
+	pathResults := "../results/Parts"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetPartDuration(0.5)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if !strings.Contains(chunklist, "#EXT-X-TARGETDURATION:4\n#EXT-X-PART-INF:PART-TARGET=0.50000\n") {
+		t.Errorf("EXT-X-PART-INF is not correct, got: %s.", chunklist)
+	}
+
+	// The parts of every chunk are before its EXTINF, the ones starting at the IDRs (every 2s) are independent
+	for n := 0; n < 2; n++ {
+		xpectedParts := ""
+		for i := 0; i < 8; i++ {
+			xpectedParts = xpectedParts + fmt.Sprintf("#EXT-X-PART:DURATION=0.50000,URI=\"chunk_%05d.%d.ts\"", n, i)
+			if i%4 == 0 {
+				xpectedParts = xpectedParts + ",INDEPENDENT=YES"
+			}
+			xpectedParts = xpectedParts + "\n"
+		}
+		xpectedParts = xpectedParts + fmt.Sprintf("#EXTINF:4.00000000,\nchunk_%05d.ts\n", n)
+		if !strings.Contains(chunklist, xpectedParts) {
+			t.Errorf("Parts of the chunk %d are not correct, got: %s, want: %s.", n, chunklist, xpectedParts)
+		}
+
+		// The parts have the same data than the chunk
+		chunk, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", n)))
+		if err != nil {
+			t.Fatal("Error reading chunk. Err: ", err)
+		}
+		parts := []byte{}
+		for i := 0; i < 8; i++ {
+			part, err := ioutil.ReadFile(path.Join(pathResults, fmt.Sprintf("chunk_%05d.%d.ts", n, i)))
+			if err != nil {
+				t.Fatal("Error reading part. Err: ", err)
+			}
+			parts = append(parts, part...)
+		}
+		if !bytes.Equal(chunk, parts) {
+			t.Errorf("Parts data of the chunk %d is not correct, got: %d (bytes), want: %d (bytes).", n, len(parts), len(chunk))
+		}
+	}
+}
+
+func TestManifestGeneratorPreloadHint(t *testing.T) {
+	pathResults := "../results/PreloadHint"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 0, nil, nil)
+	mg.SetPartDuration(0.5)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+
+	// The hint is the part after the last one in the chunklist
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	lines := strings.Split(strings.TrimSuffix(chunklist, "\n"), "\n")
+	hintPrefix := "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\""
+	hint := lines[len(lines)-1]
+	if !strings.HasPrefix(hint, hintPrefix) {
+		t.Fatalf("Chunklist does not end with the preload hint, got: %s.", chunklist)
+	}
+	hintFileName := strings.TrimSuffix(strings.TrimPrefix(hint, hintPrefix), "\"")
+	xpectedHintFileName := "chunk_00002.7.ts"
+	if hintFileName != xpectedHintFileName || !strings.HasPrefix(lines[len(lines)-2], "#EXT-X-PART:DURATION=0.50000,URI=\"chunk_00002.6.ts\"") {
+		t.Errorf("Preload hint is not correct, got: %s, want: %s.", chunklist, xpectedHintFileName)
+	}
+
+	// The hinted part is the one written
+	mg.Close()
+	if _, err := os.Stat(path.Join(pathResults, hintFileName)); err != nil {
+		t.Errorf("Hinted part %s does not exist. Err: %v", hintFileName, err)
+	}
+
+	chunklist = readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	if strings.Contains(chunklist, "#EXT-X-PRELOAD-HINT") {
+		t.Errorf("Preload hint is in the chunklist after the last chunk, got: %s.", chunklist)
+	}
+}
+
+func TestManifestGeneratorDeltaChunklist(t *testing.T) {
+	pathResults := "../results/DeltaChunklist"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+	mg.SetServerControl(true, 0, 0)
+	mg.SetDeltaChunklist("chunklist_delta.m3u8", 0)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+
+	// Saved with the chunklist, the window (10s) is within CAN-SKIP-UNTIL (24s) so nothing is skipped
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	delta := readChunklist(t, path.Join(pathResults, "chunklist_delta.m3u8"))
+	if !strings.Contains(chunklist, "#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=24.000,CAN-BLOCK-RELOAD=YES,HOLD-BACK=12.000\n") {
+		t.Errorf("Server control is not correct, got: %s.", chunklist)
+	}
+	if delta != chunklist {
+		t.Errorf("Delta chunklist is not correct, got: %s, want: %s.", delta, chunklist)
+	}
+}
+
+func TestManifestGeneratorLHLSRealDurations(t *testing.T) {
+	pathResults := "../results/LHLSRealDurations"
+	clearResultsDir(pathResults)
+
+	// Target 3s, the chunks are cut at the IDRs (every 2s) so they last 4s, the last one until its last frame (4s)
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 3.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 2, nil, nil)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+
+	// Saved when every chunk is finalized, the advanced ones keep the target duration
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedChunks := "#EXT-X-TARGETDURATION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:3.00000000,\nchunk_00002.ts\n#EXTINF:3.00000000,\nchunk_00003.ts\n"
+	if !strings.Contains(chunklist, xpectedChunks) {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunks)
+	}
+
+	mg.Close()
+	chunklist = readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedChunks = "#EXTINF:4.00000000,\nchunk_00000.ts\n#EXTINF:4.00000000,\nchunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXTINF:3.00000000,\nchunk_00003.ts\n"
+	if !strings.Contains(chunklist, xpectedChunks) {
+		t.Errorf("Chunklist after the last chunk is not correct, got: %s, want: %s.", chunklist, xpectedChunks)
+	}
+}
+
+func TestManifestGeneratorLHLSLiveWindow(t *testing.T) {
+	pathResults := "../results/LHLSLiveWindow"
+	clearResultsDir(pathResults)
+
+	// Chunks of 2s (IDRs), the window has 3 completed chunks and the 3 advanced ones after them (the 1st one streamed now)
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 2.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 3, nil, nil)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	xpectedChunklist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:2\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n#EXT-X-TARGETDURATION:2\n#EXT-X-INDEPENDENT-SEGMENTS\n"
+	for i := 2; i < 8; i++ {
+		xpectedChunklist = xpectedChunklist + fmt.Sprintf("#EXTINF:2.00000000,\nchunk_%05d.ts\n", i)
+	}
+	xpectedChunklist = xpectedChunklist + "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"chunk_00005.ts\"\n"
+	if chunklist != xpectedChunklist {
+		t.Errorf("Chunklist is not correct, got: %s, want: %s.", chunklist, xpectedChunklist)
+	}
+}
+
+func TestManifestGeneratorBitrateTag(t *testing.T) {
+	for _, lhlsAdvancedChunks := range []int{0, 2} {
+		pathResults := "../results/BitrateTag"
+		clearResultsDir(pathResults)
+
+		chunklistFile := "chunklist.m3u8"
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, lhlsAdvancedChunks, nil, nil)
+		mg.SetBitrateTag(true)
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		// Size of the chunk file over its EXTINF
+		chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+		lines := strings.Split(chunklist, "\n")
+		bitrates := 0
+		for i := 0; i+2 < len(lines); i++ {
+			if !strings.HasPrefix(lines[i], "#EXT-X-BITRATE:") {
+				continue
+			}
+			bitrates++
+			durationS, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(lines[i+1], "#EXTINF:"), ","), 64)
+			fi, err := os.Stat(path.Join(pathResults, lines[i+2]))
+			if err != nil {
+				t.Fatalf("Error reading chunk %s. Err: %v", lines[i+2], err)
+			}
+			xpectedBitrate := strconv.FormatFloat(math.Round(float64(fi.Size())*8/1000/durationS), 'f', 0, 64)
+			if got := strings.TrimPrefix(lines[i], "#EXT-X-BITRATE:"); got != xpectedBitrate {
+				t.Errorf("EXT-X-BITRATE of %s is not correct (LHLS: %d), got: %s, want: %s.", lines[i+2], lhlsAdvancedChunks, got, xpectedBitrate)
+			}
+		}
+		// The 3 chunks with data (the LHLS advanced one after the last is still growing)
+		if bitrates != 3 {
+			t.Errorf("EXT-X-BITRATE number is not correct (LHLS: %d), got: %d, want: %d.", lhlsAdvancedChunks, bitrates, 3)
+		}
+	}
+}
+
+func TestManifestGeneratorChunkFilenameTemplate(t *testing.T) {
+	pathResults := "../results/ChunkFilenameTemplate"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetChunkFilenameTemplate("chan1_{seq:3}_{ptsms}_{dur}"); err != nil {
+		t.Fatal("Error setting the filename template. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// Chunks of 4s from the 1st IDR (PTS 1.4427s), the last one until its last frame
+	xpectedFileNames := []string{"chan1_000_1443_4000.ts", "chan1_001_5443_4000.ts", "chan1_002_9443_4000.ts"}
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	for _, fileName := range xpectedFileNames {
+		if !strings.Contains(chunklist, ",\n"+fileName+"\n") {
+			t.Errorf("Chunk %s is not in the chunklist, got: %s.", fileName, chunklist)
+		}
+		if _, err := os.Stat(path.Join(pathResults, fileName)); err != nil {
+			t.Errorf("Chunk file %s is not saved. Err: %v", fileName, err)
+		}
+	}
+	files, _ := filepath.Glob(path.Join(pathResults, ".*"))
+	if len(files) > 0 {
+		t.Errorf("Temporary files are left, got: %v.", files)
+	}
+
+	// Tokens that can not be resolved
+	mg = New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveEvent, 3, 2, nil, nil)
+	if err := mg.SetChunkFilenameTemplate("chan1_{seq}_{dur}"); err == nil {
+		t.Errorf("Filename template with the duration of the LHLS advanced chunks is valid")
+	}
+	if err := mg.SetChunkFilenameTemplate("chan1_{seq}_{unixms}"); err != nil {
+		t.Errorf("Filename template known when LHLS chunks are created is not valid. Err: %v", err)
+	}
+}
+
+func TestManifestGeneratorEpochNumbering(t *testing.T) {
+	pathResults := "../results/EpochNumbering"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+	before := uint64(time.Now().UnixNano() / int64(4*time.Second))
+	mg.SetEpochNumbering(true)
+	after := uint64(time.Now().UnixNano() / int64(4*time.Second))
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	matches := regexp.MustCompile(`#EXT-X-MEDIA-SEQUENCE:(\d+)\n`).FindStringSubmatch(chunklist)
+	if len(matches) < 2 {
+		t.Fatalf("Chunklist without media sequence, got: %s.", chunklist)
+	}
+	mseq, _ := strconv.ParseUint(matches[1], 10, 64)
+	if mseq < before || mseq > after {
+		t.Errorf("Media sequence is not from the wall clock, got: %d, want: %d - %d.", mseq, before, after)
+	}
+
+	// The filenames have the same numbering than the media sequence
+	for i := uint64(0); i < 3; i++ {
+		fileName := fmt.Sprintf("chunk_%05d.ts", mseq+i)
+		if !strings.Contains(chunklist, ",\n"+fileName+"\n") {
+			t.Errorf("Chunk %s is not in the chunklist, got: %s.", fileName, chunklist)
+		}
+		if _, err := os.Stat(path.Join(pathResults, fileName)); err != nil {
+			t.Errorf("Chunk file %s is not saved. Err: %v", fileName, err)
+		}
+	}
+}
+
+func TestManifestGeneratorChunkSharding(t *testing.T) {
+	pathResults := "../results/ChunkSharding"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetChunkSharding("../2006"); err == nil {
+		t.Errorf("Sharding out of the destination is valid")
+	}
+	if err := mg.SetChunkSharding("2006/01/02/15"); err != nil {
+		t.Fatal("Error setting the sharding. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// The chunks URIs are relative to the chunklist, in the hour directory (UTC) when they were created
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	uris := regexp.MustCompile(`(?m)^(\d{4}/\d{2}/\d{2}/\d{2})/chunk_(\d{5})\.ts$`).FindAllStringSubmatch(chunklist, -1)
+	if len(uris) != 3 {
+		t.Fatalf("Sharded chunks are not correct, got: %s.", chunklist)
+	}
+	for i, uri := range uris {
+		if uri[2] != fmt.Sprintf("%05d", i) {
+			t.Errorf("Chunk %d is not correct, got: %s.", i, uri[0])
+		}
+		if shard, err := time.Parse("2006/01/02/15", uri[1]); err != nil || time.Since(shard) > 2*time.Hour {
+			t.Errorf("Chunk %s directory is not the current hour", uri[0])
+		}
+		if _, err := os.Stat(path.Join(pathResults, uri[0])); err != nil {
+			t.Errorf("Chunk file %s is not saved. Err: %v", uri[0], err)
+		}
+	}
+	if _, err := os.Stat(path.Join(pathResults, "chunk_00000.ts")); err == nil {
+		t.Errorf("Chunk saved out of the hour directory")
+	}
+}
+
+func TestManifestGeneratorChunkRetention(t *testing.T) {
+	pathResults := "../results/ChunkRetention"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	mgVod := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 2.0, ChunkInitStart, true, -1, -1, hls.Vod, 2, 0, nil, nil)
+	if err := mgVod.SetChunkRetention(true, 1); err == nil {
+		t.Errorf("Chunk retention in VOD is valid")
+	}
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 2.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 2, 0, nil, nil)
+	if err := mg.SetChunkRetention(true, 1); err != nil {
+		t.Fatal("Error setting the chunk retention. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// The chunks of the window and the margin one before them are kept, the older ones are deleted
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	mseqMatch := regexp.MustCompile(`#EXT-X-MEDIA-SEQUENCE:(\d+)`).FindStringSubmatch(chunklist)
+	if mseqMatch == nil {
+		t.Fatalf("Chunklist without media sequence, got: %s.", chunklist)
+	}
+	mseq, _ := strconv.Atoi(mseqMatch[1])
+	if mseq < 2 {
+		t.Fatalf("Chunklist window did not slide, got: %s.", chunklist)
+	}
+	last := mseq + len(regexp.MustCompile(`(?m)^chunk_\d{5}\.ts$`).FindAllString(chunklist, -1))
+	for i := 0; i < last; i++ {
+		_, err := os.Stat(path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i)))
+		if xpected := i >= mseq-1; (err == nil) != xpected {
+			t.Errorf("Chunk %d file is not correct, got exists: %t, want: %t.", i, err == nil, xpected)
+		}
+	}
+}
+
+func TestManifestGeneratorResumeState(t *testing.T) {
+	pathResults := "../results/ResumeState"
+	clearResultsDir(pathResults)
+
+	chunklistFile := "chunklist.m3u8"
+	stateFile := path.Join(pathResults, "state.json")
+	newMg := func() ManifestGenerator {
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", chunklistFile, 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 5, 0, nil, nil)
+		if err := mg.SetStateFile(stateFile, true); err != nil {
+			t.Fatal("Error setting the state file. Err: ", err)
+		}
+		return mg
+	}
+
+	// Missing state file, it starts from scratch
+	mg := newMg()
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// Restarted: the chunks of the 1st run are kept, the numbering continues and the 1st new chunk is a discontinuity
+	mg = newMg()
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	chunklist := readChunklist(t, path.Join(pathResults, chunklistFile))
+	if !strings.Contains(chunklist, "#EXT-X-MEDIA-SEQUENCE:1\n") {
+		t.Errorf("Media sequence is not correct, got: %s.", chunklist)
+	}
+	xpected := "chunk_00001.ts\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4.00000000,\nchunk_00003.ts\n"
+	if !strings.Contains(chunklist, xpected) {
+		t.Errorf("Resumed chunks are not correct, got: %s, want: %s.", chunklist, xpected)
+	}
+	if strings.Contains(chunklist, "chunk_00000.ts") || !strings.Contains(chunklist, "chunk_00005.ts") {
+		t.Errorf("Window is not correct, got: %s.", chunklist)
+	}
+
+	// Corrupt state file, it starts from scratch
+	if err := os.WriteFile(stateFile, []byte("{\"nextChunkIndex\":"), 0644); err != nil {
+		t.Fatal("Error writing the state file. Err: ", err)
+	}
+	mg = newMg()
+	if mg.currentChunkIndex != 0 || mg.isNextChunkDisco {
+		t.Errorf("Corrupt state file is not ignored, got next chunk index: %d.", mg.currentChunkIndex)
+	}
+}
+
+func TestManifestGeneratorResumeStateEncryption(t *testing.T) {
+	pathResults := "../results/ResumeStateEncryption"
+	clearResultsDir(pathResults)
+
+	stateFile := path.Join(pathResults, "state.json")
+	newMg := func() (ManifestGenerator, error) {
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 10, 0, nil, nil)
+		// Random keys, every run has new ones
+		if err := mg.SetEncryption(encryption.MethodAES128, nil, "", "", encryption.IVMediaSequence, "", ""); err != nil {
+			t.Fatal("Error setting the encryption. Err: ", err)
+		}
+		if err := mg.SetKeyRotation(1, 0); err != nil {
+			t.Fatal("Error setting the key rotation. Err: ", err)
+		}
+		return mg, mg.SetStateFile(stateFile, true)
+	}
+
+	mg, err := newMg()
+	if err != nil {
+		t.Fatal("Error setting the state file. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+	keys := [][]byte{}
+	for i := 0; i < 3; i++ {
+		data, err := os.ReadFile(path.Join(pathResults, fmt.Sprintf("key%05d.key", i)))
+		if err != nil {
+			t.Fatal("Error reading key file. Err: ", err)
+		}
+		keys = append(keys, data)
+	}
+
+	// Restarted: the key files of the 1st run are not overwritten, the numbering continues
+	mg, err = newMg()
+	if err != nil {
+		t.Fatal("Error setting the state file. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	for i, xpected := range keys {
+		if data, _ := os.ReadFile(path.Join(pathResults, fmt.Sprintf("key%05d.key", i))); !bytes.Equal(data, xpected) {
+			t.Errorf("Key file %d is overwritten, got: %x, want: %x.", i, data, xpected)
+		}
+	}
+	chunklist := readChunklist(t, path.Join(pathResults, "chunklist.m3u8"))
+	xpected := "#EXT-X-KEY:METHOD=AES-128,URI=\"key00002.key\"\n#EXTINF:4.00000000,\nchunk_00002.ts\n#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=AES-128,URI=\"key00003.key\"\n#EXTINF:4.00000000,\nchunk_00003.ts\n"
+	if !strings.Contains(chunklist, xpected) {
+		t.Errorf("Resumed keys are not correct, got: %s, want: %s.", chunklist, xpected)
+	}
+}
+
+func TestManifestGeneratorChunkMetadata(t *testing.T) {
+	pathResults := "../results/ChunkMetadata"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetChunkMetadata(true, "index.json"); err != nil {
+		t.Fatal("Error setting the chunk metadata. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// A sidecar per chunk (IDRs every 2s), the index has all of them in VOD
+	for i := 0; i < 3; i++ {
+		chunkFileName := path.Join(pathResults, fmt.Sprintf("chunk_%05d.ts", i))
+		data, err := os.ReadFile(chunkmeta.FileName(chunkFileName))
+		if err != nil {
+			t.Fatal("Error reading the chunk metadata. Err: ", err)
+		}
+		meta := chunkmeta.Chunk{}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			t.Fatal("Error decoding the chunk metadata. Err: ", err)
+		}
+		info, _ := os.Stat(chunkFileName)
+		if meta.Version != chunkmeta.Version || meta.SequenceNumber != uint64(i) || meta.URI != path.Base(chunkFileName) || meta.DurationS != 4 || meta.Keyframes != 2 || meta.Discontinuity {
+			t.Errorf("Chunk %d metadata is not correct, got: %s.", i, data)
+		}
+		if info == nil || meta.Bytes != uint64(info.Size()) {
+			t.Errorf("Chunk %d metadata bytes are not correct, got: %d.", i, meta.Bytes)
+		}
+		if xpected := 1.4427 + float64(i)*4; math.Abs(meta.StartPTSS-xpected) > 0.1 || meta.WallClockStart.IsZero() {
+			t.Errorf("Chunk %d metadata start is not correct, got: %f, want: %f.", i, meta.StartPTSS, xpected)
+		}
+	}
+
+	data, err := os.ReadFile(path.Join(pathResults, "index.json"))
+	if err != nil {
+		t.Fatal("Error reading the index. Err: ", err)
+	}
+	index := chunkmeta.Index{}
+	if err := json.Unmarshal(data, &index); err != nil || len(index.Chunks) != 3 || index.Chunks[2].SequenceNumber != 2 {
+		t.Errorf("Index is not correct, got: %s.", data)
+	}
+}
+
+func TestManifestGeneratorCallbacks(t *testing.T) {
+	pathResults := "../results/Callbacks"
+	clearResultsDir(pathResults)
+
+	chunks := map[string][]byte{}
+	closed := []mediachunk.ChunkInfo{}
+	manifests := map[string]string{}
+	mg := New(nil, mediachunk.ChunkOutputModeCallback, hls.HlsOutputModeCallback, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	mg.SetCallbacks(Callbacks{
+		OnChunkData: func(info mediachunk.ChunkInfo, r io.Reader) {
+			data, _ := io.ReadAll(r)
+			chunks[info.FileName] = append(chunks[info.FileName], data...)
+		},
+		OnChunkClosed: func(info mediachunk.ChunkInfo) {
+			closed = append(closed, info)
+		},
+		OnManifestUpdated: func(name string, body []byte) {
+			manifests[name] = string(body)
+		},
+	})
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	// The chunks data and the chunklist are only passed to the callbacks
+	if len(closed) != 3 {
+		t.Fatalf("Closed chunks are not correct, got: %d, want: 3.", len(closed))
+	}
+	for i, info := range closed {
+		data := chunks[info.FileName]
+		if info.Index != uint64(i) || info.DurationS != 4 || len(data) != info.Bytes || len(data) <= 0 || data[0] != 0x47 {
+			t.Errorf("Chunk %d is not correct, got: %+v (%d bytes).", i, info, len(data))
+		}
+	}
+	chunklist, found := manifests[path.Join(pathResults, "chunklist.m3u8")]
+	if !found || !strings.Contains(chunklist, "chunk_00002.ts\n#EXT-X-ENDLIST") {
+		t.Errorf("Chunklist is not correct, got: %s.", chunklist)
+	}
+	if files, _ := os.ReadDir(pathResults); len(files) > 0 {
+		t.Errorf("Files saved with the callback outputs, got: %d.", len(files))
+	}
+}
+
+func TestManifestGeneratorMediaFsync(t *testing.T) {
+	pathResults := "../results/mediaFsync"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetMediaFsync(-1); err == nil {
+		t.Errorf("Negative media fsync accepted")
+	}
+	if err := mg.SetMediaFsync(2); err != nil {
+		t.Fatal("Error setting the media fsync. Err: ", err)
+	}
+	for index, xpected := range []bool{true, false, true} {
+		if got := mg.isMediaFsync(uint64(index)); got != xpected {
+			t.Errorf("Chunk %d fsync is not correct, got: %t, want: %t.", index, got, xpected)
+		}
+	}
+	mg.SetManifestFsync(true)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	for _, fileName := range []string{"chunk_00000.ts", "chunk_00001.ts", "chunk_00002.ts", "chunklist.m3u8"} {
+		if info, err := os.Stat(path.Join(pathResults, fileName)); err != nil || info.Size() == 0 {
+			t.Errorf("File %s is not saved", fileName)
+		}
+	}
+
+	mgHTTP := New(nil, mediachunk.ChunkOutputModeHTTPRegular, hls.HlsOutputModeHTTP, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mgHTTP.SetMediaFsync(1); err == nil {
+		t.Errorf("Media fsync accepted with the HTTP output")
+	}
+}
+
+func TestManifestGeneratorChunkVerification(t *testing.T) {
+	pathResults := "../results/chunkVerification"
+
+	for _, toleranceS := range []float64{0.5, 0} {
+		clearResultsDir(pathResults)
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		if err := mg.SetChunkVerification(true, toleranceS, true); err != nil {
+			t.Fatal("Error setting the chunk verification. Err: ", err)
+		}
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		// The PTS span never matches the EXTINF exactly (the last frame duration)
+		xpected := 0
+		if toleranceS == 0 {
+			xpected = 3
+		}
+		if got := mg.GetStats().VerifyFailures; got != uint64(xpected) {
+			t.Errorf("Verification failures with tolerance %f are not correct, got: %d, want: %d.", toleranceS, got, xpected)
+		}
+		chunklist, _ := os.ReadFile(path.Join(pathResults, "chunklist.m3u8"))
+		if got := strings.Count(string(chunklist), "#EXT-X-GAP"); got != xpected {
+			t.Errorf("Gaps with tolerance %f are not correct, got: %d, want: %d.", toleranceS, got, xpected)
+		}
+	}
+
+	mgHTTP := New(nil, mediachunk.ChunkOutputModeHTTPRegular, hls.HlsOutputModeHTTP, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mgHTTP.SetChunkVerification(true, 0.5, false); err == nil {
+		t.Errorf("Chunk verification accepted with the HTTP output")
+	}
+}
+
+func TestManifestGeneratorSecondaryDestinations(t *testing.T) {
+	pathResults := "../results/secondaryDestinations"
+	clearResultsDir(pathResults)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetSecondaryDestinations([]mediachunk.OutputTypes{mediachunk.ChunkOutputModeFile}, nil); err == nil {
+		t.Errorf("Repeated media destination accepted")
+	}
+	if err := mg.SetSecondaryDestinations([]mediachunk.OutputTypes{mediachunk.ChunkOutputModeFileByteRange}, nil); err == nil {
+		t.Errorf("Byte range secondary destination accepted")
+	}
+
+	// The secondary destination gets the same media files and manifests
+	chunks := map[string][]byte{}
+	manifests := map[string]string{}
+	mg.SetCallbacks(Callbacks{
+		OnChunkData: func(info mediachunk.ChunkInfo, r io.Reader) {
+			data, _ := io.ReadAll(r)
+			chunks[info.FileName] = append(chunks[info.FileName], data...)
+		},
+		OnManifestUpdated: func(name string, body []byte) {
+			manifests[name] = string(body)
+		},
+	})
+	if err := mg.SetSecondaryDestinations([]mediachunk.OutputTypes{mediachunk.ChunkOutputModeCallback}, []hls.OutputTypes{hls.HlsOutputModeCallback}); err != nil {
+		t.Fatal("Error setting the secondary destinations. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	for i := 0; i < 3; i++ {
+		fileName := path.Join(pathResults, "chunk_0000"+strconv.Itoa(i)+".ts")
+		data, err := os.ReadFile(fileName)
+		if err != nil || len(data) <= 0 || !bytes.Equal(chunks[fileName], data) {
+			t.Errorf("Chunk %d of the secondary destination is not correct, got: %d bytes, want: %d.", i, len(chunks[fileName]), len(data))
+		}
+	}
+	chunklistFileName := path.Join(pathResults, "chunklist.m3u8")
+	if data, _ := os.ReadFile(chunklistFileName); len(data) <= 0 || manifests[chunklistFileName] != string(data) {
+		t.Errorf("Chunklist of the secondary destination is not correct, got: %s.", manifests[chunklistFileName])
+	}
+	if stats := mg.GetStats(); stats.SecondaryFailures != 0 {
+		t.Errorf("Secondary failures are not correct, got: %d, want: 0.", stats.SecondaryFailures)
+	}
+
+	// A failing secondary destination (HTTP errors) does not affect the primary one
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	httpUploader := httpuploader.New(nil, false, u.Scheme, u.Host, 2, 1)
+
+	closed := 0
+	chunklist := ""
+	mg = New(nil, mediachunk.ChunkOutputModeCallback, hls.HlsOutputModeCallback, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, &httpUploader, nil)
+	mg.SetCallbacks(Callbacks{
+		OnChunkClosed: func(info mediachunk.ChunkInfo) {
+			closed++
+		},
+		OnManifestUpdated: func(name string, body []byte) {
+			chunklist = string(body)
+		},
+	})
+	mg.SetGapOnUploadFailure(true)
+	if err := mg.SetSecondaryDestinations([]mediachunk.OutputTypes{mediachunk.ChunkOutputModeHTTPRegular}, []hls.OutputTypes{hls.HlsOutputModeHTTP}); err != nil {
+		t.Fatal("Error setting the secondary destinations. Err: ", err)
+	}
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	stats := mg.GetStats()
+	if closed != 3 || !strings.Contains(chunklist, "chunk_00002.ts\n#EXT-X-ENDLIST") || strings.Contains(chunklist, "#EXT-X-GAP") {
+		t.Errorf("Primary destination is not correct, got: %d chunks, chunklist: %s.", closed, chunklist)
+	}
+	if stats.SecondaryFailures < 6 || stats.Gaps != 0 {
+		t.Errorf("Secondary failures are not correct, got: %d (%d gaps), want: >= 6 (0 gaps).", stats.SecondaryFailures, stats.Gaps)
+	}
+}
+
+func TestManifestGeneratorStdout(t *testing.T) {
+	pathResults := "../results/stdout"
+	defer func(stdout io.Writer) { mediachunk.Stdout = stdout }(mediachunk.Stdout)
+
+	// The stream is the init data and the chunks, the same bytes of the files
+	for _, chunkInitType := range []ChunkInitTypes{ChunkInitStart, ChunkInit} {
+		clearResultsDir(pathResults)
+		stdout := bytes.Buffer{}
+		mediachunk.Stdout = &stdout
+
+		mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, chunkInitType, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+		if err := mg.SetSecondaryDestinations([]mediachunk.OutputTypes{mediachunk.ChunkOutputModeStdout}, nil); err != nil {
+			t.Fatal("Error setting the stdout destination. Err: ", err)
+		}
+		addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+		mg.Close()
+
+		xpected := []byte{}
+		fileNames := []string{"chunk_00000.ts", "chunk_00001.ts", "chunk_00002.ts"}
+		if chunkInitType == ChunkInit {
+			fileNames = append([]string{ChunkInitFileName + "00000.ts"}, fileNames...)
+		}
+		for _, fileName := range fileNames {
+			data, err := os.ReadFile(path.Join(pathResults, fileName))
+			if err != nil {
+				t.Fatal("Error reading ", fileName, ". Err: ", err)
+			}
+			xpected = append(xpected, data...)
+		}
+		if !bytes.Equal(stdout.Bytes(), xpected) {
+			t.Errorf("Stdout stream of init type %d is not correct, got: %d bytes, want: %d.", chunkInitType, stdout.Len(), len(xpected))
+		}
+	}
+}
+
+func TestManifestGeneratorInProgressSignaling(t *testing.T) {
+	pathResults := "../results/inProgressSignaling"
+	clearResultsDir(pathResults)
+	liveStateFileName := path.Join(pathResults, chunkmeta.LiveStateFileName)
+
+	mg := New(nil, mediachunk.ChunkOutputModeFile, hls.HlsOutputModeFile, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.Vod, 3, 0, nil, nil)
+	if err := mg.SetInProgressSignaling(false, InProgressExtensionDefault, liveStateFileName); err != nil {
+		t.Fatal("Error setting the in progress signaling. Err: ", err)
+	}
+
+	readLiveState := func() chunkmeta.LiveState {
+		state := chunkmeta.LiveState{}
+		data, err := os.ReadFile(liveStateFileName)
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil {
+			t.Fatal("Error reading the live state. Err: ", err)
+		}
+		return state
+	}
+
+	// The 2nd chunk is being written, only with its .part file
+	data, _ := os.ReadFile("../fixture/testSmall.ts")
+	mg.AddData(data[:150*1024])
+	state := readLiveState()
+	if state.Chunk == nil || state.Chunk.SequenceNumber != 1 || state.Chunk.URI != "chunk_00001.ts" {
+		t.Fatalf("Live state is not correct, got: %+v.", state.Chunk)
+	}
+	info, err := os.Stat(path.Join(pathResults, "chunk_00001.ts"+InProgressExtensionDefault))
+	if err != nil || info.Size() != int64(state.Chunk.Bytes) || state.Chunk.FileName != path.Join(pathResults, "chunk_00001.ts"+InProgressExtensionDefault) {
+		t.Errorf("Chunk being written is not correct, got: %v (%+v).", err, state.Chunk)
+	}
+	for _, fileName := range []string{"chunk_00001.ts", GhostPrefixDefault + "chunk_00001.ts", "chunk_00000.ts" + InProgressExtensionDefault} {
+		if _, err := os.Stat(path.Join(pathResults, fileName)); err == nil {
+			t.Errorf("File %s exists while the chunk is written.", fileName)
+		}
+	}
+
+	mg.AddData(data[150*1024:])
+	mg.Close()
+
+	if state := readLiveState(); state.Chunk != nil {
+		t.Errorf("Live state after the last chunk is not correct, got: %+v.", state.Chunk)
+	}
+	chunklist, _ := os.ReadFile(path.Join(pathResults, "chunklist.m3u8"))
+	for i := 0; i < 3; i++ {
+		fileName := "chunk_0000" + strconv.Itoa(i) + ".ts"
+		if _, err := os.Stat(path.Join(pathResults, fileName)); err != nil || !strings.Contains(string(chunklist), "\n"+fileName+"\n") {
+			t.Errorf("Chunk %s is not correct. Err: %v", fileName, err)
+		}
+	}
+	if files, _ := filepath.Glob(path.Join(pathResults, "*"+InProgressExtensionDefault)); len(files) > 0 {
+		t.Errorf("In progress files after the chunks are completed, got: %v.", files)
+	}
+}
+
+func TestManifestGeneratorHTTPServer(t *testing.T) {
+	pathResults := "../results/HTTPServer"
+
+	server, err := httpserver.New(nil, "127.0.0.1", 0, "", "", 0)
+	if err != nil {
+		t.Fatal("Error creating HTTP server. Err: ", err)
+	}
+	defer server.Close()
+
+	mg := New(nil, mediachunk.ChunkOutputModeHTTPServer, hls.HlsOutputModeHTTPServer, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 3, nil, nil)
+	mg.SetHTTPServer(&server)
+	addFileData(&mg, "../fixture/testSmall.ts", 4*1024)
+	mg.Close()
+
+	get := func(fileName string) []byte {
+		resp, err := http.Get("http://" + server.Listener.Addr().String() + "/" + path.Join(pathResults, fileName))
+		if err != nil {
+			t.Fatal("Error getting ", fileName, ". Err: ", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Status code of %s is not correct, got: %d, want: %d.", fileName, resp.StatusCode, http.StatusOK)
+		}
+		return body
+	}
+
+	// Same chunks and chunklist than the LHLS file output
+	chunklist := string(get("chunklist.m3u8"))
+	if !strings.Contains(chunklist, "#EXTINF:4.00000000,\nchunk_00000.ts\n") {
+		t.Errorf("Served chunklist is not correct, got: %s.", chunklist)
+	}
+	if got, xpected := len(get("chunk_00000.ts")), 103400; got != xpected {
+		t.Errorf("Served chunk size is not correct, got: %d, want: %d.", got, xpected)
+	}
+}
+
+func TestManifestGeneratorHTTPServerBlockingReload(t *testing.T) {
+	pathResults := "../results/HTTPServerBlockingReload"
+
+	server, err := httpserver.New(nil, "127.0.0.1", 0, "", "", 0)
+	if err != nil {
+		t.Fatal("Error creating HTTP server. Err: ", err)
+	}
+	defer server.Close()
+	server.SetBlockingReload(time.Second)
+
+	mg := New(nil, mediachunk.ChunkOutputModeHTTPServer, hls.HlsOutputModeHTTPServer, pathResults, "chunk_", "chunklist.m3u8", 5, 4.0, ChunkInitStart, true, -1, -1, hls.LiveWindow, 3, 0, nil, nil)
+	mg.SetHTTPServer(&server)
+	mg.SetPartDuration(0.5)
+
+	data, err := ioutil.ReadFile("../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading test file. Err: ", err)
+	}
+	// Paced ingest, the player requests are ahead of it
+	ingestDone := make(chan bool)
+	go func() {
+		defer close(ingestDone)
+		for len(data) > 0 {
+			n := 4 * 1024
+			if n > len(data) {
+				n = len(data)
+			}
+			mg.AddData(data[:n])
+			data = data[n:]
+			time.Sleep(time.Millisecond)
+		}
+		mg.Close()
+	}()
+
+	// Simulated player, it always asks for the next part (or the next chunk when the current one is completed)
+	chunklistURL := "http://" + server.Listener.Addr().String() + "/" + path.Join(pathResults, "chunklist.m3u8")
+	for i := 0; ; i++ {
+		resp, err := http.Get(chunklistURL)
+		if err != nil {
+			t.Fatal("Error getting chunklist. Err: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if i >= 1000 {
+			t.Fatalf("Chunklist is not published, got: %d, want: %d.", resp.StatusCode, http.StatusOK)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	msn, part, partsReceived := 0, 0, 0
+	for i := 0; i < 200; i++ {
+		resp, err := http.Get(fmt.Sprintf("%s?_HLS_msn=%d&_HLS_part=%d", chunklistURL, msn, part))
+		if err != nil {
+			t.Fatal("Error getting chunklist. Err: ", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		chunklist := string(body)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			// The live chunklist does not end, the part after the last one timeouts
+			select {
+			case <-ingestDone:
+			default:
+				t.Fatalf("Blocking reload %d.%d timeout before the end of the ingest.", msn, part)
+			}
+			break
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Status code of the blocking reload %d.%d is not correct, got: %d, want: %d.", msn, part, resp.StatusCode, http.StatusOK)
+		}
+		if strings.Contains(chunklist, "#EXT-X-ENDLIST") {
+			break
+		}
+
+		isPart := strings.Contains(chunklist, fmt.Sprintf("URI=\"chunk_%05d.%d.ts\"", msn, part))
+		isChunk := strings.Contains(chunklist, fmt.Sprintf("\nchunk_%05d.ts\n", msn))
+		if !isPart && !isChunk {
+			t.Fatalf("Blocking reload response does not have the part %d.%d, got: %s.", msn, part, chunklist)
+		}
+		if isChunk {
+			msn, part = msn+1, 0
+		} else {
+			partsReceived++
+			part++
+		}
+	}
+
+	// 4s chunks of 0.5s parts, the player gets every part of the first ones
+	if msn < 2 || partsReceived < 16 {
+		t.Errorf("Simulated player did not follow the live edge, got: chunk %d (%d parts), want: chunk >= 2 (>= 16 parts).", msn, partsReceived)
+	}
+}
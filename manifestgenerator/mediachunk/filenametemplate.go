@@ -0,0 +1,192 @@
+package mediachunk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filename template tokens
+const (
+	// TokenSeq Chunk index, zero padded to the file number length ({seq}) or to N digits ({seq:N})
+	TokenSeq = "seq"
+
+	// TokenUnixMs Wall clock time when the chunk is created, in ms since the epoch
+	TokenUnixMs = "unixms"
+
+	// TokenPDT Program date time of the chunk (UTC) in the Go layout ({pdt:2006-01-02T15-04-05}), the time the chunk is created if it is not known
+	TokenPDT = "pdt"
+
+	// TokenPTSMs PTS of the 1st sample of the chunk in ms
+	TokenPTSMs = "ptsms"
+
+	// TokenDur Duration of the chunk in ms
+	TokenDur = "dur"
+)
+
+// FilenameValues Values of the template tokens of a chunk, the ones only known when it is closed are set then
+type FilenameValues struct {
+	Index           uint64
+	CreatedAt       time.Time
+	ProgramDateTime time.Time
+	StartPTSS       float64
+	DurationS       float64
+}
+
+// templatePart Literal text or token (with its argument) of the template
+type templatePart struct {
+	text  string
+	token string
+	arg   string
+}
+
+// FilenameTemplate Chunk filename template (without the extension), Ex: chan1_{pdt:20060102T150405}_{seq}
+type FilenameTemplate struct {
+	template string
+	parts    []templatePart
+}
+
+// ParseFilenameTemplate Parses and validates the template, it has to have at least a token and it can not have unknown tokens, paths or empty arguments
+func ParseFilenameTemplate(template string) (*FilenameTemplate, error) {
+	if template == "" {
+		return nil, errors.New("empty filename template")
+	}
+	if strings.ContainsAny(template, "/\\") {
+		return nil, fmt.Errorf("filename template %s can not have paths", template)
+	}
+
+	t := FilenameTemplate{template, []templatePart{}}
+	hasToken := false
+	for rest := template; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			if strings.Contains(rest, "}") {
+				return nil, fmt.Errorf("filename template %s has an unbalanced }", template)
+			}
+			t.parts = append(t.parts, templatePart{text: rest})
+			break
+		}
+		if strings.Contains(rest[:start], "}") {
+			return nil, fmt.Errorf("filename template %s has an unbalanced }", template)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{text: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("filename template %s has an unbalanced {", template)
+		}
+
+		token, arg, hasArg := strings.Cut(rest[start+1:start+end], ":")
+		if err := validateToken(token, arg, hasArg); err != nil {
+			return nil, fmt.Errorf("filename template %s: %w", template, err)
+		}
+		t.parts = append(t.parts, templatePart{token: token, arg: arg})
+		hasToken = true
+		rest = rest[start+end+1:]
+	}
+	if !hasToken {
+		return nil, fmt.Errorf("filename template %s without tokens, all the chunks would have the same name", template)
+	}
+
+	return &t, nil
+}
+
+// validateToken Returns an error if the token is unknown or its argument is not valid
+func validateToken(token string, arg string, hasArg bool) error {
+	switch token {
+	case TokenSeq:
+		if hasArg {
+			if width, err := strconv.Atoi(arg); err != nil || width <= 0 {
+				return fmt.Errorf("invalid {%s} width %s", token, arg)
+			}
+		}
+	case TokenPDT:
+		if arg == "" {
+			return fmt.Errorf("{%s} without layout (Ex: {%s:2006-01-02T15-04-05})", token, token)
+		}
+		if strings.ContainsAny(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Format(arg), "/\\") {
+			return fmt.Errorf("{%s} layout %s can not have paths", token, arg)
+		}
+	case TokenUnixMs, TokenPTSMs, TokenDur:
+		if hasArg {
+			return fmt.Errorf("{%s} has no arguments", token)
+		}
+	default:
+		return fmt.Errorf("unknown token {%s}", token)
+	}
+
+	return nil
+}
+
+// String Returns the template
+func (t *FilenameTemplate) String() string {
+	return t.template
+}
+
+// HasToken Returns true if the template has the token
+func (t *FilenameTemplate) HasToken(token string) bool {
+	for _, part := range t.parts {
+		if part.token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// IsResolvedAtClose Returns true if the template has tokens that are only known when the chunk is closed (PDT, PTS and duration), the chunk needs a temporary name until then
+func (t *FilenameTemplate) IsResolvedAtClose() bool {
+	return t.HasToken(TokenPDT) || t.HasToken(TokenPTSMs) || t.HasToken(TokenDur)
+}
+
+// DashTemplate Returns the template as DASH SegmentTemplate@media ($Number$), it only has {seq} tokens
+func (t *FilenameTemplate) DashTemplate(fileNumberLength int) (string, error) {
+	ret := ""
+	for _, part := range t.parts {
+		if part.token == "" {
+			ret = ret + part.text
+		} else if part.token == TokenSeq {
+			ret = ret + "$Number%0" + strconv.Itoa(seqWidth(part.arg, fileNumberLength)) + "d$"
+		} else {
+			return "", fmt.Errorf("filename template %s with {%s} can not be a DASH segment template (only {%s})", t.template, part.token, TokenSeq)
+		}
+	}
+	return ret, nil
+}
+
+// Resolve Returns the filename (without the extension) of the values
+func (t *FilenameTemplate) Resolve(values FilenameValues, fileNumberLength int) string {
+	ret := ""
+	for _, part := range t.parts {
+		switch part.token {
+		case "":
+			ret = ret + part.text
+		case TokenSeq:
+			ret = ret + padNumberWithZero(values.Index, seqWidth(part.arg, fileNumberLength))
+		case TokenUnixMs:
+			ret = ret + strconv.FormatInt(values.CreatedAt.UnixNano()/int64(time.Millisecond), 10)
+		case TokenPDT:
+			pdt := values.ProgramDateTime
+			if pdt.IsZero() {
+				pdt = values.CreatedAt
+			}
+			ret = ret + pdt.UTC().Format(part.arg)
+		case TokenPTSMs:
+			ret = ret + strconv.FormatInt(int64(math.Round(math.Max(0, values.StartPTSS)*1000)), 10)
+		case TokenDur:
+			ret = ret + strconv.FormatInt(int64(math.Round(math.Max(0, values.DurationS)*1000)), 10)
+		}
+	}
+	return ret
+}
+
+// seqWidth Returns the {seq} width of the argument, the file number length if it is not set
+func seqWidth(arg string, fileNumberLength int) int {
+	if width, err := strconv.Atoi(arg); err == nil && width > 0 {
+		return width
+	}
+	return fileNumberLength
+}
@@ -0,0 +1,90 @@
+package mediachunk
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseFilenameTemplate(t *testing.T) {
+	valid := []string{"chan1_{seq}", "{seq:8}", "chan1_{pdt:20060102T150405}_{seq}", "{pdt:2006-01-02T15:04:05}", "{unixms}_{ptsms}_{dur}"}
+	for _, template := range valid {
+		if _, err := ParseFilenameTemplate(template); err != nil {
+			t.Errorf("Template %s is not valid. Err: %v", template, err)
+		}
+	}
+
+	invalid := []string{"", "chan1", "chan1_{seq", "chan1_seq}", "{foo}", "{seq:0}", "{seq:x}", "{pdt}", "{pdt:2006/01/02}", "{dur:3}", "dir/{seq}", "{seq}}"}
+	for _, template := range invalid {
+		if _, err := ParseFilenameTemplate(template); err == nil {
+			t.Errorf("Template %s is valid", template)
+		}
+	}
+}
+
+func TestResolveFilenameTemplate(t *testing.T) {
+	createdAt := time.Date(2024, 5, 7, 10, 15, 29, 500000000, time.UTC)
+	values := FilenameValues{Index: 42, CreatedAt: createdAt, ProgramDateTime: createdAt.Add(500 * time.Millisecond), StartPTSS: 1.4427, DurationS: 3.9999}
+
+	tests := []struct {
+		template string
+		xpected  string
+	}{
+		{"chan1_{pdt:20060102T150405}_{seq}", "chan1_20240507T101530_00042"},
+		{"{seq:3}-{seq:7}", "042-0000042"},
+		{"{unixms}", "1715076929500"},
+		{"{ptsms}_{dur}", "1443_4000"},
+	}
+	for _, test := range tests {
+		template, _ := ParseFilenameTemplate(test.template)
+		if got := template.Resolve(values, 5); got != test.xpected {
+			t.Errorf("Template %s resolved is not correct, got: %s, want: %s.", test.template, got, test.xpected)
+		}
+	}
+
+	// Without PDT the creation time
+	template, _ := ParseFilenameTemplate("{pdt:150405.000}")
+	if got := template.Resolve(FilenameValues{CreatedAt: createdAt}, 5); got != "101529.500" {
+		t.Errorf("Template without PDT resolved is not correct, got: %s, want: %s.", got, "101529.500")
+	}
+
+	if dash, err := template.DashTemplate(5); err == nil {
+		t.Errorf("Template with PDT is a DASH template, got: %s.", dash)
+	}
+	template, _ = ParseFilenameTemplate("chan1_{seq:8}")
+	if dash, _ := template.DashTemplate(5); dash != "chan1_$Number%08d$" {
+		t.Errorf("DASH template is not correct, got: %s, want: %s.", dash, "chan1_$Number%08d$")
+	}
+}
+
+func TestFilenameTemplateResolvedAtClose(t *testing.T) {
+	pathResults := "../../results/ChunkFilenameTemplate"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	template, _ := ParseFilenameTemplate("chan1_{seq}_{dur}")
+	c := New(3, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, GhostPrefix: ".growing_", FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "chunk_", FilenameTemplate: template})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	c.AddData(make([]byte, 188))
+	if fileName := c.GetFilename(); fileName != path.Join(pathResults, ".chunk_00003.ts"+TemporaryFileExtension) {
+		t.Errorf("Temporary filename is not correct, got: %s.", fileName)
+	}
+
+	c.ResolveFilename(FilenameValues{DurationS: 4})
+	c.Close(4)
+	xpectedFileName := path.Join(pathResults, "chan1_00003_4000.ts")
+	if fileName := c.GetFilename(); fileName != xpectedFileName {
+		t.Errorf("Resolved filename is not correct, got: %s, want: %s.", fileName, xpectedFileName)
+	}
+	if got := fileSize(t, xpectedFileName); got != 188 {
+		t.Errorf("Renamed chunk size is not correct, got: %d, want: 188.", got)
+	}
+	if files, _ := os.ReadDir(pathResults); len(files) != 1 {
+		t.Errorf("Files left in the chunks directory are not correct, got: %d, want: 1.", len(files))
+	}
+}
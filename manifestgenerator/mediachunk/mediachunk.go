@@ -2,8 +2,14 @@ package mediachunk
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"hash"
+	"io"
 	"math/rand"
 	"os"
 	"path"
@@ -12,12 +18,24 @@ import (
 	"strings"
 	"time"
 
+	"go-ts-segmenter/manifestgenerator/atomicfile"
+	"go-ts-segmenter/manifestgenerator/encryption"
+	"go-ts-segmenter/uploaders/httpserver"
 	"go-ts-segmenter/uploaders/httpuploader"
 	"go-ts-segmenter/uploaders/s3uploader"
 
 	"github.com/sirupsen/logrus"
 )
 
+// TemporaryFileExtension Extension of the chunk files until their name is resolved (filename template resolved at close)
+const TemporaryFileExtension = ".tmp"
+
+// ChecksumSidecarExtension Extension added to the chunk filename of its SHA-256 sidecar file (sha256sum format)
+const ChecksumSidecarExtension = ".sha256"
+
+// ChecksumHeader Header of the HTTP uploads (S3 metadata) with the SHA-256 of the chunk in hex
+const ChecksumHeader = "Joc-Hls-Chunk-Sha256"
+
 // OutputTypes indicates the manifest type
 type OutputTypes int
 
@@ -36,8 +54,41 @@ const (
 
 	// ChunkOutputModeS3 chunks to S3
 	ChunkOutputModeS3
+
+	// ChunkOutputModeFileByteRange Appends the chunks to a single file (byte ranges)
+	ChunkOutputModeFileByteRange
+
+	// ChunkOutputModeHTTPServer Serves the chunks from the built-in HTTP server
+	ChunkOutputModeHTTPServer
+
+	// ChunkOutputModeCallback Passes the chunks data to the callbacks (Options.Callbacks)
+	ChunkOutputModeCallback
+
+	// ChunkOutputModeStdout Writes the chunks data one after the other to Stdout (a TS stream to pipe to other process)
+	ChunkOutputModeStdout
 )
 
+// Stdout Destination of ChunkOutputModeStdout, nothing else has to be written to it (Ex: logs)
+var Stdout io.Writer = os.Stdout
+
+// ChunkInfo Chunk passed to the callbacks
+type ChunkInfo struct {
+	FileName string
+	Index    uint64
+
+	// DurationS Duration of the chunk, < 0 until it is closed
+	DurationS float64
+
+	// Bytes Bytes added to the chunk (before the encryption)
+	Bytes int
+}
+
+// Callbacks Receivers of the chunks in ChunkOutputModeCallback, called from the goroutine that adds the data (the ones nil are not called). OnChunkData gets the data as it is written (r is only valid during the call), OnChunkClosed when the chunk is completed
+type Callbacks struct {
+	OnChunkData   func(info ChunkInfo, r io.Reader)
+	OnChunkClosed func(info ChunkInfo)
+}
+
 // Options Chunking options
 type Options struct {
 	Log                *logrus.Logger
@@ -51,6 +102,57 @@ type Options struct {
 	ChunkBaseFilename  string
 	HTTPUploader       *httpuploader.HTTPUploader
 	S3Uploader         *s3uploader.S3Uploader
+	HTTPServer         *httpserver.HTTPServer
+
+	// FileIndex Index of the file where the chunk is appended (only ChunkOutputModeFileByteRange), used in the filename instead of the chunk index
+	FileIndex uint64
+
+	// FlushBytes and FlushInterval The data is accumulated and written (the files also synced) when any of them is reached, both <= 0 every data is written when added
+	FlushBytes    int
+	FlushInterval time.Duration
+
+	// EncryptionKey and EncryptionIV AES-128 key and IV of the chunk data (nil not encrypted)
+	EncryptionKey []byte
+	EncryptionIV  []byte
+
+	// OnUploadFailed Called (if not nil) when the upload of the chunk to S3 / HTTP failed after its retries, from other goroutine in chunked transfer
+	OnUploadFailed func(fileName string, err error)
+
+	// FilenameTemplate Filename of the chunk (before the extension) instead of ChunkBaseFilename and the index (nil not used). If it is resolved at close the chunk has a temporary name until ResolveFilename, it is not valid with the outputs that need the name when the chunk is created
+	FilenameTemplate *FilenameTemplate
+
+	// Callbacks Receivers of the data in ChunkOutputModeCallback
+	Callbacks *Callbacks
+
+	// Filename Filename of the chunk (with its path) instead of ChunkBaseFilename and the index (Ex: sidecar files), it has no flag file. Empty not used
+	Filename string
+
+	// Checksum Computes the SHA-256 of the data written (after the encryption), it is sent in the ChecksumHeader of the HTTP / S3 uploads (S3 also gets the Content-MD5 to verify it). With ChecksumSidecar the file output also saves it in the sidecar file
+	Checksum        bool
+	ChecksumSidecar bool
+
+	// NoFlagFile (only ChunkOutputModeFile) Writes the chunk to a hidden temporary file renamed to its filename when it is closed instead of having a flag file while it is written, so the readers only see it completed. The LHLS chunks are read while they grow, they keep the flag file
+	NoFlagFile bool
+
+	// Fsync (only ChunkOutputModeFile and ChunkOutputModeFileByteRange) Fsyncs the file when the chunk is closed, and its directory after it has its final name, so a chunk in the chunklist survives a crash. The latency is logged (debug)
+	Fsync bool
+
+	// WriteQueueBytes (only ChunkOutputModeFile and ChunkOutputModeFileByteRange) If > 0 the data is written to the file by other goroutine from a queue of this size, so adding data only blocks on the disk when the queue is full. Close waits until all of it is written. WriteQueueStats (can be nil) gets the queue counters
+	WriteQueueBytes int
+	WriteQueueStats *WriteQueueStats
+
+	// SecondaryOutputTypes Other outputs where the chunk is also written (same name and data), OutputType is the primary one. Their errors (also the failed uploads) are reported to OnSecondaryFailed (logged if nil) and they are not returned nor reported to OnUploadFailed
+	SecondaryOutputTypes []OutputTypes
+	OnSecondaryFailed    func(outputType OutputTypes, fileName string, err error)
+
+	// InProgressExtension (only ChunkOutputModeFile) Extension of the file while the chunk is written (Ex: .part, also the LHLS chunks), it is renamed to its filename when it is closed. Empty not used
+	InProgressExtension string
+
+	// OnWritten Called (if not nil) after every data written to the output (every flush), the Bytes of the info are the ones written so far (after the encryption). Not called by the secondary outputs
+	OnWritten func(info ChunkInfo)
+
+	// UploadType Kind of the chunk in the HTTP upload URL ({type} of the URL template), empty httpuploader.UploadTypeChunk
+	UploadType httpuploader.UploadTypes
 }
 
 // Chunk Chunk class
@@ -76,20 +178,125 @@ type Chunk struct {
 
 	// Epoch time when we received first byte for this chunk
 	createdAt int64
+
+	// Position of the chunk in the file (only ChunkOutputModeFileByteRange)
+	byteRangeOffset int64
+
+	// Used by the built-in HTTP server
+	serverObject *httpserver.Object
+
+	// Data pending to be flushed (only with FlushBytes or FlushInterval)
+	pendingBuf  []byte
+	lastFlushAt time.Time
+	flushes     int
+
+	// Encrypts the data written (nil not encrypted)
+	encrypter *encryption.CBCEncrypter
+
+	// Filename of the template resolved at close, the file is renamed to it when the chunk is closed
+	resolvedFilename string
+
+	// Checksums of the data written (nil disabled), MD5 only for S3
+	checksum    hash.Hash
+	checksumMD5 hash.Hash
+
+	// File written until the chunk is closed, then renamed to filename (only NoFlagFile or InProgressExtension)
+	hiddenFilename string
+
+	// Writes the file data from other goroutine (nil the data is written when added)
+	writeQueue *writeQueue
+
+	// Same chunk in the secondary outputs, not written after its 1st error
+	secondaries  []*Chunk
+	secondaryErr error
+
+	// Bytes written to the output (after the encryption)
+	writtenBytes int
 }
 
 // New Creates a chunk instance
 func New(index uint64, options Options) Chunk {
-	c := Chunk{nil, nil, nil, options, index, "", "", "", 0, time.Now().UnixNano()}
+	createdAt := time.Now().UnixNano()
+	c := newChunk(index, options, createdAt)
+
+	for _, outputType := range options.SecondaryOutputTypes {
+		secondaryOptions := options
+		secondaryOptions.OutputType = outputType
+		secondaryOptions.SecondaryOutputTypes = nil
+		secondaryOptions.OnUploadFailed = secondaryFailedReporter(options, outputType)
+		secondaryOptions.OnWritten = nil
+		secondary := newChunk(index, secondaryOptions, createdAt)
+		c.secondaries = append(c.secondaries, &secondary)
+	}
+
+	return c
+}
+
+// secondaryFailedReporter Returns the OnUploadFailed of a secondary output, it reports the error to OnSecondaryFailed (logged if nil)
+func secondaryFailedReporter(options Options, outputType OutputTypes) func(fileName string, err error) {
+	return func(fileName string, err error) {
+		if options.OnSecondaryFailed != nil {
+			options.OnSecondaryFailed(outputType, fileName, err)
+		} else {
+			options.Log.Error("Error writing chunk ", fileName, " to the secondary output ", outputType, ". Err: ", err)
+		}
+	}
+}
+
+// newChunk Creates a chunk of the output (createdAt is shared with its secondaries, so they get the same name)
+func newChunk(index uint64, options Options, createdAt int64) Chunk {
+	c := Chunk{nil, nil, nil, options, index, "", "", "", 0, createdAt, 0, nil, nil, time.Now(), 0, nil, "", nil, nil, "", nil, nil, nil, 0}
+	if options.Checksum {
+		c.checksum = sha256.New()
+		if options.OutputType == ChunkOutputModeS3 {
+			c.checksumMD5 = md5.New()
+		}
+	}
 
-	c.filename = c.createFilename(options.BasePath, options.ChunkBaseFilename, index, options.FileNumberLength, options.FileExtension, "")
-	if options.GhostPrefix != "" {
+	fileIndex := index
+	if options.OutputType == ChunkOutputModeFileByteRange {
+		fileIndex = options.FileIndex
+	}
+	c.filename = c.createFilename(options.BasePath, options.ChunkBaseFilename, fileIndex, options.FileNumberLength, options.FileExtension, "")
+	if options.Filename != "" {
+		c.filename = options.Filename
+		return c
+	}
+	if options.FilenameTemplate != nil {
+		if options.FilenameTemplate.IsResolvedAtClose() {
+			// Hidden until it is renamed to the resolved name (no flag file)
+			c.filename = c.createFilename(options.BasePath, options.ChunkBaseFilename, index, options.FileNumberLength, options.FileExtension+TemporaryFileExtension, ".")
+			return c
+		}
+		c.filename = path.Join(options.BasePath, options.FilenameTemplate.Resolve(FilenameValues{Index: index, CreatedAt: time.Unix(0, c.createdAt)}, options.FileNumberLength)+options.FileExtension)
+		if options.GhostPrefix != "" && options.OutputType != ChunkOutputModeHTTPServer {
+			c.filenameGhost = path.Join(path.Dir(c.filename), options.GhostPrefix+path.Base(c.filename))
+		}
+		c.hideUntilClosed()
+		return c
+	}
+	if options.GhostPrefix != "" && options.OutputType != ChunkOutputModeFileByteRange && options.OutputType != ChunkOutputModeHTTPServer {
 		c.filenameGhost = c.createFilename(options.BasePath, options.ChunkBaseFilename, index, options.FileNumberLength, options.FileExtension, options.GhostPrefix)
 	}
+	c.hideUntilClosed()
 
 	return c
 }
 
+// hideUntilClosed Writes the file chunk to the file with the InProgressExtension, or to a hidden temporary file (without flag file) if NoFlagFile is set and it is not LHLS
+func (c *Chunk) hideUntilClosed() {
+	if c.options.InProgressExtension != "" && c.options.OutputType == ChunkOutputModeFile {
+		c.hiddenFilename = c.filename + c.options.InProgressExtension
+		return
+	}
+	if !c.options.NoFlagFile || c.options.OutputType != ChunkOutputModeFile || c.options.LHLS {
+		return
+	}
+
+	c.filenameGhost = ""
+	c.hiddenFilename = path.Join(path.Dir(c.filename), "."+path.Base(c.filename)+TemporaryFileExtension)
+}
+
 func (c *Chunk) initializeChunkTempFile() error {
 	rand.Seed(time.Now().UnixNano())
 	c.tmpFilename = filepath.Join(os.TempDir(), strconv.Itoa(rand.Intn(1<<32-1))+".tmp")
@@ -113,19 +320,23 @@ func (c *Chunk) initializeChunkFile() error {
 		// Create ghost file
 		exists, _ := fileExists(c.filenameGhost)
 		if !exists {
-			err := ioutil.WriteFile(c.filenameGhost, nil, 0644)
+			err := atomicfile.WriteFile(c.filenameGhost, nil, 0644, false)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	if c.filename != "" {
+	fileName := c.filename
+	if c.hiddenFilename != "" {
+		fileName = c.hiddenFilename
+	}
+	if fileName != "" {
 		// Create media file
-		exists, _ := fileExists(c.filename)
+		exists, _ := fileExists(fileName)
 		if !exists {
 			var err error
-			c.fileDescriptor, err = os.Create(c.filename)
+			c.fileDescriptor, err = os.Create(fileName)
 			if err != nil {
 				return err
 			}
@@ -137,8 +348,32 @@ func (c *Chunk) initializeChunkFile() error {
 	return nil
 }
 
+func (c *Chunk) initializeChunkFileByteRange() error {
+	var err error
+	c.fileDescriptor, err = os.OpenFile(c.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	// The chunk starts at the end of the previous ones
+	info, err := c.fileDescriptor.Stat()
+	if err != nil {
+		c.fileDescriptor.Close()
+		return err
+	}
+	c.byteRangeOffset = info.Size()
+	c.fileWriter = bufio.NewWriter(c.fileDescriptor)
+
+	return nil
+}
+
 func (c *Chunk) initializeChunkHTTPChunkedTransfer() error {
-	c.httpWriteChan = c.options.HTTPUploader.UploadChunkedTransfer(c.filename, c.getChunkHeaders(-1))
+	onDone := func(err error) {
+		if err != nil {
+			c.uploadFailed(err)
+		}
+	}
+	c.httpWriteChan = c.httpUploader().UploadChunkedTransferNotify(c.filename, c.getChunkHeaders(-1), onDone)
 
 	return nil
 }
@@ -147,16 +382,45 @@ func (c *Chunk) initializeChunkHTTPChunkedTransfer() error {
 func (c *Chunk) InitializeChunk() error {
 	ret := error(nil)
 
+	if c.options.EncryptionKey != nil {
+		c.encrypter, ret = encryption.NewCBCEncrypter(c.options.EncryptionKey, c.options.EncryptionIV)
+		if ret != nil {
+			return ret
+		}
+	}
+
 	if c.options.OutputType == ChunkOutputModeFile {
 		ret = c.initializeChunkFile()
+	} else if c.options.OutputType == ChunkOutputModeFileByteRange {
+		ret = c.initializeChunkFileByteRange()
 	} else if c.options.OutputType == ChunkOutputModeHTTPChunkedTransfer {
 		ret = c.initializeChunkHTTPChunkedTransfer()
 	} else if c.options.OutputType == ChunkOutputModeHTTPRegular || c.options.OutputType == ChunkOutputModeS3 {
 		ret = c.initializeChunkTempFile()
+	} else if c.options.OutputType == ChunkOutputModeHTTPServer {
+		c.serverObject = c.options.HTTPServer.Create(c.filename)
+	}
+	if ret == nil && c.fileWriter != nil && c.options.WriteQueueBytes > 0 && (c.options.OutputType == ChunkOutputModeFile || c.options.OutputType == ChunkOutputModeFileByteRange) {
+		c.writeQueue = newWriteQueue(c.fileWriter, c.fileDescriptor, c.options.WriteQueueBytes, c.options.WriteQueueStats)
+	}
+
+	for _, secondary := range c.secondaries {
+		if err := secondary.InitializeChunk(); err != nil {
+			secondary.failed(err)
+		}
 	}
 	return ret
 }
 
+// failed Reports the 1st error of the secondary chunk, it is not written after it
+func (c *Chunk) failed(err error) {
+	if c.secondaryErr != nil {
+		return
+	}
+	c.secondaryErr = err
+	c.uploadFailed(err)
+}
+
 func (c *Chunk) closeChunkFile() {
 	if c.filenameGhost != "" {
 		exists, _ := fileExists(c.filenameGhost)
@@ -168,6 +432,12 @@ func (c *Chunk) closeChunkFile() {
 	if c.fileWriter != nil {
 		c.fileDescriptor.Close()
 	}
+
+	if c.hiddenFilename != "" && c.fileDescriptor != nil {
+		if err := os.Rename(c.hiddenFilename, c.filename); err != nil {
+			c.options.Log.Error("Error renaming chunk ", c.hiddenFilename, " to ", c.filename, ". Err: ", err)
+		}
+	}
 }
 
 func (c *Chunk) closeChunkTmpFileExternal(outputType OutputTypes, durationS float64) {
@@ -178,54 +448,209 @@ func (c *Chunk) closeChunkTmpFileExternal(outputType OutputTypes, durationS floa
 
 	if c.tmpFilename != "" {
 		h := c.getChunkHeaders(durationS)
-		if outputType == ChunkOutputModeS3 {
-			c.options.S3Uploader.UploadLocalFile(c.tmpFilename, c.filename, h)
-		} else {
-			c.options.HTTPUploader.UploadLocalFile(c.tmpFilename, c.filename, h)
+		if c.checksum != nil {
+			h[ChecksumHeader] = c.GetChecksum()
+		}
+		if c.checksumMD5 != nil {
+			h["Content-MD5"] = base64.StdEncoding.EncodeToString(c.checksumMD5.Sum(nil))
+		}
+		if outputType == ChunkOutputModeHTTPRegular {
+			// Uploaded with the concurrent uploads (the next chunk and the manifest do not wait for it), the temp file is deleted after it
+			c.httpUploader().UploadLocalFileNotify(c.tmpFilename, c.filename, h, func(err error) {
+				if err != nil {
+					c.uploadFailed(err)
+				}
+				c.removeTmpFile()
+			})
+			return
+		}
+		if err := c.options.S3Uploader.UploadLocalFile(c.tmpFilename, c.filename, h); err != nil {
+			c.uploadFailed(err)
 		}
 	}
 
-	// Delete temp file
+	c.removeTmpFile()
+}
+
+// removeTmpFile Deletes the temp file of the upload
+func (c *Chunk) removeTmpFile() {
 	exists, _ := fileExists(c.tmpFilename)
 	if exists {
 		os.Remove(c.tmpFilename)
 	}
 }
 
+// httpUploader Returns the HTTP uploader of the chunk with its target ({type} and {seq} of the URL), the files with Filename have no sequence number
+func (c *Chunk) httpUploader() *httpuploader.HTTPUploader {
+	target := httpuploader.Target{Type: c.options.UploadType, Seq: int64(c.index)}
+	if target.Type == "" {
+		target.Type = httpuploader.UploadTypeChunk
+	}
+	if c.options.Filename != "" {
+		target.Seq = -1
+	}
+
+	return c.options.HTTPUploader.ForTarget(target)
+}
+
+// uploadFailed Reports the failed upload of the chunk
+func (c *Chunk) uploadFailed(err error) {
+	if c.options.OnUploadFailed != nil {
+		c.options.OnUploadFailed(c.filename, err)
+	}
+}
+
 func (c *Chunk) closeChunkHTTPChunkedTransfer() {
 	if c.httpWriteChan != nil {
 		close(c.httpWriteChan)
 	}
 }
 
+// ResolveFilename Sets the filename of the template resolved at close with the values of the chunk (the index and creation time are the chunk ones), it has to be called before Close. Nothing is done if the template is resolved when the chunk is created
+func (c *Chunk) ResolveFilename(values FilenameValues) {
+	if c.options.FilenameTemplate == nil || !c.options.FilenameTemplate.IsResolvedAtClose() {
+		return
+	}
+
+	values.Index = c.index
+	values.CreatedAt = time.Unix(0, c.createdAt)
+	c.resolvedFilename = path.Join(c.options.BasePath, c.options.FilenameTemplate.Resolve(values, c.options.FileNumberLength)+c.options.FileExtension)
+
+	for _, secondary := range c.secondaries {
+		secondary.ResolveFilename(values)
+	}
+}
+
+// renameResolved Renames the chunk file (or only its name if it is not saved yet, Ex: S3 upload) to the resolved filename
+func (c *Chunk) renameResolved() {
+	if c.resolvedFilename == "" {
+		return
+	}
+
+	if c.options.OutputType == ChunkOutputModeFile && c.fileDescriptor != nil {
+		if err := os.Rename(c.filename, c.resolvedFilename); err != nil {
+			c.options.Log.Error("Error renaming chunk ", c.filename, " to ", c.resolvedFilename, ". Err: ", err)
+			return
+		}
+	}
+	c.filename = c.resolvedFilename
+	c.resolvedFilename = ""
+}
+
 //Close Closes chunk
 func (c *Chunk) Close(durationS float64) {
-	c.options.Log.Debug("Closing chunk ", c.filename)
-	if c.options.OutputType == ChunkOutputModeFile {
+	if err := c.flush(); err != nil {
+		c.options.Log.Error("Error flushing chunk ", c.filename, ". Err: ", err)
+	}
+	if c.encrypter != nil {
+		// Padded last block
+		if err := c.writeOutput(c.encrypter.Final()); err != nil {
+			c.options.Log.Error("Error writing the last encrypted block of chunk ", c.filename, ". Err: ", err)
+		}
+	}
+	c.options.Log.Debug("Closing chunk ", c.filename, " (", c.flushes, " flushes)")
+	if c.options.OutputType == ChunkOutputModeFile || c.options.OutputType == ChunkOutputModeFileByteRange {
+		if c.writeQueue != nil {
+			// All the data is in the file before it is referenced
+			if err := c.writeQueue.close(); err != nil {
+				c.options.Log.Error("Error writing chunk ", c.filename, ". Err: ", err)
+			}
+		}
+		fileSyncDuration := c.syncFile()
 		c.closeChunkFile()
+		c.renameResolved()
+		c.saveChecksumSidecar()
+		c.syncDir(fileSyncDuration)
 	} else if c.options.OutputType == ChunkOutputModeHTTPChunkedTransfer {
 		c.closeChunkHTTPChunkedTransfer()
 	} else if c.options.OutputType == ChunkOutputModeHTTPRegular || c.options.OutputType == ChunkOutputModeS3 {
+		c.renameResolved()
 		c.closeChunkTmpFileExternal(c.options.OutputType, durationS)
+	} else if c.options.OutputType == ChunkOutputModeHTTPServer && c.serverObject != nil {
+		c.serverObject.Close()
+	} else if c.options.OutputType == ChunkOutputModeCallback && c.options.Callbacks != nil && c.options.Callbacks.OnChunkClosed != nil {
+		c.options.Callbacks.OnChunkClosed(c.info(durationS))
+	}
+
+	for _, secondary := range c.secondaries {
+		secondary.Close(durationS)
 	}
 	return
 }
 
+// syncFile Fsyncs the chunk file (only Fsync), returns the time it took
+func (c *Chunk) syncFile() time.Duration {
+	if !c.options.Fsync || c.fileDescriptor == nil {
+		return 0
+	}
+
+	startedAt := time.Now()
+	if err := c.fileDescriptor.Sync(); err != nil {
+		c.options.Log.Error("Error fsyncing chunk ", c.filename, ". Err: ", err)
+	}
+	return time.Since(startedAt)
+}
+
+// syncDir Fsyncs the directory of the chunk file (only Fsync), so its name is durable, and logs the latency added by the fsyncs
+func (c *Chunk) syncDir(fileSyncDuration time.Duration) {
+	if !c.options.Fsync || c.fileDescriptor == nil {
+		return
+	}
+
+	startedAt := time.Now()
+	if err := atomicfile.SyncDir(path.Dir(c.filename)); err != nil {
+		c.options.Log.Error("Error fsyncing the directory of chunk ", c.filename, ". Err: ", err)
+	}
+	c.options.Log.Debug("Chunk ", c.filename, " fsynced, file: ", fileSyncDuration, ", directory: ", time.Since(startedAt))
+}
+
+// info Returns the information of the chunk passed to the callbacks
+func (c *Chunk) info(durationS float64) ChunkInfo {
+	return ChunkInfo{c.filename, c.index, durationS, c.totalBytes}
+}
+
+// saveChecksumSidecar Saves the SHA-256 of the chunk file to its sidecar file (if it is enabled), the errors are logged
+func (c *Chunk) saveChecksumSidecar() {
+	if c.checksum == nil || !c.options.ChecksumSidecar || c.options.OutputType != ChunkOutputModeFile || c.fileDescriptor == nil {
+		return
+	}
+
+	data := []byte(c.GetChecksum() + "  " + path.Base(c.filename) + "\n")
+	if err := atomicfile.WriteFile(c.filename+ChecksumSidecarExtension, data, 0644, false); err != nil {
+		c.options.Log.Error("Error saving the checksum of chunk ", c.filename, ". Err: ", err)
+	}
+}
+
 func (c *Chunk) getChunkHeaders(durationS float64) map[string]string {
 	h := make(map[string]string)
-	if strings.ToLower(path.Ext(c.filename)) == ".ts" {
+	ext := strings.ToLower(path.Ext(c.filename))
+	if ext == ".ts" || ext == ".m4s" {
 		h["Content-Type"] = "video/MP2T"
+		if ext == ".m4s" {
+			h["Content-Type"] = "video/mp4"
+		}
 		h["Joc-Hls-Chunk-Seq-Number"] = strconv.FormatUint(c.index, 10)
 		h["Joc-Hls-Targetduration-Ms"] = strconv.FormatFloat(c.options.EstimatedDurationS*1000, 'f', 8, 64)
 		h["Joc-Hls-CreatedAt-Ns"] = strconv.FormatInt(c.createdAt, 10)
 		if durationS >= 0 {
 			h["Joc-Hls-Duration-Ms"] = strconv.FormatFloat(durationS*1000, 'f', 8, 64)
 		}
+	} else if ext == ".vtt" {
+		h["Content-Type"] = "text/vtt"
+	} else if ext == ".mp4" {
+		h["Content-Type"] = "video/mp4"
+	} else if ext == ".key" {
+		h["Content-Type"] = "application/octet-stream"
+	} else if ext == ".json" {
+		h["Content-Type"] = "application/json"
 	}
 	return h
 }
 
 func (c *Chunk) addDataChunkFile(buf []byte) error {
+	if c.writeQueue != nil {
+		return c.writeQueue.add(buf)
+	}
 	if c.fileWriter != nil {
 		totalWrittenBytes := 0
 		err := error(nil)
@@ -255,19 +680,93 @@ func (c *Chunk) addDataChunkHTTP(buf []byte) error {
 	return nil
 }
 
-//AddData Add data to chunk and flush it
+//AddData Add data to chunk and flush it (if FlushBytes or FlushInterval are set only when any of them is reached)
 func (c *Chunk) AddData(buf []byte) error {
+	c.options.Log.Debug("Adding data to chunk ", c.filename)
+
+	for _, secondary := range c.secondaries {
+		if secondary.secondaryErr == nil {
+			if err := secondary.AddData(buf); err != nil {
+				secondary.failed(err)
+			}
+		}
+	}
+
+	c.totalBytes = c.totalBytes + len(buf)
+	if c.options.FlushBytes <= 0 && c.options.FlushInterval <= 0 {
+		c.flushes++
+		return c.writeData(buf)
+	}
+
+	c.pendingBuf = append(c.pendingBuf, buf...)
+	if (c.options.FlushBytes > 0 && len(c.pendingBuf) >= c.options.FlushBytes) || (c.options.FlushInterval > 0 && time.Since(c.lastFlushAt) >= c.options.FlushInterval) {
+		return c.flush()
+	}
+	return nil
+}
+
+// flush Writes the data pending, the files are also synced
+func (c *Chunk) flush() error {
+	if len(c.pendingBuf) <= 0 {
+		return nil
+	}
+
+	ret := c.writeData(c.pendingBuf)
+	if ret == nil && c.writeQueue != nil {
+		// Synced after the data queued
+		ret = c.writeQueue.add(nil)
+	} else if ret == nil && c.fileWriter != nil && (c.options.OutputType == ChunkOutputModeFile || c.options.OutputType == ChunkOutputModeFileByteRange) {
+		ret = c.fileDescriptor.Sync()
+	}
+	c.pendingBuf = c.pendingBuf[:0]
+	c.lastFlushAt = time.Now()
+	c.flushes++
+
+	return ret
+}
+
+// writeData Writes the data (encrypted if there is a key) to the output
+func (c *Chunk) writeData(buf []byte) error {
+	if c.encrypter != nil {
+		buf = c.encrypter.Encrypt(buf)
+		if len(buf) <= 0 {
+			// Less than a block, kept for the next data
+			return nil
+		}
+	}
+
+	return c.writeOutput(buf)
+}
+
+// writeOutput Writes the data to the output
+func (c *Chunk) writeOutput(buf []byte) error {
 	ret := error(nil)
 
-	c.options.Log.Debug("Adding data to chunk ", c.filename)
+	if c.checksum != nil {
+		c.checksum.Write(buf)
+	}
+	if c.checksumMD5 != nil {
+		c.checksumMD5.Write(buf)
+	}
 
-	if c.options.OutputType == ChunkOutputModeFile || c.options.OutputType == ChunkOutputModeHTTPRegular || c.options.OutputType == ChunkOutputModeS3 {
+	if c.options.OutputType == ChunkOutputModeFile || c.options.OutputType == ChunkOutputModeFileByteRange || c.options.OutputType == ChunkOutputModeHTTPRegular || c.options.OutputType == ChunkOutputModeS3 {
 		ret = c.addDataChunkFile(buf)
 	} else if c.options.OutputType == ChunkOutputModeHTTPChunkedTransfer {
 		ret = c.addDataChunkHTTP(buf)
+	} else if c.options.OutputType == ChunkOutputModeHTTPServer && c.serverObject != nil {
+		_, ret = c.serverObject.Write(buf)
+	} else if c.options.OutputType == ChunkOutputModeCallback && c.options.Callbacks != nil && c.options.Callbacks.OnChunkData != nil {
+		c.options.Callbacks.OnChunkData(c.info(-1), bytes.NewReader(buf))
+	} else if c.options.OutputType == ChunkOutputModeStdout {
+		_, ret = Stdout.Write(buf)
 	}
-	c.totalBytes = c.totalBytes + len(buf)
 
+	if ret == nil {
+		c.writtenBytes = c.writtenBytes + len(buf)
+		if c.options.OnWritten != nil {
+			c.options.OnWritten(ChunkInfo{c.filename, c.index, -1, c.writtenBytes})
+		}
+	}
 	return ret
 }
 
@@ -281,11 +780,33 @@ func (c *Chunk) IsEmpty() bool {
 	return ret
 }
 
+//GetTotalBytes Returns the bytes added to the chunk
+func (c *Chunk) GetTotalBytes() int {
+	return c.totalBytes
+}
+
 //GetFilename Returns the filename
 func (c *Chunk) GetFilename() string {
 	return c.filename
 }
 
+// GetChecksum Returns the SHA-256 (hex) of the data written, empty if it is not computed
+func (c *Chunk) GetChecksum() string {
+	if c.checksum == nil {
+		return ""
+	}
+	return hex.EncodeToString(c.checksum.Sum(nil))
+}
+
+//GetByteRange Returns the position and size of the chunk in the file (only ChunkOutputModeFileByteRange, size 0 otherwise)
+func (c *Chunk) GetByteRange() (offset int64, length int64) {
+	if c.options.OutputType != ChunkOutputModeFileByteRange {
+		return 0, 0
+	}
+
+	return c.byteRangeOffset, int64(c.totalBytes)
+}
+
 //GetIndex Returns the index
 func (c *Chunk) GetIndex() uint64 {
 	return c.index
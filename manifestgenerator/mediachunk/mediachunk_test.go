@@ -1,3 +1,212 @@
 package mediachunk
 
-//TODO: Add testing
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"go-ts-segmenter/uploaders/httpuploader"
+
+	"github.com/sirupsen/logrus"
+)
+
+func fileSize(t *testing.T, fileName string) int64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal("Error reading chunk file. Err: ", err)
+	}
+	return info.Size()
+}
+
+func TestFlushBytes(t *testing.T) {
+	pathResults := "../../results/ChunkFlushBytes"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "bytes_", FlushBytes: 1000})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	fileName := path.Join(pathResults, "bytes_00000.ts")
+	packet := make([]byte, 188)
+
+	// Written only when the threshold is reached
+	for i := 0; i < 5; i++ {
+		c.AddData(packet)
+	}
+	if got := fileSize(t, fileName); got != 0 {
+		t.Errorf("Chunk size before the flush is not correct, got: %d, want: 0.", got)
+	}
+	c.AddData(packet)
+	if got, xpected := fileSize(t, fileName), int64(6*188); got != xpected {
+		t.Errorf("Chunk size after the flush is not correct, got: %d, want: %d.", got, xpected)
+	}
+
+	// The rest when it is closed
+	c.AddData(packet)
+	c.Close(1)
+	if got, xpected := fileSize(t, fileName), int64(7*188); got != xpected {
+		t.Errorf("Chunk size after close is not correct, got: %d, want: %d.", got, xpected)
+	}
+	if c.flushes != 2 {
+		t.Errorf("Chunk flushes are not correct, got: %d, want: 2.", c.flushes)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	pathResults := "../../results/ChunkFlushInterval"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "interval_", FlushInterval: 50 * time.Millisecond})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	fileName := path.Join(pathResults, "interval_00000.ts")
+	packet := make([]byte, 188)
+
+	c.AddData(packet)
+	c.AddData(packet)
+	if got := fileSize(t, fileName); got != 0 {
+		t.Errorf("Chunk size before the interval is not correct, got: %d, want: 0.", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	c.AddData(packet)
+	if got, xpected := fileSize(t, fileName), int64(3*188); got != xpected {
+		t.Errorf("Chunk size after the interval is not correct, got: %d, want: %d.", got, xpected)
+	}
+	c.Close(1)
+}
+
+func TestChecksumSidecar(t *testing.T) {
+	pathResults := "../../results/ChunkChecksum"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	// Encrypted, the checksum is of the bytes written
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "checksum_", EncryptionKey: []byte("0123456789abcdef"), EncryptionIV: make([]byte, 16), Checksum: true, ChecksumSidecar: true})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	packet := make([]byte, 188)
+	for i := 0; i < 5; i++ {
+		c.AddData(packet)
+	}
+	c.Close(1)
+
+	fileName := path.Join(pathResults, "checksum_00000.ts")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal("Error reading chunk file. Err: ", err)
+	}
+	sum := sha256.Sum256(data)
+	xpected := hex.EncodeToString(sum[:])
+	if got := c.GetChecksum(); got != xpected {
+		t.Errorf("Checksum is not correct, got: %s, want: %s.", got, xpected)
+	}
+	sidecar, err := os.ReadFile(fileName + ChecksumSidecarExtension)
+	if err != nil {
+		t.Fatal("Error reading checksum file. Err: ", err)
+	}
+	if got := string(sidecar); got != xpected+"  checksum_00000.ts\n" {
+		t.Errorf("Checksum file is not correct, got: %s, want: %s.", got, xpected)
+	}
+}
+
+func TestNoFlagFile(t *testing.T) {
+	pathResults := "../../results/ChunkNoFlagFile"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, GhostPrefix: ".growing_", FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "chunk_", NoFlagFile: true})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	c.AddData(make([]byte, 188))
+
+	fileName := path.Join(pathResults, "chunk_00000.ts")
+	for _, name := range []string{fileName, path.Join(pathResults, ".growing_chunk_00000.ts")} {
+		if exists, _ := fileExists(name); exists {
+			t.Errorf("File %s exists before the chunk is closed", name)
+		}
+	}
+	if exists, _ := fileExists(path.Join(pathResults, ".chunk_00000.ts"+TemporaryFileExtension)); !exists {
+		t.Errorf("Chunk is not written to the hidden temporary file")
+	}
+
+	c.Close(1)
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal("Error reading chunk file. Err: ", err)
+	}
+	if info.Size() != 188 {
+		t.Errorf("Chunk size is not correct, got: %d, want: %d.", info.Size(), 188)
+	}
+	if exists, _ := fileExists(path.Join(pathResults, ".chunk_00000.ts"+TemporaryFileExtension)); exists {
+		t.Errorf("Hidden temporary file exists after the chunk is closed")
+	}
+}
+
+func TestWriteQueue(t *testing.T) {
+	pathResults := "../../results/ChunkWriteQueue"
+	os.RemoveAll(pathResults)
+	os.MkdirAll(pathResults, 0744)
+
+	stats := WriteQueueStats{}
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeFile, FileNumberLength: 5, FileExtension: ".ts", BasePath: pathResults, ChunkBaseFilename: "queue_", FlushBytes: 188 * 10, WriteQueueBytes: 188 * 20, WriteQueueStats: &stats})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	xpected := []byte{}
+	for i := 0; i < 500; i++ {
+		packet := bytes.Repeat([]byte{byte(i)}, 188)
+		xpected = append(xpected, packet...)
+		if err := c.AddData(packet); err != nil {
+			t.Fatal("Error adding data. Err: ", err)
+		}
+	}
+	c.Close(1)
+
+	data, err := os.ReadFile(path.Join(pathResults, "queue_00000.ts"))
+	if err != nil {
+		t.Fatal("Error reading chunk file. Err: ", err)
+	}
+	if !bytes.Equal(data, xpected) {
+		t.Errorf("Chunk data is not correct, got: %d bytes, want: %d bytes.", len(data), len(xpected))
+	}
+	if stats.HighWaterBytes <= 0 || stats.HighWaterBytes > 188*20 {
+		t.Errorf("Write queue high water is not correct, got: %d, want: 1 - %d.", stats.HighWaterBytes, 188*20)
+	}
+}
+
+func TestChecksumHTTPHeader(t *testing.T) {
+	var body []byte
+	header := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Get(ChecksumHeader)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	uploader := httpuploader.New(nil, false, u.Scheme, u.Host, 1, 0)
+	c := New(0, Options{Log: logrus.New(), OutputType: ChunkOutputModeHTTPRegular, FileNumberLength: 5, FileExtension: ".ts", BasePath: "checksum", ChunkBaseFilename: "chunk_", HTTPUploader: &uploader, Checksum: true})
+	if err := c.InitializeChunk(); err != nil {
+		t.Fatal("Error initializing chunk. Err: ", err)
+	}
+	c.AddData([]byte{0x47, 1, 2, 3})
+	c.Close(1)
+
+	sum := sha256.Sum256(body)
+	if xpected := hex.EncodeToString(sum[:]); len(body) != 4 || header != xpected {
+		t.Errorf("Checksum header is not correct, got: %s, want: %s.", header, xpected)
+	}
+}
@@ -0,0 +1,140 @@
+package mediachunk
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriteQueueStats Counters of the write queues of the chunks sharing it (updated atomically): max bytes queued, writes blocked because the queue was full and the time blocked
+type WriteQueueStats struct {
+	HighWaterBytes uint64
+	Blocks         uint64
+	BlockedNs      uint64
+}
+
+// writeQueue Bounded byte queue of the data of a chunk file written by its own goroutine, add only blocks when the queue is full. The order of the data is kept, the write errors are returned by the next add / close
+type writeQueue struct {
+	fileWriter     *bufio.Writer
+	fileDescriptor *os.File
+	maxBytes       int
+	stats          *WriteQueueStats
+
+	mutex       sync.Mutex
+	cond        *sync.Cond
+	bufs        [][]byte
+	queuedBytes int
+	isClosed    bool
+	err         error
+	done        chan struct{}
+}
+
+// newWriteQueue Creates the queue of the file (maxBytes queued at most) and starts its writer goroutine, stats can be nil
+func newWriteQueue(fileWriter *bufio.Writer, fileDescriptor *os.File, maxBytes int, stats *WriteQueueStats) *writeQueue {
+	q := &writeQueue{fileWriter, fileDescriptor, maxBytes, stats, sync.Mutex{}, nil, [][]byte{}, 0, false, nil, make(chan struct{})}
+	q.cond = sync.NewCond(&q.mutex)
+
+	go q.run()
+
+	return q
+}
+
+// add Queues a copy of the data, nil queues a file sync (done after the data queued before it). It waits if the queue is full (a buffer larger than the queue waits until it is empty)
+func (q *writeQueue) add(buf []byte) error {
+	var data []byte
+	if buf != nil {
+		data = append(make([]byte, 0, len(buf)), buf...)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.err == nil && q.queuedBytes > 0 && q.queuedBytes+len(data) > q.maxBytes {
+		blockedAt := time.Now()
+		for q.err == nil && q.queuedBytes > 0 && q.queuedBytes+len(data) > q.maxBytes {
+			q.cond.Wait()
+		}
+		if q.stats != nil {
+			atomic.AddUint64(&q.stats.Blocks, 1)
+			atomic.AddUint64(&q.stats.BlockedNs, uint64(time.Since(blockedAt)))
+		}
+	}
+	if q.err != nil {
+		return q.err
+	}
+
+	q.bufs = append(q.bufs, data)
+	q.queuedBytes = q.queuedBytes + len(data)
+	if q.stats != nil {
+		for {
+			highWater := atomic.LoadUint64(&q.stats.HighWaterBytes)
+			if uint64(q.queuedBytes) <= highWater || atomic.CompareAndSwapUint64(&q.stats.HighWaterBytes, highWater, uint64(q.queuedBytes)) {
+				break
+			}
+		}
+	}
+	q.cond.Broadcast()
+
+	return nil
+}
+
+// run Writes the queued data to the file until the queue is closed and empty
+func (q *writeQueue) run() {
+	defer close(q.done)
+
+	q.mutex.Lock()
+	for {
+		for len(q.bufs) <= 0 && !q.isClosed {
+			q.cond.Wait()
+		}
+		if len(q.bufs) <= 0 {
+			break
+		}
+		bufs := q.bufs
+		q.bufs = [][]byte{}
+		q.mutex.Unlock()
+
+		err := error(nil)
+		written := 0
+		for _, buf := range bufs {
+			if err == nil {
+				err = q.write(buf)
+			}
+			written = written + len(buf)
+		}
+
+		q.mutex.Lock()
+		q.queuedBytes = q.queuedBytes - written
+		if err != nil && q.err == nil {
+			q.err = err
+		}
+		q.cond.Broadcast()
+	}
+	q.mutex.Unlock()
+}
+
+// write Writes the data to the file (nil syncs it)
+func (q *writeQueue) write(buf []byte) error {
+	if buf == nil {
+		return q.fileDescriptor.Sync()
+	}
+
+	if _, err := q.fileWriter.Write(buf); err != nil {
+		return err
+	}
+	return q.fileWriter.Flush()
+}
+
+// close Waits until all the queued data is written, returns the 1st write error
+func (q *writeQueue) close() error {
+	q.mutex.Lock()
+	q.isClosed = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	<-q.done
+
+	return q.err
+}
@@ -0,0 +1,222 @@
+package pestransform
+
+import (
+	"sort"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+)
+
+// Transform Returns the new ES data of a complete PES of the PID (Ex: encrypted), it can be longer than the original one
+type Transform func(pID int, es []byte) []byte
+
+// pesPackets PES being received of a PID, the packets (header and adaptation field) it came in and its data (PES header and ES)
+type pesPackets struct {
+	headers [][]byte
+	data    []byte
+	length  int
+	cc      int
+}
+
+// Rewriter Rewrites the PES of the TS PIDs with the transform of their ES. The packets of a PES are kept until it is complete (its PES_packet_length, the next PES or EndPES) and then written again: the original headers and adaptation fields (Ex: PCR) are kept, the data that does not fit goes in new packets and the continuity counters are renumbered
+type Rewriter struct {
+	transform Transform
+	pIDs      map[int]*pesPackets
+}
+
+// New Creates a PES rewriter of the transform
+func New(transform Transform) Rewriter {
+	return Rewriter{transform, map[int]*pesPackets{}}
+}
+
+// AddPID Rewrites the packets of the PID (the other ones are returned as they are)
+func (r *Rewriter) AddPID(pID int) {
+	if _, found := r.pIDs[pID]; !found {
+		r.pIDs[pID] = &pesPackets{nil, nil, 0, -1}
+	}
+}
+
+// Reset Discards the PES being received (Ex: timestamps discontinuity), the PIDs are kept
+func (r *Rewriter) Reset() {
+	for pID := range r.pIDs {
+		r.pIDs[pID] = &pesPackets{nil, nil, 0, -1}
+	}
+}
+
+// AddPacket Adds a TS packet, returns the packets ready to be written (none if it is kept in the PES being received)
+func (r *Rewriter) AddPacket(pckt []byte) []byte {
+	if len(pckt) != tspacket.TsDefaultPacketSize {
+		return pckt
+	}
+	pID := int(pckt[1]&0x1F)<<8 | int(pckt[2])
+	p, found := r.pIDs[pID]
+	if !found {
+		return pckt
+	}
+
+	payloadOffset := 4
+	if pckt[3]&0x20 > 0 {
+		payloadOffset = 5 + int(pckt[4])
+	}
+	if payloadOffset > len(pckt) {
+		payloadOffset = len(pckt)
+	}
+	hasPayload := pckt[3]&0x10 > 0
+
+	ret := []byte{}
+	if hasPayload && pckt[1]&0x40 > 0 {
+		// PES start, the previous one is complete
+		ret = r.EndPES(pID)
+		if p.cc < 0 {
+			// The renumbering continues the source counters
+			p.cc = (int(pckt[3]&0x0F) + 15) & 0x0F
+		}
+		p.headers = [][]byte{}
+		p.data = []byte{}
+		p.length = 0
+		if payload := pckt[payloadOffset:]; len(payload) >= 6 && payload[0] == 0 && payload[1] == 0 && payload[2] == 1 {
+			p.length = int(payload[4])<<8 | int(payload[5])
+		}
+	}
+	if p.headers == nil {
+		// Not in a PES (Ex: the stream started in the middle of one)
+		p.cc = int(pckt[3] & 0x0F)
+		return append(ret, pckt...)
+	}
+
+	p.headers = append(p.headers, append([]byte{}, pckt[:payloadOffset]...))
+	if hasPayload {
+		p.data = append(p.data, pckt[payloadOffset:]...)
+	}
+	if p.length > 0 && len(p.data) >= 6+p.length {
+		ret = append(ret, r.EndPES(pID)...)
+	}
+
+	return ret
+}
+
+// EndPES Completes the PES being received of the PID (Ex: before a chunk is closed at the start of the next one), returns its packets
+func (r *Rewriter) EndPES(pID int) []byte {
+	p, found := r.pIDs[pID]
+	if !found || p.headers == nil {
+		return nil
+	}
+
+	data := r.transformPES(pID, p.data, p.length)
+	ret := []byte{}
+	for i, header := range p.headers {
+		if header[3]&0x10 == 0 {
+			// Adaptation field only (the continuity counter does not change)
+			ret = append(ret, header...)
+			ret[len(ret)-len(header)+3] = header[3]&0xF0 | byte(p.cc&0x0F)
+			continue
+		}
+		if len(data) <= 0 {
+			// Nothing left (the ES is shorter)
+			continue
+		}
+
+		p.cc = (p.cc + 1) & 0x0F
+		n := min(len(data), tspacket.TsDefaultPacketSize-len(header))
+		ret = append(ret, packet(header, data[:n], p.cc)...)
+		data = data[n:]
+
+		if i == len(p.headers)-1 {
+			// The rest in new packets
+			for len(data) > 0 {
+				p.cc = (p.cc + 1) & 0x0F
+				n = min(len(data), tspacket.TsDefaultPacketSize-4)
+				ret = append(ret, packet([]byte{header[0], header[1] &^ 0x40, header[2], header[3]&0xC0 | 0x10}, data[:n], p.cc)...)
+				data = data[n:]
+			}
+		}
+	}
+
+	p.headers = nil
+	p.data = nil
+	p.length = 0
+
+	return ret
+}
+
+// Flush Completes the PES being received of all the PIDs, returns their packets
+func (r *Rewriter) Flush() []byte {
+	pIDs := []int{}
+	for pID := range r.pIDs {
+		pIDs = append(pIDs, pID)
+	}
+	sort.Ints(pIDs)
+
+	ret := []byte{}
+	for _, pID := range pIDs {
+		ret = append(ret, r.EndPES(pID)...)
+	}
+	return ret
+}
+
+// transformPES Returns the PES with the transformed ES, the PES_packet_length is updated (0 if it does not fit)
+func (r *Rewriter) transformPES(pID int, pes []byte, length int) []byte {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return pes
+	}
+	headerLength := 9 + int(pes[8])
+	if headerLength > len(pes) {
+		return pes
+	}
+	esEnd := len(pes)
+	if length > 0 && 6+length <= len(pes) {
+		esEnd = 6 + length
+	}
+
+	es := r.transform(pID, pes[headerLength:esEnd])
+	out := append(append([]byte{}, pes[:headerLength]...), es...)
+	if length > 0 {
+		newLength := len(out) - 6
+		if newLength > 0xFFFF {
+			newLength = 0
+		}
+		out[4], out[5] = byte(newLength>>8), byte(newLength)
+	}
+
+	return out
+}
+
+// packet Returns the packet of the header (with the adaptation field) and the data that fits, the rest of the packet is stuffed in the adaptation field
+func packet(header []byte, data []byte, cc int) []byte {
+	pckt := append([]byte{}, header...)
+	pckt[3] = pckt[3]&0xF0 | byte(cc&0x0F)
+
+	capacity := tspacket.TsDefaultPacketSize - len(pckt)
+	if len(data) >= capacity {
+		return append(pckt, data[:capacity]...)
+	}
+
+	stuffing := capacity - len(data)
+	if pckt[3]&0x20 > 0 {
+		// Existing adaptation field, stuffing at its end
+		pckt[4] = pckt[4] + byte(stuffing)
+		if pckt[4] == byte(stuffing) {
+			// It was empty, the flags byte is needed
+			pckt = append(pckt, 0x00)
+			stuffing--
+		}
+	} else {
+		pckt[3] = pckt[3] | 0x20
+		pckt = append(pckt, byte(stuffing-1))
+		if stuffing > 1 {
+			pckt = append(pckt, 0x00)
+		}
+		stuffing = stuffing - 2
+	}
+	for i := 0; i < stuffing; i++ {
+		pckt = append(pckt, 0xFF)
+	}
+
+	return append(pckt, data...)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,103 @@
+package pestransform
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"go-ts-segmenter/manifestgenerator/tspacket"
+)
+
+// pidPackets Returns the packets of the PID in the data
+func pidPackets(data []byte, pID int) [][]byte {
+	packets := [][]byte{}
+	for i := 0; i+tspacket.TsDefaultPacketSize <= len(data); i = i + tspacket.TsDefaultPacketSize {
+		pckt := data[i : i+tspacket.TsDefaultPacketSize]
+		if int(pckt[1]&0x1F)<<8|int(pckt[2]) == pID {
+			packets = append(packets, pckt)
+		}
+	}
+	return packets
+}
+
+// rewrite Returns the fixture rewritten with the transform of the video (256) and audio (257) PIDs
+func rewrite(t *testing.T, transform Transform) (fixture []byte, rewritten []byte) {
+	fixture, err := os.ReadFile("../../fixture/testSmall.ts")
+	if err != nil {
+		t.Fatal("Error reading fixture. Err: ", err)
+	}
+
+	r := New(transform)
+	r.AddPID(256)
+	r.AddPID(257)
+	for i := 0; i+tspacket.TsDefaultPacketSize <= len(fixture); i = i + tspacket.TsDefaultPacketSize {
+		rewritten = append(rewritten, r.AddPacket(fixture[i:i+tspacket.TsDefaultPacketSize])...)
+	}
+	rewritten = append(rewritten, r.Flush()...)
+
+	return fixture, rewritten
+}
+
+func TestRewriterIdentity(t *testing.T) {
+	fixture, rewritten := rewrite(t, func(pID int, es []byte) []byte { return es })
+
+	if len(rewritten) != len(fixture) {
+		t.Fatalf("Rewritten length is not correct, got: %d, want: %d.", len(rewritten), len(fixture))
+	}
+	for _, pID := range []int{0, 256, 257} {
+		xpected, got := pidPackets(fixture, pID), pidPackets(rewritten, pID)
+		if len(got) != len(xpected) {
+			t.Fatalf("PID %d packets are not correct, got: %d, want: %d.", pID, len(got), len(xpected))
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], xpected[i]) {
+				t.Fatalf("PID %d packet %d is not the original one", pID, i)
+			}
+		}
+	}
+}
+
+func TestRewriterLongerES(t *testing.T) {
+	extra := bytes.Repeat([]byte{0xAB}, 500)
+	fixture, rewritten := rewrite(t, func(pID int, es []byte) []byte { return append(append([]byte{}, es...), extra...) })
+
+	for _, pID := range []int{256, 257} {
+		packets := pidPackets(rewritten, pID)
+		if len(packets) <= len(pidPackets(fixture, pID)) {
+			t.Errorf("PID %d has no new packets", pID)
+		}
+
+		cc := -1
+		pesStarts := 0
+		for i, pckt := range packets {
+			if pckt[0] != 0x47 {
+				t.Fatalf("PID %d packet %d has no sync byte", pID, i)
+			}
+			if pckt[3]&0x10 > 0 {
+				if cc >= 0 && int(pckt[3]&0x0F) != (cc+1)&0x0F {
+					t.Fatalf("PID %d packet %d continuity counter is not correct, got: %d, want: %d.", pID, i, pckt[3]&0x0F, (cc+1)&0x0F)
+				}
+				cc = int(pckt[3] & 0x0F)
+			}
+			if pckt[1]&0x40 > 0 {
+				pesStarts++
+			}
+		}
+		if pesStarts <= 0 {
+			t.Errorf("PID %d without PES", pID)
+		}
+	}
+
+	// The audio PES have PES_packet_length, it includes the new data
+	for _, pckt := range pidPackets(rewritten, 257) {
+		if pckt[1]&0x40 == 0 {
+			continue
+		}
+		p := tspacket.New(tspacket.TsDefaultPacketSize)
+		p.AddData(pckt)
+		p.Parse(-1)
+		if payload := p.GetPayload(); len(payload) >= 6 && int(payload[4])<<8|int(payload[5]) < len(extra) {
+			t.Errorf("Audio PES_packet_length is not correct, got: %d.", int(payload[4])<<8|int(payload[5]))
+		}
+	}
+}
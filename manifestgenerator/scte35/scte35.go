@@ -0,0 +1,352 @@
+package scte35
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SpliceInfoTableID Table ID of the splice_info_section
+	SpliceInfoTableID uint8 = 0xFC
+
+	// SpliceNullCommand splice_null command type
+	SpliceNullCommand uint8 = 0x00
+
+	// SpliceInsertCommand splice_insert command type
+	SpliceInsertCommand uint8 = 0x05
+
+	// TimeSignalCommand time_signal command type
+	TimeSignalCommand uint8 = 0x06
+
+	// SegmentationDescriptorTag Tag of the segmentation_descriptor
+	SegmentationDescriptorTag uint8 = 0x02
+
+	// MaxPTSValue 33 bits of PTS (90KHz)
+	MaxPTSValue uint64 = 1 << 33
+
+	// headerSize Bytes of splice_info_section before the command (including the command type)
+	headerSize = 14
+)
+
+// SignalTypes Signal of the splice info for the ad insertion
+type SignalTypes int
+
+const (
+	// SignalNone Nothing to signal (Ex: splice_null, heartbeats)
+	SignalNone SignalTypes = iota
+
+	// SignalOut Start of a break (out of network)
+	SignalOut
+
+	// SignalIn End of a break (back to network)
+	SignalIn
+
+	// SignalCmd Other commands (Ex: time_signal without a known segmentation type)
+	SignalCmd
+)
+
+// SpliceInfo Data of a splice_info_section
+type SpliceInfo struct {
+	CommandType   uint8
+	Signal        SignalTypes
+	EventID       uint32
+	IsCancel      bool
+	IsImmediate   bool
+	HasPTS        bool
+	PTS           uint64
+	HasDuration   bool
+	DurationTicks uint64
+	Raw           []byte
+}
+
+// GetPTSS Returns the splice PTS in seconds (pts_adjustment applied), -1 if it has no PTS
+func (si *SpliceInfo) GetPTSS() float64 {
+	if !si.HasPTS {
+		return -1
+	}
+	return float64(si.PTS) / 90000.0
+}
+
+// GetDurationS Returns the break duration in seconds, -1 if it has no duration
+func (si *SpliceInfo) GetDurationS() float64 {
+	if !si.HasDuration {
+		return -1
+	}
+	return float64(si.DurationTicks) / 90000.0
+}
+
+// Parse Parses a splice_info_section (the CRC is not checked)
+func Parse(section []byte) (SpliceInfo, error) {
+	si := SpliceInfo{}
+
+	if len(section) < headerSize {
+		return si, errors.New("splice info section too short")
+	}
+	if section[0] != SpliceInfoTableID {
+		return si, fmt.Errorf("invalid splice info table ID 0x%02X", section[0])
+	}
+	sectionLength := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+	if len(section) < 3+sectionLength {
+		return si, fmt.Errorf("splice info section truncated, got: %d bytes, want: %d", len(section), 3+sectionLength)
+	}
+	section = section[:3+sectionLength]
+	if section[4]&0x80 > 0 {
+		return si, errors.New("encrypted splice info sections are not supported")
+	}
+
+	ptsAdjustment := uint64(section[4]&0x01)<<32 | uint64(binary.BigEndian.Uint32(section[5:9]))
+
+	si.Raw = make([]byte, len(section))
+	copy(si.Raw, section)
+	si.CommandType = section[13]
+
+	r := reader{section, headerSize, nil}
+	switch si.CommandType {
+	case SpliceInsertCommand:
+		si.parseSpliceInsert(&r)
+	case TimeSignalCommand:
+		si.HasPTS, si.PTS = r.spliceTime()
+		si.Signal = SignalCmd
+	}
+
+	// Descriptors after the command (the command length can be 0xFFF in old versions)
+	commandLength := int(binary.BigEndian.Uint16(section[11:13]) & 0x0FFF)
+	if commandLength != 0x0FFF {
+		r.pos = headerSize + commandLength
+	}
+	descriptorsLength := int(r.uint16())
+	descriptors := r.bytes(descriptorsLength)
+	if r.err != nil {
+		return si, r.err
+	}
+
+	if si.CommandType == TimeSignalCommand {
+		si.parseSegmentationDescriptors(descriptors)
+	}
+
+	if si.HasPTS {
+		si.PTS = (si.PTS + ptsAdjustment) % MaxPTSValue
+	}
+
+	return si, nil
+}
+
+// parseSpliceInsert Parses the splice_insert command, the 1st component time is used for component splices
+func (si *SpliceInfo) parseSpliceInsert(r *reader) {
+	si.EventID = r.uint32()
+	si.IsCancel = r.uint8()&0x80 > 0
+	if si.IsCancel {
+		return
+	}
+
+	flags := r.uint8()
+	isOutOfNetwork := flags&0x80 > 0
+	isProgramSplice := flags&0x40 > 0
+	hasDuration := flags&0x20 > 0
+	si.IsImmediate = flags&0x10 > 0
+
+	if isProgramSplice {
+		if !si.IsImmediate {
+			si.HasPTS, si.PTS = r.spliceTime()
+		}
+	} else {
+		componentCount := int(r.uint8())
+		for n := 0; n < componentCount; n++ {
+			r.uint8()
+			if !si.IsImmediate {
+				hasPTS, pts := r.spliceTime()
+				if n == 0 {
+					si.HasPTS, si.PTS = hasPTS, pts
+				}
+			}
+		}
+	}
+
+	if hasDuration {
+		si.HasDuration, si.DurationTicks = true, r.uint40()&(MaxPTSValue-1)
+	}
+
+	si.Signal = SignalIn
+	if isOutOfNetwork {
+		si.Signal = SignalOut
+	}
+}
+
+// parseSegmentationDescriptors Gets the signal from the 1st segmentation_descriptor of a time_signal (starts are even types from 0x22, ends odd types)
+func (si *SpliceInfo) parseSegmentationDescriptors(descriptors []byte) {
+	r := reader{descriptors, 0, nil}
+
+	for r.pos < len(descriptors) && r.err == nil {
+		tag := r.uint8()
+		descriptor := reader{r.bytes(int(r.uint8())), 0, nil}
+		if r.err != nil || tag != SegmentationDescriptorTag {
+			continue
+		}
+
+		// Identifier (CUEI)
+		descriptor.uint32()
+		si.EventID = descriptor.uint32()
+		si.IsCancel = descriptor.uint8()&0x80 > 0
+		if si.IsCancel {
+			return
+		}
+
+		flags := descriptor.uint8()
+		if flags&0x80 == 0 {
+			// Component segmentation
+			descriptor.bytes(int(descriptor.uint8()) * 6)
+		}
+		if flags&0x40 > 0 {
+			si.HasDuration, si.DurationTicks = true, descriptor.uint40()
+		}
+		// UPID
+		descriptor.uint8()
+		descriptor.bytes(int(descriptor.uint8()))
+		typeID := descriptor.uint8()
+		if descriptor.err != nil {
+			return
+		}
+
+		if typeID >= 0x22 {
+			si.Signal = SignalIn
+			if typeID%2 == 0 {
+				si.Signal = SignalOut
+			}
+		}
+		return
+	}
+}
+
+// reader Big endian reader that keeps the 1st error
+type reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.buf) {
+		r.err = errors.New("splice info section truncated")
+		return nil
+	}
+	ret := r.buf[r.pos : r.pos+n]
+	r.pos = r.pos + n
+
+	return ret
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint40() uint64 {
+	b := r.bytes(5)
+	if b == nil {
+		return 0
+	}
+	return uint64(b[0])<<32 | uint64(binary.BigEndian.Uint32(b[1:]))
+}
+
+// spliceTime Reads a splice_time (33 bits PTS if time_specified_flag is set)
+func (r *reader) spliceTime() (bool, uint64) {
+	if r.err != nil || r.pos >= len(r.buf) {
+		r.err = errors.New("splice info section truncated")
+		return false, 0
+	}
+	if r.buf[r.pos]&0x80 == 0 {
+		r.pos++
+		return false, 0
+	}
+
+	return true, r.uint40() & (MaxPTSValue - 1)
+}
+
+// SectionAssembler Joins the sections of a PID split in several TS packets
+type SectionAssembler struct {
+	buf     []byte
+	started bool
+}
+
+// AddPayload Adds the TS packet payload, returns the sections completed by it
+func (a *SectionAssembler) AddPayload(payload []byte, isPayloadUnitStart bool) (sections [][]byte) {
+	data := payload
+	if isPayloadUnitStart {
+		if len(payload) <= 0 {
+			return
+		}
+		pointer := int(payload[0])
+		data = payload[1:]
+		if pointer > len(data) {
+			a.Reset()
+			return
+		}
+
+		// The bytes before the pointer are the end of the previous section
+		if a.started {
+			a.buf = append(a.buf, data[:pointer]...)
+			sections = a.completedSections(sections)
+		}
+		a.buf = a.buf[:0]
+		a.started = true
+		data = data[pointer:]
+	} else if !a.started {
+		// Waiting for a section start
+		return
+	}
+
+	a.buf = append(a.buf, data...)
+
+	return a.completedSections(sections)
+}
+
+// completedSections Extracts the completed sections from the buffer
+func (a *SectionAssembler) completedSections(sections [][]byte) [][]byte {
+	for len(a.buf) >= 3 {
+		if a.buf[0] == 0xFF {
+			// Stuffing, nothing else until the next section start
+			a.Reset()
+			break
+		}
+		sectionSize := 3 + int(binary.BigEndian.Uint16(a.buf[1:3])&0x0FFF)
+		if len(a.buf) < sectionSize {
+			break
+		}
+
+		section := make([]byte, sectionSize)
+		copy(section, a.buf)
+		sections = append(sections, section)
+		a.buf = a.buf[:copy(a.buf, a.buf[sectionSize:])]
+	}
+
+	return sections
+}
+
+// Reset Discards the partial section
+func (a *SectionAssembler) Reset() {
+	a.buf = a.buf[:0]
+	a.started = false
+}
@@ -0,0 +1,78 @@
+package scte35
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// Samples from SCTE-35 2019 (14.1 time_signal placement opportunity start, 14.2 splice_insert)
+const (
+	timeSignalSample   = "/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg=="
+	spliceInsertSample = "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+)
+
+func TestParseSpliceInsert(t *testing.T) {
+	section, _ := base64.StdEncoding.DecodeString(spliceInsertSample)
+
+	si, err := Parse(section)
+	if err != nil {
+		t.Fatal("Error parsing splice insert. Err: ", err)
+	}
+
+	if si.CommandType != SpliceInsertCommand || si.Signal != SignalOut || si.IsCancel || si.IsImmediate {
+		t.Errorf("Splice insert data is not correct, got: %+v.", si)
+	}
+	if si.EventID != 0x4800008F {
+		t.Errorf("Event ID is not correct, got: %X, want: %X.", si.EventID, 0x4800008F)
+	}
+	if si.PTS != 0x07369C02E {
+		t.Errorf("PTS is not correct, got: %d, want: %d.", si.PTS, 0x07369C02E)
+	}
+	if si.DurationTicks != 0x00052CCF5 {
+		t.Errorf("Duration is not correct, got: %d, want: %d.", si.DurationTicks, 0x00052CCF5)
+	}
+	if base64.StdEncoding.EncodeToString(si.Raw) != spliceInsertSample {
+		t.Errorf("Raw section is not correct, got: %s, want: %s.", base64.StdEncoding.EncodeToString(si.Raw), spliceInsertSample)
+	}
+}
+
+func TestParseTimeSignal(t *testing.T) {
+	section, _ := base64.StdEncoding.DecodeString(timeSignalSample)
+
+	si, err := Parse(section)
+	if err != nil {
+		t.Fatal("Error parsing time signal. Err: ", err)
+	}
+
+	// Segmentation type 0x34 (provider placement opportunity start)
+	if si.CommandType != TimeSignalCommand || si.Signal != SignalOut || si.IsCancel {
+		t.Errorf("Time signal data is not correct, got: %+v.", si)
+	}
+	if si.EventID != 0x4800008E {
+		t.Errorf("Segmentation event ID is not correct, got: %X, want: %X.", si.EventID, 0x4800008E)
+	}
+	if si.PTS != 0x072BD0050 {
+		t.Errorf("PTS is not correct, got: %d, want: %d.", si.PTS, 0x072BD0050)
+	}
+	if si.GetDurationS() != 307.0 {
+		t.Errorf("Duration is not correct, got: %f, want: %f.", si.GetDurationS(), 307.0)
+	}
+}
+
+func TestSectionAssembler(t *testing.T) {
+	section, _ := base64.StdEncoding.DecodeString(spliceInsertSample)
+
+	// Section split in 3 payloads, and the start of the next one after it
+	payloads := [][]byte{append([]byte{0x00}, section[:10]...), section[10:30], append(section[30:], section[:5]...)}
+	starts := []bool{true, false, false}
+
+	var a SectionAssembler
+	sections := [][]byte{}
+	for i := range payloads {
+		sections = append(sections, a.AddPayload(payloads[i], starts[i])...)
+	}
+
+	if len(sections) != 1 || string(sections[0]) != string(section) {
+		t.Errorf("Assembled sections are not correct, got: %x, want: %x.", sections, section)
+	}
+}
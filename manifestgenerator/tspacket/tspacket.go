@@ -4,14 +4,21 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 const (
 	// TsDefaultPacketSize Default TS packet size
 	TsDefaultPacketSize int = 188
 
-	// MaxPCRSValue (in seconds). 2^33 / 90000 (33 bits used by pcr with timebase of 90KHz)
-	MaxPCRSValue float64 = 95443
+	// TsRSPacketSize TS packet size with 16 trailing Reed-Solomon bytes (DVB)
+	TsRSPacketSize int = 204
+
+	// MaxPCRSValue (in seconds). 2^33 / 90000 (33 bits used by pcr with timebase of 90KHz), also the PTS wraparound
+	MaxPCRSValue float64 = float64(1<<33) / 90000.0
+
+	// ClockHz PTS and PCR base clock (90KHz)
+	ClockHz = 90000
 
 	// tsStartByte Start byte for TS pakcets
 	tsStartByte uint8 = 0x47
@@ -19,11 +26,89 @@ const (
 	// H264StreamType indicates h264 video ES
 	H264StreamType uint8 = 0x1B
 
+	// HEVCStreamType indicates h265 (HEVC) video ES
+	HEVCStreamType uint8 = 0x24
+
+	// MPEG2VideoStreamType indicates MPEG-2 video ES
+	MPEG2VideoStreamType uint8 = 0x02
+
 	// ADTSStreamType indicates audio ADTS ES
 	ADTSStreamType uint8 = 0x0F
 
+	// AC3StreamType indicates audio AC-3 ES (ATSC)
+	AC3StreamType uint8 = 0x81
+
+	// EAC3StreamType indicates audio E-AC-3 ES (ATSC)
+	EAC3StreamType uint8 = 0x87
+
+	// EAC3AltStreamType indicates audio E-AC-3 ES (used by some encoders)
+	EAC3AltStreamType uint8 = 0xCC
+
+	// PrivatePESStreamType indicates PES private data, the ES descriptors indicate the codec (Ex: AC-3 in DVB)
+	PrivatePESStreamType uint8 = 0x06
+
+	// MetadataStreamType indicates timed metadata in PES (Ex: ID3)
+	MetadataStreamType uint8 = 0x15
+
+	// SCTE35StreamType indicates SCTE-35 splice info sections
+	SCTE35StreamType uint8 = 0x86
+
+	// SampleAESH264StreamType indicates SAMPLE-AES encrypted h264 video ES
+	SampleAESH264StreamType uint8 = 0xDB
+
+	// SampleAESADTSStreamType indicates SAMPLE-AES encrypted audio ADTS ES
+	SampleAESADTSStreamType uint8 = 0xCF
+
 	// PATPID PID of PAT table
 	PATPID uint16 = 0
+
+	// MinESPID Lowest PID that can be used for PMT or elementary streams (0x0000 - 0x000F are reserved)
+	MinESPID int = 0x0010
+
+	// NullPID PID of null packets (also the highest PID)
+	NullPID int = 0x1FFF
+)
+
+// HEVC NAL unit types
+const (
+	// hevcNALTypeBLAWLP 1st IRAP NAL unit type (BLA_W_LP)
+	hevcNALTypeBLAWLP uint8 = 16
+
+	// hevcNALTypeCRA Last IRAP NAL unit type used (CRA_NUT), 22 and 23 are reserved
+	hevcNALTypeCRA uint8 = 21
+
+	// hevcNALTypeVPS VPS NAL unit type, lower types are VCL
+	hevcNALTypeVPS uint8 = 32
+)
+
+// ES descriptor tags
+const (
+	// registrationDescriptorTag Registration descriptor, the format identifier indicates the codec (Ex: "AC-3")
+	registrationDescriptorTag uint8 = 0x05
+
+	// ac3DescriptorTag AC-3 descriptor (DVB)
+	ac3DescriptorTag uint8 = 0x6A
+
+	// eac3DescriptorTag Enhanced AC-3 descriptor (DVB)
+	eac3DescriptorTag uint8 = 0x7A
+
+	// ac3ATSCDescriptorTag AC-3 audio descriptor (ATSC)
+	ac3ATSCDescriptorTag uint8 = 0x81
+
+	// eac3ATSCDescriptorTag E-AC-3 audio descriptor (ATSC)
+	eac3ATSCDescriptorTag uint8 = 0xCC
+)
+
+// MPEG-2 video start codes
+const (
+	// mpeg2PictureStartCode Picture header start code
+	mpeg2PictureStartCode uint8 = 0x00
+
+	// mpeg2SequenceHeaderCode Sequence header start code
+	mpeg2SequenceHeaderCode uint8 = 0xB3
+
+	// mpeg2PictureCodingTypeI picture_coding_type of I pictures
+	mpeg2PictureCodingTypeI uint8 = 1
 )
 
 // transportPacketData TS packet info
@@ -69,9 +154,18 @@ func (t *transportPacketData) Reset() {
 	t.AdaptationField.PCRData.PCRs = 0
 	t.Pat.valid = false
 	t.Pat.PmtPID = 0
+	t.Pat.Programs = t.Pat.Programs[:0]
 	t.Pmt.valid = false
+	t.Pmt.Version = 0
+	t.Pmt.PCRPID = 0
 	t.Pmt.AudioADTS = t.Pmt.AudioADTS[:0]
+	t.Pmt.AudioAC3 = t.Pmt.AudioAC3[:0]
+	t.Pmt.AudioEAC3 = t.Pmt.AudioEAC3[:0]
 	t.Pmt.Videoh264 = t.Pmt.Videoh264[:0]
+	t.Pmt.VideoHEVC = t.Pmt.VideoHEVC[:0]
+	t.Pmt.VideoMPEG2 = t.Pmt.VideoMPEG2[:0]
+	t.Pmt.SCTE35 = t.Pmt.SCTE35[:0]
+	t.Pmt.Metadata = t.Pmt.Metadata[:0]
 	t.Pmt.Other = t.Pmt.Other[:0]
 }
 
@@ -98,18 +192,33 @@ type transportPacketAdaptationPCRFieldData struct {
 	valid                          bool
 }
 
-// PAT data storing the PMT ID
+// PAT data storing the PMT ID (of the 1st program) and all the programs
 type programAddressTable struct {
-	valid  bool
-	PmtPID uint16
+	valid    bool
+	PmtPID   uint16
+	Programs []PATProgram
+}
+
+// PATProgram Program defined in the PAT
+type PATProgram struct {
+	ProgramNumber int
+	PMTPID        int
 }
 
 // PMT data storing the video and audio PIDs to process
 type programMapTable struct {
-	valid     bool
-	Videoh264 []uint16
-	AudioADTS []uint16
-	Other     []uint16
+	valid      bool
+	Version    uint8
+	PCRPID     uint16
+	Videoh264  []uint16
+	VideoHEVC  []uint16
+	VideoMPEG2 []uint16
+	AudioADTS  []uint16
+	AudioAC3   []uint16
+	AudioEAC3  []uint16
+	SCTE35     []uint16
+	Metadata   []uint16
+	Other      []uint16
 }
 
 // TsPacket Transport stream packet
@@ -142,11 +251,25 @@ func CloneFrom(srcPckt TsPacket) TsPacket {
 
 	newPckt.pmt.AudioADTS = make([]uint16, len(srcPckt.pmt.AudioADTS))
 	copy(newPckt.pmt.AudioADTS, srcPckt.pmt.AudioADTS)
+	newPckt.pmt.AudioAC3 = make([]uint16, len(srcPckt.pmt.AudioAC3))
+	copy(newPckt.pmt.AudioAC3, srcPckt.pmt.AudioAC3)
+	newPckt.pmt.AudioEAC3 = make([]uint16, len(srcPckt.pmt.AudioEAC3))
+	copy(newPckt.pmt.AudioEAC3, srcPckt.pmt.AudioEAC3)
 	newPckt.pmt.Videoh264 = make([]uint16, len(srcPckt.pmt.Videoh264))
 	copy(newPckt.pmt.Videoh264, srcPckt.pmt.Videoh264)
+	newPckt.pmt.VideoHEVC = make([]uint16, len(srcPckt.pmt.VideoHEVC))
+	copy(newPckt.pmt.VideoHEVC, srcPckt.pmt.VideoHEVC)
+	newPckt.pmt.VideoMPEG2 = make([]uint16, len(srcPckt.pmt.VideoMPEG2))
+	copy(newPckt.pmt.VideoMPEG2, srcPckt.pmt.VideoMPEG2)
 	newPckt.pmt.Other = make([]uint16, len(srcPckt.pmt.Other))
 	copy(newPckt.pmt.Other, srcPckt.pmt.Other)
+	newPckt.pmt.SCTE35 = make([]uint16, len(srcPckt.pmt.SCTE35))
+	copy(newPckt.pmt.SCTE35, srcPckt.pmt.SCTE35)
+	newPckt.pmt.Metadata = make([]uint16, len(srcPckt.pmt.Metadata))
+	copy(newPckt.pmt.Metadata, srcPckt.pmt.Metadata)
 	newPckt.pmt.valid = srcPckt.pmt.valid
+	newPckt.pmt.Version = srcPckt.pmt.Version
+	newPckt.pmt.PCRPID = srcPckt.pmt.PCRPID
 
 	return newPckt
 }
@@ -295,26 +418,41 @@ func (p *TsPacket) Parse(pmtPID int) bool {
 		}
 	}
 
-	// PAT Packet (Getting the 1st PMT info, so assuming only one program and there is NO network ID)
+	// PAT Packet (The 1st program is used as default PMT, program 0 is the network ID)
 	if p.transportPacket.PID == PATPID {
-		var pmtPID16b struct {
+		var patHeader struct {
 			TableID                    uint8
 			FlagsReservedSectionLength uint16
 			TSId                       uint16
 			Flags                      uint8
 			SectionNumber              uint8
 			LastSectionNumber          uint8
-
-			//Initial PMT
-			ProgramNumber  uint16
-			ReservedPMTPID uint16
 		}
-		err = binary.Read(r, binary.BigEndian, &pmtPID16b)
+		err = binary.Read(r, binary.BigEndian, &patHeader)
 		if err != nil {
 			return false
 		}
-		p.transportPacket.Pat.PmtPID = pmtPID16b.ReservedPMTPID & 0x1FFF
-		p.transportPacket.Pat.valid = true
+
+		// 5 bytes of header after the section length and 4 of CRC
+		numPrograms := (int(patHeader.FlagsReservedSectionLength&0x0FFF) - 9) / 4
+		for n := 0; n < numPrograms; n++ {
+			var program struct {
+				ProgramNumber  uint16
+				ReservedPMTPID uint16
+			}
+			err = binary.Read(r, binary.BigEndian, &program)
+			if err != nil {
+				break
+			}
+			if program.ProgramNumber == 0 {
+				continue
+			}
+			p.transportPacket.Pat.Programs = append(p.transportPacket.Pat.Programs, PATProgram{int(program.ProgramNumber), int(program.ReservedPMTPID & 0x1FFF)})
+		}
+		if len(p.transportPacket.Pat.Programs) > 0 {
+			p.transportPacket.Pat.PmtPID = uint16(p.transportPacket.Pat.Programs[0].PMTPID)
+			p.transportPacket.Pat.valid = true
+		}
 	}
 
 	// PMT Packet
@@ -322,9 +460,11 @@ func (p *TsPacket) Parse(pmtPID int) bool {
 		var tableInfo struct {
 			_                uint8
 			SectionLength    uint16
-			_                uint32
 			_                uint16
+			VersionCurrent   uint8
 			_                uint8
+			_                uint8
+			PCRPID           uint16
 			ProgamInfoLength uint16
 		}
 		err = binary.Read(r, binary.BigEndian, &tableInfo)
@@ -333,23 +473,21 @@ func (p *TsPacket) Parse(pmtPID int) bool {
 		}
 
 		sectionLength := tableInfo.SectionLength & 0x0FFF
+		p.transportPacket.Pmt.Version = (tableInfo.VersionCurrent >> 1) & 0x1F
+		p.transportPacket.Pmt.PCRPID = tableInfo.PCRPID & 0x1FFF
 		tableEnd := int(sectionLength - 13)
 
 		programInfoLength := tableInfo.ProgamInfoLength & 0x0FFF
 
-		paddingBytes := programInfoLength
-		offset := 0
+		// Program descriptors are skipped
+		programInfo := make([]byte, programInfoLength)
+		err = binary.Read(r, binary.BigEndian, programInfo)
+		if err != nil {
+			return false
+		}
+		offset := int(programInfoLength)
 
 		for offset < tableEnd {
-			for paddingBytes > 0 {
-				var pad uint8
-				err = binary.Read(r, binary.BigEndian, &pad)
-				if err != nil {
-					return false
-				}
-				paddingBytes = paddingBytes - 1
-				offset = offset + 1
-			}
 			var program struct {
 				StreamType uint8
 				PID        uint16
@@ -359,16 +497,39 @@ func (p *TsPacket) Parse(pmtPID int) bool {
 			if err != nil {
 				return false
 			}
-			offset = offset + 5
 
-			paddingBytes = program.Next & 0x0FFF
+			// ES descriptors
+			esInfo := make([]byte, program.Next&0x0FFF)
+			err = binary.Read(r, binary.BigEndian, esInfo)
+			if err != nil {
+				return false
+			}
+			offset = offset + 5 + len(esInfo)
+
 			pid := program.PID & 0x1FFF
 
-			switch program.StreamType {
+			streamType := program.StreamType
+			if streamType == PrivatePESStreamType {
+				streamType = getDolbyStreamType(esInfo)
+			}
+
+			switch streamType {
 			case H264StreamType:
 				p.transportPacket.Pmt.Videoh264 = append(p.transportPacket.Pmt.Videoh264, pid)
+			case HEVCStreamType:
+				p.transportPacket.Pmt.VideoHEVC = append(p.transportPacket.Pmt.VideoHEVC, pid)
+			case MPEG2VideoStreamType:
+				p.transportPacket.Pmt.VideoMPEG2 = append(p.transportPacket.Pmt.VideoMPEG2, pid)
 			case ADTSStreamType:
 				p.transportPacket.Pmt.AudioADTS = append(p.transportPacket.Pmt.AudioADTS, pid)
+			case AC3StreamType:
+				p.transportPacket.Pmt.AudioAC3 = append(p.transportPacket.Pmt.AudioAC3, pid)
+			case EAC3StreamType, EAC3AltStreamType:
+				p.transportPacket.Pmt.AudioEAC3 = append(p.transportPacket.Pmt.AudioEAC3, pid)
+			case SCTE35StreamType:
+				p.transportPacket.Pmt.SCTE35 = append(p.transportPacket.Pmt.SCTE35, pid)
+			case MetadataStreamType:
+				p.transportPacket.Pmt.Metadata = append(p.transportPacket.Pmt.Metadata, pid)
 			default:
 				p.transportPacket.Pmt.Other = append(p.transportPacket.Pmt.Other, pid)
 			}
@@ -382,6 +543,64 @@ func (p *TsPacket) Parse(pmtPID int) bool {
 	return true
 }
 
+// getDolbyStreamType Returns the AC-3 / E-AC-3 stream type signaled by the ES descriptors of a private PES (DVB, ATSC or registration descriptors), PrivatePESStreamType if there is none
+func getDolbyStreamType(esInfo []byte) uint8 {
+	streamType := PrivatePESStreamType
+	for i := 0; i+2 <= len(esInfo); i = i + 2 + int(esInfo[i+1]) {
+		tag := esInfo[i]
+		data := esInfo[i+2:]
+		if len(data) > int(esInfo[i+1]) {
+			data = data[:esInfo[i+1]]
+		}
+
+		switch {
+		case tag == ac3DescriptorTag || tag == ac3ATSCDescriptorTag || (tag == registrationDescriptorTag && string(data) == "AC-3"):
+			if streamType == PrivatePESStreamType {
+				streamType = AC3StreamType
+			}
+		case tag == eac3DescriptorTag || tag == eac3ATSCDescriptorTag || (tag == registrationDescriptorTag && string(data) == "EAC3"):
+			// E-AC-3 wins, the AC-3 descriptors can be there for compatibility
+			streamType = EAC3StreamType
+		}
+	}
+
+	return streamType
+}
+
+// TimeDiffS Returns toS - fromS (PCR or PTS in seconds) taking into account the 33 bits wraparound of maxS, the shortest distance is used
+func TimeDiffS(fromS float64, toS float64, maxS float64) float64 {
+	diffS := toS - fromS
+	if diffS > maxS/2 {
+		diffS = diffS - maxS
+	} else if diffS < -maxS/2 {
+		diffS = diffS + maxS
+	}
+
+	return diffS
+}
+
+// SecondsToTicks Returns the time in 90KHz ticks (the closest tick)
+func SecondsToTicks(timeS float64) int64 {
+	return int64(math.Round(timeS * ClockHz))
+}
+
+// TicksToSeconds Returns the 90KHz ticks in seconds
+func TicksToSeconds(ticks int64) float64 {
+	return float64(ticks) / ClockHz
+}
+
+// TimeDiffTicks Returns the modular distance in ticks from fromTicks to toTicks (negative if toTicks is before), maxTicks is the wraparound value
+func TimeDiffTicks(fromTicks int64, toTicks int64, maxTicks int64) int64 {
+	diffTicks := toTicks - fromTicks
+	if diffTicks > maxTicks/2 {
+		diffTicks = diffTicks - maxTicks
+	} else if diffTicks < -maxTicks/2 {
+		diffTicks = diffTicks + maxTicks
+	}
+
+	return diffTicks
+}
+
 func calculatePCRS(pcrBase uint64, pcrExtension uint16) (PCRs float64) {
 	PCRs = -1
 
@@ -418,6 +637,17 @@ func (p *TsPacket) GetPATdata() (PMTPID int) {
 	return
 }
 
+// GetPATPrograms Gets all the programs of the PAT if present
+func (p *TsPacket) GetPATPrograms() (programs []PATProgram) {
+	if !p.transportPacket.valid || !p.transportPacket.Pat.valid {
+		return
+	}
+
+	programs = p.transportPacket.Pat.Programs
+
+	return
+}
+
 // GetPMTdata Gets the PMT dta if present (video, audios, and other PIDs)
 func (p *TsPacket) GetPMTdata() (valid bool, Videoh264 []uint16, AudioADTS []uint16, Other []uint16) {
 	valid = false
@@ -433,6 +663,80 @@ func (p *TsPacket) GetPMTdata() (valid bool, Videoh264 []uint16, AudioADTS []uin
 	return
 }
 
+// GetPMTVersion Gets the PMT version_number, -1 if this is not a valid PMT
+func (p *TsPacket) GetPMTVersion() int {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return -1
+	}
+
+	return int(p.transportPacket.Pmt.Version)
+}
+
+// GetPMTPCRPID Gets the PCR PID of the PMT, -1 if this is not a valid PMT
+func (p *TsPacket) GetPMTPCRPID() int {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return -1
+	}
+
+	return int(p.transportPacket.Pmt.PCRPID)
+}
+
+// GetPMTHEVCPIDs Gets the HEVC video PIDs of the PMT if present
+func (p *TsPacket) GetPMTHEVCPIDs() (VideoHEVC []uint16) {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return
+	}
+
+	VideoHEVC = p.transportPacket.Pmt.VideoHEVC
+
+	return
+}
+
+// GetPMTMPEG2PIDs Gets the MPEG-2 video PIDs of the PMT if present
+func (p *TsPacket) GetPMTMPEG2PIDs() (VideoMPEG2 []uint16) {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return
+	}
+
+	VideoMPEG2 = p.transportPacket.Pmt.VideoMPEG2
+
+	return
+}
+
+// GetPMTDolbyPIDs Gets the AC-3 and E-AC-3 audio PIDs of the PMT if present
+func (p *TsPacket) GetPMTDolbyPIDs() (AudioAC3 []uint16, AudioEAC3 []uint16) {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return
+	}
+
+	AudioAC3 = p.transportPacket.Pmt.AudioAC3
+	AudioEAC3 = p.transportPacket.Pmt.AudioEAC3
+
+	return
+}
+
+// GetPMTSCTE35PIDs Gets the SCTE-35 PIDs of the PMT if present
+func (p *TsPacket) GetPMTSCTE35PIDs() (SCTE35 []uint16) {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return
+	}
+
+	SCTE35 = p.transportPacket.Pmt.SCTE35
+
+	return
+}
+
+// GetPMTMetadataPIDs Gets the timed metadata PIDs (Ex: ID3) of the PMT if present
+func (p *TsPacket) GetPMTMetadataPIDs() (Metadata []uint16) {
+	if !p.transportPacket.valid || !p.transportPacket.Pmt.valid {
+		return
+	}
+
+	Metadata = p.transportPacket.Pmt.Metadata
+
+	return
+}
+
 // GetPID Adds bytes to the packet
 func (p *TsPacket) GetPID() (pID int) {
 	pID = -1
@@ -473,3 +777,482 @@ func (p *TsPacket) IsRandomAccess(pID int) (isIDR bool) {
 
 	return
 }
+
+// IsHEVCRandomAccess Return true if a HEVC IRAP picture (BLA, IDR, CRA) starts in this packet, by the NAL unit type of the 1st VCL NAL unit of the PES (false if it is not in this packet)
+func (p *TsPacket) IsHEVCRandomAccess(pID int) bool {
+	isRandomAccess, _ := p.GetHEVCRandomAccess(pID)
+	return isRandomAccess
+}
+
+// GetHEVCRandomAccess Returns if a HEVC IRAP picture (BLA, IDR, CRA) starts in this packet, isKnown is false if the PES starts in this packet but its 1st VCL NAL unit does not (Ex: large parameter sets or SEI), it is in the next packets of the PID
+func (p *TsPacket) GetHEVCRandomAccess(pID int) (isRandomAccess bool, isKnown bool) {
+	if !p.transportPacket.valid || p.transportPacket.PID != uint16(pID) || !p.transportPacket.PayloadUnitStartIndicator {
+		return false, true
+	}
+
+	nalType, found := FindHEVCVCLType(p.getPESData())
+	if !found {
+		return false, false
+	}
+
+	return IsHEVCIRAPType(nalType), true
+}
+
+// FindHEVCVCLType Returns the NAL unit type of the 1st VCL NAL unit of the HEVC elementary stream data, found is false if there is none
+func FindHEVCVCLType(es []byte) (nalType uint8, found bool) {
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0x00 || es[i+1] != 0x00 || es[i+2] != 0x01 {
+			continue
+		}
+
+		nalType = (es[i+3] >> 1) & 0x3F
+		if nalType < hevcNALTypeVPS {
+			return nalType, true
+		}
+		i = i + 2
+	}
+
+	return 0, false
+}
+
+// IsHEVCIRAPType Returns true if the HEVC NAL unit type is an IRAP picture (BLA, IDR, CRA)
+func IsHEVCIRAPType(nalType uint8) bool {
+	return nalType >= hevcNALTypeBLAWLP && nalType <= hevcNALTypeCRA
+}
+
+// GetESData Returns the elementary stream data of the packet: after the PES header if a PES starts in it, the payload otherwise
+func (p *TsPacket) GetESData() []byte {
+	if !p.transportPacket.valid {
+		return nil
+	}
+	if p.transportPacket.PayloadUnitStartIndicator {
+		return p.getPESData()
+	}
+
+	return p.GetPayload()
+}
+
+// IsMPEG2RandomAccess Return true if a MPEG-2 video sequence header followed by an I picture starts in this packet. If the picture header is not in this packet the sequence header is enough
+func (p *TsPacket) IsMPEG2RandomAccess(pID int) bool {
+	if !p.transportPacket.valid || p.transportPacket.PID != uint16(pID) || !p.transportPacket.PayloadUnitStartIndicator {
+		return false
+	}
+
+	es := p.getPESData()
+	hasSequenceHeader := false
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0x00 || es[i+1] != 0x00 || es[i+2] != 0x01 {
+			continue
+		}
+
+		startCode := es[i+3]
+		if startCode == mpeg2SequenceHeaderCode {
+			hasSequenceHeader = true
+		} else if startCode == mpeg2PictureStartCode {
+			if i+5 >= len(es) {
+				break
+			}
+			pictureCodingType := (es[i+5] >> 3) & 0x07
+			return hasSequenceHeader && pictureCodingType == mpeg2PictureCodingTypeI
+		}
+		i = i + 2
+	}
+
+	return hasSequenceHeader
+}
+
+// IsDiscontinuity Returns true if the discontinuity_indicator is set (Ex: the source was switched, the timestamps jump)
+func (p *TsPacket) IsDiscontinuity() bool {
+	if !p.transportPacket.valid {
+		return false
+	}
+
+	return p.transportPacket.AdaptationField.DiscontinuityIndicator
+}
+
+// GetContinuityCounter Returns the continuity_counter, -1 if the packet is not valid
+func (p *TsPacket) GetContinuityCounter() int {
+	if !p.transportPacket.valid {
+		return -1
+	}
+
+	return int(p.transportPacket.ContinuityCounter)
+}
+
+// HasPayload Returns true if the packet carries payload (the continuity_counter only increments in those packets)
+func (p *TsPacket) HasPayload() bool {
+	if !p.transportPacket.valid {
+		return false
+	}
+
+	return p.transportPacket.AdaptationFieldControl == 1 || p.transportPacket.AdaptationFieldControl == 3
+}
+
+// getPayloadOffset Returns the position where the payload starts, -1 if there is no payload
+func (p *TsPacket) getPayloadOffset() int {
+	if p.transportPacket.AdaptationFieldControl == 0 || p.transportPacket.AdaptationFieldControl == 2 {
+		return -1
+	}
+
+	offset := 4
+	if p.transportPacket.AdaptationFieldControl == 3 {
+		offset = offset + 1 + int(p.buf[4])
+	}
+	if offset >= TsDefaultPacketSize {
+		return -1
+	}
+
+	return offset
+}
+
+// getPESData Returns the elementary stream data (after the PES header) of the PES that starts in this packet, nil if it does not start a PES
+func (p *TsPacket) getPESData() []byte {
+	offset := p.getPayloadOffset()
+	if offset < 0 || offset+9 > TsDefaultPacketSize {
+		return nil
+	}
+
+	pes := p.buf[offset:TsDefaultPacketSize]
+	if pes[0] != 0x00 || pes[1] != 0x00 || pes[2] != 0x01 {
+		return nil
+	}
+
+	dataOffset := 9 + int(pes[8])
+	if dataOffset >= len(pes) {
+		return nil
+	}
+
+	return pes[dataOffset:]
+}
+
+// GetPESPTS Returns the PTS in seconds of the PES that starts in this packet, -1 if it does not start a PES or it has no PTS
+func (p *TsPacket) GetPESPTS() (PTSs float64) {
+	PTSs = -1
+	if !p.transportPacket.valid || !p.transportPacket.PayloadUnitStartIndicator {
+		return
+	}
+
+	offset := p.getPayloadOffset()
+	if offset < 0 || offset+14 > TsDefaultPacketSize {
+		return
+	}
+
+	// PES start code prefix (0x000001), stream id, PES length, flags, flags (PTS DTS), header length
+	pes := p.buf[offset:]
+	if pes[0] != 0x00 || pes[1] != 0x00 || pes[2] != 0x01 {
+		return
+	}
+	if (pes[7] & 0x80) == 0 {
+		return
+	}
+
+	PTSs = float64(pesTimestamp(pes[9:14])) / 90000.0
+
+	return
+}
+
+// GetPESDTS Returns the DTS in seconds of the PES that starts in this packet (the PTS if it has no DTS), -1 if it does not start a PES or it has no PTS
+func (p *TsPacket) GetPESDTS() (DTSs float64) {
+	DTSs = p.GetPESPTS()
+	if DTSs < 0 {
+		return
+	}
+
+	offset := p.getPayloadOffset()
+	pes := p.buf[offset:]
+	if (pes[7]&0xC0) != 0xC0 || offset+19 > TsDefaultPacketSize {
+		return
+	}
+
+	DTSs = float64(pesTimestamp(pes[14:19])) / 90000.0
+
+	return
+}
+
+// pesTimestamp Returns the 33 bits timestamp (90KHz) of the 5 bytes PTS / DTS field
+func pesTimestamp(b []byte) uint64 {
+	return uint64(b[0]&0x0E)<<29 | uint64(b[1])<<22 | uint64(b[2]&0xFE)<<14 | uint64(b[3])<<7 | uint64(b[4])>>1
+}
+
+// IsPayloadUnitStart Returns true if a PES or a PSI section starts in this packet
+func (p *TsPacket) IsPayloadUnitStart() bool {
+	return p.transportPacket.valid && p.transportPacket.PayloadUnitStartIndicator
+}
+
+// GetPayload Returns the packet payload (after the adaptation field), nil if there is no payload
+func (p *TsPacket) GetPayload() []byte {
+	if !p.transportPacket.valid {
+		return nil
+	}
+
+	offset := p.getPayloadOffset()
+	if offset < 0 {
+		return nil
+	}
+
+	return p.buf[offset:TsDefaultPacketSize]
+}
+
+// getPSISection Returns the position of the PSI section that starts in this packet (table_id) and its end (after the CRC), -1 if the section does not start or it does not fit in the packet
+func (p *TsPacket) getPSISection() (start int, end int) {
+	start = -1
+	end = -1
+	if !p.transportPacket.valid || !p.transportPacket.PayloadUnitStartIndicator {
+		return
+	}
+
+	offset := p.getPayloadOffset()
+	if offset < 0 {
+		return
+	}
+
+	sectionStart := offset + 1 + int(p.buf[offset])
+	if sectionStart+3 > TsDefaultPacketSize {
+		return
+	}
+	sectionEnd := sectionStart + 3 + int(binary.BigEndian.Uint16(p.buf[sectionStart+1:])&0x0FFF)
+	if sectionEnd > TsDefaultPacketSize {
+		return
+	}
+
+	start = sectionStart
+	end = sectionEnd
+
+	return
+}
+
+// setPSISection Replaces the PSI section that starts at start, it updates the section length and the CRC, the rest of the packet is stuffed
+func (p *TsPacket) setPSISection(start int, section []byte) {
+	binary.BigEndian.PutUint16(section[1:], binary.BigEndian.Uint16(section[1:])&0xF000|uint16(len(section)+4-3))
+	section = append(section, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(section[len(section)-4:], crc32MPEG2(section[:len(section)-4]))
+
+	n := copy(p.buf[start:TsDefaultPacketSize], section)
+	for i := start + n; i < TsDefaultPacketSize; i++ {
+		p.buf[i] = 0xFF
+	}
+}
+
+// FilterPAT Rewrites the PAT of this packet so it only contains the program of the PMT PID (the parsed data is not modified), returns false if it can not be rewritten
+func (p *TsPacket) FilterPAT(pmtPID int) bool {
+	if p.GetPID() != int(PATPID) {
+		return false
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 12 {
+		return false
+	}
+
+	// 8 bytes header, programs of 4 bytes, 4 CRC
+	section := append([]byte{}, p.buf[start:start+8]...)
+	for i := start + 8; i+4 <= end-4; i = i + 4 {
+		programNumber := binary.BigEndian.Uint16(p.buf[i:])
+		if programNumber != 0 && int(binary.BigEndian.Uint16(p.buf[i+2:])&0x1FFF) == pmtPID {
+			section = append(section, p.buf[i:i+4]...)
+		}
+	}
+	p.setPSISection(start, section)
+
+	return true
+}
+
+// FilterPMT Rewrites the PMT of this packet so it only contains the ES of the PIDs (the parsed data is not modified), returns false if it can not be rewritten
+func (p *TsPacket) FilterPMT(pIDs map[int]bool) bool {
+	if !p.transportPacket.Pmt.valid {
+		return false
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 16 {
+		return false
+	}
+
+	// 12 bytes header, program descriptors, ES (5 bytes + descriptors), 4 CRC
+	esStart := start + 12 + int(binary.BigEndian.Uint16(p.buf[start+10:])&0x0FFF)
+	if esStart > end-4 {
+		return false
+	}
+	section := append([]byte{}, p.buf[start:esStart]...)
+	for i := esStart; i+5 <= end-4; {
+		esEnd := i + 5 + int(binary.BigEndian.Uint16(p.buf[i+3:])&0x0FFF)
+		if esEnd > end-4 {
+			return false
+		}
+		if pIDs[int(binary.BigEndian.Uint16(p.buf[i+1:])&0x1FFF)] {
+			section = append(section, p.buf[i:esEnd]...)
+		}
+		i = esEnd
+	}
+	p.setPSISection(start, section)
+
+	return true
+}
+
+// PSIStream Elementary stream of a PMT
+type PSIStream struct {
+	StreamType  uint8
+	PID         uint16
+	Descriptors []byte
+}
+
+// GetPMTStreams Gets the PCR PID and the ES (with their descriptors) of the PMT section in the packet data (so it reflects the rewrites), pcrPID is -1 if the PMT can not be read
+func (p *TsPacket) GetPMTStreams() (pcrPID int, streams []PSIStream) {
+	pcrPID = -1
+	if !p.transportPacket.Pmt.valid {
+		return
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 16 {
+		return
+	}
+
+	esStart := start + 12 + int(binary.BigEndian.Uint16(p.buf[start+10:])&0x0FFF)
+	for i := esStart; i+5 <= end-4; {
+		esEnd := i + 5 + int(binary.BigEndian.Uint16(p.buf[i+3:])&0x0FFF)
+		if esEnd > end-4 {
+			return
+		}
+		streams = append(streams, PSIStream{p.buf[i], binary.BigEndian.Uint16(p.buf[i+1:]) & 0x1FFF, append([]byte{}, p.buf[i+5:esEnd]...)})
+		i = esEnd
+	}
+	pcrPID = int(binary.BigEndian.Uint16(p.buf[start+8:]) & 0x1FFF)
+
+	return
+}
+
+// SetPMTStreams Rewrites the ES (with their descriptors) of the PMT of this packet, the program data is kept (the parsed data is not modified), returns false if it can not be rewritten or they do not fit in the packet
+func (p *TsPacket) SetPMTStreams(streams []PSIStream) bool {
+	if !p.transportPacket.Pmt.valid {
+		return false
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 16 {
+		return false
+	}
+
+	esStart := start + 12 + int(binary.BigEndian.Uint16(p.buf[start+10:])&0x0FFF)
+	if esStart > end-4 {
+		return false
+	}
+	section := append([]byte{}, p.buf[start:esStart]...)
+	for _, es := range streams {
+		section = append(section, es.StreamType, 0xE0|byte(es.PID>>8)&0x1F, byte(es.PID), 0xF0|byte(len(es.Descriptors)>>8)&0x0F, byte(len(es.Descriptors)))
+		section = append(section, es.Descriptors...)
+	}
+	if start+len(section)+4 > TsDefaultPacketSize {
+		return false
+	}
+	p.setPSISection(start, section)
+
+	return true
+}
+
+// NewPATPacket Creates a PAT packet with a single program (section version 0)
+func NewPATPacket(programNumber int, pmtPID int) TsPacket {
+	section := []byte{0x00, 0xB0, 0x00, 0x00, 0x01, 0xC1, 0x00, 0x00, byte(programNumber >> 8), byte(programNumber), 0xE0 | byte(pmtPID>>8)&0x1F, byte(pmtPID)}
+
+	return newPSIPacket(int(PATPID), section, -1)
+}
+
+// NewPMTPacket Creates a PMT packet of the program with the ES (no program descriptors), it returns false if the ES do not fit in a packet
+func NewPMTPacket(pmtPID int, programNumber int, version int, pcrPID int, streams []PSIStream) (TsPacket, bool) {
+	section := []byte{0x02, 0xB0, 0x00, byte(programNumber >> 8), byte(programNumber), 0xC1 | byte(version&0x1F)<<1, 0x00, 0x00, 0xE0 | byte(pcrPID>>8)&0x1F, byte(pcrPID), 0xF0, 0x00}
+	for _, es := range streams {
+		section = append(section, es.StreamType, 0xE0|byte(es.PID>>8)&0x1F, byte(es.PID), 0xF0|byte(len(es.Descriptors)>>8)&0x0F, byte(len(es.Descriptors)))
+		section = append(section, es.Descriptors...)
+	}
+	if 5+len(section)+4 > TsDefaultPacketSize {
+		return New(TsDefaultPacketSize), false
+	}
+
+	return newPSIPacket(pmtPID, section, pmtPID), true
+}
+
+// newPSIPacket Creates a packet (CC 0) with the PSI section (without CRC) and parses it
+func newPSIPacket(pID int, section []byte, pmtPID int) TsPacket {
+	p := New(TsDefaultPacketSize)
+
+	buf := []byte{tsStartByte, 0x40 | byte(pID>>8)&0x1F, byte(pID), 0x10, 0x00}
+	buf = append(buf, make([]byte, TsDefaultPacketSize-len(buf))...)
+	p.AddData(buf)
+	p.setPSISection(5, section)
+	p.Parse(pmtPID)
+
+	return p
+}
+
+// SetPID Rewrites the PID of the packet (the parsed data is not modified)
+func (p *TsPacket) SetPID(pID int) {
+	binary.BigEndian.PutUint16(p.buf[1:], binary.BigEndian.Uint16(p.buf[1:])&0xE000|uint16(pID)&0x1FFF)
+}
+
+// SetContinuityCounter Rewrites the continuity_counter of the packet (the parsed data is not modified)
+func (p *TsPacket) SetContinuityCounter(cc int) {
+	p.buf[3] = p.buf[3]&0xF0 | byte(cc)&0x0F
+}
+
+// setSectionPID Rewrites the 13 bits PID at the position of the section (the 3 reserved bits are kept)
+func setSectionPID(section []byte, pos int, pID int) {
+	binary.BigEndian.PutUint16(section[pos:], binary.BigEndian.Uint16(section[pos:])&0xE000|uint16(pID)&0x1FFF)
+}
+
+// RemapPAT Rewrites the PAT of this packet so the program of the PMT PID references the new PMT PID (the parsed data is not modified), returns false if it can not be rewritten
+func (p *TsPacket) RemapPAT(pmtPID int, newPMTPID int) bool {
+	if p.GetPID() != int(PATPID) {
+		return false
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 12 {
+		return false
+	}
+
+	section := append([]byte{}, p.buf[start:end-4]...)
+	for i := 8; i+4 <= len(section); i = i + 4 {
+		if binary.BigEndian.Uint16(section[i:]) != 0 && int(binary.BigEndian.Uint16(section[i+2:])&0x1FFF) == pmtPID {
+			setSectionPID(section, i+2, newPMTPID)
+		}
+	}
+	p.setPSISection(start, section)
+
+	return true
+}
+
+// RemapPMT Rewrites the PMT of this packet so the ES and the PCR PIDs use the new PIDs (old PID: new PID, the parsed data is not modified), returns false if it can not be rewritten
+func (p *TsPacket) RemapPMT(pIDs map[int]int) bool {
+	if !p.transportPacket.Pmt.valid {
+		return false
+	}
+	start, end := p.getPSISection()
+	if start < 0 || end-start < 16 {
+		return false
+	}
+
+	section := append([]byte{}, p.buf[start:end-4]...)
+	if newPID, found := pIDs[int(binary.BigEndian.Uint16(section[8:])&0x1FFF)]; found {
+		setSectionPID(section, 8, newPID)
+	}
+	for i := 12 + int(binary.BigEndian.Uint16(section[10:])&0x0FFF); i+5 <= len(section); i = i + 5 + int(binary.BigEndian.Uint16(section[i+3:])&0x0FFF) {
+		if newPID, found := pIDs[int(binary.BigEndian.Uint16(section[i+1:])&0x1FFF)]; found {
+			setSectionPID(section, i+1, newPID)
+		}
+	}
+	p.setPSISection(start, section)
+
+	return true
+}
+
+// crc32MPEG2 Calculates the CRC used by the PSI sections (CRC-32/MPEG-2)
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc ^ uint32(b)<<24
+		for n := 0; n < 8; n++ {
+			if crc&0x80000000 > 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc = crc << 1
+			}
+		}
+	}
+
+	return crc
+}
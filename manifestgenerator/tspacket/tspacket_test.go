@@ -2,6 +2,7 @@ package tspacket
 
 import (
 	"encoding/hex"
+	"math"
 	"testing"
 )
 
@@ -64,3 +65,293 @@ func TestTSPacketPIDIDRPCR(t *testing.T) {
 		t.Errorf("RandomAccess is not correct, got = %t, want %t", isRandomAccess, xpectedisRandomAccess)
 	}
 }
+
+func TestTSPacketPESPTS(t *testing.T) {
+	tsPckt := New(TsDefaultPacketSize)
+
+	// Generate TS packet (video PES start with PTS and DTS)
+	buf := parseHexString("47410030075000007B0C7E00000001E0000080C00A310007EFD1110007D8610000000109F000000001674D4029965280A00B74A40404050000030001000003003C840000000168E90935200000000165888040006B6FFEF7D4B7CCB2D9A9BED82EA3DE8A78997D0DD494066F86757E1D7F4A3FA82C376EE9C0FE81F4F746A24E305C9A3E0DD5859DE0D287E8BEF70EA0CCF9008A25F52EF9A9CFA59B78AA5D34CB88001425FE7AB544EF7171FC56F27719F9C72D13FA7B0F5F3211A6")
+	tsPckt.AddData(buf)
+	tsPckt.Parse(-1)
+
+	xpectedPTSs := 129000.0 / 90000.0
+	if ptsS := tsPckt.GetPESPTS(); ptsS != xpectedPTSs {
+		t.Errorf("PTS is not correct, got = %f, want %f", ptsS, xpectedPTSs)
+	}
+	xpectedDTSs := 126000.0 / 90000.0
+	if dtsS := tsPckt.GetPESDTS(); dtsS != xpectedDTSs {
+		t.Errorf("DTS is not correct, got = %f, want %f", dtsS, xpectedDTSs)
+	}
+
+	// Generate TS packet (PSI, no PES)
+	tsPckt.Reset()
+	buf = parseHexString("474011100042F0250001C10000FF01FF0001FC80144812010646466D70656709536572766963653031777C43CAFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
+	tsPckt.AddData(buf)
+	tsPckt.Parse(-1)
+
+	xpectedPTSs = -1.0
+	if ptsS := tsPckt.GetPESPTS(); ptsS != xpectedPTSs {
+		t.Errorf("PTS is not correct, got = %f, want %f", ptsS, xpectedPTSs)
+	}
+	if dtsS := tsPckt.GetPESDTS(); dtsS != xpectedPTSs {
+		t.Errorf("DTS is not correct, got = %f, want %f", dtsS, xpectedPTSs)
+	}
+}
+
+func TestTSPacketPATPrograms(t *testing.T) {
+	tsPckt := New(TsDefaultPacketSize)
+
+	// Generate TS packet (PAT with NIT and 2 programs)
+	buf := parseHexString("4740001000" + "00B0150001C10000" + "0000E010" + "0001F000" + "0002F200" + "00000000")
+	for len(buf) < TsDefaultPacketSize {
+		buf = append(buf, 0xFF)
+	}
+	tsPckt.AddData(buf)
+	tsPckt.Parse(-1)
+
+	xpectedPrograms := []PATProgram{{1, 0x1000}, {2, 0x1200}}
+	programs := tsPckt.GetPATPrograms()
+	if len(programs) != len(xpectedPrograms) || programs[0] != xpectedPrograms[0] || programs[1] != xpectedPrograms[1] {
+		t.Errorf("PAT programs are not correct, got = %v, want %v", programs, xpectedPrograms)
+	}
+
+	xpectedPMTPID := 0x1000
+	if pmtPID := tsPckt.GetPATdata(); pmtPID != xpectedPMTPID {
+		t.Errorf("PMT PID is not correct, got = %d, want %d", pmtPID, xpectedPMTPID)
+	}
+}
+
+func TestTimeDiffSWraparound(t *testing.T) {
+	// Values just below and above the 33 bits wraparound (2^33 / 90000 s)
+	belowWrapS := float64((1<<33)-90000) / 90000.0
+	aboveWrapS := float64(3*90000) / 90000.0
+
+	tests := []struct {
+		fromS       float64
+		toS         float64
+		xpectedDurS float64
+	}{
+		{10.0, 14.0, 4.0},
+		{14.0, 10.0, -4.0},
+		{belowWrapS, aboveWrapS, 4.0},
+		{aboveWrapS, belowWrapS, -4.0},
+	}
+
+	for _, test := range tests {
+		durS := TimeDiffS(test.fromS, test.toS, MaxPCRSValue)
+		if math.Abs(durS-test.xpectedDurS) > 0.000001 {
+			t.Errorf("Time diff from %f to %f is not correct, got = %f, want %f", test.fromS, test.toS, durS, test.xpectedDurS)
+		}
+	}
+}
+
+func TestTimeDiffTicksWraparound(t *testing.T) {
+	maxTicks := SecondsToTicks(MaxPCRSValue)
+	if maxTicks != 1<<33 {
+		t.Errorf("Max ticks is not correct, got = %d, want %d", maxTicks, int64(1<<33))
+	}
+
+	// 1 tick below the wraparound to 3001 ticks after it
+	if diffTicks := TimeDiffTicks((1<<33)-1, 3000, maxTicks); diffTicks != 3001 {
+		t.Errorf("Time diff is not correct, got = %d, want %d", diffTicks, 3001)
+	}
+	if diffTicks := TimeDiffTicks(3000, (1<<33)-1, maxTicks); diffTicks != -3001 {
+		t.Errorf("Time diff is not correct, got = %d, want %d", diffTicks, -3001)
+	}
+
+	// PCR with extension (27MHz) rounded to the closest tick
+	if ticks := SecondsToTicks(calculatePCRS(900000, 299)); ticks != 900001 {
+		t.Errorf("PCR ticks are not correct, got = %d, want %d", ticks, 900001)
+	}
+}
+
+func TestTSPacketHEVCRandomAccess(t *testing.T) {
+	// Video PES start (PID 256) with PTS, AUD and the NAL units of each case
+	nalUnits := map[string]bool{
+		"000000012601AF":                     true,  // IDR_W_RADL
+		"000000012801AF":                     true,  // IDR_N_LP
+		"000000012A01AF":                     true,  // CRA
+		"000000010201AF":                     false, // TRAIL_R
+		"00000001400C01":                     false, // VPS, VCL NAL unit in the next packet
+		"000000014E010501FF80000000010201AF": false, // SEI, TRAIL_R
+	}
+
+	for nal, xpectedIsRandomAccess := range nalUnits {
+		pckt := parseHexString("47410010" + "000001E000008080052100010001" + "00000001460150" + nal)
+		for len(pckt) < TsDefaultPacketSize {
+			pckt = append(pckt, 0xFF)
+		}
+
+		tsPckt := New(TsDefaultPacketSize)
+		tsPckt.AddData(pckt)
+		tsPckt.Parse(-1)
+
+		if isRandomAccess := tsPckt.IsHEVCRandomAccess(256); isRandomAccess != xpectedIsRandomAccess {
+			t.Errorf("HEVC RandomAccess is not correct for %s, got = %t, want %t", nal, isRandomAccess, xpectedIsRandomAccess)
+		}
+		if _, isKnown := tsPckt.GetHEVCRandomAccess(256); isKnown != (nal != "00000001400C01") {
+			t.Errorf("HEVC RandomAccess known is not correct for %s, got = %t, want %t", nal, isKnown, !isKnown)
+		}
+	}
+
+	// 1st VCL NAL unit (CRA) after the VPS in the next packet of the PID
+	nalType, found := FindHEVCVCLType(parseHexString("00000001400C01" + "FFFF" + "000000012A01AF"))
+	if !found || !IsHEVCIRAPType(nalType) {
+		t.Errorf("HEVC VCL NAL unit type is not correct, got = %d (found %t), want %d", nalType, found, hevcNALTypeCRA)
+	}
+}
+
+func TestTSPacketMPEG2RandomAccess(t *testing.T) {
+	// Video PES start (PID 256) with PTS and the start codes of each case
+	startCodes := map[string]bool{
+		"000001B32D00F013FFFFE018" + "000001B800080000" + "00000100000FFFF8": true, // Sequence header, GOP, I picture
+		"000001B32D00F013FFFFE018" + "00000100000FFFF8":                      true, // Sequence header, I picture
+		"00000100000FFFF8": false, // I picture without sequence header
+		"000001B32D00F013FFFFE018" + "000001000017FFF8": false, // Sequence header, P picture
+		"000001B32D00F013FFFFE018" + "000001B5":         true,  // Sequence header, picture in the next packet
+	}
+
+	for startCode, xpectedIsRandomAccess := range startCodes {
+		pckt := parseHexString("47410010" + "000001E000008080052100010001" + startCode)
+		for len(pckt) < TsDefaultPacketSize {
+			pckt = append(pckt, 0xFF)
+		}
+
+		tsPckt := New(TsDefaultPacketSize)
+		tsPckt.AddData(pckt)
+		tsPckt.Parse(-1)
+
+		if isRandomAccess := tsPckt.IsMPEG2RandomAccess(256); isRandomAccess != xpectedIsRandomAccess {
+			t.Errorf("MPEG-2 RandomAccess is not correct for %s, got = %t, want %t", startCode, isRandomAccess, xpectedIsRandomAccess)
+		}
+	}
+}
+
+func TestTSPacketPMTDolbyAudio(t *testing.T) {
+	tsPckt := New(TsDefaultPacketSize)
+
+	// Generate TS packet (PMT with h264, AC-3 by stream type and DVB descriptor, E-AC-3 by registration descriptor and stream type, and private data)
+	buf := parseHexString("4750001000" + "02B0340001C10000E100F000" + "1BE100F000" + "81E101F000" + "06E102F0036A0100" + "06E103F006050445414333" + "87E104F000" + "06E105F000" + "00000000")
+	for len(buf) < TsDefaultPacketSize {
+		buf = append(buf, 0xFF)
+	}
+	tsPckt.AddData(buf)
+	tsPckt.Parse(0x1000)
+
+	AudioAC3, AudioEAC3 := tsPckt.GetPMTDolbyPIDs()
+	if len(AudioAC3) != 2 || AudioAC3[0] != 0x101 || AudioAC3[1] != 0x102 {
+		t.Errorf("AC-3 PIDs are not correct, got = %v, want %v", AudioAC3, []uint16{0x101, 0x102})
+	}
+	if len(AudioEAC3) != 2 || AudioEAC3[0] != 0x103 || AudioEAC3[1] != 0x104 {
+		t.Errorf("E-AC-3 PIDs are not correct, got = %v, want %v", AudioEAC3, []uint16{0x103, 0x104})
+	}
+
+	_, Videoh264, AudioADTS, Other := tsPckt.GetPMTdata()
+	if len(Videoh264) != 1 || len(AudioADTS) != 0 || len(Other) != 1 || Other[0] != 0x105 {
+		t.Errorf("PMT PIDs are not correct, got = %v / %v / %v", Videoh264, AudioADTS, Other)
+	}
+}
+
+// psiPacket Returns a PSI packet (stuffed) from the hex header + section
+func psiPacket(h string) []byte {
+	buf := parseHexString(h)
+	for len(buf) < TsDefaultPacketSize {
+		buf = append(buf, 0xFF)
+	}
+	return buf
+}
+
+func TestTSPacketFilterPSI(t *testing.T) {
+	// PAT with 2 programs, the rewritten one only has the 1st (same as the fixture PAT)
+	tsPckt := New(TsDefaultPacketSize)
+	tsPckt.AddData(psiPacket("4740001000" + "00B0110001C100000001F0000002F010" + "6852BC8A"))
+	tsPckt.Parse(-1)
+
+	if !tsPckt.FilterPAT(0x1000) {
+		t.Fatal("Error filtering PAT")
+	}
+	xpectedPAT := psiPacket("4740001000" + "00B00D0001C100000001F000" + "2AB104B2")
+	if buf := tsPckt.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPAT) {
+		t.Errorf("PAT is not correct, got = %x, want %x", buf, xpectedPAT)
+	}
+
+	// PMT with h264, AAC and private data, the rewritten one does not have the private data
+	tsPckt = New(TsDefaultPacketSize)
+	tsPckt.AddData(psiPacket("4750001000" + "02B01C0001C10000E100F0001BE100F0000FE101F00006E102F000" + "9C840842"))
+	tsPckt.Parse(0x1000)
+
+	if pcrPID := tsPckt.GetPMTPCRPID(); pcrPID != 0x100 {
+		t.Errorf("PCR PID is not correct, got = %d, want %d", pcrPID, 0x100)
+	}
+	if !tsPckt.FilterPMT(map[int]bool{0x100: true, 0x101: true}) {
+		t.Fatal("Error filtering PMT")
+	}
+	xpectedPMT := psiPacket("4750001000" + "02B0170001C10000E100F0001BE100F0000FE101F000" + "2F44B99B")
+	if buf := tsPckt.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPMT) {
+		t.Errorf("PMT is not correct, got = %x, want %x", buf, xpectedPMT)
+	}
+}
+
+func TestTSPacketNewPSI(t *testing.T) {
+	// Same as the fixture PAT and the filtered PMT
+	pat := NewPATPacket(1, 0x1000)
+	xpectedPAT := psiPacket("4740001000" + "00B00D0001C100000001F000" + "2AB104B2")
+	if buf := pat.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPAT) {
+		t.Errorf("PAT is not correct, got = %x, want %x", buf, xpectedPAT)
+	}
+	if pmtPID := pat.GetPATdata(); pmtPID != 0x1000 {
+		t.Errorf("PMT PID is not correct, got = %d, want %d", pmtPID, 0x1000)
+	}
+
+	streams := []PSIStream{{H264StreamType, 0x100, []byte{}}, {ADTSStreamType, 0x101, []byte{}}}
+	pmt, ok := NewPMTPacket(0x1000, 1, 0, 0x100, streams)
+	if !ok {
+		t.Fatal("Error creating PMT")
+	}
+	xpectedPMT := psiPacket("4750001000" + "02B0170001C10000E100F0001BE100F0000FE101F000" + "2F44B99B")
+	if buf := pmt.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPMT) {
+		t.Errorf("PMT is not correct, got = %x, want %x", buf, xpectedPMT)
+	}
+
+	// The ES descriptors are kept
+	tsPckt := New(TsDefaultPacketSize)
+	tsPckt.AddData(psiPacket("4750001000" + "02B0340001C10000E100F000" + "1BE100F000" + "81E101F000" + "06E102F0036A0100" + "06E103F006050445414333" + "87E104F000" + "06E105F000" + "00000000"))
+	tsPckt.Parse(0x1000)
+	pcrPID, streams := tsPckt.GetPMTStreams()
+	if pcrPID != 0x100 || len(streams) != 6 || streams[2].StreamType != PrivatePESStreamType || streams[2].PID != 0x102 || hex.EncodeToString(streams[2].Descriptors) != "6a0100" {
+		t.Errorf("PMT streams are not correct, got = %d / %v", pcrPID, streams)
+	}
+	pmt, _ = NewPMTPacket(0x1000, 1, 0, pcrPID, streams[1:3])
+	AudioAC3, AudioEAC3 := pmt.GetPMTDolbyPIDs()
+	if len(AudioAC3) != 2 || len(AudioEAC3) != 0 {
+		t.Errorf("AC-3 PIDs of the new PMT are not correct, got = %v, want %v", AudioAC3, []uint16{0x101, 0x102})
+	}
+}
+
+func TestTSPacketRemapPSI(t *testing.T) {
+	tsPckt := New(TsDefaultPacketSize)
+	tsPckt.AddData(psiPacket("4740001000" + "00B00D0001C100000001F000" + "2AB104B2"))
+	tsPckt.Parse(-1)
+
+	if !tsPckt.RemapPAT(0x1000, 0x300) {
+		t.Fatal("Error remapping PAT")
+	}
+	xpectedPAT := psiPacket("4740001000" + "00B00D0001C100000001E300" + "480BC072")
+	if buf := tsPckt.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPAT) {
+		t.Errorf("PAT is not correct, got = %x, want %x", buf, xpectedPAT)
+	}
+
+	// The PCR PID is the video one, it is remapped too
+	tsPckt = New(TsDefaultPacketSize)
+	tsPckt.AddData(psiPacket("4750001000" + "02B0170001C10000E100F0001BE100F0000FE101F000" + "2F44B99B"))
+	tsPckt.Parse(0x1000)
+
+	if !tsPckt.RemapPMT(map[int]int{0x100: 0x200, 0x101: 0x201}) {
+		t.Fatal("Error remapping PMT")
+	}
+	tsPckt.SetPID(0x300)
+	xpectedPMT := psiPacket("4743001000" + "02B0170001C10000E200F0001BE200F0000FE201F000" + "95EBBBAB")
+	if buf := tsPckt.GetBuffer(); hex.EncodeToString(buf) != hex.EncodeToString(xpectedPMT) {
+		t.Errorf("PMT is not correct, got = %x, want %x", buf, xpectedPMT)
+	}
+}
@@ -0,0 +1,456 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PlaylistCacheControl Cache-Control of the playlists (they change all the time)
+	PlaylistCacheControl = "no-cache"
+
+	// SegmentCacheControl Cache-Control of the segments (they never change once completed)
+	SegmentCacheControl = "public, max-age=31536000"
+
+	// DefaultMaxSegments Default number of segments retained in memory
+	DefaultMaxSegments = 100
+
+	// DefaultBlockTimeout Default max time a blocking playlist reload (_HLS_msn) is held
+	DefaultBlockTimeout = 12 * time.Second
+
+	// MaxMSNAhead Max _HLS_msn ahead of the last segment of the playlist, the ones further are rejected (400)
+	MaxMSNAhead = 2
+)
+
+// PlaylistState Last media sequence number completed and last part of the next one (-1 none) of a playlist, used by the blocking reloads. Ended playlists (EXT-X-ENDLIST) are never held
+type PlaylistState struct {
+	MSN     int64
+	Part    int
+	IsEnded bool
+}
+
+// HTTPServer Built-in HTTP(S) server output class, it serves the manifests and chunks from memory (the growing chunks with chunked transfer)
+type HTTPServer struct {
+	Listener net.Listener
+	Server   *http.Server
+
+	Log         *logrus.Logger
+	LocalAddr   string
+	CertFile    string
+	KeyFile     string
+	MaxSegments int
+
+	store *objectStore
+}
+
+// objectStore Objects served, the segments in creation order (the oldest ones are removed). updated is closed (and replaced) every time a playlist changes
+type objectStore struct {
+	mutex    sync.Mutex
+	objects  map[string]*Object
+	segments []string
+	updated  chan bool
+
+	blockTimeout      time.Duration
+	corsOrigin        string
+	corsExposeHeaders string
+}
+
+// Object File served, a segment grows until it is closed
+type Object struct {
+	mutex       sync.Mutex
+	cond        *sync.Cond
+	data        []byte
+	isClosed    bool
+	isPlaylist  bool
+	contentType string
+	modTime     time.Time
+
+	// Playlists with a state support blocking reloads, and delta updates (_HLS_skip) if they have a delta
+	state     *PlaylistState
+	deltaData []byte
+}
+
+// New Creates an HTTP server output and starts listening, if certFile and keyFile are set it uses TLS. maxSegments <= 0 retains all the segments
+func New(log *logrus.Logger, bindHost string, localPort int, certFile string, keyFile string, maxSegments int) (HTTPServer, error) {
+	if log == nil {
+		log = logrus.New()
+		log.SetLevel(logrus.ErrorLevel)
+	}
+
+	h := HTTPServer{nil, nil, log, net.JoinHostPort(bindHost, strconv.Itoa(localPort)), certFile, keyFile, maxSegments, &objectStore{objects: map[string]*Object{}, updated: make(chan bool), blockTimeout: DefaultBlockTimeout}}
+
+	if (certFile == "") != (keyFile == "") {
+		return h, errors.New("both TLS cert and key are needed for the HTTPS server")
+	}
+
+	ln, err := net.Listen("tcp", h.LocalAddr)
+	if err != nil {
+		return h, fmt.Errorf("listening HTTP server on %s: %w", h.LocalAddr, err)
+	}
+	h.Listener = ln
+	h.Server = &http.Server{Handler: h}
+
+	go func() {
+		var err error
+		if h.IsTLS() {
+			err = h.Server.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = h.Server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			h.Log.Error("Error serving HTTP server on ", h.LocalAddr, ". Err: ", err)
+		}
+	}()
+
+	return h, nil
+}
+
+// IsTLS Returns true if it is served over HTTPS
+func (h HTTPServer) IsTLS() bool {
+	return h.CertFile != "" && h.KeyFile != ""
+}
+
+// objectPath Returns the URL path of the file
+func objectPath(fileName string) string {
+	return path.Clean("/" + fileName)
+}
+
+// contentType Returns the Content-Type of the file
+func contentType(fileName string) string {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".ts":
+		return "video/MP2T"
+	case ".vtt":
+		return "text/vtt"
+	case ".mp4", ".m4s":
+		return "video/mp4"
+	}
+	return "application/octet-stream"
+}
+
+func newObject(fileName string, isPlaylist bool) *Object {
+	o := &Object{data: []byte{}, isPlaylist: isPlaylist, contentType: contentType(fileName), modTime: time.Now()}
+	o.cond = sync.NewCond(&o.mutex)
+
+	return o
+}
+
+// SetBlockingReload Sets the max time a blocking playlist reload is held, after it the response is 503
+func (h *HTTPServer) SetBlockingReload(timeout time.Duration) {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+
+	h.store.blockTimeout = timeout
+}
+
+// SetCORS Sets the Access-Control-Allow-Origin (empty no CORS headers) and Access-Control-Expose-Headers of the responses
+func (h *HTTPServer) SetCORS(origin string, exposeHeaders string) {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+
+	h.store.corsOrigin = origin
+	h.store.corsExposeHeaders = exposeHeaders
+}
+
+// Put Sets the whole data of a manifest (it replaces the previous one)
+func (h *HTTPServer) Put(fileName string, data []byte) {
+	h.put(fileName, data, nil, nil)
+}
+
+// PutPlaylist Sets the whole data of a media playlist with its state (blocking reloads) and its delta update (nil none)
+func (h *HTTPServer) PutPlaylist(fileName string, data []byte, deltaData []byte, state PlaylistState) {
+	h.put(fileName, data, deltaData, &state)
+}
+
+func (h *HTTPServer) put(fileName string, data []byte, deltaData []byte, state *PlaylistState) {
+	o := newObject(fileName, true)
+	o.data = append(o.data, data...)
+	o.isClosed = true
+	o.state = state
+	if deltaData != nil {
+		o.deltaData = append([]byte{}, deltaData...)
+	}
+
+	h.store.mutex.Lock()
+	h.store.objects[objectPath(fileName)] = o
+	close(h.store.updated)
+	h.store.updated = make(chan bool)
+	h.store.mutex.Unlock()
+}
+
+// Create Creates a growing segment, it is served with chunked transfer until it is closed. The oldest completed segments over MaxSegments are removed
+func (h *HTTPServer) Create(fileName string) *Object {
+	o := newObject(fileName, false)
+	objPath := objectPath(fileName)
+
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+
+	if _, found := h.store.objects[objPath]; !found {
+		h.store.segments = append(h.store.segments, objPath)
+	}
+	h.store.objects[objPath] = o
+
+	for h.MaxSegments > 0 && len(h.store.segments) > h.MaxSegments {
+		oldest := h.store.objects[h.store.segments[0]]
+		if oldest != nil && !oldest.IsClosed() {
+			break
+		}
+		h.Log.Debug("Removing segment ", h.store.segments[0], " from the HTTP server")
+		delete(h.store.objects, h.store.segments[0])
+		h.store.segments = h.store.segments[1:]
+	}
+
+	return o
+}
+
+// get Returns the object of the URL path, nil if not found
+func (h *HTTPServer) get(urlPath string) *Object {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+
+	return h.store.objects[path.Clean(urlPath)]
+}
+
+// Write Adds data to the segment
+func (o *Object) Write(buf []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.isClosed {
+		return 0, errors.New("segment already closed")
+	}
+	o.data = append(o.data, buf...)
+	o.cond.Broadcast()
+
+	return len(buf), nil
+}
+
+// Close Completes the segment
+func (o *Object) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.isClosed = true
+	o.modTime = time.Now()
+	o.cond.Broadcast()
+
+	return nil
+}
+
+// IsClosed Returns true if the segment is completed
+func (o *Object) IsClosed() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.isClosed
+}
+
+// ServeHTTP Serves the manifests and chunks (implements http.Handler), the completed ones support range requests. The playlist requests with _HLS_msn (and _HLS_part) are held until that segment (or part) is available
+func (h HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.store.mutex.Lock()
+	corsOrigin, corsExposeHeaders, blockTimeout := h.store.corsOrigin, h.store.corsExposeHeaders, h.store.blockTimeout
+	h.store.mutex.Unlock()
+
+	if corsOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", corsOrigin)
+		if corsExposeHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+		if r.Method == http.MethodOptions {
+			// Preflight
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Range")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET or HEAD allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	o := h.get(r.URL.Path)
+	if o == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if o.state != nil {
+		msn, part, err := blockingRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msn >= 0 {
+			var status int
+			o, status = h.waitPlaylist(r, msn, part, blockTimeout)
+			if o == nil {
+				if status > 0 {
+					http.Error(w, http.StatusText(status), status)
+				}
+				return
+			}
+		}
+		if r.URL.Query().Get("_HLS_skip") == "YES" && o.deltaData != nil {
+			w.Header().Set("Content-Type", o.contentType)
+			w.Header().Set("Cache-Control", PlaylistCacheControl)
+			http.ServeContent(w, r, path.Base(r.URL.Path), o.modTime, bytes.NewReader(o.deltaData))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", o.contentType)
+	if o.isPlaylist {
+		w.Header().Set("Cache-Control", PlaylistCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", SegmentCacheControl)
+	}
+
+	o.mutex.Lock()
+	if o.isClosed {
+		data, modTime := o.data, o.modTime
+		o.mutex.Unlock()
+
+		http.ServeContent(w, r, path.Base(r.URL.Path), modTime, bytes.NewReader(data))
+		return
+	}
+	o.mutex.Unlock()
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.serveGrowing(w, r, o)
+}
+
+// blockingRequest Returns the _HLS_msn and _HLS_part of the request (-1 not set), _HLS_part needs _HLS_msn
+func blockingRequest(r *http.Request) (msn int64, part int, err error) {
+	msn, part = -1, -1
+	query := r.URL.Query()
+
+	if value := query.Get("_HLS_msn"); value != "" {
+		msn, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || msn < 0 {
+			return -1, -1, fmt.Errorf("invalid _HLS_msn %s", value)
+		}
+	}
+	if value := query.Get("_HLS_part"); value != "" {
+		if msn < 0 {
+			return -1, -1, errors.New("_HLS_part without _HLS_msn")
+		}
+		part, err = strconv.Atoi(value)
+		if err != nil || part < 0 {
+			return -1, -1, fmt.Errorf("invalid _HLS_part %s", value)
+		}
+	}
+
+	return msn, part, nil
+}
+
+// isAvailable Returns true if the segment msn (or its part, part < 0 the whole segment) is in the playlist
+func (s *PlaylistState) isAvailable(msn int64, part int) bool {
+	if s.IsEnded || s.MSN >= msn {
+		return true
+	}
+	return part >= 0 && s.MSN == msn-1 && s.Part >= part
+}
+
+// waitPlaylist Holds the request until the playlist has the segment msn (or its part), returns nil and the status to send (0 none, the client is gone) if it is not available
+func (h HTTPServer) waitPlaylist(r *http.Request, msn int64, part int, timeout time.Duration) (*Object, int) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		h.store.mutex.Lock()
+		o, updated := h.store.objects[path.Clean(r.URL.Path)], h.store.updated
+		h.store.mutex.Unlock()
+
+		if o == nil {
+			return nil, http.StatusNotFound
+		}
+		if o.state.isAvailable(msn, part) {
+			return o, 0
+		}
+		if msn > o.state.MSN+MaxMSNAhead {
+			return nil, http.StatusBadRequest
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			h.Log.Debug("Timeout waiting for _HLS_msn ", msn, " _HLS_part ", part, " of ", r.URL.Path)
+			return nil, http.StatusServiceUnavailable
+		case <-r.Context().Done():
+			return nil, 0
+		}
+	}
+}
+
+// serveGrowing Sends the segment data as it arrives (chunked transfer) until it is closed or the client is gone
+func (h HTTPServer) serveGrowing(w http.ResponseWriter, r *http.Request, o *Object) {
+	flusher, _ := w.(http.Flusher)
+
+	// Wake up the wait if the client is gone
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			o.mutex.Lock()
+			o.cond.Broadcast()
+			o.mutex.Unlock()
+		case <-done:
+		}
+	}()
+
+	sent := 0
+	for {
+		o.mutex.Lock()
+		for sent >= len(o.data) && !o.isClosed && r.Context().Err() == nil {
+			o.cond.Wait()
+		}
+		buf, isClosed := o.data[sent:], o.isClosed
+		o.mutex.Unlock()
+
+		if r.Context().Err() != nil {
+			return
+		}
+		if len(buf) > 0 {
+			if _, err := w.Write(buf); err != nil {
+				h.Log.Debug("Error sending ", r.URL.Path, " to ", r.RemoteAddr, ". Err: ", err)
+				return
+			}
+			sent = sent + len(buf)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if isClosed && sent >= len(o.data) {
+			return
+		}
+	}
+}
+
+// Close Closes the HTTP server
+func (h *HTTPServer) Close() error {
+	if h.Server == nil {
+		return nil
+	}
+	return h.Server.Close()
+}
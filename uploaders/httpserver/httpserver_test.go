@@ -0,0 +1,254 @@
+package httpserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, maxSegments int) (HTTPServer, string) {
+	h, err := New(nil, "127.0.0.1", 0, "", "", maxSegments)
+	if err != nil {
+		t.Fatal("Error creating HTTP server. Err: ", err)
+	}
+
+	return h, "http://" + h.Listener.Addr().String()
+}
+
+func TestServePlaylist(t *testing.T) {
+	h, url := newTestServer(t, 0)
+	defer h.Close()
+
+	h.Put("results/chunklist.m3u8", []byte("#EXTM3U\n"))
+	h.Put("./results/chunklist.m3u8", []byte("#EXTM3U\n#EXT-X-VERSION:3\n"))
+
+	resp, err := http.Get(url + "/results/chunklist.m3u8")
+	if err != nil {
+		t.Fatal("Error getting playlist. Err: ", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "#EXTM3U\n#EXT-X-VERSION:3\n" {
+		t.Errorf("Playlist is not correct, got: %s, want: the last one.", string(body))
+	}
+	if got := resp.Header.Get("Cache-Control"); got != PlaylistCacheControl {
+		t.Errorf("Playlist Cache-Control is not correct, got: %s, want: %s.", got, PlaylistCacheControl)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Errorf("Playlist Content-Type is not correct, got: %s, want: application/vnd.apple.mpegurl.", got)
+	}
+
+	resp, _ = http.Get(url + "/results/none.m3u8")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Status code of a missing file is not correct, got: %d, want: %d.", resp.StatusCode, http.StatusNotFound)
+	}
+	resp, _ = http.Post(url+"/results/chunklist.m3u8", "text/plain", bytes.NewReader([]byte("ABC")))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Status code of a POST is not correct, got: %d, want: %d.", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServeGrowingSegment(t *testing.T) {
+	h, url := newTestServer(t, 0)
+	defer h.Close()
+
+	o := h.Create("results/chunk_00000.ts")
+	o.Write([]byte("ABC"))
+
+	type result struct {
+		body []byte
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/results/chunk_00000.ts")
+		if err != nil {
+			done <- result{nil, nil, err}
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		done <- result{body, resp, err}
+	}()
+
+	// The response continues until the segment is closed
+	time.Sleep(50 * time.Millisecond)
+	o.Write([]byte("DEF"))
+	select {
+	case <-done:
+		t.Fatal("Growing segment response finished before the segment is closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	o.Close()
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal("Error getting growing segment. Err: ", r.err)
+	}
+	if string(r.body) != "ABCDEF" {
+		t.Errorf("Growing segment data is not correct, got: %s, want: ABCDEF.", string(r.body))
+	}
+	if r.resp.ContentLength != -1 {
+		t.Errorf("Growing segment is not sent with chunked transfer, got Content-Length: %d.", r.resp.ContentLength)
+	}
+	if got := r.resp.Header.Get("Cache-Control"); got != SegmentCacheControl {
+		t.Errorf("Segment Cache-Control is not correct, got: %s, want: %s.", got, SegmentCacheControl)
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	h, url := newTestServer(t, 0)
+	defer h.Close()
+
+	o := h.Create("results/chunk_00000.ts")
+	o.Write([]byte("ABCDEFGHIJ"))
+	o.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/results/chunk_00000.ts", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error getting segment range. Err: ", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "CDE" {
+		t.Errorf("Segment range is not correct, got: %d %s, want: %d CDE.", resp.StatusCode, string(body), http.StatusPartialContent)
+	}
+}
+
+func TestMaxSegments(t *testing.T) {
+	h, url := newTestServer(t, 2)
+	defer h.Close()
+
+	for _, fileName := range []string{"chunk_00000.ts", "chunk_00001.ts", "chunk_00002.ts"} {
+		o := h.Create(fileName)
+		o.Write([]byte("ABC"))
+		o.Close()
+	}
+	// A growing segment is not removed, even if it is over the limit
+	h.Create("chunk_00003.ts")
+	h.Create("chunk_00004.ts")
+	h.Create("chunk_00005.ts")
+
+	xpected := map[string]int{"chunk_00000.ts": http.StatusNotFound, "chunk_00001.ts": http.StatusNotFound, "chunk_00002.ts": http.StatusNotFound, "chunk_00003.ts": http.StatusOK}
+	for fileName, xpectedStatus := range xpected {
+		req, _ := http.NewRequest(http.MethodHead, url+"/"+fileName, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Error getting segment. Err: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != xpectedStatus {
+			t.Errorf("Status code of %s is not correct, got: %d, want: %d.", fileName, resp.StatusCode, xpectedStatus)
+		}
+	}
+}
+
+func TestBlockingReload(t *testing.T) {
+	h, url := newTestServer(t, 0)
+	defer h.Close()
+	h.SetBlockingReload(200 * time.Millisecond)
+
+	h.PutPlaylist("chunklist.m3u8", []byte("MSN 5"), []byte("DELTA"), PlaylistState{5, 1, false})
+
+	type result struct {
+		status int
+		body   string
+	}
+	get := func(query string) chan result {
+		done := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(url + "/chunklist.m3u8" + query)
+			if err != nil {
+				done <- result{0, err.Error()}
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			done <- result{resp.StatusCode, string(body)}
+		}()
+		return done
+	}
+
+	// Available ones are not held
+	xpected := map[string]result{
+		"?_HLS_msn=5":                {http.StatusOK, "MSN 5"},
+		"?_HLS_msn=6&_HLS_part=1":    {http.StatusOK, "MSN 5"},
+		"?_HLS_msn=5&_HLS_skip=YES":  {http.StatusOK, "DELTA"},
+		"?_HLS_msn=8":                {http.StatusBadRequest, ""},
+		"?_HLS_part=1":               {http.StatusBadRequest, ""},
+		"?_HLS_msn=A":                {http.StatusBadRequest, ""},
+		"?_HLS_msn=6":                {http.StatusServiceUnavailable, ""},
+		"?_HLS_msn=6&_HLS_part=2":    {http.StatusServiceUnavailable, ""},
+		"?_HLS_msn=7&_HLS_part=0":    {http.StatusServiceUnavailable, ""},
+		"?_HLS_msn=5&_HLS_skip=NONE": {http.StatusOK, "MSN 5"},
+	}
+	for query, x := range xpected {
+		r := <-get(query)
+		if r.status != x.status || (x.body != "" && r.body != x.body) {
+			t.Errorf("Response of %s is not correct, got: %d %s, want: %d %s.", query, r.status, r.body, x.status, x.body)
+		}
+	}
+
+	// Held until the part is available
+	done := get("?_HLS_msn=6&_HLS_part=2")
+	time.Sleep(50 * time.Millisecond)
+	h.PutPlaylist("chunklist.m3u8", []byte("MSN 5 PART 1"), nil, PlaylistState{5, 1, false})
+	select {
+	case r := <-done:
+		t.Fatalf("Blocking reload finished before the part is available, got: %d %s.", r.status, r.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+	h.PutPlaylist("chunklist.m3u8", []byte("MSN 5 PART 2"), nil, PlaylistState{5, 2, false})
+	if r := <-done; r.status != http.StatusOK || r.body != "MSN 5 PART 2" {
+		t.Errorf("Blocking reload response is not correct, got: %d %s, want: %d MSN 5 PART 2.", r.status, r.body, http.StatusOK)
+	}
+
+	// An ended playlist is never held
+	done = get("?_HLS_msn=7")
+	h.PutPlaylist("chunklist.m3u8", []byte("ENDED"), nil, PlaylistState{6, -1, true})
+	if r := <-done; r.status != http.StatusOK || r.body != "ENDED" {
+		t.Errorf("Blocking reload response of an ended playlist is not correct, got: %d %s, want: %d ENDED.", r.status, r.body, http.StatusOK)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h, url := newTestServer(t, 0)
+	defer h.Close()
+
+	h.Put("chunklist.m3u8", []byte("#EXTM3U\n"))
+
+	resp, _ := http.Get(url + "/chunklist.m3u8")
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin is not correct, got: %s, want: none.", got)
+	}
+
+	h.SetCORS("*", "Content-Length, Date")
+	resp, _ = http.Get(url + "/chunklist.m3u8")
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin is not correct, got: %s, want: *.", got)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); got != "Content-Length, Date" {
+		t.Errorf("Access-Control-Expose-Headers is not correct, got: %s, want: Content-Length, Date.", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodOptions, url+"/chunklist.m3u8", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Error sending preflight. Err: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("Preflight response is not correct, got: %d %s, want: %d GET, HEAD, OPTIONS.", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Methods"), http.StatusNoContent)
+	}
+}
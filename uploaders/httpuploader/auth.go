@@ -0,0 +1,136 @@
+package httpuploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// errUnauthorized Upload rejected with 401, retried once with a new bearer token
+var errUnauthorized = errors.New("Unauthorized upload error")
+
+// Auth Authorization of the upload requests: bearer token or basic auth. With TokenURL the bearer token is requested again when a request gets 401
+type Auth struct {
+	BasicUser     string
+	BasicPassword string
+
+	// TokenURL, TokenClientID and TokenClientSecret Token endpoint (POST grant_type=client_credentials, the client credentials as basic auth if they are set). The response is a JSON with access_token (OAuth 2.0) or the token as plain text
+	TokenURL          string
+	TokenClientID     string
+	TokenClientSecret string
+
+	mutex       sync.Mutex
+	bearerToken string
+	refreshing  *tokenRefresh
+}
+
+// tokenRefresh Token request in progress, the concurrent refreshes wait for its result
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewAuth Creates the authorization, bearerToken is the initial one (empty with TokenURL it is requested after the 1st 401)
+func NewAuth(bearerToken string, basicUser string, basicPassword string, tokenURL string, tokenClientID string, tokenClientSecret string) *Auth {
+	return &Auth{basicUser, basicPassword, tokenURL, tokenClientID, tokenClientSecret, sync.Mutex{}, bearerToken, nil}
+}
+
+// token Returns the current bearer token ("" none), nil is no authorization
+func (a *Auth) token() string {
+	if a == nil {
+		return ""
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.bearerToken
+}
+
+// isRefreshable The bearer token can be requested again
+func (a *Auth) isRefreshable() bool {
+	return a != nil && a.TokenURL != ""
+}
+
+// set Sets the Authorization header of the request, the bearer token has priority over the basic auth
+func (a *Auth) set(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if a != nil && a.BasicUser != "" {
+		req.SetBasicAuth(a.BasicUser, a.BasicPassword)
+	}
+}
+
+// refresh Requests a new bearer token if the current one is still the rejected one, only one request at a time (the concurrent calls get its result)
+func (a *Auth) refresh(client *http.Client, rejectedToken string) (string, error) {
+	a.mutex.Lock()
+	if a.bearerToken != rejectedToken {
+		token := a.bearerToken
+		a.mutex.Unlock()
+		return token, nil
+	}
+	if r := a.refreshing; r != nil {
+		a.mutex.Unlock()
+		<-r.done
+		return r.token, r.err
+	}
+	r := &tokenRefresh{make(chan struct{}), "", nil}
+	a.refreshing = r
+	a.mutex.Unlock()
+
+	r.token, r.err = a.requestToken(client)
+
+	a.mutex.Lock()
+	if r.err == nil {
+		a.bearerToken = r.token
+	}
+	a.refreshing = nil
+	a.mutex.Unlock()
+	close(r.done)
+
+	return r.token, r.err
+}
+
+// requestToken Requests a bearer token to TokenURL
+func (a *Auth) requestToken(client *http.Client) (string, error) {
+	req, err := http.NewRequest("POST", a.TokenURL, strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.TokenClientID != "" {
+		req.SetBasicAuth(a.TokenClientID, a.TokenClientSecret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("token request HTTP Error: %d", resp.StatusCode)
+	}
+
+	token := strings.TrimSpace(string(body))
+	tokenResp := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if json.Unmarshal(body, &tokenResp) == nil {
+		token = tokenResp.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("token request: empty token")
+	}
+
+	return token, nil
+}
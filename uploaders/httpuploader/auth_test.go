@@ -0,0 +1,97 @@
+package httpuploader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploadTokenRefresh(t *testing.T) {
+	data := []byte("ABCDE")
+	uploads := 5
+	tokenRequests := int32(0)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		if user, password, _ := req.BasicAuth(); user != "id" || password != "secret" {
+			t.Errorf("Token request credentials are wrong, got: %s:%s, want: id:secret.", user, password)
+		}
+		// The concurrent uploads get 401 while the token is requested
+		time.Sleep(100 * time.Millisecond)
+		rw.Write([]byte(`{"access_token":"new","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	serverHandleTest := func(rw http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadAll(req.Body)
+		if req.Header.Get("Authorization") != "Bearer new" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The retry sends the data again
+		if !testBinary(buf, data) {
+			t.Errorf("Different data from original and uploaded file, got: %d (bytes), want: %d (bytes).", len(buf), len(data))
+		}
+		rw.Write([]byte(`OK`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(serverHandleTest))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 100)
+	up.Auth = NewAuth("expired", "", "", tokenServer.URL, "id", "secret")
+
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errUpload := up.UploadData(data, "test/fileData.ts", nil); errUpload != nil {
+				t.Error("Error uploading data. Err ", errUpload)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tokenRequests != 1 {
+		t.Errorf("Token requests are wrong, got: %d, want: %d.", tokenRequests, 1)
+	}
+
+	// Without token URL the 401 is not retried
+	upBasic := New(nil, false, u.Scheme, u.Host, 3, 100)
+	upBasic.Auth = NewAuth("", "user", "password", "", "", "")
+	if errUpload := upBasic.UploadData(data, "test/fileData.ts", nil); errUpload == nil {
+		t.Error("Upload rejected with 401 without error")
+	}
+}
+
+func TestUploadTokenRefreshFailure(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 1)
+	up.Auth = NewAuth("", "", "", tokenServer.URL, "", "")
+
+	if errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil); errUpload == nil {
+		t.Error("Upload with a failed token refresh without error")
+	}
+}
@@ -2,19 +2,49 @@ package httpuploader
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultRetryableStatuses HTTP statuses of the uploads that are retried by default (the network errors are always retried)
+var DefaultRetryableStatuses = []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// errIdleTimeout Cause of the chunked transfer uploads cancelled by ChunkedIdleTimeout
+var errIdleTimeout = errors.New("idle timeout")
+
+// retryableError Upload error that is retried (Ex: server busy), retryAfter is the delay of its Retry-After header (0 none)
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+// Error Returns the error of the upload (implements error)
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// maxBackoffDoublings Max doublings of the retry delay without cap (no overflow)
+const maxBackoffDoublings = 30
+
+// envVarRegexp ${NAME} references to environment variables in the header values
+var envVarRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
 // HTTPUploader HTTP uploader class class
 type HTTPUploader struct {
 	HTTPClient *http.Client
@@ -25,6 +55,47 @@ type HTTPUploader struct {
 	HTTPHost                string
 	MaxHTTPRetries          int
 	InitialHTTPRetryDelayMs int
+
+	// Chunked transfer uploads and the ones of the pool in progress
+	pendingUploads *sync.WaitGroup
+
+	// Headers Added to every request (chunks and manifests), the headers of each request override them (Ex: Content-Type)
+	Headers http.Header
+
+	// Auth Authorization of the requests, nil none
+	Auth *Auth
+
+	// MaxHTTPRetryDelayMs and MaxHTTPRetryTimeMs Cap of the exponential retry delay and max time retrying an upload (Ex: the live window duration), <= 0 none
+	MaxHTTPRetryDelayMs int
+	MaxHTTPRetryTimeMs  int
+
+	// Retries done (updated atomically)
+	retries *uint64
+
+	// RetryableStatuses HTTP statuses that are retried (DefaultRetryableStatuses), the other errors (Ex: 4xx) are permanent failures
+	RetryableStatuses []int
+
+	// Method and URLTemplate HTTP method (POST) and path of the uploads (nil DefaultURLTemplate)
+	Method      string
+	URLTemplate *URLTemplate
+
+	// target Target of the uploads (ForTarget), nil it is got from the destination
+	target *Target
+
+	// ChunkTimeout and ManifestTimeout Max time of each request of the uploads (not chunked transfer) of the manifests and of the other files, the timeouts are retried. <= 0 none
+	ChunkTimeout    time.Duration
+	ManifestTimeout time.Duration
+
+	// ChunkedIdleTimeout Max time of the chunked transfer uploads without writing data (also waiting the response after the last one), <= 0 none
+	ChunkedIdleTimeout time.Duration
+
+	// tlsConfig and transportOptions Config of the transport of HTTPClient
+	tlsConfig        *tls.Config
+	transportOptions TransportOptions
+
+	// concurrency and pool Uploads done at the same time by the Notify functions (SetConcurrency), nil pool they are done by the caller
+	concurrency int
+	pool        *uploadPool
 }
 
 // New Creates a chunk instance
@@ -34,22 +105,61 @@ func New(log *logrus.Logger, httpsInsecure bool, httpScheme string, httpHost str
 		log.SetLevel(logrus.ErrorLevel)
 	}
 
-	var tr = http.DefaultTransport
+	var tlsConfig *tls.Config
 	if (strings.Compare(httpScheme, "https") == 0) && (httpsInsecure) {
 		// Setup HTTPS client in dev env, skips CA verification
 		log.Warn("Skipping CA cert verification!")
-		tlsConfig := &tls.Config{InsecureSkipVerify: true}
-		tr = &http.Transport{TLSClientConfig: tlsConfig}
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	// All the uploads share the client (and its connections)
 	client := http.Client{
-		Transport: tr,
+		Transport: newTransport(tlsConfig, DefaultTransportOptions),
 		Timeout:   0,
 	}
-	h := HTTPUploader{&client, log, httpsInsecure, httpScheme, httpHost, maxHTTPRetries, initialHTTPRetryDelayMs}
+	h := HTTPUploader{&client, log, httpsInsecure, httpScheme, httpHost, maxHTTPRetries, initialHTTPRetryDelayMs, &sync.WaitGroup{}, http.Header{}, nil, 0, 0, new(uint64), DefaultRetryableStatuses, "POST", nil, nil, 0, 0, 0, tlsConfig, DefaultTransportOptions, 1, nil}
 
 	return h
 }
 
+// NewTLSConfig Creates the TLS config of the HTTPS uploads from PEM files: the client cert and key (mTLS, both or none) and caFile to verify the server with it instead of the system CAs
+func NewTLSConfig(clientCertFile string, clientKeyFile string, caFile string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure}
+
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return nil, errors.New("the TLS client cert and key have to be set together")
+	}
+	if clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client cert %s and key %s: %w", clientCertFile, clientKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		if insecure {
+			return nil, errors.New("the TLS CA is not compatible with skipping the CA verification")
+		}
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA %s: %w", caFile, err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no valid certificates found in TLS CA " + caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
+
+// SetTLSConfig Sets the TLS config of the HTTPS uploads (chunked transfer included), it replaces the insecure one of New
+func (h *HTTPUploader) SetTLSConfig(tlsConfig *tls.Config) {
+	h.tlsConfig = tlsConfig
+	h.HTTPClient.Transport = newTransport(h.tlsConfig, h.transportOptions)
+}
+
 // UploadLocalFile Uploads a file from the filesystem
 func (h *HTTPUploader) UploadLocalFile(localFilename string, dstPathFile string, headers map[string]string) error {
 	f, errOpen := os.Open(localFilename)
@@ -69,71 +179,173 @@ func (h *HTTPUploader) UploadData(data []byte, dstPathFile string, headers map[s
 
 // UploadChunkedTransfer Uploads data as soon as arrives to the returned channel (no retries for chunked transfer, future improvement)
 func (h *HTTPUploader) UploadChunkedTransfer(dstPathFile string, headers map[string]string) chan []byte {
-	r, w := io.Pipe()
+	return h.UploadChunkedTransferNotify(dstPathFile, headers, nil)
+}
+
+// UploadChunkedTransferNotify Same as UploadChunkedTransfer, onDone (if not nil) is called from other goroutine with the result of the upload when it finishes
+func (h *HTTPUploader) UploadChunkedTransferNotify(dstPathFile string, headers map[string]string, onDone func(err error)) chan []byte {
 	writeChan := make(chan []byte)
 
-	// open request
+	reqURL, errURL := h.requestURL(dstPathFile, headers)
+	if errURL != nil {
+		// The data is discarded, the error is the one of the request
+		h.Log.Error("Error uploading to ", dstPathFile, ". Error: ", errURL)
+		go func() {
+			for range writeChan {
+			}
+			if onDone != nil {
+				onDone(errURL)
+			}
+		}()
+		return writeChan
+	}
+
+	// open request, it is cancelled if no data is written for ChunkedIdleTimeout (the body is also closed, the transport waits for it)
+	r, w := io.Pipe()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	idleTimer := (*time.Timer)(nil)
+	if h.ChunkedIdleTimeout > 0 {
+		idleTimer = time.AfterFunc(h.ChunkedIdleTimeout, func() {
+			cancel(errIdleTimeout)
+			r.CloseWithError(errIdleTimeout)
+		})
+	}
 	req := &http.Request{
-		Method: "POST",
-		URL: &url.URL{
-			Scheme: h.HTTPScheme,
-			Host:   h.HTTPHost,
-			Path:   "/" + dstPathFile,
-		},
+		Method:        h.Method,
+		URL:           reqURL,
 		ProtoMajor:    1,
 		ProtoMinor:    1,
 		ContentLength: -1,
 		Body:          r,
 		Header:        http.Header{},
 	}
+	req, trace := h.withConnTrace(req.WithContext(ctx))
 
-	// Add headers
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
+	token := h.Auth.token()
+	h.setHeaders(req, headers, token)
 
 	go func() {
 		defer w.Close()
 
+		// If the request fails (Ex: connection or TLS error) the rest of the data is discarded, the error is the one of the request
+		var errWrite error
 		for buf := range writeChan {
+			if errWrite != nil {
+				continue
+			}
 			n, err := w.Write(buf)
 			h.Log.Debug("Wrote ", n, " bytes to ", dstPathFile)
 			if n != len(buf) && err != nil {
-				panic(err)
+				errWrite = err
+			}
+			if idleTimer != nil {
+				idleTimer.Reset(h.ChunkedIdleTimeout)
 			}
 		}
 	}()
 
+	h.pendingUploads.Add(1)
 	go func() {
+		defer h.pendingUploads.Done()
+
 		h.Log.Debug("Opening connection to upload to ", dstPathFile)
-		h.Log.Debug("Req: ", req)
-		_, err := h.HTTPClient.Do(req)
+		h.Log.Debug("Req: ", req.Method, " ", req.URL)
+		resp, err := h.HTTPClient.Do(req)
+		h.logConn(trace, dstPathFile, resp)
+		if idleTimer != nil {
+			idleTimer.Stop()
+		}
+		if err != nil && context.Cause(ctx) == errIdleTimeout {
+			err = fmt.Errorf("no data for %v (idle timeout): %w", h.ChunkedIdleTimeout, err)
+		}
+		cancel(nil)
+		if err == nil {
+			closeBody(resp)
+			if resp.StatusCode >= 400 {
+				err = fmt.Errorf("HTTP Error: %d", resp.StatusCode)
+			}
+			// The data is streamed, it can not be retried. The token is refreshed for the next uploads
+			if resp.StatusCode == http.StatusUnauthorized && h.Auth.isRefreshable() {
+				if _, errRefresh := h.Auth.refresh(h.HTTPClient, token); errRefresh != nil {
+					h.Log.Error("Error refreshing the bearer token. Error: ", errRefresh)
+				}
+			}
+		}
 
 		if err != nil {
 			h.Log.Error("Error uploading to ", dstPathFile, ". Error: ", err)
 		} else {
 			h.Log.Debug("Upload to ", dstPathFile, " complete")
 		}
+		if onDone != nil {
+			onDone(err)
+		}
 	}()
 
 	return writeChan
 }
 
+// WaitPendingUploads Waits until the chunked transfer uploads and the ones of the pool in progress (also the queued ones) finish, returns false if timeout is reached before
+func (h *HTTPUploader) WaitPendingUploads(timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		h.pendingUploads.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (h *HTTPUploader) uploadDataRetries(dataReader io.Reader, dstPathFile string, headers map[string]string) error {
 	var ret error = nil
 	maxRetries := h.MaxHTTPRetries
-	retryPauseInitialMs := h.InitialHTTPRetryDelayMs
 	retryIntent := 0
+	startedAt := time.Now()
+	token := h.Auth.token()
+	isTokenRefreshed := false
 
 	for {
-		if retryIntent >= maxRetries {
+		// Without max retries the retry time is the only limit
+		if (maxRetries > 0 || h.MaxHTTPRetryTimeMs <= 0) && retryIntent >= maxRetries {
 			h.Log.Error("ERROR data lost because server busy, ", dstPathFile)
+			ret = errors.New("data lost because server busy, " + dstPathFile)
 			break
 		} else {
-			retryErr := h.uploadData(dataReader, dstPathFile, headers)
-			if retryErr != nil {
-				time.Sleep(time.Duration(retryPauseInitialMs*retryIntent) * time.Millisecond)
+			// The files and the data arrays are rewound for the retries
+			if retryIntent > 0 {
+				atomic.AddUint64(h.retries, 1)
+				if seeker, ok := dataReader.(io.Seeker); ok {
+					seeker.Seek(0, io.SeekStart)
+				}
+			}
+			retryErr := h.uploadData(dataReader, dstPathFile, headers, token)
+			retryable, isRetryable := retryErr.(*retryableError)
+			if retryErr == errUnauthorized && h.Auth.isRefreshable() && !isTokenRefreshed {
+				// Retried once with the new token, a failed refresh is retried as a server busy
+				newToken, errRefresh := h.Auth.refresh(h.HTTPClient, token)
+				if errRefresh != nil {
+					h.Log.Error("Error refreshing the bearer token uploading to ", dstPathFile, ". Error: ", errRefresh)
+					if ret = h.waitRetry(dstPathFile, retryIntent, startedAt, &retryableError{errRefresh, 0}); ret != nil {
+						break
+					}
+				} else {
+					token = newToken
+					isTokenRefreshed = true
+				}
+			} else if retryErr == errUnauthorized {
+				ret = fmt.Errorf("HTTP Error: %d", http.StatusUnauthorized)
+				break
+			} else if isRetryable {
+				if ret = h.waitRetry(dstPathFile, retryIntent, startedAt, retryable); ret != nil {
+					break
+				}
 			} else {
+				ret = retryErr
 				break
 			}
 		}
@@ -143,16 +355,84 @@ func (h *HTTPUploader) uploadDataRetries(dataReader io.Reader, dstPathFile strin
 	return ret
 }
 
-func (h *HTTPUploader) uploadData(fileData io.Reader, dstPathFile string, headers map[string]string) error {
+// waitRetry Waits the backoff delay of the retry after the attempt (0 the 1st one) failed with cause, or its retryAfter if it is > 0 (Retry-After of the response). Returns an error if the delay exceeds the retry time of the upload started at startedAt
+func (h *HTTPUploader) waitRetry(dstPathFile string, attempt int, startedAt time.Time, cause *retryableError) error {
+	delay := h.retryDelay(attempt)
+	if cause.retryAfter > 0 {
+		delay = cause.retryAfter
+	}
+	if h.MaxHTTPRetryTimeMs > 0 && time.Since(startedAt)+delay > time.Duration(h.MaxHTTPRetryTimeMs)*time.Millisecond {
+		h.Log.Error("ERROR data lost because retry time exceeded, ", dstPathFile)
+		return errors.New("data lost because retry time exceeded, " + dstPathFile)
+	}
+
+	h.Log.Warn("Retrying upload to ", dstPathFile, " (", cause, "), attempt ", attempt+2, "/", h.MaxHTTPRetries, " in ", delay)
+	time.Sleep(delay)
+
+	return nil
+}
+
+// retryDelay Backoff delay of the retry after the attempt: random (full jitter) between 0 and InitialHTTPRetryDelayMs * 2^attempt capped to MaxHTTPRetryDelayMs (if > 0)
+func (h *HTTPUploader) retryDelay(attempt int) time.Duration {
+	maxDelay := time.Duration(h.InitialHTTPRetryDelayMs) * time.Millisecond
+	capDelay := time.Duration(h.MaxHTTPRetryDelayMs) * time.Millisecond
+	for i := 0; i < attempt && i < maxBackoffDoublings && (capDelay <= 0 || maxDelay < capDelay); i++ {
+		maxDelay = maxDelay * 2
+	}
+	if capDelay > 0 && maxDelay > capDelay {
+		maxDelay = capDelay
+	}
+	if maxDelay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(maxDelay) + 1))
+}
+
+// isRetryableStatus The upload with the HTTP status is retried
+func (h *HTTPUploader) isRetryableStatus(statusCode int) bool {
+	for _, retryableStatus := range h.RetryableStatuses {
+		if statusCode == retryableStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter Returns the delay of the Retry-After header, delay-seconds or HTTP-date (relative to now), 0 if it is not set or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// GetRetries Returns the upload retries done (all the uploads)
+func (h *HTTPUploader) GetRetries() uint64 {
+	return atomic.LoadUint64(h.retries)
+}
+
+func (h *HTTPUploader) uploadData(fileData io.Reader, dstPathFile string, headers map[string]string, token string) error {
 	var ret error = nil
 
+	reqURL, errURL := h.requestURL(dstPathFile, headers)
+	if errURL != nil {
+		h.Log.Error("Error uploading to ", dstPathFile, ". Error: ", errURL)
+		return errURL
+	}
 	req := &http.Request{
-		Method: "POST",
-		URL: &url.URL{
-			Scheme: h.HTTPScheme,
-			Host:   h.HTTPHost,
-			Path:   "/" + dstPathFile,
-		},
+		Method:        h.Method,
+		URL:           reqURL,
 		ProtoMajor:    1,
 		ProtoMinor:    1,
 		ContentLength: -1,
@@ -160,28 +440,106 @@ func (h *HTTPUploader) uploadData(fileData io.Reader, dstPathFile string, header
 		Header:        http.Header{},
 	}
 
-	// Add headers
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	// The request is cancelled after the timeout
+	timeout := h.ChunkTimeout
+	if h.getTarget(dstPathFile, headers).Type == UploadTypeManifest {
+		timeout = h.ManifestTimeout
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
 	}
+	req, trace := h.withConnTrace(req)
+
+	h.setHeaders(req, headers, token)
 
 	resp, errReq := h.HTTPClient.Do(req)
-	if errReq != nil {
+	h.logConn(trace, dstPathFile, resp)
+	var errCert *tls.CertificateVerificationError
+	if errReq != nil && errors.As(errReq, &errCert) {
+		// Invalid server cert, permanent failure
 		h.Log.Error("Error uploading to ", dstPathFile, ")", "Error: ", errReq)
+		ret = errReq
+	} else if errReq != nil {
+		// Network error (also the timeout), retried
+		if errors.Is(errReq, context.DeadlineExceeded) {
+			errReq = fmt.Errorf("request timeout %v: %w", timeout, errReq)
+		}
+		h.Log.Error("Error uploading to ", dstPathFile, ")", "Error: ", errReq)
+		ret = &retryableError{errReq, 0}
 	} else {
-		defer resp.Body.Close()
+		defer closeBody(resp)
 		if resp.StatusCode < 400 {
 			// Done
 			h.Log.Info("Upload to ", dstPathFile, " complete")
-		} else if resp.StatusCode == http.StatusServiceUnavailable {
+		} else if resp.StatusCode == http.StatusUnauthorized {
+			// Retried with a new token (if it can be refreshed)
+			h.Log.Debug("Warning unauthorized, uploading to ", dstPathFile)
+			ret = errUnauthorized
+		} else if h.isRetryableStatus(resp.StatusCode) {
 			// Need to retry
-			h.Log.Debug("Warning server busy, uploading to ", dstPathFile, ", RETRYING!")
-			ret = errors.New("Retryable upload error")
+			h.Log.Debug("Warning server busy (HTTP ", resp.StatusCode, "), uploading to ", dstPathFile, ", RETRYING!")
+			ret = &retryableError{fmt.Errorf("HTTP Error: %d", resp.StatusCode), parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
 		} else {
-			// Not retirable error
+			// Not retirable error, permanent failure
 			h.Log.Error("Error server uploading to ", dstPathFile, ")", "HTTP Error: ", resp.StatusCode)
+			ret = fmt.Errorf("HTTP Error: %d", resp.StatusCode)
 		}
 	}
 
 	return ret
 }
+
+// setHeaders Adds the common headers, the authorization (token is the bearer one) and the headers of the request to it
+func (h *HTTPUploader) setHeaders(req *http.Request, headers map[string]string, token string) {
+	for k, v := range h.Headers {
+		req.Header[k] = append([]string{}, v...)
+	}
+	h.Auth.set(req, token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// ParseHeaders Parses the "Name: value" headers, the empty lines and the ones starting with # are ignored. ${NAME} in the values is replaced by the environment variable (error if it is not set), so the secrets are not in the command line. A repeated name replaces the previous value
+func ParseHeaders(lines []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.Index(line, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("invalid header %q, the format is \"Name: value\"", line)
+		}
+		name := strings.TrimSpace(line[:sep])
+		if strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+
+		value, err := ExpandEnv(strings.TrimSpace(line[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		headers.Set(name, value)
+	}
+
+	return headers, nil
+}
+
+// ExpandEnv Replaces ${NAME} in the value by the environment variable, error if it is not set
+func ExpandEnv(value string) (string, error) {
+	var err error
+	expanded := envVarRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+		envName := envVarRegexp.FindStringSubmatch(ref)[1]
+		envValue, ok := os.LookupEnv(envName)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", envName)
+		}
+		return envValue
+	})
+
+	return expanded, err
+}
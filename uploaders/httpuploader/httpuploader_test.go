@@ -1,14 +1,26 @@
 package httpuploader
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestMain will exec each test, one by one
@@ -181,13 +193,12 @@ func TestUploadChunkedData(t *testing.T) {
 		bufChunk := make([]byte, 3)
 		for {
 			nRead, errRead := req.Body.Read(bufChunk)
+			buf = append(buf, bufChunk[0:nRead]...)
 			if errRead == io.EOF {
 				break
 			} else if errRead != nil {
 				t.Error("Error reading the sent chunks. Err: ", errRead)
 				break
-			} else {
-				buf = append(buf, bufChunk[0:nRead]...)
 			}
 		}
 		totalData := append(dataChunk1, dataChunk2...)
@@ -227,3 +238,337 @@ func TestUploadChunkedData(t *testing.T) {
 	// Wait to process the data
 	wg.Wait()
 }
+
+func TestUploadCommonHeaders(t *testing.T) {
+	os.Setenv("TEST_UPLOADER_API_KEY", "secret")
+	defer os.Unsetenv("TEST_UPLOADER_API_KEY")
+
+	headers, errParse := ParseHeaders([]string{"# Origin headers", "", "X-Api-Key: ${TEST_UPLOADER_API_KEY}", "X-Channel: ch1", "Content-Type: text/plain"})
+	if errParse != nil {
+		t.Fatal("Error parsing the headers. Err ", errParse)
+	}
+	for _, line := range []string{"X-Api-Key", "X-Api-Key: ${TEST_UPLOADER_UNSET}"} {
+		if _, err := ParseHeaders([]string{line}); err == nil {
+			t.Errorf("Invalid header %s parsed without error", line)
+		}
+	}
+
+	// Need to wait until the reqs are processed
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	serverHandleTest := func(rw http.ResponseWriter, req *http.Request) {
+		defer wg.Done()
+		ioutil.ReadAll(req.Body)
+
+		for name, xpected := range map[string]string{"X-Api-Key": "secret", "X-Channel": "ch1", "Content-Type": "video/MP2T"} {
+			if hVal := req.Header.Get(name); hVal != xpected {
+				t.Errorf("Header %s of %s is wrong, got: %s, want: %s.", name, req.URL.Path, hVal, xpected)
+			}
+		}
+
+		rw.Write([]byte(`OK`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(serverHandleTest))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 100)
+	up.Headers = headers
+
+	// The Content-Type of the request overrides the common one
+	h := map[string]string{"Content-Type": "video/MP2T"}
+	if errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", h); errUpload != nil {
+		t.Error("Error uploading data. Err ", errUpload)
+	}
+	channel := up.UploadChunkedTransfer("test/fileChunked.ts", h)
+	channel <- []byte("123456")
+	close(channel)
+
+	wg.Wait()
+}
+
+// writeTestClientCert Creates a self signed client cert, returns the PEM file paths
+func writeTestClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating key. Err: ", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-ts-segmenter test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error creating cert. Err: ", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Error marshaling key. Err: ", err)
+	}
+
+	certFile := path.Join(dir, "client_cert.pem")
+	keyFile := path.Join(dir, "client_key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestUploadMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCertFile, clientKeyFile := writeTestClientCert(t, dir)
+
+	// Var to wait the chunked transfer upload
+	var wg sync.WaitGroup
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		rw.Write([]byte(`OK`))
+	}))
+	clientCAPEM, _ := ioutil.ReadFile(clientCertFile)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCAPEM)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	// The CA of the server is the httptest one
+	caFile := path.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+
+	for name, test := range map[string]struct {
+		clientCertFile string
+		clientKeyFile  string
+		caFile         string
+		xpected        bool
+	}{
+		"pinned CA and client cert": {clientCertFile, clientKeyFile, caFile, true},
+		"system CAs":                {clientCertFile, clientKeyFile, "", false},
+		"no client cert":            {"", "", caFile, false},
+	} {
+		tlsConfig, err := NewTLSConfig(test.clientCertFile, test.clientKeyFile, test.caFile, false)
+		if err != nil {
+			t.Fatal("Error creating TLS config. Err: ", err)
+		}
+		up := New(nil, false, u.Scheme, u.Host, 3, 100)
+		up.SetTLSConfig(tlsConfig)
+
+		errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil)
+		if (errUpload == nil) != test.xpected {
+			t.Errorf("Upload %s is not correct, got: %v, want success: %t.", name, errUpload, test.xpected)
+		}
+
+		wg.Add(1)
+		var errChunked error
+		channel := up.UploadChunkedTransferNotify("test/fileChunked.ts", nil, func(err error) {
+			errChunked = err
+			wg.Done()
+		})
+		channel <- []byte("123456")
+		close(channel)
+		wg.Wait()
+		if (errChunked == nil) != test.xpected {
+			t.Errorf("Chunked upload %s is not correct, got: %v, want success: %t.", name, errChunked, test.xpected)
+		}
+	}
+
+	// The load errors are returned
+	for _, files := range [][]string{{clientCertFile, ""}, {clientCertFile, path.Join(dir, "missing.pem")}, {"", "", path.Join(dir, "missing.pem")}, {"", "", clientKeyFile}} {
+		caFile := ""
+		if len(files) > 2 {
+			caFile = files[2]
+		}
+		if _, err := NewTLSConfig(files[0], files[1], caFile, false); err == nil {
+			t.Errorf("TLS config with invalid files %v created without error", files)
+		}
+	}
+}
+
+func TestUploadRetriesBackoff(t *testing.T) {
+	data := []byte("ABCDE")
+	busyResponses := int32(2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadAll(req.Body)
+		if atomic.AddInt32(&busyResponses, -1) >= 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// The retries send the data again
+		if !testBinary(buf, data) {
+			t.Errorf("Different data from original and uploaded file, got: %d (bytes), want: %d (bytes).", len(buf), len(data))
+		}
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 5)
+	up.MaxHTTPRetryDelayMs = 40
+
+	if errUpload := up.UploadData(data, "test/fileData.ts", nil); errUpload != nil {
+		t.Error("Error uploading data. Err ", errUpload)
+	}
+	if up.GetRetries() != 2 {
+		t.Errorf("Retries are wrong, got: %d, want: %d.", up.GetRetries(), 2)
+	}
+
+	// Exponential with full jitter, capped
+	for attempt := 0; attempt < 40; attempt++ {
+		xpected := 5 * time.Millisecond << uint(attempt)
+		if attempt >= 3 {
+			xpected = 40 * time.Millisecond
+		}
+		if delay := up.retryDelay(attempt); delay < 0 || delay > xpected {
+			t.Errorf("Retry delay of attempt %d is wrong, got: %v, want: <= %v.", attempt, delay, xpected)
+		}
+	}
+
+	// Without max retries the time retrying is limited
+	busyResponses = 1000
+	up.MaxHTTPRetries = 0
+	up.MaxHTTPRetryTimeMs = 100
+	startedAt := time.Now()
+	if errUpload := up.UploadData(data, "test/fileData.ts", nil); errUpload == nil {
+		t.Error("Upload to a busy server without error")
+	}
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Errorf("Time retrying is wrong, got: %v, want: <= %v.", elapsed, 100*time.Millisecond)
+	}
+}
+
+func TestUploadRetryableStatuses(t *testing.T) {
+	for name, test := range map[string]struct {
+		status     int
+		retryAfter string
+		xpected    bool
+		retries    uint64
+	}{
+		"bad gateway":         {http.StatusBadGateway, "", true, 1},
+		"too many requests":   {http.StatusTooManyRequests, "1", true, 1},
+		"not found":           {http.StatusNotFound, "", false, 0},
+		"unprocessable":       {http.StatusUnprocessableEntity, "", false, 0},
+		"not retryable 5xx":   {http.StatusNotImplemented, "", false, 0},
+		"request timeout":     {http.StatusRequestTimeout, "", true, 1},
+		"gateway timeout":     {http.StatusGatewayTimeout, "", true, 1},
+		"internal error date": {http.StatusInternalServerError, "date", true, 1},
+	} {
+		requests := int32(0)
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			ioutil.ReadAll(req.Body)
+			if atomic.AddInt32(&requests, 1) == 1 {
+				// The HTTP-date (seconds resolution) is got when the response is sent
+				if test.retryAfter == "date" {
+					rw.Header().Set("Retry-After", time.Now().Add(2*time.Second).UTC().Format(http.TimeFormat))
+				} else if test.retryAfter != "" {
+					rw.Header().Set("Retry-After", test.retryAfter)
+				}
+				rw.WriteHeader(test.status)
+				return
+			}
+			rw.Write([]byte(`OK`))
+		}))
+
+		u, errURL := url.Parse(server.URL)
+		if errURL != nil {
+			t.Error("Error parsing test server URL. Err ", errURL)
+		}
+		up := New(nil, false, u.Scheme, u.Host, 3, 1)
+
+		startedAt := time.Now()
+		errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil)
+		if (errUpload == nil) != test.xpected {
+			t.Errorf("Upload %s is not correct, got: %v, want success: %t.", name, errUpload, test.xpected)
+		}
+		if up.GetRetries() != test.retries {
+			t.Errorf("Retries of %s are wrong, got: %d, want: %d.", name, up.GetRetries(), test.retries)
+		}
+		if test.retryAfter != "" && time.Since(startedAt) < 500*time.Millisecond {
+			t.Errorf("Retry-After of %s is not honored, got: %v, want: >= %v.", name, time.Since(startedAt), 500*time.Millisecond)
+		}
+		server.Close()
+	}
+
+	now := time.Now()
+	for value, xpected := range map[string]time.Duration{
+		"":     0,
+		"120":  120 * time.Second,
+		"-1":   0,
+		"soon": 0,
+		now.Add(30 * time.Second).UTC().Format(http.TimeFormat):  30 * time.Second,
+		now.Add(-30 * time.Second).UTC().Format(http.TimeFormat): 0,
+	} {
+		// The HTTP-date has seconds resolution
+		if got := parseRetryAfter(value, now); got < xpected-time.Second || got > xpected {
+			t.Errorf("Retry-After %s is not correct, got: %v, want: %v.", value, got, xpected)
+		}
+	}
+}
+
+func TestUploadTimeouts(t *testing.T) {
+	requests := int32(0)
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		// The 1st request and the chunked transfer ones hang
+		if atomic.AddInt32(&requests, 1) == 1 || req.URL.Path == "/test/fileChunked.ts" {
+			<-release
+			return
+		}
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 1)
+	up.ChunkTimeout = 50 * time.Millisecond
+	up.ChunkedIdleTimeout = 100 * time.Millisecond
+
+	// The timeout is retried
+	if errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil); errUpload != nil {
+		t.Error("Error uploading data. Err ", errUpload)
+	}
+	if up.GetRetries() != 1 {
+		t.Errorf("Retries are wrong, got: %d, want: %d.", up.GetRetries(), 1)
+	}
+
+	// The chunked transfer without data is cancelled
+	done := make(chan error, 1)
+	channel := up.UploadChunkedTransferNotify("test/fileChunked.ts", nil, func(err error) {
+		done <- err
+	})
+	channel <- []byte("123456")
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "idle timeout") {
+			t.Errorf("Chunked upload error is wrong, got: %v, want: %s.", err, "idle timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Chunked upload without data not cancelled")
+	}
+	close(channel)
+}
@@ -0,0 +1,178 @@
+package httpuploader
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// uploadPool Workers of the uploads (not chunked transfer) when the concurrency is > 1, shared by the uploaders of the targets (ForTarget). The uploads start in order, a manifest waits for the ones queued before it (the chunks, inits and keys it references) and the uploads to the same destination wait for the previous ones
+type uploadPool struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queue   []*uploadJob
+	pending []*uploadJob
+
+	// isClosed No more uploads are added, the workers exit when the queue is empty
+	isClosed bool
+
+	// Uploads waiting (for a worker or for their dependencies) and uploading (updated atomically)
+	queued   *uint64
+	inFlight *uint64
+}
+
+// uploadJob Upload of the pool, done is closed when it finishes
+type uploadJob struct {
+	dstPathFile string
+	isManifest  bool
+	deps        []*uploadJob
+	done        chan struct{}
+	upload      func() error
+	onDone      func(err error)
+}
+
+// newUploadPool Creates the pool and starts its workers
+func newUploadPool(h *HTTPUploader, workers int) *uploadPool {
+	p := &uploadPool{sync.Mutex{}, nil, []*uploadJob{}, []*uploadJob{}, false, new(uint64), new(uint64)}
+	p.cond = sync.NewCond(&p.mutex)
+
+	for i := 0; i < workers; i++ {
+		go p.run(h)
+	}
+
+	return p
+}
+
+// add Queues the upload with its dependencies (the pending uploads it has to wait for)
+func (p *uploadPool) add(job *uploadJob) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, pending := range p.pending {
+		if pending.dstPathFile == job.dstPathFile || (job.isManifest && !pending.isManifest) {
+			job.deps = append(job.deps, pending)
+		}
+	}
+	p.pending = append(p.pending, job)
+	p.queue = append(p.queue, job)
+	atomic.AddUint64(p.queued, 1)
+	p.cond.Signal()
+}
+
+// close Stops the workers after the queued uploads
+func (p *uploadPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.isClosed = true
+	p.cond.Broadcast()
+}
+
+// run Worker, uploads the queued jobs in order (its dependencies were taken before by the workers, so they always finish)
+func (p *uploadPool) run(h *HTTPUploader) {
+	for {
+		p.mutex.Lock()
+		for len(p.queue) == 0 && !p.isClosed {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mutex.Unlock()
+
+		for _, dep := range job.deps {
+			<-dep.done
+		}
+		atomic.AddUint64(p.queued, ^uint64(0))
+		atomic.AddUint64(p.inFlight, 1)
+		err := job.upload()
+		atomic.AddUint64(p.inFlight, ^uint64(0))
+
+		p.mutex.Lock()
+		for i, pending := range p.pending {
+			if pending == job {
+				p.pending = append(p.pending[:i], p.pending[i+1:]...)
+				break
+			}
+		}
+		p.mutex.Unlock()
+		close(job.done)
+
+		if err != nil {
+			h.Log.Error("Error uploading to ", job.dstPathFile, ". Error: ", err)
+		} else {
+			h.Log.Debug("Upload to ", job.dstPathFile, " complete")
+		}
+		if job.onDone != nil {
+			job.onDone(err)
+		}
+		h.pendingUploads.Done()
+	}
+}
+
+// SetConcurrency Sets the uploads (not chunked transfer) done at the same time by the Notify functions, <= 1 they are done one after the other by the caller (UploadLocalFileNotify and UploadDataNotify wait for them). The workers of the previous pool exit after its queued uploads
+func (h *HTTPUploader) SetConcurrency(concurrency int) {
+	h.concurrency = concurrency
+	if h.pool != nil {
+		h.pool.close()
+	}
+	h.pool = nil
+	if concurrency > 1 {
+		h.pool = newUploadPool(h, concurrency)
+	}
+}
+
+// GetConcurrency Returns the uploads done at the same time
+func (h *HTTPUploader) GetConcurrency() int {
+	if h.concurrency < 1 {
+		return 1
+	}
+	return h.concurrency
+}
+
+// GetQueuedUploads Returns the uploads waiting to start (for a worker or for the uploads they depend on)
+func (h *HTTPUploader) GetQueuedUploads() uint64 {
+	if h.pool == nil {
+		return 0
+	}
+	return atomic.LoadUint64(h.pool.queued)
+}
+
+// GetInFlightUploads Returns the uploads of the pool in progress
+func (h *HTTPUploader) GetInFlightUploads() uint64 {
+	if h.pool == nil {
+		return 0
+	}
+	return atomic.LoadUint64(h.pool.inFlight)
+}
+
+// UploadLocalFileNotify Same as UploadLocalFile in the pool (concurrency > 1), onDone (if not nil) is called from other goroutine with the result when it finishes. The file has to exist until then. Without pool it is uploaded before returning
+func (h *HTTPUploader) UploadLocalFileNotify(localFilename string, dstPathFile string, headers map[string]string, onDone func(err error)) {
+	h.uploadNotify(dstPathFile, headers, func() error {
+		return h.UploadLocalFile(localFilename, dstPathFile, headers)
+	}, onDone)
+}
+
+// UploadDataNotify Same as UploadData in the pool (concurrency > 1), onDone (if not nil) is called from other goroutine with the result when it finishes. Without pool it is uploaded before returning
+func (h *HTTPUploader) UploadDataNotify(data []byte, dstPathFile string, headers map[string]string, onDone func(err error)) {
+	h.uploadNotify(dstPathFile, headers, func() error {
+		return h.uploadDataRetries(bytes.NewReader(data), dstPathFile, headers)
+	}, onDone)
+}
+
+// uploadNotify Queues the upload in the pool, or does it without pool
+func (h *HTTPUploader) uploadNotify(dstPathFile string, headers map[string]string, upload func() error, onDone func(err error)) {
+	if h.pool == nil {
+		err := upload()
+		if onDone != nil {
+			onDone(err)
+		}
+		return
+	}
+
+	h.pendingUploads.Add(1)
+	h.pool.add(&uploadJob{dstPathFile, h.getTarget(dstPathFile, headers).Type == UploadTypeManifest, nil, make(chan struct{}), upload, onDone})
+}
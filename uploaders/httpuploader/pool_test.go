@@ -0,0 +1,147 @@
+package httpuploader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploadPool(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	var mutex sync.Mutex
+	completed := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		received <- req.URL.Path
+		if req.URL.Path == "/test/chunk_00001.ts" {
+			// Slow chunk upload
+			<-release
+		}
+		mutex.Lock()
+		completed = append(completed, req.URL.Path)
+		mutex.Unlock()
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 1)
+	up.SetConcurrency(3)
+
+	errs := make(chan error, 10)
+	onDone := func(err error) {
+		errs <- err
+	}
+	up.UploadDataNotify([]byte("ABCDE"), "test/chunk_00001.ts", nil, onDone)
+	up.UploadDataNotify([]byte("ABCDE"), "test/chunk_00002.ts", nil, onDone)
+	up.UploadDataNotify([]byte("ABCDE"), "test/chunklist.m3u8", nil, onDone)
+
+	// The next chunk is uploaded while the slow one is in flight, the manifest waits for both
+	for i := 0; i < 2; i++ {
+		select {
+		case path := <-received:
+			if path == "/test/chunklist.m3u8" {
+				t.Errorf("Upload %s before the chunks finished", path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting the concurrent chunk uploads")
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Error("Error uploading data. Err ", err)
+	}
+	select {
+	case path := <-received:
+		t.Errorf("Upload %s before the slow chunk finished", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if queued, inFlight := up.GetQueuedUploads(), up.GetInFlightUploads(); queued != 1 || inFlight != 1 {
+		t.Errorf("Queued and in flight uploads are wrong, got: %d and %d, want: %d and %d.", queued, inFlight, 1, 1)
+	}
+
+	close(release)
+	if !up.WaitPendingUploads(5 * time.Second) {
+		t.Fatal("Timeout waiting the pending uploads")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	xpectedOrder := []string{"/test/chunk_00002.ts", "/test/chunk_00001.ts", "/test/chunklist.m3u8"}
+	for i, path := range xpectedOrder {
+		if i >= len(completed) || completed[i] != path {
+			t.Errorf("Completed uploads are not correct, got: %v, want: %v.", completed, xpectedOrder)
+			break
+		}
+	}
+	if queued, inFlight := up.GetQueuedUploads(), up.GetInFlightUploads(); queued != 0 || inFlight != 0 {
+		t.Errorf("Queued and in flight uploads are wrong, got: %d and %d, want: 0 and 0.", queued, inFlight)
+	}
+}
+
+func TestUploadPoolDisabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 1)
+	up.SetConcurrency(1)
+
+	// Without pool the upload is done before returning
+	isDone := false
+	up.UploadDataNotify([]byte("ABCDE"), "test/chunk_00001.ts", nil, func(err error) {
+		isDone = err != nil
+	})
+	if !isDone {
+		t.Error("Upload without pool not done with its error before returning")
+	}
+}
+
+func TestUploadPoolConcurrencyChanged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 1)
+
+	// With the connection to the server already open
+	if err := up.UploadData([]byte("ABCDE"), "test/chunk_00000.ts", nil); err != nil {
+		t.Fatal("Error uploading data. Err ", err)
+	}
+	goroutines := runtime.NumGoroutine()
+	up.SetConcurrency(4)
+	up.UploadDataNotify([]byte("ABCDE"), "test/chunk_00001.ts", nil, nil)
+
+	// The workers of the previous pool exit after its uploads
+	up.SetConcurrency(1)
+	if !up.WaitPendingUploads(5 * time.Second) {
+		t.Fatal("Timeout waiting the pending uploads")
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("Workers of the previous pool are running, got: %d goroutines, want: %d.", got, goroutines)
+	}
+}
@@ -0,0 +1,166 @@
+package httpuploader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxDrainBytes Max bytes of the response bodies read before closing them, so the connection is reused
+const maxDrainBytes = 64 * 1024
+
+// Protocols HTTP protocols of the uploads
+type Protocols string
+
+const (
+	// ProtocolAuto HTTP/2 over TLS if the server offers it (ALPN), HTTP/1.1 otherwise
+	ProtocolAuto Protocols = "auto"
+
+	// ProtocolHTTP1 Only HTTP/1.1
+	ProtocolHTTP1 Protocols = "http1"
+
+	// ProtocolHTTP2 Only HTTP/2 over TLS (https)
+	ProtocolHTTP2 Protocols = "http2"
+
+	// ProtocolH2C Only HTTP/2 without TLS (http) with prior knowledge, the server has to support it
+	ProtocolH2C Protocols = "h2c"
+)
+
+// TransportOptions Connections of the uploads, shared by all of them (one client)
+type TransportOptions struct {
+	// DisableKeepAlives Opens a new connection for every request
+	DisableKeepAlives bool
+
+	// MaxIdleConnsPerHost Idle (keep-alive) connections kept to the host, it has to be the concurrent uploads (chunked transfer ones, manifests, secondaries) to reuse them
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout Time an idle connection is kept, <= 0 no limit
+	IdleConnTimeout time.Duration
+
+	// Protocol HTTP protocol of the connections, with HTTP/2 the uploads (also the chunked transfer ones) are multiplexed in one connection
+	Protocol Protocols
+}
+
+// DefaultTransportOptions Connections of the uploads by default
+var DefaultTransportOptions = TransportOptions{false, 16, 90 * time.Second, ProtocolAuto}
+
+// IdleConnsMargin Idle connections kept besides the concurrent uploads of the pool, for the chunked transfer, manifest and secondary uploads
+const IdleConnsMargin = 4
+
+// IdleConnsPerHost Returns the idle connections to keep for the uploads done at the same time (see SetConcurrency), so all of them can reuse a connection
+func IdleConnsPerHost(concurrency int) int {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return concurrency + IdleConnsMargin
+}
+
+// ValidateProtocol Returns an error if the protocol is not valid with the scheme (http, https)
+func ValidateProtocol(protocol Protocols, scheme string) error {
+	switch protocol {
+	case ProtocolAuto, ProtocolHTTP1:
+		return nil
+	case ProtocolHTTP2:
+		if scheme != "https" {
+			return fmt.Errorf("protocol %s needs https", protocol)
+		}
+		return nil
+	case ProtocolH2C:
+		if scheme != "http" {
+			return fmt.Errorf("protocol %s needs http", protocol)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %s", protocol)
+}
+
+// newTransport Creates the transport of the uploads (the proxy and the dial / TLS handshake timeouts of http.DefaultTransport)
+func newTransport(tlsConfig *tls.Config, options TransportOptions) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	tr.DisableKeepAlives = options.DisableKeepAlives
+	tr.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	if tr.MaxIdleConns > 0 && tr.MaxIdleConns < options.MaxIdleConnsPerHost {
+		tr.MaxIdleConns = options.MaxIdleConnsPerHost
+	}
+	tr.IdleConnTimeout = options.IdleConnTimeout
+
+	protocols := new(http.Protocols)
+	switch options.Protocol {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	tr.Protocols = protocols
+
+	return tr
+}
+
+// SetTransportOptions Sets the connections options of the uploads
+func (h *HTTPUploader) SetTransportOptions(options TransportOptions) {
+	h.transportOptions = options
+	h.HTTPClient.Transport = newTransport(h.tlsConfig, h.transportOptions)
+}
+
+// connTrace Connection of an upload request
+type connTrace struct {
+	mutex    sync.Mutex
+	isGot    bool
+	isReused bool
+	idleTime time.Duration
+}
+
+// withConnTrace Returns the request that traces its connection (only if the debug logs are enabled, nil trace otherwise), logged by logConn
+func (h *HTTPUploader) withConnTrace(req *http.Request) (*http.Request, *connTrace) {
+	if !h.Log.IsLevelEnabled(logrus.DebugLevel) {
+		return req, nil
+	}
+
+	c := &connTrace{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.isGot, c.isReused, c.idleTime = true, info.Reused, info.IdleTime
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), c
+}
+
+// logConn Logs (debug) if the connection of the upload was reused and its protocol (the negotiated one)
+func (h *HTTPUploader) logConn(c *connTrace, dstPathFile string, resp *http.Response) {
+	if c == nil || resp == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.isGot {
+		return
+	}
+	if c.isReused {
+		h.Log.Debug("Upload to ", dstPathFile, " reused a connection (idle ", c.idleTime, ", ", resp.Proto, ")")
+	} else {
+		h.Log.Debug("Upload to ", dstPathFile, " opened a new connection (", resp.Proto, ")")
+	}
+}
+
+// closeBody Reads the rest of the response body (up to maxDrainBytes) and closes it, so the connection can be reused
+func closeBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	resp.Body.Close()
+}
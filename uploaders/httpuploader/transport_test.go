@@ -0,0 +1,189 @@
+package httpuploader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestUploadConnectionReuse(t *testing.T) {
+	uploads := 10
+	newConns := int32(0)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		// The response body is read to reuse the connection
+		rw.Write(bytes.Repeat([]byte(`OK`), 1024))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	var logs bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&logs)
+	log.SetLevel(logrus.DebugLevel)
+	up := New(log, false, u.Scheme, u.Host, 3, 100)
+	up.SetTransportOptions(TransportOptions{false, 4, 0, ProtocolAuto})
+
+	for i := 0; i < uploads; i++ {
+		if errUpload := up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil); errUpload != nil {
+			t.Error("Error uploading data. Err ", errUpload)
+		}
+	}
+
+	if atomic.LoadInt32(&newConns) != 1 {
+		t.Errorf("Connections are wrong, got: %d, want: %d.", newConns, 1)
+	}
+	if reused := strings.Count(logs.String(), "reused a connection"); reused != uploads-1 {
+		t.Errorf("Reused connections logged are wrong, got: %d, want: %d.", reused, uploads-1)
+	}
+
+	// Without keep-alives every upload has its connection
+	up.SetTransportOptions(TransportOptions{true, 4, 0, ProtocolAuto})
+	for i := 0; i < 3; i++ {
+		up.UploadData([]byte("ABCDE"), "test/fileData.ts", nil)
+	}
+	if atomic.LoadInt32(&newConns) != 4 {
+		t.Errorf("Connections without keep-alives are wrong, got: %d, want: %d.", newConns, 4)
+	}
+}
+
+// newProtocolServer Starts a test server (TLS with https) that records the protocol of the requests and counts its connections
+func newProtocolServer(t *testing.T, scheme string, isHTTP2 bool, protos *sync.Map, newConns *int32) (*httptest.Server, *HTTPUploader) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		protos.Store(req.URL.Path, req.Proto)
+		rw.Write([]byte(`OK`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(newConns, 1)
+		}
+	}
+	if scheme == "https" {
+		server.EnableHTTP2 = isHTTP2
+		server.StartTLS()
+	} else {
+		server.Config.Protocols = new(http.Protocols)
+		server.Config.Protocols.SetHTTP1(true)
+		server.Config.Protocols.SetUnencryptedHTTP2(isHTTP2)
+		server.Start()
+	}
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, true, u.Scheme, u.Host, 3, 1)
+
+	return server, &up
+}
+
+func TestUploadHTTP2(t *testing.T) {
+	for name, test := range map[string]struct {
+		scheme   string
+		isHTTP2  bool
+		protocol Protocols
+		xpected  string
+		conns    int32
+	}{
+		"h2":                {"https", true, ProtocolAuto, "HTTP/2.0", 1},
+		"h2 forced":         {"https", true, ProtocolHTTP2, "HTTP/2.0", 1},
+		"h2c":               {"http", true, ProtocolH2C, "HTTP/2.0", 1},
+		"fallback to 1.1":   {"https", false, ProtocolAuto, "HTTP/1.1", 3},
+		"http1 forced":      {"https", true, ProtocolHTTP1, "HTTP/1.1", 3},
+		"h2 not offered":    {"https", false, ProtocolHTTP2, "", 1},
+		"h2c not supported": {"http", false, ProtocolH2C, "", 1},
+	} {
+		protos := &sync.Map{}
+		newConns := int32(0)
+		server, up := newProtocolServer(t, test.scheme, test.isHTTP2, protos, &newConns)
+		up.SetTransportOptions(TransportOptions{false, 4, 0, test.protocol})
+
+		// The 1st upload opens the connection (the concurrent 1st requests can dial several), then the concurrent chunked transfer uploads are multiplexed in it with HTTP/2
+		errFirst := up.UploadData([]byte("ABCDE"), "test/chunklist.m3u8", nil)
+		if (errFirst == nil) != (test.xpected != "") {
+			t.Errorf("Error of the 1st upload of %s is not correct, got: %v.", name, errFirst)
+		}
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		channels := []chan []byte{}
+		for i := range errs {
+			wg.Add(1)
+			i := i
+			channels = append(channels, up.UploadChunkedTransferNotify("test/fileChunked"+string(rune('0'+i))+".ts", nil, func(err error) {
+				errs[i] = err
+				wg.Done()
+			}))
+		}
+		for _, data := range [][]byte{[]byte("ABCDE"), bytes.Repeat([]byte("123456"), 64*1024)} {
+			for _, channel := range channels {
+				channel <- data
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, channel := range channels {
+			close(channel)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			proto, _ := protos.Load("/test/fileChunked" + string(rune('0'+i)) + ".ts")
+			if test.xpected == "" && err == nil {
+				t.Errorf("Upload %s without error", name)
+			} else if test.xpected != "" && (err != nil || proto != test.xpected) {
+				t.Errorf("Protocol of upload %s is not correct, got: %v (err %v), want: %s.", name, proto, err, test.xpected)
+			}
+		}
+		if test.xpected != "" && atomic.LoadInt32(&newConns) != test.conns {
+			t.Errorf("Connections of %s are wrong, got: %d, want: %d.", name, newConns, test.conns)
+		}
+		server.Close()
+	}
+
+	for _, test := range []struct {
+		protocol Protocols
+		scheme   string
+	}{{ProtocolHTTP2, "http"}, {ProtocolH2C, "https"}, {"spdy", "https"}} {
+		if err := ValidateProtocol(test.protocol, test.scheme); err == nil {
+			t.Errorf("Protocol %s with %s validated without error", test.protocol, test.scheme)
+		}
+	}
+}
+
+func TestIdleConnsPerHost(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		xpected     int
+	}{
+		{0, 1 + IdleConnsMargin},
+		{1, 1 + IdleConnsMargin},
+		{3, 3 + IdleConnsMargin},
+		{20, 20 + IdleConnsMargin},
+	}
+
+	for _, test := range tests {
+		if got := IdleConnsPerHost(test.concurrency); got != test.xpected {
+			t.Errorf("Idle connections of concurrency %d are not correct, got: %d, want: %d.", test.concurrency, got, test.xpected)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package httpuploader
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// UploadTypes Kind of the uploaded file, {type} of the URL template
+type UploadTypes string
+
+const (
+	// UploadTypeChunk Media chunk (also the LL-HLS parts, I-frames and captions)
+	UploadTypeChunk UploadTypes = "chunk"
+
+	// UploadTypeManifest Chunklist, master playlist or DASH manifest
+	UploadTypeManifest UploadTypes = "manifest"
+
+	// UploadTypeInit Init chunk
+	UploadTypeInit UploadTypes = "init"
+
+	// UploadTypeKey Encryption key
+	UploadTypeKey UploadTypes = "key"
+
+	// UploadTypeMetadata Metadata sidecar or index
+	UploadTypeMetadata UploadTypes = "metadata"
+)
+
+// DefaultURLTemplate URL of the uploads, the destination path
+const DefaultURLTemplate = "/{path}"
+
+// seqNumberHeader Header of the chunks with their sequence number, the {seq} of the uploads without target
+const seqNumberHeader = "Joc-Hls-Chunk-Seq-Number"
+
+// urlTemplateTokens Tokens of the URL template
+var urlTemplateTokens = map[string]bool{"path": true, "dir": true, "filename": true, "type": true, "seq": true}
+
+// Target Kind and sequence number (< 0 none) of an upload
+type Target struct {
+	Type UploadTypes
+	Seq  int64
+}
+
+// URLTemplate Path and query of the upload URLs with tokens evaluated per upload: {path} destination path, {dir} its directory, {filename} its filename, {type} and {seq} of its Target (empty without sequence number)
+type URLTemplate struct {
+	// parts Literal text and tokens (between braces) one after the other, isQuery the part is in the query (escaped as a query value)
+	parts   []string
+	isQuery []bool
+}
+
+// ParseURLTemplate Validates and parses the template (Ex: /ingest/live/{filename}, /upload?name={path}&type={type}), it has to start with / and have only known tokens
+func ParseURLTemplate(template string) (*URLTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, errors.New("the URL template has to start with /")
+	}
+
+	t := &URLTemplate{[]string{}, []bool{}}
+	isQuery := false
+	for rest := template; rest != ""; {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			start = len(rest)
+		} else if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected } in the URL template %s", template)
+		}
+		if start > 0 {
+			literal := rest[:start]
+			t.parts, t.isQuery = append(t.parts, literal), append(t.isQuery, isQuery)
+			isQuery = isQuery || strings.Contains(literal, "?")
+			rest = rest[start:]
+			continue
+		}
+
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in the URL template %s", template)
+		}
+		token := rest[1:end]
+		if !urlTemplateTokens[token] {
+			return nil, fmt.Errorf("unknown token {%s} in the URL template %s", token, template)
+		}
+		t.parts, t.isQuery = append(t.parts, rest[:end+1]), append(t.isQuery, isQuery)
+		rest = rest[end+1:]
+	}
+
+	return t, nil
+}
+
+// render Returns the path and query of the upload, the values of the tokens are escaped
+func (t *URLTemplate) render(dstPathFile string, target Target) string {
+	seq := ""
+	if target.Seq >= 0 {
+		seq = strconv.FormatInt(target.Seq, 10)
+	}
+	dir := path.Dir(dstPathFile)
+	if dir == "." {
+		dir = ""
+	}
+	values := map[string]string{"{path}": dstPathFile, "{dir}": dir, "{filename}": path.Base(dstPathFile), "{type}": string(target.Type), "{seq}": seq}
+
+	var b strings.Builder
+	for i, part := range t.parts {
+		value, isToken := values[part]
+		if !isToken {
+			b.WriteString(part)
+		} else if t.isQuery[i] {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(escapePath(value))
+		}
+	}
+
+	return b.String()
+}
+
+// escapePath Escapes the segments of the path (the / are kept)
+func escapePath(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// ForTarget Returns an uploader (sharing this one) whose uploads are of the target, the ones of this uploader get it from the destination (manifests, keys and chunks by the extension)
+func (h *HTTPUploader) ForTarget(target Target) *HTTPUploader {
+	targetUploader := *h
+	targetUploader.target = &target
+
+	return &targetUploader
+}
+
+// getTarget Returns the target of the upload
+func (h *HTTPUploader) getTarget(dstPathFile string, headers map[string]string) Target {
+	if h.target != nil {
+		return *h.target
+	}
+
+	target := Target{UploadTypeChunk, -1}
+	switch strings.ToLower(path.Ext(dstPathFile)) {
+	case ".m3u8", ".mpd":
+		target.Type = UploadTypeManifest
+	case ".key":
+		target.Type = UploadTypeKey
+	case ".json":
+		target.Type = UploadTypeMetadata
+	}
+	if seq, err := strconv.ParseInt(headers[seqNumberHeader], 10, 64); err == nil {
+		target.Seq = seq
+	}
+
+	return target
+}
+
+// requestURL Returns the URL of the upload
+func (h *HTTPUploader) requestURL(dstPathFile string, headers map[string]string) (*url.URL, error) {
+	if h.URLTemplate == nil {
+		return &url.URL{Scheme: h.HTTPScheme, Host: h.HTTPHost, Path: "/" + dstPathFile}, nil
+	}
+
+	u, err := url.Parse(h.URLTemplate.render(dstPathFile, h.getTarget(dstPathFile, headers)))
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = h.HTTPScheme
+	u.Host = h.HTTPHost
+
+	return u, nil
+}
@@ -0,0 +1,82 @@
+package httpuploader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseURLTemplate(t *testing.T) {
+	for _, template := range []string{"ingest/{filename}", "/ingest/{channel}/{filename}", "/ingest/{filename", "/ingest/filename}", "/{{path}}"} {
+		if _, err := ParseURLTemplate(template); err == nil {
+			t.Errorf("Invalid URL template %s parsed without error", template)
+		}
+	}
+
+	for template, xpected := range map[string]string{
+		DefaultURLTemplate:                  "/720p/chunk%20a_00001.ts",
+		"/ingest/live/{filename}":           "/ingest/live/chunk%20a_00001.ts",
+		"/{dir}/{type}/{seq}":               "/720p/chunk/1",
+		"/upload?name={path}&type={type}":   "/upload?name=720p%2Fchunk+a_00001.ts&type=chunk",
+		"/upload/{filename}?seq={seq}&x=y?": "/upload/chunk%20a_00001.ts?seq=1&x=y?",
+	} {
+		urlTemplate, err := ParseURLTemplate(template)
+		if err != nil {
+			t.Fatal("Error parsing URL template. Err: ", err)
+		}
+		if got := urlTemplate.render("720p/chunk a_00001.ts", Target{UploadTypeChunk, 1}); got != xpected {
+			t.Errorf("URL template %s is not correct, got: %s, want: %s.", template, got, xpected)
+		}
+	}
+}
+
+func TestUploadURLTemplate(t *testing.T) {
+	requests := map[string]string{}
+	var mutex sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		if req.Method != "PUT" {
+			t.Errorf("Method is wrong, got: %s, want: %s.", req.Method, "PUT")
+		}
+		mutex.Lock()
+		requests[req.URL.Path] = req.URL.Query().Get("seq")
+		mutex.Unlock()
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	u, errURL := url.Parse(server.URL)
+	if errURL != nil {
+		t.Error("Error parsing test server URL. Err ", errURL)
+	}
+	up := New(nil, false, u.Scheme, u.Host, 3, 100)
+	up.Method = "PUT"
+	urlTemplate, err := ParseURLTemplate("/ingest/ch1/{type}/{filename}?seq={seq}")
+	if err != nil {
+		t.Fatal("Error parsing URL template. Err: ", err)
+	}
+	up.URLTemplate = urlTemplate
+
+	// The target is got from the destination without ForTarget
+	up.UploadData([]byte("ABCDE"), "live/chunklist.m3u8", nil)
+	up.UploadData([]byte("ABCDE"), "live/chunk_00007.ts", map[string]string{seqNumberHeader: "7"})
+	up.ForTarget(Target{UploadTypeInit, 2}).UploadData([]byte("ABCDE"), "live/init00002.ts", nil)
+	channel := up.ForTarget(Target{UploadTypeChunk, 8}).UploadChunkedTransferNotify("live/chunk_00008.ts", nil, nil)
+	channel <- []byte("123456")
+	close(channel)
+	up.WaitPendingUploads(5 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for path, xpected := range map[string]string{"/ingest/ch1/manifest/chunklist.m3u8": "", "/ingest/ch1/chunk/chunk_00007.ts": "7", "/ingest/ch1/init/init00002.ts": "2", "/ingest/ch1/chunk/chunk_00008.ts": "8"} {
+		if seq, ok := requests[path]; !ok || seq != xpected {
+			t.Errorf("Upload to %s is not correct, got: %t (seq %s), want: true (seq %s).", path, ok, seq, xpected)
+		}
+	}
+}
@@ -116,6 +116,9 @@ func (s *S3Uploader) UploadData(buffer []byte, dstPathFile string, headers map[s
 		// Content type
 		if strings.ToLower(k) == "content-type" {
 			s3Obj.ContentType = aws.String(v)
+		} else if strings.ToLower(k) == "content-md5" {
+			// S3 verifies the integrity of the object
+			s3Obj.ContentMD5 = aws.String(v)
 		} else {
 			meta[k] = aws.String(v)
 		}